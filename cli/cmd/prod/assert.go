@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/assert"
+	"github.com/QuadTriangle/prod.bd/cli/internal/config"
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+)
+
+// runAssert implements `prod assert [subdomain|url]`: it sends the probes in
+// -config through a running tunnel and exits 1 if any expectation fails.
+func runAssert(args []string) {
+	fs := flag.NewFlagSet("assert", flag.ExitOnError)
+	configPath := fs.String("config", "asserts.yaml", "Assertions file with the tunnel's subdomain or url and the probes to send")
+	timeout := fs.Duration("timeout", 10*time.Second, "How long each probe may take")
+	wait := fs.Duration("wait", 0, "Keep retrying failing probes for up to this long, e.g. while a fresh tunnel comes up (0 to try once)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s assert [flags] [subdomain|url]\n\nFlags:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := hooks.BindEnv(fs); err != nil {
+		log.Fatal(err)
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	file, err := assert.Load(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	target := file.URL
+	if file.Subdomain != "" {
+		target = config.PublicURL(file.Subdomain)
+	}
+	if fs.NArg() == 1 {
+		target = fs.Arg(0)
+		if !strings.Contains(target, "://") {
+			target = config.PublicURL(target)
+		}
+	}
+	if target == "" {
+		log.Fatalf("No tunnel to probe: pass a subdomain or URL, or set subdomain or url in %s", *configPath)
+	}
+
+	deadline := time.Now().Add(*wait)
+	failed := 0
+	for i := range file.Probes {
+		p := &file.Probes[i]
+		var res assert.Result
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+			res = assert.Run(ctx, target, p)
+			cancel()
+			if res.Passed() || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(min(time.Second, time.Until(deadline)))
+		}
+		if res.Passed() {
+			fmt.Printf("PASS  %-30s %d in %s\n", p.Name, res.Status, res.Latency.Round(time.Millisecond))
+			continue
+		}
+		failed++
+		fmt.Printf("FAIL  %s\n", p.Name)
+		for _, f := range res.Failures {
+			fmt.Printf("        %s\n", f)
+		}
+	}
+
+	fmt.Printf("\n%d/%d probes passed against %s\n", len(file.Probes)-failed, len(file.Probes), target)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/audit"
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+)
+
+// runAudit implements `prod audit <subdomain>`.
+func runAudit(args []string) {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	strategy := fs.String("strategy", "mobile", "PageSpeed strategy: mobile or desktop")
+	apiKey := fs.String("api-key", os.Getenv("PAGESPEED_API_KEY"), "PageSpeed Insights API key (optional, raises quota)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s audit [flags] <subdomain>\n\nFlags:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := hooks.BindEnv(fs); err != nil {
+		log.Fatal(err)
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	subdomain := fs.Arg(0)
+
+	log.Printf("Auditing %s (%s)...", subdomain, *strategy)
+	report, err := audit.Run(subdomain, *strategy, *apiKey)
+	if err != nil {
+		log.Fatalf("Audit failed: %v", err)
+	}
+
+	path, err := audit.Save(report)
+	if err != nil {
+		log.Fatalf("Failed to save report: %v", err)
+	}
+
+	fmt.Printf("\n--- Audit: %s ---\n", report.URL)
+	names := make([]string, 0, len(report.Scores))
+	for name := range report.Scores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%-16s %3.0f\n", name, report.Scores[name])
+	}
+	names = names[:0]
+	for name := range report.Metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%-26s %s\n", name, report.Metrics[name])
+	}
+	fmt.Println("-----------------------")
+	fmt.Printf("Report saved to %s\n", path)
+}
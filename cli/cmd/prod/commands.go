@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// command is a `prod <name>` subcommand. Each parses its own flag set.
+type command struct {
+	name  string
+	usage string // what follows the name in `prod -h`
+	run   func(args []string)
+}
+
+// commands are dispatched on the first argument; anything else runs
+// tunnels, so `prod 3000` is short for `prod up 3000`. Filled in init
+// because completion refers back to the list.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"up", "<profile|group|port...> [flags]", runUp},
+		{"status", "[-v] [-json] [-pid n]", runStatus},
+		{"logs", "[-f] [-n 20] [-subdomain abc] [-pid n]", runLogs},
+		{"config", "[path|edit|get <key>|set <key> <value>|unset <key>]", runConfig},
+		{"init", "[flags]", runInit},
+		{"login", "[token]", runLogin},
+		{"logout", "", func([]string) { runLogout() }},
+		{"audit", "<subdomain>", runAudit},
+		{"screenshot", "<subdomain> [path]", runScreenshot},
+		{"soak", "<subdomain> [path]", runSoak},
+		{"assert", "[-config asserts.yaml] [subdomain|url]", runAssert},
+		{"replay", "[-users 10] [-duration 1m] <scenario.yaml> <port|url>", runReplay},
+		{"serve", "[-spa] [-listing] <dir> [flags]", runServe},
+		{"link", "new -email <addr> [-subdomain abc] [-path /] [-ttl 24h]|list|revoke <id>", runLink},
+		{"export", "-har <file> [-subdomain abc] [-since 15m]", runExport},
+		{"hook", "github -repo <owner/name> [-events push] [flags] <port>", runHook},
+		{"import", "[-write] <ngrok.yml|cloudflared.yml>", runImport},
+		{"ready", "", runReady},
+		{"observe", "[-port 4041] <share-link>", runObserve},
+		{"connect", "[-listen 127.0.0.1:0] <url|subdomain>", runConnect},
+		{"completion", "bash|zsh|fish", runCompletion},
+	}
+}
+
+// findCommand returns the subcommand called name, or nil.
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// commandNames lists the subcommands, for completion.
+func commandNames() []string {
+	names := make([]string, len(commands))
+	for i, c := range commands {
+		names[i] = c.name
+	}
+	return names
+}
+
+// commandUsage renders the usage lines of `prod -h`.
+func commandUsage() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Usage: %s [flags] <port[:subdomain]|url>[=label] ...\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(&b, "       %s %s", os.Args[0], c.name)
+		if c.usage != "" {
+			b.WriteString(" " + c.usage)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
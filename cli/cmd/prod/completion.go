@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Flags are listed by the installed binary at completion time (from the
+// usage text of `prod -h`), so scripts stay current as plugins add flags.
+const bashCompletion = `# bash completion for prod
+_prod() {
+	local cur=${COMP_WORDS[COMP_CWORD]}
+	if [[ $cur == -* ]]; then
+		COMPREPLY=($(compgen -W "$(prod -h 2>&1 | sed -n 's/^  \(-[a-z0-9-]*\).*/\1/p')" -- "$cur"))
+	elif [[ $COMP_CWORD -eq 1 ]]; then
+		COMPREPLY=($(compgen -W "%s" -- "$cur"))
+	elif [[ $COMP_CWORD -eq 2 && ${COMP_WORDS[1]} == completion ]]; then
+		COMPREPLY=($(compgen -W "bash zsh fish" -- "$cur"))
+	fi
+}
+complete -F _prod prod
+`
+
+const zshCompletion = `# zsh completion for prod
+autoload -U +X bashcompinit && bashcompinit
+` + bashCompletion
+
+const fishCompletion = `# fish completion for prod
+complete -c prod -f -n __fish_use_subcommand -a '%s'
+complete -c prod -f -n '__fish_seen_subcommand_from completion' -a 'bash zsh fish'
+complete -c prod -f -n 'string match -q -- "-*" (commandline -ct)' -a '(prod -h 2>&1 | string replace -rf "^  (-[a-z0-9-]+).*" "\$1")'
+`
+
+// completionScript returns the completion script for shell.
+func completionScript(shell string) (string, error) {
+	words := strings.Join(commandNames(), " ")
+	switch shell {
+	case "bash":
+		return fmt.Sprintf(bashCompletion, words), nil
+	case "zsh":
+		return fmt.Sprintf(zshCompletion, words), nil
+	case "fish":
+		return fmt.Sprintf(fishCompletion, words), nil
+	}
+	return "", fmt.Errorf("unsupported shell %q (use bash, zsh or fish)", shell)
+}
+
+// runCompletion implements `prod completion <shell>`, printing a completion
+// script for package managers and rc files to load.
+func runCompletion(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s completion bash|zsh|fish\n", os.Args[0])
+		os.Exit(1)
+	}
+	script, err := completionScript(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Print(script)
+}
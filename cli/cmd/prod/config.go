@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/config"
+)
+
+// runConfig implements `prod config`, which shows and edits
+// ~/.prod/config.yaml. Keys are dotted paths into the file, e.g.
+// worker_url or profiles.myapp.flags.auth.
+func runConfig(args []string) {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s config              print the config file\n"+
+			"       %s config path         print its location\n"+
+			"       %s config edit         open it in $EDITOR\n"+
+			"       %s config get <key>\n"+
+			"       %s config set <key> <value>\n"+
+			"       %s config unset <key>\n\n"+
+			"Keys are dotted paths, e.g. worker_url or profiles.myapp.flags.auth.\n",
+			os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+		os.Exit(1)
+	}
+	path, err := config.FilePath()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(args) == 0 {
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "No config file yet (%s); see prod init and prod config set.\n", path)
+			return
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Stdout.Write(data)
+		return
+	}
+
+	switch cmd, rest := args[0], args[1:]; {
+	case cmd == "path" && len(rest) == 0:
+		fmt.Println(path)
+	case cmd == "edit" && len(rest) == 0:
+		editor := os.Getenv("VISUAL")
+		if editor == "" {
+			editor = os.Getenv("EDITOR")
+		}
+		if editor == "" {
+			editor = "vi"
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			log.Fatal(err)
+		}
+		c := exec.Command("sh", "-c", editor+` "$1"`, "sh", path)
+		c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := c.Run(); err != nil {
+			log.Fatalf("Editor failed: %v", err)
+		}
+		if _, err := config.LoadFile(); err != nil {
+			log.Fatalf("The config file no longer parses: %v", err)
+		}
+	case cmd == "get" && len(rest) == 1:
+		value, ok, err := config.GetValue(rest[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !ok {
+			fmt.Fprintf(os.Stderr, "%s is not set\n", rest[0])
+			os.Exit(1)
+		}
+		fmt.Println(value)
+	case cmd == "set" && len(rest) == 2:
+		if err := config.SetValue(rest[0], rest[1]); err != nil {
+			log.Fatalf("Failed to set %s: %v", rest[0], err)
+		}
+	case cmd == "unset" && len(rest) == 1:
+		ok, err := config.UnsetValue(rest[0])
+		if err != nil {
+			log.Fatalf("Failed to unset %s: %v", rest[0], err)
+		}
+		if !ok {
+			fmt.Fprintf(os.Stderr, "%s was not set\n", rest[0])
+		}
+	default:
+		usage()
+	}
+}
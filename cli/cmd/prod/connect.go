@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/config"
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+
+	"github.com/gorilla/websocket"
+)
+
+// runConnect implements `prod connect <url|subdomain>`: the client end of a
+// -tcp tunnel. Workers only take HTTP, so it accepts connections on a local
+// address and carries each one to the tunnel over its own WebSocket; point
+// psql, redis-cli or ssh at the address it prints.
+func runConnect(args []string) {
+	fs := flag.NewFlagSet("connect", flag.ExitOnError)
+	listen := fs.String("listen", "127.0.0.1:0", "Local address to accept connections on")
+	auth := fs.String("auth", "", "Basic auth credentials (user:pass) of a tunnel started with -auth")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s connect [flags] <url|subdomain>\n\nFlags:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := hooks.BindEnv(fs); err != nil {
+		log.Fatal(err)
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	target := fs.Arg(0)
+	if !strings.Contains(target, "://") {
+		target = config.PublicURL(target)
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		log.Fatalf("Invalid tunnel URL %q", fs.Arg(0))
+	}
+	switch u.Scheme {
+	case "https", "wss":
+		u.Scheme = "wss"
+	case "http", "ws":
+		u.Scheme = "ws"
+	default:
+		log.Fatalf("Invalid tunnel URL %q: want http(s)", fs.Arg(0))
+	}
+	header := http.Header{}
+	if *auth != "" {
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(*auth)))
+	}
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Forwarding %s -> %s", ln.Addr(), target)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Fatal(err)
+		}
+		go bridgeTCP(conn, u.String(), header)
+	}
+}
+
+// bridgeTCP copies conn to and from a new WebSocket to the tunnel at wsURL,
+// until either side closes.
+func bridgeTCP(conn net.Conn, wsURL string, header http.Header) {
+	defer conn.Close()
+	ws, resp, err := websocket.DefaultDialer.Dial(wsURL, header)
+	if err != nil {
+		if resp != nil {
+			err = fmt.Errorf("%w (%s)", err, resp.Status)
+		}
+		log.Printf("Connecting %s to the tunnel failed: %v", conn.RemoteAddr(), err)
+		return
+	}
+	defer ws.Close()
+
+	go func() {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := conn.Read(buf)
+			if n > 0 {
+				if werr := ws.WriteMessage(websocket.BinaryMessage, buf[:n]); werr != nil {
+					break
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+		ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	}()
+
+	for {
+		_, r, err := ws.NextReader()
+		if err != nil {
+			if ce, ok := err.(*websocket.CloseError); ok && ce.Code != websocket.CloseNormalClosure && ce.Text != "" {
+				log.Printf("Tunnel closed the connection from %s: %s", conn.RemoteAddr(), ce.Text)
+			}
+			return
+		}
+		if _, err := io.Copy(conn, r); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/config"
+	"github.com/QuadTriangle/prod.bd/cli/internal/tunnel"
+)
+
+// defaultHealthzAddr is where -container serves /healthz unless
+// -healthz-addr is set.
+const defaultHealthzAddr = ":9998"
+
+// setupContainer applies -container: JSON logs, local ports resolved on the
+// Docker host, and /healthz and /readyz for the orchestrator. Call after
+// flags are parsed.
+func setupContainer(fs *flag.FlagSet) {
+	log.SetFlags(0)
+	log.SetOutput(&jsonLogWriter{w: os.Stderr})
+
+	if f := fs.Lookup("healthz-addr"); f != nil && f.Value.String() == "" {
+		fs.Set("healthz-addr", defaultHealthzAddr)
+	}
+
+	host, err := config.ContainerTargetHost()
+	if err != nil {
+		log.Printf("Forwarding to %s: %v", config.GetTargetHost(), err)
+		return
+	}
+	config.SetTargetHost(host)
+	log.Printf("Forwarding local ports to %s", host)
+}
+
+// jsonLogWriter turns each log line into a JSON object with time, msg and,
+// for "[plugin] ..." lines, component; lines about one tunnel carry its name
+// in tunnel.
+type jsonLogWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+type logLine struct {
+	Time      string `json:"time"`
+	Component string `json:"component,omitempty"`
+	Tunnel    string `json:"tunnel,omitempty"`
+	Msg       string `json:"msg"`
+}
+
+func (j *jsonLogWriter) Write(p []byte) (int, error) {
+	line := logLine{Time: time.Now().UTC().Format(time.RFC3339Nano), Msg: strings.TrimRight(string(p), "\n")}
+	if name, rest, ok := tunnel.SplitLogLine(line.Msg); ok {
+		line.Tunnel, line.Msg = name, rest
+	}
+	if rest, ok := strings.CutPrefix(line.Msg, "["); ok {
+		if name, msg, ok := strings.Cut(rest, "] "); ok && !strings.ContainsAny(name, " []") {
+			line.Component, line.Msg = name, msg
+		}
+	}
+	data, err := json.Marshal(line)
+	if err != nil {
+		return 0, err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.w.Write(append(data, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
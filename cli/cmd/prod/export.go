@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"text/template"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/config"
+)
+
+// defaultURLTemplate is the -export-env line for each tunnel.
+const defaultURLTemplate = "PRODBD_URL_{{.Port}}={{.URL}}"
+
+// exportedTunnel is the data -url-template is executed with.
+type exportedTunnel struct {
+	Port      int
+	Subdomain string
+	URL       string
+	Label     string
+}
+
+// parseURLTemplate parses -url-template and does a dry run, so unknown
+// fields fail at startup rather than after registering.
+func parseURLTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("url").Parse(text)
+	if err == nil {
+		err = tmpl.Execute(io.Discard, exportedTunnel{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid -url-template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// writeExportEnv writes one templated line per tunnel, ordered by port, so
+// scripts can source the public URLs. The file is replaced atomically.
+func writeExportEnv(path string, tmpl *template.Template, mapping map[int]string, labels map[int]string) error {
+	ports := make([]int, 0, len(mapping))
+	for port := range mapping {
+		ports = append(ports, port)
+	}
+	slices.Sort(ports)
+
+	var buf bytes.Buffer
+	for _, port := range ports {
+		sub := mapping[port]
+		t := exportedTunnel{Port: port, Subdomain: sub, URL: config.PublicURL(sub), Label: labels[port]}
+		if err := tmpl.Execute(&buf, t); err != nil {
+			return fmt.Errorf("-url-template: %w", err)
+		}
+		buf.WriteByte('\n')
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".env-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/tunnel"
+)
+
+// planDepends parses -depends: comma-separated tunnel=dependency pairs,
+// each side a port label or a port number. It returns each port's
+// dependencies, refusing unknown tunnels and cycles.
+func planDepends(spec string, ports []int, labels map[int]string) (map[int][]int, error) {
+	resolve := func(name string) (int, error) {
+		for port, label := range labels {
+			if label == name {
+				return port, nil
+			}
+		}
+		if port, err := strconv.Atoi(name); err == nil && slices.Contains(ports, port) {
+			return port, nil
+		}
+		return 0, fmt.Errorf("-depends: %q is neither a label nor one of the tunnel's ports", name)
+	}
+
+	deps := make(map[int][]int)
+	for _, item := range splitList(spec) {
+		from, to, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("-depends: %q is not tunnel=dependency", item)
+		}
+		fromPort, err := resolve(strings.TrimSpace(from))
+		if err != nil {
+			return nil, err
+		}
+		toPort, err := resolve(strings.TrimSpace(to))
+		if err != nil {
+			return nil, err
+		}
+		if fromPort == toPort {
+			return nil, fmt.Errorf("-depends: %s depends on itself", from)
+		}
+		if !slices.Contains(deps[fromPort], toPort) {
+			deps[fromPort] = append(deps[fromPort], toPort)
+		}
+	}
+
+	// Depth-first search for cycles: 1 = on the current path, 2 = done
+	state := make(map[int]int)
+	var visit func(port int) error
+	visit = func(port int) error {
+		switch state[port] {
+		case 1:
+			return fmt.Errorf("-depends: cycle through %s", tunnelName(port, labels, ""))
+		case 2:
+			return nil
+		}
+		state[port] = 1
+		for _, dep := range deps[port] {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[port] = 2
+		return nil
+	}
+	for _, port := range ports {
+		if err := visit(port); err != nil {
+			return nil, err
+		}
+	}
+	return deps, nil
+}
+
+// tunnelName is how a port is referred to in messages: its label if it has
+// one, else fallback, else the port number.
+func tunnelName(port int, labels map[int]string, fallback string) string {
+	if l := labels[port]; l != "" {
+		return l
+	}
+	if fallback != "" {
+		return fallback
+	}
+	return strconv.Itoa(port)
+}
+
+// filterPorts resolves tunnel names (labels, subdomains or port numbers) to
+// ports.
+func filterPorts(names []string, mapping map[int]string, labels map[int]string) ([]int, error) {
+	var ports []int
+	for _, name := range names {
+		found := false
+		for port, sub := range mapping {
+			if name == labels[port] || name == sub || name == strconv.Itoa(port) {
+				ports = append(ports, port)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no tunnel named %q", name)
+		}
+	}
+	return ports, nil
+}
+
+// startGate lets a tunnel wait until the tunnels it depends on have
+// connected. It is a connection hook, fed by every tunnel's first connect.
+type startGate struct {
+	hooks.NoOpConnectionHook
+
+	mu sync.Mutex
+	up map[int]chan struct{} // port -> closed once connected
+}
+
+func newStartGate(ports []int) *startGate {
+	g := &startGate{up: make(map[int]chan struct{}, len(ports))}
+	for _, port := range ports {
+		g.up[port] = make(chan struct{})
+	}
+	return g
+}
+
+func (g *startGate) OnConnect(_ string, port int, _ *hooks.WarmupResult) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	select {
+	case <-g.up[port]:
+	default:
+		close(g.up[port])
+	}
+}
+
+// wait blocks until every port in deps has connected, returning false if
+// done closes first.
+func (g *startGate) wait(port int, deps []int, labels map[int]string, done <-chan struct{}) bool {
+	for _, dep := range deps {
+		select {
+		case <-g.up[dep]:
+			continue
+		default:
+		}
+		tunnel.Logf(port, "Waiting for %s to connect...", tunnelName(dep, labels, ""))
+		select {
+		case <-g.up[dep]:
+		case <-done:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/statsapi"
+)
+
+// runExport implements `prod export -har out.har`: it downloads the running
+// tunnel's request log from the dashboard API as a HAR file, to load into
+// browser devtools or share with teammates.
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	harPath := fs.String("har", "", "Write the request log to this HAR file (- for stdout)")
+	dashboardPort := fs.Int("dashboard-port", 9999, "Dashboard port of the running tunnel")
+	subdomain := fs.String("subdomain", "", "Only export requests to this tunnel")
+	method := fs.String("method", "", "Only export these methods (comma-separated)")
+	status := fs.String("status", "", "Only export these statuses, e.g. 404 or 5xx (comma-separated)")
+	pathPrefix := fs.String("path", "", "Only export paths starting with this prefix")
+	since := fs.Duration("since", 0, "Only export requests from this long ago onward, e.g. 15m (0 for the whole log)")
+	limit := fs.Int("limit", 1000, "Export at most this many of the newest matching requests (max 10000)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s export -har <file> [flags]\n\nFlags:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := hooks.BindEnv(fs); err != nil {
+		log.Fatal(err)
+	}
+	if *harPath == "" || fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	q := statsapi.RequestQuery{
+		Subdomain:  *subdomain,
+		Methods:    splitList(*method),
+		Statuses:   splitList(*status),
+		PathPrefix: *pathPrefix,
+		Limit:      *limit,
+	}
+	if *since > 0 {
+		q.Since = time.Now().Add(-*since)
+	}
+	client := statsapi.New(*dashboardPort)
+	har, err := client.Export(context.Background(), q)
+	if err != nil {
+		log.Fatalf("Export failed: %v", dashboardError(*dashboardPort, err))
+	}
+	defer har.Close()
+
+	out := os.Stdout
+	if *harPath != "-" {
+		f, err := os.Create(*harPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		out = f
+	}
+	n, err := io.Copy(out, har)
+	if err != nil {
+		log.Fatalf("Failed to write %s: %v", *harPath, err)
+	}
+	if *harPath != "-" {
+		log.Printf("Wrote %s (%d bytes)", *harPath, n)
+	}
+}
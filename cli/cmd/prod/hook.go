@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+)
+
+// hookProviderFlags maps each `prod hook <provider>` flag to the tunnel
+// flag it stands for.
+var hookProviderFlags = map[string]map[string]string{
+	"github": {
+		"repo":   "github-hook",
+		"events": "github-hook-events",
+		"token":  "github-token",
+		"path":   "hook-path",
+	},
+}
+
+// runHook implements `prod hook <provider> [flags] <port>`: it starts the
+// tunnels with the provider's webhook pointed at the public URL, and
+// restores the webhook on exit. Flags not listed in hookProviderFlags are
+// passed through as tunnel flags.
+func runHook(args []string) {
+	if len(args) < 1 || hookProviderFlags[args[0]] == nil {
+		fmt.Fprintf(os.Stderr, "Usage: %s hook github -repo <owner/name> [-events push,pull_request] [-path /webhooks] [-token $GITHUB_TOKEN] [flags] <port>\n", os.Args[0])
+		os.Exit(1)
+	}
+	renames := hookProviderFlags[args[0]]
+	tunnelArgs := make([]string, 0, len(args)-1)
+	hasRepo := os.Getenv(hooks.EnvName(renames["repo"])) != ""
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(arg, "-") {
+			key, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+			if to, ok := renames[key]; ok {
+				arg = "-" + to
+				if hasValue {
+					arg += "=" + value
+				}
+				hasRepo = hasRepo || key == "repo"
+			}
+		}
+		tunnelArgs = append(tunnelArgs, arg)
+	}
+	if !hasRepo {
+		fmt.Fprintf(os.Stderr, "%s hook %s: -repo is required\n", os.Args[0], args[0])
+		os.Exit(1)
+	}
+	runTunnels(tunnelArgs, nil)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/config"
+)
+
+// runImport implements `prod import <ngrok.yml|cloudflared.yml>`: it
+// translates the other tool's tunnels into profiles, printing them or, with
+// -write, adding them to ~/.prod/config.yaml.
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	write := fs.Bool("write", false, "Add the profiles to ~/.prod/config.yaml instead of printing them")
+	force := fs.Bool("force", false, "With -write, replace profiles that already exist")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s import [flags] <ngrok.yml|cloudflared config.yml>\n\nngrok tunnels become one profile each; a cloudflared config becomes the \"cloudflared\" profile.\n\nFlags:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	im, err := config.ImportFile(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, w := range im.Warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+	}
+	if len(im.Profiles) == 0 {
+		log.Fatal("nothing to import")
+	}
+
+	if !*write {
+		out, err := config.MarshalYAML(map[string]any{"profiles": im.Profiles})
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Stdout.Write(out)
+		return
+	}
+	if err := config.AddProfiles(im.Profiles, *force); err != nil {
+		log.Fatal(err)
+	}
+	path, _ := config.FilePath()
+	for _, name := range slices.Sorted(maps.Keys(im.Profiles)) {
+		fmt.Printf("Added profile %q (run: %s up %s)\n", name, os.Args[0], name)
+	}
+	fmt.Printf("Saved to %s\n", path)
+}
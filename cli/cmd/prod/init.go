@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/config"
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+)
+
+// starterConfig is written to ~/.prod/config.yaml by `prod init`.
+const starterConfig = `# prod configuration. Flags in profiles and presets are the CLI flags
+# without the leading dash; run "prod -h" for the full list.
+%sprofiles:
+  dev:
+    ports: ["%d"]
+    flags:
+      noindex: "true"
+# presets:
+#   review:
+#     banner: "true"
+#     banner-feedback: "true"
+`
+
+// runInit implements `prod init [flags]`: it creates the client ID, saves an
+// account token, writes a starter config file and installs shell completion.
+// Values not given as flags are asked for when stdin is a terminal.
+func runInit(args []string) {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	token := fs.String("token", "", "Account token to save (default: $"+config.TokenEnv+", or prompt)")
+	workerURL := fs.String("worker-url", "", "Worker URL to store in the config file (default "+config.DefaultWorkerURL+")")
+	port := fs.Int("port", 3000, "Local port for the starter \"dev\" profile")
+	shell := fs.String("shell", filepath.Base(os.Getenv("SHELL")), "Shell to install completion for: bash, zsh, fish or none")
+	yes := fs.Bool("yes", false, "Don't prompt; use flags and defaults only")
+	force := fs.Bool("force", false, "Overwrite an existing config file")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s init [flags]\n\nFlags:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := hooks.BindEnv(fs); err != nil {
+		log.Fatal(err)
+	}
+
+	var in *bufio.Reader
+	if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 && !*yes {
+		in = bufio.NewReader(os.Stdin)
+	}
+	ask := func(prompt, def string) string {
+		if in == nil {
+			return def
+		}
+		fmt.Fprintf(os.Stderr, "%s [%s]: ", prompt, def)
+		line, _ := in.ReadString('\n')
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+		return def
+	}
+
+	// 1. Identity
+	id, err := config.GetClientID()
+	if err != nil {
+		log.Fatalf("Failed to create client ID: %v", err)
+	}
+	fmt.Printf("Client ID: %s\n", id)
+
+	// 2. Account token
+	if *token == "" {
+		*token = ask("Account token (blank if the worker doesn't require one)", "")
+	}
+	if *token != "" {
+		if err := config.SaveToken(strings.TrimSpace(*token)); err != nil {
+			log.Fatalf("Failed to save token: %v", err)
+		}
+		fmt.Println("Token saved to ~/.prod/token")
+	}
+
+	// 3. Config file
+	path, err := config.FilePath()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := os.Stat(path); err == nil && !*force {
+		fmt.Printf("Keeping existing %s (use -force to overwrite)\n", path)
+	} else {
+		if *workerURL == "" {
+			*workerURL = ask("Worker URL", config.GetWorkerURL())
+		}
+		workerLine := ""
+		if *workerURL != "" && *workerURL != config.DefaultWorkerURL {
+			workerLine = fmt.Sprintf("worker_url: %s\n", *workerURL)
+		}
+		if err := os.WriteFile(path, fmt.Appendf(nil, starterConfig, workerLine, *port), 0644); err != nil {
+			log.Fatalf("Failed to write config file: %v", err)
+		}
+		fmt.Printf("Wrote %s\n", path)
+	}
+
+	// 4. Shell completion
+	if *shell != "none" && *shell != "" && *shell != "." {
+		if err := installCompletion(*shell); err != nil {
+			log.Printf("Skipping shell completion: %v", err)
+		}
+	}
+
+	fmt.Printf("\nReady. Start a tunnel with `%s %d` or `%s up dev`.\n", os.Args[0], *port, os.Args[0])
+}
+
+// installCompletion writes the completion script for shell. fish loads it
+// from its completions directory; bash and zsh need a line in the rc file,
+// which is printed rather than edited in.
+func installCompletion(shell string) error {
+	script, err := completionScript(shell)
+	if err != nil {
+		return err
+	}
+	var path string
+	if shell == "fish" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		path = filepath.Join(home, ".config", "fish", "completions", "prod.fish")
+	} else {
+		dir, err := config.Dir()
+		if err != nil {
+			return err
+		}
+		path = filepath.Join(dir, "completion."+shell)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Installed %s completion to %s\n", shell, path)
+	if shell != "fish" {
+		fmt.Printf("  add to ~/.%src: source %s\n", shell, path)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/statsapi"
+)
+
+// runLink makes and manages magic links on a tunnel started with
+// -magic-links.
+func runLink(args []string) {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s link new -email <addr> [-subdomain abc] [-path /] [-ttl 24h]\n"+
+			"       %s link list [-subdomain abc]\n"+
+			"       %s link revoke <id>\n\n"+
+			"Each also takes -dashboard-port and -pid to pick the prod to ask.\n",
+			os.Args[0], os.Args[0], os.Args[0])
+		os.Exit(1)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+	fs := flag.NewFlagSet("link "+args[0], flag.ExitOnError)
+	dashboardPort := fs.Int("dashboard-port", 9999, "Ask the tunnel on this dashboard port instead of the running prod")
+	pid := fs.Int("pid", 0, "Process ID of the prod to ask, if several are running")
+	fs.Usage = usage
+
+	ctx := context.Background()
+	switch args[0] {
+	case "new":
+		email := fs.String("email", "", "Who the link is for")
+		subdomain := fs.String("subdomain", "", "Tunnel the link opens, if several are running")
+		path := fs.String("path", "/", "Page the link lands on")
+		ttl := fs.Duration("ttl", 0, "How long the link can be opened (default the tunnel's -magic-link-ttl)")
+		proc := linkProcess(fs, args[1:], dashboardPort, pid)
+		if *email == "" || fs.NArg() > 0 {
+			usage()
+		}
+		l, err := proc.Client.NewLink(ctx, statsapi.LinkRequest{Subdomain: *subdomain, Email: *email, Path: *path, TTL: *ttl})
+		if err != nil {
+			log.Fatal(processError(proc, *dashboardPort, err))
+		}
+		fmt.Println(l.URL)
+		fmt.Fprintf(os.Stderr, "Link %s for %s, single use, valid until %s\n", l.ID, l.Email, time.Unix(l.ExpiresAt, 0).Format(time.DateTime))
+
+	case "list":
+		subdomain := fs.String("subdomain", "", "Only list links to this tunnel")
+		proc := linkProcess(fs, args[1:], dashboardPort, pid)
+		links, err := proc.Client.Links(ctx, *subdomain)
+		if err != nil {
+			log.Fatal(processError(proc, *dashboardPort, err))
+		}
+		if len(links) == 0 {
+			fmt.Println("No magic links yet; make one with prod link new -email <addr>")
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tTUNNEL\tEMAIL\tPATH\tSTATE")
+		for _, l := range links {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", l.ID, l.Subdomain, l.Email, l.Path, linkState(l, time.Now()))
+		}
+		w.Flush()
+
+	case "revoke":
+		proc := linkProcess(fs, args[1:], dashboardPort, pid)
+		if fs.NArg() != 1 {
+			usage()
+		}
+		if err := proc.Client.RevokeLink(ctx, fs.Arg(0)); err != nil {
+			log.Fatal(processError(proc, *dashboardPort, err))
+		}
+		fmt.Printf("Revoked %s\n", fs.Arg(0))
+
+	default:
+		usage()
+	}
+}
+
+// linkProcess parses args into fs and returns the prod to ask.
+func linkProcess(fs *flag.FlagSet, args []string, port, pid *int) statsapi.Process {
+	fs.Parse(args)
+	if err := hooks.BindEnv(fs); err != nil {
+		log.Fatal(err)
+	}
+	procs := runningProcesses(fs, *port, *pid)
+	if len(procs) > 1 {
+		pids := make([]string, len(procs))
+		for i, p := range procs {
+			pids[i] = strconv.Itoa(p.PID)
+		}
+		log.Fatalf("Several prod processes are running (%s); pick one with -pid", strings.Join(pids, ", "))
+	}
+	return procs[0]
+}
+
+// linkState describes where a link is in its life for prod link list.
+func linkState(l statsapi.Link, now time.Time) string {
+	switch {
+	case l.Revoked:
+		return "revoked"
+	case l.UsedAt != 0:
+		return "used " + time.Unix(l.UsedAt, 0).Format(time.DateTime)
+	case now.Unix() > l.ExpiresAt:
+		return "expired"
+	}
+	return "open until " + time.Unix(l.ExpiresAt, 0).Format(time.DateTime)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/config"
+)
+
+// runLogin implements `prod login [token]`. Without an argument the token is
+// read from stdin, so it stays out of shell history.
+func runLogin(args []string) {
+	if len(args) > 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s login [token]\n", os.Args[0])
+		os.Exit(1)
+	}
+
+	token := ""
+	if len(args) == 1 {
+		token = args[0]
+	} else {
+		fmt.Fprint(os.Stderr, "Paste your account token: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			log.Fatalf("Failed to read token: %v", err)
+		}
+		token = line
+	}
+	token = strings.TrimSpace(token)
+	if token == "" {
+		log.Fatal("Token must not be empty")
+	}
+
+	if err := config.SaveToken(token); err != nil {
+		log.Fatalf("Failed to save token: %v", err)
+	}
+	fmt.Println("Logged in. Token saved to ~/.prod/token")
+}
+
+// runLogout implements `prod logout`.
+func runLogout() {
+	if err := config.SaveToken(""); err != nil {
+		log.Fatalf("Failed to remove token: %v", err)
+	}
+	fmt.Println("Logged out.")
+}
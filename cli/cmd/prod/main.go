@@ -1,63 +1,394 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"log"
+	"maps"
+	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/QuadTriangle/prod.bd/cli/internal/config"
 	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/plugins/access"
 	"github.com/QuadTriangle/prod.bd/cli/internal/plugins/auth"
+	"github.com/QuadTriangle/prod.bd/cli/internal/plugins/banner"
+	"github.com/QuadTriangle/prod.bd/cli/internal/plugins/eventstream"
+	"github.com/QuadTriangle/prod.bd/cli/internal/plugins/headerpolicy"
+	"github.com/QuadTriangle/prod.bd/cli/internal/plugins/health"
+	"github.com/QuadTriangle/prod.bd/cli/internal/plugins/hostrewrite"
+	"github.com/QuadTriangle/prod.bd/cli/internal/plugins/inject"
+	"github.com/QuadTriangle/prod.bd/cli/internal/plugins/inspector"
 	"github.com/QuadTriangle/prod.bd/cli/internal/plugins/ipallow"
+	"github.com/QuadTriangle/prod.bd/cli/internal/plugins/jwt"
+	"github.com/QuadTriangle/prod.bd/cli/internal/plugins/magiclink"
+	"github.com/QuadTriangle/prod.bd/cli/internal/plugins/mock"
+	"github.com/QuadTriangle/prod.bd/cli/internal/plugins/noindex"
+	"github.com/QuadTriangle/prod.bd/cli/internal/plugins/oauth"
+	"github.com/QuadTriangle/prod.bd/cli/internal/plugins/password"
+	"github.com/QuadTriangle/prod.bd/cli/internal/plugins/ratelimit"
+	"github.com/QuadTriangle/prod.bd/cli/internal/plugins/schema"
 	"github.com/QuadTriangle/prod.bd/cli/internal/plugins/stats"
+	"github.com/QuadTriangle/prod.bd/cli/internal/plugins/totp"
+	"github.com/QuadTriangle/prod.bd/cli/internal/plugins/tui"
+	"github.com/QuadTriangle/prod.bd/cli/internal/plugins/webhooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/presets"
+	"github.com/QuadTriangle/prod.bd/cli/internal/protobuf"
+	"github.com/QuadTriangle/prod.bd/cli/internal/proxy"
 	"github.com/QuadTriangle/prod.bd/cli/internal/tunnel"
 )
 
 func main() {
+	// Subcommands
+	if len(os.Args) > 1 {
+		if c := findCommand(os.Args[1]); c != nil {
+			c.run(os.Args[2:])
+			return
+		}
+	}
+
+	runTunnels(os.Args[1:], nil)
+}
+
+// runTunnels parses tunnel flags and ports from args and runs until interrupted.
+// up is the profile or group of prod up, re-read on reload; nil otherwise.
+func runTunnels(cliArgs []string, up *upSource) {
 	pipeline := &hooks.Pipeline{}
 
 	// --- Register plugins ---
 	// Each plugin owns its own flags and config.
 	// To add a new feature, just add a line here:
-	//   pipeline.RegisterPlugin(inspector.New())
 	//   pipeline.RegisterPlugin(qrcode.New())
 	//   pipeline.RegisterPlugin(auth.New())
-	pipeline.RegisterPlugin(stats.New())
+	statsPlugin := stats.New()
+	statsPlugin.SetOverheadSource(pipeline.Overhead)
+	pipeline.RegisterPlugin(statsPlugin)
 	pipeline.RegisterPlugin(ipallow.New())
+	pipeline.RegisterPlugin(ratelimit.New())
 	pipeline.RegisterPlugin(auth.New())
+	// Before anything that answers requests itself (inspector, mock)
+	oauthPlugin := oauth.New()
+	pipeline.RegisterPlugin(oauthPlugin)
+	passwordPlugin := password.New()
+	pipeline.RegisterPlugin(passwordPlugin)
+	jwtPlugin := jwt.New()
+	pipeline.RegisterPlugin(jwtPlugin)
+	magicPlugin := magiclink.New()
+	pipeline.RegisterPlugin(magicPlugin)
+	statsPlugin.SetLinks(magicPlugin)
+	totpPlugin := totp.New(pipeline.AuthPlugins)
+	pipeline.RegisterPlugin(totpPlugin)
+	// After the auth plugins, whose schemes it combines
+	accessPlugin := access.New(pipeline.AuthPlugins)
+	pipeline.RegisterPlugin(accessPlugin)
+	schemaPlugin := schema.New()
+	pipeline.RegisterPlugin(schemaPlugin)
+	pipeline.RegisterPlugin(inspector.New())
+	mockPlugin := mock.New()
+	pipeline.RegisterPlugin(mockPlugin)
+	pipeline.RegisterPlugin(headerpolicy.New())
+	pipeline.RegisterPlugin(hostrewrite.New())
+	bannerPlugin := banner.New()
+	pipeline.RegisterPlugin(bannerPlugin)
+	statsPlugin.SetFeedbackWidget(bannerPlugin)
+	pipeline.RegisterPlugin(inject.New())
+	tuiPlugin := tui.New(statsPlugin.Store())
+	pipeline.RegisterPlugin(tuiPlugin)
+	pipeline.RegisterPlugin(noindex.New())
+	healthPlugin := health.New()
+	pipeline.RegisterPlugin(healthPlugin)
+	eventsPlugin := eventstream.New()
+	pipeline.RegisterPlugin(eventsPlugin)
+	webhooksPlugin := webhooks.New()
+	pipeline.RegisterPlugin(webhooksPlugin)
+
+	// Presets compose the plugins above into named bundles (-preset <name>).
+	// Presets from ~/.prod/config.yaml can add to or override the built-ins.
+	for _, ps := range presets.All {
+		pipeline.RegisterPreset(ps)
+	}
+	if file, err := config.LoadFile(); err != nil {
+		log.Printf("Ignoring config file: %v", err)
+	} else {
+		for name, values := range file.Presets {
+			pipeline.RegisterPreset(hooks.Preset{Name: name, Description: "from config file", Flags: values})
+		}
+	}
 
 	// Let plugins register their flags, then parse
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [flags] <port> [port...]\n\nFlags:\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "%s\nEvery flag can also be set from the environment as %s<FLAG> (e.g. %s):\ncommand-line flags win over env, which wins over presets and profiles.\n\nFlags:\n", commandUsage(), hooks.EnvPrefix, hooks.EnvName("dashboard-port"))
 		flag.PrintDefaults()
 	}
+	subdomainFlag := flag.String("subdomain", "", "Request a specific subdomain (single port only; use port:subdomain for several)")
+	presetFlag := flag.String("preset", "", "Apply comma-separated presets of plugin flags: "+presetNames(pipeline))
+	modeFlag := flag.String("mode", "", "Alias for -preset")
+	retryMaxFlag := flag.Duration("retry-max", tunnel.DefaultBackoff.Max, "Longest wait between reconnect attempts")
+	pingIntervalFlag := flag.Duration("ping-interval", 30*time.Second, "How often to ping the worker to keep the tunnel alive and detect dead connections (0 to disable)")
+	maxMissedPingsFlag := flag.Int("max-missed-pings", 3, "Reconnect after this many keepalive pings in a row go unanswered")
+	lowPowerFlag := flag.String("low-power", tunnel.LowPowerOff, "Go easy on a laptop's battery while tunnels are idle: battery (only when unplugged), always, or off")
+	lowPowerIdleFlag := flag.Duration("low-power-idle", 5*time.Minute, "How long without requests before -low-power kicks in")
+	lowPowerPingFlag := flag.Duration("low-power-ping", 90*time.Second, "Keepalive interval in -low-power mode")
+	networkPollFlag := flag.Duration("network-poll", 2*time.Second, "How often to check for a network change (Wi-Fi to hotspot, VPN) and reconnect right away (0 to disable)")
+	drainFlag := flag.Duration("drain-timeout", 10*time.Second, "On shutdown, how long to let in-flight requests finish (0 to close immediately)")
+	ttlFlag := flag.Duration("ttl", 0, "Close the tunnels after this long, e.g. 2h, so a forgotten one doesn't stay exposed (0 for no limit)")
+	ttlWarningFlag := flag.Duration("ttl-warning", 5*time.Minute, "How long before -ttl runs out to warn connection hooks (TUI, -event-stream) and the log")
+	ttlRenewFlag := flag.Bool("ttl-renew", false, "When -ttl runs out, register again and reconnect instead of exiting; the URLs may change")
+	targetFlag := flag.String("target", "", "Comma-separated local target URLs, e.g. https://192.168.1.10:3000 (same as passing them as arguments)")
+	workerCertFlag := flag.String("worker-cert", "", "Client certificate (PEM) to present to a self-hosted worker that requires mTLS; needs -worker-key")
+	workerKeyFlag := flag.String("worker-key", "", "Private key (PEM) of -worker-cert")
+	workerCAFlag := flag.String("worker-ca", "", "CA bundle (PEM) to trust for the worker's certificate, on top of the system roots")
+	insecureFlag := flag.Bool("insecure-skip-verify", false, "Don't verify TLS certificates of https targets (self-signed dev certs)")
+	maxBodySize := byteSize(10 << 20)
+	flag.Var(&maxBodySize, "max-body-size", "Largest request or buffered response body to tunnel, e.g. 512KB, 10MB (0 for no limit)")
+	compressMinSize := byteSize(1 << 10)
+	flag.Var(&compressMinSize, "compress-min-size", "Gzip buffered responses at least this large over the tunnel when the worker supports it, e.g. 1KB (0 to disable)")
+	warmupFlag := flag.String("warmup", "", "Path to GET on the local server when a tunnel connects, so the first visitor skips the cold start (e.g. /)")
+	warmupConnsFlag := flag.Int("warmup-conns", 4, "Keep-alive connections to open with -warmup")
+	healthcheckFlag := flag.String("healthcheck", "", "Path to GET on the local server before registering and every -healthcheck-interval; while it fails, visitors get a 503 \"local app not running\" page (e.g. /healthz)")
+	healthcheckIntervalFlag := flag.Duration("healthcheck-interval", 10*time.Second, "How often to probe the local server with -healthcheck")
+	maxConcurrentFlag := flag.Int("max-concurrent", 0, "Requests served at once per tunnel; extra ones queue with page loads first, then assets, then webhooks (0 for no limit)")
+	maxQueuedFlag := flag.Int("max-queued", 100, "Requests waiting per tunnel under -max-concurrent before more are answered with 503 (0 for no limit)")
+	webhookPathsFlag := flag.String("webhook-paths", strings.Join(tunnel.DefaultClassifier.Webhooks, ","), "Comma-separated path globs queued as webhooks (lowest priority) under -max-concurrent")
+	assetPathsFlag := flag.String("asset-paths", strings.Join(tunnel.DefaultClassifier.Assets, ","), "Comma-separated path globs queued as assets under -max-concurrent; static file extensions always are")
+	costPerGBFlag := flag.Float64("cost-per-gb", 0, "Price of a GB of tunnel traffic on your connection or relay, to estimate costs in the stats and the exit summary (e.g. 0.09)")
+	routeFlag := flag.String("route", "", "Send path prefixes to other local ports: comma-separated prefix=port rules, or tunnel:prefix=port for one tunnel (e.g. /api=8080 or 3000:/api=8080); the rest goes to the tunnel's own port")
+	throttleFlag := flag.String("throttle", "", "Limit bandwidth to simulate a slow network, e.g. 1mbps or 500KB/s; port=rate pairs set it per tunnel (e.g. 1mbps,8080=256kbps)")
+	latencyFlag := flag.String("latency", "", "Delay each request to simulate a slow network, e.g. 200ms; port=duration pairs set it per tunnel")
+	protoFlag := flag.String("proto", "", "Comma-separated .proto files or directories; protobuf and gRPC-web bodies then show as JSON in the dashboard, inspector and HAR exports")
+	protoTypesFlag := flag.String("proto-types", "", "Message types of plain protobuf bodies by path, as path=pkg.Message pairs (e.g. /api/items=shop.ItemList); gRPC-web types come from the services in -proto")
+	coalesceFlag := flag.Bool("coalesce", false, "Serve identical concurrent GETs with a single local request (for slow endpoints under bursts)")
+	containerFlag := flag.Bool("container", false, "Container mode: JSON logs, local ports resolved on the Docker host, /healthz and /readyz on -healthz-addr (set by the official image)")
+	tcpFlag := flag.String("tcp", "", "Comma-separated ports to expose as raw TCP (e.g. 5432,6379), accepts port:subdomain; clients reach them through prod connect")
+	unixFlag := flag.String("unix", "", "Comma-separated unix sockets of local HTTP servers to expose, each optionally =subdomain (e.g. /tmp/app.sock=myapp)")
+	regionFlag := flag.String("region", "", "Worker region from the config file's regions, or auto for the lowest latency; port=region pairs force single tunnels (e.g. auto,8080=eu)")
+	groupFlag := flag.String("group", "", "Group name shown with the tunnels in the output and stats (set by up <group>)")
+	dependsFlag := flag.String("depends", "", "Connect a tunnel only after others have: comma-separated tunnel=dependency pairs of labels or ports (e.g. web=api)")
+	logFilterFlag := flag.String("log-filter", "", "Only log lines of these tunnels: comma-separated labels, subdomains or ports (lines not about a tunnel are always shown)")
+	pluginPortsFlag := flag.String("plugin-ports", "", "Limit plugins to some tunnels: comma-separated plugin=port pairs (e.g. ratelimit=3000,inspector=3000,inspector=8080); unlisted plugins run for all")
+	providerFlag := flag.String("provider", "prodbd", "Tunnel server protocol: prodbd, or localtunnel to serve through a localtunnel server at -host")
+	hostFlag := flag.String("host", "https://localtunnel.me", "localtunnel server URL for -provider localtunnel")
+	exportEnvFlag := flag.String("export-env", "", "After registering, write one line per tunnel (PRODBD_URL_<PORT>=<url>) to this file for scripts to source")
+	urlTemplateFlag := flag.String("url-template", defaultURLTemplate, "Go template for each -export-env line; fields: .Port .Subdomain .URL .Label")
 	pipeline.RegisterFlags(flag.CommandLine)
-	flag.Parse()
+	flag.CommandLine.Parse(cliArgs)
+	if err := hooks.BindEnv(flag.CommandLine); err != nil {
+		log.Fatal(err)
+	}
+	if *presetFlag == "" {
+		*presetFlag = *modeFlag
+	}
+	if *presetFlag != "" {
+		if err := pipeline.ApplyPreset(flag.CommandLine, *presetFlag); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *containerFlag {
+		setupContainer(flag.CommandLine)
+	}
 
 	args := flag.Args()
-	if len(args) < 1 {
+	if *targetFlag != "" {
+		args = append(args, strings.Split(*targetFlag, ",")...)
+	}
+	if len(args) < 1 && *tcpFlag == "" && *unixFlag == "" {
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	ports := make([]int, 0, len(args))
-	for _, arg := range args {
-		port, err := strconv.Atoi(arg)
+	parsed, err := parsePorts(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var tcpPorts []int
+	if *tcpFlag != "" {
+		tcp, err := parseTCPPorts(*tcpFlag, parsed.ports)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tcpPorts = tcp.ports
+		parsed.add(tcp)
+	}
+	if *unixFlag != "" {
+		sockets, err := parseUnixSockets(splitList(*unixFlag), parsed.ports)
 		if err != nil {
-			log.Fatalf("Invalid port: %s", arg)
+			log.Fatal(err)
 		}
-		ports = append(ports, port)
+		parsed.add(sockets)
+	}
+	ports, subdomains, targets, labels := parsed.ports, parsed.subdomains, parsed.targets, parsed.labels
+	for port, target := range targets {
+		proxy.SetTarget(port, target)
+	}
+	proxy.SetInsecureSkipVerify(*insecureFlag)
+	proxy.SetMaxBodySize(int64(maxBodySize))
+	proxy.SetCoalesce(*coalesceFlag)
+	proxy.SetCompression(int64(compressMinSize))
+	if *warmupFlag != "" && !strings.HasPrefix(*warmupFlag, "/") {
+		log.Fatal("-warmup must be a path starting with /")
+	}
+	tunnel.SetWarmup(*warmupFlag, *warmupConnsFlag)
+	if *healthcheckFlag != "" && !strings.HasPrefix(*healthcheckFlag, "/") {
+		log.Fatal("-healthcheck must be a path starting with /")
+	}
+	if *healthcheckIntervalFlag <= 0 {
+		log.Fatal("-healthcheck-interval must be positive")
+	}
+	tunnel.SetHealthCheck(*healthcheckFlag, *healthcheckIntervalFlag)
+	if *pingIntervalFlag < 0 || *maxMissedPingsFlag < 1 {
+		log.Fatal("-ping-interval can't be negative and -max-missed-pings must be at least 1")
+	}
+	tunnel.SetKeepalive(*pingIntervalFlag, *maxMissedPingsFlag)
+	if err := tunnel.SetWorkerTLS(*workerCertFlag, *workerKeyFlag, *workerCAFlag); err != nil {
+		log.Fatalf("-worker-cert/-worker-key/-worker-ca: %v", err)
+	}
+	if *networkPollFlag < 0 {
+		log.Fatal("-network-poll can't be negative")
+	}
+	tunnel.SetNetworkWatch(*networkPollFlag)
+	switch *lowPowerFlag {
+	case tunnel.LowPowerOff, tunnel.LowPowerBattery, tunnel.LowPowerAlways:
+	default:
+		log.Fatalf("-low-power must be battery, always or off, not %q", *lowPowerFlag)
+	}
+	if *lowPowerIdleFlag <= 0 || *lowPowerPingFlag <= 0 {
+		log.Fatal("-low-power-idle and -low-power-ping must be positive")
+	}
+	tunnel.SetLowPower(*lowPowerFlag, *lowPowerIdleFlag, *lowPowerPingFlag)
+	if *ttlFlag < 0 || *ttlWarningFlag < 0 {
+		log.Fatal("-ttl and -ttl-warning can't be negative")
+	}
+	if *ttlRenewFlag && *ttlFlag == 0 {
+		log.Fatal("-ttl-renew needs -ttl")
+	}
+	if *maxQueuedFlag < 0 {
+		log.Fatal("-max-queued can't be negative")
+	}
+	tunnel.SetScheduling(*maxConcurrentFlag, *maxQueuedFlag, tunnel.Classifier{
+		Webhooks: splitList(*webhookPathsFlag),
+		Assets:   splitList(*assetPathsFlag),
+	})
+	tunnel.SetTCPPorts(tcpPorts)
+	if *subdomainFlag != "" {
+		if len(ports) != 1 {
+			log.Fatal("-subdomain needs exactly one port; use port:subdomain pairs instead")
+		}
+		subdomains[ports[0]] = strings.ToLower(*subdomainFlag)
+	}
+
+	if err := applyThrottle(*throttleFlag, *latencyFlag, ports); err != nil {
+		log.Fatal(err)
+	}
+	if err := applyRoutes(*routeFlag, ports); err != nil {
+		log.Fatal(err)
+	}
+	if *costPerGBFlag < 0 {
+		log.Fatal("-cost-per-gb can't be negative")
+	}
+	statsPlugin.Store().SetUsageSource(tunnel.Usage, *costPerGBFlag)
+	if *protoFlag != "" {
+		reg, err := protobuf.Load(splitList(*protoFlag))
+		if err != nil {
+			log.Fatalf("-proto: %v", err)
+		}
+		if err := reg.SetTypes(*protoTypesFlag); err != nil {
+			log.Fatalf("-proto-types: %v", err)
+		}
+		protobuf.Use(reg)
+	} else if *protoTypesFlag != "" {
+		log.Fatal("-proto-types needs -proto")
+	}
+
+	urlTemplate, err := parseURLTemplate(*urlTemplateFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := eventsPlugin.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if err := statsPlugin.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if err := webhooksPlugin.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if err := oauthPlugin.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if err := passwordPlugin.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if err := jwtPlugin.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if err := magicPlugin.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if err := totpPlugin.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if err := accessPlugin.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if err := mockPlugin.Load(); err != nil {
+		log.Fatal(err)
+	}
+	if err := schemaPlugin.Load(); err != nil {
+		log.Fatal(err)
+	}
+	localtunnel := false
+	switch *providerFlag {
+	case "prodbd":
+	case "localtunnel":
+		// localtunnel servers only forward HTTP, and have nothing to
+		// enforce the worker-side options with
+		localtunnel = true
+		if len(tcpPorts) > 0 {
+			log.Fatal("-tcp isn't supported with -provider localtunnel")
+		}
+		if *regionFlag != "" {
+			log.Fatal("-region isn't supported with -provider localtunnel")
+		}
+		if u, err := url.Parse(*hostFlag); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			log.Fatalf("-host must be an http(s) URL, got %q", *hostFlag)
+		}
+	default:
+		log.Fatalf("unknown -provider %q (prodbd or localtunnel)", *providerFlag)
+	}
+
+	depends, err := planDepends(*dependsFlag, ports, labels)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	statsPlugin.Store().SetLabels(labels)
+	statsPlugin.Store().SetGroup(*groupFlag)
+	if err := scopePlugins(pipeline, *pluginPortsFlag, ports); err != nil {
+		log.Fatal(err)
 	}
 
 	// Activate enabled plugins (collect hooks)
 	pipeline.Activate()
-
-	workerURL := config.GetWorkerURL()
+	if localtunnel && len(pipeline.WorkerConfig()) > 0 {
+		log.Fatal("-auth, -allow-ip (unless one of several ways in with -access) and -oauth-provider rely on the prod.bd worker and aren't available with -provider localtunnel")
+	}
+	gate := newStartGate(ports)
+	pipeline.AddConnectionHook(gate)
+	reload, err := newReloader(up, pipeline, cliArgs)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// 1. Get Client ID
 	clientID, err := config.GetClientID()
@@ -65,19 +396,93 @@ func main() {
 		log.Fatalf("Failed to get client ID: %v", err)
 	}
 
-	// 2. Register Ports (with merged plugin config)
-	log.Println("Registering ports...")
-	mapping, err := tunnel.Register(clientID, ports, workerURL, pipeline.WorkerConfig())
+	// Probe local servers first, so a typo'd port shows up before the URLs
+	var httpPorts []int
+	for _, port := range ports {
+		if !slices.Contains(tcpPorts, port) {
+			httpPorts = append(httpPorts, port)
+		}
+	}
+	tunnel.CheckTargets(httpPorts)
+
+	// 2. Register Ports (with merged plugin config), with each port's region
+	workers, regions, err := planRegions(*regionFlag, ports)
 	if err != nil {
-		log.Fatalf("Failed to register ports: %v", err)
+		log.Fatal(err)
 	}
+	statsPlugin.Store().SetRegions(regions)
+	// Registration runs again for each -ttl-renew session
+	register := func() map[int]string {
+		log.Println("Registering ports...")
+		mapping := make(map[int]string, len(ports))
+		workerURLs, groups := portsByWorker(workers)
+		if localtunnel {
+			m, err := tunnel.RegisterLocaltunnel(*hostFlag, ports, subdomains)
+			if err != nil {
+				log.Fatalf("Failed to register ports: %v", err)
+			}
+			mapping, workerURLs = m, nil
+		}
+		for _, workerURL := range workerURLs {
+			m, err := tunnel.Register(clientID, groups[workerURL], subdomains, tcpPorts, labels, workerURL, reload.workerConfig())
+			if errors.Is(err, tunnel.ErrUnauthorized) {
+				log.Fatal(err)
+			}
+			if err != nil {
+				log.Fatalf("Failed to register ports: %v", err)
+			}
+			maps.Copy(mapping, m)
+		}
 
-	// 3. Print Mappings
-	fmt.Println("\n--- Tunnel Mappings ---")
-	for port, sub := range mapping {
-		fmt.Printf("http://localhost:%d  ->  https://%s.prod.bd\n", port, sub)
+		for port, sub := range mapping {
+			tunnel.SetLogName(port, tunnelName(port, labels, sub))
+		}
+		if *logFilterFlag != "" {
+			logPorts, err := filterPorts(splitList(*logFilterFlag), mapping, labels)
+			if err != nil {
+				log.Fatalf("-log-filter: %v", err)
+			}
+			tunnel.SetLogFilter(logPorts)
+		}
+
+		eventsPlugin.Registered(mapping, labels)
+		healthPlugin.SetTunnels(mapping)
+		webhooksPlugin.Registered(mapping)
+		if *exportEnvFlag != "" {
+			if err := writeExportEnv(*exportEnvFlag, urlTemplate, mapping, labels); err != nil {
+				log.Fatalf("Failed to write -export-env file: %v", err)
+			}
+		}
+
+		// 3. Print Mappings (as log entries in container mode, so output stays JSON)
+		out := io.Writer(os.Stdout)
+		if *containerFlag {
+			out = log.Writer()
+		} else if *groupFlag != "" {
+			fmt.Printf("\n--- Tunnel Mappings (group %s) ---\n", *groupFlag)
+		} else {
+			fmt.Println("\n--- Tunnel Mappings ---")
+		}
+		for port, sub := range mapping {
+			name := ""
+			if l := labels[port]; l != "" {
+				name = l + ": "
+			}
+			if slices.Contains(tcpPorts, port) {
+				fmt.Fprintf(out, "%stcp://localhost:%d  ->  %s (tcp; connect with: prod connect %s)\n", name, port, config.PublicURL(sub), config.PublicURL(sub))
+				continue
+			}
+			fmt.Fprintf(out, "%s%s  ->  %s\n", name, localURL(port, targets), config.PublicURL(sub))
+			for _, r := range proxy.Routes(port) {
+				fmt.Fprintf(out, "%s  %s  ->  %s%s\n", strings.Repeat(" ", len(name)), localURL(r.Port, targets), strings.TrimSuffix(config.PublicURL(sub), "/"), r.Prefix)
+			}
+		}
+		if !*containerFlag {
+			fmt.Println("-----------------------")
+		}
+		return mapping
 	}
-	fmt.Println("-----------------------")
+	mapping := register()
 
 	// 4. Graceful shutdown setup
 	done := make(chan struct{})
@@ -86,20 +491,270 @@ func main() {
 
 	go func() {
 		sig := <-sigCh
-		log.Printf("Received %v, shutting down...", sig)
+		log.Printf("Received %v, draining in-flight requests (up to %s)...", sig, *drainFlag)
 		close(done)
+		// A second signal skips the drain
+		<-sigCh
+		log.Println("Forced shutdown")
+		os.Exit(1)
 	}()
 
-	// 5. Start Tunnels
-	var wg sync.WaitGroup
-	for port, sub := range mapping {
-		wg.Add(1)
-		go func(p int, s string) {
-			defer wg.Done()
-			tunnel.StartTunnel(s, p, workerURL, pipeline, done)
-		}(port, sub)
+	// Hot reload re-sends the worker config under the same subdomains
+	reload.resend = func() error {
+		if localtunnel {
+			return errors.New("worker config isn't available with -provider localtunnel")
+		}
+		workerURLs, groups := portsByWorker(workers)
+		for _, workerURL := range workerURLs {
+			if _, err := tunnel.Register(clientID, groups[workerURL], subdomains, tcpPorts, labels, workerURL, reload.workerConfig()); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
+	go reload.watch(done)
 
-	wg.Wait()
+	// 5. Start Tunnels, for -ttl at a time if set
+	backoff := tunnel.Backoff{Initial: tunnel.DefaultBackoff.Initial, Max: *retryMaxFlag}
+	for {
+		var session <-chan struct{} = done
+		if *ttlFlag > 0 {
+			session = expireAfter(*ttlFlag, *ttlWarningFlag, *ttlRenewFlag, pipeline, mapping, done)
+		}
+		var wg sync.WaitGroup
+		for port, sub := range mapping {
+			wg.Add(1)
+			go func(p int, s string) {
+				defer wg.Done()
+				if !gate.wait(p, depends[p], labels, session) {
+					return
+				}
+				if localtunnel {
+					tunnel.StartLocaltunnel(s, p, *hostFlag, pipeline, backoff, *drainFlag, session)
+					return
+				}
+				tunnel.StartTunnel(s, p, workers[p], pipeline, backoff, *drainFlag, session)
+			}(port, sub)
+		}
+		wg.Wait()
+
+		select {
+		case <-done:
+		default:
+			if *ttlRenewFlag {
+				mapping = register()
+				continue
+			}
+		}
+		break
+	}
+	tuiPlugin.Stop()
+	names := map[string]string{}
+	for port, sub := range mapping {
+		names[sub] = tunnelName(port, labels, sub)
+	}
+	printUsage(log.Writer(), names, *costPerGBFlag)
+	statsPlugin.Close()
+	eventsPlugin.Close()
+	healthPlugin.Close()
+	webhooksPlugin.Close()
 	log.Println("All tunnels closed. Goodbye!")
 }
+
+// localURL is how a tunnel's local side is shown in the mappings.
+func localURL(port int, targets map[int]*url.URL) string {
+	if t, ok := targets[port]; ok {
+		if t.Scheme == "unix" {
+			return "unix:" + t.Path
+		}
+		return t.String()
+	}
+	return fmt.Sprintf("http://localhost:%d", port)
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// scopePlugins applies -plugin-ports: plugin=port pairs limiting each listed
+// plugin to the tunnels of those ports.
+func scopePlugins(p *hooks.Pipeline, spec string, ports []int) error {
+	scopes := map[string][]int{}
+	for _, item := range splitList(spec) {
+		name, portStr, ok := strings.Cut(item, "=")
+		port, err := strconv.Atoi(portStr)
+		if !ok || err != nil {
+			return fmt.Errorf("-plugin-ports: %q is not plugin=port", item)
+		}
+		if !slices.Contains(ports, port) {
+			return fmt.Errorf("-plugin-ports %s: %d is not one of the tunnel's ports", item, port)
+		}
+		scopes[name] = append(scopes[name], port)
+	}
+	for _, name := range slices.Sorted(maps.Keys(scopes)) {
+		if err := p.ScopePlugin(name, scopes[name]); err != nil {
+			return fmt.Errorf("-plugin-ports: %w", err)
+		}
+	}
+	return nil
+}
+
+// presetNames lists registered presets for the -mode help text.
+func presetNames(p *hooks.Pipeline) string {
+	var names []string
+	for _, ps := range p.Presets() {
+		names = append(names, ps.Name+" ("+ps.Description+")")
+	}
+	return strings.Join(names, "; ")
+}
+
+// portArgs is the parsed form of the port arguments.
+type portArgs struct {
+	ports      []int
+	subdomains map[int]string   // port -> requested subdomain
+	targets    map[int]*url.URL // port -> explicit local target
+	labels     map[int]string   // port -> display label
+}
+
+// add merges o into a.
+func (a *portArgs) add(o portArgs) {
+	a.ports = append(a.ports, o.ports...)
+	maps.Copy(a.subdomains, o.subdomains)
+	maps.Copy(a.targets, o.targets)
+	maps.Copy(a.labels, o.labels)
+}
+
+// parsePorts parses "<port>", "<port>:<subdomain>" and target URL arguments
+// (e.g. https://localhost:8443), each optionally followed by "=<label>".
+// URL targets are registered under their port; their label goes right
+// after the host and port, since an "=" in the path or query is the URL's.
+func parsePorts(args []string) (portArgs, error) {
+	a := portArgs{
+		ports:      make([]int, 0, len(args)),
+		subdomains: make(map[int]string),
+		targets:    make(map[int]*url.URL),
+		labels:     make(map[int]string),
+	}
+	for _, arg := range args {
+		arg = strings.TrimSpace(arg)
+		spec, label, hasLabel := cutLabel(arg)
+		if hasLabel && label == "" {
+			return portArgs{}, fmt.Errorf("missing label in %q", arg)
+		}
+		arg = spec
+
+		var port int
+		if strings.Contains(arg, "://") {
+			target, err := proxy.ParseTarget(arg)
+			if err != nil {
+				return portArgs{}, err
+			}
+			port = proxy.TargetPort(target)
+			if _, dup := a.targets[port]; dup || slices.Contains(a.ports, port) {
+				return portArgs{}, fmt.Errorf("port %d is used by more than one target", port)
+			}
+			a.targets[port] = target
+		} else {
+			portStr, sub, hasSub := strings.Cut(arg, ":")
+			var err error
+			port, err = strconv.Atoi(portStr)
+			if err != nil {
+				return portArgs{}, fmt.Errorf("invalid port: %s", arg)
+			}
+			if hasSub {
+				if sub == "" {
+					return portArgs{}, fmt.Errorf("missing subdomain in %q", arg)
+				}
+				a.subdomains[port] = strings.ToLower(sub)
+			}
+		}
+		if label != "" {
+			a.labels[port] = label
+		}
+		a.ports = append(a.ports, port)
+	}
+	return a, nil
+}
+
+// parseTCPPorts parses -tcp like parsePorts. A local port is tunneled
+// either as HTTP or as raw TCP, so ports already in httpPorts are refused.
+func parseTCPPorts(spec string, httpPorts []int) (portArgs, error) {
+	tcp, err := parsePorts(strings.Split(spec, ","))
+	if err != nil {
+		return portArgs{}, err
+	}
+	for _, port := range tcp.ports {
+		if slices.Contains(httpPorts, port) {
+			return portArgs{}, fmt.Errorf("port %d is given both as an HTTP port and in -tcp", port)
+		}
+	}
+	return tcp, nil
+}
+
+// cutLabel splits a port argument at the "=" before its label. In a URL
+// target, only an "=" up to the end of the host and port counts.
+func cutLabel(arg string) (target, label string, ok bool) {
+	end := len(arg)
+	if _, rest, isURL := strings.Cut(arg, "://"); isURL {
+		if i := strings.IndexAny(rest, "/?#"); i >= 0 {
+			end -= len(rest) - i
+		}
+	}
+	i := strings.LastIndex(arg[:end], "=")
+	if i < 0 {
+		return arg, "", false
+	}
+	return arg[:i] + arg[end:], strings.TrimSpace(arg[i+1 : end]), true
+}
+
+// parseUnixSockets parses "<socket path>[=<subdomain>]" arguments. The worker
+// keys tunnels by port, so each socket is registered under a port derived
+// from its absolute path (stable across runs, so it keeps its subdomain)
+// that doesn't collide with taken.
+func parseUnixSockets(args []string, taken []int) (portArgs, error) {
+	a := portArgs{
+		subdomains: make(map[int]string),
+		targets:    make(map[int]*url.URL),
+		labels:     make(map[int]string),
+	}
+	for _, arg := range args {
+		sock, sub, hasSub := strings.Cut(arg, "=")
+		abs, err := filepath.Abs(sock)
+		if err != nil {
+			return portArgs{}, fmt.Errorf("invalid socket path %q: %w", sock, err)
+		}
+		if hasSub && sub == "" {
+			return portArgs{}, fmt.Errorf("missing subdomain in %q", arg)
+		}
+		for _, t := range a.targets {
+			if t.Path == abs {
+				return portArgs{}, fmt.Errorf("socket %s is listed more than once", abs)
+			}
+		}
+		h := fnv.New32a()
+		h.Write([]byte(abs))
+		port := unixPortBase + int(h.Sum32()%unixPortRange)
+		for slices.Contains(taken, port) || slices.Contains(a.ports, port) {
+			port = unixPortBase + (port-unixPortBase+1)%unixPortRange
+		}
+		a.targets[port] = proxy.UnixTarget(abs)
+		if hasSub {
+			a.subdomains[port] = strings.ToLower(sub)
+		}
+		a.ports = append(a.ports, port)
+	}
+	return a, nil
+}
+
+// Unix socket tunnels are registered under ports in the dynamic range.
+const (
+	unixPortBase  = 49152
+	unixPortRange = 65536 - unixPortBase
+)
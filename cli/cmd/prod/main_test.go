@@ -0,0 +1,51 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParsePorts(t *testing.T) {
+	tests := []struct {
+		arg    string
+		port   int
+		target string
+		label  string
+	}{
+		{"3000", 3000, "", ""},
+		{"3000=web", 3000, "", "web"},
+		{"https://localhost:8443=api", 8443, "https://localhost:8443", "api"},
+		{"http://localhost:3000/?a=b", 3000, "http://localhost:3000", ""},
+		{"http://localhost:3000=web/?a=b", 3000, "http://localhost:3000", "web"},
+	}
+	for _, tt := range tests {
+		a, err := parsePorts([]string{tt.arg})
+		if err != nil {
+			t.Errorf("%s: %v", tt.arg, err)
+			continue
+		}
+		var target string
+		if u := a.targets[tt.port]; u != nil {
+			target = u.String()
+		}
+		if !slices.Equal(a.ports, []int{tt.port}) || target != tt.target || a.labels[tt.port] != tt.label {
+			t.Errorf("%s: ports %v, target %q, label %q", tt.arg, a.ports, target, a.labels[tt.port])
+		}
+	}
+
+	for _, bad := range []string{"3000=", "http://localhost:3000=/x"} {
+		if _, err := parsePorts([]string{bad}); err == nil {
+			t.Errorf("%s accepted", bad)
+		}
+	}
+}
+
+func TestParseTCPPortsRejectsHTTPPorts(t *testing.T) {
+	tcp, err := parseTCPPorts("5432,6379:cache", []int{3000})
+	if err != nil || !slices.Equal(tcp.ports, []int{5432, 6379}) {
+		t.Fatalf("ports %v, err %v", tcp.ports, err)
+	}
+	if _, err := parseTCPPorts("5432,3000", []int{3000}); err == nil {
+		t.Fatal("port 3000 accepted both as HTTP and in -tcp")
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/plugins/stats"
+)
+
+// runObserve implements `prod observe <share-link>`: it serves someone else's
+// tunnel dashboard, read-only, on localhost. The share link is what
+// -observer-token prints; the token never leaves the proxy, so the local
+// page can be opened without it.
+func runObserve(args []string) {
+	fs := flag.NewFlagSet("observe", flag.ExitOnError)
+	port := fs.Int("port", 4041, "Local port to serve the observer dashboard on")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s observe [flags] <share-link>\n\nFlags:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := hooks.BindEnv(fs); err != nil {
+		log.Fatal(err)
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	link, err := url.Parse(fs.Arg(0))
+	if err != nil || (link.Scheme != "http" && link.Scheme != "https") || link.Host == "" {
+		log.Fatalf("Invalid share link %q", fs.Arg(0))
+	}
+	token := link.Query().Get("token")
+	if token == "" {
+		log.Fatalf("Share link has no ?token=")
+	}
+
+	remote := &url.URL{Scheme: link.Scheme, Host: link.Host}
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(remote)
+			r.Out.Host = remote.Host
+			r.Out.Header.Set("Authorization", "Bearer "+token)
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, stats.ObserverPath) {
+			http.Redirect(w, r, stats.ObserverPath, http.StatusFound)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "observers are read-only", http.StatusForbidden)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	})
+
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(*port))
+	log.Printf("Observing %s (read-only) at http://%s", remote, addr)
+	log.Fatal(http.ListenAndServe(addr, mux))
+}
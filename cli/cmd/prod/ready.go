@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+)
+
+// runReady implements `prod ready`: it exits 0 if /readyz on the health
+// address reports ready, else 1. The image has no shell or curl, so this is
+// what Compose and Docker health checks run.
+func runReady(args []string) {
+	fs := flag.NewFlagSet("ready", flag.ExitOnError)
+	addr := fs.String("healthz-addr", defaultHealthzAddr, "Address the tunnel process serves /readyz on")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s ready [flags]\n\nFlags:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := hooks.BindEnv(fs); err != nil {
+		log.Fatal(err)
+	}
+
+	host, port, err := net.SplitHostPort(*addr)
+	if err != nil {
+		log.Fatalf("Invalid -healthz-addr: %v", err)
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	client := &http.Client{Timeout: 3 * time.Second}
+	resp, err := client.Get("http://" + net.JoinHostPort(host, port) + "/readyz")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "not ready:", err)
+		os.Exit(1)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintln(os.Stderr, "not ready:", resp.Status)
+		os.Exit(1)
+	}
+	fmt.Println("ready")
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/config"
+	"github.com/QuadTriangle/prod.bd/cli/internal/tunnel"
+)
+
+// planRegions decides which worker each port registers with. spec is the
+// -region value: a region name or "auto" for all ports, and/or port=region
+// pairs to force single tunnels, comma-separated. Regions come from the
+// config file. With an empty spec every port uses the default worker URL
+// and regions is nil.
+func planRegions(spec string, ports []int) (workers, regions map[int]string, err error) {
+	workers = make(map[int]string, len(ports))
+	if spec == "" {
+		for _, port := range ports {
+			workers[port] = config.GetWorkerURL()
+		}
+		return workers, nil, nil
+	}
+
+	file, err := config.LoadFile()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(file.Regions) == 0 {
+		return nil, nil, fmt.Errorf("-region needs regions in the config file (regions: {name: worker URL})")
+	}
+
+	def, forced := "", map[int]string{}
+	for _, item := range splitList(spec) {
+		name := strings.ToLower(item)
+		if portStr, region, ok := strings.Cut(item, "="); ok {
+			port, err := strconv.Atoi(portStr)
+			if err != nil || !slices.Contains(ports, port) {
+				return nil, nil, fmt.Errorf("-region %s: %s is not one of the tunnel's ports", item, portStr)
+			}
+			name = strings.ToLower(region)
+			forced[port] = name
+		} else {
+			def = name
+		}
+		if _, ok := file.Regions[name]; !ok && name != "auto" {
+			return nil, nil, fmt.Errorf("unknown region %q (configured: %s)", name, strings.Join(slices.Sorted(maps.Keys(file.Regions)), ", "))
+		}
+	}
+
+	// Measure only if some port asked for the nearest region
+	auto := ""
+	if def == "auto" || slices.Contains(slices.Collect(maps.Values(forced)), "auto") {
+		for _, r := range tunnel.RankRegions(file.Regions) {
+			if r.Err != nil {
+				log.Printf("Region %s (%s): unreachable: %v", r.Name, r.WorkerURL, r.Err)
+				continue
+			}
+			log.Printf("Region %s (%s): %s", r.Name, r.WorkerURL, r.RTT.Round(time.Millisecond))
+			if auto == "" {
+				auto = r.Name
+			}
+		}
+		if auto == "" {
+			return nil, nil, fmt.Errorf("no region is reachable")
+		}
+		log.Printf("Using region %s (lowest latency)", auto)
+	}
+
+	regions = make(map[int]string, len(ports))
+	for _, port := range ports {
+		name, ok := forced[port]
+		if !ok {
+			name = def
+		}
+		if name == "auto" {
+			name = auto
+		}
+		if name == "" {
+			workers[port] = config.GetWorkerURL()
+			continue
+		}
+		workers[port], regions[port] = file.Regions[name], name
+	}
+	return workers, regions, nil
+}
+
+// portsByWorker groups ports by the worker they register with, in a stable
+// order.
+func portsByWorker(workers map[int]string) (urls []string, groups map[string][]int) {
+	groups = make(map[string][]int)
+	for port, url := range workers {
+		groups[url] = append(groups[url], port)
+	}
+	for _, ports := range groups {
+		slices.Sort(ports)
+	}
+	return slices.Sorted(maps.Keys(groups)), groups
+}
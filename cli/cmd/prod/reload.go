@@ -0,0 +1,202 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/config"
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+)
+
+// configPollInterval is how often the config file is checked for changes.
+const configPollInterval = 2 * time.Second
+
+// reloader applies config changes to running tunnels, on SIGHUP and, under
+// prod up, when ~/.prod/config.yaml changes. Flags of reloadable plugins
+// (auth, allowlists, header policy, rate limits) are updated in place and
+// the worker config re-sent, so tunnels stay connected; other changes are
+// logged as needing a restart.
+type reloader struct {
+	up       *upSource
+	pipeline *hooks.Pipeline
+	resend   func() error // re-registers the tunnels with the current worker config
+
+	mu     sync.Mutex        // guards plugin flags against WorkerConfig reads
+	values map[string]string // flag values of the running config, as given
+	stale  bool              // the worker hasn't got the current config yet
+}
+
+// newReloader records the flag values args produce, to diff reloads against.
+func newReloader(up *upSource, pipeline *hooks.Pipeline, args []string) (*reloader, error) {
+	r := &reloader{up: up, pipeline: pipeline}
+	values, err := r.flagValues(args)
+	if err != nil {
+		return nil, err
+	}
+	r.values = values
+	return r, nil
+}
+
+// workerConfig returns the pipeline's WorkerConfig, safe against a
+// concurrent reload.
+func (r *reloader) workerConfig() map[string]any {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.pipeline.WorkerConfig()
+}
+
+// watch reloads on SIGHUP and, under prod up, on config file changes, until
+// done closes.
+func (r *reloader) watch(done <-chan struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var poll <-chan time.Time
+	var path string
+	var modTime time.Time
+	if r.up != nil {
+		if p, err := config.FilePath(); err == nil {
+			path = p
+			modTime = fileModTime(path)
+			ticker := time.NewTicker(configPollInterval)
+			defer ticker.Stop()
+			poll = ticker.C
+		}
+	}
+	for {
+		select {
+		case <-done:
+			return
+		case <-hup:
+			log.Println("Received SIGHUP, reloading config...")
+			r.reload()
+		case <-poll:
+			if t := fileModTime(path); !t.Equal(modTime) {
+				modTime = t
+				log.Printf("%s changed, reloading...", path)
+				r.reload()
+			}
+		}
+	}
+}
+
+func fileModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// reload reads the profile or group again and applies what changed. It
+// only runs on the watch goroutine.
+func (r *reloader) reload() {
+	if r.up == nil {
+		log.Println("Nothing to reload: only tunnels started with prod up read their flags from the config file")
+		return
+	}
+	args, _, err := upArgs(r.up.name, r.up.overrides)
+	if err != nil {
+		log.Printf("Reload failed: %v", err)
+		return
+	}
+	values, err := r.flagValues(args)
+	if err != nil {
+		log.Printf("Reload failed: %v", err)
+		return
+	}
+
+	r.mu.Lock()
+	changed := map[string]string{}
+	for name, v := range values {
+		if r.values[name] != v {
+			changed[name] = v
+		}
+	}
+	if len(changed) == 0 && !r.stale {
+		r.mu.Unlock()
+		log.Println("Reload: no flag changes")
+		return
+	}
+	before := r.pipeline.WorkerConfig()
+	restart, err := r.pipeline.Reload(flag.CommandLine, changed)
+	after := r.pipeline.WorkerConfig()
+	var applied []string
+	for name, v := range changed {
+		if !slices.Contains(restart, name) {
+			r.values[name] = v
+			applied = append(applied, "-"+name)
+		}
+	}
+	r.mu.Unlock()
+
+	if err != nil {
+		log.Printf("Reload: %v", err)
+	}
+	if len(restart) > 0 {
+		log.Printf("Reload: restart to apply -%s", strings.Join(restart, ", -"))
+	}
+	if r.stale || !reflect.DeepEqual(before, after) {
+		if err := r.resend(); err != nil {
+			r.stale = true
+			log.Printf("Reload: failed to send the new config to the worker, will retry on the next reload: %v", err)
+			return
+		}
+		r.stale = false
+	}
+	if len(applied) == 0 {
+		return
+	}
+	slices.Sort(applied)
+	log.Printf("Reloaded %s", strings.Join(applied, ", "))
+}
+
+// flagValues parses args into a scratch copy of the command line's flags,
+// with env and presets applied as runTunnels does, and returns every flag's
+// value as given (defaults for flags not set).
+func (r *reloader) flagValues(args []string) (map[string]string, error) {
+	fs := flag.NewFlagSet("reload", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		bf, isBool := f.Value.(interface{ IsBoolFlag() bool })
+		fs.Var(&rawValue{s: f.DefValue, isBool: isBool && bf.IsBoolFlag()}, f.Name, f.Usage)
+	})
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if err := hooks.BindEnv(fs); err != nil {
+		return nil, err
+	}
+	preset := fs.Lookup("preset").Value.String()
+	if preset == "" {
+		preset = fs.Lookup("mode").Value.String()
+	}
+	if preset != "" {
+		if err := r.pipeline.ApplyPreset(fs, preset); err != nil {
+			return nil, err
+		}
+	}
+	values := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) { values[f.Name] = f.Value.String() })
+	return values, nil
+}
+
+// rawValue holds a flag's value as the string it was given.
+type rawValue struct {
+	s      string
+	isBool bool
+}
+
+func (v *rawValue) String() string     { return v.s }
+func (v *rawValue) Set(s string) error { v.s = s; return nil }
+func (v *rawValue) IsBoolFlag() bool   { return v.isBool }
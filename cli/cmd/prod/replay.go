@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/scenario"
+)
+
+// runReplay implements `prod replay <scenario.yaml> <port|url>`: virtual
+// users run a scenario (e.g. a captured session from the dashboard's
+// /api/v1/stats/scenario) against a local server.
+func runReplay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	users := fs.Int("users", 1, "Virtual users running the scenario at once, each with its own cookies")
+	iterations := fs.Int("iterations", 1, "Scenario runs per user (0 to repeat until -duration is up)")
+	duration := fs.Duration("duration", 0, "Stop after this long (0 to stop after -iterations)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s replay [flags] <scenario.yaml> <port|url>\n\nExport a captured session with:\n  curl 'http://localhost:9999/api/v1/stats/scenario?subdomain=abc&since=...' > scenario.yaml\n\nFlags:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := hooks.BindEnv(fs); err != nil {
+		log.Fatal(err)
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *users < 1 {
+		log.Fatal("-users must be at least 1")
+	}
+	if *iterations == 0 && *duration <= 0 {
+		log.Fatal("-iterations 0 repeats until -duration is up, so it needs a -duration")
+	}
+
+	file, err := scenario.Load(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	baseURL := fs.Arg(1)
+	if port, err := strconv.Atoi(baseURL); err == nil {
+		baseURL = fmt.Sprintf("http://localhost:%d", port)
+	} else if !strings.Contains(baseURL, "://") {
+		log.Fatalf("%s is neither a port nor a URL", baseURL)
+	}
+
+	// Ctrl-C ends the run early but still prints the report
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("Replaying %s (%d steps) against %s with %d user(s)...", fs.Arg(0), len(file.Steps), baseURL, *users)
+	report := scenario.Run(ctx, file, scenario.Config{
+		BaseURL:    baseURL,
+		Users:      *users,
+		Iterations: *iterations,
+		Duration:   *duration,
+	})
+
+	fmt.Printf("\n%-40s %8s %7s %9s %9s\n", "STEP", "REQS", "ERRORS", "P50", "P99")
+	failed := false
+	for _, s := range report.Steps {
+		fmt.Printf("%-40s %8d %7d %9s %9s\n", truncate(s.Name, 40), s.Requests, s.Errors,
+			s.P50.Round(time.Millisecond), s.P99.Round(time.Millisecond))
+		if s.Errors > 0 {
+			failed = true
+			fmt.Printf("  last error: %s\n", s.LastErr)
+		}
+	}
+	fmt.Printf("\n%d scenario runs completed, %d failed in %s (%.1f runs/s)\n",
+		report.Iterations, report.Failed, report.Elapsed.Round(time.Millisecond),
+		float64(report.Iterations)/max(report.Elapsed.Seconds(), 0.001))
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-1] + "…"
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/proxy"
+)
+
+// applyRoutes applies -route: comma-separated prefix=port rules for every
+// tunnel, or tunnel:prefix=port rules for the tunnel on one port (e.g.
+// /api=8080 or 3000:/api=8080,3000:/ws=8081).
+func applyRoutes(spec string, ports []int) error {
+	tables := map[int][]proxy.Route{}
+	for _, item := range splitList(spec) {
+		rule, portStr, ok := strings.Cut(item, "=")
+		if !ok {
+			return fmt.Errorf("-route %s: want prefix=port", item)
+		}
+		tunnels := ports
+		if tunnelStr, prefix, ok := strings.Cut(rule, ":"); ok && !strings.HasPrefix(rule, "/") {
+			tunnel, err := strconv.Atoi(tunnelStr)
+			if err != nil || !slices.Contains(ports, tunnel) {
+				return fmt.Errorf("-route %s: %s is not one of the tunnel's ports", item, tunnelStr)
+			}
+			tunnels, rule = []int{tunnel}, prefix
+		}
+		if !strings.HasPrefix(rule, "/") {
+			return fmt.Errorf("-route %s: the path prefix must start with /", item)
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("-route %s: invalid port %q", item, portStr)
+		}
+		for _, t := range tunnels {
+			tables[t] = append(tables[t], proxy.Route{Prefix: rule, Port: port})
+		}
+	}
+	for port, rs := range tables {
+		proxy.SetRoutes(port, rs)
+	}
+	return nil
+}
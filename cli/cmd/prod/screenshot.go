@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/config"
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/screenshot"
+)
+
+// runScreenshot implements `prod screenshot <subdomain> [path]`.
+func runScreenshot(args []string) {
+	fs := flag.NewFlagSet("screenshot", flag.ExitOnError)
+	out := fs.String("o", "", "Output PNG file (default <subdomain>-<timestamp>.png)")
+	width := fs.Int("width", 1280, "Viewport width in pixels")
+	timeout := fs.Duration("timeout", 60*time.Second, "Maximum time to wait for the page")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s screenshot [flags] <subdomain> [path]\n\nFlags:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := hooks.BindEnv(fs); err != nil {
+		log.Fatal(err)
+	}
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	subdomain := fs.Arg(0)
+	target := config.PublicURL(subdomain)
+	if fs.NArg() == 2 {
+		target += "/" + strings.TrimPrefix(fs.Arg(1), "/")
+	}
+
+	file := *out
+	if file == "" {
+		file = fmt.Sprintf("%s-%d.png", subdomain, time.Now().Unix())
+	}
+
+	log.Printf("Capturing %s...", target)
+	png, err := screenshot.Capture(target, screenshot.Options{Width: *width, Timeout: *timeout})
+	if err != nil {
+		log.Fatalf("Screenshot failed: %v", err)
+	}
+	if err := os.WriteFile(file, png, 0644); err != nil {
+		log.Fatalf("Failed to write screenshot: %v", err)
+	}
+	fmt.Printf("Screenshot saved to %s\n", file)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// runServe implements `prod serve [flags] <dir> [tunnel flags]`: it serves
+// dir from a built-in static file server on a free local port and tunnels
+// that port, so sharing a build doesn't need a separate server.
+func runServe(args []string) {
+	fset := flag.NewFlagSet("serve", flag.ExitOnError)
+	spa := fset.Bool("spa", false, "Serve index.html for paths that don't match a file (single-page apps with client-side routing)")
+	listing := fset.Bool("listing", false, "List the files of directories without an index.html")
+	fset.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s serve [flags] <dir> [tunnel flags]\n\nTunnel flags (e.g. -subdomain, -auth) go after the directory.\n\nFlags:\n", os.Args[0])
+		fset.PrintDefaults()
+	}
+	fset.Parse(args)
+	if fset.NArg() < 1 {
+		fset.Usage()
+		os.Exit(1)
+	}
+	dir := fset.Arg(0)
+	if info, err := os.Stat(dir); err != nil {
+		log.Fatal(err)
+	} else if !info.IsDir() {
+		log.Fatalf("%s is not a directory", dir)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatalf("Failed to start file server: %v", err)
+	}
+	handler := &fileServer{root: os.DirFS(dir), spa: *spa, listing: *listing}
+	go func() {
+		if err := http.Serve(ln, handler); err != nil {
+			log.Printf("File server error: %v", err)
+		}
+	}()
+
+	abs, _ := filepath.Abs(dir)
+	label := filepath.Base(abs)
+	log.Printf("Serving %s on http://%s", abs, ln.Addr())
+	tunnelArgs := append(fset.Args()[1:], fmt.Sprintf("http://%s=%s", ln.Addr(), label))
+	runTunnels(tunnelArgs, nil)
+}
+
+// fileServer serves static files from root. Dotfiles (.env, .git, ...) are
+// never served, since build directories sometimes pick them up.
+type fileServer struct {
+	root    fs.FS
+	spa     bool
+	listing bool
+}
+
+func (s *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + r.URL.Path)
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	rel := strings.TrimPrefix(name, "/")
+	if rel == "" {
+		rel = "."
+	}
+	info, err := fs.Stat(s.root, rel)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		if s.spa && (r.Method == http.MethodGet || r.Method == http.MethodHead) && path.Ext(name) == "" {
+			s.serveIndex(w, r)
+			return
+		}
+		http.NotFound(w, r)
+		return
+	case err != nil:
+		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+		return
+	case info.IsDir() && !s.listing:
+		if _, err := fs.Stat(s.root, path.Join(rel, "index.html")); err != nil {
+			if s.spa {
+				s.serveIndex(w, r)
+				return
+			}
+			http.NotFound(w, r)
+			return
+		}
+	}
+	http.FileServerFS(s.root).ServeHTTP(w, r)
+}
+
+// serveIndex answers with the root index.html, for -spa client-side routes.
+func (s *fileServer) serveIndex(w http.ResponseWriter, r *http.Request) {
+	data, err := fs.ReadFile(s.root, "index.html")
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(data)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/config"
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/soak"
+	"github.com/QuadTriangle/prod.bd/cli/statsapi"
+)
+
+// runSoak implements `prod soak <subdomain> [path]`.
+func runSoak(args []string) {
+	fs := flag.NewFlagSet("soak", flag.ExitOnError)
+	rps := fs.Int("rps", 10, "Requests per second")
+	duration := fs.Duration("duration", 10*time.Minute, "How long to run")
+	window := fs.Duration("window", time.Minute, "Sampling interval for the report")
+	dashboardPort := fs.Int("dashboard-port", 9999, "Dashboard port of the running tunnel, for memory/goroutine sampling (0 to skip)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s soak [flags] <subdomain> [path]\n\nFlags:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := hooks.BindEnv(fs); err != nil {
+		log.Fatal(err)
+	}
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	subdomain := fs.Arg(0)
+	path := "/"
+	if fs.NArg() == 2 {
+		path = "/" + strings.TrimPrefix(fs.Arg(1), "/")
+	}
+
+	cfg := soak.Config{
+		Subdomain: subdomain,
+		URL:       config.PublicURL(subdomain) + path,
+		RPS:       *rps,
+		Duration:  *duration,
+		Window:    *window,
+	}
+	if *dashboardPort > 0 {
+		cfg.RuntimeURL = fmt.Sprintf("http://127.0.0.1:%d%s/stats/runtime", *dashboardPort, statsapi.Prefix)
+	}
+
+	// Ctrl-C ends the run early but still writes the report
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("Soaking %s at %d req/s for %s...", cfg.URL, cfg.RPS, cfg.Duration)
+	fmt.Printf("%-8s %8s %7s %9s %9s %10s %9s\n", "TIME", "REQS", "ERRORS", "P50", "P99", "GOROUTINES", "HEAP")
+	report, err := soak.Run(ctx, cfg, func(s soak.Sample) {
+		fmt.Printf("%-8s %8d %7d %7.1fms %7.1fms %10d %7.1fMB\n",
+			time.Unix(s.At, 0).Format("15:04:05"), s.Requests, s.Errors, s.P50Ms, s.P99Ms, s.Goroutines, s.HeapMB)
+	})
+	if err != nil {
+		log.Fatalf("Soak failed: %v", err)
+	}
+
+	path, err = soak.Save(report)
+	if err != nil {
+		log.Fatalf("Failed to save report: %v", err)
+	}
+
+	fmt.Printf("\n--- Soak: %s ---\n", report.URL)
+	fmt.Printf("%-18s %d (%d errors)\n", "requests", report.Requests, report.Errors)
+	fmt.Printf("%-18s %+.1fms\n", "p99 latency drift", report.LatencyDriftMs)
+	fmt.Printf("%-18s %+d\n", "goroutine drift", report.GoroutineDrift)
+	fmt.Printf("%-18s %+.1fMB\n", "heap drift", report.HeapDriftMB)
+	fmt.Println("-----------------------")
+	fmt.Printf("Report saved to %s\n", path)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/config"
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/statsapi"
+)
+
+// printRequest prints one line of prod logs.
+func printRequest(r statsapi.Request) {
+	fmt.Printf("%s %s %s %s %d %.0fms\n", time.Unix(r.CreatedAt, 0).Format("15:04:05"),
+		r.Subdomain, r.Method, r.Path, r.Status, r.LatencyMs)
+}
+
+// dashboardError explains a failure to reach the running tunnel.
+func dashboardError(port int, err error) error {
+	var apiErr *statsapi.Error
+	if errors.As(err, &apiErr) {
+		return err
+	}
+	return fmt.Errorf("failed to reach the dashboard API (is a tunnel running with -dashboard-port %d?): %w", port, err)
+}
+
+// runningProcesses finds the prod processes to talk to: the one on
+// -dashboard-port if it was given, else those with a control socket (only
+// pid, if set), else the one on the default dashboard port.
+func runningProcesses(fs *flag.FlagSet, port, pid int) []statsapi.Process {
+	portSet := false
+	fs.Visit(func(f *flag.Flag) { portSet = portSet || f.Name == "dashboard-port" })
+	if !portSet {
+		procs, err := statsapi.Running()
+		if err != nil {
+			log.Printf("Failed to list running processes: %v", err)
+		}
+		if pid != 0 {
+			procs = slices.DeleteFunc(procs, func(p statsapi.Process) bool { return p.PID != pid })
+			if len(procs) == 0 {
+				log.Fatalf("No prod with pid %d is running", pid)
+			}
+		}
+		if len(procs) > 0 {
+			return procs
+		}
+	}
+	return []statsapi.Process{{Client: statsapi.New(port)}}
+}
+
+// processError explains a failure to reach p.
+func processError(p statsapi.Process, port int, err error) error {
+	if p.Socket == "" {
+		return dashboardError(port, err)
+	}
+	return fmt.Errorf("prod %d (%s): %w", p.PID, p.Socket, err)
+}
+
+// processStatus is one process in prod status -json.
+type processStatus struct {
+	PID           int               `json:"pid"`
+	UptimeSeconds int64             `json:"uptime_seconds"`
+	LowPower      bool              `json:"low_power"`
+	Tunnels       []statsapi.Tunnel `json:"tunnels"`
+}
+
+// runStatus implements `prod status`: each running prod with its uptime,
+// and its tunnels with their traffic and reconnects so far.
+func runStatus(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	dashboardPort := fs.Int("dashboard-port", 9999, "Ask the tunnel on this dashboard port instead of every running prod")
+	pid := fs.Int("pid", 0, "Only show the prod with this process ID")
+	asJSON := fs.Bool("json", false, "Print JSON instead of a table")
+	verbose := fs.Bool("v", false, "Also print each tunnel's reconnect history")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s status [flags]\n\nFlags:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := hooks.BindEnv(fs); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.Background()
+	var statuses []processStatus
+	for _, p := range runningProcesses(fs, *dashboardPort, *pid) {
+		rt, err := p.Client.Runtime(ctx)
+		if err != nil {
+			log.Fatal(processError(p, *dashboardPort, err))
+		}
+		tunnels, err := p.Client.Tunnels(ctx)
+		if err != nil {
+			log.Fatal(processError(p, *dashboardPort, err))
+		}
+		slices.SortFunc(tunnels, func(a, b statsapi.Tunnel) int { return a.Port - b.Port })
+		statuses = append(statuses, processStatus{PID: rt.PID, UptimeSeconds: rt.UptimeSeconds, LowPower: rt.LowPower, Tunnels: tunnels})
+	}
+	if *asJSON {
+		json.NewEncoder(os.Stdout).Encode(map[string]any{"processes": statuses})
+		return
+	}
+
+	for i, st := range statuses {
+		if i > 0 {
+			fmt.Println()
+		}
+		power := ""
+		if st.LowPower {
+			power = " (idle, low-power mode)"
+		}
+		fmt.Printf("prod %d, up %s%s\n", st.PID, time.Duration(st.UptimeSeconds)*time.Second, power)
+		if len(st.Tunnels) == 0 {
+			fmt.Println("No tunnels connected.")
+			continue
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "PORT\tURL\tLABEL\tREQUESTS\tERRORS\tAVG\tP99\tUP\tRECONNECTS\tWIRE")
+		for _, t := range st.Tunnels {
+			up := time.Since(time.Unix(t.ConnectedAt, 0)).Round(time.Second)
+			fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%d\t%.0fms\t%.0fms\t%s\t%d\t%s\n", t.Port, config.PublicURL(t.Subdomain), t.Label,
+				t.TotalRequests, t.ErrorCount, t.AvgLatency, t.P99Latency, up, t.Reconnects, formatWireBytes(t.WireBytesSent+t.WireBytesReceived))
+		}
+		w.Flush()
+		if !*verbose {
+			continue
+		}
+		for _, t := range st.Tunnels {
+			fmt.Printf("\n%s:\n", t.Subdomain)
+			cost := ""
+			if t.CostEstimate > 0 {
+				cost = fmt.Sprintf(" (~$%.4f)", t.CostEstimate)
+			}
+			fmt.Printf("  sent %s, received %s on the wire%s\n", formatWireBytes(t.WireBytesSent), formatWireBytes(t.WireBytesReceived), cost)
+			fmt.Printf("  latency p50 %.0fms, p90 %.0fms, p99 %.0fms\n", t.P50Latency, t.P90Latency, t.P99Latency)
+			for _, e := range t.Connections {
+				at := time.Unix(e.Time, 0).Format("2006-01-02 15:04:05")
+				switch {
+				case e.Connected:
+					fmt.Printf("  %s connected\n", at)
+				case e.Error != "":
+					fmt.Printf("  %s dropped: %s\n", at, e.Error)
+				default:
+					fmt.Printf("  %s dropped\n", at)
+				}
+			}
+			if t.ConfigUpdatedAt != 0 {
+				fmt.Printf("  %s worker updated config: %s\n", time.Unix(t.ConfigUpdatedAt, 0).Format("2006-01-02 15:04:05"),
+					strings.Join(t.WorkerConfigKeys, ", "))
+			}
+		}
+	}
+}
+
+// runLogs implements `prod logs`: the newest logged requests of the running
+// session, oldest first, and with -f new ones as they arrive.
+func runLogs(args []string) {
+	fs := flag.NewFlagSet("logs", flag.ExitOnError)
+	dashboardPort := fs.Int("dashboard-port", 9999, "Ask the tunnel on this dashboard port instead of the running prod")
+	pid := fs.Int("pid", 0, "Process ID of the prod to ask, if several are running")
+	n := fs.Int("n", 20, "Print this many of the newest requests (max 500)")
+	follow := fs.Bool("f", false, "Keep printing requests as they arrive")
+	subdomain := fs.String("subdomain", "", "Only show requests to this tunnel")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s logs [flags]\n\nFlags:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := hooks.BindEnv(fs); err != nil {
+		log.Fatal(err)
+	}
+
+	procs := runningProcesses(fs, *dashboardPort, *pid)
+	if len(procs) > 1 {
+		pids := make([]string, len(procs))
+		for i, p := range procs {
+			pids[i] = strconv.Itoa(p.PID)
+		}
+		log.Fatalf("Several prod processes are running (%s); pick one with -pid", strings.Join(pids, ", "))
+	}
+	proc := procs[0]
+	client := proc.Client
+	ctx := context.Background()
+	if *n > 0 {
+		page, err := client.Requests(ctx, statsapi.RequestQuery{Subdomain: *subdomain, Limit: *n})
+		if err != nil {
+			log.Fatal(processError(proc, *dashboardPort, err))
+		}
+		for _, r := range slices.Backward(page.Requests) {
+			printRequest(r)
+		}
+	}
+	if !*follow {
+		return
+	}
+	err := client.Stream(ctx, *subdomain, func(r statsapi.Request) bool {
+		printRequest(r)
+		return true
+	})
+	if err != nil {
+		log.Fatal(processError(proc, *dashboardPort, err))
+	}
+	log.Fatal("Stream ended: the tunnel stopped")
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/proxy"
+)
+
+// rateUnits are the suffixes accepted by parseRate, in bytes per second:
+// bits per second (decimal, as network speeds are quoted) or bytes per second.
+var rateUnits = []struct {
+	suffix string
+	mult   float64
+}{
+	{"gbps", 1e9 / 8},
+	{"mbps", 1e6 / 8},
+	{"kbps", 1e3 / 8},
+	{"bps", 1.0 / 8},
+	{"gb/s", 1 << 30},
+	{"mb/s", 1 << 20},
+	{"kb/s", 1 << 10},
+	{"b/s", 1},
+}
+
+// parseRate parses a bandwidth like 1mbps, 256kbps or 500KB/s into bytes per
+// second.
+func parseRate(s string) (int64, error) {
+	lower := strings.ToLower(strings.TrimSpace(s))
+	for _, u := range rateUnits {
+		if num, ok := strings.CutSuffix(lower, u.suffix); ok {
+			n, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+			if err != nil || n <= 0 {
+				break
+			}
+			return max(int64(n*u.mult), 1), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid rate %q (use e.g. 1mbps, 256kbps or 500KB/s)", s)
+}
+
+// perPort parses a flag of the form "value" or "port=value" pairs (e.g.
+// 1mbps,8080=256kbps): a bare value applies to every port without its own.
+func perPort(name, spec string, ports []int) (map[int]string, error) {
+	def, out := "", map[int]string{}
+	for _, item := range splitList(spec) {
+		portStr, value, ok := strings.Cut(item, "=")
+		if !ok {
+			def = item
+			continue
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil || !slices.Contains(ports, port) {
+			return nil, fmt.Errorf("-%s %s: %s is not one of the tunnel's ports", name, item, portStr)
+		}
+		out[port] = value
+	}
+	if def != "" {
+		for _, port := range ports {
+			if _, ok := out[port]; !ok {
+				out[port] = def
+			}
+		}
+	}
+	return out, nil
+}
+
+// applyThrottle applies -throttle and -latency to the tunnels' ports.
+func applyThrottle(throttleSpec, latencySpec string, ports []int) error {
+	rates, err := perPort("throttle", throttleSpec, ports)
+	if err != nil {
+		return err
+	}
+	latencies, err := perPort("latency", latencySpec, ports)
+	if err != nil {
+		return err
+	}
+	for _, port := range ports {
+		var rate int64
+		if s, ok := rates[port]; ok {
+			if rate, err = parseRate(s); err != nil {
+				return fmt.Errorf("-throttle: %w", err)
+			}
+		}
+		var latency time.Duration
+		if s, ok := latencies[port]; ok {
+			if latency, err = time.ParseDuration(s); err != nil || latency < 0 {
+				return fmt.Errorf("-latency: invalid duration %q (use e.g. 200ms)", s)
+			}
+		}
+		proxy.SetThrottle(port, rate, latency)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+)
+
+// expireAfter returns a channel that closes once ttl has passed (-ttl) or
+// done closes, to end a tunnel session. warning before the end, the
+// connection hooks of each tunnel in mapping are told (hooks.ExpiryHook).
+func expireAfter(ttl, warning time.Duration, renew bool, p *hooks.Pipeline, mapping map[int]string, done <-chan struct{}) <-chan struct{} {
+	session := make(chan struct{})
+	end := time.Now().Add(ttl)
+	go func() {
+		defer close(session)
+		if warning > 0 {
+			select {
+			case <-done:
+				return
+			case <-time.After(ttl - min(warning, ttl)):
+			}
+			remaining := time.Until(end).Round(time.Second)
+			if renew {
+				log.Printf("Tunnels reach -ttl in %s and will be registered again; their URLs may change", remaining)
+			} else {
+				log.Printf("Tunnels reach -ttl in %s and will be closed", remaining)
+			}
+			for port, sub := range mapping {
+				p.ForTunnel(sub, port).NotifyExpiring(remaining, renew)
+			}
+		}
+		select {
+		case <-done:
+		case <-time.After(time.Until(end)):
+			log.Printf("Tunnels reached -ttl %s, closing", ttl)
+		}
+	}()
+	return session
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/config"
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+)
+
+// runUp implements `prod up <profile|group> [flags]`, starting the tunnels
+// described by a profile or group in ~/.prod/config.yaml. Extra flags
+// override the profile's. Given flags or ports instead of a name, it is
+// plain `prod [flags] <port>...`.
+func runUp(args []string) {
+	if len(args) < 1 || args[0] == "-h" || args[0] == "-help" {
+		fmt.Fprintf(os.Stderr, "Usage: %s up <profile|group> [flags]\n       %s up [flags] <port[:subdomain]|url>...\n\nProfiles and groups are read from ~/.prod/config.yaml.\n", os.Args[0], os.Args[0])
+		os.Exit(1)
+	}
+	if isTunnelArg(args[0]) {
+		runTunnels(args, nil)
+		return
+	}
+
+	tunnelArgs, kind, err := upArgs(args[0], args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Starting %s %q...", kind, args[0])
+	runTunnels(tunnelArgs, &upSource{name: args[0], overrides: args[1:]})
+}
+
+// isTunnelArg reports whether arg is a flag, port or URL rather than a
+// profile or group name.
+func isTunnelArg(arg string) bool {
+	if strings.HasPrefix(arg, "-") || strings.Contains(arg, "://") {
+		return true
+	}
+	port, _, _ := strings.Cut(arg, ":")
+	_, err := strconv.Atoi(port)
+	return err == nil
+}
+
+// upSource is the profile or group tunnels were started from with prod up,
+// which a reload reads again.
+type upSource struct {
+	name      string
+	overrides []string // flags given after the name, which win over the file
+}
+
+// upArgs reads the profile or group name from the config file and renders
+// it as runTunnels arguments: its flags, then overrides (last value wins),
+// then its ports. Flags whose env var is set are dropped, since env wins
+// over them. kind is "profile" or "group".
+func upArgs(name string, overrides []string) (tunnelArgs []string, kind string, err error) {
+	file, err := config.LoadFile()
+	if err != nil {
+		return nil, "", err
+	}
+	var flagArgs, ports []string
+	kind = "profile"
+	if _, isGroup := file.Groups[name]; isGroup {
+		if _, ok := file.Profiles[name]; ok {
+			return nil, "", fmt.Errorf("%q is both a profile and a group in the config file; rename one", name)
+		}
+		group, err := file.Group(name)
+		if err != nil {
+			return nil, "", err
+		}
+		flagArgs, ports = group.Args(name)
+		kind = "group"
+	} else {
+		profile, err := file.Profile(name)
+		if err != nil {
+			return nil, "", err
+		}
+		flagArgs, ports = profile.FlagArgs(), profile.Ports
+	}
+	if file.WorkerURL != "" && os.Getenv("WORKER_URL") == "" {
+		os.Setenv("WORKER_URL", file.WorkerURL)
+	}
+
+	for _, arg := range flagArgs {
+		name, _, _ := strings.Cut(strings.TrimPrefix(arg, "-"), "=")
+		if _, ok := os.LookupEnv(hooks.EnvName(name)); !ok {
+			tunnelArgs = append(tunnelArgs, arg)
+		}
+	}
+	tunnelArgs = append(tunnelArgs, overrides...)
+	tunnelArgs = append(tunnelArgs, ports...)
+	return tunnelArgs, kind, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/tunnel"
+)
+
+// printUsage writes the exit summary: each tunnel's traffic with the worker
+// this session, on the wire, and what it cost at costPerGB if set. names
+// maps subdomains to display names.
+func printUsage(out io.Writer, names map[string]string, costPerGB float64) {
+	var sent, received int64
+	var cost float64
+	usage := tunnel.AllUsage()
+	for _, u := range usage {
+		if u.Connections == 0 {
+			continue
+		}
+		name := u.Subdomain
+		if n := names[u.Subdomain]; n != "" {
+			name = n
+		}
+		fmt.Fprintf(out, "%s: sent %s, received %s over %d connection(s)%s\n", name,
+			formatWireBytes(u.Sent), formatWireBytes(u.Received), u.Connections, formatCost(u.Cost(costPerGB), costPerGB))
+		sent += u.Sent
+		received += u.Received
+		cost += u.Cost(costPerGB)
+	}
+	if len(usage) > 1 {
+		fmt.Fprintf(out, "Total: %s on the wire%s\n", formatWireBytes(sent+received), formatCost(cost, costPerGB))
+	}
+}
+
+// formatWireBytes renders n in decimal units, as metered connections and
+// egress are billed.
+func formatWireBytes(n int64) string {
+	switch {
+	case n >= 1e9:
+		return fmt.Sprintf("%.2f GB", float64(n)/1e9)
+	case n >= 1e6:
+		return fmt.Sprintf("%.1f MB", float64(n)/1e6)
+	case n >= 1e3:
+		return fmt.Sprintf("%.1f KB", float64(n)/1e3)
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// formatCost renders a cost estimate to append to a usage line, or "" if no
+// -cost-per-gb is set.
+func formatCost(cost, costPerGB float64) string {
+	if costPerGB <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (~$%.4f at $%g/GB)", cost, costPerGB)
+}
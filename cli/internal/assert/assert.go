@@ -0,0 +1,207 @@
+// Package assert sends probes through a tunnel's public URL and checks the
+// responses, so CI can verify a preview before handing it out.
+package assert
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxBody caps how much of a response body is read for body assertions.
+const maxBody = 10 << 20
+
+// File is an assertions file:
+//
+//	subdomain: myapp          # or url: https://myapp.prod.bd
+//	probes:
+//	  - name: home
+//	    path: /
+//	    status: 200
+//	    body: <title>My App</title>
+//	  - path: /api/items
+//	    method: POST
+//	    request_headers: {Content-Type: application/json}
+//	    request_body: '{"name": "smoke"}'
+//	    status: 2xx
+//	    headers: {Content-Type: ^application/json}
+//	    body: '"id":\s*\d+'
+type File struct {
+	Subdomain string  `yaml:"subdomain"`
+	URL       string  `yaml:"url"`
+	Probes    []Probe `yaml:"probes"`
+}
+
+// Probe is one request and what its response must look like. Header and
+// body expectations are regular expressions matched anywhere in the value.
+type Probe struct {
+	Name           string            `yaml:"name"`
+	Method         string            `yaml:"method"` // default GET
+	Path           string            `yaml:"path"`
+	RequestHeaders map[string]string `yaml:"request_headers"`
+	RequestBody    string            `yaml:"request_body"`
+	Status         string            `yaml:"status"` // e.g. 200 or 2xx; default 2xx
+	Headers        map[string]string `yaml:"headers"`
+	Body           string            `yaml:"body"`
+	NotBody        string            `yaml:"not_body"` // must not match
+
+	headers map[string]*regexp.Regexp
+	body    *regexp.Regexp
+	notBody *regexp.Regexp
+}
+
+// Result is the outcome of one probe.
+type Result struct {
+	Probe    *Probe
+	Status   int
+	Latency  time.Duration
+	Failures []string // empty if the probe passed
+}
+
+// Passed reports whether every expectation held.
+func (r Result) Passed() bool { return len(r.Failures) == 0 }
+
+// Load reads and checks an assertions file.
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f File
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if len(f.Probes) == 0 {
+		return nil, fmt.Errorf("%s has no probes", path)
+	}
+	for i := range f.Probes {
+		p := &f.Probes[i]
+		if err := p.compile(); err != nil {
+			return nil, fmt.Errorf("%s: probe %d: %w", path, i+1, err)
+		}
+	}
+	return &f, nil
+}
+
+func (p *Probe) compile() error {
+	if !strings.HasPrefix(p.Path, "/") {
+		return fmt.Errorf("path must start with /")
+	}
+	p.Method = strings.ToUpper(p.Method)
+	if p.Method == "" {
+		p.Method = http.MethodGet
+	}
+	if p.Name == "" {
+		p.Name = p.Method + " " + p.Path
+	}
+	if p.Status == "" {
+		p.Status = "2xx"
+	}
+	if !validStatus(p.Status) {
+		return fmt.Errorf("status %q is not a code like 200 or a class like 2xx", p.Status)
+	}
+	var err error
+	p.headers = make(map[string]*regexp.Regexp, len(p.Headers))
+	for name, expr := range p.Headers {
+		if p.headers[name], err = regexp.Compile(expr); err != nil {
+			return fmt.Errorf("header %s: %w", name, err)
+		}
+	}
+	if p.Body != "" {
+		if p.body, err = regexp.Compile(p.Body); err != nil {
+			return fmt.Errorf("body: %w", err)
+		}
+	}
+	if p.NotBody != "" {
+		if p.notBody, err = regexp.Compile(p.NotBody); err != nil {
+			return fmt.Errorf("not_body: %w", err)
+		}
+	}
+	return nil
+}
+
+// validStatus reports whether s is a status code or class such as 404 or 5xx.
+func validStatus(s string) bool {
+	if len(s) != 3 || s[0] < '1' || s[0] > '5' {
+		return false
+	}
+	for _, c := range s[1:] {
+		if (c < '0' || c > '9') && c != 'x' && c != 'X' {
+			return false
+		}
+	}
+	return true
+}
+
+func matchStatus(pat string, status int) bool {
+	code := strconv.Itoa(status)
+	if len(code) != 3 {
+		return false
+	}
+	for i := range 3 {
+		if pat[i] != 'x' && pat[i] != 'X' && pat[i] != code[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// client doesn't follow redirects, so probes can assert on them.
+var client = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// Run sends p to baseURL and checks the response.
+func Run(ctx context.Context, baseURL string, p *Probe) Result {
+	res := Result{Probe: p}
+	req, err := http.NewRequestWithContext(ctx, p.Method, strings.TrimSuffix(baseURL, "/")+p.Path, strings.NewReader(p.RequestBody))
+	if err != nil {
+		res.Failures = append(res.Failures, err.Error())
+		return res
+	}
+	for k, v := range p.RequestHeaders {
+		req.Header.Set(k, v)
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		res.Failures = append(res.Failures, err.Error())
+		return res
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBody))
+	res.Latency = time.Since(start)
+	res.Status = resp.StatusCode
+	if err != nil {
+		res.Failures = append(res.Failures, fmt.Sprintf("reading body: %v", err))
+	}
+
+	if !matchStatus(p.Status, resp.StatusCode) {
+		res.Failures = append(res.Failures, fmt.Sprintf("status %d, want %s", resp.StatusCode, p.Status))
+	}
+	for name, re := range p.headers {
+		values, ok := resp.Header[http.CanonicalHeaderKey(name)]
+		if !ok {
+			res.Failures = append(res.Failures, fmt.Sprintf("header %s missing", name))
+			continue
+		}
+		if !re.MatchString(strings.Join(values, ", ")) {
+			res.Failures = append(res.Failures, fmt.Sprintf("header %s = %q doesn't match %q", name, strings.Join(values, ", "), p.Headers[name]))
+		}
+	}
+	if p.body != nil && !p.body.Match(body) {
+		res.Failures = append(res.Failures, fmt.Sprintf("body doesn't match %q", p.Body))
+	}
+	if p.notBody != nil && p.notBody.Match(body) {
+		res.Failures = append(res.Failures, fmt.Sprintf("body matches %q", p.NotBody))
+	}
+	return res
+}
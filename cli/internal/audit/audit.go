@@ -0,0 +1,185 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/config"
+	"github.com/QuadTriangle/prod.bd/cli/internal/storage"
+)
+
+// PageSpeedURL is the PageSpeed Insights v5 endpoint used for audits.
+const PageSpeedURL = "https://www.googleapis.com/pagespeedonline/v5/runPagespeed"
+
+// Categories audited by default.
+var Categories = []string{"performance", "accessibility", "best-practices", "seo"}
+
+// Report is a saved audit result for one tunnel.
+type Report struct {
+	Subdomain string             `json:"subdomain"`
+	URL       string             `json:"url"`
+	Strategy  string             `json:"strategy"`
+	Scores    map[string]float64 `json:"scores"` // category -> 0..100
+	Metrics   map[string]string  `json:"metrics,omitempty"`
+	CreatedAt int64              `json:"created_at"`
+}
+
+// pageSpeedResponse is the subset of the PageSpeed response we keep.
+type pageSpeedResponse struct {
+	LighthouseResult struct {
+		Categories map[string]struct {
+			Score *float64 `json:"score"`
+		} `json:"categories"`
+		Audits map[string]struct {
+			DisplayValue string `json:"displayValue"`
+		} `json:"audits"`
+	} `json:"lighthouseResult"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// metricAudits are the Lighthouse audits surfaced in Report.Metrics.
+var metricAudits = []string{
+	"first-contentful-paint",
+	"largest-contentful-paint",
+	"total-blocking-time",
+	"cumulative-layout-shift",
+	"speed-index",
+}
+
+// ErrInvalidSubdomain is returned for a subdomain that isn't a single DNS
+// label, which could point an audit elsewhere or a report out of its
+// directory.
+var ErrInvalidSubdomain = errors.New("invalid subdomain")
+
+var subdomainRe = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+func checkSubdomain(subdomain string) error {
+	if !subdomainRe.MatchString(subdomain) {
+		return fmt.Errorf("%w %q: use lowercase letters, digits and dashes", ErrInvalidSubdomain, subdomain)
+	}
+	return nil
+}
+
+// Run triggers a PageSpeed Insights run against the tunnel's public URL.
+// strategy is "mobile" or "desktop"; apiKey is optional.
+func Run(subdomain, strategy, apiKey string) (*Report, error) {
+	if err := checkSubdomain(subdomain); err != nil {
+		return nil, err
+	}
+	target := config.PublicURL(subdomain)
+
+	q := url.Values{}
+	q.Set("url", target)
+	q.Set("strategy", strategy)
+	for _, c := range Categories {
+		q.Add("category", c)
+	}
+	if apiKey != "" {
+		q.Set("key", apiKey)
+	}
+
+	// Lighthouse runs take a while; PageSpeed regularly needs over a minute
+	client := &http.Client{Timeout: 3 * time.Minute}
+	resp, err := client.Get(PageSpeedURL + "?" + q.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var res pageSpeedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("failed to decode PageSpeed response: %w", err)
+	}
+	if res.Error != nil {
+		return nil, fmt.Errorf("pagespeed error: %s", res.Error.Message)
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("pagespeed returned status: %d", resp.StatusCode)
+	}
+
+	report := &Report{
+		Subdomain: subdomain,
+		URL:       target,
+		Strategy:  strategy,
+		Scores:    make(map[string]float64),
+		Metrics:   make(map[string]string),
+		CreatedAt: time.Now().Unix(),
+	}
+	for name, c := range res.LighthouseResult.Categories {
+		if c.Score != nil {
+			report.Scores[name] = *c.Score * 100
+		}
+	}
+	for _, name := range metricAudits {
+		if a, ok := res.LighthouseResult.Audits[name]; ok && a.DisplayValue != "" {
+			report.Metrics[name] = a.DisplayValue
+		}
+	}
+	return report, nil
+}
+
+// ReportsCollection holds saved audit and soak reports in storage.Default.
+const ReportsCollection = "reports"
+
+// Save stores the report and returns where it went.
+func Save(r *Report) (string, error) {
+	if err := checkSubdomain(r.Subdomain); err != nil {
+		return "", err
+	}
+	store, err := storage.Default()
+	if err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	where, err := store.Put(ReportsCollection, fmt.Sprintf("audit-%s-%d", r.Subdomain, r.CreatedAt), data)
+	if err != nil {
+		return "", fmt.Errorf("failed to write report: %w", err)
+	}
+	return where, nil
+}
+
+// List returns saved reports for a subdomain (all subdomains if empty), newest first.
+func List(subdomain string) ([]Report, error) {
+	if subdomain != "" {
+		if err := checkSubdomain(subdomain); err != nil {
+			return nil, err
+		}
+	}
+	store, err := storage.Default()
+	if err != nil {
+		return nil, err
+	}
+	prefix := "audit-"
+	if subdomain != "" {
+		prefix += subdomain + "-"
+	}
+	docs, err := store.List(ReportsCollection, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Report
+	for _, data := range docs {
+		var r Report
+		if err := json.Unmarshal(data, &r); err != nil {
+			continue
+		}
+		if subdomain != "" && r.Subdomain != subdomain {
+			continue
+		}
+		out = append(out, r)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt > out[j].CreatedAt })
+	return out, nil
+}
@@ -0,0 +1,30 @@
+package audit
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSubdomainMustBeALabel(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	for _, sub := range []string{"../../x", "a/b", `a\b`, "..", "", "-abc", "abc-", "ABC", "evil.example", "a?b", "a#b"} {
+		if _, err := Run(sub, "mobile", ""); !errors.Is(err, ErrInvalidSubdomain) {
+			t.Errorf("Run(%q): %v", sub, err)
+		}
+		if _, err := Save(&Report{Subdomain: sub}); !errors.Is(err, ErrInvalidSubdomain) {
+			t.Errorf("Save(%q): %v", sub, err)
+		}
+	}
+	if _, err := List("../x"); !errors.Is(err, ErrInvalidSubdomain) {
+		t.Errorf("List: %v", err)
+	}
+
+	r := &Report{Subdomain: "my-app2", CreatedAt: 1}
+	if _, err := Save(r); err != nil {
+		t.Fatal(err)
+	}
+	got, err := List("my-app2")
+	if err != nil || len(got) != 1 {
+		t.Fatalf("List = %v, %v", got, err)
+	}
+}
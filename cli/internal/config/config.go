@@ -7,24 +7,58 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 )
 
 const DefaultWorkerURL = "https://tunnel.prod.bd"
 
+// PublicDomain is the apex domain tunnels are exposed under.
+const PublicDomain = "prod.bd"
+
+// GetWorkerURL returns $WORKER_URL, else worker_url from the config file,
+// else DefaultWorkerURL.
 func GetWorkerURL() string {
 	if v := os.Getenv("WORKER_URL"); v != "" {
 		return v
 	}
+	if f, err := LoadFile(); err == nil && f.WorkerURL != "" {
+		return f.WorkerURL
+	}
 	return DefaultWorkerURL
 }
 
-func GetClientID() (string, error) {
+// publicURLs holds URLs assigned by servers that don't follow the
+// <subdomain>.PublicDomain scheme (e.g. localtunnel); see SetPublicURL.
+var publicURLs sync.Map // subdomain -> URL
+
+// SetPublicURL records the public URL a server assigned to subdomain, for
+// PublicURL to return.
+func SetPublicURL(subdomain, url string) {
+	publicURLs.Store(subdomain, url)
+}
+
+// PublicURL returns the public https URL for a tunnel subdomain.
+func PublicURL(subdomain string) string {
+	if u, ok := publicURLs.Load(subdomain); ok {
+		return u.(string)
+	}
+	return fmt.Sprintf("https://%s.%s", subdomain, PublicDomain)
+}
+
+// Dir returns the CLI's config directory (~/.prod).
+func Dir() (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return "", fmt.Errorf("failed to get user home directory: %w", err)
 	}
+	return filepath.Join(homeDir, ".prod"), nil
+}
 
-	configDir := filepath.Join(homeDir, ".prod")
+func GetClientID() (string, error) {
+	configDir, err := Dir()
+	if err != nil {
+		return "", err
+	}
 	idFile := filepath.Join(configDir, "id")
 
 	// Check if ID file exists
@@ -55,6 +89,52 @@ func GetClientID() (string, error) {
 	return id, nil
 }
 
+// TokenEnv overrides the saved account token (useful in CI).
+const TokenEnv = "PROD_TOKEN"
+
+// GetToken returns the account token from $PROD_TOKEN or ~/.prod/token.
+// Returns "" if neither is set.
+func GetToken() (string, error) {
+	if v := os.Getenv(TokenEnv); v != "" {
+		return v, nil
+	}
+	configDir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(filepath.Join(configDir, "token"))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read token file: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// SaveToken stores the account token in ~/.prod/token (owner-only).
+// An empty token removes the file.
+func SaveToken(token string) error {
+	configDir, err := Dir()
+	if err != nil {
+		return err
+	}
+	tokenFile := filepath.Join(configDir, "token")
+	if token == "" {
+		if err := os.Remove(tokenFile); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove token file: %w", err)
+		}
+		return nil
+	}
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(tokenFile, []byte(token), 0600); err != nil {
+		return fmt.Errorf("failed to write token file: %w", err)
+	}
+	return nil
+}
+
 func generateID() (string, error) {
 	b := make([]byte, 16)
 	_, err := rand.Read(b)
@@ -64,8 +144,20 @@ func generateID() (string, error) {
 	return hex.EncodeToString(b), nil
 }
 
+// targetHost overrides GetTargetHost when set; see SetTargetHost.
+var targetHost string
+
+// SetTargetHost makes local ports resolve to host instead of localhost.
+// Call before starting tunnels.
+func SetTargetHost(host string) {
+	targetHost = host
+}
+
 // host.docker.internal is not available in Linux
 func GetTargetHost() string {
+	if targetHost != "" {
+		return targetHost
+	}
 	if os.Getenv("NET_HOST") == "false" {
 		return "host.docker.internal"
 	}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// dockerHostName resolves to the host on Docker Desktop, and on Linux when
+// the container runs with --add-host=host.docker.internal:host-gateway.
+const dockerHostName = "host.docker.internal"
+
+// ContainerTargetHost returns the address of the machine running the
+// container, where the ports being tunneled live: localhost with
+// NET_HOST=true (host networking), else host.docker.internal if it resolves,
+// else the container's default gateway.
+func ContainerTargetHost() (string, error) {
+	if os.Getenv("NET_HOST") == "true" {
+		return "localhost", nil
+	}
+	if addrs, err := net.LookupHost(dockerHostName); err == nil && len(addrs) > 0 {
+		return dockerHostName, nil
+	}
+	gw, err := defaultGateway()
+	if err != nil {
+		return "", fmt.Errorf("%s doesn't resolve and no default gateway found: %w", dockerHostName, err)
+	}
+	return gw, nil
+}
+
+// defaultGateway reads the IPv4 default route from /proc/net/route.
+func defaultGateway() (string, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// Iface Destination Gateway Flags ... (hex, little-endian)
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		b, err := hex.DecodeString(fields[2])
+		if err != nil || len(b) != 4 {
+			continue
+		}
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, binary.LittleEndian.Uint32(b))
+		if !ip.IsUnspecified() {
+			return ip.String(), nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no default route")
+}
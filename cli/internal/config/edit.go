@@ -0,0 +1,142 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loadDoc reads the config file as a YAML node tree, so it can be edited
+// without losing comments. A missing file yields an empty mapping.
+func loadDoc() (path string, doc *yaml.Node, err error) {
+	path, err = FilePath()
+	if err != nil {
+		return "", nil, err
+	}
+	doc = &yaml.Node{}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err := yaml.Unmarshal(data, doc); err != nil {
+		return "", nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if doc.Kind == 0 {
+		doc = &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	if doc.Content[0].Kind != yaml.MappingNode {
+		return "", nil, fmt.Errorf("%s is not a YAML mapping", path)
+	}
+	return path, doc, nil
+}
+
+// saveDoc writes doc back to the config file, after checking it still
+// decodes as a File.
+func saveDoc(path string, doc *yaml.Node) error {
+	out, err := MarshalYAML(doc)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(out, &File{}); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// Profiles can hold credentials (auth)
+	return os.WriteFile(path, out, 0600)
+}
+
+// lookupKey walks a dotted key ("profiles.myapp.flags.auth") down the
+// mappings of doc. With create, missing mappings are added. It returns the
+// mapping holding the last part, or nil if a part is missing.
+func lookupKey(doc *yaml.Node, key string, create bool) (*yaml.Node, string, error) {
+	parts := strings.Split(key, ".")
+	for _, p := range parts {
+		if p == "" {
+			return nil, "", fmt.Errorf("invalid key %q", key)
+		}
+	}
+	m := doc.Content[0]
+	for i, p := range parts[:len(parts)-1] {
+		next := mappingValue(m, p)
+		if next == nil {
+			if !create {
+				return nil, "", nil
+			}
+			next = &yaml.Node{Kind: yaml.MappingNode}
+			m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: p}, next)
+		}
+		if next.Kind != yaml.MappingNode {
+			return nil, "", fmt.Errorf("%s is not a mapping", strings.Join(parts[:i+1], "."))
+		}
+		m = next
+	}
+	return m, parts[len(parts)-1], nil
+}
+
+// GetValue returns the value at a dotted key of the config file, as YAML
+// for anything but a plain scalar. ok is false if it isn't set.
+func GetValue(key string) (value string, ok bool, err error) {
+	_, doc, err := loadDoc()
+	if err != nil {
+		return "", false, err
+	}
+	m, last, err := lookupKey(doc, key, false)
+	if err != nil || m == nil {
+		return "", false, err
+	}
+	node := mappingValue(m, last)
+	if node == nil {
+		return "", false, nil
+	}
+	if node.Kind == yaml.ScalarNode {
+		return node.Value, true, nil
+	}
+	out, err := MarshalYAML(node)
+	return strings.TrimSuffix(string(out), "\n"), true, err
+}
+
+// SetValue sets a dotted key of the config file to a string, creating the
+// mappings above it, and keeps the rest of the file as it was.
+func SetValue(key, value string) error {
+	path, doc, err := loadDoc()
+	if err != nil {
+		return err
+	}
+	m, last, err := lookupKey(doc, key, true)
+	if err != nil {
+		return err
+	}
+	node := &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+	if existing := mappingValue(m, last); existing != nil {
+		node.HeadComment, node.LineComment = existing.HeadComment, existing.LineComment
+		*existing = *node
+	} else {
+		m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: last}, node)
+	}
+	return saveDoc(path, doc)
+}
+
+// UnsetValue removes a dotted key from the config file. ok is false if it
+// wasn't set.
+func UnsetValue(key string) (ok bool, err error) {
+	path, doc, err := loadDoc()
+	if err != nil {
+		return false, err
+	}
+	m, last, err := lookupKey(doc, key, false)
+	if err != nil || m == nil {
+		return false, err
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == last {
+			m.Content = append(m.Content[:i], m.Content[i+2:]...)
+			return true, saveDoc(path, doc)
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,168 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// File is the optional ~/.prod/config.yaml.
+//
+//	worker_url: https://tunnel.prod.bd
+//	regions:
+//	  us: https://us.tunnel.example.com
+//	  eu: https://eu.tunnel.example.com
+//	profiles:
+//	  myapp:
+//	    ports: ["3000:myapp", "8080"]
+//	    tcp: ["5432"]
+//	    flags:
+//	      auth: user:pass
+//	      banner: "true"
+//	groups:
+//	  stack:
+//	    tunnels:
+//	      api: {port: "8080:api"}
+//	      web: {port: "3000", depends_on: [api]}
+//	presets:
+//	  review:
+//	    banner: "true"
+//	    noindex: "true"
+type File struct {
+	WorkerURL string                       `yaml:"worker_url"`
+	Regions   map[string]string            `yaml:"regions"` // name -> worker URL, used with -region
+	Profiles  map[string]Profile           `yaml:"profiles"`
+	Groups    map[string]Group             `yaml:"groups"`
+	Presets   map[string]map[string]string `yaml:"presets"` // name -> flag values, used with -preset
+}
+
+// Profile is a named tunnel invocation: ports plus any CLI flags, including
+// those owned by plugins (auth, allow-ip, banner, ...).
+type Profile struct {
+	Ports     []string          `yaml:"ports,omitempty"` // "3000" or "3000:subdomain"
+	TCP       []string          `yaml:"tcp,omitempty"`
+	Subdomain string            `yaml:"subdomain,omitempty"`
+	Flags     map[string]string `yaml:"flags,omitempty"` // flag name (without dash) -> value
+}
+
+// Group is a named set of tunnels started together with `prod up <group>`,
+// each connecting once the tunnels it depends on have. The group's name is
+// shown with its tunnels in the output and stats.
+type Group struct {
+	Tunnels map[string]GroupTunnel `yaml:"tunnels"` // name (the port's label) -> tunnel
+	Flags   map[string]string      `yaml:"flags,omitempty"`
+}
+
+// GroupTunnel is one tunnel of a Group.
+type GroupTunnel struct {
+	Port      string   `yaml:"port"` // "3000", "3000:subdomain" or a target URL
+	TCP       bool     `yaml:"tcp,omitempty"`
+	DependsOn []string `yaml:"depends_on,omitempty"` // tunnels to connect first
+}
+
+// FilePath returns the config file location (~/.prod/config.yaml).
+func FilePath() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "config.yaml"), nil
+}
+
+// LoadFile reads ~/.prod/config.yaml. A missing file yields an empty config.
+func LoadFile() (*File, error) {
+	path, err := FilePath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &File{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	var f File
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &f, nil
+}
+
+// Profile looks up a named profile.
+func (f *File) Profile(name string) (Profile, error) {
+	p, ok := f.Profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("no profile %q in config file", name)
+	}
+	return p, nil
+}
+
+// Group looks up a named group.
+func (f *File) Group(name string) (Group, error) {
+	g, ok := f.Groups[name]
+	if !ok {
+		return Group{}, fmt.Errorf("no group %q in config file", name)
+	}
+	if len(g.Tunnels) == 0 {
+		return Group{}, fmt.Errorf("group %q has no tunnels", name)
+	}
+	return g, nil
+}
+
+// Args renders the group as command-line flags and positional ports, each
+// port labeled with its tunnel's name.
+func (g Group) Args(name string) (flags, ports []string) {
+	flags = Profile{Flags: g.Flags}.FlagArgs()
+	flags = append(flags, "-group="+name)
+
+	tunnels := make([]string, 0, len(g.Tunnels))
+	for t := range g.Tunnels {
+		tunnels = append(tunnels, t)
+	}
+	sort.Strings(tunnels)
+	var tcp, depends []string
+	for _, t := range tunnels {
+		gt := g.Tunnels[t]
+		if gt.TCP {
+			tcp = append(tcp, gt.Port+"="+t)
+		} else {
+			ports = append(ports, gt.Port+"="+t)
+		}
+		for _, dep := range gt.DependsOn {
+			depends = append(depends, t+"="+dep)
+		}
+	}
+	if len(tcp) > 0 {
+		flags = append(flags, "-tcp="+strings.Join(tcp, ","))
+	}
+	if len(depends) > 0 {
+		flags = append(flags, "-depends="+strings.Join(depends, ","))
+	}
+	return flags, ports
+}
+
+// FlagArgs renders the profile's settings as command-line flags. Ports are
+// positional and left to the caller so extra flags can be placed before them.
+func (p Profile) FlagArgs() []string {
+	var args []string
+	names := make([]string, 0, len(p.Flags))
+	for name := range p.Flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, fmt.Sprintf("-%s=%s", name, p.Flags[name]))
+	}
+	if p.Subdomain != "" {
+		args = append(args, "-subdomain="+p.Subdomain)
+	}
+	if len(p.TCP) > 0 {
+		args = append(args, "-tcp="+strings.Join(p.TCP, ","))
+	}
+	return args
+}
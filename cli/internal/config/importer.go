@@ -0,0 +1,319 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Import translates another tunnel tool's config file into profiles.
+// Settings without an equivalent are reported in warnings instead.
+type Import struct {
+	Profiles map[string]Profile
+	Warnings []string
+}
+
+func (im *Import) warnf(format string, args ...any) {
+	im.Warnings = append(im.Warnings, fmt.Sprintf(format, args...))
+}
+
+// ngrokFile covers the tunnels of ngrok agent configs (v2 and v3) and the
+// endpoints of v3.
+type ngrokFile struct {
+	Tunnels   map[string]ngrokTunnel `yaml:"tunnels"`
+	Endpoints []struct {
+		Name     string `yaml:"name"`
+		URL      string `yaml:"url"`
+		Upstream struct {
+			URL string `yaml:"url"`
+		} `yaml:"upstream"`
+		TrafficPolicy any `yaml:"traffic_policy"`
+	} `yaml:"endpoints"`
+}
+
+type ngrokTunnel struct {
+	Proto         string   `yaml:"proto"`
+	Addr          string   `yaml:"addr"`
+	Subdomain     string   `yaml:"subdomain"`
+	Hostname      string   `yaml:"hostname"`
+	Domain        string   `yaml:"domain"`
+	Auth          string   `yaml:"auth"`       // v2
+	BasicAuth     []string `yaml:"basic_auth"` // v3
+	CIDRAllow     []string `yaml:"cidr_allow"`
+	IPRestriction struct {
+		AllowCIDRs []string `yaml:"allow_cidrs"`
+	} `yaml:"ip_restriction"`
+}
+
+// cloudflaredFile is a cloudflared tunnel config.
+type cloudflaredFile struct {
+	Ingress []struct {
+		Hostname      string `yaml:"hostname"`
+		Path          string `yaml:"path"`
+		Service       string `yaml:"service"`
+		OriginRequest any    `yaml:"originRequest"`
+	} `yaml:"ingress"`
+}
+
+// ImportFile reads an ngrok or cloudflared config file, telling them apart
+// by their top-level keys. ngrok tunnels become one profile each, named
+// after the tunnel; a cloudflared config becomes a single profile named
+// cloudflared.
+func ImportFile(path string) (*Import, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys map[string]any
+	if err := yaml.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	switch {
+	case keys["ingress"] != nil:
+		var f cloudflaredFile
+		if err := yaml.Unmarshal(data, &f); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		return importCloudflared(f), nil
+	case keys["tunnels"] != nil || keys["endpoints"] != nil:
+		var f ngrokFile
+		if err := yaml.Unmarshal(data, &f); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		return importNgrok(f), nil
+	}
+	return nil, fmt.Errorf("%s has no ngrok tunnels/endpoints or cloudflared ingress", path)
+}
+
+func importNgrok(f ngrokFile) *Import {
+	im := &Import{Profiles: make(map[string]Profile)}
+	names := make([]string, 0, len(f.Tunnels))
+	for name := range f.Tunnels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		t := f.Tunnels[name]
+		target, err := importTarget(t.Addr)
+		if err != nil {
+			im.warnf("ngrok tunnel %s: %v; skipped", name, err)
+			continue
+		}
+		p := Profile{Flags: map[string]string{}}
+		for _, host := range []string{t.Subdomain, t.Hostname, t.Domain} {
+			if host != "" {
+				p.Subdomain = firstLabel(host)
+				break
+			}
+		}
+		switch t.Proto {
+		case "", "http":
+			p.Ports = []string{target}
+		case "tcp":
+			if strings.Contains(target, "://") {
+				im.warnf("ngrok tunnel %s: only local TCP ports are supported; skipped", name)
+				continue
+			}
+			p.TCP = []string{target}
+		default:
+			im.warnf("ngrok tunnel %s: proto %s isn't supported; skipped", name, t.Proto)
+			continue
+		}
+		auth := t.BasicAuth
+		if t.Auth != "" {
+			auth = append(auth, t.Auth)
+		}
+		if len(auth) > 0 {
+			p.Flags["auth"] = auth[0]
+			if len(auth) > 1 {
+				im.warnf("ngrok tunnel %s: only the first of %d basic auth credentials is kept", name, len(auth))
+			}
+		}
+		if cidrs := append(t.CIDRAllow, t.IPRestriction.AllowCIDRs...); len(cidrs) > 0 {
+			p.Flags["allow-ip"] = strings.Join(cidrs, ",")
+		}
+		if len(p.Flags) == 0 {
+			p.Flags = nil
+		}
+		im.Profiles[name] = p
+	}
+	for i, e := range f.Endpoints {
+		name := e.Name
+		if name == "" {
+			name = "endpoint" + strconv.Itoa(i+1)
+		}
+		target, err := importTarget(e.Upstream.URL)
+		if err != nil {
+			im.warnf("ngrok endpoint %s: %v; skipped", name, err)
+			continue
+		}
+		if e.TrafficPolicy != nil {
+			im.warnf("ngrok endpoint %s: traffic_policy isn't translated", name)
+		}
+		host := e.URL
+		if u, err := url.Parse(e.URL); err == nil && u.Host != "" {
+			host = u.Hostname()
+		}
+		im.Profiles[name] = Profile{Ports: []string{target}, Subdomain: firstLabel(host)}
+	}
+	return im
+}
+
+func importCloudflared(f cloudflaredFile) *Import {
+	im := &Import{Profiles: make(map[string]Profile)}
+	var p Profile
+	var unix []string
+	for _, rule := range f.Ingress {
+		if rule.Hostname == "" {
+			// The catch-all rule (e.g. http_status:404) has no equivalent
+			continue
+		}
+		sub := firstLabel(rule.Hostname)
+		if rule.Path != "" {
+			im.warnf("cloudflared rule %s: path %s isn't supported; the whole hostname is forwarded", rule.Hostname, rule.Path)
+		}
+		if rule.OriginRequest != nil {
+			im.warnf("cloudflared rule %s: originRequest settings aren't translated", rule.Hostname)
+		}
+		scheme, rest, _ := strings.Cut(rule.Service, ":")
+		switch scheme {
+		case "http", "https":
+			target, err := importTarget(rule.Service)
+			if err != nil {
+				im.warnf("cloudflared rule %s: %v; skipped", rule.Hostname, err)
+				continue
+			}
+			if strings.Contains(target, "://") {
+				im.warnf("cloudflared rule %s: %s can't keep subdomain %s in a profile; it gets a generated one", rule.Hostname, target, sub)
+				p.Ports = append(p.Ports, target)
+			} else {
+				p.Ports = append(p.Ports, target+":"+sub)
+			}
+		case "tcp", "ssh", "rdp", "smb":
+			target, err := importTarget(rule.Service)
+			if err != nil || strings.Contains(target, "://") {
+				im.warnf("cloudflared rule %s: only local TCP ports are supported; skipped", rule.Hostname)
+				continue
+			}
+			p.TCP = append(p.TCP, target+":"+sub)
+		case "unix":
+			unix = append(unix, strings.TrimPrefix(rest, "//")+"="+sub)
+		default:
+			im.warnf("cloudflared rule %s: service %s isn't supported; skipped", rule.Hostname, rule.Service)
+		}
+	}
+	if len(unix) > 0 {
+		p.Flags = map[string]string{"unix": strings.Join(unix, ",")}
+	}
+	if len(p.Ports) > 0 || len(p.TCP) > 0 || p.Flags != nil {
+		im.Profiles["cloudflared"] = p
+	}
+	return im
+}
+
+// importTarget turns an ngrok addr or cloudflared service into a port
+// argument: a bare port for local targets, else a target URL.
+func importTarget(addr string) (string, error) {
+	if addr == "" {
+		return "", fmt.Errorf("no local address")
+	}
+	if _, err := strconv.Atoi(addr); err == nil {
+		return addr, nil
+	}
+	scheme := "http"
+	if s, rest, ok := strings.Cut(addr, "://"); ok {
+		scheme, addr = s, rest
+	}
+	addr, _, _ = strings.Cut(addr, "/")
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("unsupported address %q", addr)
+	}
+	switch scheme {
+	case "http", "tcp", "ssh", "rdp", "smb":
+		if host == "localhost" || host == "127.0.0.1" || host == "" {
+			return port, nil
+		}
+		if scheme != "http" {
+			return scheme + "://" + addr, nil
+		}
+	case "https":
+	default:
+		return "", fmt.Errorf("unsupported scheme %s", scheme)
+	}
+	return scheme + "://" + addr, nil
+}
+
+// firstLabel returns the subdomain part of a hostname (or a bare subdomain).
+func firstLabel(host string) string {
+	label, _, _ := strings.Cut(strings.ToLower(host), ".")
+	return label
+}
+
+// AddProfiles writes profiles into the config file, keeping its other
+// contents and comments. Existing profiles of the same name are only
+// replaced with overwrite.
+func AddProfiles(profiles map[string]Profile, overwrite bool) error {
+	path, doc, err := loadDoc()
+	if err != nil {
+		return err
+	}
+	root := doc.Content[0]
+	section := mappingValue(root, "profiles")
+	if section == nil {
+		section = &yaml.Node{Kind: yaml.MappingNode}
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "profiles"}, section)
+	}
+
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var value yaml.Node
+		if err := value.Encode(profiles[name]); err != nil {
+			return err
+		}
+		if existing := mappingValue(section, name); existing != nil {
+			if !overwrite {
+				return fmt.Errorf("profile %q already exists in %s", name, path)
+			}
+			*existing = value
+			continue
+		}
+		section.Content = append(section.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: name}, &value)
+	}
+	return saveDoc(path, doc)
+}
+
+// MarshalYAML encodes v with the two-space indent config files use.
+func MarshalYAML(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// mappingValue returns the value node for key in a YAML mapping.
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
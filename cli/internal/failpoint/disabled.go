@@ -0,0 +1,18 @@
+//go:build !failpoints
+
+package failpoint
+
+import "errors"
+
+// Enabled reports whether failpoints are compiled in.
+const Enabled = false
+
+// Set fails: the binary was built without -tags failpoints.
+func Set(name string, value int) error {
+	return errors.New("failpoints not compiled in; rebuild with -tags failpoints")
+}
+
+func List() map[string]int      { return nil }
+func DropFrame() bool           { return false }
+func Corrupt(msg []byte) []byte { return msg }
+func DelayWrite()               {}
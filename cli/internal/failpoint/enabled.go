@@ -0,0 +1,88 @@
+//go:build failpoints
+
+package failpoint
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Enabled reports whether failpoints are compiled in.
+const Enabled = true
+
+var (
+	mu     sync.Mutex
+	values = map[string]int{}
+)
+
+// Set arms failpoint name with value; 0 disarms it.
+func Set(name string, value int) error {
+	if !slices.Contains(Names, name) {
+		return fmt.Errorf("unknown failpoint %q", name)
+	}
+	if value < 0 {
+		return fmt.Errorf("failpoint %s: value must be >= 0", name)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if value == 0 {
+		delete(values, name)
+	} else {
+		values[name] = value
+	}
+	return nil
+}
+
+// List returns the armed failpoints and their values.
+func List() map[string]int {
+	mu.Lock()
+	defer mu.Unlock()
+	out := make(map[string]int, len(values))
+	for k, v := range values {
+		out[k] = v
+	}
+	return out
+}
+
+// take consumes one count from a counting failpoint.
+func take(name string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	n := values[name]
+	if n == 0 {
+		return false
+	}
+	if n == 1 {
+		delete(values, name)
+	} else {
+		values[name] = n - 1
+	}
+	return true
+}
+
+// DropFrame reports whether the incoming frame should be dropped.
+func DropFrame() bool { return take(DropFrames) }
+
+// Corrupt returns msg garbled if CorruptFrames is armed, else msg unchanged.
+func Corrupt(msg []byte) []byte {
+	if !take(CorruptFrames) {
+		return msg
+	}
+	out := slices.Clone(msg)
+	for i := range out {
+		out[i] ^= 0xff
+	}
+	return out
+}
+
+// DelayWrite blocks for the DelayWrites duration, if armed.
+func DelayWrite() {
+	mu.Lock()
+	ms := values[DelayWrites]
+	mu.Unlock()
+	if ms > 0 {
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+	}
+}
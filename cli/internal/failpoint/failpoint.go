@@ -0,0 +1,18 @@
+// Package failpoint injects faults into the tunnel connection so reconnects,
+// backpressure and error paths can be exercised deterministically. The real
+// implementation is only compiled with -tags failpoints; otherwise every
+// check is a no-op the compiler can inline away.
+package failpoint
+
+// Failpoint names, set through Set or the dashboard API (POST /api/v1/failpoints).
+const (
+	// DropFrames drops the next N incoming tunnel frames.
+	DropFrames = "drop-frames"
+	// CorruptFrames garbles the next N incoming tunnel frames.
+	CorruptFrames = "corrupt-frames"
+	// DelayWrites sleeps N milliseconds before every tunnel write while set.
+	DelayWrites = "delay-writes"
+)
+
+// Names lists the known failpoints.
+var Names = []string{DropFrames, CorruptFrames, DelayWrites}
@@ -0,0 +1,232 @@
+package hooks
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+// --- Authentication ---
+
+// Authenticator is one way for visitors to prove they may use a tunnel
+// (password, OAuth, an IP allowlist, ...). Authenticators don't block
+// requests themselves: the access plugin combines them (-access) and asks
+// the ones a visitor failed for a challenge.
+type Authenticator interface {
+	// Name is how -access refers to the scheme (e.g. "password").
+	Name() string
+	// Verify returns who the visitor is, or "" for schemes without
+	// identities, and true if req passes the scheme.
+	Verify(ctx *RequestContext, req types.TunnelRequest) (identity string, ok bool)
+	// Challenge returns the response that lets a visitor who failed Verify
+	// authenticate (a sign-in page, a redirect, a 401 with
+	// WWW-Authenticate), or false if there's nothing the visitor can do.
+	Challenge(ctx *RequestContext, req types.TunnelRequest) (types.TunnelResponse, bool)
+}
+
+// AuthPlugin is optionally implemented by plugins that gate visitors.
+type AuthPlugin interface {
+	Plugin
+	Authenticator() Authenticator
+}
+
+// WorkerAuthPlugin is implemented by auth plugins the worker enforces on
+// its own through WorkerConfig. EnforceLocally moves the check into the
+// CLI, so it can be combined with other schemes (-access ip|password); it
+// is called before registering, and WorkerConfig must then leave the scheme
+// out.
+type WorkerAuthPlugin interface {
+	AuthPlugin
+	EnforceLocally() error
+}
+
+// SecondFactor is implemented by auth plugins that only add to another
+// scheme (-totp): a code from an app proves little on its own. The access
+// plugin refuses policies that let visitors in on second factors alone.
+type SecondFactor interface {
+	AuthPlugin
+	SecondFactor()
+}
+
+// GateHook is optionally implemented by request hooks that decide whether a
+// visitor may use the tunnel at all (the access plugin). WebSockets and TCP
+// streams don't pass through BeforeProxy, so the tunnel asks the gates
+// before opening one.
+type GateHook interface {
+	// Admit returns req as the local server should see it and true if the
+	// visitor may go on, or the response turning them away and false.
+	Admit(ctx *RequestContext, req types.TunnelRequest) (types.TunnelRequest, types.TunnelResponse, bool)
+}
+
+// AuthPlugins returns the enabled plugins that implement AuthPlugin, in
+// registration order.
+func (p *Pipeline) AuthPlugins() []AuthPlugin {
+	var out []AuthPlugin
+	for _, pl := range p.plugins {
+		if ap, ok := pl.(AuthPlugin); ok && pl.Enabled() {
+			out = append(out, ap)
+		}
+	}
+	return out
+}
+
+// SessionCookiePrefix starts the name of every session cookie Sessions
+// issue. The access plugin keeps these cookies from the local server.
+const SessionCookiePrefix = "prodbd_session_"
+
+// Sessions issues and checks the signed session cookies an authenticator
+// hands out once a visitor passed its challenge, so they aren't challenged
+// on every request. Each scheme has its own cookie, so sessions of
+// combined schemes live side by side. The signing key lives as long as the
+// CLI; restarting it signs everyone out.
+type Sessions struct {
+	scheme string
+	ttl    time.Duration
+	key    []byte
+}
+
+// NewSessions returns sessions for scheme that last ttl.
+func NewSessions(scheme string, ttl time.Duration) *Sessions {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return &Sessions{scheme: scheme, ttl: ttl, key: key}
+}
+
+// CookieName is the cookie holding the scheme's session.
+func (s *Sessions) CookieName() string { return SessionCookiePrefix + s.scheme }
+
+// Issue returns a Set-Cookie value starting a session for identity.
+func (s *Sessions) Issue(identity string, now time.Time) string {
+	value := identity + "|" + strconv.FormatInt(now.Add(s.ttl).Unix(), 10)
+	return (&http.Cookie{
+		Name:     s.CookieName(),
+		Value:    base64.RawURLEncoding.EncodeToString([]byte(value + "|" + s.sign(value))),
+		Path:     "/",
+		MaxAge:   int(s.ttl.Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}).String()
+}
+
+// Clear returns a Set-Cookie value ending the session.
+func (s *Sessions) Clear() string {
+	return (&http.Cookie{Name: s.CookieName(), Path: "/", MaxAge: -1, Secure: true, HttpOnly: true}).String()
+}
+
+// Verify returns the identity of the valid session req carries.
+func (s *Sessions) Verify(req types.TunnelRequest, now time.Time) (string, bool) {
+	c, err := (&http.Request{Header: CanonicalHeader(req.Headers)}).Cookie(s.CookieName())
+	if err != nil {
+		return "", false
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(c.Value)
+	if err != nil {
+		return "", false
+	}
+	// The identity may hold "|"; expiry and MAC can't
+	i := strings.LastIndexByte(string(raw), '|')
+	if i < 0 {
+		return "", false
+	}
+	value, mac := string(raw[:i]), string(raw[i+1:])
+	if !hmac.Equal([]byte(mac), []byte(s.sign(value))) {
+		return "", false
+	}
+	j := strings.LastIndexByte(value, '|')
+	if j < 0 {
+		return "", false
+	}
+	exp, err := strconv.ParseInt(value[j+1:], 10, 64)
+	if err != nil || now.Unix() > exp {
+		return "", false
+	}
+	return value[:j], true
+}
+
+// sign returns the MAC of value under the session key.
+func (s *Sessions) sign(value string) string {
+	m := hmac.New(sha256.New, s.key)
+	m.Write([]byte(s.scheme + "|" + value))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+// StripSessionCookies removes the session cookies of every scheme from
+// req, leaving the visitor's other cookies.
+func StripSessionCookies(req types.TunnelRequest) types.TunnelRequest {
+	header := CanonicalHeader(req.Headers)
+	var kept []string
+	for _, c := range (&http.Request{Header: header}).Cookies() {
+		if !strings.HasPrefix(c.Name, SessionCookiePrefix) {
+			kept = append(kept, c.String())
+		}
+	}
+	header.Del("Cookie")
+	if len(kept) > 0 {
+		header.Set("Cookie", strings.Join(kept, "; "))
+	}
+	req.Headers = header
+	return req
+}
+
+// Redirect sends the visitor to location after signing in. Only paths on
+// the tunnel are followed, so sign-in flows can't be used as open
+// redirects: anything else, including "//host" and "/\\host", which
+// browsers read as another site, goes to "/".
+func Redirect(location string) types.TunnelResponse {
+	if !strings.HasPrefix(location, "/") || strings.HasPrefix(location, "//") || strings.HasPrefix(location, "/\\") {
+		location = "/"
+	}
+	return types.TunnelResponse{
+		Status: http.StatusSeeOther,
+		Headers: map[string][]string{
+			"Location":      {location},
+			"Cache-Control": {"no-store"},
+		},
+	}
+}
+
+// TextResponse is an uncached plain-text answer from a plugin.
+func TextResponse(status int, msg string) types.TunnelResponse {
+	return uncached(status, "text/plain; charset=utf-8", msg)
+}
+
+// FormResponse renders page, a sign-in form whose verbs are an error
+// message, the form action and the escaped path to go back to.
+func FormResponse(status int, page, msg, action, returnTo string) types.TunnelResponse {
+	if msg != "" {
+		msg = `<span style="color:#b91c1c">` + html.EscapeString(msg) + `</span>`
+	}
+	return uncached(status, "text/html; charset=utf-8", fmt.Sprintf(page, msg, action, html.EscapeString(returnTo)))
+}
+
+func uncached(status int, contentType, body string) types.TunnelResponse {
+	return types.TunnelResponse{
+		Status: status,
+		Headers: map[string][]string{
+			"Content-Type":  {contentType},
+			"Cache-Control": {"no-store"},
+		},
+		Body: base64.StdEncoding.EncodeToString([]byte(body)),
+	}
+}
+
+// canonicalHeader copies h with canonical names; the worker sends them
+// lower-cased.
+func CanonicalHeader(h map[string][]string) http.Header {
+	out := make(http.Header, len(h))
+	for k, v := range h {
+		k = http.CanonicalHeaderKey(k)
+		out[k] = append(out[k], v...)
+	}
+	return out
+}
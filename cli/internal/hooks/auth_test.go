@@ -0,0 +1,72 @@
+package hooks
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+// withCookie returns a request carrying the cookie of a Set-Cookie value.
+func withCookie(setCookie string) types.TunnelRequest {
+	pair, _, _ := strings.Cut(setCookie, ";")
+	return types.TunnelRequest{Headers: map[string][]string{"Cookie": {"theme=dark; " + pair}}}
+}
+
+func TestSessions(t *testing.T) {
+	now := time.Now()
+	s := NewSessions("password", time.Hour)
+	cookie := s.Issue("ana|admin", now)
+	if !strings.Contains(cookie, "HttpOnly") || !strings.Contains(cookie, "Secure") {
+		t.Errorf("session cookie %q", cookie)
+	}
+
+	if id, ok := s.Verify(withCookie(cookie), now.Add(time.Minute)); !ok || id != "ana|admin" {
+		t.Fatalf("Verify = %q, %v", id, ok)
+	}
+	if _, ok := s.Verify(withCookie(cookie), now.Add(2*time.Hour)); ok {
+		t.Error("expired session accepted")
+	}
+	// Another scheme's cookie of the same shape, or another run's key
+	if _, ok := NewSessions("password", time.Hour).Verify(withCookie(cookie), now); ok {
+		t.Error("session signed by another CLI accepted")
+	}
+	other := NewSessions("oauth", time.Hour)
+	forged := strings.Replace(other.Issue("ana|admin", now), other.CookieName(), s.CookieName(), 1)
+	if _, ok := s.Verify(withCookie(forged), now); ok {
+		t.Error("oauth session accepted as a password session")
+	}
+
+	for _, bad := range []string{"", "%%%", "YWJj", "YW5hfDE"} {
+		req := types.TunnelRequest{Headers: map[string][]string{"Cookie": {s.CookieName() + "=" + bad}}}
+		if _, ok := s.Verify(req, now); ok {
+			t.Errorf("cookie %q accepted", bad)
+		}
+	}
+}
+
+func TestStripSessionCookies(t *testing.T) {
+	req := withCookie(NewSessions("password", time.Hour).Issue("ana", time.Now()))
+	req = StripSessionCookies(req)
+	if got := http.Header(req.Headers).Get("Cookie"); got != "theme=dark" {
+		t.Fatalf("Cookie %q", got)
+	}
+}
+
+func TestRedirectStaysOnTunnel(t *testing.T) {
+	tests := []struct{ location, want string }{
+		{"/app?x=1", "/app?x=1"},
+		{"", "/"},
+		{"https://evil.example", "/"},
+		{"//evil.example", "/"},
+		{`/\evil.example`, "/"},
+	}
+	for _, tt := range tests {
+		resp := Redirect(tt.location)
+		if got := resp.Headers["Location"]; resp.Status != http.StatusSeeOther || len(got) != 1 || got[0] != tt.want {
+			t.Errorf("Redirect(%q) = %d %v, want %s", tt.location, resp.Status, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package hooks
+
+import (
+	"sync"
+	"time"
+)
+
+// Event is something published on the pipeline's Bus.
+type Event interface {
+	EventName() string
+}
+
+// RequestCompleted is published after a proxied request's response hooks ran.
+type RequestCompleted struct {
+	Subdomain string
+	Method    string
+	Path      string
+	Status    int
+	Latency   time.Duration
+	Streamed  bool
+}
+
+// TunnelDegraded is published when a tunnel loses its connection and is
+// about to retry.
+type TunnelDegraded struct {
+	Subdomain string
+	Attempt   int
+	Err       error
+}
+
+// AlertFired is published by plugins that detect something a user should
+// know about (error spikes, expiring tunnels, ...).
+type AlertFired struct {
+	Source    string // plugin name
+	Subdomain string // empty if not tunnel-specific
+	Message   string
+}
+
+// ProtocolError is published when the CLI receives a tunnel message it can't
+// handle and reports it back to the worker.
+type ProtocolError struct {
+	Subdomain string
+	Code      string // types.ErrCode*
+	MsgType   string // type of the offending message, if known
+	Err       error
+}
+
+// SchemaViolation is published when a request body fails -schema validation.
+type SchemaViolation struct {
+	Subdomain string
+	Method    string
+	Path      string
+	Schema    string   // schema file
+	Errors    []string // JSON pointer + message, capped
+}
+
+// QueueChanged is published when HTTP requests queue behind -max-concurrent
+// or leave the queue, and when one is turned away with a 503 because the
+// queue is full (Rejected).
+type QueueChanged struct {
+	Subdomain string
+	Queued    int
+	Rejected  bool
+}
+
+// PowerModeChanged is published when tunnels go idle on battery and switch
+// to low-power mode (-low-power), and again at the first request after.
+// Plugins with background work should pause it while LowPower is set.
+type PowerModeChanged struct {
+	LowPower bool
+}
+
+func (RequestCompleted) EventName() string { return "request-completed" }
+func (TunnelDegraded) EventName() string   { return "tunnel-degraded" }
+func (AlertFired) EventName() string       { return "alert-fired" }
+func (ProtocolError) EventName() string    { return "protocol-error" }
+func (SchemaViolation) EventName() string  { return "schema-violation" }
+func (QueueChanged) EventName() string     { return "queue-changed" }
+func (PowerModeChanged) EventName() string { return "power-mode-changed" }
+
+// EventPlugin is optionally implemented by plugins that react to events.
+// SubscribeEvents is called once from Activate for enabled plugins.
+type EventPlugin interface {
+	SubscribeEvents(bus *Bus)
+}
+
+// Bus is a synchronous publish/subscribe bus for cross-plugin events.
+// Handlers run on the publisher's goroutine, possibly concurrently with each
+// other, so they must be quick and safe for concurrent use. Zero-value is
+// ready to use.
+type Bus struct {
+	mu     sync.RWMutex
+	nextID int
+	subs   map[string]map[int]func(Event) // event name -> subscription id -> handler
+}
+
+// Subscribe registers fn for events of type E and returns a function that
+// removes the subscription.
+func Subscribe[E Event](b *Bus, fn func(E)) (unsubscribe func()) {
+	var zero E
+	name := zero.EventName()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs == nil {
+		b.subs = make(map[string]map[int]func(Event))
+	}
+	if b.subs[name] == nil {
+		b.subs[name] = make(map[int]func(Event))
+	}
+	b.nextID++
+	id := b.nextID
+	b.subs[name][id] = func(e Event) { fn(e.(E)) }
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs[name], id)
+	}
+}
+
+// Publish delivers e to its subscribers.
+func (b *Bus) Publish(e Event) {
+	b.mu.RLock()
+	handlers := make([]func(Event), 0, len(b.subs[e.EventName()]))
+	for _, fn := range b.subs[e.EventName()] {
+		handlers = append(handlers, fn)
+	}
+	b.mu.RUnlock()
+
+	for _, fn := range handlers {
+		fn(e)
+	}
+}
@@ -1,40 +1,225 @@
 package hooks
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/QuadTriangle/prod.bd/cli/internal/types"
 )
 
-// --- Hook interfaces (unchanged) ---
+// --- Hook interfaces ---
+
+// TunnelContext holds state scoped to one tunnel (one subdomain/port pair).
+// It lives as long as its TunnelPipeline and survives reconnects, so plugins can
+// keep per-tunnel state here instead of in maps keyed by subdomain. Requests
+// for the same tunnel run concurrently; the accessors are safe for that.
+type TunnelContext struct {
+	Subdomain string
+	Port      int // local port the tunnel forwards to
+
+	mu     sync.Mutex
+	values map[string]any
+}
+
+// NewTunnelContext creates a standalone tunnel context. Inside the CLI, use
+// Pipeline.ForTunnel so all requests for a tunnel share one.
+func NewTunnelContext(subdomain string, port int) *TunnelContext {
+	return &TunnelContext{Subdomain: subdomain, Port: port, values: make(map[string]any)}
+}
+
+// Value returns the value stored under key, or nil.
+func (t *TunnelContext) Value(key string) any {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.values[key]
+}
+
+// SetValue stores v under key.
+func (t *TunnelContext) SetValue(key string, v any) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.values[key] = v
+}
+
+// LoadOrStore returns the value under key, creating it with init if absent.
+// init runs with the context locked and must not call back into it.
+func (t *TunnelContext) LoadOrStore(key string, init func() any) any {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	v, ok := t.values[key]
+	if !ok {
+		v = init()
+		t.values[key] = v
+	}
+	return v
+}
+
+// RequestContext carries per-request state through the whole hook chain.
+// The same pointer is passed to every hook's BeforeProxy and AfterProxy for
+// one request, so a hook can stash data in Values and read it back later.
+// Hooks for a single request run sequentially; Values needs no locking.
+type RequestContext struct {
+	Subdomain string
+	Port      int            // local port the tunnel forwards to
+	Tunnel    *TunnelContext // state shared by all requests on this tunnel
+	Deadline  time.Time      // when the worker gives up on the response
+	// Streamed is set before AfterProxy when the response body is streamed
+	// to the visitor; AfterProxy then only sees status and headers.
+	Streamed bool
+	Values   map[string]any
+}
+
+// NewRequestContext creates a context for one request on tunnel.
+func NewRequestContext(tunnel *TunnelContext, deadline time.Time) *RequestContext {
+	return &RequestContext{
+		Subdomain: tunnel.Subdomain,
+		Port:      tunnel.Port,
+		Tunnel:    tunnel,
+		Deadline:  deadline,
+		Values:    make(map[string]any),
+	}
+}
 
 // RequestHook intercepts HTTP requests/responses flowing through the tunnel.
 type RequestHook interface {
-	BeforeProxy(req types.TunnelRequest) types.TunnelRequest
-	AfterProxy(req types.TunnelRequest, resp types.TunnelResponse) types.TunnelResponse
+	BeforeProxy(ctx *RequestContext, req types.TunnelRequest) types.TunnelRequest
+	AfterProxy(ctx *RequestContext, req types.TunnelRequest, resp types.TunnelResponse) types.TunnelResponse
+}
+
+// ShortCircuitHook is optionally implemented by request hooks that can answer
+// a request themselves (local auth, rate limiting, mocks). Respond is called
+// right after the hook's BeforeProxy; returning true skips the remaining
+// BeforeProxy hooks and the local server. AfterProxy still runs on every
+// hook, so the response is logged and decorated like a proxied one.
+type ShortCircuitHook interface {
+	Respond(ctx *RequestContext, req types.TunnelRequest) (types.TunnelResponse, bool)
+}
+
+// BodyTransformer is optionally implemented by request hooks that rewrite
+// bodies (compression, redaction, HTML injection). Bodies pass through as
+// they are proxied, streamed or not, so a transformer sees them in pieces
+// and never has to decode and re-encode the base64 strings BeforeProxy and
+// AfterProxy carry. Transformers run in hook order.
+type BodyTransformer interface {
+	// TransformRequest wraps the request body on its way to the local
+	// server, after BeforeProxy. Return body to leave it alone.
+	TransformRequest(ctx *RequestContext, req types.TunnelRequest, body io.Reader) io.Reader
+	// TransformResponse wraps w, which the response body is written to,
+	// once the local server's status and headers are known and before
+	// AfterProxy. header may be edited. Return nil to leave the body alone;
+	// otherwise the writer is closed after the last write, when it must
+	// flush into w (but not close it).
+	TransformResponse(ctx *RequestContext, req types.TunnelRequest, status int, header http.Header, w io.Writer) io.WriteCloser
+}
+
+// WarmupResult describes the warm-up requests sent to the local server when
+// a tunnel connects (-warmup).
+type WarmupResult struct {
+	Path    string
+	Status  int           // status of the first response; 0 if all failed
+	Conns   int           // requests that succeeded, each leaving a pooled connection
+	Latency time.Duration // slowest warm-up request
+	Err     error         // set when every request failed
 }
 
 // ConnectionHook observes tunnel lifecycle events.
 type ConnectionHook interface {
-	OnConnect(subdomain string, port int)
+	// OnConnect runs once the tunnel is up. warmup is nil unless warm-up is
+	// enabled, in which case it has already finished.
+	OnConnect(subdomain string, port int, warmup *WarmupResult)
 	OnDisconnect(subdomain string, err error)
 	OnRequest(subdomain string)
 }
 
+// RetryHook is optionally implemented by connection hooks that want to know
+// about reconnect attempts. OnRetry runs before waiting delay; attempt counts
+// from 1 since the last successful connection.
+type RetryHook interface {
+	OnRetry(subdomain string, attempt int, delay time.Duration, err error)
+}
+
+// UnhealthyHook is optionally implemented by connection hooks that want to
+// know when a tunnel connection is presumed dead because the worker stopped
+// answering keepalive pings. OnUnhealthy runs before the connection is
+// closed; OnDisconnect and a reconnect follow.
+type UnhealthyHook interface {
+	OnUnhealthy(subdomain string, missedPings int)
+}
+
+// TargetHealth is the result of probing the local server (-healthcheck).
+type TargetHealth struct {
+	Path    string
+	Healthy bool // the probe got a response below 500
+	Status  int  // 0 if the request failed
+	Latency time.Duration
+	Err     error // set when the request failed
+	Checked time.Time
+}
+
+// TargetHealthHook is optionally implemented by connection hooks that want
+// to know whether the local server is up. OnTargetHealth runs with the first
+// probe result after a tunnel starts and again whenever health changes.
+type TargetHealthHook interface {
+	OnTargetHealth(subdomain string, port int, health TargetHealth)
+}
+
+// ExpiryHook is optionally implemented by connection hooks that want a
+// warning before a tunnel is torn down by -ttl. OnExpiring runs once per
+// session, remaining before the end; renew reports whether a fresh
+// registration (likely under a new subdomain) follows.
+type ExpiryHook interface {
+	OnExpiring(subdomain string, remaining time.Duration, renew bool)
+}
+
+// ConfigUpdateHook is optionally implemented by connection hooks that want
+// to know when the worker changes a tunnel's config mid-session. config is
+// the whole config now in effect (the WorkerConfig keys of all plugins, with
+// the worker's overrides); hooks should re-apply the keys they own.
+type ConfigUpdateHook interface {
+	OnConfigUpdate(subdomain string, config map[string]any)
+}
+
+// ReservedPrefix is the path prefix on the public tunnel that the CLI serves
+// itself (via PathHandlers) instead of forwarding to the local port.
+const ReservedPrefix = "/_prodbd/"
+
+// PathHandler serves requests under ReservedPrefix.
+type PathHandler interface {
+	// ServePath returns a response and true if it handled req.
+	ServePath(ctx *RequestContext, req types.TunnelRequest) (types.TunnelResponse, bool)
+}
+
+// PathPlugin is optionally implemented by plugins that serve reserved paths.
+type PathPlugin interface {
+	PathHandlers() []PathHandler
+}
+
 // NoOpRequestHook is a convenience embed for hooks that only need one method.
 type NoOpRequestHook struct{}
 
-func (NoOpRequestHook) BeforeProxy(req types.TunnelRequest) types.TunnelRequest { return req }
-func (NoOpRequestHook) AfterProxy(_ types.TunnelRequest, resp types.TunnelResponse) types.TunnelResponse {
+func (NoOpRequestHook) BeforeProxy(_ *RequestContext, req types.TunnelRequest) types.TunnelRequest {
+	return req
+}
+func (NoOpRequestHook) AfterProxy(_ *RequestContext, _ types.TunnelRequest, resp types.TunnelResponse) types.TunnelResponse {
 	return resp
 }
 
 // NoOpConnectionHook is a convenience embed for hooks that only need one method.
 type NoOpConnectionHook struct{}
 
-func (NoOpConnectionHook) OnConnect(_ string, _ int)      {}
-func (NoOpConnectionHook) OnDisconnect(_ string, _ error) {}
-func (NoOpConnectionHook) OnRequest(_ string)             {}
+func (NoOpConnectionHook) OnConnect(_ string, _ int, _ *WarmupResult) {}
+func (NoOpConnectionHook) OnDisconnect(_ string, _ error)             {}
+func (NoOpConnectionHook) OnRequest(_ string)                         {}
 
 // --- Plugin interface ---
 
@@ -57,13 +242,97 @@ type Plugin interface {
 	ConnectionHooks() []ConnectionHook
 }
 
+// ReloadablePlugin is optionally implemented by plugins that can take new
+// flag values while tunnels run (config hot reload, see Pipeline.Reload).
+// Reload runs after the changed flags were set and must be safe with
+// requests in flight. WorkerConfig is re-sent by the caller afterwards.
+type ReloadablePlugin interface {
+	Plugin
+	Reload() error
+}
+
+// --- Presets ---
+
+// Preset is a named bundle of flag values that composes several plugins into
+// one mode (e.g. -mode internal-demo). Values only fill in flags the user
+// didn't set explicitly, so the command line always wins.
+type Preset struct {
+	Name        string
+	Description string
+	Flags       map[string]string // flag name (without dash) -> value
+	// Validate, if set, runs after the preset is applied and can refuse to
+	// start when a required piece is missing.
+	Validate func(fs *flag.FlagSet) error
+}
+
 // --- Pipeline ---
 
-// Pipeline runs registered hooks in order. Zero-value is ready to use.
+// Pipeline collects plugins and their hooks. Hooks run through the
+// per-tunnel view ForTunnel returns. Zero-value is ready to use.
 type Pipeline struct {
 	plugins   []Plugin
-	reqHooks  []RequestHook
-	connHooks []ConnectionHook
+	reqHooks  []namedRequestHook
+	connHooks []namedConnectionHook
+	pathHooks []namedPathHandler
+	presets   map[string]Preset
+	scopes    map[string][]int  // plugin name -> the only ports it runs for
+	owners    map[string]Plugin // flag name -> plugin that registered it
+
+	events Bus
+
+	tunnelsMu sync.Mutex
+	tunnels   map[string]*TunnelPipeline // keyed by subdomain
+
+	overheadMu sync.Mutex
+	overhead   map[string]*PluginOverhead // keyed by plugin name
+}
+
+// namedRequestHook remembers which plugin contributed a request hook,
+// so its execution time can be attributed.
+type namedRequestHook struct {
+	plugin string
+	RequestHook
+}
+
+// namedConnectionHook and namedPathHandler remember their plugin so
+// ForTunnel can leave out plugins scoped to other ports.
+type namedConnectionHook struct {
+	plugin string
+	ConnectionHook
+}
+
+type namedPathHandler struct {
+	plugin string
+	auth   bool // of an AuthPlugin: sign-in pages and callbacks, served ungated
+	PathHandler
+}
+
+// customHookName attributes hooks added directly via AddRequestHook.
+const customHookName = "custom"
+
+// PluginOverhead is the accumulated time a plugin's request hooks spent
+// in BeforeProxy + AfterProxy.
+type PluginOverhead struct {
+	Plugin string
+	Calls  int // hook invocations (BeforeProxy and AfterProxy counted separately)
+	Total  time.Duration
+	Max    time.Duration
+}
+
+// WireUsage is what a tunnel sent to and received from the worker this
+// session, counted on the wire: TLS records, WebSocket frames and the
+// JSON and base64 encoding of messages included.
+type WireUsage struct {
+	Subdomain   string
+	Sent        int64
+	Received    int64
+	Connections int // including reconnects
+}
+
+// Cost estimates the price of u's traffic at perGB per gigabyte (10^9
+// bytes) in either direction.
+func (u WireUsage) Cost(perGB float64) float64 {
+	return float64(u.Sent+u.Received) / 1e9 * perGB
 }
 
 // RegisterPlugin adds a plugin. Call before flag.Parse().
@@ -71,11 +340,176 @@ func (p *Pipeline) RegisterPlugin(pl Plugin) {
 	p.plugins = append(p.plugins, pl)
 }
 
+// RegisterPreset makes a preset available to ApplyPreset.
+func (p *Pipeline) RegisterPreset(ps Preset) {
+	if p.presets == nil {
+		p.presets = make(map[string]Preset)
+	}
+	p.presets[ps.Name] = ps
+}
+
+// Presets returns the registered presets sorted by name.
+func (p *Pipeline) Presets() []Preset {
+	out := make([]Preset, 0, len(p.presets))
+	for _, ps := range p.presets {
+		out = append(out, ps)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// ApplyPreset applies one or more comma-separated presets in order (later
+// ones win), skipping flags already set on the command line. Call after
+// fs.Parse() and before Activate().
+func (p *Pipeline) ApplyPreset(fs *flag.FlagSet, names string) error {
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	var applied []Preset
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		ps, ok := p.presets[name]
+		if !ok {
+			return fmt.Errorf("unknown preset %q", name)
+		}
+		values := make(map[string]string, len(ps.Flags))
+		for k, v := range ps.Flags {
+			if !explicit[k] {
+				values[k] = v
+			}
+		}
+		if err := Configure(fs, values); err != nil {
+			return fmt.Errorf("preset %s: %w", name, err)
+		}
+		applied = append(applied, ps)
+	}
+	for _, ps := range applied {
+		if ps.Validate != nil {
+			if err := ps.Validate(fs); err != nil {
+				return fmt.Errorf("preset %s: %w", ps.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Configure sets plugin flags programmatically, for callers that embed the
+// pipeline without a command line: register flags on fs with RegisterFlags,
+// then Configure(fs, values) instead of fs.Parse().
+func Configure(fs *flag.FlagSet, values map[string]string) error {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("unknown flag -%s", name)
+		}
+		if err := fs.Set(name, values[name]); err != nil {
+			return fmt.Errorf("-%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// EnvPrefix is prepended to a flag's env var name; see EnvName.
+const EnvPrefix = "PROD_"
+
+// EnvName returns the environment variable that sets a flag:
+// "dashboard-port" is PROD_DASHBOARD_PORT.
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// BindEnv sets every flag not given on the command line from its environment
+// variable, if present. Call after fs.Parse() and before ApplyPreset, so the
+// precedence is flag > env > preset or profile > default.
+func BindEnv(fs *flag.FlagSet) error {
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || explicit[f.Name] {
+			return
+		}
+		name := EnvName(f.Name)
+		if v, ok := os.LookupEnv(name); ok {
+			if e := fs.Set(f.Name, v); e != nil {
+				err = fmt.Errorf("invalid value %q for %s: %w", v, name, e)
+			}
+		}
+	})
+	return err
+}
+
 // RegisterFlags calls RegisterFlags on all plugins.
 func (p *Pipeline) RegisterFlags(fs *flag.FlagSet) {
+	if p.owners == nil {
+		p.owners = make(map[string]Plugin)
+	}
+	defined := map[string]bool{}
+	fs.VisitAll(func(f *flag.Flag) { defined[f.Name] = true })
 	for _, pl := range p.plugins {
 		pl.RegisterFlags(fs)
+		fs.VisitAll(func(f *flag.Flag) {
+			if !defined[f.Name] {
+				defined[f.Name] = true
+				p.owners[f.Name] = pl
+			}
+		})
+	}
+}
+
+// Reload applies changed flag values (flag name -> new value) while tunnels
+// run. Flags of plugins implementing ReloadablePlugin are set on fs and
+// those plugins reloaded. The names of the other changed flags, which only
+// take effect on a restart, are returned in restart and left as they were,
+// as are the flags of a plugin whose Reload fails.
+func (p *Pipeline) Reload(fs *flag.FlagSet, changed map[string]string) (restart []string, err error) {
+	byPlugin := map[ReloadablePlugin][]string{}
+	var order []ReloadablePlugin
+	for _, name := range slices.Sorted(maps.Keys(changed)) {
+		rp, ok := p.owners[name].(ReloadablePlugin)
+		if !ok {
+			restart = append(restart, name)
+			continue
+		}
+		if _, seen := byPlugin[rp]; !seen {
+			order = append(order, rp)
+		}
+		byPlugin[rp] = append(byPlugin[rp], name)
+	}
+
+	var errs []error
+	for _, rp := range order {
+		names := byPlugin[rp]
+		old := make(map[string]string, len(names))
+		var perr error
+		for _, name := range names {
+			old[name] = fs.Lookup(name).Value.String()
+			if perr = fs.Set(name, changed[name]); perr != nil {
+				perr = fmt.Errorf("-%s: %w", name, perr)
+				break
+			}
+		}
+		if perr == nil {
+			perr = rp.Reload()
+		}
+		if perr != nil {
+			for name, v := range old {
+				fs.Set(name, v)
+			}
+			restart = append(restart, names...)
+			errs = append(errs, fmt.Errorf("%s: %w", rp.Name(), perr))
+		}
 	}
+	slices.Sort(restart)
+	return restart, errors.Join(errs...)
 }
 
 // Activate checks which plugins are enabled after flag.Parse(),
@@ -86,10 +520,19 @@ func (p *Pipeline) Activate() {
 			continue
 		}
 		for _, h := range pl.RequestHooks() {
-			p.reqHooks = append(p.reqHooks, h)
+			p.reqHooks = append(p.reqHooks, namedRequestHook{plugin: pl.Name(), RequestHook: h})
 		}
 		for _, h := range pl.ConnectionHooks() {
-			p.connHooks = append(p.connHooks, h)
+			p.connHooks = append(p.connHooks, namedConnectionHook{plugin: pl.Name(), ConnectionHook: h})
+		}
+		if pp, ok := pl.(PathPlugin); ok {
+			_, auth := pl.(AuthPlugin)
+			for _, h := range pp.PathHandlers() {
+				p.pathHooks = append(p.pathHooks, namedPathHandler{plugin: pl.Name(), auth: auth, PathHandler: h})
+			}
+		}
+		if ep, ok := pl.(EventPlugin); ok {
+			ep.SubscribeEvents(&p.events)
 		}
 	}
 }
@@ -111,37 +554,328 @@ func (p *Pipeline) WorkerConfig() map[string]any {
 	return merged
 }
 
-func (p *Pipeline) AddRequestHook(h RequestHook)       { p.reqHooks = append(p.reqHooks, h) }
-func (p *Pipeline) AddConnectionHook(h ConnectionHook) { p.connHooks = append(p.connHooks, h) }
+func (p *Pipeline) AddRequestHook(h RequestHook) {
+	p.reqHooks = append(p.reqHooks, namedRequestHook{plugin: customHookName, RequestHook: h})
+}
+func (p *Pipeline) AddConnectionHook(h ConnectionHook) {
+	p.connHooks = append(p.connHooks, namedConnectionHook{plugin: customHookName, ConnectionHook: h})
+}
 
-func (p *Pipeline) RunBeforeProxy(req types.TunnelRequest) types.TunnelRequest {
-	for _, h := range p.reqHooks {
-		req = h.BeforeProxy(req)
+// ScopePlugin limits a plugin's hooks to the tunnels of ports; other tunnels
+// run without it. Call after flags are parsed and before Activate. Plugins
+// the worker enforces (WorkerConfig) apply to every port and can't be
+// scoped.
+func (p *Pipeline) ScopePlugin(name string, ports []int) error {
+	for _, pl := range p.plugins {
+		if pl.Name() != name {
+			continue
+		}
+		if pl.Enabled() && pl.WorkerConfig() != nil {
+			return fmt.Errorf("%s is enforced by the worker for every port and can't be scoped", name)
+		}
+		if p.scopes == nil {
+			p.scopes = make(map[string][]int)
+		}
+		p.scopes[name] = append(p.scopes[name], ports...)
+		return nil
 	}
-	return req
+	return fmt.Errorf("unknown plugin %q", name)
+}
+
+// inScope reports whether plugin's hooks run for the tunnel on port.
+func (p *Pipeline) inScope(plugin string, port int) bool {
+	ports, ok := p.scopes[plugin]
+	return !ok || slices.Contains(ports, port)
 }
 
-func (p *Pipeline) RunAfterProxy(req types.TunnelRequest, resp types.TunnelResponse) types.TunnelResponse {
+// Events returns the pipeline's event bus.
+func (p *Pipeline) Events() *Bus { return &p.events }
+
+// ForTunnel returns the pipeline of the tunnel on subdomain, creating it on
+// first use with the hooks of plugins in scope for port. Call after Activate;
+// hooks added later only reach tunnels created after them.
+func (p *Pipeline) ForTunnel(subdomain string, port int) *TunnelPipeline {
+	p.tunnelsMu.Lock()
+	defer p.tunnelsMu.Unlock()
+	if p.tunnels == nil {
+		p.tunnels = make(map[string]*TunnelPipeline)
+	}
+	t, ok := p.tunnels[subdomain]
+	if ok {
+		return t
+	}
+	t = &TunnelPipeline{parent: p, tunnel: NewTunnelContext(subdomain, port)}
 	for _, h := range p.reqHooks {
-		resp = h.AfterProxy(req, resp)
+		if p.inScope(h.plugin, port) {
+			t.reqHooks = append(t.reqHooks, h)
+		}
+	}
+	for _, h := range p.connHooks {
+		if p.inScope(h.plugin, port) {
+			t.connHooks = append(t.connHooks, h)
+		}
+	}
+	for _, h := range p.pathHooks {
+		if p.inScope(h.plugin, port) {
+			t.pathHooks = append(t.pathHooks, h)
+		}
+	}
+	p.tunnels[subdomain] = t
+	return t
+}
+
+// Tunnel returns the context for the tunnel on subdomain, creating it on
+// first use.
+func (p *Pipeline) Tunnel(subdomain string, port int) *TunnelContext {
+	return p.ForTunnel(subdomain, port).Tunnel()
+}
+
+func (p *Pipeline) recordOverhead(plugin string, d time.Duration) {
+	p.overheadMu.Lock()
+	defer p.overheadMu.Unlock()
+	if p.overhead == nil {
+		p.overhead = make(map[string]*PluginOverhead)
+	}
+	o, ok := p.overhead[plugin]
+	if !ok {
+		o = &PluginOverhead{Plugin: plugin}
+		p.overhead[plugin] = o
+	}
+	o.Calls++
+	o.Total += d
+	if d > o.Max {
+		o.Max = d
+	}
+}
+
+// Overhead returns a snapshot of per-plugin hook execution time, sorted by plugin name.
+func (p *Pipeline) Overhead() []PluginOverhead {
+	p.overheadMu.Lock()
+	defer p.overheadMu.Unlock()
+	out := make([]PluginOverhead, 0, len(p.overhead))
+	for _, o := range p.overhead {
+		out = append(out, *o)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Plugin < out[j].Plugin })
+	return out
+}
+
+// TunnelPipeline runs the hooks for one tunnel. Every hook call carries the
+// tunnel's identity, so plugins don't have to work it out from requests.
+type TunnelPipeline struct {
+	parent    *Pipeline
+	tunnel    *TunnelContext
+	reqHooks  []namedRequestHook
+	connHooks []namedConnectionHook
+	pathHooks []namedPathHandler
+}
+
+// Tunnel returns the tunnel's context.
+func (t *TunnelPipeline) Tunnel() *TunnelContext { return t.tunnel }
+
+// Events returns the event bus shared by all tunnels.
+func (t *TunnelPipeline) Events() *Bus { return t.parent.Events() }
+
+// NewRequest creates the context for one request on the tunnel.
+func (t *TunnelPipeline) NewRequest(deadline time.Time) *RequestContext {
+	return NewRequestContext(t.tunnel, deadline)
+}
+
+// ServeReserved offers a request under ReservedPrefix to the path handlers.
+// Returns false if none handled it (the request is then proxied as usual).
+// The auth plugins' paths (sign-in pages, callbacks) are open to everyone;
+// the others are behind the gates, which answer visitors they turn away.
+func (t *TunnelPipeline) ServeReserved(ctx *RequestContext, req types.TunnelRequest) (types.TunnelResponse, bool) {
+	if !strings.HasPrefix(req.Path, ReservedPrefix) {
+		return types.TunnelResponse{}, false
+	}
+	serve := func(auth bool, req types.TunnelRequest) (types.TunnelResponse, bool) {
+		for _, h := range t.pathHooks {
+			if h.auth != auth {
+				continue
+			}
+			if resp, ok := h.ServePath(ctx, req); ok {
+				resp.Type = types.TypeHTTPResponse
+				resp.ID = req.ID
+				return resp, true
+			}
+		}
+		return types.TunnelResponse{}, false
+	}
+	if resp, ok := serve(true, req); ok {
+		return resp, true
+	}
+	req, resp, admitted := t.Admit(ctx, req)
+	if !admitted {
+		return resp, true
+	}
+	return serve(false, req)
+}
+
+// Admit asks the tunnel's gates (see GateHook) about a visitor opening a
+// WebSocket or TCP stream, which skip the request hooks. It returns the
+// request to open the stream with, or the first gate's refusal and false.
+func (t *TunnelPipeline) Admit(ctx *RequestContext, req types.TunnelRequest) (types.TunnelRequest, types.TunnelResponse, bool) {
+	for _, h := range t.reqHooks {
+		g, ok := h.RequestHook.(GateHook)
+		if !ok {
+			continue
+		}
+		start := time.Now()
+		var resp types.TunnelResponse
+		var admitted bool
+		req, resp, admitted = g.Admit(ctx, req)
+		t.parent.recordOverhead(h.plugin, time.Since(start))
+		if !admitted {
+			resp.Type = types.TypeHTTPResponse
+			resp.ID = req.ID
+			return req, resp, false
+		}
+	}
+	return req, types.TunnelResponse{}, true
+}
+
+// RunBeforeProxy runs BeforeProxy on each hook. If a ShortCircuitHook answers
+// the request, it returns that response and true, and the request must not be
+// proxied; pass the response through RunAfterProxy as usual.
+func (t *TunnelPipeline) RunBeforeProxy(ctx *RequestContext, req types.TunnelRequest) (types.TunnelRequest, types.TunnelResponse, bool) {
+	for _, h := range t.reqHooks {
+		start := time.Now()
+		req = h.BeforeProxy(ctx, req)
+		var resp types.TunnelResponse
+		var answered bool
+		if sc, ok := h.RequestHook.(ShortCircuitHook); ok {
+			resp, answered = sc.Respond(ctx, req)
+		}
+		t.parent.recordOverhead(h.plugin, time.Since(start))
+		if answered {
+			resp.Type = types.TypeHTTPResponse
+			resp.ID = req.ID
+			return req, resp, true
+		}
+	}
+	return req, types.TunnelResponse{}, false
+}
+
+// RequestBody runs the hooks' TransformRequest over body.
+func (t *TunnelPipeline) RequestBody(ctx *RequestContext, req types.TunnelRequest, body io.Reader) io.Reader {
+	for _, h := range t.reqHooks {
+		if bt, ok := h.RequestHook.(BodyTransformer); ok {
+			body = bt.TransformRequest(ctx, req, body)
+		}
+	}
+	return body
+}
+
+// ResponseBody puts the hooks' TransformResponse writers in front of w, the
+// first hook's first. It returns nil if none wants the body; otherwise
+// close the writer after the last write to flush every transformer.
+func (t *TunnelPipeline) ResponseBody(ctx *RequestContext, req types.TunnelRequest, status int, header http.Header, w io.Writer) io.WriteCloser {
+	var chain []io.WriteCloser
+	for _, h := range slices.Backward(t.reqHooks) {
+		bt, ok := h.RequestHook.(BodyTransformer)
+		if !ok {
+			continue
+		}
+		if tw := bt.TransformResponse(ctx, req, status, header, w); tw != nil {
+			chain = append(chain, tw)
+			w = tw
+		}
+	}
+	if len(chain) == 0 {
+		return nil
+	}
+	slices.Reverse(chain)
+	return &bodyChain{Writer: w, chain: chain}
+}
+
+// bodyChain closes transformers outermost first, so each flushes into the
+// next before that one is closed.
+type bodyChain struct {
+	io.Writer
+	chain []io.WriteCloser
+}
+
+func (c *bodyChain) Close() error {
+	var errs []error
+	for _, w := range c.chain {
+		errs = append(errs, w.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (t *TunnelPipeline) RunAfterProxy(ctx *RequestContext, req types.TunnelRequest, resp types.TunnelResponse) types.TunnelResponse {
+	for _, h := range t.reqHooks {
+		start := time.Now()
+		resp = h.AfterProxy(ctx, req, resp)
+		t.parent.recordOverhead(h.plugin, time.Since(start))
 	}
 	return resp
 }
 
-func (p *Pipeline) NotifyConnect(subdomain string, port int) {
-	for _, h := range p.connHooks {
-		h.OnConnect(subdomain, port)
+func (t *TunnelPipeline) NotifyConnect(warmup *WarmupResult) {
+	for _, h := range t.connHooks {
+		h.OnConnect(t.tunnel.Subdomain, t.tunnel.Port, warmup)
 	}
 }
 
-func (p *Pipeline) NotifyDisconnect(subdomain string, err error) {
-	for _, h := range p.connHooks {
-		h.OnDisconnect(subdomain, err)
+func (t *TunnelPipeline) NotifyDisconnect(err error) {
+	for _, h := range t.connHooks {
+		h.OnDisconnect(t.tunnel.Subdomain, err)
 	}
 }
 
-func (p *Pipeline) NotifyRequest(subdomain string) {
-	for _, h := range p.connHooks {
-		h.OnRequest(subdomain)
+// NotifyRetry tells connection hooks implementing RetryHook about a reconnect.
+func (t *TunnelPipeline) NotifyRetry(attempt int, delay time.Duration, err error) {
+	for _, h := range t.connHooks {
+		if rh, ok := h.ConnectionHook.(RetryHook); ok {
+			rh.OnRetry(t.tunnel.Subdomain, attempt, delay, err)
+		}
+	}
+}
+
+// NotifyUnhealthy tells connection hooks implementing UnhealthyHook that the
+// connection stopped answering keepalive pings.
+func (t *TunnelPipeline) NotifyUnhealthy(missedPings int) {
+	for _, h := range t.connHooks {
+		if uh, ok := h.ConnectionHook.(UnhealthyHook); ok {
+			uh.OnUnhealthy(t.tunnel.Subdomain, missedPings)
+		}
+	}
+}
+
+// NotifyTargetHealth tells connection hooks implementing TargetHealthHook
+// about the local server's health.
+func (t *TunnelPipeline) NotifyTargetHealth(health TargetHealth) {
+	for _, h := range t.connHooks {
+		if th, ok := h.ConnectionHook.(TargetHealthHook); ok {
+			th.OnTargetHealth(t.tunnel.Subdomain, t.tunnel.Port, health)
+		}
+	}
+}
+
+// NotifyExpiring tells connection hooks implementing ExpiryHook that the
+// tunnel's -ttl is about to run out.
+func (t *TunnelPipeline) NotifyExpiring(remaining time.Duration, renew bool) {
+	for _, h := range t.connHooks {
+		if eh, ok := h.ConnectionHook.(ExpiryHook); ok {
+			eh.OnExpiring(t.tunnel.Subdomain, remaining, renew)
+		}
+	}
+}
+
+// NotifyConfigUpdate tells connection hooks implementing ConfigUpdateHook
+// that the worker changed the tunnel's config.
+func (t *TunnelPipeline) NotifyConfigUpdate(config map[string]any) {
+	for _, h := range t.connHooks {
+		if ch, ok := h.ConnectionHook.(ConfigUpdateHook); ok {
+			ch.OnConfigUpdate(t.tunnel.Subdomain, config)
+		}
+	}
+}
+
+func (t *TunnelPipeline) NotifyRequest() {
+	for _, h := range t.connHooks {
+		h.OnRequest(t.tunnel.Subdomain)
 	}
 }
@@ -0,0 +1,303 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+// Middleware is the standard net/http middleware shape.
+type Middleware func(http.Handler) http.Handler
+
+// FromMiddleware adapts a net/http middleware (gzip, secure headers, request
+// logging, ...) into a RequestHook.
+//
+// The middleware runs once per request, spanning the proxy call: BeforeProxy
+// starts it and forwards whatever request it passes to next; the handler
+// behind next blocks until AfterProxy supplies the local response, and what
+// the middleware finally writes becomes the tunnel response.
+//
+// A middleware that answers without calling next (auth, rate limiting)
+// short-circuits the request: it never reaches the local server. When
+// another hook short-circuits instead, the middleware sees that hook's
+// response as the local one. Streamed responses (ctx.Streamed) pass through
+// untouched, since the middleware would only see headers. If AfterProxy
+// never comes, the handler behind next gives up at ctx.Deadline, so the
+// middleware's goroutine always returns.
+func FromMiddleware(mw Middleware) RequestHook {
+	h := &middlewareHook{mw: mw}
+	h.key = fmt.Sprintf("hooks.middleware.%p", h)
+	return h
+}
+
+type middlewareHook struct {
+	mw  Middleware
+	key string // ctx.Values key, unique per adapter
+}
+
+// middlewareRun is one in-flight middleware invocation, kept in ctx.Values.
+type middlewareRun struct {
+	resp chan types.TunnelResponse // AfterProxy -> inner handler
+	done chan *responseRecorder    // middleware finished writing
+}
+
+// give hands the inner handler resp and waits for the middleware to finish.
+func (run *middlewareRun) give(resp types.TunnelResponse) *responseRecorder {
+	run.resp <- resp
+	return <-run.done
+}
+
+func (h *middlewareHook) BeforeProxy(ctx *RequestContext, req types.TunnelRequest) types.TunnelRequest {
+	httpReq, err := ToHTTPRequest(req)
+	if err != nil {
+		return req
+	}
+
+	run := &middlewareRun{
+		resp: make(chan types.TunnelResponse, 1),
+		done: make(chan *responseRecorder, 1),
+	}
+	forwarded := make(chan types.TunnelRequest, 1)
+
+	var expired <-chan time.Time
+	var timer *time.Timer
+	if !ctx.Deadline.IsZero() {
+		timer = time.NewTimer(time.Until(ctx.Deadline))
+		expired = timer.C
+	}
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case forwarded <- FromHTTPRequest(r, req.ID):
+		default:
+			// next called twice; only the first call is proxied
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		select {
+		case resp := <-run.resp:
+			writeTunnelResponse(w, resp)
+		case <-expired:
+			w.WriteHeader(http.StatusGatewayTimeout)
+		}
+	})
+
+	go func() {
+		rec := newResponseRecorder()
+		h.mw(inner).ServeHTTP(rec, httpReq)
+		if timer != nil {
+			timer.Stop()
+		}
+		run.done <- rec
+	}()
+
+	select {
+	case fwd := <-forwarded:
+		ctx.Values[h.key] = run
+		return fwd
+	case rec := <-run.done:
+		// Answered without calling next; Respond short-circuits with it
+		ctx.Values[h.key] = rec
+		return req
+	}
+}
+
+func (h *middlewareHook) Respond(ctx *RequestContext, req types.TunnelRequest) (types.TunnelResponse, bool) {
+	rec, ok := ctx.Values[h.key].(*responseRecorder)
+	if !ok {
+		return types.TunnelResponse{}, false
+	}
+	delete(ctx.Values, h.key)
+	return rec.tunnelResponse(types.TunnelResponse{ID: req.ID}), true
+}
+
+func (h *middlewareHook) AfterProxy(ctx *RequestContext, req types.TunnelRequest, resp types.TunnelResponse) types.TunnelResponse {
+	switch v := ctx.Values[h.key].(type) {
+	case *responseRecorder:
+		return v.tunnelResponse(resp)
+	case *middlewareRun:
+		if ctx.Streamed {
+			// Unblock the middleware with the headers only and discard its output
+			head := resp
+			head.Body = ""
+			v.give(head)
+			return resp
+		}
+		return v.give(resp).tunnelResponse(resp)
+	}
+	return resp
+}
+
+// ToMiddleware adapts a RequestHook into a net/http middleware, so tunnel
+// hooks can be reused in ordinary Go servers. The subdomain is taken from the
+// first label of the Host header; each request gets a fresh TunnelContext.
+func ToMiddleware(h RequestHook) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			deadline, _ := r.Context().Deadline()
+			subdomain, _, _ := strings.Cut(r.Host, ".")
+			ctx := NewRequestContext(NewTunnelContext(subdomain, 0), deadline)
+
+			req := h.BeforeProxy(ctx, FromHTTPRequest(r, ""))
+			if sc, ok := h.(ShortCircuitHook); ok {
+				if resp, answered := sc.Respond(ctx, req); answered {
+					writeTunnelResponse(w, h.AfterProxy(ctx, req, resp))
+					return
+				}
+			}
+			httpReq, err := ToHTTPRequest(req)
+			if err != nil {
+				http.Error(w, "invalid request", http.StatusBadRequest)
+				return
+			}
+			httpReq = httpReq.WithContext(r.Context())
+			httpReq.RemoteAddr = r.RemoteAddr
+
+			rec := newResponseRecorder()
+			next.ServeHTTP(rec, httpReq)
+			resp := h.AfterProxy(ctx, req, rec.tunnelResponse(types.TunnelResponse{ID: req.ID}))
+			writeTunnelResponse(w, resp)
+		})
+	}
+}
+
+// ToHTTPRequest builds an *http.Request from a tunnel request.
+func ToHTTPRequest(req types.TunnelRequest) (*http.Request, error) {
+	var body io.Reader = http.NoBody
+	if req.Body != "" {
+		decoded, err := base64.StdEncoding.DecodeString(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(decoded)
+	}
+	httpReq, err := http.NewRequestWithContext(context.Background(), req.Method, "http://tunnel"+req.Path, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, vals := range req.Headers {
+		httpReq.Header[http.CanonicalHeaderKey(k)] = vals
+	}
+	if host := httpReq.Header.Get("Host"); host != "" {
+		httpReq.Host = host
+	}
+	return httpReq, nil
+}
+
+// FromHTTPRequest converts an *http.Request back into a tunnel request.
+func FromHTTPRequest(r *http.Request, id string) types.TunnelRequest {
+	req := types.TunnelRequest{
+		Type:    types.TypeHTTPRequest,
+		ID:      id,
+		Method:  r.Method,
+		Path:    r.URL.RequestURI(),
+		Headers: maps.Clone(map[string][]string(r.Header)),
+	}
+	if req.Headers == nil {
+		req.Headers = map[string][]string{}
+	}
+	if r.Host != "" {
+		req.Headers["Host"] = []string{r.Host}
+	}
+	if r.Body != nil {
+		if data, err := io.ReadAll(r.Body); err == nil && len(data) > 0 {
+			req.Body = base64.StdEncoding.EncodeToString(data)
+		}
+	}
+	return req
+}
+
+// writeTunnelResponse writes a tunnel response to an http.ResponseWriter.
+func writeTunnelResponse(w http.ResponseWriter, resp types.TunnelResponse) {
+	for k, vals := range resp.Headers {
+		w.Header()[k] = vals
+	}
+	status := resp.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	if resp.Body != "" {
+		if data, err := base64.StdEncoding.DecodeString(resp.Body); err == nil {
+			w.Write(data)
+		}
+	}
+}
+
+// responseRecorder is a minimal http.ResponseWriter that buffers the response.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newResponseRecorder() *responseRecorder {
+	return &responseRecorder{header: http.Header{}}
+}
+
+func (r *responseRecorder) Header() http.Header { return r.header }
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(p)
+}
+func (r *responseRecorder) Flush() {}
+
+// tunnelResponse converts the recording, keeping base's type and ID.
+func (r *responseRecorder) tunnelResponse(base types.TunnelResponse) types.TunnelResponse {
+	status := r.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	headers := maps.Clone(map[string][]string(r.header))
+	delete(headers, "Content-Length") // recomputed by the worker
+	out := types.TunnelResponse{
+		Type:    base.Type,
+		ID:      base.ID,
+		Status:  status,
+		Headers: headers,
+	}
+	if out.Type == "" {
+		out.Type = types.TypeHTTPResponse
+	}
+	if r.body.Len() > 0 {
+		out.Body = base64.StdEncoding.EncodeToString(r.body.Bytes())
+	}
+	return out
+}
+
+// FromHandler adapts a net/http handler into a PathHandler for the paths
+// under prefix, which should start with ReservedPrefix. The handler sees the
+// full path; its response is buffered, so it can't stream.
+func FromHandler(prefix string, h http.Handler) PathHandler {
+	return &handlerPath{prefix: prefix, h: h}
+}
+
+type handlerPath struct {
+	prefix string
+	h      http.Handler
+}
+
+func (hp *handlerPath) ServePath(_ *RequestContext, req types.TunnelRequest) (types.TunnelResponse, bool) {
+	if path, _, _ := strings.Cut(req.Path, "?"); !strings.HasPrefix(path, hp.prefix) {
+		return types.TunnelResponse{}, false
+	}
+	httpReq, err := ToHTTPRequest(req)
+	if err != nil {
+		return types.TunnelResponse{Status: http.StatusBadRequest}, true
+	}
+	rec := newResponseRecorder()
+	hp.h.ServeHTTP(rec, httpReq)
+	return rec.tunnelResponse(types.TunnelResponse{}), true
+}
@@ -0,0 +1,108 @@
+package hooks
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+func TestFromHandler(t *testing.T) {
+	h := FromHandler(ReservedPrefix+"echo", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Length", "99")
+		w.Header().Set("X-Seen", r.Method+" "+r.URL.RequestURI()+" "+r.Header.Get("X-In"))
+		w.WriteHeader(http.StatusCreated)
+		w.Write(body)
+	}))
+
+	req := types.TunnelRequest{
+		Method:  "POST",
+		Path:    ReservedPrefix + "echo/1?x=2",
+		Headers: map[string][]string{"x-in": {"yes"}},
+		Body:    base64.StdEncoding.EncodeToString([]byte("hi")),
+	}
+	resp, ok := h.ServePath(nil, req)
+	if !ok || resp.Type != types.TypeHTTPResponse || resp.Status != http.StatusCreated || resp.Body != req.Body {
+		t.Fatalf("ServePath = %+v, %v", resp, ok)
+	}
+	if got := resp.Headers["X-Seen"]; len(got) != 1 || got[0] != "POST /_prodbd/echo/1?x=2 yes" {
+		t.Errorf("handler saw %v", got)
+	}
+	if resp.Headers["Content-Length"] != nil {
+		t.Error("Content-Length kept; the worker sets it")
+	}
+
+	if _, ok := h.ServePath(nil, types.TunnelRequest{Method: "GET", Path: "/echo"}); ok {
+		t.Error("served a path outside its prefix")
+	}
+	req.Body = "%%%"
+	if resp, _ := h.ServePath(nil, req); resp.Status != http.StatusBadRequest {
+		t.Errorf("bad body answered %d", resp.Status)
+	}
+}
+
+// settles waits for the goroutine count to drop back to want.
+func settles(want int) bool {
+	for range 100 {
+		if runtime.NumGoroutine() <= want {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+// forbid answers every request with 403.
+type forbid struct{ NoOpRequestHook }
+
+func (forbid) Respond(*RequestContext, types.TunnelRequest) (types.TunnelResponse, bool) {
+	return types.TunnelResponse{Status: http.StatusForbidden}, true
+}
+
+func TestFromMiddlewareShortCircuited(t *testing.T) {
+	var seen int
+	mw := FromMiddleware(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := newResponseRecorder()
+			next.ServeHTTP(rec, r)
+			seen = rec.status
+			w.WriteHeader(rec.status)
+		})
+	})
+	var p Pipeline
+	p.RegisterPlugin(&testPlugin{name: "gzip", reqHooks: []RequestHook{mw}})
+	p.RegisterPlugin(&testPlugin{name: "ratelimit", reqHooks: []RequestHook{forbid{}}})
+	p.Activate()
+	tp := p.ForTunnel("abc", 3000)
+
+	base := runtime.NumGoroutine()
+	ctx := tp.NewRequest(time.Time{})
+	req := types.TunnelRequest{ID: "r1", Method: "GET", Path: "/"}
+	req, resp, answered := tp.RunBeforeProxy(ctx, req)
+	if !answered {
+		t.Fatal("request not short-circuited")
+	}
+	resp = tp.RunAfterProxy(ctx, req, resp)
+	if resp.Status != http.StatusForbidden || seen != http.StatusForbidden {
+		t.Fatalf("answered %d, middleware saw %d", resp.Status, seen)
+	}
+	if !settles(base) {
+		t.Fatal("middleware goroutine still running after the request was answered")
+	}
+}
+
+func TestFromMiddlewareGivesUpAtDeadline(t *testing.T) {
+	h := FromMiddleware(func(next http.Handler) http.Handler { return next })
+	base := runtime.NumGoroutine()
+	ctx := NewRequestContext(NewTunnelContext("abc", 3000), time.Now().Add(50*time.Millisecond))
+	h.BeforeProxy(ctx, types.TunnelRequest{ID: "r1", Method: "GET", Path: "/"})
+	// AfterProxy never comes
+	if !settles(base) {
+		t.Fatal("middleware goroutine still running past the deadline")
+	}
+}
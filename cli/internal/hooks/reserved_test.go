@@ -0,0 +1,94 @@
+package hooks
+
+import (
+	"flag"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+// testPlugin is a plugin made of whatever hooks a test gives it.
+type testPlugin struct {
+	name     string
+	reqHooks []RequestHook
+	paths    []PathHandler
+}
+
+func (p *testPlugin) Name() string                      { return p.name }
+func (p *testPlugin) RegisterFlags(*flag.FlagSet)       {}
+func (p *testPlugin) Enabled() bool                     { return true }
+func (p *testPlugin) WorkerConfig() map[string]any      { return nil }
+func (p *testPlugin) RequestHooks() []RequestHook       { return p.reqHooks }
+func (p *testPlugin) ConnectionHooks() []ConnectionHook { return nil }
+func (p *testPlugin) PathHandlers() []PathHandler       { return p.paths }
+
+// testAuthPlugin is a testPlugin that is also an auth scheme.
+type testAuthPlugin struct{ testPlugin }
+
+func (p *testAuthPlugin) Authenticator() Authenticator { return nil }
+
+// pathFunc serves one path with a fixed status.
+type pathFunc struct {
+	path   string
+	status int
+}
+
+func (h pathFunc) ServePath(_ *RequestContext, req types.TunnelRequest) (types.TunnelResponse, bool) {
+	if req.Path != h.path {
+		return types.TunnelResponse{}, false
+	}
+	return types.TunnelResponse{Status: h.status}, true
+}
+
+// headerGate admits requests carrying X-Let-In.
+type headerGate struct{ NoOpRequestHook }
+
+func (headerGate) Admit(_ *RequestContext, req types.TunnelRequest) (types.TunnelRequest, types.TunnelResponse, bool) {
+	if CanonicalHeader(req.Headers).Get("X-Let-In") == "" {
+		return req, types.TunnelResponse{Status: http.StatusUnauthorized}, false
+	}
+	return req, types.TunnelResponse{}, true
+}
+
+func TestServeReservedGatesAllButSignIn(t *testing.T) {
+	var p Pipeline
+	// Registered first, as the stats plugin is
+	p.RegisterPlugin(&testPlugin{name: "stats", paths: []PathHandler{pathFunc{ReservedPrefix + "stats", http.StatusOK}}})
+	p.RegisterPlugin(&testAuthPlugin{testPlugin{name: "password", paths: []PathHandler{pathFunc{ReservedPrefix + "password/login", http.StatusOK}}}})
+	p.RegisterPlugin(&testPlugin{name: "access", reqHooks: []RequestHook{headerGate{}}})
+	p.Activate()
+	tp := p.ForTunnel("abc", 3000)
+
+	serve := func(path string, letIn bool) (int, bool) {
+		req := types.TunnelRequest{ID: "r1", Method: "GET", Path: path, Headers: map[string][]string{}}
+		if letIn {
+			req.Headers["X-Let-In"] = []string{"1"}
+		}
+		resp, ok := tp.ServeReserved(tp.NewRequest(time.Now().Add(time.Minute)), req)
+		if ok && (resp.ID != "r1" || resp.Type != types.TypeHTTPResponse) {
+			t.Errorf("%s: response not addressed to the request: %+v", path, resp)
+		}
+		return resp.Status, ok
+	}
+
+	tests := []struct {
+		path    string
+		letIn   bool
+		status  int
+		handled bool
+	}{
+		{ReservedPrefix + "password/login", false, http.StatusOK, true},
+		{ReservedPrefix + "stats", false, http.StatusUnauthorized, true},
+		{ReservedPrefix + "stats", true, http.StatusOK, true},
+		{ReservedPrefix + "nothing", true, 0, false},
+		{"/stats", false, 0, false},
+	}
+	for _, tt := range tests {
+		status, handled := serve(tt.path, tt.letIn)
+		if status != tt.status || handled != tt.handled {
+			t.Errorf("%s (let in %v): %d %v, want %d %v", tt.path, tt.letIn, status, handled, tt.status, tt.handled)
+		}
+	}
+}
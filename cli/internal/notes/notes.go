@@ -0,0 +1,126 @@
+package notes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/storage"
+)
+
+// Note is an annotation left on the dashboard. It is attached either to a
+// single logged request (RequestID) or to a time range (From..To).
+type Note struct {
+	ID        int    `json:"id"`
+	Subdomain string `json:"subdomain,omitempty"`
+	Author    string `json:"author,omitempty"`
+	Text      string `json:"text"`
+	RequestID int    `json:"request_id,omitempty"`
+	From      int64  `json:"from,omitempty"` // unix seconds
+	To        int64  `json:"to,omitempty"`   // unix seconds
+	CreatedAt int64  `json:"created_at"`
+}
+
+// notesKey is the document holding all notes, at the top level of
+// storage.Default (~/.prod/notes.json).
+const notesKey = "notes"
+
+// Store holds session notes and persists them to storage.Default.
+// Safe for concurrent use.
+type Store struct {
+	mu      sync.RWMutex
+	backend storage.Documents
+	notes   []Note
+	nextID  int
+}
+
+// Open loads the saved notes, or returns an empty store if there are none yet.
+func Open() (*Store, error) {
+	backend, err := storage.Default()
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{backend: backend}
+
+	data, err := backend.Get("", notesKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notes: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.notes); err != nil {
+		return nil, fmt.Errorf("failed to decode notes: %w", err)
+	}
+	for _, n := range s.notes {
+		if n.ID > s.nextID {
+			s.nextID = n.ID
+		}
+	}
+	return s, nil
+}
+
+// Add validates and stores a note, assigning its ID and timestamp.
+func (s *Store) Add(n Note) (Note, error) {
+	if n.Text == "" {
+		return Note{}, errors.New("note text is required")
+	}
+	if n.From != 0 && n.To != 0 && n.To < n.From {
+		return Note{}, errors.New("note range ends before it starts")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	n.ID = s.nextID
+	n.CreatedAt = time.Now().Unix()
+	s.notes = append(s.notes, n)
+	if err := s.save(); err != nil {
+		s.notes = s.notes[:len(s.notes)-1]
+		return Note{}, err
+	}
+	return n, nil
+}
+
+// Delete removes a note by ID. Returns false if no such note exists.
+func (s *Store) Delete(id int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, n := range s.notes {
+		if n.ID == id {
+			s.notes = append(s.notes[:i], s.notes[i+1:]...)
+			return true, s.save()
+		}
+	}
+	return false, nil
+}
+
+// List returns notes for a subdomain (all subdomains if empty), oldest first.
+func (s *Store) List(subdomain string) []Note {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Note, 0, len(s.notes))
+	for _, n := range s.notes {
+		if subdomain != "" && n.Subdomain != subdomain {
+			continue
+		}
+		out = append(out, n)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].CreatedAt < out[j].CreatedAt })
+	return out
+}
+
+// save writes all notes. Caller must hold s.mu.
+func (s *Store) save() error {
+	data, err := json.MarshalIndent(s.notes, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := s.backend.Put("", notesKey, data); err != nil {
+		return fmt.Errorf("failed to write notes: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package access
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+// UserHeader tells the local server who the visitor authenticated as.
+// Visitors can't set it.
+const UserHeader = "X-Forwarded-User"
+
+// decisionKey holds the gate's verdict in RequestContext.Values between
+// BeforeProxy and Respond.
+const decisionKey = "access.decision"
+
+// Plugin is the gate in front of the auth plugins (-auth, -allow-ip,
+// -password, -jwt-secret, -oauth-provider, -magic-links, -totp). Each
+// contributes a hooks.Authenticator; the gate lets a request through if the
+// policy passes, and otherwise answers with a challenge. WebSockets and TCP
+// streams are held to the same policy (hooks.GateHook) and closed with 1008
+// when it fails, as they can't be challenged. By default every
+// scheme the CLI enforces must pass, while the worker keeps enforcing
+// -auth and -allow-ip; -access combines schemes freely. The worker keeps
+// those two where -access requires them anyway, and they only move into
+// the CLI when they are one of several ways in; the CLI then gates every
+// way in: HTTP, reserved paths, WebSockets and TCP streams.
+type Plugin struct {
+	expr    string
+	plugins func() []hooks.AuthPlugin
+
+	// policy passes if every authenticator of any one group does
+	policy [][]hooks.Authenticator
+}
+
+// New returns the gate; plugins lists the enabled auth plugins.
+func New(plugins func() []hooks.AuthPlugin) *Plugin {
+	return &Plugin{plugins: plugins}
+}
+
+func (p *Plugin) Name() string { return "access" }
+func (p *Plugin) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&p.expr, "access", "", "Combine auth schemes: names joined with + (all must pass) and | (either may), e.g. ip|password; + binds tighter. Schemes: basic (-auth), ip (-allow-ip), password, jwt, oauth, link (-magic-links), totp")
+}
+func (p *Plugin) Enabled() bool                           { return len(p.policy) > 0 }
+func (p *Plugin) WorkerConfig() map[string]any            { return nil }
+func (p *Plugin) ConnectionHooks() []hooks.ConnectionHook { return nil }
+func (p *Plugin) RequestHooks() []hooks.RequestHook {
+	return []hooks.RequestHook{&reqHook{plugin: p}}
+}
+
+// Validate builds the policy from -access and the enabled auth plugins.
+// Call after flags are parsed and the auth plugins validated, before
+// registering.
+func (p *Plugin) Validate() error {
+	byName := map[string]hooks.AuthPlugin{}
+	var names []string
+	for _, ap := range p.plugins() {
+		name := ap.Authenticator().Name()
+		byName[name] = ap
+		names = append(names, name)
+	}
+
+	if strings.TrimSpace(p.expr) == "" {
+		// Everything the CLI enforces; the worker has the rest
+		var all []hooks.Authenticator
+		for _, name := range names {
+			if _, worker := byName[name].(hooks.WorkerAuthPlugin); !worker {
+				all = append(all, byName[name].Authenticator())
+			}
+		}
+		p.policy = nil
+		if len(all) > 0 {
+			p.policy = [][]hooks.Authenticator{all}
+		}
+		return nil
+	}
+
+	var groups [][]string
+	used := map[string]int{} // groups a scheme is in
+	for _, alt := range strings.Split(p.expr, "|") {
+		var group []string
+		for _, name := range strings.Split(alt, "+") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if _, ok := byName[name]; !ok {
+				return fmt.Errorf("-access: %q isn't an enabled auth scheme (enabled: %s)", name, strings.Join(names, ", "))
+			}
+			if !slices.Contains(group, name) {
+				group = append(group, name)
+				used[name]++
+			}
+		}
+		if secondOnly(group, byName) {
+			return fmt.Errorf("-access: %s is a second factor; pair it with another scheme, e.g. password+%s", strings.Join(group, "+"), group[0])
+		}
+		groups = append(groups, group)
+	}
+	for _, name := range names {
+		if used[name] == 0 {
+			return fmt.Errorf("-access leaves out %s, which is enabled; add it or turn it off", name)
+		}
+	}
+
+	// A worker scheme every group requires stays with the worker, which
+	// guards everything it relays; only one that is optional moves here
+	atWorker := map[string]bool{}
+	for _, name := range names {
+		wp, ok := byName[name].(hooks.WorkerAuthPlugin)
+		if !ok {
+			continue
+		}
+		if used[name] == len(groups) {
+			atWorker[name] = true
+			continue
+		}
+		if err := wp.EnforceLocally(); err != nil {
+			return fmt.Errorf("-access: %s: %w", name, err)
+		}
+	}
+	var policy [][]hooks.Authenticator
+	for _, group := range groups {
+		var authenticators []hooks.Authenticator
+		for _, name := range group {
+			if !atWorker[name] {
+				authenticators = append(authenticators, byName[name].Authenticator())
+			}
+		}
+		if len(authenticators) == 0 {
+			// Whoever the worker lets through may go on
+			policy = nil
+			break
+		}
+		policy = append(policy, authenticators)
+	}
+	p.policy = policy
+	return nil
+}
+
+// secondOnly reports whether every scheme of group is a second factor.
+func secondOnly(group []string, byName map[string]hooks.AuthPlugin) bool {
+	for _, name := range group {
+		if _, ok := byName[name].(hooks.SecondFactor); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Schemes describes the policy for the startup banner, e.g. "ip | password".
+func (p *Plugin) Schemes() string {
+	alts := make([]string, len(p.policy))
+	for i, group := range p.policy {
+		names := make([]string, len(group))
+		for j, a := range group {
+			names[j] = a.Name()
+		}
+		alts[i] = strings.Join(names, " + ")
+	}
+	return strings.Join(alts, " | ")
+}
+
+// decision is the outcome of the policy for one request.
+type decision struct {
+	allowed bool
+	failed  []hooks.Authenticator // per group, the first that failed
+}
+
+// evaluate runs the policy on req and returns the verdict and the
+// identity of the group that passed.
+func (p *Plugin) evaluate(ctx *hooks.RequestContext, req types.TunnelRequest) (decision, string) {
+	var d decision
+	for _, group := range p.policy {
+		identity := ""
+		var failed hooks.Authenticator
+		for _, a := range group {
+			id, ok := a.Verify(ctx, req)
+			if !ok {
+				failed = a
+				break
+			}
+			if identity == "" {
+				identity = id
+			}
+		}
+		if failed == nil {
+			return decision{allowed: true}, identity
+		}
+		if !slices.Contains(d.failed, failed) {
+			d.failed = append(d.failed, failed)
+		}
+	}
+	return d, ""
+}
+
+type reqHook struct {
+	hooks.NoOpRequestHook
+	plugin *Plugin
+}
+
+// BeforeProxy decides on the request and passes who the visitor is to the
+// local server, keeping session cookies to ourselves.
+func (h *reqHook) BeforeProxy(ctx *hooks.RequestContext, req types.TunnelRequest) types.TunnelRequest {
+	d, identity := h.plugin.evaluate(ctx, req)
+	ctx.Values[decisionKey] = d
+	req = hooks.StripSessionCookies(req)
+	header := http.Header(req.Headers)
+	header.Del(UserHeader)
+	if identity != "" {
+		header.Set(UserHeader, identity)
+	}
+	return req
+}
+
+// Respond challenges visitors the policy turned away, with the challenge
+// of the first scheme in -access they failed that has one.
+func (h *reqHook) Respond(ctx *hooks.RequestContext, req types.TunnelRequest) (types.TunnelResponse, bool) {
+	d, _ := ctx.Values[decisionKey].(decision)
+	if d.allowed {
+		return types.TunnelResponse{}, false
+	}
+	for _, a := range d.failed {
+		if resp, ok := a.Challenge(ctx, req); ok {
+			return resp, true
+		}
+	}
+	return types.TunnelResponse{
+		Status: http.StatusForbidden,
+		Headers: map[string][]string{
+			"Content-Type":  {"text/plain; charset=utf-8"},
+			"Cache-Control": {"no-store"},
+		},
+		Body: base64.StdEncoding.EncodeToString([]byte("Forbidden")),
+	}, true
+}
+
+// Admit runs the policy on the upgrade request of a WebSocket or TCP
+// stream, which has no way to answer a challenge.
+func (h *reqHook) Admit(ctx *hooks.RequestContext, req types.TunnelRequest) (types.TunnelRequest, types.TunnelResponse, bool) {
+	req = h.BeforeProxy(ctx, req)
+	resp, denied := h.Respond(ctx, req)
+	return req, resp, !denied
+}
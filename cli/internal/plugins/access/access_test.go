@@ -0,0 +1,195 @@
+package access
+
+import (
+	"flag"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+// fakeScheme passes requests carrying its header, as identity "user-<name>".
+type fakeScheme struct {
+	name  string
+	local bool // EnforceLocally was called
+}
+
+func (f *fakeScheme) Name() string                            { return f.name }
+func (f *fakeScheme) RegisterFlags(*flag.FlagSet)             {}
+func (f *fakeScheme) Enabled() bool                           { return true }
+func (f *fakeScheme) WorkerConfig() map[string]any            { return nil }
+func (f *fakeScheme) RequestHooks() []hooks.RequestHook       { return nil }
+func (f *fakeScheme) ConnectionHooks() []hooks.ConnectionHook { return nil }
+func (f *fakeScheme) Authenticator() hooks.Authenticator      { return f }
+
+func (f *fakeScheme) Verify(_ *hooks.RequestContext, req types.TunnelRequest) (string, bool) {
+	if hooks.CanonicalHeader(req.Headers).Get("X-Pass-"+f.name) == "" {
+		return "", false
+	}
+	return "user-" + f.name, true
+}
+
+func (f *fakeScheme) Challenge(*hooks.RequestContext, types.TunnelRequest) (types.TunnelResponse, bool) {
+	return types.TunnelResponse{Status: http.StatusUnauthorized, Headers: map[string][]string{"X-Challenge": {f.name}}}, true
+}
+
+// workerScheme is a fakeScheme the worker enforces unless moved into the
+// CLI, like -auth and -allow-ip.
+type workerScheme struct{ fakeScheme }
+
+func (w *workerScheme) EnforceLocally() error {
+	w.local = true
+	return nil
+}
+
+// secondScheme is a fakeScheme that is a second factor, like -totp.
+type secondScheme struct{ fakeScheme }
+
+func (*secondScheme) SecondFactor() {}
+
+func newGate(t *testing.T, expr string, schemes ...hooks.AuthPlugin) *Plugin {
+	t.Helper()
+	p := New(func() []hooks.AuthPlugin { return schemes })
+	p.expr = expr
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func request(passes ...string) types.TunnelRequest {
+	h := map[string][]string{}
+	for _, name := range passes {
+		h["X-Pass-"+name] = []string{"1"}
+	}
+	return types.TunnelRequest{Type: types.TypeHTTPRequest, ID: "r1", Method: "GET", Path: "/", Headers: h}
+}
+
+func newCtx() *hooks.RequestContext {
+	return hooks.NewRequestContext(hooks.NewTunnelContext("abc", 3000), time.Now().Add(time.Minute))
+}
+
+func TestPolicy(t *testing.T) {
+	ip := &fakeScheme{name: "ip"}
+	password := &fakeScheme{name: "password"}
+	oauth := &fakeScheme{name: "oauth"}
+	gate := newGate(t, "ip | password + oauth", ip, password, oauth)
+	h := gate.RequestHooks()[0].(*reqHook)
+
+	tests := []struct {
+		passes   []string
+		allowed  bool
+		identity string
+	}{
+		{[]string{"ip"}, true, "user-ip"},
+		{[]string{"password", "oauth"}, true, "user-password"},
+		{[]string{"password"}, false, ""},
+		{nil, false, ""},
+	}
+	for _, tt := range tests {
+		d, identity := gate.evaluate(newCtx(), request(tt.passes...))
+		if d.allowed != tt.allowed || (tt.allowed && identity != tt.identity) {
+			t.Errorf("passes %v: allowed %v as %q, want %v as %q", tt.passes, d.allowed, identity, tt.allowed, tt.identity)
+		}
+
+		ctx := newCtx()
+		req := h.BeforeProxy(ctx, request(tt.passes...))
+		resp, answered := h.Respond(ctx, req)
+		if answered == tt.allowed {
+			t.Errorf("passes %v: answered %v", tt.passes, answered)
+		}
+		if got := http.Header(req.Headers).Get(UserHeader); tt.identity != "" && got != tt.identity {
+			t.Errorf("passes %v: %s = %q, want %q", tt.passes, UserHeader, got, tt.identity)
+		}
+		if answered && resp.Status != http.StatusUnauthorized {
+			t.Errorf("passes %v: challenged with %d", tt.passes, resp.Status)
+		}
+	}
+}
+
+func TestRespondChallengesFirstFailedScheme(t *testing.T) {
+	password := &fakeScheme{name: "password"}
+	oauth := &fakeScheme{name: "oauth"}
+	h := newGate(t, "password+oauth", password, oauth).RequestHooks()[0].(*reqHook)
+
+	ctx := newCtx()
+	req := h.BeforeProxy(ctx, request("password"))
+	resp, answered := h.Respond(ctx, req)
+	if !answered || resp.Headers["X-Challenge"][0] != "oauth" {
+		t.Fatalf("got %v %v, want the oauth challenge", answered, resp.Headers)
+	}
+}
+
+func TestVisitorCantSetUserHeader(t *testing.T) {
+	h := newGate(t, "", &fakeScheme{name: "password"}).RequestHooks()[0].(*reqHook)
+	req := request("password")
+	req.Headers[UserHeader] = []string{"admin"}
+	req = h.BeforeProxy(newCtx(), req)
+	if got := http.Header(req.Headers).Get(UserHeader); got != "user-password" {
+		t.Fatalf("%s = %q", UserHeader, got)
+	}
+}
+
+func TestAdmitStreams(t *testing.T) {
+	var p hooks.Pipeline
+	gate := newGate(t, "", &fakeScheme{name: "password"})
+	p.RegisterPlugin(gate)
+	p.Activate()
+	tp := p.ForTunnel("abc", 3000)
+
+	req := request()
+	req.Headers["Cookie"] = []string{hooks.SessionCookiePrefix + "password=x; theme=dark"}
+	if _, _, ok := tp.Admit(tp.NewRequest(time.Now()), req); ok {
+		t.Fatal("visitor without credentials admitted to a stream")
+	}
+
+	req = request("password")
+	req.Headers["Cookie"] = []string{hooks.SessionCookiePrefix + "password=x; theme=dark"}
+	out, _, ok := tp.Admit(tp.NewRequest(time.Now()), req)
+	if !ok {
+		t.Fatal("visitor with credentials refused")
+	}
+	if got := http.Header(out.Headers).Get("Cookie"); got != "theme=dark" {
+		t.Errorf("stream opened with Cookie %q; session cookies must stay with the CLI", got)
+	}
+	if got := http.Header(out.Headers).Get(UserHeader); got != "user-password" {
+		t.Errorf("%s = %q", UserHeader, got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	password := &fakeScheme{name: "password"}
+	ip := &workerScheme{fakeScheme{name: "ip"}}
+
+	// By default the worker keeps its schemes
+	p := newGate(t, "", password, ip)
+	if ip.local || p.Schemes() != "password" {
+		t.Fatalf("default policy %q, ip local %v", p.Schemes(), ip.local)
+	}
+
+	// Required either way: the worker keeps it
+	p = newGate(t, "ip+password | ip+oauth", password, ip, &fakeScheme{name: "oauth"})
+	if ip.local || p.Schemes() != "password | oauth" {
+		t.Fatalf("policy %q, ip local %v", p.Schemes(), ip.local)
+	}
+	if p = newGate(t, "ip", ip); p.Enabled() {
+		t.Fatalf("policy %q left for the CLI", p.Schemes())
+	}
+
+	// One of two ways in: the CLI checks it
+	p = newGate(t, "ip|password", password, ip)
+	if !ip.local || p.Schemes() != "ip | password" {
+		t.Fatalf("policy %q, ip local %v", p.Schemes(), ip.local)
+	}
+
+	totp := &secondScheme{fakeScheme{name: "totp"}}
+	for _, expr := range []string{"password|nope", "password", "password | totp", "totp+totp | password"} {
+		p := New(func() []hooks.AuthPlugin { return []hooks.AuthPlugin{password, ip, totp} })
+		p.expr = expr
+		if err := p.Validate(); err == nil {
+			t.Errorf("-access %q accepted", expr)
+		}
+	}
+}
@@ -1,13 +1,21 @@
 package auth
 
 import (
+	"crypto/subtle"
+	"encoding/base64"
 	"flag"
+	"net/http"
+	"strings"
+	"sync"
 
 	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
 )
 
 type plugin struct {
-	auth *string
+	auth   *string
+	local  bool     // checked by the CLI (-access) instead of the worker
+	pushed sync.Map // subdomain -> credentials the worker pushed, when local
 }
 
 func New() hooks.Plugin {
@@ -23,8 +31,85 @@ func (p *plugin) RegisterFlags(fs *flag.FlagSet) {
 func (p *plugin) Enabled() bool { return p.auth != nil && *p.auth != "" }
 
 func (p *plugin) WorkerConfig() map[string]any {
+	if p.local {
+		return nil
+	}
 	return map[string]any{"auth": *p.auth}
 }
 
-func (p *plugin) RequestHooks() []hooks.RequestHook       { return nil }
-func (p *plugin) ConnectionHooks() []hooks.ConnectionHook { return nil }
+// Reload is a no-op: the credentials are read on every request, or only
+// live in WorkerConfig, which the caller re-sends.
+func (p *plugin) Reload() error { return nil }
+
+func (p *plugin) RequestHooks() []hooks.RequestHook { return nil }
+func (p *plugin) ConnectionHooks() []hooks.ConnectionHook {
+	return []hooks.ConnectionHook{connHook{plugin: p}}
+}
+
+func (p *plugin) Authenticator() hooks.Authenticator { return authenticator{p} }
+func (p *plugin) EnforceLocally() error {
+	p.local = true
+	return nil
+}
+
+// connHook applies credentials the worker pushes (an admin changing them
+// mid-session) when the CLI checks them; the worker checks its own copy.
+type connHook struct {
+	hooks.NoOpConnectionHook
+	plugin *plugin
+}
+
+// OnConfigUpdate replaces the tunnel's credentials with a pushed "auth", or
+// goes back to -auth without one.
+func (h connHook) OnConfigUpdate(subdomain string, config map[string]any) {
+	if !h.plugin.local {
+		return
+	}
+	if creds, _ := config["auth"].(string); creds != "" {
+		h.plugin.pushed.Store(subdomain, creds)
+	} else {
+		h.plugin.pushed.Delete(subdomain)
+	}
+}
+
+// credentials returns the user:pass visitors to subdomain must send.
+func (p *plugin) credentials(subdomain string) string {
+	if creds, ok := p.pushed.Load(subdomain); ok {
+		return creds.(string)
+	}
+	return *p.auth
+}
+
+// authenticator is the "basic" scheme, the CLI's copy of the worker's
+// check.
+type authenticator struct {
+	plugin *plugin
+}
+
+func (a authenticator) Name() string { return "basic" }
+
+// Verify returns the user name of matching credentials.
+func (a authenticator) Verify(ctx *hooks.RequestContext, req types.TunnelRequest) (string, bool) {
+	header := hooks.CanonicalHeader(req.Headers).Get("Authorization")
+	encoded, ok := strings.CutPrefix(header, "Basic ")
+	if !ok {
+		return "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || subtle.ConstantTimeCompare(decoded, []byte(a.plugin.credentials(ctx.Subdomain))) != 1 {
+		return "", false
+	}
+	user, _, _ := strings.Cut(string(decoded), ":")
+	return user, true
+}
+
+func (a authenticator) Challenge(_ *hooks.RequestContext, _ types.TunnelRequest) (types.TunnelResponse, bool) {
+	return types.TunnelResponse{
+		Status: http.StatusUnauthorized,
+		Headers: map[string][]string{
+			"Www-Authenticate": {`Basic realm="Tunnel"`},
+			"Content-Type":     {"text/plain; charset=utf-8"},
+		},
+		Body: base64.StdEncoding.EncodeToString([]byte("Unauthorized")),
+	}, true
+}
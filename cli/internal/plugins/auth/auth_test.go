@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+func signIn(p *plugin, subdomain, creds string) (string, bool) {
+	ctx := hooks.NewRequestContext(hooks.NewTunnelContext(subdomain, 3000), time.Now())
+	req := types.TunnelRequest{Headers: map[string][]string{
+		"authorization": {"Basic " + base64.StdEncoding.EncodeToString([]byte(creds))},
+	}}
+	return p.Authenticator().Verify(ctx, req)
+}
+
+func TestPushedCredentials(t *testing.T) {
+	creds := "ana:secret"
+	p := &plugin{auth: &creds}
+	p.EnforceLocally()
+	if user, ok := signIn(p, "abc", "ana:secret"); !ok || user != "ana" {
+		t.Fatalf("-auth: %q %v", user, ok)
+	}
+	if _, ok := signIn(p, "abc", "ana:wrong"); ok {
+		t.Fatal("wrong password accepted")
+	}
+
+	hook := p.ConnectionHooks()[0].(hooks.ConfigUpdateHook)
+	hook.OnConfigUpdate("abc", map[string]any{"auth": "bo:rotated"})
+	if _, ok := signIn(p, "abc", "ana:secret"); ok {
+		t.Fatal("old credentials still accepted after the push")
+	}
+	if user, ok := signIn(p, "abc", "bo:rotated"); !ok || user != "bo" {
+		t.Fatalf("pushed credentials: %q %v", user, ok)
+	}
+	if _, ok := signIn(p, "other", "ana:secret"); !ok {
+		t.Fatal("push applied to another tunnel")
+	}
+	hook.OnConfigUpdate("abc", map[string]any{"allowIps": []any{}})
+	if _, ok := signIn(p, "abc", "ana:secret"); !ok {
+		t.Fatal("-auth not back after the override went")
+	}
+}
@@ -0,0 +1,158 @@
+package banner
+
+import (
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+// bannerTemplate is injected right before </body>. The verbs are the escaped
+// message and the optional feedback widget.
+const bannerTemplate = `<div id="prodbd-banner" style="position:fixed;left:0;right:0;bottom:0;z-index:2147483647;` +
+	`display:flex;align-items:center;justify-content:center;gap:12px;padding:8px 12px;` +
+	`font:13px/1.4 system-ui,sans-serif;color:#fff;background:#b45309;box-shadow:0 -1px 4px rgba(0,0,0,.3)">` +
+	`<span>%s</span>%s` +
+	`<button type="button" onclick="this.parentNode.remove()" aria-label="Dismiss" ` +
+	`style="border:0;background:transparent;color:inherit;font-size:16px;cursor:pointer">&times;</button></div>`
+
+// feedbackWidget adds a comment box to the banner that posts to the stats
+// plugin's reserved feedback path on the same origin.
+const feedbackWidget = `<form onsubmit="event.preventDefault();var t=this.c,f=this;` +
+	`fetch('/_prodbd/feedback',{method:'POST',headers:{'Content-Type':'application/json'},` +
+	`body:JSON.stringify({comment:t.value,url:location.href})}).then(function(r){` +
+	`f.innerHTML=r.ok?'<span>Thanks for the feedback!</span>':'<span>Feedback failed</span>'})" ` +
+	`style="display:flex;gap:6px;margin:0">` +
+	`<input name="c" required maxlength="4000" placeholder="Leave feedback…" ` +
+	`style="padding:3px 6px;border:0;border-radius:3px;font:inherit;width:220px">` +
+	`<button type="submit" style="border:0;border-radius:3px;padding:3px 8px;font:inherit;cursor:pointer">Send</button></form>`
+
+type Plugin struct {
+	enabled  *bool
+	text     *string
+	expires  *time.Duration
+	feedback *bool
+	started  time.Time
+}
+
+func New() *Plugin {
+	return &Plugin{started: time.Now()}
+}
+
+func (p *Plugin) Name() string { return "banner" }
+
+func (p *Plugin) RegisterFlags(fs *flag.FlagSet) {
+	p.enabled = fs.Bool("banner", false, "Inject a dismissible \"preview tunnel\" banner into HTML responses")
+	p.text = fs.String("banner-text", "Preview tunnel — not production", "Banner message")
+	p.expires = fs.Duration("banner-expires", 0, "Show \"expires in …\" counted from startup (e.g. 2h); 0 to omit")
+	p.feedback = fs.Bool("banner-feedback", false, "Add a feedback box to the banner; comments show up at /api/v1/stats/feedback on the dashboard")
+}
+
+func (p *Plugin) Enabled() bool { return p.enabled != nil && *p.enabled }
+
+// Feedback reports whether the banner carries the feedback widget
+// (-banner-feedback), whose comments the stats plugin collects.
+func (p *Plugin) Feedback() bool { return p.Enabled() && *p.feedback }
+
+func (p *Plugin) WorkerConfig() map[string]any { return nil }
+
+func (p *Plugin) RequestHooks() []hooks.RequestHook {
+	return []hooks.RequestHook{&reqHook{plugin: p}}
+}
+
+func (p *Plugin) ConnectionHooks() []hooks.ConnectionHook { return nil }
+
+// message builds the banner text for a visitor-facing host.
+func (p *Plugin) message(host string) string {
+	msg := *p.text
+	if host != "" {
+		msg = host + ": " + msg
+	}
+	if *p.expires > 0 {
+		left := time.Until(p.started.Add(*p.expires)).Round(time.Minute)
+		if left <= 0 {
+			msg += ", expired"
+		} else {
+			msg += ", expires in " + formatLeft(left)
+		}
+	}
+	return msg
+}
+
+// formatLeft renders a duration as "2h", "1h30m" or "45m".
+func formatLeft(d time.Duration) string {
+	h, m := int(d.Hours()), int(d.Minutes())%60
+	switch {
+	case h == 0:
+		return fmt.Sprintf("%dm", m)
+	case m == 0:
+		return fmt.Sprintf("%dh", h)
+	default:
+		return fmt.Sprintf("%dh%dm", h, m)
+	}
+}
+
+// IsHTML reports whether the response headers declare an HTML body.
+func IsHTML(headers map[string][]string) bool {
+	for k, vals := range headers {
+		if !strings.EqualFold(k, "Content-Type") {
+			continue
+		}
+		for _, v := range vals {
+			if strings.HasPrefix(strings.ToLower(strings.TrimSpace(v)), "text/html") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// InjectBeforeBody inserts snippet before the last </body> tag (or appends it
+// if there is none) in a base64-encoded HTML body, returning the new body.
+func InjectBeforeBody(encoded string, snippet string) (string, error) {
+	body, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	idx := bytes.LastIndex(bytes.ToLower(body), []byte("</body>"))
+	if idx < 0 {
+		idx = len(body)
+	}
+	out := make([]byte, 0, len(body)+len(snippet))
+	out = append(out, body[:idx]...)
+	out = append(out, snippet...)
+	out = append(out, body[idx:]...)
+	return base64.StdEncoding.EncodeToString(out), nil
+}
+
+type reqHook struct {
+	hooks.NoOpRequestHook
+	plugin *Plugin
+}
+
+func (h *reqHook) AfterProxy(_ *hooks.RequestContext, req types.TunnelRequest, resp types.TunnelResponse) types.TunnelResponse {
+	if resp.Body == "" || !IsHTML(resp.Headers) {
+		return resp
+	}
+	host := ""
+	for k, vals := range req.Headers {
+		if strings.EqualFold(k, "Host") && len(vals) > 0 {
+			host = vals[0]
+		}
+	}
+	widget := ""
+	if *h.plugin.feedback {
+		widget = feedbackWidget
+	}
+	snippet := fmt.Sprintf(bannerTemplate, html.EscapeString(h.plugin.message(host)), widget)
+	if body, err := InjectBeforeBody(resp.Body, snippet); err == nil {
+		resp.Body = body
+	}
+	return resp
+}
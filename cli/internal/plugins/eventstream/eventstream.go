@@ -0,0 +1,220 @@
+package eventstream
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/config"
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+)
+
+// Event names.
+const (
+	EventRegistered   = "registered"
+	EventURL          = "url"
+	EventConnected    = "connected"
+	EventDegraded     = "degraded"
+	EventDisconnected = "disconnected"
+	EventExpiring     = "expiring"
+	EventAlert        = "alert"
+	EventShutdown     = "shutdown"
+)
+
+var eventNames = []string{EventRegistered, EventURL, EventConnected, EventDegraded, EventDisconnected, EventExpiring, EventAlert, EventShutdown}
+
+// Event is one line of the stream. Fields that don't apply are omitted.
+type Event struct {
+	Event     string         `json:"event"`
+	Time      time.Time      `json:"time"`
+	Subdomain string         `json:"subdomain,omitempty"`
+	Port      int            `json:"port,omitempty"`
+	URL       string         `json:"url,omitempty"`
+	Label     string         `json:"label,omitempty"`
+	Attempt   int            `json:"attempt,omitempty"`
+	Error     string         `json:"error,omitempty"`
+	Source    string         `json:"source,omitempty"`     // alert: plugin that raised it
+	Message   string         `json:"message,omitempty"`    // alert
+	ExpiresIn int            `json:"expires_in,omitempty"` // expiring: seconds left
+	Renew     bool           `json:"renew,omitempty"`      // expiring: a fresh registration follows
+	Tunnels   map[int]string `json:"tunnels,omitempty"`    // registered: port -> subdomain
+}
+
+// Plugin writes tunnel lifecycle events and alerts as JSON lines to the
+// -event-stream destination, for process supervisors and wrapper tools, and
+// POSTs them to -event-webhook URLs for external automation.
+type Plugin struct {
+	dest          string
+	webhookURLs   string
+	webhookEvents string
+	webhookSecret string
+	webhooks      *webhooks // set by Validate
+
+	mu     sync.Mutex
+	out    io.WriteCloser // nil until opened, or after a write failed
+	opened bool
+}
+
+func New() *Plugin {
+	return &Plugin{}
+}
+
+func (p *Plugin) Name() string { return "eventstream" }
+func (p *Plugin) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&p.dest, "event-stream", "", "Write lifecycle events as JSON lines to fd://N, unix:///path/to.sock or a file path")
+	fs.StringVar(&p.webhookURLs, "event-webhook", "", "Comma-separated URLs to POST each lifecycle event and alert to as JSON")
+	fs.StringVar(&p.webhookEvents, "event-webhook-events", "", "Comma-separated events to POST to -event-webhook (default all): "+strings.Join(eventNames, ", "))
+	fs.StringVar(&p.webhookSecret, "event-webhook-secret", "", "Sign -event-webhook bodies with HMAC-SHA256 in the "+SignatureHeader+" header (sha256=<hex>)")
+}
+func (p *Plugin) Enabled() bool                     { return p.dest != "" || p.webhookURLs != "" }
+func (p *Plugin) WorkerConfig() map[string]any      { return nil }
+func (p *Plugin) RequestHooks() []hooks.RequestHook { return nil }
+func (p *Plugin) ConnectionHooks() []hooks.ConnectionHook {
+	return []hooks.ConnectionHook{&connHook{plugin: p}}
+}
+
+// Validate checks the -event-webhook flags. Call after flags are parsed.
+func (p *Plugin) Validate() error {
+	if p.webhookURLs == "" {
+		if p.webhookEvents != "" || p.webhookSecret != "" {
+			return fmt.Errorf("-event-webhook-events and -event-webhook-secret need -event-webhook")
+		}
+		return nil
+	}
+	w, err := parseWebhooks(p.webhookURLs, p.webhookEvents, p.webhookSecret)
+	if err != nil {
+		return err
+	}
+	p.webhooks = w
+	return nil
+}
+
+// SubscribeEvents forwards reconnect attempts as degraded events and
+// plugin alerts as alert events.
+func (p *Plugin) SubscribeEvents(bus *hooks.Bus) {
+	hooks.Subscribe(bus, func(e hooks.AlertFired) {
+		p.emit(Event{Event: EventAlert, Subdomain: e.Subdomain, Source: e.Source, Message: e.Message})
+	})
+	hooks.Subscribe(bus, func(e hooks.TunnelDegraded) {
+		ev := Event{Event: EventDegraded, Subdomain: e.Subdomain, Attempt: e.Attempt}
+		if e.Err != nil {
+			ev.Error = e.Err.Error()
+		}
+		p.emit(ev)
+	})
+}
+
+// Registered reports the subdomains the worker assigned: one registered
+// event with the whole mapping, then a url event per tunnel.
+func (p *Plugin) Registered(mapping map[int]string, labels map[int]string) {
+	if !p.Enabled() {
+		return
+	}
+	p.emit(Event{Event: EventRegistered, Tunnels: mapping})
+	for port, sub := range mapping {
+		p.emit(Event{Event: EventURL, Subdomain: sub, Port: port, URL: config.PublicURL(sub), Label: labels[port]})
+	}
+}
+
+// Close emits a shutdown event, closes the destination and delivers the
+// webhook events still queued.
+func (p *Plugin) Close() {
+	if !p.Enabled() {
+		return
+	}
+	p.emit(Event{Event: EventShutdown})
+	if p.webhooks != nil {
+		p.webhooks.close()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.out != nil {
+		p.out.Close()
+		p.out = nil
+	}
+}
+
+// emit writes e as one JSON line and queues it for the webhooks. The
+// destination is opened on first use; if it can't be opened or a write
+// fails, the stream is disabled.
+func (p *Plugin) emit(e Event) {
+	e.Time = time.Now().UTC()
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	if p.webhooks != nil {
+		p.webhooks.send(e.Event, data)
+	}
+	if p.dest == "" {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.opened {
+		p.opened = true
+		out, err := open(p.dest)
+		if err != nil {
+			log.Printf("[eventstream] disabled: %v", err)
+			return
+		}
+		p.out = out
+	}
+	if p.out == nil {
+		return
+	}
+	if _, err := p.out.Write(append(data, '\n')); err != nil {
+		log.Printf("[eventstream] write failed, disabling: %v", err)
+		p.out.Close()
+		p.out = nil
+	}
+}
+
+// open opens an -event-stream destination.
+func open(dest string) (io.WriteCloser, error) {
+	switch {
+	case strings.HasPrefix(dest, "fd://"):
+		fd, err := strconv.Atoi(strings.TrimPrefix(dest, "fd://"))
+		if err != nil || fd < 0 {
+			return nil, fmt.Errorf("invalid file descriptor in %q", dest)
+		}
+		f := os.NewFile(uintptr(fd), dest)
+		if f == nil {
+			return nil, fmt.Errorf("invalid file descriptor in %q", dest)
+		}
+		return f, nil
+	case strings.HasPrefix(dest, "unix://"):
+		return net.Dial("unix", strings.TrimPrefix(dest, "unix://"))
+	default:
+		return os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	}
+}
+
+type connHook struct {
+	hooks.NoOpConnectionHook
+	plugin *Plugin
+}
+
+func (h *connHook) OnConnect(subdomain string, port int, _ *hooks.WarmupResult) {
+	h.plugin.emit(Event{Event: EventConnected, Subdomain: subdomain, Port: port, URL: config.PublicURL(subdomain)})
+}
+
+func (h *connHook) OnExpiring(subdomain string, remaining time.Duration, renew bool) {
+	h.plugin.emit(Event{Event: EventExpiring, Subdomain: subdomain, ExpiresIn: int(remaining.Round(time.Second).Seconds()), Renew: renew})
+}
+
+func (h *connHook) OnDisconnect(subdomain string, err error) {
+	e := Event{Event: EventDisconnected, Subdomain: subdomain}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	h.plugin.emit(e)
+}
@@ -0,0 +1,169 @@
+package eventstream
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Outbound webhook delivery (-event-webhook).
+const (
+	webhookQueue    = 256
+	webhookTimeout  = 10 * time.Second
+	webhookAttempts = 3
+	// closeTimeout bounds how long Close waits for queued deliveries.
+	closeTimeout = 5 * time.Second
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of the body, keyed with
+// -event-webhook-secret, as sha256=<hex> (the same scheme as GitHub).
+const SignatureHeader = "X-Prodbd-Signature"
+
+// delivery is one event on its way to the webhook URLs.
+type delivery struct {
+	event string
+	body  []byte
+}
+
+// webhooks POSTs events to URLs in the background, in order, retrying
+// failures and 5xx responses with backoff.
+type webhooks struct {
+	urls   []string
+	events []string // event names to send; empty for all
+	secret string
+	client *http.Client
+
+	mu     sync.Mutex
+	queue  chan delivery // nil until the first event
+	done   chan struct{} // closed once the queue is drained
+	closed bool
+}
+
+// parseWebhooks checks the -event-webhook flags.
+func parseWebhooks(urls, events, secret string) (*webhooks, error) {
+	w := &webhooks{secret: secret, client: &http.Client{Timeout: webhookTimeout}}
+	for _, u := range splitList(urls) {
+		parsed, err := url.Parse(u)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("-event-webhook: %q is not an http(s) URL", u)
+		}
+		w.urls = append(w.urls, u)
+	}
+	w.events = splitList(events)
+	for _, e := range w.events {
+		if !slices.Contains(eventNames, e) {
+			return nil, fmt.Errorf("-event-webhook-events: unknown event %q (%s)", e, strings.Join(eventNames, ", "))
+		}
+	}
+	return w, nil
+}
+
+// send queues body for delivery, dropping it if the queue is full so a slow
+// receiver can't hold up the tunnels.
+func (w *webhooks) send(event string, body []byte) {
+	if len(w.events) > 0 && !slices.Contains(w.events, event) {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	if w.queue == nil {
+		w.queue = make(chan delivery, webhookQueue)
+		w.done = make(chan struct{})
+		go w.run()
+	}
+	select {
+	case w.queue <- delivery{event, body}:
+	default:
+		log.Printf("[eventstream] webhook queue full, dropping %s event", event)
+	}
+}
+
+func (w *webhooks) run() {
+	defer close(w.done)
+	for d := range w.queue {
+		for _, u := range w.urls {
+			w.deliver(u, d)
+		}
+	}
+}
+
+// deliver POSTs d to u, retrying with backoff.
+func (w *webhooks) deliver(u string, d delivery) {
+	var err error
+	for attempt := range webhookAttempts {
+		if attempt > 0 {
+			time.Sleep(time.Duration(1<<(attempt-1)) * time.Second)
+		}
+		if err = w.post(u, d); err == nil {
+			return
+		}
+	}
+	log.Printf("[eventstream] webhook %s: %s event not delivered: %v", u, d.event, err)
+}
+
+func (w *webhooks) post(u string, d delivery) error {
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(d.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "prodbd-event-webhook")
+	req.Header.Set("X-Prodbd-Event", d.event)
+	if w.secret != "" {
+		m := hmac.New(sha256.New, []byte(w.secret))
+		m.Write(d.body)
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(m.Sum(nil)))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	if resp.StatusCode >= 400 {
+		// The receiver rejected it; retrying won't help
+		log.Printf("[eventstream] webhook %s: %s event rejected: %s", u, d.event, resp.Status)
+	}
+	return nil
+}
+
+// close delivers what's queued, waiting up to closeTimeout.
+func (w *webhooks) close() {
+	w.mu.Lock()
+	w.closed = true
+	queue := w.queue
+	w.mu.Unlock()
+	if queue == nil {
+		return
+	}
+	close(queue)
+	select {
+	case <-w.done:
+	case <-time.After(closeTimeout):
+		log.Printf("[eventstream] gave up on undelivered webhook events")
+	}
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
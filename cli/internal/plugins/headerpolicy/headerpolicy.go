@@ -0,0 +1,111 @@
+package headerpolicy
+
+import (
+	"errors"
+	"flag"
+	"path"
+	"strings"
+	"sync/atomic"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+// defaultDeny strips headers dev frameworks commonly use to leak stack and path details.
+const defaultDeny = "X-Powered-By,X-AspNet-Version,X-AspNetMvc-Version,X-SourceFiles,X-Debug-Token,X-Debug-Token-Link"
+
+type plugin struct {
+	deny  *string
+	allow *string
+	hook  *reqHook // set by RequestHooks
+}
+
+func New() hooks.Plugin {
+	return &plugin{}
+}
+
+func (p *plugin) Name() string { return "headerpolicy" }
+
+func (p *plugin) RegisterFlags(fs *flag.FlagSet) {
+	p.deny = fs.String("strip-header", defaultDeny, "Comma-separated response headers to strip before they leave the CLI; * wildcards allowed (e.g. Server,X-Internal-*). Empty to disable.")
+	p.allow = fs.String("allow-header", "", "Comma-separated response headers to keep; when set, all others are stripped (* wildcards allowed).")
+}
+
+func (p *plugin) Enabled() bool {
+	return (p.deny != nil && *p.deny != "") || (p.allow != nil && *p.allow != "")
+}
+
+func (p *plugin) WorkerConfig() map[string]any { return nil }
+
+func (p *plugin) RequestHooks() []hooks.RequestHook {
+	p.hook = &reqHook{}
+	p.hook.rules.Store(p.rules())
+	return []hooks.RequestHook{p.hook}
+}
+
+// Reload swaps in the current -strip-header and -allow-header lists.
+func (p *plugin) Reload() error {
+	if p.hook == nil {
+		return errors.New("header policy was off at startup; restart to turn it on")
+	}
+	p.hook.rules.Store(p.rules())
+	return nil
+}
+
+func (p *plugin) rules() *rules {
+	return &rules{deny: parsePatterns(*p.deny), allow: parsePatterns(*p.allow)}
+}
+
+func (p *plugin) ConnectionHooks() []hooks.ConnectionHook { return nil }
+
+// parsePatterns splits a comma-separated list into lowercase glob patterns.
+func parsePatterns(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+func matchAny(patterns []string, name string) bool {
+	name = strings.ToLower(name)
+	for _, pat := range patterns {
+		if ok, _ := path.Match(pat, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+type rules struct {
+	deny  []string
+	allow []string
+}
+
+type reqHook struct {
+	hooks.NoOpRequestHook
+	rules atomic.Pointer[rules]
+}
+
+func (h *reqHook) AfterProxy(_ *hooks.RequestContext, _ types.TunnelRequest, resp types.TunnelResponse) types.TunnelResponse {
+	if len(resp.Headers) == 0 {
+		return resp
+	}
+	r := h.rules.Load()
+	// Copy so we never mutate a map another hook may still hold
+	headers := make(map[string][]string, len(resp.Headers))
+	for k, v := range resp.Headers {
+		if len(r.allow) > 0 && !matchAny(r.allow, k) {
+			continue
+		}
+		if matchAny(r.deny, k) {
+			continue
+		}
+		headers[k] = v
+	}
+	resp.Headers = headers
+	return resp
+}
@@ -0,0 +1,223 @@
+package health
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/config"
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+)
+
+// Plugin serves health and readiness endpoints for orchestrators
+// (-healthz-addr) and can signal readiness with a file (-ready-file).
+//
+// /healthz reports whether every tunnel is connected right now. /readyz and
+// the ready file flip once, when every registered tunnel has connected for
+// the first time, so jobs that need the public URLs can wait for them.
+type Plugin struct {
+	addr      string
+	readyFile string
+
+	mu        sync.Mutex
+	tunnels   map[string]int  // subdomain -> local port, set by SetTunnels
+	connected map[string]bool // subdomain -> currently connected
+	ready     bool
+	started   bool
+}
+
+func New() *Plugin {
+	return &Plugin{connected: make(map[string]bool)}
+}
+
+func (p *Plugin) Name() string { return "health" }
+func (p *Plugin) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&p.addr, "healthz-addr", "", "Serve /healthz and /readyz on this address for orchestrators, e.g. :9998 (default :9998 with -container)")
+	fs.StringVar(&p.readyFile, "ready-file", "", "Write the public URLs as JSON to this file once every tunnel is connected, and remove it on exit")
+}
+func (p *Plugin) Enabled() bool                     { return p.addr != "" || p.readyFile != "" }
+func (p *Plugin) WorkerConfig() map[string]any      { return nil }
+func (p *Plugin) RequestHooks() []hooks.RequestHook { return nil }
+func (p *Plugin) ConnectionHooks() []hooks.ConnectionHook {
+	return []hooks.ConnectionHook{&connHook{plugin: p}}
+}
+
+// SetTunnels records the registered tunnels (port -> subdomain) that must
+// connect before the process counts as ready, and starts the endpoints.
+func (p *Plugin) SetTunnels(mapping map[int]string) {
+	if !p.Enabled() {
+		return
+	}
+	p.mu.Lock()
+	p.tunnels = make(map[string]int, len(mapping))
+	for port, sub := range mapping {
+		p.tunnels[sub] = port
+	}
+	p.mu.Unlock()
+	if p.addr != "" {
+		p.start()
+	}
+}
+
+// Close removes the ready file.
+func (p *Plugin) Close() {
+	if p.readyFile == "" {
+		return
+	}
+	if err := os.Remove(p.readyFile); err != nil && !os.IsNotExist(err) {
+		log.Printf("[health] removing ready file: %v", err)
+	}
+}
+
+// start serves /healthz and /readyz.
+func (p *Plugin) start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.started {
+		return
+	}
+	p.started = true
+	ln, err := net.Listen("tcp", p.addr)
+	if err != nil {
+		log.Printf("[health] failed to listen on %s: %v", p.addr, err)
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", p.handleHealthz)
+	mux.HandleFunc("GET /readyz", p.handleReadyz)
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			log.Printf("[health] server error: %v", err)
+		}
+	}()
+	log.Printf("[health] /healthz and /readyz listening on %s", ln.Addr())
+}
+
+// healthy reports whether every registered tunnel is connected.
+func (p *Plugin) healthy() (bool, map[string]bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tunnels := make(map[string]bool, len(p.tunnels))
+	ok := len(p.tunnels) > 0
+	for sub := range p.tunnels {
+		tunnels[sub] = p.connected[sub]
+		ok = ok && p.connected[sub]
+	}
+	return ok, tunnels
+}
+
+func (p *Plugin) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	ok, tunnels := p.healthy()
+	status, code := "ok", http.StatusOK
+	if !ok {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, map[string]any{"status": status, "tunnels": tunnels})
+}
+
+// tunnelURL is one entry of the /readyz response and the ready file.
+type tunnelURL struct {
+	Port      int    `json:"port"`
+	Subdomain string `json:"subdomain"`
+	URL       string `json:"url"`
+}
+
+func (p *Plugin) urls() []tunnelURL {
+	out := make([]tunnelURL, 0, len(p.tunnels))
+	for sub, port := range p.tunnels {
+		out = append(out, tunnelURL{Port: port, Subdomain: sub, URL: config.PublicURL(sub)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Port < out[j].Port })
+	return out
+}
+
+func (p *Plugin) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	ready, urls := p.ready, p.urls()
+	p.mu.Unlock()
+	if !ready {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]any{"ready": false})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ready": true, "tunnels": urls})
+}
+
+// connect marks subdomain connected and, the first time every tunnel is,
+// flips to ready.
+func (p *Plugin) connect(subdomain string) {
+	p.mu.Lock()
+	p.connected[subdomain] = true
+	if p.ready || len(p.tunnels) == 0 {
+		p.mu.Unlock()
+		return
+	}
+	for sub := range p.tunnels {
+		if !p.connected[sub] {
+			p.mu.Unlock()
+			return
+		}
+	}
+	p.ready = true
+	urls := p.urls()
+	p.mu.Unlock()
+
+	log.Printf("[health] ready: %d tunnel(s) connected", len(urls))
+	if p.readyFile != "" {
+		if err := writeReadyFile(p.readyFile, urls); err != nil {
+			log.Printf("[health] writing ready file: %v", err)
+		}
+	}
+}
+
+func (p *Plugin) disconnect(subdomain string) {
+	p.mu.Lock()
+	p.connected[subdomain] = false
+	p.mu.Unlock()
+}
+
+// writeReadyFile writes the file atomically, so watchers never see it half
+// written.
+func writeReadyFile(path string, urls []tunnelURL) error {
+	data, err := json.MarshalIndent(map[string]any{"tunnels": urls}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".ready-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+type connHook struct {
+	hooks.NoOpConnectionHook
+	plugin *Plugin
+}
+
+func (h *connHook) OnConnect(subdomain string, _ int, _ *hooks.WarmupResult) {
+	h.plugin.connect(subdomain)
+}
+
+func (h *connHook) OnDisconnect(subdomain string, _ error) {
+	h.plugin.disconnect(subdomain)
+}
@@ -0,0 +1,246 @@
+package hostrewrite
+
+import (
+	"encoding/base64"
+	"flag"
+	"net"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/config"
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/plugins/banner"
+	"github.com/QuadTriangle/prod.bd/cli/internal/proxy"
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+// loopbackHosts are the names local apps put in their own URLs, besides
+// the host each tunnel targets.
+var loopbackHosts = []string{"localhost", "127.0.0.1", "[::1]", "0.0.0.0"}
+
+type plugin struct {
+	enabled bool
+	html    bool
+
+	mu    sync.RWMutex
+	ports map[int]string // local port -> subdomain of its tunnel
+	urls  *regexp.Regexp // absolute URLs on a local host; nil before the first tunnel is up
+}
+
+func New() hooks.Plugin {
+	return &plugin{ports: make(map[int]string)}
+}
+
+func (p *plugin) Name() string { return "hostrewrite" }
+
+func (p *plugin) RegisterFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&p.enabled, "rewrite-host", false, "Rewrite localhost:<port> in Location headers and Set-Cookie domains to the public URL of the tunnel for that port")
+	fs.BoolVar(&p.html, "rewrite-host-html", false, "Also rewrite absolute localhost URLs in HTML responses; implies -rewrite-host")
+}
+
+func (p *plugin) Enabled() bool { return p.enabled || p.html }
+
+func (p *plugin) WorkerConfig() map[string]any { return nil }
+
+func (p *plugin) RequestHooks() []hooks.RequestHook {
+	return []hooks.RequestHook{&reqHook{plugin: p}}
+}
+
+func (p *plugin) ConnectionHooks() []hooks.ConnectionHook {
+	return []hooks.ConnectionHook{&connHook{plugin: p}}
+}
+
+// addTunnel maps port to the tunnel on subdomain, and the host it targets
+// to local.
+func (p *plugin) addTunnel(subdomain string, port int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ports[port] = subdomain
+	hosts := slices.Clone(loopbackHosts)
+	for port := range p.ports {
+		h := proxy.Target(port).Hostname()
+		if strings.Contains(h, ":") {
+			h = "[" + h + "]"
+		}
+		if !slices.Contains(hosts, h) {
+			hosts = append(hosts, h)
+		}
+	}
+	quoted := make([]string, len(hosts))
+	for i, h := range hosts {
+		quoted[i] = regexp.QuoteMeta(h)
+	}
+	// scheme, host, port; scheme-relative URLs have no scheme
+	p.urls = regexp.MustCompile(`(?i)(?:(https?|wss?):)?//(` + strings.Join(quoted, "|") + `)(?::(\d+))?`)
+}
+
+// public returns the public URL to put in place of the local origin
+// matched by m (as regexp submatch indexes into s), or false if no tunnel
+// serves it.
+func (p *plugin) public(s string, m []int) (string, bool) {
+	scheme := ""
+	if m[2] >= 0 {
+		scheme = strings.ToLower(s[m[2]:m[3]])
+	}
+	port := 80
+	if scheme == "https" || scheme == "wss" {
+		port = 443
+	}
+	if m[6] >= 0 {
+		port, _ = strconv.Atoi(s[m[6]:m[7]])
+	}
+	subdomain, ok := p.ports[port]
+	if !ok {
+		return "", false
+	}
+	u, err := url.Parse(config.PublicURL(subdomain))
+	if err != nil {
+		return "", false
+	}
+	switch scheme {
+	case "":
+		return "//" + u.Host, true
+	case "ws", "wss":
+		if u.Scheme == "https" {
+			return "wss://" + u.Host, true
+		}
+		return "ws://" + u.Host, true
+	}
+	return u.Scheme + "://" + u.Host, true
+}
+
+// rewrite replaces the local origins in s that a tunnel serves.
+func (p *plugin) rewrite(s string) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.urls == nil {
+		return s
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range p.urls.FindAllStringSubmatchIndex(s, -1) {
+		// localhost.example.com isn't local
+		if m[1] < len(s) && isHostByte(s[m[1]]) {
+			continue
+		}
+		if public, ok := p.public(s, m); ok {
+			b.WriteString(s[last:m[0]])
+			b.WriteString(public)
+			last = m[1]
+		}
+	}
+	if last == 0 {
+		return s
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+func isHostByte(c byte) bool {
+	return c == '.' || c == '-' || c == '_' || c == ':' ||
+		'0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// isLocal reports whether a cookie domain names the local machine.
+func (p *plugin) isLocal(domain string) bool {
+	domain = strings.TrimPrefix(strings.ToLower(domain), ".")
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, h := range loopbackHosts {
+		if domain == strings.Trim(h, "[]") {
+			return true
+		}
+	}
+	for port := range p.ports {
+		if domain == strings.ToLower(proxy.Target(port).Hostname()) {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteCookie points a Set-Cookie value's local Domain attribute at
+// host. Cookie domains have no port, so this tunnel's host is used.
+func (p *plugin) rewriteCookie(cookie, host string) string {
+	parts := strings.Split(cookie, ";")
+	for i, part := range parts[1:] {
+		name, value, _ := strings.Cut(part, "=")
+		if strings.EqualFold(strings.TrimSpace(name), "Domain") && p.isLocal(strings.TrimSpace(value)) {
+			parts[i+1] = " Domain=" + host
+		}
+	}
+	return strings.Join(parts, ";")
+}
+
+type reqHook struct {
+	hooks.NoOpRequestHook
+	plugin *plugin
+}
+
+func (h *reqHook) AfterProxy(ctx *hooks.RequestContext, _ types.TunnelRequest, resp types.TunnelResponse) types.TunnelResponse {
+	p := h.plugin
+	u, err := url.Parse(config.PublicURL(ctx.Subdomain))
+	if err != nil {
+		return resp
+	}
+	host, _, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		host = u.Host
+	}
+	headers := make(map[string][]string, len(resp.Headers))
+	encoded := false
+	for k, vals := range resp.Headers {
+		switch {
+		case strings.EqualFold(k, "Location"), strings.EqualFold(k, "Content-Location"):
+			vals = mapValues(vals, p.rewrite)
+		case strings.EqualFold(k, "Set-Cookie"):
+			vals = mapValues(vals, func(v string) string { return p.rewriteCookie(v, host) })
+		case strings.EqualFold(k, "Content-Encoding"):
+			encoded = len(vals) > 0 && !strings.EqualFold(strings.TrimSpace(vals[0]), "identity")
+		}
+		headers[k] = vals
+	}
+	resp.Headers = headers
+
+	// Compressed bodies can't be edited in place; local responses arrive
+	// decoded
+	if !p.html || encoded || resp.Body == "" || !banner.IsHTML(resp.Headers) {
+		return resp
+	}
+	body, err := base64.StdEncoding.DecodeString(resp.Body)
+	if err != nil {
+		return resp
+	}
+	if out := p.rewrite(string(body)); out != string(body) {
+		resp.Body = base64.StdEncoding.EncodeToString([]byte(out))
+		// The body changed length; the worker sets it again
+		for k := range resp.Headers {
+			if strings.EqualFold(k, "Content-Length") {
+				delete(resp.Headers, k)
+			}
+		}
+	}
+	return resp
+}
+
+type connHook struct {
+	hooks.NoOpConnectionHook
+	plugin *plugin
+}
+
+func (h *connHook) OnConnect(subdomain string, port int, _ *hooks.WarmupResult) {
+	h.plugin.addTunnel(subdomain, port)
+}
+
+// mapValues returns f applied to each of vals, in a new slice.
+func mapValues(vals []string, f func(string) string) []string {
+	out := make([]string, len(vals))
+	for i, v := range vals {
+		out[i] = f(v)
+	}
+	return out
+}
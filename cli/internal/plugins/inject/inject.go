@@ -0,0 +1,220 @@
+package inject
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"html"
+	"log"
+	"mime"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/plugins/banner"
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+// presets are the snippets -inject knows by name: in-page consoles for
+// debugging on phones, which have no devtools.
+var presets = map[string]string{
+	"eruda":    `<script src="https://cdn.jsdelivr.net/npm/eruda"></script><script>eruda.init();</script>`,
+	"vconsole": `<script src="https://cdn.jsdelivr.net/npm/vconsole"></script><script>new VConsole();</script>`,
+}
+
+// scriptsFlag collects -inject values as HTML snippets.
+type scriptsFlag struct {
+	set      []string
+	snippets []string
+}
+
+func (f *scriptsFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return strings.Join(f.set, ",")
+}
+
+// Set takes a preset name, a script URL (absolute, or a path on the
+// tunnel), raw HTML starting with "<", or a local .js file to inline, read
+// once at startup.
+func (f *scriptsFlag) Set(s string) error {
+	s = strings.TrimSpace(s)
+	var snippet string
+	switch {
+	case s == "":
+		return fmt.Errorf("empty script")
+	case presets[s] != "":
+		snippet = presets[s]
+	case strings.HasPrefix(s, "<"):
+		snippet = s
+	case strings.HasPrefix(s, "http://"), strings.HasPrefix(s, "https://"), strings.HasPrefix(s, "/"):
+		snippet = `<script src="` + html.EscapeString(s) + `"></script>`
+	default:
+		src, err := os.ReadFile(s)
+		if err != nil {
+			return fmt.Errorf("not a preset (eruda, vconsole), URL or HTML, and %w", err)
+		}
+		// A literal </script> would end the tag early
+		js := strings.ReplaceAll(string(src), "</script", `<\/script`)
+		snippet = "<script>\n" + js + "\n</script>"
+	}
+	f.set = append(f.set, s)
+	f.snippets = append(f.snippets, snippet)
+	return nil
+}
+
+type plugin struct {
+	scripts scriptsFlag
+	warned  sync.Map // charsets already logged as skipped
+}
+
+func New() hooks.Plugin {
+	return &plugin{}
+}
+
+func (p *plugin) Name() string { return "inject" }
+
+func (p *plugin) RegisterFlags(fs *flag.FlagSet) {
+	fs.Var(&p.scripts, "inject", "Add a <script> to HTML responses, before </body>: eruda or vconsole for a console on phones, a script URL or path, a local .js file, or raw HTML; repeatable. Pages whose Content-Security-Policy forbids it will block it")
+}
+
+func (p *plugin) Enabled() bool { return len(p.scripts.snippets) > 0 }
+
+func (p *plugin) WorkerConfig() map[string]any { return nil }
+
+func (p *plugin) RequestHooks() []hooks.RequestHook {
+	return []hooks.RequestHook{&reqHook{plugin: p}}
+}
+
+func (p *plugin) ConnectionHooks() []hooks.ConnectionHook { return nil }
+
+type reqHook struct {
+	hooks.NoOpRequestHook
+	plugin *plugin
+}
+
+func (h *reqHook) AfterProxy(_ *hooks.RequestContext, _ types.TunnelRequest, resp types.TunnelResponse) types.TunnelResponse {
+	if resp.Body == "" || !banner.IsHTML(resp.Headers) {
+		return resp
+	}
+	// Compressed bodies can't be edited in place. Local responses arrive
+	// decoded; this only skips bodies another plugin encoded.
+	if enc := strings.ToLower(strings.TrimSpace(header(resp.Headers, "Content-Encoding"))); enc != "" && enc != "identity" {
+		return resp
+	}
+	body, err := base64.StdEncoding.DecodeString(resp.Body)
+	if err != nil {
+		return resp
+	}
+	_, params, _ := mime.ParseMediaType(header(resp.Headers, "Content-Type"))
+	charset := strings.ToLower(params["charset"])
+	out, ok := injectEncoded(body, charset, strings.Join(h.plugin.scripts.snippets, ""))
+	if !ok {
+		if charset == "" {
+			charset = "non-UTF-8"
+		}
+		if _, seen := h.plugin.warned.LoadOrStore(charset, true); !seen {
+			log.Printf("[inject] not injecting into %s pages: the scripts aren't ASCII", charset)
+		}
+		return resp
+	}
+	headers := make(map[string][]string, len(resp.Headers))
+	for k, v := range resp.Headers {
+		// The body grew; the worker sets the length again
+		if !strings.EqualFold(k, "Content-Length") {
+			headers[k] = v
+		}
+	}
+	resp.Headers = headers
+	resp.Body = base64.StdEncoding.EncodeToString(out)
+	return resp
+}
+
+// injectEncoded inserts snippet before the last </body> of body, encoding
+// it the way body is. UTF-16 bodies, by charset or byte order mark, are
+// rewritten whole. Other charsets are ASCII-compatible, so an ASCII snippet
+// goes in as is; one that isn't ASCII only goes into UTF-8 bodies, and ok
+// is false otherwise.
+func injectEncoded(body []byte, charset, snippet string) (out []byte, ok bool) {
+	switch {
+	case bytes.HasPrefix(body, []byte{0xFF, 0xFE}) && (charset == "" || strings.HasPrefix(charset, "utf-16")):
+		return append(body[:2:2], injectUTF16(body[2:], binary.LittleEndian, snippet)...), true
+	case bytes.HasPrefix(body, []byte{0xFE, 0xFF}) && (charset == "" || strings.HasPrefix(charset, "utf-16")):
+		return append(body[:2:2], injectUTF16(body[2:], binary.BigEndian, snippet)...), true
+	case charset == "utf-16le":
+		return injectUTF16(body, binary.LittleEndian, snippet), true
+	case charset == "utf-16be", charset == "utf-16":
+		return injectUTF16(body, binary.BigEndian, snippet), true
+	}
+	if !isASCII(snippet) {
+		utf8Body := charset == "utf-8" || charset == "utf8" || (charset == "" && utf8.Valid(body))
+		if !utf8Body {
+			return nil, false
+		}
+	}
+	return insertBeforeBody(body, []byte(snippet)), true
+}
+
+func injectUTF16(body []byte, order binary.ByteOrder, snippet string) []byte {
+	encode := func(s string) []byte {
+		units := utf16.Encode([]rune(s))
+		b := make([]byte, 2*len(units))
+		for i, u := range units {
+			order.PutUint16(b[2*i:], u)
+		}
+		return b
+	}
+	units := make([]uint16, len(body)/2)
+	for i := range units {
+		units[i] = order.Uint16(body[2*i:])
+	}
+	// Go through the text so a </body> split across code units can't match
+	text := string(utf16.Decode(units))
+	return encode(string(insertBeforeBody([]byte(text), []byte(snippet))))
+}
+
+// insertBeforeBody inserts snippet before the last </body> in body, or
+// appends it if there is none.
+func insertBeforeBody(body, snippet []byte) []byte {
+	// Lowered byte for byte, as bytes.ToLower can change the length
+	lower := make([]byte, len(body))
+	for i, c := range body {
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		lower[i] = c
+	}
+	idx := bytes.LastIndex(lower, []byte("</body>"))
+	if idx < 0 {
+		idx = len(body)
+	}
+	out := make([]byte, 0, len(body)+len(snippet))
+	out = append(out, body[:idx]...)
+	out = append(out, snippet...)
+	return append(out, body[idx:]...)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// header returns the first value of header name, whatever its case.
+func header(h map[string][]string, name string) string {
+	for k, vals := range h {
+		if strings.EqualFold(k, name) && len(vals) > 0 {
+			return vals[0]
+		}
+	}
+	return ""
+}
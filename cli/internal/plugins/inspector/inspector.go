@@ -0,0 +1,433 @@
+package inspector
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+// releaseMargin is how long before the worker's deadline a held request is
+// released unchanged, so the visitor still gets a response.
+const releaseMargin = 2 * time.Second
+
+// plugin serves a local web UI where requests matching the intercept paths
+// are held before proxying, and responses matching the response patterns
+// before they are returned, to be inspected, edited, released or dropped.
+type plugin struct {
+	enabled            bool
+	port               int
+	intercept          string
+	interceptResponses string
+
+	mu        sync.Mutex
+	paths     []string // intercept patterns, "[METHOD ]glob"; empty means intercept is off
+	responses []string // response patterns: statuses like 500 or 5xx, or request patterns
+	addr      string   // where the UI listens, once started
+	held      map[int]*heldRequest
+	nextID    int
+	started   bool
+}
+
+// heldRequest is a request, or the response to one, waiting for a decision
+// in the UI.
+type heldRequest struct {
+	ID        int
+	Subdomain string
+	Req       types.TunnelRequest
+	Resp      *types.TunnelResponse // set when the response is held
+	Received  time.Time
+	Expires   time.Time
+	decision  chan decision // buffered; the first decision wins
+}
+
+type decision struct {
+	drop bool
+	req  types.TunnelRequest  // the request to proxy, possibly edited
+	resp types.TunnelResponse // the response to return, possibly edited
+}
+
+// unchanged is the decision that lets h through as it is.
+func (h *heldRequest) unchanged() decision {
+	d := decision{req: h.Req}
+	if h.Resp != nil {
+		d.resp = *h.Resp
+	}
+	return d
+}
+
+func New() hooks.Plugin {
+	return &plugin{held: make(map[int]*heldRequest)}
+}
+
+func (p *plugin) Name() string { return "inspector" }
+func (p *plugin) RegisterFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&p.enabled, "inspect", false, "Serve a local UI for holding, editing and dropping requests before they reach the local server")
+	fs.IntVar(&p.port, "inspect-port", 4040, "Port for the -inspect UI")
+	fs.StringVar(&p.intercept, "intercept", "", "Comma-separated [METHOD] path globs to hold for editing (e.g. 'POST /api/*', /webhooks/*, or * for all); implies -inspect and can be changed in the UI")
+	fs.StringVar(&p.interceptResponses, "intercept-responses", "", "Comma-separated statuses (500, 5xx) or [METHOD] path globs whose buffered responses are held for editing before they are returned; implies -inspect")
+}
+func (p *plugin) Enabled() bool {
+	return p.enabled || p.intercept != "" || p.interceptResponses != ""
+}
+func (p *plugin) WorkerConfig() map[string]any { return nil }
+func (p *plugin) RequestHooks() []hooks.RequestHook {
+	p.paths = splitGlobs(p.intercept)
+	for _, pat := range p.paths {
+		if err := checkPattern(pat); err != nil {
+			log.Printf("[inspector] -intercept: %v", err)
+		}
+	}
+	p.responses = splitGlobs(p.interceptResponses)
+	for _, pat := range p.responses {
+		if err := checkResponsePattern(pat); err != nil {
+			log.Printf("[inspector] -intercept-responses: %v", err)
+		}
+	}
+	return []hooks.RequestHook{&reqHook{plugin: p}}
+}
+func (p *plugin) ConnectionHooks() []hooks.ConnectionHook {
+	return []hooks.ConnectionHook{&connHook{plugin: p}}
+}
+
+// startServer starts the inspector UI on first connect.
+func (p *plugin) startServer() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.started {
+		return
+	}
+	p.started = true
+	addr, err := startServer(p, p.port)
+	if err != nil {
+		log.Printf("[inspector] failed to start UI: %v", err)
+		return
+	}
+	p.addr = addr
+	log.Printf("[inspector] UI listening on http://%s", addr)
+}
+
+// splitPattern splits an intercept pattern into its method (empty for any)
+// and path glob.
+func splitPattern(pat string) (method, glob string) {
+	if m, g, ok := strings.Cut(pat, " "); ok {
+		return strings.ToUpper(m), strings.TrimSpace(g)
+	}
+	return "", pat
+}
+
+// checkPattern reports whether pat is a usable intercept pattern.
+func checkPattern(pat string) error {
+	method, glob := splitPattern(pat)
+	if method == "*" {
+		method = ""
+	}
+	if strings.ContainsAny(method, "/*?[") {
+		return fmt.Errorf("%q: the method must come first, e.g. POST /api/*", pat)
+	}
+	if glob == "*" {
+		return nil
+	}
+	if !strings.HasPrefix(glob, "/") {
+		return fmt.Errorf("%q: the path must start with / (or be * for all)", pat)
+	}
+	if _, err := path.Match(glob, "/"); err != nil {
+		return fmt.Errorf("%q: bad path glob", pat)
+	}
+	return nil
+}
+
+// isStatusPattern reports whether pat is a status code or class such as
+// 404 or 5xx.
+func isStatusPattern(pat string) bool {
+	if len(pat) != 3 || pat[0] < '1' || pat[0] > '5' {
+		return false
+	}
+	for _, c := range pat[1:] {
+		if (c < '0' || c > '9') && c != 'x' && c != 'X' {
+			return false
+		}
+	}
+	return true
+}
+
+// checkResponsePattern reports whether pat is a usable response pattern.
+func checkResponsePattern(pat string) error {
+	if isStatusPattern(pat) {
+		return nil
+	}
+	if err := checkPattern(pat); err != nil {
+		return fmt.Errorf("%w, or a status like 500 or 5xx", err)
+	}
+	return nil
+}
+
+// matchStatus reports whether status matches the status pattern pat.
+func matchStatus(pat string, status int) bool {
+	code := fmt.Sprint(status)
+	if len(code) != 3 {
+		return false
+	}
+	for i := range 3 {
+		if pat[i] != 'x' && pat[i] != 'X' && pat[i] != code[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// matchRequest reports whether a request matches the intercept pattern pat.
+func matchRequest(pat, method, reqPath string) bool {
+	m, glob := splitPattern(pat)
+	if m != "" && m != "*" && m != method {
+		return false
+	}
+	if glob == "*" {
+		return true
+	}
+	if ok, _ := path.Match(glob, reqPath); ok {
+		return true
+	}
+	dir, ok := strings.CutSuffix(glob, "*")
+	return ok && strings.HasSuffix(dir, "/") && strings.HasPrefix(reqPath, dir)
+}
+
+// shouldHold reports whether a request matches the intercept patterns.
+func (p *plugin) shouldHold(method, reqPath string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	reqPath, _, _ = strings.Cut(reqPath, "?")
+	for _, pat := range p.paths {
+		if matchRequest(pat, method, reqPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldHoldResponse reports whether the response to a request matches the
+// response patterns.
+func (p *plugin) shouldHoldResponse(method, reqPath string, status int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	reqPath, _, _ = strings.Cut(reqPath, "?")
+	for _, pat := range p.responses {
+		if isStatusPattern(pat) {
+			if matchStatus(pat, status) {
+				return true
+			}
+		} else if matchRequest(pat, method, reqPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// hold parks req until it is released or dropped in the UI, or until the
+// deadline, and returns the decision.
+func (p *plugin) hold(subdomain string, req types.TunnelRequest, deadline time.Time) decision {
+	return p.park(&heldRequest{Subdomain: subdomain, Req: req}, deadline)
+}
+
+// holdResponse is hold for the response to req.
+func (p *plugin) holdResponse(subdomain string, req types.TunnelRequest, resp types.TunnelResponse, deadline time.Time) decision {
+	return p.park(&heldRequest{Subdomain: subdomain, Req: req, Resp: &resp}, deadline)
+}
+
+// park waits for a decision on h, releasing it unchanged at the deadline.
+func (p *plugin) park(h *heldRequest, deadline time.Time) decision {
+	now := time.Now()
+	expires := now.Add(30 * time.Second)
+	if !deadline.IsZero() {
+		expires = deadline.Add(-releaseMargin)
+	}
+	h.Received, h.Expires = now, expires
+	h.decision = make(chan decision, 1)
+	req := h.Req
+	what := fmt.Sprintf("%s %s", req.Method, req.Path)
+	if h.Resp != nil {
+		what = fmt.Sprintf("the %d response to %s", h.Resp.Status, what)
+	}
+	p.mu.Lock()
+	p.nextID++
+	h.ID = p.nextID
+	p.held[h.ID] = h
+	addr := p.addr
+	p.mu.Unlock()
+	if addr != "" {
+		log.Printf("[inspector] holding %s (#%d): release, edit or drop it at http://%s", what, h.ID, addr)
+	}
+
+	defer func() {
+		p.mu.Lock()
+		delete(p.held, h.ID)
+		p.mu.Unlock()
+	}()
+
+	timer := time.NewTimer(time.Until(expires))
+	defer timer.Stop()
+	select {
+	case d := <-h.decision:
+		return d
+	case <-timer.C:
+		log.Printf("[inspector] releasing %s unchanged: no decision before the deadline", what)
+		return h.unchanged()
+	}
+}
+
+// decide delivers a decision for a held request. It returns false if the
+// request is no longer held.
+func (p *plugin) decide(id int, d decision) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h, ok := p.held[id]
+	if !ok {
+		return false
+	}
+	delete(p.held, id)
+	h.decision <- d
+	return true
+}
+
+// lookup returns what is held under id.
+func (p *plugin) lookup(id int) (heldRequest, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h, ok := p.held[id]
+	if !ok {
+		return heldRequest{}, false
+	}
+	return *h, true
+}
+
+// pending returns the held requests, oldest first.
+func (p *plugin) pending() []*heldRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]*heldRequest, 0, len(p.held))
+	for _, h := range p.held {
+		out = append(out, h)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// releaseAll lets every held request, or every held response, through
+// unchanged, e.g. when intercept is turned off.
+func (p *plugin) releaseAll(responses bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, h := range p.held {
+		if (h.Resp != nil) != responses {
+			continue
+		}
+		delete(p.held, id)
+		h.decision <- h.unchanged()
+	}
+}
+
+func (p *plugin) interceptPatterns() (paths, responses []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string{}, p.paths...), append([]string{}, p.responses...)
+}
+
+func (p *plugin) setInterceptPaths(paths []string) {
+	p.mu.Lock()
+	p.paths = paths
+	p.mu.Unlock()
+	if len(paths) == 0 {
+		p.releaseAll(false)
+	}
+}
+
+func (p *plugin) setInterceptResponses(responses []string) {
+	p.mu.Lock()
+	p.responses = responses
+	p.mu.Unlock()
+	if len(responses) == 0 {
+		p.releaseAll(true)
+	}
+}
+
+func splitGlobs(s string) []string {
+	var out []string
+	for _, g := range strings.Split(s, ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			out = append(out, g)
+		}
+	}
+	return out
+}
+
+// --- Hooks ---
+
+// droppedKey marks a request dropped in the UI, for Respond to answer.
+const droppedKey = "inspector.dropped"
+
+type reqHook struct {
+	hooks.NoOpRequestHook
+	plugin *plugin
+}
+
+func (h *reqHook) BeforeProxy(ctx *hooks.RequestContext, req types.TunnelRequest) types.TunnelRequest {
+	if !h.plugin.shouldHold(req.Method, req.Path) {
+		return req
+	}
+	d := h.plugin.hold(ctx.Subdomain, req, ctx.Deadline)
+	if d.drop {
+		ctx.Values[droppedKey] = true
+		return req
+	}
+	return d.req
+}
+
+// AfterProxy holds buffered responses matching the response patterns.
+// Streamed responses have already started reaching the visitor and pass
+// through.
+func (h *reqHook) AfterProxy(ctx *hooks.RequestContext, req types.TunnelRequest, resp types.TunnelResponse) types.TunnelResponse {
+	if ctx.Streamed || ctx.Values[droppedKey] == true || !h.plugin.shouldHoldResponse(req.Method, req.Path, resp.Status) {
+		return resp
+	}
+	d := h.plugin.holdResponse(ctx.Subdomain, req, resp, ctx.Deadline)
+	if d.drop {
+		return types.TunnelResponse{
+			Type:    resp.Type,
+			ID:      resp.ID,
+			Status:  http.StatusBadGateway,
+			Headers: map[string][]string{"Content-Type": {"text/plain; charset=utf-8"}},
+			Body:    base64.StdEncoding.EncodeToString([]byte("Response dropped by inspector")),
+		}
+	}
+	return d.resp
+}
+
+// Respond answers requests dropped in the UI with a 502.
+func (h *reqHook) Respond(ctx *hooks.RequestContext, _ types.TunnelRequest) (types.TunnelResponse, bool) {
+	if ctx.Values[droppedKey] != true {
+		return types.TunnelResponse{}, false
+	}
+	return types.TunnelResponse{
+		Status:  http.StatusBadGateway,
+		Headers: map[string][]string{"Content-Type": {"text/plain; charset=utf-8"}},
+		Body:    base64.StdEncoding.EncodeToString([]byte("Request dropped by inspector")),
+	}, true
+}
+
+type connHook struct {
+	hooks.NoOpConnectionHook
+	plugin *plugin
+}
+
+func (h *connHook) OnConnect(_ string, _ int, _ *hooks.WarmupResult) {
+	h.plugin.startServer()
+}
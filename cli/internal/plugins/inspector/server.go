@@ -0,0 +1,299 @@
+package inspector
+
+import (
+	"embed"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/protobuf"
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+//go:embed index.html
+var uiHTML embed.FS
+
+type heldJSON struct {
+	ID         int                 `json:"id"`
+	Subdomain  string              `json:"subdomain"`
+	Method     string              `json:"method"`
+	Path       string              `json:"path"`
+	Headers    map[string][]string `json:"headers"`
+	Body       string              `json:"body"`
+	BodyBase64 bool                `json:"body_base64,omitempty"` // body isn't UTF-8 and is sent as base64
+	// BodyDecoded is a protobuf or gRPC-web body as JSON, for reading only
+	BodyDecoded string        `json:"body_decoded,omitempty"`
+	Response    *responseJSON `json:"response,omitempty"` // set when the response is held
+	ReceivedAt  int64         `json:"received_at"`
+	ExpiresAt   int64         `json:"expires_at"` // released unchanged at this time (unix seconds)
+}
+
+type responseJSON struct {
+	Status      int                 `json:"status"`
+	Headers     map[string][]string `json:"headers"`
+	Body        string              `json:"body"`
+	BodyBase64  bool                `json:"body_base64,omitempty"`
+	BodyDecoded string              `json:"body_decoded,omitempty"`
+}
+
+// editJSON is the body of a release. Omitted fields keep the original value.
+// For a held response, status, headers and body edit the response and method
+// and path are ignored.
+type editJSON struct {
+	Method     *string             `json:"method"`
+	Path       *string             `json:"path"`
+	Status     *int                `json:"status"`
+	Headers    map[string][]string `json:"headers"`
+	Body       *string             `json:"body"`
+	BodyBase64 bool                `json:"body_base64"`
+}
+
+// interceptJSON holds the intercept patterns. In a PUT, an omitted list is
+// left as it is.
+type interceptJSON struct {
+	Paths     []string `json:"paths"`
+	Responses []string `json:"responses"`
+}
+
+// startServer serves the inspector UI and API on localhost and returns the
+// address it listens on.
+func startServer(p *plugin, port int) (string, error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/inspector/requests", p.handleList)
+	mux.HandleFunc("POST /api/inspector/requests/{id}/release", p.handleRelease)
+	mux.HandleFunc("POST /api/inspector/requests/{id}/drop", p.handleDrop)
+	mux.HandleFunc("GET /api/inspector/intercept", p.handleGetIntercept)
+	mux.HandleFunc("PUT /api/inspector/intercept", p.handleSetIntercept)
+	mux.HandleFunc("GET /", func(w http.ResponseWriter, r *http.Request) {
+		data, _ := uiHTML.ReadFile("index.html")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(data)
+	})
+
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return "", err
+	}
+	// No CORS headers: the API changes live traffic, so only the UI's own
+	// origin may call it. Requiring JSON bodies keeps plain cross-site form
+	// posts out as well.
+	go func() {
+		if err := http.Serve(ln, jsonOnly(mux)); err != nil {
+			log.Printf("[inspector] server error: %v", err)
+		}
+	}()
+	return ln.Addr().String(), nil
+}
+
+// jsonOnly rejects state-changing requests that aren't application/json.
+func jsonOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+				http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (p *plugin) handleList(w http.ResponseWriter, r *http.Request) {
+	held := p.pending()
+	out := make([]heldJSON, 0, len(held))
+	for _, h := range held {
+		hj := heldJSON{
+			ID:         h.ID,
+			Subdomain:  h.Subdomain,
+			Method:     h.Req.Method,
+			Path:       h.Req.Path,
+			Headers:    h.Req.Headers,
+			ReceivedAt: h.Received.Unix(),
+			ExpiresAt:  h.Expires.Unix(),
+		}
+		hj.Body, hj.BodyBase64 = bodyText(h.Req.Body)
+		hj.BodyDecoded = decodedBody(h.Req.Path, h.Req.Headers, h.Req.Body, false)
+		if h.Resp != nil {
+			rj := &responseJSON{Status: h.Resp.Status, Headers: h.Resp.Headers}
+			rj.Body, rj.BodyBase64 = bodyText(h.Resp.Body)
+			rj.BodyDecoded = decodedBody(h.Req.Path, h.Resp.Headers, h.Resp.Body, true)
+			hj.Response = rj
+		}
+		out = append(out, hj)
+	}
+	writeJSON(w, map[string]any{"requests": out})
+}
+
+func (p *plugin) handleRelease(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	var edit editJSON
+	if err := json.NewDecoder(r.Body).Decode(&edit); err != nil {
+		http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	h, ok := p.lookup(id)
+	if !ok {
+		http.Error(w, "request is no longer held", http.StatusNotFound)
+		return
+	}
+	d := h.unchanged()
+	if h.Resp != nil {
+		d.resp, err = applyResponseEdit(d.resp, edit)
+	} else {
+		d.req, err = applyEdit(d.req, edit)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !p.decide(id, d) {
+		http.Error(w, "request is no longer held", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, map[string]any{"released": id})
+}
+
+func (p *plugin) handleDrop(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	if !p.decide(id, decision{drop: true}) {
+		http.Error(w, "request is no longer held", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, map[string]any{"dropped": id})
+}
+
+func (p *plugin) handleGetIntercept(w http.ResponseWriter, r *http.Request) {
+	paths, responses := p.interceptPatterns()
+	writeJSON(w, interceptJSON{Paths: paths, Responses: responses})
+}
+
+// handleSetIntercept replaces the intercept patterns. An empty list turns
+// that kind of intercept off and releases everything it held.
+func (p *plugin) handleSetIntercept(w http.ResponseWriter, r *http.Request) {
+	var in interceptJSON
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	paths := splitGlobs(strings.Join(in.Paths, ","))
+	for _, pat := range paths {
+		if err := checkPattern(pat); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	responses := splitGlobs(strings.Join(in.Responses, ","))
+	for _, pat := range responses {
+		if err := checkResponsePattern(pat); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if in.Paths != nil {
+		p.setInterceptPaths(paths)
+	}
+	if in.Responses != nil {
+		p.setInterceptResponses(responses)
+	}
+	p.handleGetIntercept(w, r)
+}
+
+// bodyText returns a base64 body as text, or as is with isBase64 set if it
+// isn't UTF-8.
+func bodyText(b64 string) (text string, isBase64 bool) {
+	body, err := base64.StdEncoding.DecodeString(b64)
+	if err == nil && utf8.Valid(body) {
+		return string(body), false
+	}
+	return b64, true
+}
+
+// decodedBody renders a base64 protobuf body as JSON, or "" if it isn't one.
+func decodedBody(reqPath string, headers map[string][]string, b64 string, response bool) string {
+	body, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return ""
+	}
+	text, _, _ := protobuf.DecodeBody(reqPath, headers, body, response)
+	return text
+}
+
+// editedBody returns an edited body in base64.
+func editedBody(body string, isBase64 bool) (string, error) {
+	if !isBase64 {
+		return base64.StdEncoding.EncodeToString([]byte(body)), nil
+	}
+	if _, err := base64.StdEncoding.DecodeString(body); err != nil {
+		return "", fmt.Errorf("body is not valid base64")
+	}
+	return body, nil
+}
+
+// applyResponseEdit returns resp with the fields set in edit replaced.
+func applyResponseEdit(resp types.TunnelResponse, edit editJSON) (types.TunnelResponse, error) {
+	if edit.Status != nil {
+		if *edit.Status < 100 || *edit.Status > 599 {
+			return resp, fmt.Errorf("invalid status %d", *edit.Status)
+		}
+		resp.Status = *edit.Status
+	}
+	if edit.Headers != nil {
+		resp.Headers = edit.Headers
+	}
+	if edit.Body != nil {
+		body, err := editedBody(*edit.Body, edit.BodyBase64)
+		if err != nil {
+			return resp, err
+		}
+		resp.Body = body
+	}
+	return resp, nil
+}
+
+// applyEdit returns req with the fields set in edit replaced.
+func applyEdit(req types.TunnelRequest, edit editJSON) (types.TunnelRequest, error) {
+	if edit.Method != nil {
+		m := strings.ToUpper(strings.TrimSpace(*edit.Method))
+		if m == "" || strings.ContainsAny(m, " \t\r\n") {
+			return req, fmt.Errorf("invalid method %q", *edit.Method)
+		}
+		req.Method = m
+	}
+	if edit.Path != nil {
+		if !strings.HasPrefix(*edit.Path, "/") {
+			return req, fmt.Errorf("path must start with /")
+		}
+		req.Path = *edit.Path
+	}
+	if edit.Headers != nil {
+		req.Headers = edit.Headers
+	}
+	if edit.Body != nil {
+		body, err := editedBody(*edit.Body, edit.BodyBase64)
+		if err != nil {
+			return req, err
+		}
+		req.Body = body
+	}
+	return req, nil
+}
@@ -2,13 +2,22 @@ package ipallow
 
 import (
 	"flag"
+	"fmt"
+	"log"
+	"net/netip"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
 )
 
 type plugin struct {
 	allowIPs *string
+	local    bool                           // checked by the CLI (-access) instead of the worker
+	prefixes atomic.Pointer[[]netip.Prefix] // parsed -allow-ip, when local
+	pushed   sync.Map                       // subdomain -> []netip.Prefix the worker pushed, when local
 }
 
 func New() hooks.Plugin {
@@ -24,6 +33,13 @@ func (p *plugin) RegisterFlags(fs *flag.FlagSet) {
 func (p *plugin) Enabled() bool { return p.allowIPs != nil && *p.allowIPs != "" }
 
 func (p *plugin) WorkerConfig() map[string]any {
+	if p.local {
+		return nil
+	}
+	return map[string]any{"allowIps": p.entries()}
+}
+
+func (p *plugin) entries() []string {
 	parts := strings.Split(*p.allowIPs, ",")
 	ips := make([]string, 0, len(parts))
 	for _, s := range parts {
@@ -32,8 +48,118 @@ func (p *plugin) WorkerConfig() map[string]any {
 			ips = append(ips, s)
 		}
 	}
-	return map[string]any{"allowIps": ips}
+	return ips
+}
+
+// Reload re-parses the allowlist when the CLI checks it; otherwise it only
+// lives in WorkerConfig, which the caller re-sends.
+func (p *plugin) Reload() error {
+	if !p.local {
+		return nil
+	}
+	return p.parse()
+}
+
+func (p *plugin) RequestHooks() []hooks.RequestHook { return nil }
+func (p *plugin) ConnectionHooks() []hooks.ConnectionHook {
+	return []hooks.ConnectionHook{connHook{plugin: p}}
+}
+
+func (p *plugin) Authenticator() hooks.Authenticator { return authenticator{p} }
+func (p *plugin) EnforceLocally() error {
+	p.local = true
+	return p.parse()
+}
+
+func (p *plugin) parse() error {
+	prefixes, err := parsePrefixes(p.entries())
+	if err != nil {
+		return fmt.Errorf("-allow-ip: %w", err)
+	}
+	p.prefixes.Store(&prefixes)
+	return nil
+}
+
+func parsePrefixes(entries []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, e := range entries {
+		if !strings.Contains(e, "/") {
+			addr, err := netip.ParseAddr(e)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(e)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
 }
 
-func (p *plugin) RequestHooks() []hooks.RequestHook       { return nil }
-func (p *plugin) ConnectionHooks() []hooks.ConnectionHook { return nil }
+// connHook applies an allowlist the worker pushes (an admin tightening it
+// mid-session) when the CLI checks it; the worker checks its own copy.
+type connHook struct {
+	hooks.NoOpConnectionHook
+	plugin *plugin
+}
+
+// OnConfigUpdate replaces the tunnel's allowlist with a pushed non-empty
+// "allowIps", or goes back to -allow-ip without one. A list that doesn't
+// parse is ignored.
+func (h connHook) OnConfigUpdate(subdomain string, config map[string]any) {
+	if !h.plugin.local {
+		return
+	}
+	list, _ := config["allowIps"].([]any)
+	if len(list) == 0 {
+		h.plugin.pushed.Delete(subdomain)
+		return
+	}
+	entries := make([]string, 0, len(list))
+	for _, v := range list {
+		s, _ := v.(string)
+		entries = append(entries, strings.TrimSpace(s))
+	}
+	prefixes, err := parsePrefixes(entries)
+	if err != nil {
+		log.Printf("[ipallow] ignoring allowIps pushed for %s: %v", subdomain, err)
+		return
+	}
+	h.plugin.pushed.Store(subdomain, prefixes)
+}
+
+// authenticator is the "ip" scheme. The visitor's address is the one
+// Cloudflare puts in CF-Connecting-IP, which visitors can't set.
+type authenticator struct {
+	plugin *plugin
+}
+
+func (a authenticator) Name() string { return "ip" }
+
+func (a authenticator) Verify(ctx *hooks.RequestContext, req types.TunnelRequest) (string, bool) {
+	prefixes := a.plugin.prefixes.Load()
+	if pushed, ok := a.plugin.pushed.Load(ctx.Subdomain); ok {
+		list := pushed.([]netip.Prefix)
+		prefixes = &list
+	}
+	addr, err := netip.ParseAddr(hooks.CanonicalHeader(req.Headers).Get("Cf-Connecting-Ip"))
+	if prefixes == nil || err != nil {
+		return "", false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range *prefixes {
+		if prefix.Contains(addr) {
+			return "", true
+		}
+	}
+	return "", false
+}
+
+// Challenge has nothing to offer: visitors can't change their address.
+func (a authenticator) Challenge(_ *hooks.RequestContext, _ types.TunnelRequest) (types.TunnelResponse, bool) {
+	return types.TunnelResponse{}, false
+}
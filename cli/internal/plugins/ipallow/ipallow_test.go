@@ -0,0 +1,59 @@
+package ipallow
+
+import (
+	"testing"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+func from(addr string) types.TunnelRequest {
+	return types.TunnelRequest{Headers: map[string][]string{"Cf-Connecting-Ip": {addr}}}
+}
+
+func allowed(p *plugin, subdomain, addr string) bool {
+	ctx := hooks.NewRequestContext(hooks.NewTunnelContext(subdomain, 3000), time.Now())
+	_, ok := p.Authenticator().Verify(ctx, from(addr))
+	return ok
+}
+
+func TestPushedAllowlist(t *testing.T) {
+	list := "10.0.0.0/8, 2001:db8::1"
+	p := &plugin{allowIPs: &list}
+	if err := p.EnforceLocally(); err != nil {
+		t.Fatal(err)
+	}
+	if !allowed(p, "abc", "10.1.2.3") || !allowed(p, "abc", "::ffff:10.1.2.3") || allowed(p, "abc", "192.0.2.1") {
+		t.Fatal("-allow-ip not applied")
+	}
+
+	hook := p.ConnectionHooks()[0].(hooks.ConfigUpdateHook)
+	// Tightened for one tunnel
+	hook.OnConfigUpdate("abc", map[string]any{"allowIps": []any{"10.9.0.0/16"}})
+	if allowed(p, "abc", "10.1.2.3") || !allowed(p, "abc", "10.9.0.1") {
+		t.Fatal("pushed allowlist not applied")
+	}
+	if !allowed(p, "other", "10.1.2.3") {
+		t.Fatal("pushed allowlist applied to another tunnel")
+	}
+	// Garbage keeps what was there
+	hook.OnConfigUpdate("abc", map[string]any{"allowIps": []any{"nope"}})
+	if allowed(p, "abc", "10.1.2.3") {
+		t.Fatal("bad push loosened the allowlist")
+	}
+	// Override removed
+	hook.OnConfigUpdate("abc", map[string]any{})
+	if !allowed(p, "abc", "10.1.2.3") {
+		t.Fatal("-allow-ip not back after the override went")
+	}
+}
+
+func TestPushIgnoredWhenWorkerChecks(t *testing.T) {
+	list := "10.0.0.0/8"
+	p := &plugin{allowIPs: &list}
+	p.ConnectionHooks()[0].(hooks.ConfigUpdateHook).OnConfigUpdate("abc", map[string]any{"allowIps": []any{"192.0.2.0/24"}})
+	if _, ok := p.pushed.Load("abc"); ok {
+		t.Fatal("push kept although the worker enforces the allowlist")
+	}
+}
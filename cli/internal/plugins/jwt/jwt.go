@@ -0,0 +1,273 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+// leeway absorbs clock skew between the token issuer and us.
+const leeway = time.Minute
+
+// Plugin lets through requests carrying a valid JWT as a bearer token,
+// for API clients and services in front of the tunnel. Tokens are signed
+// with a shared secret (HS256/384/512) or a key pair whose public half is
+// given (RS*, PS*, ES*). It is the "jwt" scheme of the access plugin.
+type Plugin struct {
+	secret    string
+	publicKey string // PEM file
+	issuer    string
+	audience  string
+
+	key crypto.PublicKey // parsed -jwt-public-key
+}
+
+func New() *Plugin {
+	return &Plugin{}
+}
+
+func (p *Plugin) Name() string { return "jwt" }
+func (p *Plugin) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&p.secret, "jwt-secret", "", "Require a bearer JWT signed with this HMAC secret (HS256/384/512)")
+	fs.StringVar(&p.publicKey, "jwt-public-key", "", "Require a bearer JWT signed with the key pair of this PEM public key (RS*, PS* or ES*)")
+	fs.StringVar(&p.issuer, "jwt-issuer", "", "Only accept JWTs whose iss claim is this")
+	fs.StringVar(&p.audience, "jwt-audience", "", "Only accept JWTs whose aud claim includes this")
+}
+func (p *Plugin) Enabled() bool                           { return p.secret != "" || p.publicKey != "" }
+func (p *Plugin) WorkerConfig() map[string]any            { return nil }
+func (p *Plugin) RequestHooks() []hooks.RequestHook       { return nil }
+func (p *Plugin) ConnectionHooks() []hooks.ConnectionHook { return nil }
+func (p *Plugin) Authenticator() hooks.Authenticator      { return authenticator{p} }
+
+// Validate checks the flags and loads the public key. Call after flags are
+// parsed, before registering.
+func (p *Plugin) Validate() error {
+	if !p.Enabled() {
+		return nil
+	}
+	if p.secret != "" && p.publicKey != "" {
+		return errors.New("-jwt-secret and -jwt-public-key can't be combined")
+	}
+	if p.publicKey == "" {
+		return nil
+	}
+	data, err := os.ReadFile(p.publicKey)
+	if err != nil {
+		return fmt.Errorf("-jwt-public-key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return fmt.Errorf("-jwt-public-key: %s holds no PEM block", p.publicKey)
+	}
+	var key any
+	switch block.Type {
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return fmt.Errorf("-jwt-public-key: %w", err)
+	}
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+	default:
+		return fmt.Errorf("-jwt-public-key: unsupported key type %T", key)
+	}
+	p.key = key
+	return nil
+}
+
+// claims are the registered claims checked on every token.
+type claims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	Expiry    *int64   `json:"exp"`
+	NotBefore *int64   `json:"nbf"`
+}
+
+// audience is the aud claim, a string or a list of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// verify checks token's signature and claims and returns its subject.
+func (p *Plugin) verify(token string, now time.Time) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", errors.New("malformed signature")
+	}
+	if err := p.checkSignature(header.Alg, parts[0]+"."+parts[1], sig); err != nil {
+		return "", err
+	}
+
+	var c claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return "", err
+	}
+	switch {
+	case c.Expiry != nil && now.Add(-leeway).Unix() > *c.Expiry:
+		return "", errors.New("token expired")
+	case c.NotBefore != nil && now.Add(leeway).Unix() < *c.NotBefore:
+		return "", errors.New("token not valid yet")
+	case p.issuer != "" && c.Issuer != p.issuer:
+		return "", fmt.Errorf("token from issuer %q", c.Issuer)
+	case p.audience != "" && !slices.Contains(c.Audience, p.audience):
+		return "", errors.New("token is not for this audience")
+	}
+	return c.Subject, nil
+}
+
+// checkSignature verifies sig over signed with alg, which must suit the
+// configured key: an HMAC secret only accepts HS*, a public key only its
+// own family, so a token can't pick a weaker check.
+func (p *Plugin) checkSignature(alg, signed string, sig []byte) error {
+	var h func() hash.Hash
+	var ch crypto.Hash
+	switch alg[min(len(alg), 2):] {
+	case "256":
+		h, ch = sha256.New, crypto.SHA256
+	case "384":
+		h, ch = sha512.New384, crypto.SHA384
+	case "512":
+		h, ch = sha512.New, crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported alg %q", alg)
+	}
+	family := alg[:2]
+
+	if p.key == nil {
+		if family != "HS" {
+			return fmt.Errorf("alg %s doesn't match -jwt-secret", alg)
+		}
+		m := hmac.New(h, []byte(p.secret))
+		m.Write([]byte(signed))
+		if !hmac.Equal(sig, m.Sum(nil)) {
+			return errors.New("bad signature")
+		}
+		return nil
+	}
+
+	d := h()
+	d.Write([]byte(signed))
+	digest := d.Sum(nil)
+	switch key := p.key.(type) {
+	case *rsa.PublicKey:
+		switch family {
+		case "RS":
+			if rsa.VerifyPKCS1v15(key, ch, digest, sig) != nil {
+				return errors.New("bad signature")
+			}
+			return nil
+		case "PS":
+			if rsa.VerifyPSS(key, ch, digest, sig, nil) != nil {
+				return errors.New("bad signature")
+			}
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if family == "ES" && len(sig) == 2*size {
+			r := new(big.Int).SetBytes(sig[:size])
+			s := new(big.Int).SetBytes(sig[size:])
+			if !ecdsa.Verify(key, digest, r, s) {
+				return errors.New("bad signature")
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("alg %s doesn't match -jwt-public-key", alg)
+}
+
+func decodeSegment(seg string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return errors.New("malformed token")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.New("malformed token")
+	}
+	return nil
+}
+
+// authenticator is the "jwt" scheme.
+type authenticator struct {
+	plugin *Plugin
+}
+
+func (a authenticator) Name() string { return a.plugin.Name() }
+
+// Verify returns the token's subject.
+func (a authenticator) Verify(_ *hooks.RequestContext, req types.TunnelRequest) (string, bool) {
+	header := hooks.CanonicalHeader(req.Headers).Get("Authorization")
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return "", false
+	}
+	sub, err := a.plugin.verify(strings.TrimSpace(token), time.Now())
+	return sub, err == nil
+}
+
+func (a authenticator) Challenge(_ *hooks.RequestContext, req types.TunnelRequest) (types.TunnelResponse, bool) {
+	challenge := `Bearer realm="Tunnel"`
+	if strings.HasPrefix(hooks.CanonicalHeader(req.Headers).Get("Authorization"), "Bearer ") {
+		challenge += `, error="invalid_token"`
+	}
+	return types.TunnelResponse{
+		Status: http.StatusUnauthorized,
+		Headers: map[string][]string{
+			"Www-Authenticate": {challenge},
+			"Content-Type":     {"text/plain; charset=utf-8"},
+			"Cache-Control":    {"no-store"},
+		},
+		Body: base64.StdEncoding.EncodeToString([]byte("Unauthorized")),
+	}, true
+}
@@ -0,0 +1,282 @@
+package magiclink
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/mail"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/config"
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+	"github.com/QuadTriangle/prod.bd/cli/statsapi"
+)
+
+// LinkPrefix is where links point on the public tunnel: LinkPrefix + ID +
+// "." + signature.
+const LinkPrefix = hooks.ReservedPrefix + "link/"
+
+// continuePage asks the visitor to confirm before the link is used up. The
+// verb is the escaped email the link is for; the form posts back to the
+// link itself.
+const continuePage = `<!doctype html><html><head><meta charset="utf-8">` +
+	`<meta name="viewport" content="width=device-width,initial-scale=1"><meta name="robots" content="noindex">` +
+	`<title>Open preview</title></head>` +
+	`<body style="font:15px/1.5 system-ui,sans-serif;display:flex;justify-content:center;padding-top:15vh">` +
+	`<form method="post" style="display:flex;flex-direction:column;gap:10px;width:280px">` +
+	`<strong>You were invited to this preview</strong><span>Continue as %s</span>` +
+	`<button type="submit" style="padding:6px 8px;font:inherit;cursor:pointer">Continue</button></form></body></html>`
+
+// maxLinks bounds the links kept; the oldest used, revoked or expired ones
+// are forgotten first.
+const maxLinks = 1000
+
+// Plugin gates tunnels with magic links: single-use URLs, made with `prod
+// link new`, that sign one person in. Links and the sessions they start
+// can be revoked at any time. Links live in memory, so a restart voids
+// them. It is the "link" scheme of the access plugin.
+type Plugin struct {
+	enabled    bool
+	linkTTL    time.Duration
+	sessionTTL time.Duration
+
+	sessions *hooks.Sessions // identity is the link ID
+	key      []byte          // signs link URLs
+
+	mu    sync.Mutex
+	links map[string]*link // keyed by ID
+	order []string         // IDs, oldest first
+}
+
+type link struct {
+	statsapi.Link
+	sig string
+}
+
+func New() *Plugin {
+	return &Plugin{links: make(map[string]*link)}
+}
+
+func (p *Plugin) Name() string { return "magiclink" }
+func (p *Plugin) RegisterFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&p.enabled, "magic-links", false, "Only let in visitors who opened a single-use link made with `prod link new -email <addr>`")
+	fs.DurationVar(&p.linkTTL, "magic-link-ttl", 24*time.Hour, "How long a new magic link can be opened, unless prod link new -ttl says otherwise")
+	fs.DurationVar(&p.sessionTTL, "magic-link-session", 7*24*time.Hour, "How long a sign-in through a magic link lasts")
+}
+func (p *Plugin) Enabled() bool                           { return p.enabled }
+func (p *Plugin) WorkerConfig() map[string]any            { return nil }
+func (p *Plugin) RequestHooks() []hooks.RequestHook       { return nil }
+func (p *Plugin) ConnectionHooks() []hooks.ConnectionHook { return nil }
+func (p *Plugin) PathHandlers() []hooks.PathHandler {
+	return []hooks.PathHandler{&pathHandler{plugin: p}}
+}
+func (p *Plugin) Authenticator() hooks.Authenticator { return authenticator{p} }
+
+// Validate checks the flags. Call after flags are parsed, before
+// registering.
+func (p *Plugin) Validate() error {
+	if !p.enabled {
+		return nil
+	}
+	if p.linkTTL <= 0 || p.sessionTTL <= 0 {
+		return errors.New("-magic-link-ttl and -magic-link-session must be positive")
+	}
+	p.sessions = hooks.NewSessions("link", p.sessionTTL)
+	p.key = make([]byte, 32)
+	rand.Read(p.key)
+	return nil
+}
+
+// NewLink makes a link that signs email in to the tunnel on subdomain and
+// lands on path. ttl 0 means -magic-link-ttl.
+func (p *Plugin) NewLink(subdomain, email, path string, ttl time.Duration) (statsapi.Link, error) {
+	if !p.enabled {
+		return statsapi.Link{}, errors.New("magic links are off; start the tunnel with -magic-links")
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil {
+		return statsapi.Link{}, fmt.Errorf("invalid email %q", email)
+	}
+	if path == "" {
+		path = "/"
+	}
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") {
+		return statsapi.Link{}, fmt.Errorf("path must start with a single /, got %q", path)
+	}
+	if ttl <= 0 {
+		ttl = p.linkTTL
+	}
+	b := make([]byte, 6)
+	rand.Read(b)
+	now := time.Now()
+	l := &link{Link: statsapi.Link{
+		ID:        hex.EncodeToString(b),
+		Subdomain: subdomain,
+		Email:     strings.ToLower(addr.Address),
+		Path:      path,
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	}}
+	l.sig = p.sign(l.Link)
+	p.mu.Lock()
+	p.add(l, now)
+	p.mu.Unlock()
+	out := l.Link
+	out.URL = config.PublicURL(subdomain) + LinkPrefix + l.ID + "." + l.sig
+	log.Printf("[magiclink] link %s for %s on %s, valid until %s", l.ID, l.Email, subdomain, time.Unix(l.ExpiresAt, 0).Format(time.DateTime))
+	return out, nil
+}
+
+// add stores l, making room if needed. Call with p.mu held.
+func (p *Plugin) add(l *link, now time.Time) {
+	if len(p.order) >= maxLinks {
+		i := slices.IndexFunc(p.order, func(id string) bool {
+			old := p.links[id]
+			return old.Revoked || old.UsedAt != 0 || now.Unix() > old.ExpiresAt
+		})
+		if i < 0 {
+			i = 0
+		}
+		delete(p.links, p.order[i])
+		p.order = slices.Delete(p.order, i, i+1)
+	}
+	p.links[l.ID] = l
+	p.order = append(p.order, l.ID)
+}
+
+// Links returns the links of the tunnel on subdomain, or all, newest first.
+// Their URLs are left out: only whoever made a link gets it.
+func (p *Plugin) Links(subdomain string) []statsapi.Link {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := []statsapi.Link{}
+	for _, id := range slices.Backward(p.order) {
+		if l := p.links[id]; subdomain == "" || l.Subdomain == subdomain {
+			out = append(out, l.Link)
+		}
+	}
+	return out
+}
+
+// RevokeLink voids link id and signs out whoever used it. It reports false
+// if there is no such link.
+func (p *Plugin) RevokeLink(id string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	l, ok := p.links[id]
+	if ok && !l.Revoked {
+		l.Revoked = true
+		log.Printf("[magiclink] revoked link %s for %s", l.ID, l.Email)
+	}
+	return ok
+}
+
+// sign returns the signature of l's URL.
+func (p *Plugin) sign(l statsapi.Link) string {
+	m := hmac.New(sha256.New, p.key)
+	m.Write([]byte(strings.Join([]string{l.ID, l.Subdomain, l.Email, l.Path, strconv.FormatInt(l.ExpiresAt, 10)}, "|")))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+// redeem checks the link token points to, on subdomain, and if use is set
+// uses it up.
+func (p *Plugin) redeem(subdomain, token string, use bool, now time.Time) (statsapi.Link, error) {
+	id, sig, _ := strings.Cut(token, ".")
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	l, ok := p.links[id]
+	switch {
+	case !ok || l.Subdomain != subdomain || !hmac.Equal([]byte(sig), []byte(l.sig)):
+		return statsapi.Link{}, errors.New("This link isn't valid")
+	case l.Revoked:
+		return statsapi.Link{}, errors.New("This link was revoked")
+	case l.UsedAt != 0:
+		return statsapi.Link{}, errors.New("This link was already used; ask for a new one")
+	case now.Unix() > l.ExpiresAt:
+		return statsapi.Link{}, errors.New("This link expired; ask for a new one")
+	}
+	if use {
+		l.UsedAt = now.Unix()
+	}
+	return l.Link, nil
+}
+
+// authenticator is the "link" scheme.
+type authenticator struct {
+	plugin *Plugin
+}
+
+func (a authenticator) Name() string { return "link" }
+
+// Verify returns the email a link was made for, while the link stands.
+func (a authenticator) Verify(_ *hooks.RequestContext, req types.TunnelRequest) (string, bool) {
+	id, ok := a.plugin.sessions.Verify(req, time.Now())
+	if !ok {
+		return "", false
+	}
+	a.plugin.mu.Lock()
+	defer a.plugin.mu.Unlock()
+	l, ok := a.plugin.links[id]
+	if !ok || l.Revoked {
+		return "", false
+	}
+	return l.Email, true
+}
+
+func (a authenticator) Challenge(_ *hooks.RequestContext, _ types.TunnelRequest) (types.TunnelResponse, bool) {
+	return hooks.TextResponse(http.StatusUnauthorized, "This preview is invite-only; open the link you were sent, or ask its owner for one"), true
+}
+
+type pathHandler struct {
+	plugin *Plugin
+}
+
+func (h *pathHandler) ServePath(ctx *hooks.RequestContext, req types.TunnelRequest) (types.TunnelResponse, bool) {
+	reqPath, _, _ := strings.Cut(req.Path, "?")
+	token, ok := strings.CutPrefix(reqPath, LinkPrefix)
+	if !ok {
+		return types.TunnelResponse{}, false
+	}
+	// Chat apps and mail scanners fetch links to preview them; only the
+	// button on the page, a POST, uses the link up
+	p := h.plugin
+	l, err := p.redeem(ctx.Subdomain, token, req.Method == http.MethodPost, time.Now())
+	if err != nil {
+		return hooks.TextResponse(http.StatusForbidden, err.Error()), true
+	}
+	if req.Method != http.MethodPost {
+		page := fmt.Sprintf(continuePage, html.EscapeString(l.Email))
+		return types.TunnelResponse{
+			Status: http.StatusOK,
+			Headers: map[string][]string{
+				"Content-Type":    {"text/html; charset=utf-8"},
+				"Cache-Control":   {"no-store"},
+				"Referrer-Policy": {"no-referrer"},
+			},
+			Body: base64.StdEncoding.EncodeToString([]byte(page)),
+		}, true
+	}
+	log.Printf("[magiclink] %s signed in on %s with link %s", l.Email, ctx.Subdomain, l.ID)
+	return types.TunnelResponse{
+		Status: http.StatusSeeOther,
+		Headers: map[string][]string{
+			"Location":        {l.Path},
+			"Set-Cookie":      {p.sessions.Issue(l.ID, time.Now())},
+			"Cache-Control":   {"no-store"},
+			"Referrer-Policy": {"no-referrer"},
+		},
+	}, true
+}
@@ -0,0 +1,163 @@
+package mock
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+
+	"gopkg.in/yaml.v3"
+)
+
+// rulesFile is the -mock-rules YAML file:
+//
+//	rules:
+//	  - method: GET
+//	    path: /api/users/*
+//	    status: 200
+//	    headers: {Content-Type: application/json}
+//	    body: '{"users": []}'
+//	  - path: /api/report
+//	    file: fixtures/report.pdf
+//	    delay: 300ms
+type rulesFile struct {
+	Rules []rule `yaml:"rules"`
+}
+
+// rule is one canned response. The first rule matching a request answers it.
+type rule struct {
+	Method  string            `yaml:"method"` // empty or * for any
+	Path    string            `yaml:"path"`   // path.Match glob; /dir/* also matches below dir
+	Status  int               `yaml:"status"` // default 200
+	Headers map[string]string `yaml:"headers"`
+	Body    string            `yaml:"body"`
+	File    string            `yaml:"file"` // body from a file, relative to the rules file
+	Delay   time.Duration     `yaml:"delay"`
+
+	body []byte
+}
+
+// Plugin answers requests matching -mock-rules with canned responses, so
+// parts of a stack that don't run locally can be stubbed out.
+type Plugin struct {
+	rulesPath string
+	rules     []rule
+}
+
+func New() *Plugin {
+	return &Plugin{}
+}
+
+func (p *Plugin) Name() string { return "mock" }
+func (p *Plugin) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&p.rulesPath, "mock-rules", "", "YAML file of method+path rules answered with canned responses instead of the local server")
+}
+func (p *Plugin) Enabled() bool                { return p.rulesPath != "" }
+func (p *Plugin) WorkerConfig() map[string]any { return nil }
+func (p *Plugin) RequestHooks() []hooks.RequestHook {
+	return []hooks.RequestHook{&reqHook{rules: p.rules}}
+}
+func (p *Plugin) ConnectionHooks() []hooks.ConnectionHook { return nil }
+
+// Load reads and checks the rules file. Call after flags are parsed, before
+// the pipeline is activated.
+func (p *Plugin) Load() error {
+	if !p.Enabled() {
+		return nil
+	}
+	data, err := os.ReadFile(p.rulesPath)
+	if err != nil {
+		return fmt.Errorf("-mock-rules: %w", err)
+	}
+	var f rulesFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("-mock-rules: failed to parse %s: %w", p.rulesPath, err)
+	}
+	if len(f.Rules) == 0 {
+		return fmt.Errorf("-mock-rules: %s has no rules", p.rulesPath)
+	}
+	for i := range f.Rules {
+		r := &f.Rules[i]
+		if !strings.HasPrefix(r.Path, "/") {
+			return fmt.Errorf("-mock-rules: rule %d: path must start with /", i+1)
+		}
+		if _, err := path.Match(r.Path, "/"); err != nil {
+			return fmt.Errorf("-mock-rules: rule %d: bad path pattern %q", i+1, r.Path)
+		}
+		r.Method = strings.ToUpper(r.Method)
+		if r.Status == 0 {
+			r.Status = http.StatusOK
+		}
+		if r.Status < 100 || r.Status > 599 {
+			return fmt.Errorf("-mock-rules: rule %d: invalid status %d", i+1, r.Status)
+		}
+		r.body = []byte(r.Body)
+		if r.File != "" {
+			if r.Body != "" {
+				return fmt.Errorf("-mock-rules: rule %d: set body or file, not both", i+1)
+			}
+			file := r.File
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(filepath.Dir(p.rulesPath), file)
+			}
+			if r.body, err = os.ReadFile(file); err != nil {
+				return fmt.Errorf("-mock-rules: rule %d: %w", i+1, err)
+			}
+		}
+	}
+	p.rules = f.Rules
+	return nil
+}
+
+// matches reports whether r answers a request for method and reqPath.
+func (r *rule) matches(method, reqPath string) bool {
+	if r.Method != "" && r.Method != "*" && r.Method != method {
+		return false
+	}
+	if ok, _ := path.Match(r.Path, reqPath); ok {
+		return true
+	}
+	dir, ok := strings.CutSuffix(r.Path, "*")
+	return ok && strings.HasSuffix(dir, "/") && strings.HasPrefix(reqPath, dir)
+}
+
+type reqHook struct {
+	hooks.NoOpRequestHook
+	rules []rule
+}
+
+func (h *reqHook) Respond(ctx *hooks.RequestContext, req types.TunnelRequest) (types.TunnelResponse, bool) {
+	reqPath, _, _ := strings.Cut(req.Path, "?")
+	for i := range h.rules {
+		r := &h.rules[i]
+		if !r.matches(req.Method, reqPath) {
+			continue
+		}
+		if r.Delay > 0 {
+			// Never outlast the worker's wait for the response
+			delay := min(r.Delay, time.Until(ctx.Deadline))
+			time.Sleep(delay)
+		}
+		headers := make(map[string][]string, len(r.Headers))
+		for k, v := range r.Headers {
+			headers[http.CanonicalHeaderKey(k)] = []string{v}
+		}
+		if _, ok := headers["Content-Type"]; !ok && len(r.body) > 0 {
+			headers["Content-Type"] = []string{http.DetectContentType(r.body)}
+		}
+		return types.TunnelResponse{
+			Status:  r.Status,
+			Headers: headers,
+			Body:    base64.StdEncoding.EncodeToString(r.body),
+		}, true
+	}
+	return types.TunnelResponse{}, false
+}
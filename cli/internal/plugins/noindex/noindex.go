@@ -0,0 +1,44 @@
+package noindex
+
+import (
+	"flag"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+type plugin struct {
+	enabled *bool
+}
+
+func New() hooks.Plugin {
+	return &plugin{}
+}
+
+func (p *plugin) Name() string { return "noindex" }
+
+func (p *plugin) RegisterFlags(fs *flag.FlagSet) {
+	p.enabled = fs.Bool("noindex", false, "Add X-Robots-Tag: noindex, nofollow to every response so search engines skip the tunnel")
+}
+
+func (p *plugin) Enabled() bool { return p.enabled != nil && *p.enabled }
+
+func (p *plugin) WorkerConfig() map[string]any { return nil }
+
+func (p *plugin) RequestHooks() []hooks.RequestHook { return []hooks.RequestHook{reqHook{}} }
+
+func (p *plugin) ConnectionHooks() []hooks.ConnectionHook { return nil }
+
+type reqHook struct {
+	hooks.NoOpRequestHook
+}
+
+func (reqHook) AfterProxy(_ *hooks.RequestContext, _ types.TunnelRequest, resp types.TunnelResponse) types.TunnelResponse {
+	headers := make(map[string][]string, len(resp.Headers)+1)
+	for k, v := range resp.Headers {
+		headers[k] = v
+	}
+	headers["X-Robots-Tag"] = []string{"noindex, nofollow"}
+	resp.Headers = headers
+	return resp
+}
@@ -0,0 +1,273 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/config"
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+// Reserved paths the plugin serves on the public tunnel.
+const (
+	CallbackPath = hooks.ReservedPrefix + "oauth/callback"
+	LogoutPath   = hooks.ReservedPrefix + "oauth/logout"
+)
+
+const (
+	// EmailHeader tells the local server who signed in. Visitors can't set it.
+	EmailHeader = "X-Forwarded-Email"
+	// pendingTTL bounds how long a visitor may take at the provider.
+	pendingTTL = 10 * time.Minute
+	// maxPending bounds sign-ins started but not finished.
+	maxPending = 10000
+)
+
+// Plugin protects tunnels with OpenID Connect sign-in: visitors without a
+// valid session are redirected to the provider, and only the allowed emails
+// get through. It is the "oauth" scheme of the access plugin, which turns
+// visitors away; sessions are hooks.Sessions cookies.
+type Plugin struct {
+	provider     string
+	clientID     string
+	clientSecret string
+	allowEmails  string
+	sessionTTL   time.Duration
+
+	oidc     *oidcProvider
+	allowed  []string // emails, or @domain suffixes
+	sessions *hooks.Sessions
+
+	mu      sync.Mutex
+	pending map[string]pendingLogin // keyed by state
+}
+
+// pendingLogin is a sign-in sent to the provider and not back yet.
+type pendingLogin struct {
+	verifier string // PKCE code verifier
+	returnTo string // path to go back to afterwards
+	expires  time.Time
+}
+
+func New() *Plugin {
+	return &Plugin{pending: make(map[string]pendingLogin)}
+}
+
+func (p *Plugin) Name() string { return "oauth" }
+func (p *Plugin) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&p.provider, "oauth-provider", "", "Require sign-in with an OpenID Connect provider: google, gitlab or an issuer URL")
+	fs.StringVar(&p.clientID, "oauth-client-id", "", "OAuth client ID for -oauth-provider (default $OAUTH_CLIENT_ID); its redirect URI is <tunnel URL>"+CallbackPath)
+	fs.StringVar(&p.clientSecret, "oauth-client-secret", "", "OAuth client secret for -oauth-provider (default $OAUTH_CLIENT_SECRET)")
+	fs.StringVar(&p.allowEmails, "oauth-allow-emails", "", "Comma-separated emails, or @domain for a whole domain, allowed in with -oauth-provider")
+	fs.DurationVar(&p.sessionTTL, "oauth-session", 24*time.Hour, "How long a sign-in lasts with -oauth-provider")
+}
+func (p *Plugin) Enabled() bool { return p.provider != "" }
+
+// WorkerConfig registers the callback route so the worker passes it through
+// uncached.
+func (p *Plugin) WorkerConfig() map[string]any {
+	return map[string]any{"oauth": map[string]any{"callback": CallbackPath}}
+}
+func (p *Plugin) RequestHooks() []hooks.RequestHook {
+	return []hooks.RequestHook{&reqHook{plugin: p}}
+}
+func (p *Plugin) ConnectionHooks() []hooks.ConnectionHook { return nil }
+func (p *Plugin) PathHandlers() []hooks.PathHandler {
+	return []hooks.PathHandler{&pathHandler{plugin: p}}
+}
+func (p *Plugin) Authenticator() hooks.Authenticator { return authenticator{p} }
+
+// Validate checks the flags and fetches the provider's configuration. Call
+// after flags are parsed, before registering.
+func (p *Plugin) Validate() error {
+	if !p.Enabled() {
+		return nil
+	}
+	issuer, ok := providers[strings.ToLower(p.provider)]
+	if !ok {
+		if u, err := url.Parse(p.provider); err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("-oauth-provider must be %s or an https issuer URL, got %q", providerNames(), p.provider)
+		}
+		issuer = p.provider
+	}
+	if p.clientID == "" {
+		p.clientID = os.Getenv("OAUTH_CLIENT_ID")
+	}
+	if p.clientSecret == "" {
+		p.clientSecret = os.Getenv("OAUTH_CLIENT_SECRET")
+	}
+	if p.clientID == "" || p.clientSecret == "" {
+		return fmt.Errorf("-oauth-provider needs -oauth-client-id and -oauth-client-secret (or OAUTH_CLIENT_ID and OAUTH_CLIENT_SECRET)")
+	}
+	for _, e := range strings.Split(p.allowEmails, ",") {
+		if e = strings.ToLower(strings.TrimSpace(e)); e != "" {
+			p.allowed = append(p.allowed, e)
+		}
+	}
+	if len(p.allowed) == 0 {
+		return fmt.Errorf("-oauth-provider needs -oauth-allow-emails, or anyone with an account could sign in")
+	}
+	if p.sessionTTL <= 0 {
+		return fmt.Errorf("-oauth-session must be positive")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	oidc, err := discover(ctx, issuer)
+	if err != nil {
+		return fmt.Errorf("-oauth-provider: %w", err)
+	}
+	p.oidc = oidc
+	p.sessions = hooks.NewSessions(p.Name(), p.sessionTTL)
+	return nil
+}
+
+func providerNames() string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// allows reports whether email may sign in.
+func (p *Plugin) allows(email string) bool {
+	for _, a := range p.allowed {
+		if a == email || (strings.HasPrefix(a, "@") && strings.HasSuffix(email, a)) {
+			return true
+		}
+	}
+	return false
+}
+
+// begin records a new sign-in and returns the provider URL to send the
+// visitor to.
+func (p *Plugin) begin(subdomain, returnTo string, now time.Time) string {
+	state, verifier := randomString(), randomString()
+	p.mu.Lock()
+	if len(p.pending) >= maxPending {
+		for s, pl := range p.pending {
+			if now.After(pl.expires) {
+				delete(p.pending, s)
+			}
+		}
+	}
+	if len(p.pending) < maxPending {
+		p.pending[state] = pendingLogin{verifier: verifier, returnTo: returnTo, expires: now.Add(pendingTTL)}
+	}
+	p.mu.Unlock()
+	return p.oidc.authCodeURL(p.clientID, config.PublicURL(subdomain)+CallbackPath, state, verifier)
+}
+
+// finish takes the sign-in for state, if it is still pending.
+func (p *Plugin) finish(state string, now time.Time) (pendingLogin, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pl, ok := p.pending[state]
+	delete(p.pending, state)
+	return pl, ok && now.Before(pl.expires)
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+type reqHook struct {
+	hooks.NoOpRequestHook
+	plugin *Plugin
+}
+
+// BeforeProxy passes the signed-in email to the local server.
+func (h *reqHook) BeforeProxy(_ *hooks.RequestContext, req types.TunnelRequest) types.TunnelRequest {
+	header := hooks.CanonicalHeader(req.Headers)
+	header.Del(EmailHeader)
+	if email, ok := h.plugin.sessions.Verify(req, time.Now()); ok {
+		header.Set(EmailHeader, email)
+	}
+	req.Headers = header
+	return req
+}
+
+// authenticator is the "oauth" scheme.
+type authenticator struct {
+	plugin *Plugin
+}
+
+func (a authenticator) Name() string { return a.plugin.Name() }
+
+func (a authenticator) Verify(_ *hooks.RequestContext, req types.TunnelRequest) (string, bool) {
+	return a.plugin.sessions.Verify(req, time.Now())
+}
+
+// Challenge sends visitors without a session to sign in: page loads are
+// redirected, other requests get a 401.
+func (a authenticator) Challenge(ctx *hooks.RequestContext, req types.TunnelRequest) (types.TunnelResponse, bool) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return hooks.TextResponse(http.StatusUnauthorized, "Sign-in required"), true
+	}
+	return types.TunnelResponse{
+		Status: http.StatusFound,
+		Headers: map[string][]string{
+			"Location":      {a.plugin.begin(ctx.Subdomain, req.Path, time.Now())},
+			"Cache-Control": {"no-store"},
+		},
+	}, true
+}
+
+type pathHandler struct {
+	plugin *Plugin
+}
+
+func (h *pathHandler) ServePath(ctx *hooks.RequestContext, req types.TunnelRequest) (types.TunnelResponse, bool) {
+	reqPath, rawQuery, _ := strings.Cut(req.Path, "?")
+	switch reqPath {
+	case LogoutPath:
+		resp := hooks.Redirect("/")
+		resp.Headers["Set-Cookie"] = []string{h.plugin.sessions.Clear()}
+		return resp, true
+	case CallbackPath:
+	default:
+		return types.TunnelResponse{}, false
+	}
+
+	p := h.plugin
+	q, _ := url.ParseQuery(rawQuery)
+	if e := q.Get("error"); e != "" {
+		return hooks.TextResponse(http.StatusForbidden, "Sign-in failed: "+e), true
+	}
+	pl, ok := p.finish(q.Get("state"), time.Now())
+	if !ok {
+		return hooks.TextResponse(http.StatusBadRequest, "Sign-in expired; reload the page to try again"), true
+	}
+	exchangeCtx, cancel := context.WithDeadline(context.Background(), ctx.Deadline)
+	defer cancel()
+	email, err := p.oidc.exchange(exchangeCtx, p.clientID, p.clientSecret, config.PublicURL(ctx.Subdomain)+CallbackPath, q.Get("code"), pl.verifier)
+	if err != nil {
+		log.Printf("[oauth] sign-in on %s failed: %v", ctx.Subdomain, err)
+		return hooks.TextResponse(http.StatusBadGateway, "Sign-in failed"), true
+	}
+	if !p.allows(email) {
+		log.Printf("[oauth] %s is not allowed on %s", email, ctx.Subdomain)
+		return hooks.TextResponse(http.StatusForbidden, email+" is not allowed to view this tunnel"), true
+	}
+	log.Printf("[oauth] %s signed in on %s", email, ctx.Subdomain)
+
+	resp := hooks.Redirect(pl.returnTo)
+	resp.Headers["Set-Cookie"] = []string{p.sessions.Issue(email, time.Now())}
+	return resp, true
+}
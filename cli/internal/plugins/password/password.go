@@ -0,0 +1,142 @@
+package password
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+// Reserved paths the plugin serves on the public tunnel.
+const (
+	LoginPath  = hooks.ReservedPrefix + "password/login"
+	LogoutPath = hooks.ReservedPrefix + "password/logout"
+)
+
+// failDelay is how long a wrong password holds up the next attempt, so
+// guessing runs at about one try per second however many are sent at once.
+const failDelay = time.Second
+
+// loginPage is the sign-in form. The verbs are an error message, the form
+// action and the escaped path to go back to.
+const loginPage = `<!doctype html><html><head><meta charset="utf-8">` +
+	`<meta name="viewport" content="width=device-width,initial-scale=1"><meta name="robots" content="noindex">` +
+	`<title>Password required</title></head>` +
+	`<body style="font:15px/1.5 system-ui,sans-serif;display:flex;justify-content:center;padding-top:15vh">` +
+	`<form method="post" action="%[2]s" style="display:flex;flex-direction:column;gap:10px;width:280px">` +
+	`<strong>This tunnel is password protected</strong>%[1]s` +
+	`<input type="hidden" name="return" value="%[3]s">` +
+	`<input type="password" name="password" required autofocus placeholder="Password" style="padding:6px 8px;font:inherit">` +
+	`<button type="submit" style="padding:6px 8px;font:inherit;cursor:pointer">Continue</button></form></body></html>`
+
+// Plugin protects tunnels with a shared password: visitors get a sign-in
+// page, and a right password starts a session. It is the "password" scheme
+// of the access plugin.
+type Plugin struct {
+	password   string
+	sessionTTL time.Duration
+
+	sessions *hooks.Sessions
+	guard    sync.Mutex // one attempt at a time
+}
+
+func New() *Plugin {
+	return &Plugin{}
+}
+
+func (p *Plugin) Name() string { return "password" }
+func (p *Plugin) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&p.password, "password", "", "Require a password, entered on a sign-in page (prefer $"+hooks.EnvName("password")+" to keep it out of the process list)")
+	fs.DurationVar(&p.sessionTTL, "password-session", 24*time.Hour, "How long a sign-in lasts with -password")
+}
+func (p *Plugin) Enabled() bool                           { return p.password != "" }
+func (p *Plugin) WorkerConfig() map[string]any            { return nil }
+func (p *Plugin) RequestHooks() []hooks.RequestHook       { return nil }
+func (p *Plugin) ConnectionHooks() []hooks.ConnectionHook { return nil }
+func (p *Plugin) PathHandlers() []hooks.PathHandler {
+	return []hooks.PathHandler{&pathHandler{plugin: p}}
+}
+func (p *Plugin) Authenticator() hooks.Authenticator { return authenticator{p} }
+
+// Validate checks the flags. Call after flags are parsed, before
+// registering.
+func (p *Plugin) Validate() error {
+	if !p.Enabled() {
+		return nil
+	}
+	if p.sessionTTL <= 0 {
+		return fmt.Errorf("-password-session must be positive")
+	}
+	p.sessions = hooks.NewSessions(p.Name(), p.sessionTTL)
+	return nil
+}
+
+// check reports whether password is right, holding up a wrong one.
+func (p *Plugin) check(password string) bool {
+	p.guard.Lock()
+	defer p.guard.Unlock()
+	if subtle.ConstantTimeCompare([]byte(password), []byte(p.password)) == 1 {
+		return true
+	}
+	time.Sleep(failDelay)
+	return false
+}
+
+// authenticator is the "password" scheme.
+type authenticator struct {
+	plugin *Plugin
+}
+
+func (a authenticator) Name() string { return a.plugin.Name() }
+
+func (a authenticator) Verify(_ *hooks.RequestContext, req types.TunnelRequest) (string, bool) {
+	return a.plugin.sessions.Verify(req, time.Now())
+}
+
+// Challenge shows page loads the sign-in form; other requests get a 401.
+func (a authenticator) Challenge(_ *hooks.RequestContext, req types.TunnelRequest) (types.TunnelResponse, bool) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return hooks.TextResponse(http.StatusUnauthorized, "Password required"), true
+	}
+	return hooks.FormResponse(http.StatusUnauthorized, loginPage, "", LoginPath, req.Path), true
+}
+
+type pathHandler struct {
+	plugin *Plugin
+}
+
+func (h *pathHandler) ServePath(ctx *hooks.RequestContext, req types.TunnelRequest) (types.TunnelResponse, bool) {
+	reqPath, _, _ := strings.Cut(req.Path, "?")
+	switch reqPath {
+	case LogoutPath:
+		resp := hooks.Redirect("/")
+		resp.Headers["Set-Cookie"] = []string{h.plugin.sessions.Clear()}
+		return resp, true
+	case LoginPath:
+	default:
+		return types.TunnelResponse{}, false
+	}
+
+	if req.Method != http.MethodPost {
+		return hooks.FormResponse(http.StatusOK, loginPage, "", LoginPath, "/"), true
+	}
+	body, _ := base64.StdEncoding.DecodeString(req.Body)
+	form, _ := url.ParseQuery(string(body))
+	returnTo := form.Get("return")
+	if !h.plugin.check(form.Get("password")) {
+		log.Printf("[password] wrong password on %s", ctx.Subdomain)
+		return hooks.FormResponse(http.StatusUnauthorized, loginPage, "Wrong password", LoginPath, returnTo), true
+	}
+	resp := hooks.Redirect(returnTo)
+	resp.Headers["Set-Cookie"] = []string{h.plugin.sessions.Issue("", time.Now())}
+	return resp, true
+}
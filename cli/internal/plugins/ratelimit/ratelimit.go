@@ -0,0 +1,175 @@
+package ratelimit
+
+import (
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+// maxVisitors bounds the bucket map; past it, full (idle) buckets are swept.
+const maxVisitors = 10000
+
+type plugin struct {
+	rate  rate
+	burst *int
+	hook  *reqHook // set by RequestHooks
+}
+
+func New() hooks.Plugin {
+	return &plugin{}
+}
+
+func (p *plugin) Name() string { return "ratelimit" }
+
+func (p *plugin) RegisterFlags(fs *flag.FlagSet) {
+	fs.Var(&p.rate, "rate-limit", "Requests allowed per visitor IP, e.g. 10/s, 300/m (answered locally with 429 when exceeded)")
+	p.burst = fs.Int("burst", 0, "Requests a visitor may send at once with -rate-limit (default: one second's worth, at least 1)")
+}
+
+func (p *plugin) Enabled() bool { return p.rate > 0 }
+
+func (p *plugin) WorkerConfig() map[string]any { return nil }
+
+func (p *plugin) RequestHooks() []hooks.RequestHook {
+	p.hook = &reqHook{rate: float64(p.rate), burst: p.burstSize(), buckets: make(map[string]*bucket)}
+	return []hooks.RequestHook{p.hook}
+}
+
+// Reload applies the current -rate-limit and -burst. Visitors keep their
+// buckets, capped at the new burst.
+func (p *plugin) Reload() error {
+	if p.hook == nil {
+		return errors.New("rate limiting was off at startup; restart to turn it on")
+	}
+	if p.rate <= 0 {
+		return errors.New("-rate-limit can't be turned off without a restart")
+	}
+	h := p.hook
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.rate, h.burst = float64(p.rate), p.burstSize()
+	for _, b := range h.buckets {
+		b.tokens = min(b.tokens, h.burst)
+	}
+	return nil
+}
+
+// burstSize is -burst, defaulting to one second's worth of requests.
+func (p *plugin) burstSize() float64 {
+	burst := float64(*p.burst)
+	if burst <= 0 {
+		burst = max(1, math.Ceil(float64(p.rate)))
+	}
+	return burst
+}
+
+func (p *plugin) ConnectionHooks() []hooks.ConnectionHook { return nil }
+
+// rate is a flag.Value for request rates like 10/s or 300/m, stored per second.
+type rate float64
+
+var rateUnits = map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}
+
+func (r *rate) String() string {
+	if *r == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(float64(*r), 'g', -1, 64) + "/s"
+}
+
+func (r *rate) Set(s string) error {
+	if strings.TrimSpace(s) == "" {
+		*r = 0
+		return nil
+	}
+	num, unit, ok := strings.Cut(strings.TrimSpace(s), "/")
+	per := time.Second
+	if ok {
+		if per, ok = rateUnits[strings.TrimSpace(unit)]; !ok {
+			return fmt.Errorf("invalid rate %q (use e.g. 10/s, 300/m)", s)
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid rate %q (use e.g. 10/s, 300/m)", s)
+	}
+	*r = rate(n / per.Seconds())
+	return nil
+}
+
+// bucket is one visitor's token bucket.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type reqHook struct {
+	hooks.NoOpRequestHook
+
+	mu      sync.Mutex
+	rate    float64            // tokens per second
+	burst   float64            // bucket capacity
+	buckets map[string]*bucket // keyed by visitor IP
+}
+
+func (h *reqHook) Respond(_ *hooks.RequestContext, req types.TunnelRequest) (types.TunnelResponse, bool) {
+	wait, ok := h.take(visitorIP(req), time.Now())
+	if ok {
+		return types.TunnelResponse{}, false
+	}
+	return types.TunnelResponse{
+		Status: http.StatusTooManyRequests,
+		Headers: map[string][]string{
+			"Content-Type": {"text/plain; charset=utf-8"},
+			"Retry-After":  {strconv.Itoa(int(math.Ceil(wait.Seconds())))},
+		},
+		Body: base64.StdEncoding.EncodeToString([]byte("Too Many Requests")),
+	}, true
+}
+
+// take spends a token from ip's bucket. If none is left it returns false and
+// how long until one is.
+func (h *reqHook) take(ip string, now time.Time) (time.Duration, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	b, ok := h.buckets[ip]
+	if !ok {
+		if len(h.buckets) >= maxVisitors {
+			h.sweep(now)
+		}
+		b = &bucket{tokens: h.burst, last: now}
+		h.buckets[ip] = b
+	}
+	b.tokens = min(h.burst, b.tokens+now.Sub(b.last).Seconds()*h.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	return time.Duration((1 - b.tokens) / h.rate * float64(time.Second)), false
+}
+
+// sweep drops buckets that have refilled, since a new bucket is identical.
+func (h *reqHook) sweep(now time.Time) {
+	for ip, b := range h.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*h.rate >= h.burst {
+			delete(h.buckets, ip)
+		}
+	}
+}
+
+// visitorIP returns the address the edge saw the visitor connect from.
+// X-Forwarded-For isn't used: visitors can put any address they like in it.
+func visitorIP(req types.TunnelRequest) string {
+	return hooks.CanonicalHeader(req.Headers).Get("Cf-Connecting-Ip")
+}
@@ -0,0 +1,33 @@
+package ratelimit
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+func TestSpoofedForwardedForStillLimited(t *testing.T) {
+	h := &reqHook{rate: 1, burst: 1, buckets: make(map[string]*bucket)}
+	ctx := hooks.NewRequestContext(hooks.NewTunnelContext("abc", 3000), time.Now())
+	send := func(from, xff string) int {
+		req := types.TunnelRequest{Headers: map[string][]string{
+			"Cf-Connecting-Ip": {from},
+			"X-Forwarded-For":  {xff},
+		}}
+		resp, _ := h.Respond(ctx, req)
+		return resp.Status
+	}
+
+	if status := send("1.2.3.4", "10.0.0.1"); status != 0 {
+		t.Fatalf("first request answered %d", status)
+	}
+	if status := send("1.2.3.4", "10.0.0.2"); status != http.StatusTooManyRequests {
+		t.Fatalf("request with a new X-Forwarded-For answered %d, want 429", status)
+	}
+	if status := send("5.6.7.8", "10.0.0.1"); status != 0 {
+		t.Fatalf("another visitor answered %d", status)
+	}
+}
@@ -0,0 +1,355 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"net/url"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxErrors caps the violations reported for one payload.
+const maxErrors = 10
+
+// jsonSchema is a compiled JSON Schema. The common validation keywords of
+// drafts 7 and 2020-12 are checked: type, enum, const, properties, required,
+// additionalProperties, items, prefixItems, min/maxItems, min/maxLength,
+// pattern, minimum, maximum, exclusiveMinimum/Maximum, allOf, anyOf, oneOf,
+// not and local $refs ("#/$defs/..."). Other keywords, such as format, are
+// ignored.
+type jsonSchema struct {
+	root     any
+	patterns map[string]*regexp.Regexp
+}
+
+// compileSchema parses a schema document, checking its patterns and refs.
+func compileSchema(data []byte) (*jsonSchema, error) {
+	var root any
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	s := &jsonSchema{root: root, patterns: make(map[string]*regexp.Regexp)}
+	if err := s.check(root); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// check walks a schema, compiling patterns and resolving refs up front.
+func (s *jsonSchema) check(node any) error {
+	switch n := node.(type) {
+	case map[string]any:
+		if p, ok := n["pattern"].(string); ok {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return fmt.Errorf("pattern %q: %w", p, err)
+			}
+			s.patterns[p] = re
+		}
+		if ref, ok := n["$ref"].(string); ok {
+			if _, err := s.resolve(ref); err != nil {
+				return err
+			}
+		}
+		for k, v := range n {
+			// const and enum values are data, not schemas
+			if k == "const" || k == "enum" {
+				continue
+			}
+			if err := s.check(v); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, v := range n {
+			if err := s.check(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolve follows a local $ref (a JSON pointer into the document).
+func (s *jsonSchema) resolve(ref string) (any, error) {
+	ptr, ok := strings.CutPrefix(ref, "#")
+	if !ok {
+		return nil, fmt.Errorf("$ref %q: only refs within the schema (#/...) are supported", ref)
+	}
+	node := s.root
+	if ptr == "" {
+		return node, nil
+	}
+	for _, tok := range strings.Split(strings.TrimPrefix(ptr, "/"), "/") {
+		tok, _ = url.PathUnescape(tok)
+		tok = strings.NewReplacer("~1", "/", "~0", "~").Replace(tok)
+		switch n := node.(type) {
+		case map[string]any:
+			if node, ok = n[tok]; !ok {
+				return nil, fmt.Errorf("$ref %q doesn't resolve", ref)
+			}
+		case []any:
+			i, err := strconv.Atoi(tok)
+			if err != nil || i < 0 || i >= len(n) {
+				return nil, fmt.Errorf("$ref %q doesn't resolve", ref)
+			}
+			node = n[i]
+		default:
+			return nil, fmt.Errorf("$ref %q doesn't resolve", ref)
+		}
+	}
+	return node, nil
+}
+
+// Validate checks a decoded JSON value and returns up to maxErrors
+// violations, each prefixed with the JSON pointer of the offending value.
+func (s *jsonSchema) Validate(inst any) []string {
+	var errs []string
+	s.validate(s.root, inst, "", &errs)
+	return errs
+}
+
+func (s *jsonSchema) validate(schema, inst any, ptr string, errs *[]string) {
+	if len(*errs) >= maxErrors {
+		return
+	}
+	fail := func(format string, args ...any) {
+		if len(*errs) < maxErrors {
+			at := ptr
+			if at == "" {
+				at = "/"
+			}
+			*errs = append(*errs, at+": "+fmt.Sprintf(format, args...))
+		}
+	}
+
+	if b, ok := schema.(bool); ok {
+		if !b {
+			fail("not allowed")
+		}
+		return
+	}
+	sc, ok := schema.(map[string]any)
+	if !ok {
+		return
+	}
+
+	if ref, ok := sc["$ref"].(string); ok {
+		if target, err := s.resolve(ref); err == nil {
+			s.validate(target, inst, ptr, errs)
+		}
+	}
+
+	if t, ok := sc["type"]; ok && !matchesType(t, inst) {
+		fail("expected %s, got %s", typeList(t), typeOf(inst))
+		return
+	}
+	if enum, ok := sc["enum"].([]any); ok {
+		found := false
+		for _, v := range enum {
+			if reflect.DeepEqual(v, inst) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("%s is not one of the allowed values", short(inst))
+		}
+	}
+	if c, ok := sc["const"]; ok && !reflect.DeepEqual(c, inst) {
+		fail("expected %s, got %s", short(c), short(inst))
+	}
+
+	switch v := inst.(type) {
+	case map[string]any:
+		props, _ := sc["properties"].(map[string]any)
+		if req, ok := sc["required"].([]any); ok {
+			for _, r := range req {
+				if name, ok := r.(string); ok {
+					if _, present := v[name]; !present {
+						fail("missing required property %q", name)
+					}
+				}
+			}
+		}
+		for _, name := range slices.Sorted(maps.Keys(v)) {
+			val := v[name]
+			child := ptr + "/" + escapePointer(name)
+			if ps, ok := props[name]; ok {
+				s.validate(ps, val, child, errs)
+				continue
+			}
+			switch ap := sc["additionalProperties"].(type) {
+			case bool:
+				if !ap {
+					fail("unexpected property %q", name)
+				}
+			case map[string]any:
+				s.validate(ap, val, child, errs)
+			}
+		}
+	case []any:
+		if n, ok := number(sc["minItems"]); ok && float64(len(v)) < n {
+			fail("expected at least %v items, got %d", n, len(v))
+		}
+		if n, ok := number(sc["maxItems"]); ok && float64(len(v)) > n {
+			fail("expected at most %v items, got %d", n, len(v))
+		}
+		prefix, _ := sc["prefixItems"].([]any)
+		for i, item := range v {
+			child := ptr + "/" + strconv.Itoa(i)
+			switch {
+			case i < len(prefix):
+				s.validate(prefix[i], item, child, errs)
+			case sc["items"] != nil:
+				// Draft 7 tuple form: items as an array of schemas
+				if tuple, ok := sc["items"].([]any); ok {
+					if i < len(tuple) {
+						s.validate(tuple[i], item, child, errs)
+					}
+				} else {
+					s.validate(sc["items"], item, child, errs)
+				}
+			}
+		}
+	case string:
+		n := float64(utf8.RuneCountInString(v))
+		if min, ok := number(sc["minLength"]); ok && n < min {
+			fail("expected at least %v characters, got %v", min, n)
+		}
+		if max, ok := number(sc["maxLength"]); ok && n > max {
+			fail("expected at most %v characters, got %v", max, n)
+		}
+		if p, ok := sc["pattern"].(string); ok && s.patterns[p] != nil && !s.patterns[p].MatchString(v) {
+			fail("%s doesn't match %q", short(v), p)
+		}
+	case float64:
+		if min, ok := number(sc["minimum"]); ok && v < min {
+			fail("%v is below the minimum %v", v, min)
+		}
+		if max, ok := number(sc["maximum"]); ok && v > max {
+			fail("%v is above the maximum %v", v, max)
+		}
+		if min, ok := number(sc["exclusiveMinimum"]); ok && v <= min {
+			fail("%v is not above %v", v, min)
+		}
+		if max, ok := number(sc["exclusiveMaximum"]); ok && v >= max {
+			fail("%v is not below %v", v, max)
+		}
+	}
+
+	if all, ok := sc["allOf"].([]any); ok {
+		for _, sub := range all {
+			s.validate(sub, inst, ptr, errs)
+		}
+	}
+	if anyOf, ok := sc["anyOf"].([]any); ok {
+		if s.countMatches(anyOf, inst, ptr) == 0 {
+			fail("matches none of anyOf")
+		}
+	}
+	if oneOf, ok := sc["oneOf"].([]any); ok {
+		if n := s.countMatches(oneOf, inst, ptr); n != 1 {
+			fail("matches %d of oneOf, expected exactly 1", n)
+		}
+	}
+	if not, ok := sc["not"]; ok {
+		if s.countMatches([]any{not}, inst, ptr) == 1 {
+			fail("matches a schema it must not")
+		}
+	}
+}
+
+// countMatches returns how many of schemas inst is valid against.
+func (s *jsonSchema) countMatches(schemas []any, inst any, ptr string) int {
+	n := 0
+	for _, sub := range schemas {
+		var errs []string
+		s.validate(sub, inst, ptr, &errs)
+		if len(errs) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// matchesType reports whether inst has the type (or one of the types) t.
+func matchesType(t, inst any) bool {
+	switch tt := t.(type) {
+	case string:
+		return isType(tt, inst)
+	case []any:
+		for _, one := range tt {
+			if name, ok := one.(string); ok && isType(name, inst) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func isType(name string, inst any) bool {
+	switch name {
+	case "integer":
+		f, ok := inst.(float64)
+		return ok && f == math.Trunc(f)
+	case "number":
+		_, ok := inst.(float64)
+		return ok
+	}
+	return typeOf(inst) == name
+}
+
+func typeOf(inst any) string {
+	switch inst.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return "unknown"
+}
+
+func typeList(t any) string {
+	if list, ok := t.([]any); ok {
+		names := make([]string, 0, len(list))
+		for _, v := range list {
+			names = append(names, fmt.Sprint(v))
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(t)
+}
+
+func number(v any) (float64, bool) {
+	f, ok := v.(float64)
+	return f, ok
+}
+
+func escapePointer(s string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(s)
+}
+
+// short renders a value for a message, truncated.
+func short(v any) string {
+	data, _ := json.Marshal(v)
+	if len(data) > 40 {
+		return string(data[:37]) + "..."
+	}
+	return string(data)
+}
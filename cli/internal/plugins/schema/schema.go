@@ -0,0 +1,158 @@
+package schema
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+// binding applies one schema file to request paths matching a glob.
+type binding struct {
+	pattern string // path.Match glob; /dir/* also matches below dir
+	file    string
+	schema  *jsonSchema
+}
+
+// Plugin validates JSON request bodies on configured paths against JSON
+// Schemas. Violations are logged and published as hooks.SchemaViolation
+// events (counted by stats); with -schema-reject they are answered with 422
+// before reaching the local server.
+type Plugin struct {
+	spec   string
+	reject bool
+
+	bindings []binding
+	bus      *hooks.Bus
+}
+
+func New() *Plugin {
+	return &Plugin{}
+}
+
+func (p *Plugin) Name() string { return "schema" }
+func (p *Plugin) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&p.spec, "schema", "", "Validate JSON request bodies against JSON Schemas, as path=file pairs (e.g. /webhooks/stripe=stripe.json,/api/*=api.json)")
+	fs.BoolVar(&p.reject, "schema-reject", false, "Answer requests failing -schema validation with 422 instead of only recording them")
+}
+func (p *Plugin) Enabled() bool                { return p.spec != "" }
+func (p *Plugin) WorkerConfig() map[string]any { return nil }
+func (p *Plugin) RequestHooks() []hooks.RequestHook {
+	return []hooks.RequestHook{&reqHook{p: p}}
+}
+func (p *Plugin) ConnectionHooks() []hooks.ConnectionHook { return nil }
+
+// SubscribeEvents keeps the bus violations are published on.
+func (p *Plugin) SubscribeEvents(bus *hooks.Bus) { p.bus = bus }
+
+// Load parses -schema and compiles the schema files. Call after flags are
+// parsed, before the pipeline is activated.
+func (p *Plugin) Load() error {
+	if p.reject && p.spec == "" {
+		return fmt.Errorf("-schema-reject needs -schema")
+	}
+	if !p.Enabled() {
+		return nil
+	}
+	schemas := make(map[string]*jsonSchema)
+	for _, part := range strings.Split(p.spec, ",") {
+		pattern, file, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || file == "" {
+			return fmt.Errorf("-schema: %q is not path=file", part)
+		}
+		if !strings.HasPrefix(pattern, "/") {
+			return fmt.Errorf("-schema: path %q must start with /", pattern)
+		}
+		if _, err := path.Match(pattern, "/"); err != nil {
+			return fmt.Errorf("-schema: bad path pattern %q", pattern)
+		}
+		s, ok := schemas[file]
+		if !ok {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("-schema: %w", err)
+			}
+			if s, err = compileSchema(data); err != nil {
+				return fmt.Errorf("-schema: %s: %w", file, err)
+			}
+			schemas[file] = s
+		}
+		p.bindings = append(p.bindings, binding{pattern: pattern, file: file, schema: s})
+	}
+	return nil
+}
+
+// match returns the first binding for reqPath, or nil.
+func (p *Plugin) match(reqPath string) *binding {
+	for i := range p.bindings {
+		b := &p.bindings[i]
+		if ok, _ := path.Match(b.pattern, reqPath); ok {
+			return b
+		}
+		if dir, ok := strings.CutSuffix(b.pattern, "*"); ok && strings.HasSuffix(dir, "/") && strings.HasPrefix(reqPath, dir) {
+			return b
+		}
+	}
+	return nil
+}
+
+type reqHook struct {
+	hooks.NoOpRequestHook
+	p *Plugin
+}
+
+// Respond validates the body and, with -schema-reject, answers violations
+// itself. Bodyless requests (GET, DELETE, ...) are not checked.
+func (h *reqHook) Respond(ctx *hooks.RequestContext, req types.TunnelRequest) (types.TunnelResponse, bool) {
+	reqPath, _, _ := strings.Cut(req.Path, "?")
+	b := h.p.match(reqPath)
+	if b == nil || req.Body == "" {
+		return types.TunnelResponse{}, false
+	}
+	body, err := base64.StdEncoding.DecodeString(req.Body)
+	if err != nil || len(body) == 0 {
+		return types.TunnelResponse{}, false
+	}
+
+	var errs []string
+	var inst any
+	if err := json.Unmarshal(body, &inst); err != nil {
+		errs = []string{"body is not valid JSON: " + err.Error()}
+	} else {
+		errs = b.schema.Validate(inst)
+	}
+	if len(errs) == 0 {
+		return types.TunnelResponse{}, false
+	}
+
+	log.Printf("[%s] %s %s violates %s: %s", ctx.Subdomain, req.Method, reqPath, b.file, strings.Join(errs, "; "))
+	if h.p.bus != nil {
+		h.p.bus.Publish(hooks.SchemaViolation{
+			Subdomain: ctx.Subdomain,
+			Method:    req.Method,
+			Path:      reqPath,
+			Schema:    b.file,
+			Errors:    errs,
+		})
+	}
+	if !h.p.reject {
+		return types.TunnelResponse{}, false
+	}
+	data, _ := json.Marshal(map[string]any{
+		"error":      "Request body doesn't match the schema",
+		"violations": errs,
+	})
+	return types.TunnelResponse{
+		Status:  http.StatusUnprocessableEntity,
+		Headers: map[string][]string{"Content-Type": {"application/json"}},
+		Body:    base64.StdEncoding.EncodeToString(data),
+	}, true
+}
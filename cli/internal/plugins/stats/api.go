@@ -0,0 +1,259 @@
+package stats
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/audit"
+	"github.com/QuadTriangle/prod.bd/cli/internal/failpoint"
+	"github.com/QuadTriangle/prod.bd/cli/internal/notes"
+	"github.com/QuadTriangle/prod.bd/cli/statsapi"
+)
+
+// apiRoute is one endpoint of the local API. Routes are served under
+// statsapi.Prefix, and under /api for clients from before versioning;
+// the OpenAPI document is generated from the same table.
+type apiRoute struct {
+	method  string
+	path    string // after the prefix, e.g. /stats/requests/{id}/replay
+	summary string
+	query   []apiParam
+	body    any  // example of the JSON request body, nil for none
+	result  any  // example of the JSON response, or a media type string
+	observe bool // also served read-only to -observer-token holders
+	handle  func(*Server, http.ResponseWriter, *http.Request)
+}
+
+type apiParam struct {
+	name, typ, description string
+}
+
+// searchParams are the filters of requests, scenario and export.
+var searchParams = []apiParam{
+	{"subdomain", "string", "Only this tunnel"},
+	{"method", "string", "Comma-separated methods"},
+	{"status", "string", "Comma-separated statuses, e.g. 404 or 5xx"},
+	{"path", "string", "Path prefix"},
+	{"path_regex", "string", "Path regular expression"},
+	{"min_latency", "number", "Minimum latency in milliseconds"},
+	{"since", "integer", "Unix seconds"},
+	{"until", "integer", "Unix seconds"},
+	{"cursor", "integer", "next_cursor of the previous page"},
+	{"limit", "integer", "Page size"},
+}
+
+var subdomainParam = []apiParam{{"subdomain", "string", "Only this tunnel"}}
+
+var apiRoutes = []apiRoute{
+	{method: "GET", path: "/token", summary: "Token the dashboard port wants in X-Prod-Token on requests that change anything (POST, DELETE)",
+		result: "text/plain", handle: (*Server).handleToken},
+	{method: "GET", path: "/stats/tunnels", summary: "Connected tunnels and their traffic",
+		result: map[string]any{"tunnels": []statsapi.Tunnel{}}, observe: true, handle: (*Server).handleTunnels},
+	{method: "GET", path: "/stats/summary", summary: "Totals across tunnels",
+		result: map[string]any{"summary": statsapi.Summary{}}, observe: true, handle: (*Server).handleSummary},
+	{method: "GET", path: "/stats/paths", summary: "Latency and errors per endpoint, slowest p99 first",
+		query:  []apiParam{subdomainParam[0], {"sort", "string", "p99 (default), p90, p50, avg, max, requests or errors"}, {"limit", "integer", "Default 100"}},
+		result: map[string]any{"paths": []statsapi.PathStats{}}, observe: true, handle: (*Server).handlePaths},
+	{method: "GET", path: "/stats/baseline", summary: "Responses that differed from their -baseline recording, newest first",
+		query: subdomainParam, result: map[string]any{"divergences": []statsapi.BaselineDivergence{}}, observe: true, handle: (*Server).handleBaseline},
+	{method: "GET", path: "/stats/requests", summary: "Search the request log, newest first",
+		query: searchParams, result: statsapi.RequestPage{}, observe: true, handle: (*Server).handleRequests},
+	{method: "POST", path: "/stats/requests/{id}/replay", summary: "Re-send a logged request to its local port",
+		result: statsapi.ReplayResult{}, handle: (*Server).handleReplay},
+	{method: "POST", path: "/stats/requests/{id}/diff", summary: "Replay a logged request and diff the response against the original",
+		query:  []apiParam{{"ignore", "string", "Comma-separated headers and JSON paths ($.a.b) to leave out; default Date"}},
+		result: statsapi.ReplayDiff{}, handle: (*Server).handleDiff},
+	{method: "GET", path: "/stats/requests/{id}/curl", summary: "A logged request as a curl command",
+		query:  []apiParam{{"target", "string", "public (default) or local"}},
+		result: "text/plain", observe: true, handle: (*Server).handleCurl},
+	{method: "GET", path: "/stats/history", summary: "The persistent request log across sessions (-log-db)",
+		query:  []apiParam{subdomainParam[0], {"since", "integer", "Unix seconds"}, {"until", "integer", "Unix seconds"}, {"limit", "integer", "Default 100, max 1000"}},
+		result: map[string]any{"requests": []statsapi.Request{}}, observe: true, handle: (*Server).handleHistory},
+	{method: "GET", path: "/stats/stream", summary: "Server-sent events: request and tunnel",
+		query: subdomainParam, result: "text/event-stream", handle: (*Server).handleStream},
+	{method: "GET", path: "/stats/scenario", summary: "Logged requests as a prod replay scenario",
+		query: searchParams, result: "application/yaml", handle: (*Server).handleScenario},
+	{method: "GET", path: "/stats/export", summary: "Logged requests as a HAR 1.2 file",
+		query: append([]apiParam{{"format", "string", "har"}}, searchParams...), result: "application/json", observe: true, handle: (*Server).handleExport},
+	{method: "GET", path: "/stats/audits", summary: "Saved prod audit reports",
+		query: subdomainParam, result: map[string]any{"audits": []audit.Report{}}, handle: (*Server).handleAudits},
+	{method: "GET", path: "/stats/notes", summary: "Session notes",
+		query: subdomainParam, result: map[string]any{"notes": []notes.Note{}}, handle: (*Server).handleNotes},
+	{method: "POST", path: "/stats/notes", summary: "Add a session note",
+		body: notes.Note{}, result: map[string]any{"note": notes.Note{}}, handle: (*Server).handleNotes},
+	{method: "DELETE", path: "/stats/notes", summary: "Remove a session note",
+		query: []apiParam{{"id", "integer", "Note ID"}}, handle: (*Server).handleNotes},
+	{method: "GET", path: "/stats/links", summary: "Magic links (-magic-links), newest first; needs X-Prod-Token on the dashboard port",
+		query: subdomainParam, result: map[string]any{"links": []statsapi.Link{}}, handle: (*Server).handleLinks},
+	{method: "POST", path: "/stats/links", summary: "Make a single-use magic link; its URL is only returned here",
+		query:  []apiParam{{"email", "string", "Who the link is for"}, {"subdomain", "string", "Tunnel; optional with one connected"}, {"path", "string", "Where the link lands, default /"}, {"ttl", "string", "How long it can be opened, e.g. 2h; default -magic-link-ttl"}},
+		result: map[string]any{"link": statsapi.Link{}}, handle: (*Server).handleLinks},
+	{method: "DELETE", path: "/stats/links", summary: "Revoke a magic link and sign out whoever used it",
+		query: []apiParam{{"id", "string", "Link ID"}}, handle: (*Server).handleLinks},
+	{method: "GET", path: "/stats/plugins", summary: "Time each plugin's hooks add per request",
+		result: map[string]any{"plugins": []statsapi.PluginOverhead{}}, observe: true, handle: (*Server).handlePlugins},
+	{method: "GET", path: "/stats/runtime", summary: "Health of the prod process",
+		result: statsapi.Runtime{}, observe: true, handle: (*Server).handleRuntime},
+	{method: "GET", path: "/stats/feedback", summary: "Comments from the banner's feedback widget, newest first",
+		query: subdomainParam, result: map[string]any{"feedback": []statsapi.Feedback{}}, handle: (*Server).handleFeedback},
+}
+
+// failpointRoutes are only served in builds with -tags failpoints.
+var failpointRoutes = []apiRoute{
+	{method: "GET", path: "/failpoints", summary: "Armed failpoints",
+		result: map[string]any{"available": []string{}, "armed": map[string]int{}}, handle: (*Server).handleFailpoints},
+	{method: "POST", path: "/failpoints", summary: "Arm a failpoint (value 0 disarms)",
+		body: map[string]any{"name": "", "value": 0}, result: map[string]any{"armed": map[string]int{}}, handle: (*Server).handleFailpoints},
+}
+
+// routes returns the routes this build serves.
+func routes() []apiRoute {
+	if failpoint.Enabled {
+		return slices.Concat(apiRoutes, failpointRoutes)
+	}
+	return apiRoutes
+}
+
+// registerAPI serves the routes on mux, versioned and unversioned, plus
+// the OpenAPI document.
+func (s *Server) registerAPI(mux *http.ServeMux) {
+	for _, rt := range routes() {
+		h := func(w http.ResponseWriter, r *http.Request) { rt.handle(s, w, r) }
+		mux.HandleFunc(rt.method+" "+statsapi.Prefix+rt.path, h)
+		mux.HandleFunc(rt.method+" /api"+rt.path, h)
+	}
+	mux.HandleFunc("GET "+statsapi.Prefix+"/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(OpenAPI())
+	})
+	// Rather than the dashboard page
+	mux.HandleFunc("/api/", http.NotFound)
+}
+
+// OpenAPI returns the OpenAPI 3.1 description of the local API.
+func OpenAPI() []byte {
+	paths := map[string]map[string]any{}
+	for _, rt := range routes() {
+		op := map[string]any{"summary": rt.summary}
+		var params []any
+		for _, name := range pathParams(rt.path) {
+			params = append(params, map[string]any{"name": name, "in": "path", "required": true, "schema": map[string]any{"type": "integer"}})
+		}
+		for _, p := range rt.query {
+			params = append(params, map[string]any{"name": p.name, "in": "query", "description": p.description, "schema": map[string]any{"type": p.typ}})
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+		if rt.body != nil {
+			op["requestBody"] = map[string]any{"required": true, "content": map[string]any{
+				"application/json": map[string]any{"schema": schemaOf(rt.body)},
+			}}
+		}
+		ok := map[string]any{"description": "OK"}
+		switch v := rt.result.(type) {
+		case nil:
+			ok["description"] = "No content"
+		case string:
+			ok["content"] = map[string]any{v: map[string]any{}}
+		default:
+			ok["content"] = map[string]any{"application/json": map[string]any{"schema": schemaOf(v)}}
+		}
+		status := "200"
+		if rt.result == nil {
+			status = "204"
+		}
+		op["responses"] = map[string]any{status: ok}
+		if paths[rt.path] == nil {
+			paths[rt.path] = map[string]any{}
+		}
+		paths[rt.path][strings.ToLower(rt.method)] = op
+	}
+	doc := map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":       "prod local API",
+			"version":     statsapi.Version,
+			"description": "Stats and control API a running prod serves on its dashboard port (127.0.0.1 only).",
+		},
+		"servers": []any{map[string]any{"url": "http://127.0.0.1:9999" + statsapi.Prefix}},
+		"paths":   paths,
+	}
+	data, _ := json.MarshalIndent(doc, "", "  ")
+	return append(data, '\n')
+}
+
+// pathParams lists the {name} segments of a route path.
+func pathParams(path string) []string {
+	var out []string
+	for _, seg := range strings.Split(path, "/") {
+		if name, ok := strings.CutPrefix(seg, "{"); ok {
+			out = append(out, strings.TrimSuffix(name, "}"))
+		}
+	}
+	return out
+}
+
+// schemaOf derives a JSON Schema from an example value: structs by their
+// json tags (fields without omitempty are required), map[string]any by its
+// entries.
+func schemaOf(v any) map[string]any {
+	if m, ok := v.(map[string]any); ok {
+		props := map[string]any{}
+		var required []string
+		for k, val := range m {
+			props[k] = schemaOf(val)
+			required = append(required, k)
+		}
+		slices.Sort(required)
+		return map[string]any{"type": "object", "properties": props, "required": required}
+	}
+	return schemaOfType(reflect.TypeOf(v))
+}
+
+func schemaOfType(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaOfType(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaOfType(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOfType(t.Elem())}
+	case reflect.Struct:
+		props := map[string]any{}
+		var required []string
+		for i := range t.NumField() {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			name, opts, _ := strings.Cut(tag, ",")
+			if !f.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = schemaOfType(f.Type)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		s := map[string]any{"type": "object", "properties": props}
+		if required != nil {
+			s["required"] = required
+		}
+		return s
+	}
+	return map[string]any{}
+}
@@ -0,0 +1,246 @@
+package stats
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/QuadTriangle/prod.bd/cli/statsapi"
+)
+
+// maxDivergences caps the baseline divergences kept for the API.
+const maxDivergences = 200
+
+// baselineRecord is one line of a baseline file (-baseline-record): a
+// request and the response it got, with bodies as the request log keeps
+// them.
+type baselineRecord struct {
+	Method          string              `json:"method"`
+	Path            string              `json:"path"`
+	RequestBody     string              `json:"request_body,omitempty"`
+	Status          int                 `json:"status"`
+	ResponseHeaders map[string][]string `json:"response_headers,omitempty"`
+	ResponseBody    string              `json:"response_body,omitempty"`
+	BytesOut        int                 `json:"bytes_out"`
+}
+
+func (r baselineRecord) entry() RequestEntry {
+	return RequestEntry{
+		Method:          r.Method,
+		Path:            r.Path,
+		RequestBody:     r.RequestBody,
+		Status:          r.Status,
+		ResponseHeaders: r.ResponseHeaders,
+		ResponseBody:    r.ResponseBody,
+		BytesOut:        r.BytesOut,
+	}
+}
+
+// BaselineRecorder appends every request to a baseline file.
+type BaselineRecorder struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// CreateBaseline starts a new baseline file at path, replacing any.
+func CreateBaseline(path string) (*BaselineRecorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &BaselineRecorder{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Record appends e, which should have its bodies.
+func (r *BaselineRecorder) Record(e RequestEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.enc.Encode(baselineRecord{
+		Method:          e.Method,
+		Path:            e.Path,
+		RequestBody:     e.RequestBody,
+		Status:          e.Status,
+		ResponseHeaders: e.ResponseHeaders,
+		ResponseBody:    e.ResponseBody,
+		BytesOut:        e.BytesOut,
+	})
+}
+
+func (r *BaselineRecorder) Close() error { return r.f.Close() }
+
+// Baseline compares live responses with those recorded in a baseline
+// file. A live request matches a recorded one with the same method, path
+// and body, else the same method and path; the nth live match of a
+// request is compared with its nth recording, and the last recording
+// after that.
+type Baseline struct {
+	ignore diffIgnore
+
+	mu          sync.Mutex
+	recorded    map[string][]RequestEntry // by baselineKey and by method+path
+	seen        map[string]int
+	divergences []statsapi.BaselineDivergence // oldest first
+}
+
+// LoadBaseline reads a baseline file made with -baseline-record. ignore
+// lists response headers and JSON field paths left out of comparisons.
+func LoadBaseline(path string, ignore []string) (*Baseline, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b := &Baseline{
+		ignore:   parseDiffIgnore(ignore),
+		recorded: map[string][]RequestEntry{},
+		seen:     map[string]int{},
+	}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 4*maxStoredBody)
+	for line := 1; sc.Scan(); line++ {
+		if strings.TrimSpace(sc.Text()) == "" {
+			continue
+		}
+		var r baselineRecord
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		e := r.entry()
+		exact, loose := baselineKeys(e)
+		b.recorded[exact] = append(b.recorded[exact], e)
+		b.recorded[loose] = append(b.recorded[loose], e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return b, nil
+}
+
+// baselineKeys returns the keys e is matched by: method, path and body,
+// and method and path alone.
+func baselineKeys(e RequestEntry) (exact, loose string) {
+	sum := sha256.Sum256([]byte(e.RequestBody))
+	loose = e.Method + " " + e.Path
+	return loose + " " + hex.EncodeToString(sum[:8]), loose
+}
+
+// Compare checks live, which should have its bodies, against its
+// recording. It returns false if nothing was recorded for it; the diff's
+// Original side is the recording.
+func (b *Baseline) Compare(live RequestEntry) (statsapi.ReplayDiff, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	exact, loose := baselineKeys(live)
+	key := exact
+	if len(b.recorded[key]) == 0 {
+		key = loose
+	}
+	recs := b.recorded[key]
+	if len(recs) == 0 {
+		return statsapi.ReplayDiff{}, false
+	}
+	n := b.seen[key]
+	b.seen[key]++
+	d := diffEntries(recs[min(n, len(recs)-1)], live, b.ignore)
+	d.ID = live.ID
+	if !d.Identical {
+		b.divergences = append(b.divergences, statsapi.BaselineDivergence{
+			RequestID: live.ID,
+			Subdomain: live.Subdomain,
+			Method:    live.Method,
+			Path:      live.Path,
+			Timestamp: live.Timestamp.Unix(),
+			Diff:      d,
+		})
+		if len(b.divergences) > maxDivergences {
+			b.divergences = b.divergences[1:]
+		}
+	}
+	return d, true
+}
+
+// Divergences returns the latest divergences on subdomain, or all, newest
+// first.
+func (b *Baseline) Divergences(subdomain string) []statsapi.BaselineDivergence {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := []statsapi.BaselineDivergence{}
+	for i := len(b.divergences) - 1; i >= 0; i-- {
+		if d := b.divergences[i]; subdomain == "" || d.Subdomain == subdomain {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// SetBaseline compares requests recorded from now on with b.
+func (s *Store) SetBaseline(b *Baseline) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.baseline = b
+}
+
+// Baseline returns the baseline set with -baseline, or nil.
+func (s *Store) Baseline() *Baseline {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.baseline
+}
+
+// SetBaselineRecorder records requests from now on with r.
+func (s *Store) SetBaselineRecorder(r *BaselineRecorder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recorder = r
+}
+
+// checkBaseline records full, the entry of a live request with its bodies,
+// and compares it with the baseline, counting the outcome on its tunnel.
+func (s *Store) checkBaseline(full RequestEntry) {
+	s.mu.RLock()
+	b, r := s.baseline, s.recorder
+	s.mu.RUnlock()
+	if r != nil {
+		r.Record(full)
+	}
+	if b == nil {
+		return
+	}
+	d, ok := b.Compare(full)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ts, found := s.tunnels[full.Subdomain]; found {
+		ts.BaselineMatched++
+		if !d.Identical {
+			ts.BaselineDiverged++
+		}
+	}
+	if !d.Identical {
+		log.Printf("[stats] %s %s diverged from the baseline: %s", full.Method, full.Path, baselineSummary(d))
+	}
+}
+
+// baselineSummary says what diverged, e.g. "status 200 → 500, body".
+func baselineSummary(d statsapi.ReplayDiff) string {
+	var parts []string
+	if d.Status.Changed {
+		parts = append(parts, fmt.Sprintf("status %d → %d", d.Status.Original, d.Status.Replay))
+	}
+	if len(d.Headers) > 0 {
+		parts = append(parts, fmt.Sprintf("%d headers", len(d.Headers)))
+	}
+	if d.Body.Changed {
+		parts = append(parts, "body")
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,69 @@
+package stats
+
+import (
+	"encoding/base64"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// curlSkippedHeaders aren't copied into curl commands: curl sets them
+// itself, or the tunnel added them on the way in.
+var curlSkippedHeaders = []string{
+	"Host", "Content-Length", "Connection", "Accept-Encoding",
+	"X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host", "X-Real-Ip", "Cdn-Loop",
+}
+
+// curlCommand renders a logged request as a curl command against baseURL
+// (the public URL or http://localhost:<port>). Binary bodies are piped in
+// from base64. Redacted values stay redacted.
+func curlCommand(e RequestEntry, baseURL string) string {
+	var b strings.Builder
+	args := []string{"curl"}
+	switch e.Method {
+	case http.MethodGet, "":
+	case http.MethodHead:
+		args = append(args, "--head")
+	default:
+		args = append(args, "-X", e.Method)
+	}
+	args = append(args, shellQuote(strings.TrimSuffix(baseURL, "/")+e.Path))
+
+	for _, k := range slices.Sorted(maps.Keys(e.RequestHeaders)) {
+		canonical := http.CanonicalHeaderKey(k)
+		if slices.Contains(curlSkippedHeaders, canonical) || strings.HasPrefix(canonical, "Cf-") {
+			continue
+		}
+		for _, v := range e.RequestHeaders[k] {
+			args = append(args, "-H", shellQuote(k+": "+v))
+		}
+	}
+
+	switch {
+	case e.RequestBody == "":
+		if e.BytesIn > 0 {
+			// Not captured (sampled out or over the size cap)
+			b.WriteString("# request body was not captured\n")
+		}
+	case utf8.ValidString(e.RequestBody) && !strings.ContainsRune(e.RequestBody, 0):
+		args = append(args, "--data-raw", shellQuote(e.RequestBody))
+	default:
+		fmt.Fprintf(&b, "echo %s | base64 -d | ", base64.StdEncoding.EncodeToString([]byte(e.RequestBody)))
+		args = append(args, "--data-binary", "@-")
+	}
+	b.WriteString(strings.Join(args, " "))
+	return b.String()
+}
+
+// shellQuote quotes s for POSIX shells.
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=@,+%", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,248 @@
+package stats
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/QuadTriangle/prod.bd/cli/statsapi"
+)
+
+// defaultDiffIgnore are left out of replay diffs unless ignore is given.
+var defaultDiffIgnore = []string{"Date"}
+
+// Diff limits: JSON differences reported, and lines compared for text
+// bodies (the line diff is quadratic).
+const (
+	maxFieldDiffs = 100
+	maxDiffLines  = 2000
+)
+
+// diffIgnore splits an ignore parameter into header names and JSON field
+// paths ($.a.b[0]).
+type diffIgnore struct {
+	headers map[string]bool // canonical names
+	fields  map[string]bool
+}
+
+func parseDiffIgnore(list []string) diffIgnore {
+	ig := diffIgnore{headers: map[string]bool{}, fields: map[string]bool{}}
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		switch {
+		case s == "":
+		case strings.HasPrefix(s, "$"):
+			ig.fields[s] = true
+		default:
+			ig.headers[http.CanonicalHeaderKey(s)] = true
+		}
+	}
+	return ig
+}
+
+// diffEntries compares the response of replay with that of orig.
+func diffEntries(orig, replay RequestEntry, ig diffIgnore) statsapi.ReplayDiff {
+	d := statsapi.ReplayDiff{
+		Status: statsapi.StatusDiff{Original: orig.Status, Replay: replay.Status, Changed: orig.Status != replay.Status},
+	}
+	d.Headers = diffHeaders(orig.ResponseHeaders, replay.ResponseHeaders, ig)
+	d.Body = diffBodies(orig, replay, ig)
+	d.Identical = !d.Status.Changed && len(d.Headers) == 0 && !d.Body.Changed
+	return d
+}
+
+func diffHeaders(a, b map[string][]string, ig diffIgnore) []statsapi.HeaderDiff {
+	canon := func(h map[string][]string) map[string][]string {
+		out := make(map[string][]string, len(h))
+		for k, v := range h {
+			k = http.CanonicalHeaderKey(k)
+			out[k] = append(out[k], v...)
+		}
+		return out
+	}
+	a, b = canon(a), canon(b)
+	var names []string
+	for k := range a {
+		names = append(names, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			names = append(names, k)
+		}
+	}
+	slices.Sort(names)
+	var out []statsapi.HeaderDiff
+	for _, k := range names {
+		if ig.headers[k] || slices.Equal(a[k], b[k]) {
+			continue
+		}
+		out = append(out, statsapi.HeaderDiff{Name: k, Original: a[k], Replay: b[k]})
+	}
+	return out
+}
+
+func diffBodies(orig, replay RequestEntry, ig diffIgnore) statsapi.BodyDiff {
+	var d statsapi.BodyDiff
+	switch {
+	case orig.BytesOut > 0 && orig.ResponseBody == "":
+		d.Skipped = "the original response body wasn't captured"
+		return d
+	case replay.BytesOut > 0 && replay.ResponseBody == "":
+		d.Skipped = "the replayed response body was too large to store"
+		return d
+	}
+	if orig.ResponseBody == replay.ResponseBody {
+		return d
+	}
+
+	var a, b any
+	if json.Unmarshal([]byte(orig.ResponseBody), &a) == nil && json.Unmarshal([]byte(replay.ResponseBody), &b) == nil {
+		diffJSON("$", a, b, ig, &d.Fields)
+		d.Changed = len(d.Fields) > 0
+		d.Truncated = len(d.Fields) > maxFieldDiffs
+		if d.Truncated {
+			d.Fields = d.Fields[:maxFieldDiffs]
+		}
+		return d
+	}
+
+	d.Changed = true
+	al, bl := strings.Split(orig.ResponseBody, "\n"), strings.Split(replay.ResponseBody, "\n")
+	if len(al) > maxDiffLines || len(bl) > maxDiffLines {
+		d.Truncated = true
+		return d
+	}
+	d.Unified = unifiedDiff(al, bl)
+	return d
+}
+
+// diffJSON appends the differences between a and b under path. It stops
+// just past maxFieldDiffs, so the caller can tell the list is cut.
+func diffJSON(path string, a, b any, ig diffIgnore, out *[]statsapi.FieldDiff) {
+	if ig.fields[path] || len(*out) > maxFieldDiffs {
+		return
+	}
+	switch av := a.(type) {
+	case map[string]any:
+		bv, ok := b.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(av)+len(bv))
+		for k := range av {
+			keys = append(keys, k)
+		}
+		for k := range bv {
+			if _, ok := av[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			x, inA := av[k]
+			y, inB := bv[k]
+			sub := path + "." + k
+			switch {
+			case !inA:
+				addField(sub, "added", nil, y, ig, out)
+			case !inB:
+				addField(sub, "removed", x, nil, ig, out)
+			default:
+				diffJSON(sub, x, y, ig, out)
+			}
+		}
+		return
+	case []any:
+		bv, ok := b.([]any)
+		if !ok {
+			break
+		}
+		for i := range max(len(av), len(bv)) {
+			sub := path + "[" + strconv.Itoa(i) + "]"
+			switch {
+			case i >= len(av):
+				addField(sub, "added", nil, bv[i], ig, out)
+			case i >= len(bv):
+				addField(sub, "removed", av[i], nil, ig, out)
+			default:
+				diffJSON(sub, av[i], bv[i], ig, out)
+			}
+		}
+		return
+	}
+	if !reflect.DeepEqual(a, b) {
+		addField(path, "changed", a, b, ig, out)
+	}
+}
+
+func addField(path, change string, a, b any, ig diffIgnore, out *[]statsapi.FieldDiff) {
+	if ig.fields[path] {
+		return
+	}
+	*out = append(*out, statsapi.FieldDiff{Path: path, Change: change, Original: a, Replay: b})
+}
+
+// diffContext is how many unchanged lines surround each change in a
+// unified diff.
+const diffContext = 3
+
+// unifiedDiff renders the line diff of a and b: changed lines prefixed with
+// - or +, with diffContext unchanged lines (prefixed with a space) around
+// them and "@@" between hunks.
+func unifiedDiff(a, b []string) string {
+	// Longest common subsequence, from the end
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var lines []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, " "+a[i])
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, "-"+a[i])
+			i++
+		default:
+			lines = append(lines, "+"+b[j])
+			j++
+		}
+	}
+
+	// Keep changes and their context
+	keep := make([]bool, len(lines))
+	for k, l := range lines {
+		if l[0] != ' ' {
+			for c := max(k-diffContext, 0); c <= min(k+diffContext, len(lines)-1); c++ {
+				keep[c] = true
+			}
+		}
+	}
+	var sb strings.Builder
+	for k, l := range lines {
+		if !keep[k] {
+			continue
+		}
+		if k > 0 && !keep[k-1] {
+			sb.WriteString("@@\n")
+		}
+		sb.WriteString(l)
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
@@ -0,0 +1,56 @@
+package stats
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+// FeedbackPath is where the banner's feedback widget posts comments.
+// It lives on the public tunnel, so reviewers never need the local dashboard.
+const FeedbackPath = hooks.ReservedPrefix + "feedback"
+
+// maxFeedbackLen caps a single comment.
+const maxFeedbackLen = 4000
+
+type feedbackHandler struct {
+	store *Store
+}
+
+func (h *feedbackHandler) ServePath(ctx *hooks.RequestContext, req types.TunnelRequest) (types.TunnelResponse, bool) {
+	if path, _, _ := strings.Cut(req.Path, "?"); path != FeedbackPath {
+		return types.TunnelResponse{}, false
+	}
+	if req.Method != http.MethodPost {
+		return hooks.TextResponse(http.StatusMethodNotAllowed, "method not allowed"), true
+	}
+
+	body, err := base64.StdEncoding.DecodeString(req.Body)
+	if err != nil {
+		return hooks.TextResponse(http.StatusBadRequest, "invalid body"), true
+	}
+	var in struct {
+		Comment string `json:"comment"`
+		URL     string `json:"url"`
+	}
+	if err := json.Unmarshal(body, &in); err != nil || strings.TrimSpace(in.Comment) == "" {
+		return hooks.TextResponse(http.StatusBadRequest, "comment is required"), true
+	}
+	if len(in.Comment) > maxFeedbackLen {
+		in.Comment = in.Comment[:maxFeedbackLen]
+	}
+
+	h.store.AddFeedback(Feedback{
+		Subdomain: ctx.Subdomain,
+		Comment:   strings.TrimSpace(in.Comment),
+		URL:       in.URL,
+		UserAgent: http.Header(req.Headers).Get("User-Agent"),
+		Timestamp: time.Now(),
+	})
+	return types.TunnelResponse{Status: http.StatusNoContent}, true
+}
@@ -0,0 +1,59 @@
+package stats
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+type widget bool
+
+func (w widget) Feedback() bool { return bool(w) }
+
+func feedbackRequest(path string) types.TunnelRequest {
+	return types.TunnelRequest{
+		Type:    types.TypeHTTPRequest,
+		ID:      "r1",
+		Method:  http.MethodPost,
+		Path:    path,
+		Headers: map[string][]string{"Content-Type": {"application/json"}},
+		Body:    base64.StdEncoding.EncodeToString([]byte(`{"comment":" looks good ","url":"/checkout"}`)),
+	}
+}
+
+func TestFeedbackOnlyWithWidget(t *testing.T) {
+	for _, shown := range []bool{false, true} {
+		p := New()
+		p.SetFeedbackWidget(widget(shown))
+		served := false
+		for _, h := range p.PathHandlers() {
+			_, ok := h.ServePath(hooks.NewRequestContext(hooks.NewTunnelContext("abc", 3000), time.Now().Add(time.Minute)), feedbackRequest(FeedbackPath))
+			served = served || ok
+		}
+		if served != shown {
+			t.Errorf("widget shown %v: feedback taken %v", shown, served)
+		}
+	}
+}
+
+func TestFeedbackPathWithQuery(t *testing.T) {
+	store := NewStore(10)
+	h := &feedbackHandler{store: store}
+	ctx := hooks.NewRequestContext(hooks.NewTunnelContext("abc", 3000), time.Now().Add(time.Minute))
+
+	resp, ok := h.ServePath(ctx, feedbackRequest(FeedbackPath+"?from=banner"))
+	if !ok || resp.Status != http.StatusNoContent {
+		t.Fatalf("got %v %d, want 204", ok, resp.Status)
+	}
+	if _, ok := h.ServePath(ctx, feedbackRequest(FeedbackPath+"x")); ok {
+		t.Error("served a path that only starts with the feedback path")
+	}
+	f := store.Feedback()
+	if len(f) != 1 || f[0].Comment != "looks good" || f[0].Subdomain != "abc" || f[0].URL != "/checkout" {
+		t.Fatalf("stored %+v", f)
+	}
+}
@@ -0,0 +1,19 @@
+// Command genopenapi writes the OpenAPI description of the local API to
+// the file named by its argument. Run through go generate in statsapi.
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/plugins/stats"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatal("usage: genopenapi <file>")
+	}
+	if err := os.WriteFile(os.Args[1], stats.OpenAPI(), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
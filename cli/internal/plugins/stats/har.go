@@ -0,0 +1,209 @@
+package stats
+
+import (
+	"encoding/base64"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/config"
+	"github.com/QuadTriangle/prod.bd/cli/internal/protobuf"
+)
+
+// HAR 1.2 (http://www.softwareishard.com/blog/har-12-spec/), as much of it
+// as the request log can fill. The CLI doesn't see raw header sizes, so
+// headersSize is always -1, and the whole latency counts as wait time.
+
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"` // ms
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// toHAR converts logged entries, oldest first, into a HAR log. URLs use
+// each tunnel's public address, as that is what visitors requested.
+// Protobuf bodies are exported decoded as JSON when -proto files are loaded.
+func toHAR(entries []RequestEntry) harFile {
+	out := harFile{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "prod.bd", Version: "1.0"},
+		Entries: make([]harEntry, 0, len(entries)),
+	}}
+	for _, e := range entries {
+		ms := float64(e.Latency.Microseconds()) / 1000
+		entry := harEntry{
+			StartedDateTime: e.Timestamp.Add(-e.Latency).UTC().Format(time.RFC3339Nano),
+			Time:            ms,
+			Request: harRequest{
+				Method:      e.Method,
+				URL:         config.PublicURL(e.Subdomain) + e.Path,
+				HTTPVersion: "HTTP/1.1",
+				Cookies:     []harNameValue{},
+				Headers:     harHeaders(e.RequestHeaders),
+				QueryString: harQuery(e.Path),
+				HeadersSize: -1,
+				BodySize:    e.BytesIn,
+			},
+			Response: harResponse{
+				Status:      e.Status,
+				StatusText:  http.StatusText(e.Status),
+				HTTPVersion: "HTTP/1.1",
+				Cookies:     []harNameValue{},
+				Headers:     harHeaders(e.ResponseHeaders),
+				Content:     harBody(e.Path, e.ResponseHeaders, e.ResponseBody, e.BytesOut),
+				RedirectURL: headerValue(e.ResponseHeaders, "Location"),
+				HeadersSize: -1,
+				BodySize:    e.BytesOut,
+			},
+			Timings: harTimings{Send: 0, Wait: ms, Receive: 0},
+		}
+		if e.RequestBody != "" {
+			entry.Request.PostData = &harPostData{
+				MimeType: headerValue(e.RequestHeaders, "Content-Type"),
+				Text:     e.RequestBody,
+			}
+			if text, message, ok := protobuf.DecodeBody(e.Path, e.RequestHeaders, []byte(e.RequestBody), false); ok {
+				entry.Request.PostData.Text = text
+				entry.Request.PostData.Comment = decodedComment(message)
+			}
+		}
+		if e.ReplayOf != 0 {
+			entry.Comment = "replay of request " + strconv.Itoa(e.ReplayOf)
+		}
+		out.Log.Entries = append(out.Log.Entries, entry)
+	}
+	return out
+}
+
+func harHeaders(h map[string][]string) []harNameValue {
+	out := []harNameValue{}
+	for _, k := range slices.Sorted(maps.Keys(h)) {
+		for _, v := range h[k] {
+			out = append(out, harNameValue{Name: k, Value: v})
+		}
+	}
+	return out
+}
+
+func harQuery(p string) []harNameValue {
+	out := []harNameValue{}
+	_, rawQuery, ok := strings.Cut(p, "?")
+	if !ok {
+		return out
+	}
+	q, _ := url.ParseQuery(rawQuery)
+	for _, k := range slices.Sorted(maps.Keys(q)) {
+		for _, v := range q[k] {
+			out = append(out, harNameValue{Name: k, Value: v})
+		}
+	}
+	return out
+}
+
+// harBody fills a response's content; binary bodies are base64 encoded, as
+// HAR allows, unless they decode as protobuf.
+func harBody(reqPath string, headers map[string][]string, body string, size int) harContent {
+	c := harContent{Size: size, MimeType: headerValue(headers, "Content-Type")}
+	if body == "" {
+		return c
+	}
+	if text, message, ok := protobuf.DecodeBody(reqPath, headers, []byte(body), true); ok {
+		c.Text = text
+		c.Comment = decodedComment(message)
+	} else if utf8.ValidString(body) {
+		c.Text = body
+	} else {
+		c.Text = base64.StdEncoding.EncodeToString([]byte(body))
+		c.Encoding = "base64"
+	}
+	return c
+}
+
+func decodedComment(message string) string {
+	if message == "" {
+		return "protobuf decoded by field number"
+	}
+	return "protobuf decoded as " + message
+}
+
+func headerValue(h map[string][]string, name string) string {
+	for k, vs := range h {
+		if strings.EqualFold(k, name) && len(vs) > 0 {
+			return vs[0]
+		}
+	}
+	return ""
+}
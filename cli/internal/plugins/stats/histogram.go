@@ -0,0 +1,148 @@
+package stats
+
+import (
+	"math/bits"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Latency histograms are HDR-style: exact below histSub microseconds, then
+// histSub/2 buckets per doubling, so a percentile is within about 3% of
+// the true value from microseconds to hours, in a fixed few KB.
+const (
+	histSubBits = 5
+	histSub     = 1 << histSubBits
+	histHalf    = histSub / 2
+	// histMaxExp covers latencies up to 2^(histSubBits+histMaxExp) µs,
+	// about 9.5 hours; longer ones land in the last bucket
+	histMaxExp  = 30
+	histBuckets = histSub + histMaxExp*histHalf
+)
+
+// Histogram counts latencies for percentiles. The zero value is empty and
+// ready to use; it is a plain value, so copying it takes a snapshot.
+type Histogram struct {
+	counts [histBuckets]uint32
+	total  int
+	max    time.Duration
+}
+
+// Record adds one latency.
+func (h *Histogram) Record(d time.Duration) {
+	h.counts[histIndex(d.Microseconds())]++
+	h.total++
+	h.max = max(h.max, d)
+}
+
+// Merge adds o's latencies to h.
+func (h *Histogram) Merge(o *Histogram) {
+	for i, n := range o.counts {
+		h.counts[i] += n
+	}
+	h.total += o.total
+	h.max = max(h.max, o.max)
+}
+
+// Count is the number of latencies recorded.
+func (h *Histogram) Count() int { return h.total }
+
+// Percentile returns the latency at or below which p percent of those
+// recorded fall, rounded up to its bucket's bound, or 0 if there are none.
+func (h *Histogram) Percentile(p float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := int(float64(h.total)*p/100 + 0.5)
+	rank = min(max(rank, 1), h.total)
+	seen := 0
+	for i, n := range h.counts {
+		seen += int(n)
+		if seen >= rank {
+			return min(time.Duration(histUpper(i))*time.Microsecond, h.max)
+		}
+	}
+	return 0
+}
+
+func histIndex(us int64) int {
+	if us < histSub {
+		return int(max(us, 0))
+	}
+	exp := bits.Len64(uint64(us)) - histSubBits
+	if exp > histMaxExp {
+		return histBuckets - 1
+	}
+	return exp*histHalf + int(us>>exp)
+}
+
+// histUpper is the largest latency, in microseconds, bucket i holds.
+func histUpper(i int) int64 {
+	if i < histSub {
+		return int64(i)
+	}
+	exp := (i - histHalf) / histHalf
+	sub := int64(i - exp*histHalf)
+	return (sub+1)<<exp - 1
+}
+
+// maxPathStats caps the endpoints tracked per tunnel; requests to others
+// are counted under otherPaths.
+const (
+	maxPathStats = 200
+	otherPaths   = "*"
+)
+
+// PathStats aggregates the requests to one endpoint of a tunnel: a method
+// and a path template.
+type PathStats struct {
+	Subdomain    string
+	Method       string
+	Path         string // IDs replaced by {id}, e.g. /users/{id}
+	Requests     int
+	Errors       int
+	TotalLatency time.Duration
+	MaxLatency   time.Duration
+	Latencies    Histogram
+}
+
+// pathTemplate groups paths by endpoint: the query is dropped and segments
+// that look like IDs (numbers, UUIDs, long hex or mixed tokens) become
+// {id}.
+func pathTemplate(path string) string {
+	path, _, _ = strings.Cut(path, "?")
+	segs := strings.Split(path, "/")
+	for i, seg := range segs {
+		if looksLikeID(seg) {
+			segs[i] = "{id}"
+		}
+	}
+	return strings.Join(segs, "/")
+}
+
+func looksLikeID(seg string) bool {
+	if seg == "" {
+		return false
+	}
+	digits, hex, other := 0, 0, 0
+	for _, r := range seg {
+		switch {
+		case unicode.IsDigit(r):
+			digits++
+		case strings.ContainsRune("abcdefABCDEF", r):
+			hex++
+		case r == '-' || r == '_':
+		default:
+			other++
+		}
+	}
+	switch {
+	case digits == len(seg): // 42
+		return true
+	case other == 0 && digits > 0 && len(seg) >= 8: // UUIDs, hashes
+		return true
+	case len(seg) >= 16 && digits >= 3: // opaque tokens
+		return true
+	}
+	return false
+}
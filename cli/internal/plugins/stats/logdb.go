@@ -0,0 +1,158 @@
+package stats
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/storage"
+)
+
+// logDBQueue is how many entries may wait for the writer before new ones are dropped.
+const logDBQueue = 1024
+
+// LogDB persists request log entries to a storage backend (SQLite by
+// default) so traffic history survives restarts. Inserts are queued and
+// written in the background so the tunnel never waits on disk.
+type LogDB struct {
+	db        storage.Backend
+	session   string // distinguishes entry IDs from different CLI runs
+	retention time.Duration
+	queue     chan RequestEntry
+	done      chan struct{}
+
+	mu     sync.Mutex // guards closed against Enqueue racing Close
+	closed bool
+}
+
+// HistoryEntry is a persisted RequestEntry. ID is the database row ID;
+// Entry.ID is the ID it had in its session.
+type HistoryEntry struct {
+	ID      int64
+	Session string
+	Entry   RequestEntry
+}
+
+// HistoryQuery filters LogDB.Query. Zero values mean no filter.
+type HistoryQuery = storage.Query
+
+// OpenLogDB opens (or creates) the backend at spec, a SQLite path or
+// "<backend>:<location>" (see storage.Open). Entries older than retention
+// are pruned hourly; retention <= 0 keeps everything.
+func OpenLogDB(spec string, retention time.Duration) (*LogDB, error) {
+	db, err := storage.Open(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &LogDB{
+		db:        db,
+		session:   time.Now().UTC().Format("20060102T150405Z"),
+		retention: retention,
+		queue:     make(chan RequestEntry, logDBQueue),
+		done:      make(chan struct{}),
+	}
+	l.prune()
+	go l.run()
+	return l, nil
+}
+
+// Enqueue schedules entry for insertion, dropping it if the writer is behind.
+func (l *LogDB) Enqueue(entry RequestEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return
+	}
+	select {
+	case l.queue <- entry:
+	default:
+		log.Printf("[stats] log-db queue full, dropping entry %d", entry.ID)
+	}
+}
+
+func (l *LogDB) run() {
+	defer close(l.done)
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case e, ok := <-l.queue:
+			if !ok {
+				return
+			}
+			if err := l.insert(e); err != nil {
+				log.Printf("[stats] log-db insert failed: %v", err)
+			}
+		case <-ticker.C:
+			l.prune()
+		}
+	}
+}
+
+func (l *LogDB) insert(e RequestEntry) error {
+	return l.db.AppendRequest(storage.Request{
+		Session:         l.session,
+		EntryID:         e.ID,
+		Subdomain:       e.Subdomain,
+		Method:          e.Method,
+		Path:            e.Path,
+		Status:          e.Status,
+		Latency:         e.Latency,
+		BytesIn:         e.BytesIn,
+		BytesOut:        e.BytesOut,
+		Timestamp:       e.Timestamp,
+		RequestHeaders:  e.RequestHeaders,
+		RequestBody:     e.RequestBody,
+		ResponseHeaders: e.ResponseHeaders,
+		ResponseBody:    e.ResponseBody,
+		ReplayOf:        e.ReplayOf,
+	})
+}
+
+func (l *LogDB) prune() {
+	if l.retention <= 0 {
+		return
+	}
+	if err := l.db.PruneRequests(time.Now().Add(-l.retention)); err != nil {
+		log.Printf("[stats] log-db prune failed: %v", err)
+	}
+}
+
+// Query returns persisted entries matching q, newest first.
+func (l *LogDB) Query(q HistoryQuery) ([]HistoryEntry, error) {
+	stored, err := l.db.QueryRequests(q)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]HistoryEntry, 0, len(stored))
+	for _, r := range stored {
+		out = append(out, HistoryEntry{ID: r.ID, Session: r.Session, Entry: RequestEntry{
+			ID:              r.EntryID,
+			Subdomain:       r.Subdomain,
+			Method:          r.Method,
+			Path:            r.Path,
+			Status:          r.Status,
+			Latency:         r.Latency,
+			BytesIn:         r.BytesIn,
+			BytesOut:        r.BytesOut,
+			Timestamp:       r.Timestamp,
+			RequestHeaders:  r.RequestHeaders,
+			RequestBody:     r.RequestBody,
+			ResponseHeaders: r.ResponseHeaders,
+			ResponseBody:    r.ResponseBody,
+			ReplayOf:        r.ReplayOf,
+		}})
+	}
+	return out, nil
+}
+
+// Close flushes queued entries and closes the database.
+func (l *LogDB) Close() error {
+	l.mu.Lock()
+	l.closed = true
+	close(l.queue)
+	l.mu.Unlock()
+	<-l.done
+	return l.db.Close()
+}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package stats
+
+import "syscall"
+
+// mapMemory maps size bytes of anonymous memory outside the Go heap.
+func mapMemory(size int) ([]byte, func() error, error) {
+	mem, err := syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, nil, err
+	}
+	return mem, func() error { return syscall.Munmap(mem) }, nil
+}
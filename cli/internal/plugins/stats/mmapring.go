@@ -0,0 +1,228 @@
+package stats
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// mmapRing stores entries in a preallocated memory-mapped region of
+// fixed-size slots. push encodes straight into the slot, so capturing a
+// request allocates nothing and the GC never scans the log. Fields that
+// don't fit in a slot are truncated, bodies first.
+//
+// Slot layout (little endian):
+//
+//	id, timestamp (unix ns), latency (ns), bytes in, bytes out, replay of: int64
+//	status: int32
+//	then length-prefixed (uint32) strings: subdomain, method, path,
+//	request headers, response headers, request body, response body
+//
+// Headers are encoded as "Name: value\n" lines.
+type mmapRing struct {
+	mem      []byte
+	unmap    func() error
+	slotSize int
+	slots    int
+	next     int // slot the next push writes
+	count    int // slots in use
+}
+
+const (
+	slotFixed   = 6*8 + 4
+	slotFields  = 7
+	minSlotSize = slotFixed + slotFields*4 + 256
+)
+
+func newMmapRing(slots, slotSize int) (*mmapRing, error) {
+	if slots <= 0 {
+		return nil, fmt.Errorf("capture slots must be > 0")
+	}
+	if slotSize < minSlotSize {
+		return nil, fmt.Errorf("capture slot size must be at least %d bytes", minSlotSize)
+	}
+	mem, unmap, err := mapMemory(slots * slotSize)
+	if err != nil {
+		return nil, fmt.Errorf("mapping %d bytes for the capture buffer: %w", slots*slotSize, err)
+	}
+	return &mmapRing{mem: mem, unmap: unmap, slotSize: slotSize, slots: slots}, nil
+}
+
+func (r *mmapRing) slot(i int) []byte {
+	return r.mem[i*r.slotSize : (i+1)*r.slotSize]
+}
+
+func (r *mmapRing) push(e RequestEntry) {
+	b := r.slot(r.next)
+	le := binary.LittleEndian
+	le.PutUint64(b[0:], uint64(e.ID))
+	le.PutUint64(b[8:], uint64(e.Timestamp.UnixNano()))
+	le.PutUint64(b[16:], uint64(e.Latency))
+	le.PutUint64(b[24:], uint64(e.BytesIn))
+	le.PutUint64(b[32:], uint64(e.BytesOut))
+	le.PutUint64(b[40:], uint64(e.ReplayOf))
+	le.PutUint32(b[48:], uint32(e.Status))
+
+	w := slotWriter{buf: b, off: slotFixed}
+	// Small fields first so truncation eats the bodies, not the path
+	w.str(e.Subdomain)
+	w.str(e.Method)
+	w.str(e.Path)
+	w.headers(e.RequestHeaders)
+	w.headers(e.ResponseHeaders)
+	w.str(e.RequestBody)
+	w.str(e.ResponseBody)
+
+	r.next = (r.next + 1) % r.slots
+	r.count = min(r.count+1, r.slots)
+}
+
+// index returns the slot holding the i-th oldest entry.
+func (r *mmapRing) index(i int) int {
+	return (r.next - r.count + i + r.slots) % r.slots
+}
+
+func (r *mmapRing) recent(n int) []RequestEntry {
+	n = min(n, r.count)
+	out := make([]RequestEntry, 0, n)
+	for i := r.count - n; i < r.count; i++ {
+		out = append(out, r.decode(r.slot(r.index(i))))
+	}
+	return out
+}
+
+func (r *mmapRing) find(id int) (RequestEntry, bool) {
+	for i := r.count - 1; i >= 0; i-- {
+		b := r.slot(r.index(i))
+		if int(binary.LittleEndian.Uint64(b[0:])) == id {
+			return r.decode(b), true
+		}
+	}
+	return RequestEntry{}, false
+}
+
+func (r *mmapRing) scan(fn func(RequestEntry) bool) {
+	for i := r.count - 1; i >= 0; i-- {
+		if !fn(r.decode(r.slot(r.index(i)))) {
+			return
+		}
+	}
+}
+
+func (r *mmapRing) statuses(fn func(string, int)) {
+	for i := 0; i < r.count; i++ {
+		b := r.slot(r.index(i))
+		rd := slotReader{buf: b, off: slotFixed}
+		fn(rd.str(), int(int32(binary.LittleEndian.Uint32(b[48:]))))
+	}
+}
+
+func (r *mmapRing) close() error {
+	if r.unmap == nil {
+		return nil
+	}
+	err := r.unmap()
+	r.mem, r.unmap, r.count = nil, nil, 0
+	return err
+}
+
+func (r *mmapRing) decode(b []byte) RequestEntry {
+	le := binary.LittleEndian
+	e := RequestEntry{
+		ID:        int(le.Uint64(b[0:])),
+		Timestamp: time.Unix(0, int64(le.Uint64(b[8:]))),
+		Latency:   time.Duration(le.Uint64(b[16:])),
+		BytesIn:   int(le.Uint64(b[24:])),
+		BytesOut:  int(le.Uint64(b[32:])),
+		ReplayOf:  int(le.Uint64(b[40:])),
+		Status:    int(int32(le.Uint32(b[48:]))),
+	}
+	rd := slotReader{buf: b, off: slotFixed}
+	e.Subdomain = rd.str()
+	e.Method = rd.str()
+	e.Path = rd.str()
+	e.RequestHeaders = parseHeaderLines(rd.str())
+	e.ResponseHeaders = parseHeaderLines(rd.str())
+	e.RequestBody = rd.str()
+	e.ResponseBody = rd.str()
+	return e
+}
+
+// slotWriter appends length-prefixed strings to a slot, truncating each to
+// the space left after reserving length prefixes for the remaining fields.
+type slotWriter struct {
+	buf    []byte
+	off    int
+	fields int // fields written so far
+}
+
+// space returns how many bytes the current field may use.
+func (w *slotWriter) space() int {
+	remaining := slotFields - w.fields - 1 // prefixes still needed after this one
+	return max(len(w.buf)-w.off-4-remaining*4, 0)
+}
+
+// begin reserves the length prefix and returns where the data starts.
+func (w *slotWriter) begin() int {
+	w.off += 4
+	return w.off
+}
+
+// end writes the length prefix for data that started at start.
+func (w *slotWriter) end(start int) {
+	binary.LittleEndian.PutUint32(w.buf[start-4:], uint32(w.off-start))
+	w.fields++
+}
+
+func (w *slotWriter) str(s string) {
+	limit := w.space()
+	start := w.begin()
+	w.off += copy(w.buf[w.off:w.off+min(len(s), limit)], s)
+	w.end(start)
+}
+
+func (w *slotWriter) headers(h map[string][]string) {
+	limit := w.space()
+	start := w.begin()
+	stop := w.off + limit
+	for k, vals := range h {
+		for _, v := range vals {
+			if w.off+len(k)+len(v)+3 > stop {
+				w.end(start)
+				return
+			}
+			w.off += copy(w.buf[w.off:], k)
+			w.off += copy(w.buf[w.off:], ": ")
+			w.off += copy(w.buf[w.off:], v)
+			w.buf[w.off] = '\n'
+			w.off++
+		}
+	}
+	w.end(start)
+}
+
+type slotReader struct {
+	buf []byte
+	off int
+}
+
+func (r *slotReader) str() string {
+	n := int(binary.LittleEndian.Uint32(r.buf[r.off:]))
+	r.off += 4
+	s := string(r.buf[r.off : r.off+n])
+	r.off += n
+	return s
+}
+
+func parseHeaderLines(s string) map[string][]string {
+	if s == "" {
+		return nil
+	}
+	h := make(map[string][]string)
+	for _, line := range strings.Split(strings.TrimSuffix(s, "\n"), "\n") {
+		k, v, _ := strings.Cut(line, ": ")
+		h[k] = append(h[k], v)
+	}
+	return h
+}
@@ -0,0 +1,144 @@
+package stats
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"maps"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf16"
+)
+
+// maxStoredBody caps the bodies kept in the request log, after decoding.
+const maxStoredBody = 64_000
+
+// normalizeBody turns a captured body into UTF-8 text for the log: gzip and
+// deflate content-encodings are undone and Latin-1, Windows-1252 and UTF-16
+// text is converted. The returned headers describe the stored body
+// (Content-Encoding dropped, charset=utf-8), so a replay sends an equivalent
+// request; they are a copy whenever they differ from h. Other encodings (br,
+// zstd) and charsets are stored as they came. ok is false if the body is
+// over maxStoredBody once decoded.
+func normalizeBody(body []byte, h map[string][]string) (text string, headers map[string][]string, ok bool) {
+	headers = h
+	cloned := false
+	edit := func() http.Header {
+		if !cloned {
+			headers, cloned = maps.Clone(h), true
+		}
+		return http.Header(headers)
+	}
+	if enc := strings.ToLower(strings.TrimSpace(http.Header(h).Get("Content-Encoding"))); enc != "" && enc != "identity" {
+		if decoded, err := decompress(enc, body); err == nil {
+			body = decoded
+			edit().Del("Content-Encoding")
+		}
+	}
+	if len(body) >= maxStoredBody {
+		return "", h, false
+	}
+
+	mediaType, params, err := mime.ParseMediaType(http.Header(headers).Get("Content-Type"))
+	if err != nil || !isText(mediaType) {
+		return string(body), headers, true
+	}
+	if utf8Text, converted := toUTF8(body, strings.ToLower(params["charset"])); converted {
+		body = utf8Text
+		params["charset"] = "utf-8"
+		edit().Set("Content-Type", mime.FormatMediaType(mediaType, params))
+	}
+	return string(body), headers, true
+}
+
+// decompress undoes a content-encoding, reading at most maxStoredBody bytes
+// so a compression bomb can't balloon the log.
+func decompress(enc string, body []byte) ([]byte, error) {
+	var r io.Reader
+	switch enc {
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		r = zr
+	case "deflate":
+		// Usually zlib-wrapped as the spec says, sometimes raw
+		if zr, err := zlib.NewReader(bytes.NewReader(body)); err == nil {
+			r = zr
+		} else {
+			r = flate.NewReader(bytes.NewReader(body))
+		}
+	default:
+		return nil, http.ErrNotSupported
+	}
+	return io.ReadAll(io.LimitReader(r, maxStoredBody))
+}
+
+// isText reports whether a media type carries text worth converting.
+func isText(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "json") || strings.HasSuffix(mediaType, "xml") ||
+		strings.Contains(mediaType, "javascript") || mediaType == "application/x-www-form-urlencoded"
+}
+
+// windows1252 maps bytes 0x80-0x9F of Windows-1252 (which browsers also use
+// for ISO-8859-1) to runes; 0 marks the few unassigned ones.
+var windows1252 = [32]rune{
+	'€', 0, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0, 'Ž', 0,
+	0, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0, 'ž', 'Ÿ',
+}
+
+// toUTF8 converts text in charset to UTF-8. A UTF-16 byte order mark is
+// honoured without a charset. converted is false for UTF-8 and for charsets
+// it doesn't know.
+func toUTF8(body []byte, charset string) (out []byte, converted bool) {
+	switch {
+	case bytes.HasPrefix(body, []byte{0xFF, 0xFE}) && (charset == "" || strings.HasPrefix(charset, "utf-16")):
+		return utf16ToUTF8(body[2:], binary.LittleEndian), true
+	case bytes.HasPrefix(body, []byte{0xFE, 0xFF}) && (charset == "" || strings.HasPrefix(charset, "utf-16")):
+		return utf16ToUTF8(body[2:], binary.BigEndian), true
+	}
+	switch charset {
+	case "utf-16le":
+		return utf16ToUTF8(body, binary.LittleEndian), true
+	case "utf-16be", "utf-16":
+		return utf16ToUTF8(body, binary.BigEndian), true
+	case "iso-8859-1", "latin1", "l1", "iso_8859-1", "windows-1252", "cp1252", "us-ascii", "ascii":
+		if !hasHighBytes(body) {
+			return body, false
+		}
+		var buf bytes.Buffer
+		buf.Grow(len(body) + len(body)/4)
+		for _, b := range body {
+			r := rune(b)
+			if b >= 0x80 && b <= 0x9F && windows1252[b-0x80] != 0 {
+				r = windows1252[b-0x80]
+			}
+			buf.WriteRune(r)
+		}
+		return buf.Bytes(), true
+	}
+	return body, false
+}
+
+func utf16ToUTF8(b []byte, order binary.ByteOrder) []byte {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = order.Uint16(b[2*i:])
+	}
+	return []byte(string(utf16.Decode(units)))
+}
+
+func hasHighBytes(b []byte) bool {
+	for _, c := range b {
+		if c >= 0x80 {
+			return true
+		}
+	}
+	return false
+}
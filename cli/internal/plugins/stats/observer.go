@@ -0,0 +1,79 @@
+package stats
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/statsapi"
+)
+
+// ObserverPath is where -observer-token serves the read-only dashboard on
+// the public URL.
+const ObserverPath = hooks.ReservedPrefix + "observe/"
+
+// observerCookie keeps a browser signed in after it opened the share link.
+const observerCookie = "prodbd_observer"
+
+// ObserverURL returns the share link for subdomain.
+func ObserverURL(publicURL, token string) string {
+	return strings.TrimSuffix(publicURL, "/") + ObserverPath + "?token=" + token
+}
+
+// observerHandler serves a read-only view of the dashboard to teammates
+// holding the share token: live stats and the request log, but no replay,
+// notes or failpoints. Everything is buffered through the tunnel, so the
+// dashboard polls instead of streaming.
+func observerHandler(s *Server, token string) http.Handler {
+	api := http.NewServeMux()
+	for _, rt := range routes() {
+		if rt.observe {
+			api.HandleFunc(rt.method+" "+statsapi.Prefix+rt.path, func(w http.ResponseWriter, r *http.Request) { rt.handle(s, w, r) })
+		}
+	}
+	api.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		data, _ := dashboardHTML.ReadFile("index.html")
+		data = bytes.Replace(data, []byte("const API = '';"), []byte("const API = '"+strings.TrimSuffix(ObserverPath, "/")+"';"), 1)
+		data = bytes.Replace(data, []byte(">Dashboard</span>"), []byte(">Observer (read-only)</span>"), 1)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(data)
+	})
+	api.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "observers are read-only", http.StatusForbidden)
+			return
+		}
+		http.NotFound(w, r)
+	})
+	inner := http.StripPrefix(strings.TrimSuffix(ObserverPath, "/"), api)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := r.URL.Query().Get("token")
+		if given == "" {
+			given, _ = strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+		if given == "" {
+			if c, err := r.Cookie(observerCookie); err == nil {
+				given = c.Value
+			}
+		}
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			http.Error(w, "observer token required", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Has("token") {
+			http.SetCookie(w, &http.Cookie{
+				Name:     observerCookie,
+				Value:    token,
+				Path:     ObserverPath,
+				HttpOnly: true,
+				Secure:   true,
+				SameSite: http.SameSiteStrictMode,
+			})
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		inner.ServeHTTP(w, r)
+	})
+}
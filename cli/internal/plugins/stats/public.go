@@ -0,0 +1,113 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+// PublicStatsPath serves aggregate stats on the public tunnel (-public-stats).
+// Only counters are exposed: no paths, headers, bodies or client addresses.
+const PublicStatsPath = hooks.ReservedPrefix + "stats"
+
+// publicStatsJSON is the sanitized view of one tunnel.
+type publicStatsJSON struct {
+	Subdomain     string         `json:"subdomain"`
+	TotalRequests int            `json:"total_requests"`
+	ErrorCount    int            `json:"error_count"`
+	AvgLatency    float64        `json:"avg_latency"`
+	MaxLatency    float64        `json:"max_latency"`
+	TotalBytesIn  int            `json:"total_bytes_in"`
+	TotalBytesOut int            `json:"total_bytes_out"`
+	ConnectedAt   int64          `json:"connected_at"`
+	StatusClasses map[string]int `json:"status_classes"`
+}
+
+var publicStatsPage = template.Must(template.New("stats").Parse(`<!doctype html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width,initial-scale=1">
+<title>{{.Subdomain}} · stats</title>
+<style>body{font:14px/1.5 system-ui,sans-serif;max-width:520px;margin:40px auto;padding:0 16px;color:#222}
+table{border-collapse:collapse;width:100%}td{padding:6px 0;border-bottom:1px solid #eee}td+td{text-align:right}</style>
+</head><body>
+<h1>{{.Subdomain}}</h1>
+<table>
+<tr><td>Requests</td><td>{{.TotalRequests}}</td></tr>
+<tr><td>Errors (4xx/5xx)</td><td>{{.ErrorCount}}</td></tr>
+<tr><td>Avg latency</td><td>{{printf "%.0f" .AvgLatency}} ms</td></tr>
+<tr><td>Max latency</td><td>{{printf "%.0f" .MaxLatency}} ms</td></tr>
+<tr><td>Bytes in / out</td><td>{{.TotalBytesIn}} / {{.TotalBytesOut}}</td></tr>
+{{range .Classes}}<tr><td>{{.}}</td><td>{{index $.StatusClasses .}}</td></tr>
+{{end}}</table>
+<p><small>Connected {{.Since}}. Aggregate counters only.</small></p>
+</body></html>`))
+
+type publicStatsHandler struct {
+	store *Store
+}
+
+func (h *publicStatsHandler) ServePath(ctx *hooks.RequestContext, req types.TunnelRequest) (types.TunnelResponse, bool) {
+	path, query, _ := strings.Cut(req.Path, "?")
+	if path != PublicStatsPath {
+		return types.TunnelResponse{}, false
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return hooks.TextResponse(http.StatusMethodNotAllowed, "method not allowed"), true
+	}
+
+	var ts *TunnelStats
+	for _, t := range h.store.Snapshot() {
+		if t.Subdomain == ctx.Subdomain {
+			ts = &t
+			break
+		}
+	}
+	if ts == nil {
+		return hooks.TextResponse(http.StatusNotFound, "no stats for this tunnel"), true
+	}
+
+	out := publicStatsJSON{
+		Subdomain:     ts.Subdomain,
+		TotalRequests: ts.TotalRequests,
+		ErrorCount:    ts.ErrorCount,
+		MaxLatency:    float64(ts.MaxLatency.Milliseconds()),
+		TotalBytesIn:  ts.TotalBytesIn,
+		TotalBytesOut: ts.TotalBytesOut,
+		ConnectedAt:   ts.ConnectedAt.Unix(),
+		StatusClasses: h.store.StatusClasses(ctx.Subdomain),
+	}
+	if ts.TotalRequests > 0 {
+		out.AvgLatency = float64(ts.TotalLatency.Milliseconds()) / float64(ts.TotalRequests)
+	}
+
+	headers := map[string][]string{"Cache-Control": {"no-store"}}
+	var buf bytes.Buffer
+	if query == "format=json" {
+		headers["Content-Type"] = []string{"application/json"}
+		json.NewEncoder(&buf).Encode(out)
+	} else {
+		classes := make([]string, 0, len(out.StatusClasses))
+		for c := range out.StatusClasses {
+			classes = append(classes, c)
+		}
+		sort.Strings(classes)
+		headers["Content-Type"] = []string{"text/html; charset=utf-8"}
+		publicStatsPage.Execute(&buf, struct {
+			publicStatsJSON
+			Classes []string
+			Since   string
+		}{out, classes, ts.ConnectedAt.UTC().Format(time.RFC1123)})
+	}
+	return types.TunnelResponse{
+		Status:  http.StatusOK,
+		Headers: headers,
+		Body:    base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}, true
+}
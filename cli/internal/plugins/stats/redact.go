@@ -0,0 +1,204 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// redacted replaces secret values in the request log.
+const redacted = "[REDACTED]"
+
+// Redaction decides which secrets are masked in the request log before an
+// entry is stored, so they never reach the dashboard, the mmap buffer or
+// -log-db.
+type Redaction struct {
+	Headers  []string         // canonical header names whose values are masked
+	Fields   []string         // JSON field name globs (path.Match, case-insensitive) whose values are masked
+	Patterns []*regexp.Regexp // masked wherever they match in paths, header values and bodies
+}
+
+// defaultRedaction masks credentials that are almost never useful to read
+// back from the log.
+var defaultRedaction = Redaction{
+	Headers: []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"},
+	Fields:  []string{"password", "passwd", "secret", "client_secret"},
+}
+
+// redactFlag is the -redact flag.Value. Each use adds comma-separated rules
+// to the defaults:
+//
+//	header:X-Api-Key     mask a header
+//	json:*token*         mask JSON fields whose name matches a glob
+//	regex:sk_live_\w+    mask matches anywhere
+//
+// A regex: rule runs to the end of the value, commas included, so it goes
+// last or in its own -redact. "none" drops the defaults.
+type redactFlag struct {
+	r   Redaction
+	set []string
+}
+
+func newRedactFlag() *redactFlag {
+	return &redactFlag{r: Redaction{
+		Headers: slices.Clone(defaultRedaction.Headers),
+		Fields:  slices.Clone(defaultRedaction.Fields),
+	}}
+}
+
+func (f *redactFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return strings.Join(f.set, ",")
+}
+
+func (f *redactFlag) Set(s string) error {
+	f.set = append(f.set, s)
+	for rest := s; rest != ""; {
+		var rule string
+		if strings.HasPrefix(strings.TrimSpace(rest), "regex:") {
+			rule, rest = strings.TrimSpace(rest), ""
+		} else {
+			rule, rest, _ = strings.Cut(rest, ",")
+			rule = strings.TrimSpace(rule)
+		}
+		kind, value, _ := strings.Cut(rule, ":")
+		switch {
+		case rule == "":
+		case rule == "none":
+			f.r = Redaction{}
+		case kind == "header" && value != "":
+			f.r.Headers = append(f.r.Headers, http.CanonicalHeaderKey(value))
+		case kind == "json" && value != "":
+			if _, err := path.Match(value, ""); err != nil {
+				return fmt.Errorf("bad json field pattern %q", value)
+			}
+			f.r.Fields = append(f.r.Fields, value)
+		case kind == "regex" && value != "":
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return err
+			}
+			f.r.Patterns = append(f.r.Patterns, re)
+		default:
+			return fmt.Errorf("invalid rule %q (use header:Name, json:field-glob, regex:expr or none)", rule)
+		}
+	}
+	return nil
+}
+
+// empty reports whether r masks nothing.
+func (r Redaction) empty() bool {
+	return len(r.Headers) == 0 && len(r.Fields) == 0 && len(r.Patterns) == 0
+}
+
+// apply masks the secrets in entry. Header maps are copied, since they are
+// shared with the live request.
+func (r Redaction) apply(entry *RequestEntry) {
+	if r.empty() {
+		return
+	}
+	entry.Path = r.text(entry.Path)
+	entry.RequestHeaders = r.headers(entry.RequestHeaders)
+	entry.ResponseHeaders = r.headers(entry.ResponseHeaders)
+	entry.RequestBody = r.body(entry.RequestBody)
+	entry.ResponseBody = r.body(entry.ResponseBody)
+}
+
+func (r Redaction) headers(h map[string][]string) map[string][]string {
+	if h == nil {
+		return nil
+	}
+	out := make(map[string][]string, len(h))
+	for k, vs := range h {
+		if slices.Contains(r.Headers, http.CanonicalHeaderKey(k)) {
+			out[k] = []string{redacted}
+			continue
+		}
+		masked := make([]string, len(vs))
+		for i, v := range vs {
+			masked[i] = r.text(v)
+		}
+		out[k] = masked
+	}
+	return out
+}
+
+// body masks JSON fields (if the body is JSON) and patterns.
+func (r Redaction) body(s string) string {
+	if s == "" {
+		return s
+	}
+	if len(r.Fields) > 0 {
+		if trimmed := strings.TrimSpace(s); strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+			s = r.json(s)
+		}
+	}
+	return r.text(s)
+}
+
+// json masks matching fields anywhere in a JSON document. Bodies that don't
+// parse, or have nothing to mask, are returned as is.
+func (r Redaction) json(s string) string {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return s
+	}
+	if !r.maskFields(v) {
+		return s
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return s
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func (r Redaction) maskFields(v any) bool {
+	masked := false
+	switch node := v.(type) {
+	case map[string]any:
+		for k, val := range node {
+			if r.field(k) {
+				node[k] = redacted
+				masked = true
+			} else if r.maskFields(val) {
+				masked = true
+			}
+		}
+	case []any:
+		for _, val := range node {
+			if r.maskFields(val) {
+				masked = true
+			}
+		}
+	}
+	return masked
+}
+
+func (r Redaction) field(name string) bool {
+	name = strings.ToLower(name)
+	for _, pat := range r.Fields {
+		if ok, _ := path.Match(strings.ToLower(pat), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Redaction) text(s string) string {
+	for _, re := range r.Patterns {
+		s = re.ReplaceAllLiteralString(s, redacted)
+	}
+	return s
+}
@@ -0,0 +1,65 @@
+package stats
+
+// logRing holds the most recent request entries. Implementations are not
+// safe for concurrent use; Store serializes access.
+type logRing interface {
+	push(e RequestEntry)
+	// recent returns up to n of the newest entries, oldest first.
+	recent(n int) []RequestEntry
+	find(id int) (RequestEntry, bool)
+	// scan calls fn with entries newest first until it returns false.
+	scan(fn func(RequestEntry) bool)
+	// statuses calls fn with the subdomain and status of every entry.
+	statuses(fn func(subdomain string, status int))
+	close() error
+}
+
+// sliceRing is the default in-memory log: a slice of full entries.
+type sliceRing struct {
+	max  int
+	logs []RequestEntry
+}
+
+func newSliceRing(max int) *sliceRing {
+	return &sliceRing{max: max}
+}
+
+func (r *sliceRing) push(e RequestEntry) {
+	if len(r.logs) >= r.max {
+		r.logs = append(r.logs[1:], e)
+	} else {
+		r.logs = append(r.logs, e)
+	}
+}
+
+func (r *sliceRing) recent(n int) []RequestEntry {
+	n = min(n, len(r.logs))
+	out := make([]RequestEntry, n)
+	copy(out, r.logs[len(r.logs)-n:])
+	return out
+}
+
+func (r *sliceRing) find(id int) (RequestEntry, bool) {
+	for i := len(r.logs) - 1; i >= 0; i-- {
+		if r.logs[i].ID == id {
+			return r.logs[i], true
+		}
+	}
+	return RequestEntry{}, false
+}
+
+func (r *sliceRing) scan(fn func(RequestEntry) bool) {
+	for i := len(r.logs) - 1; i >= 0; i-- {
+		if !fn(r.logs[i]) {
+			return
+		}
+	}
+}
+
+func (r *sliceRing) statuses(fn func(string, int)) {
+	for _, e := range r.logs {
+		fn(e.Subdomain, e.Status)
+	}
+}
+
+func (r *sliceRing) close() error { return nil }
@@ -0,0 +1,49 @@
+package stats
+
+import (
+	"math/rand/v2"
+	"path"
+	"strings"
+)
+
+// CapturePolicy decides which requests get their bodies stored. Metadata
+// (method, path, status, sizes, headers) is always logged.
+type CapturePolicy struct {
+	Rate   float64  // fraction of requests whose bodies are kept, 0..1
+	Errors bool     // always keep bodies of 4xx/5xx responses
+	Paths  []string // always keep bodies for these path globs (path.Match, query ignored)
+}
+
+// captureAll is the default: every body is kept.
+var captureAll = CapturePolicy{Rate: 1}
+
+// Capture reports whether bodies should be stored for a request to reqPath
+// that got status.
+func (p CapturePolicy) Capture(reqPath string, status int) bool {
+	if p.Rate >= 1 {
+		return true
+	}
+	if p.Errors && status >= 400 {
+		return true
+	}
+	if len(p.Paths) > 0 {
+		reqPath, _, _ = strings.Cut(reqPath, "?")
+		for _, pat := range p.Paths {
+			if ok, _ := path.Match(pat, reqPath); ok {
+				return true
+			}
+		}
+	}
+	return p.Rate > 0 && rand.Float64() < p.Rate
+}
+
+// parsePathGlobs splits a comma-separated -capture-paths value.
+func parsePathGlobs(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
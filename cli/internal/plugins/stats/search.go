@@ -0,0 +1,166 @@
+package stats
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RequestFilter selects logged requests. Zero fields match everything.
+type RequestFilter struct {
+	Subdomain  string
+	Methods    []string // matched case-insensitively
+	Statuses   []string // exact codes ("404") or classes ("5xx")
+	PathPrefix string
+	PathRegex  *regexp.Regexp
+	MinLatency time.Duration
+	Since      time.Time
+	Until      time.Time
+	// Before is a cursor: only entries with a lower ID match.
+	Before int
+}
+
+// Match reports whether e passes every set condition.
+func (f RequestFilter) Match(e RequestEntry) bool {
+	if f.Before > 0 && e.ID >= f.Before {
+		return false
+	}
+	if f.Subdomain != "" && e.Subdomain != f.Subdomain {
+		return false
+	}
+	if len(f.Methods) > 0 && !containsFold(f.Methods, e.Method) {
+		return false
+	}
+	if len(f.Statuses) > 0 && !matchStatus(f.Statuses, e.Status) {
+		return false
+	}
+	p, _, _ := strings.Cut(e.Path, "?")
+	if f.PathPrefix != "" && !strings.HasPrefix(p, f.PathPrefix) {
+		return false
+	}
+	if f.PathRegex != nil && !f.PathRegex.MatchString(e.Path) {
+		return false
+	}
+	if e.Latency < f.MinLatency {
+		return false
+	}
+	if !f.Since.IsZero() && e.Timestamp.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.Timestamp.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchStatus(patterns []string, status int) bool {
+	code := strconv.Itoa(status)
+	for _, p := range patterns {
+		if class, ok := strings.CutSuffix(strings.ToLower(p), "xx"); ok {
+			if len(class) == 1 && strings.HasPrefix(code, class) && len(code) == 3 {
+				return true
+			}
+		} else if p == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Search returns up to limit matching entries from the in-memory log, newest
+// first. next is the cursor for the following page (pass it as Before), or 0
+// when there are no older matches.
+func (s *Store) Search(f RequestFilter, limit int) (entries []RequestEntry, next int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.logs.scan(func(e RequestEntry) bool {
+		if !f.Match(e) {
+			return true
+		}
+		if len(entries) == limit {
+			next = entries[limit-1].ID
+			return false
+		}
+		entries = append(entries, e)
+		return true
+	})
+	return entries, next
+}
+
+// parseRequestFilter reads a RequestFilter from query parameters:
+// subdomain, method, status (comma-separated lists), path (prefix),
+// path_regex, min_latency (ms), since/until (unix seconds) and cursor.
+func parseRequestFilter(q url.Values) (RequestFilter, error) {
+	f := RequestFilter{
+		Subdomain:  q.Get("subdomain"),
+		Methods:    splitParam(q.Get("method")),
+		Statuses:   splitParam(q.Get("status")),
+		PathPrefix: q.Get("path"),
+	}
+	for _, st := range f.Statuses {
+		if !validStatus(st) {
+			return f, fmt.Errorf("invalid status %q (use e.g. 404 or 5xx)", st)
+		}
+	}
+	if v := q.Get("path_regex"); v != "" {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return f, fmt.Errorf("invalid path_regex: %v", err)
+		}
+		f.PathRegex = re
+	}
+	if v := q.Get("min_latency"); v != "" {
+		ms, err := strconv.ParseFloat(v, 64)
+		if err != nil || ms < 0 {
+			return f, fmt.Errorf("invalid min_latency %q (milliseconds)", v)
+		}
+		f.MinLatency = time.Duration(ms * float64(time.Millisecond))
+	}
+	for name, t := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return f, fmt.Errorf("invalid %s %q (unix seconds)", name, v)
+			}
+			*t = time.Unix(n, 0)
+		}
+	}
+	if v := q.Get("cursor"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return f, fmt.Errorf("invalid cursor %q", v)
+		}
+		f.Before = n
+	}
+	return f, nil
+}
+
+func splitParam(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func validStatus(s string) bool {
+	if class, ok := strings.CutSuffix(strings.ToLower(s), "xx"); ok {
+		return len(class) == 1 && class[0] >= '1' && class[0] <= '5'
+	}
+	n, err := strconv.Atoi(s)
+	return err == nil && n >= 100 && n <= 599
+}
@@ -1,113 +1,227 @@
 package stats
 
 import (
+	"bytes"
+	"cmp"
+	"crypto/rand"
+	"crypto/subtle"
 	"embed"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/audit"
+	"github.com/QuadTriangle/prod.bd/cli/internal/config"
+	"github.com/QuadTriangle/prod.bd/cli/internal/failpoint"
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/notes"
+	"github.com/QuadTriangle/prod.bd/cli/internal/protobuf"
+	"github.com/QuadTriangle/prod.bd/cli/internal/proxy"
+	"github.com/QuadTriangle/prod.bd/cli/internal/scenario"
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+	"github.com/QuadTriangle/prod.bd/cli/statsapi"
 )
 
+// processStart approximates when the CLI started, for the runtime endpoint.
+var processStart = time.Now()
+
 //go:embed index.html
 var dashboardHTML embed.FS
 
-// JSON response types matching what the dashboard expects
-
-type tunnelJSON struct {
-	Subdomain     string  `json:"subdomain"`
-	Port          int     `json:"port"`
-	TotalRequests int     `json:"total_requests"`
-	ErrorCount    int     `json:"error_count"`
-	AvgLatency    float64 `json:"avg_latency"`
-	MaxLatency    float64 `json:"max_latency"`
-	MinLatency    float64 `json:"min_latency"`
-	TotalBytesIn  int     `json:"total_bytes_in"`
-	TotalBytesOut int     `json:"total_bytes_out"`
-	ConnectedAt   int64   `json:"connected_at"`
-}
-
-type requestJSON struct {
-	ID              int                 `json:"id"`
-	Subdomain       string              `json:"subdomain"`
-	Method          string              `json:"method"`
-	Path            string              `json:"path"`
-	Status          int                 `json:"status"`
-	LatencyMs       float64             `json:"latency_ms"`
-	BytesIn         int                 `json:"bytes_in"`
-	BytesOut        int                 `json:"bytes_out"`
-	CreatedAt       int64               `json:"created_at"`
-	RequestHeaders  map[string][]string `json:"request_headers,omitempty"`
-	RequestBody     string              `json:"request_body,omitempty"`
-	ResponseHeaders map[string][]string `json:"response_headers,omitempty"`
-	ResponseBody    string              `json:"response_body,omitempty"`
-}
-
-type summaryJSON struct {
-	ActiveTunnels int     `json:"active_tunnels"`
-	TotalRequests int     `json:"total_requests"`
-	TotalErrors   int     `json:"total_errors"`
-	AvgLatency    float64 `json:"avg_latency"`
-	TotalBytesIn  int     `json:"total_bytes_in"`
-	TotalBytesOut int     `json:"total_bytes_out"`
-}
-
-// Server serves the stats API locally for the dashboard to connect to.
+// JSON response types, shared with the Go client in statsapi
+type (
+	tunnelJSON         = statsapi.Tunnel
+	targetHealthJSON   = statsapi.TargetHealth
+	requestJSON        = statsapi.Request
+	pluginOverheadJSON = statsapi.PluginOverhead
+	feedbackJSON       = statsapi.Feedback
+	summaryJSON        = statsapi.Summary
+	pathStatsJSON      = statsapi.PathStats
+)
+
+// Server serves the stats API locally for the dashboard to connect to, and
+// on a control socket for prod status.
 type Server struct {
-	store    *Store
-	listener net.Listener
+	store     *Store
+	token     string       // wanted in statsapi.TokenHeader on the dashboard port
+	notes     *notes.Store // nil if the notes file couldn't be loaded
+	overhead  func() []hooks.PluginOverhead
+	links     Links // nil without a link provider
+	handler   http.Handler
+	listener  net.Listener
+	control   net.Listener
+	servers   []*http.Server
+	closeOnce sync.Once
 }
 
-// StartServer starts the local stats HTTP server on the given port.
-// Returns the server and the actual address it's listening on.
-func StartServer(store *Store, port int) (*Server, error) {
+// ServerOptions are what the API serves besides the store. They are fixed
+// once the server is built, as handlers read them without locking.
+type ServerOptions struct {
+	Overhead func() []hooks.PluginOverhead // nil without hook timings
+	Links    Links                         // nil without a link provider
+}
+
+// NewServer builds the dashboard and API handler. It serves nothing until
+// Listen or ListenControl.
+func NewServer(store *Store, opts ServerOptions) *Server {
 	mux := http.NewServeMux()
-	s := &Server{store: store}
+	s := &Server{store: store, token: newToken(), overhead: opts.Overhead, links: opts.Links}
+	if ns, err := notes.Open(); err != nil {
+		log.Printf("[stats] session notes disabled: %v", err)
+	} else {
+		s.notes = ns
+	}
 
-	mux.HandleFunc("/api/stats/tunnels", s.handleTunnels)
-	mux.HandleFunc("/api/stats/requests", s.handleRequests)
-	mux.HandleFunc("/api/stats/summary", s.handleSummary)
+	s.registerAPI(mux)
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		data, _ := dashboardHTML.ReadFile("index.html")
+		data = bytes.Replace(data, []byte("const TOKEN = '';"), []byte("const TOKEN = '"+s.token+"';"), 1)
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		w.Write(data)
 	})
+	s.handler = mux
+	return s
+}
 
+// StartServer starts the local stats HTTP server on the given port.
+// Returns the server and the actual address it's listening on.
+func StartServer(store *Store, port int, opts ServerOptions) (*Server, error) {
+	s := NewServer(store, opts)
+	if err := s.Listen(port); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Listen serves the dashboard on 127.0.0.1:port.
+func (s *Server) Listen(port int) error {
 	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
 	if err != nil {
-		return nil, err
+		return err
 	}
 	s.listener = ln
+	s.serve(ln, s.localOnly(s.handler))
+	return nil
+}
 
-	srv := &http.Server{Handler: corsMiddleware(mux)}
+// ListenControl serves the API on a unix socket at path, replacing a stale
+// one a crashed process left behind. The directory is private to the user.
+func (s *Server) ListenControl(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	os.Remove(path)
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	s.control = ln
+	s.serve(ln, s.handler)
+	return nil
+}
+
+func (s *Server) serve(ln net.Listener, h http.Handler) {
+	srv := &http.Server{Handler: h}
+	s.servers = append(s.servers, srv)
 	go func() {
 		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
 			log.Printf("[stats] server error: %v", err)
 		}
 	}()
-
-	return s, nil
 }
 
+// Addr is the dashboard's address, or "" if it isn't listening.
 func (s *Server) Addr() string {
+	if s.listener == nil {
+		return ""
+	}
 	return s.listener.Addr().String()
 }
 
-func corsMiddleware(next http.Handler) http.Handler {
+// Close stops serving and removes the control socket.
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		for _, srv := range s.servers {
+			srv.Close()
+		}
+		if s.control != nil {
+			os.Remove(s.control.Addr().String())
+		}
+	})
+}
+
+// newToken returns a random 128-bit token.
+func newToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// localOnly guards the dashboard port from other websites. The Host must
+// be the dashboard itself, which a DNS-rebound name isn't, and requests a
+// browser marks as coming from another origin are refused. No CORS headers
+// are sent, so pages elsewhere can't read responses either. Requests that
+// change anything must also carry the server's token (see checkToken). The
+// control socket needs none of this: only the user can reach it.
+func (s *Server) localOnly(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusNoContent)
+		if !dashboardHost(r) {
+			http.Error(w, "unexpected Host "+strconv.Quote(r.Host), http.StatusMisdirectedRequest)
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" && origin != "http://"+r.Host {
+			http.Error(w, "cross-origin requests are refused", http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && !s.checkToken(w, r) {
 			return
 		}
 		next.ServeHTTP(w, r)
 	})
 }
 
+// checkToken reports whether a request to the dashboard port carries the
+// server's token, which only the dashboard page and local clients can read,
+// answering it with 403 if not. Requests on the control socket pass.
+func (s *Server) checkToken(w http.ResponseWriter, r *http.Request) bool {
+	if _, ok := r.Context().Value(http.LocalAddrContextKey).(*net.TCPAddr); !ok {
+		return true
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(statsapi.TokenHeader)), []byte(s.token)) != 1 {
+		http.Error(w, statsapi.TokenHeader+" required; read it from GET "+statsapi.Prefix+"/token", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// dashboardHost reports whether r is addressed to the loopback port it
+// arrived on.
+func dashboardHost(r *http.Request) bool {
+	host, port, err := net.SplitHostPort(r.Host)
+	if err != nil || (host != "127.0.0.1" && host != "localhost") {
+		return false
+	}
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if !ok {
+		return false
+	}
+	_, local, _ := net.SplitHostPort(addr.String())
+	return port == local
+}
+
 func writeJSON(w http.ResponseWriter, v any) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(v)
@@ -115,6 +229,7 @@ func writeJSON(w http.ResponseWriter, v any) {
 
 func (s *Server) handleTunnels(w http.ResponseWriter, r *http.Request) {
 	snap := s.store.Snapshot()
+	costPerGB := s.store.CostPerGB()
 	tunnels := make([]tunnelJSON, 0, len(snap))
 	for _, ts := range snap {
 		avg := float64(0)
@@ -125,22 +240,70 @@ func (s *Server) handleTunnels(w http.ResponseWriter, r *http.Request) {
 		if ts.MinLatency < time.Duration(1<<63-1) {
 			minLat = float64(ts.MinLatency.Milliseconds())
 		}
-		tunnels = append(tunnels, tunnelJSON{
-			Subdomain:     ts.Subdomain,
-			Port:          ts.Port,
-			TotalRequests: ts.TotalRequests,
-			ErrorCount:    ts.ErrorCount,
-			AvgLatency:    avg,
-			MaxLatency:    float64(ts.MaxLatency.Milliseconds()),
-			MinLatency:    minLat,
-			TotalBytesIn:  ts.TotalBytesIn,
-			TotalBytesOut: ts.TotalBytesOut,
-			ConnectedAt:   ts.ConnectedAt.Unix(),
-		})
+		var health *targetHealthJSON
+		if h := ts.TargetHealth; h != nil {
+			health = &targetHealthJSON{
+				Healthy:   h.Healthy,
+				Status:    h.Status,
+				LatencyMs: float64(h.Latency.Milliseconds()),
+				CheckedAt: h.Checked.Unix(),
+			}
+			if h.Err != nil {
+				health.Error = h.Err.Error()
+			}
+		}
+		t := tunnelJSON{
+			Subdomain:        ts.Subdomain,
+			Port:             ts.Port,
+			Label:            ts.Label,
+			Region:           ts.Region,
+			Group:            ts.Group,
+			TotalRequests:    ts.TotalRequests,
+			ErrorCount:       ts.ErrorCount,
+			AvgLatency:       avg,
+			MaxLatency:       float64(ts.MaxLatency.Milliseconds()),
+			MinLatency:       minLat,
+			P50Latency:       latencyMs(ts.Latencies.Percentile(50)),
+			P90Latency:       latencyMs(ts.Latencies.Percentile(90)),
+			P99Latency:       latencyMs(ts.Latencies.Percentile(99)),
+			TotalBytesIn:     ts.TotalBytesIn,
+			TotalBytesOut:    ts.TotalBytesOut,
+			ConnectedAt:      ts.ConnectedAt.Unix(),
+			ProtocolErrors:   ts.ProtocolErrors,
+			SchemaViolations: ts.SchemaViolations,
+			BaselineMatched:  ts.BaselineMatched,
+			BaselineDiverged: ts.BaselineDiverged,
+			Queued:           ts.Queued,
+			MaxQueued:        ts.MaxQueued,
+			QueueRejected:    ts.QueueRejected,
+			TargetHealth:     health,
+			FirstConnectedAt: ts.FirstConnectedAt.Unix(),
+			Reconnects:       ts.Reconnects,
+			Connections:      connEventsJSON(ts.Connections),
+			WorkerConfigKeys: ts.WorkerConfigKeys,
+		}
+		if !ts.ConfigUpdatedAt.IsZero() {
+			t.ConfigUpdatedAt = ts.ConfigUpdatedAt.Unix()
+		}
+		u := s.store.Usage(ts.Subdomain)
+		t.WireBytesSent, t.WireBytesReceived, t.CostEstimate = u.Sent, u.Received, u.Cost(costPerGB)
+		tunnels = append(tunnels, t)
 	}
 	writeJSON(w, map[string]any{"tunnels": tunnels})
 }
 
+func connEventsJSON(events []ConnEvent) []statsapi.ConnEvent {
+	out := make([]statsapi.ConnEvent, len(events))
+	for i, e := range events {
+		out[i] = statsapi.ConnEvent{Time: e.Time.Unix(), Connected: e.Connected, Error: e.Error}
+	}
+	return out
+}
+
+// handleRequests lists logged requests newest first. Filters: subdomain,
+// method, status (e.g. 404 or 5xx), path (prefix), path_regex, min_latency
+// (ms), since/until (unix seconds). Pages with limit (default 100, max 500)
+// and cursor, taken from the previous page's next_cursor.
 func (s *Server) handleRequests(w http.ResponseWriter, r *http.Request) {
 	limitStr := r.URL.Query().Get("limit")
 	limit := 100
@@ -151,31 +314,76 @@ func (s *Server) handleRequests(w http.ResponseWriter, r *http.Request) {
 		limit = 500
 	}
 
-	subdomain := r.URL.Query().Get("subdomain")
-	entries := s.store.RecentLogs(limit)
+	filter, err := parseRequestFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entries, next := s.store.Search(filter, limit)
 
-	// Filter by subdomain if provided
 	reqs := make([]requestJSON, 0, len(entries))
-	for i := len(entries) - 1; i >= 0; i-- {
-		e := entries[i]
-		if subdomain != "" && e.Subdomain != subdomain {
-			continue
-		}
-		reqs = append(reqs, requestJSON{
-			ID:              e.ID,
-			Subdomain:       e.Subdomain,
-			Method:          e.Method,
-			Path:            e.Path,
-			Status:          e.Status,
-			LatencyMs:       float64(e.Latency.Milliseconds()),
-			BytesIn:         e.BytesIn,
-			BytesOut:        e.BytesOut,
-			CreatedAt:       e.Timestamp.Unix(),
-			RequestHeaders:  e.RequestHeaders,
-			RequestBody:     e.RequestBody,
-			ResponseHeaders: e.ResponseHeaders,
-			ResponseBody:    e.ResponseBody,
-		})
+	for _, e := range entries {
+		reqs = append(reqs, toRequestJSON(e))
+	}
+	writeJSON(w, statsapi.RequestPage{Requests: reqs, NextCursor: next})
+}
+
+func toRequestJSON(e RequestEntry) requestJSON {
+	reqDecoded, _, _ := protobuf.DecodeBody(e.Path, e.RequestHeaders, []byte(e.RequestBody), false)
+	respDecoded, _, _ := protobuf.DecodeBody(e.Path, e.ResponseHeaders, []byte(e.ResponseBody), true)
+	return requestJSON{
+		ID:              e.ID,
+		Subdomain:       e.Subdomain,
+		Method:          e.Method,
+		Path:            e.Path,
+		Status:          e.Status,
+		LatencyMs:       float64(e.Latency.Milliseconds()),
+		BytesIn:         e.BytesIn,
+		BytesOut:        e.BytesOut,
+		CreatedAt:       e.Timestamp.Unix(),
+		RequestHeaders:  e.RequestHeaders,
+		RequestBody:     e.RequestBody,
+		ResponseHeaders: e.ResponseHeaders,
+		ResponseBody:    e.ResponseBody,
+		ReplayOf:        e.ReplayOf,
+
+		RequestBodyDecoded:  reqDecoded,
+		ResponseBodyDecoded: respDecoded,
+		Curl:                curlCommand(e, config.PublicURL(e.Subdomain)),
+	}
+}
+
+// handleHistory queries the persistent request log across sessions.
+// Filters: subdomain, since/until (unix seconds), limit (default 100, max 1000).
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	db := s.store.LogDB()
+	if db == nil {
+		http.Error(w, "request history disabled; start with -log-db", http.StatusServiceUnavailable)
+		return
+	}
+
+	q := HistoryQuery{Subdomain: r.URL.Query().Get("subdomain"), Limit: 100}
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 {
+		q.Limit = min(n, 1000)
+	}
+	if n, err := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64); err == nil {
+		q.Since = time.Unix(n, 0)
+	}
+	if n, err := strconv.ParseInt(r.URL.Query().Get("until"), 10, 64); err == nil {
+		q.Until = time.Unix(n, 0)
+	}
+
+	entries, err := db.Query(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	reqs := make([]requestJSON, 0, len(entries))
+	for _, h := range entries {
+		rj := toRequestJSON(h.Entry)
+		rj.ID = int(h.ID)
+		rj.Session = h.Session
+		reqs = append(reqs, rj)
 	}
 	writeJSON(w, map[string]any{"requests": reqs})
 }
@@ -184,16 +392,476 @@ func (s *Server) handleSummary(w http.ResponseWriter, r *http.Request) {
 	snap := s.store.Snapshot()
 	var sum summaryJSON
 	sum.ActiveTunnels = len(snap)
+	sum.CostPerGB = s.store.CostPerGB()
 	var totalLatency int64
+	var latencies Histogram
 	for _, ts := range snap {
+		latencies.Merge(&ts.Latencies)
 		sum.TotalRequests += ts.TotalRequests
 		sum.TotalErrors += ts.ErrorCount
 		sum.TotalBytesIn += ts.TotalBytesIn
 		sum.TotalBytesOut += ts.TotalBytesOut
 		totalLatency += ts.TotalLatency.Milliseconds()
+		for _, n := range ts.ProtocolErrors {
+			sum.ProtocolErrors += n
+		}
+		sum.SchemaViolations += ts.SchemaViolations
+		u := s.store.Usage(ts.Subdomain)
+		sum.WireBytesSent += u.Sent
+		sum.WireBytesReceived += u.Received
+		sum.CostEstimate += u.Cost(sum.CostPerGB)
 	}
 	if sum.TotalRequests > 0 {
 		sum.AvgLatency = float64(totalLatency) / float64(sum.TotalRequests)
 	}
+	sum.P50Latency = latencyMs(latencies.Percentile(50))
+	sum.P90Latency = latencyMs(latencies.Percentile(90))
+	sum.P99Latency = latencyMs(latencies.Percentile(99))
 	writeJSON(w, map[string]any{"summary": sum})
 }
+
+// handlePaths reports latency and errors per endpoint, sorted by sort
+// (default p99), slowest or most first.
+func (s *Server) handlePaths(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := 100
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	paths := s.store.Paths(q.Get("subdomain"))
+	out := make([]pathStatsJSON, 0, len(paths))
+	for _, ps := range paths {
+		out = append(out, pathStatsJSON{
+			Subdomain:  ps.Subdomain,
+			Method:     ps.Method,
+			Path:       ps.Path,
+			Requests:   ps.Requests,
+			Errors:     ps.Errors,
+			AvgLatency: latencyMs(ps.TotalLatency / time.Duration(ps.Requests)),
+			MaxLatency: latencyMs(ps.MaxLatency),
+			P50Latency: latencyMs(ps.Latencies.Percentile(50)),
+			P90Latency: latencyMs(ps.Latencies.Percentile(90)),
+			P99Latency: latencyMs(ps.Latencies.Percentile(99)),
+		})
+	}
+	var key func(p pathStatsJSON) float64
+	switch q.Get("sort") {
+	case "", "p99":
+		key = func(p pathStatsJSON) float64 { return p.P99Latency }
+	case "p90":
+		key = func(p pathStatsJSON) float64 { return p.P90Latency }
+	case "p50":
+		key = func(p pathStatsJSON) float64 { return p.P50Latency }
+	case "avg":
+		key = func(p pathStatsJSON) float64 { return p.AvgLatency }
+	case "max":
+		key = func(p pathStatsJSON) float64 { return p.MaxLatency }
+	case "requests":
+		key = func(p pathStatsJSON) float64 { return float64(p.Requests) }
+	case "errors":
+		key = func(p pathStatsJSON) float64 { return float64(p.Errors) }
+	default:
+		http.Error(w, "sort must be p99, p90, p50, avg, max, requests or errors", http.StatusBadRequest)
+		return
+	}
+	slices.SortStableFunc(out, func(a, b pathStatsJSON) int { return cmp.Compare(key(b), key(a)) })
+	writeJSON(w, map[string]any{"paths": out[:min(limit, len(out))]})
+}
+
+// latencyMs converts d to fractional milliseconds.
+func latencyMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// handleAudits lists saved `prod audit` reports, optionally filtered by subdomain.
+func (s *Server) handleAudits(w http.ResponseWriter, r *http.Request) {
+	reports, err := audit.List(r.URL.Query().Get("subdomain"))
+	if errors.Is(err, audit.ErrInvalidSubdomain) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if reports == nil {
+		reports = []audit.Report{}
+	}
+	writeJSON(w, map[string]any{"audits": reports})
+}
+
+// replay re-sends logged request id and records the result. On failure it
+// returns the HTTP status and message to answer with.
+func (s *Server) replay(id int) (orig RequestEntry, newID int, status int, msg string) {
+	orig, ok := s.store.Entry(id)
+	if !ok {
+		return orig, 0, http.StatusNotFound, "request not found"
+	}
+	// Bodies over the storage cap aren't kept, so they can't be replayed faithfully
+	if orig.BytesIn > 0 && orig.RequestBody == "" {
+		return orig, 0, http.StatusUnprocessableEntity, "request body was too large to store; cannot replay"
+	}
+	port, ok := s.store.TunnelPort(orig.Subdomain)
+	if !ok {
+		return orig, 0, http.StatusConflict, "tunnel is not connected"
+	}
+
+	req := types.TunnelRequest{
+		Type:    types.TypeHTTPRequest,
+		ID:      fmt.Sprintf("replay-%d-%d", id, time.Now().UnixNano()),
+		Method:  orig.Method,
+		Path:    orig.Path,
+		Headers: orig.RequestHeaders,
+	}
+	if orig.RequestBody != "" {
+		req.Body = base64.StdEncoding.EncodeToString([]byte(orig.RequestBody))
+	}
+
+	start := time.Now()
+	resp := proxy.HandleRequest(req, port)
+	return orig, s.store.RecordReplay(id, orig.Subdomain, req, resp, time.Since(start)), 0, ""
+}
+
+// handleReplay re-sends a logged request to its tunnel's local port and
+// records the result as a new entry (replay_of = original ID).
+func (s *Server) handleReplay(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	_, newID, status, msg := s.replay(id)
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+	replayed, _ := s.store.Entry(newID)
+	writeJSON(w, statsapi.ReplayResult{ID: newID, ReplayOf: id, Status: replayed.Status})
+}
+
+// handleDiff replays a logged request and compares the response with the
+// original: status, headers (except those in ignore, default Date) and
+// body (JSON field by field, skipping $-paths in ignore).
+func (s *Server) handleDiff(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	ignore := defaultDiffIgnore
+	if r.URL.Query().Has("ignore") {
+		ignore = strings.Split(r.URL.Query().Get("ignore"), ",")
+	}
+	orig, newID, status, msg := s.replay(id)
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+	replayed, ok := s.store.Entry(newID)
+	if !ok {
+		http.Error(w, "replay was evicted from the log", http.StatusConflict)
+		return
+	}
+	d := diffEntries(orig, replayed, parseDiffIgnore(ignore))
+	d.ID, d.ReplayOf = newID, id
+	writeJSON(w, d)
+}
+
+// handleCurl renders a logged request as a curl command, against the public
+// URL or, with target=local, the local server the tunnel forwards to.
+func (s *Server) handleCurl(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	orig, ok := s.store.Entry(id)
+	if !ok {
+		http.Error(w, "request not found", http.StatusNotFound)
+		return
+	}
+	base := config.PublicURL(orig.Subdomain)
+	switch r.URL.Query().Get("target") {
+	case "", "public":
+	case "local":
+		port, ok := s.store.TunnelPort(orig.Subdomain)
+		if !ok {
+			http.Error(w, "tunnel is not connected", http.StatusConflict)
+			return
+		}
+		base = proxy.Target(port).String()
+	default:
+		http.Error(w, "target must be public or local", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, curlCommand(orig, base))
+}
+
+// handleScenario turns the logged requests matching the search filters into
+// a scenario file for `prod replay`, oldest first with the gaps between them
+// as think times. Replays and requests whose body wasn't stored are left out.
+func (s *Server) handleScenario(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseRequestFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entries, _ := s.store.Search(filter, 500)
+	reqs := make([]scenario.Captured, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if e.ReplayOf != 0 || (e.BytesIn > 0 && e.RequestBody == "") {
+			continue
+		}
+		reqs = append(reqs, scenario.Captured{Method: e.Method, Path: e.Path, Headers: e.RequestHeaders, Body: e.RequestBody, At: e.Timestamp})
+	}
+	if len(reqs) == 0 {
+		http.Error(w, "no logged requests match", http.StatusNotFound)
+		return
+	}
+	data, err := scenario.FromCaptured(reqs).Marshal()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(data)
+}
+
+// handleExport downloads the logged requests matching the search filters,
+// oldest first, as format=har (HAR 1.2, for browser devtools). limit
+// defaults to 1000, max 10000.
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	if format := r.URL.Query().Get("format"); format != "" && format != "har" {
+		http.Error(w, "unsupported format "+strconv.Quote(format)+" (use har)", http.StatusBadRequest)
+		return
+	}
+	limit := 1000
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 {
+		limit = min(n, 10000)
+	}
+	filter, err := parseRequestFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entries, _ := s.store.Search(filter, limit)
+	slices.Reverse(entries)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="prodbd.har"`)
+	json.NewEncoder(w).Encode(toHAR(entries))
+}
+
+// handleFeedback lists reviewer comments from the banner's feedback widget, newest first.
+func (s *Server) handleFeedback(w http.ResponseWriter, r *http.Request) {
+	subdomain := r.URL.Query().Get("subdomain")
+	entries := s.store.Feedback()
+	out := make([]feedbackJSON, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		f := entries[i]
+		if subdomain != "" && f.Subdomain != subdomain {
+			continue
+		}
+		out = append(out, feedbackJSON{
+			ID:        f.ID,
+			Subdomain: f.Subdomain,
+			Comment:   f.Comment,
+			URL:       f.URL,
+			UserAgent: f.UserAgent,
+			CreatedAt: f.Timestamp.Unix(),
+		})
+	}
+	writeJSON(w, map[string]any{"feedback": out})
+}
+
+// handlePlugins reports how much time each plugin's request hooks add per call.
+func (s *Server) handlePlugins(w http.ResponseWriter, r *http.Request) {
+	var snap []hooks.PluginOverhead
+	if s.overhead != nil {
+		snap = s.overhead()
+	}
+	plugins := make([]pluginOverheadJSON, 0, len(snap))
+	for _, o := range snap {
+		avg := float64(0)
+		if o.Calls > 0 {
+			avg = float64(o.Total.Microseconds()) / 1000 / float64(o.Calls)
+		}
+		plugins = append(plugins, pluginOverheadJSON{
+			Plugin: o.Plugin,
+			Calls:  o.Calls,
+			AvgMs:  avg,
+			MaxMs:  float64(o.Max.Microseconds()) / 1000,
+		})
+	}
+	writeJSON(w, map[string]any{"plugins": plugins})
+}
+
+// handleToken returns the token the dashboard port wants on requests that
+// change anything.
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprintln(w, s.token)
+}
+
+// handleRuntime reports process health (used by `prod soak` to spot leaks).
+func (s *Server) handleRuntime(w http.ResponseWriter, r *http.Request) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	writeJSON(w, statsapi.Runtime{
+		PID:           os.Getpid(),
+		Goroutines:    runtime.NumGoroutine(),
+		HeapAlloc:     m.HeapAlloc,
+		HeapInuse:     m.HeapInuse,
+		Sys:           m.Sys,
+		NumGC:         m.NumGC,
+		UptimeSeconds: int64(time.Since(processStart).Seconds()),
+		LowPower:      s.store.LowPower(),
+	})
+}
+
+// handleNotes lists (GET), adds (POST) or removes (DELETE ?id=) session notes.
+func (s *Server) handleNotes(w http.ResponseWriter, r *http.Request) {
+	if s.notes == nil {
+		http.Error(w, "session notes unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, map[string]any{"notes": s.notes.List(r.URL.Query().Get("subdomain"))})
+
+	case http.MethodPost:
+		var n notes.Note
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			http.Error(w, "invalid note: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		n, err := s.notes.Add(n)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, map[string]any{"note": n})
+
+	case http.MethodDelete:
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		ok, err := s.notes.Delete(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "note not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleBaseline lists responses that differed from the -baseline
+// recording.
+func (s *Server) handleBaseline(w http.ResponseWriter, r *http.Request) {
+	b := s.store.Baseline()
+	if b == nil {
+		http.Error(w, "no baseline; start the tunnel with -baseline <file>", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, map[string]any{"divergences": b.Divergences(r.URL.Query().Get("subdomain"))})
+}
+
+// handleLinks lists (GET), makes (POST) or revokes (DELETE ?id=) magic
+// links. On the dashboard port even listing them needs the token.
+func (s *Server) handleLinks(w http.ResponseWriter, r *http.Request) {
+	if !s.checkToken(w, r) {
+		return
+	}
+	if s.links == nil {
+		http.Error(w, "magic links unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	q := r.URL.Query()
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, map[string]any{"links": s.links.Links(q.Get("subdomain"))})
+
+	case http.MethodPost:
+		subdomain := q.Get("subdomain")
+		if subdomain == "" {
+			tunnels := s.store.Snapshot()
+			if len(tunnels) != 1 {
+				http.Error(w, fmt.Sprintf("%d tunnels connected; pick one with subdomain", len(tunnels)), http.StatusBadRequest)
+				return
+			}
+			subdomain = tunnels[0].Subdomain
+		}
+		var ttl time.Duration
+		if v := q.Get("ttl"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				http.Error(w, "invalid ttl", http.StatusBadRequest)
+				return
+			}
+			ttl = d
+		}
+		l, err := s.links.NewLink(subdomain, q.Get("email"), q.Get("path"), ttl)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, map[string]any{"link": l})
+
+	case http.MethodDelete:
+		if !s.links.RevokeLink(q.Get("id")) {
+			http.Error(w, "link not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleFailpoints lists armed failpoints (GET) or arms one (POST
+// {"name": "drop-frames", "value": 3}; value 0 disarms). Only registered in
+// builds with -tags failpoints.
+func (s *Server) handleFailpoints(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, map[string]any{"available": failpoint.Names, "armed": failpoint.List()})
+
+	case http.MethodPost:
+		var body struct {
+			Name  string `json:"name"`
+			Value int    `json:"value"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid failpoint: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := failpoint.Set(body.Name, body.Value); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("[stats] failpoint %s set to %d", body.Name, body.Value)
+		writeJSON(w, map[string]any{"armed": failpoint.List()})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,178 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/statsapi"
+)
+
+// startServer serves a fresh store on a free dashboard port and a control
+// socket, with notes and sockets under a temporary home.
+func startServer(t *testing.T) (*Server, string) {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	s := NewServer(NewStore(100), ServerOptions{})
+	if err := s.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	sock := filepath.Join(home, "prod.sock")
+	if err := s.ListenControl(sock); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s, sock
+}
+
+// send makes a request to the dashboard port with the given headers and
+// returns its status.
+func send(t *testing.T, s *Server, method, path string, header map[string]string) int {
+	t.Helper()
+	req, err := http.NewRequest(method, "http://"+s.Addr()+path, strings.NewReader(`{"text":"hi"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		if k == "Host" {
+			req.Host = v
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if v := resp.Header.Get("Access-Control-Allow-Origin"); v != "" {
+		t.Errorf("%s %s: Access-Control-Allow-Origin %q", method, path, v)
+	}
+	return resp.StatusCode
+}
+
+func TestDashboardRefusesOtherSites(t *testing.T) {
+	s, _ := startServer(t)
+	self := "http://" + s.Addr()
+	_, port, _ := strings.Cut(s.Addr(), ":")
+
+	tests := []struct {
+		name   string
+		method string
+		header map[string]string
+		status int
+	}{
+		{"same origin", "GET", map[string]string{"Origin": self}, http.StatusOK},
+		{"same origin posting", "POST", map[string]string{"Origin": self, statsapi.TokenHeader: s.token}, http.StatusOK},
+		{"no origin", "GET", nil, http.StatusOK},
+		{"localhost", "GET", map[string]string{"Host": "localhost:" + port}, http.StatusOK},
+		{"other site", "GET", map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{"other site posting", "POST", map[string]string{"Origin": "https://evil.example", "Content-Type": "text/plain"}, http.StatusForbidden},
+		{"sandboxed frame", "POST", map[string]string{"Origin": "null"}, http.StatusForbidden},
+		{"rebound name", "GET", map[string]string{"Host": "evil.example:" + port}, http.StatusMisdirectedRequest},
+		{"other port", "GET", map[string]string{"Host": "127.0.0.1:1"}, http.StatusMisdirectedRequest},
+	}
+	for _, tt := range tests {
+		if got := send(t, s, tt.method, "/api/v1/stats/notes", tt.header); got != tt.status {
+			t.Errorf("%s: %d, want %d", tt.name, got, tt.status)
+		}
+	}
+}
+
+func TestChangesNeedToken(t *testing.T) {
+	s, sock := startServer(t)
+
+	for _, path := range []string{"/api/v1/stats/notes", "/api/v1/stats/requests/1/replay", "/api/v1/stats/requests/1/diff"} {
+		if got := send(t, s, "POST", path, nil); got != http.StatusForbidden {
+			t.Errorf("POST %s without the token: %d", path, got)
+		}
+		if got := send(t, s, "POST", path, map[string]string{statsapi.TokenHeader: "guess"}); got != http.StatusForbidden {
+			t.Errorf("POST %s with a wrong token: %d", path, got)
+		}
+	}
+	if got := send(t, s, "DELETE", "/api/v1/stats/notes?id=1", nil); got != http.StatusForbidden {
+		t.Errorf("DELETE without the token: %d", got)
+	}
+
+	// The dashboard page carries it
+	resp, err := http.Get("http://" + s.Addr() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := new(strings.Builder)
+	_, _ = io.Copy(page, resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(page.String(), "const TOKEN = '"+s.token+"';") {
+		t.Error("dashboard page doesn't carry the token")
+	}
+
+	// The Go client fetches it on the dashboard port; the control socket needs none
+	_, port, _ := strings.Cut(s.Addr(), ":")
+	n, _ := strconv.Atoi(port)
+	for name, c := range map[string]*statsapi.Client{"port": statsapi.New(n), "socket": statsapi.NewUnix(sock)} {
+		_, err := c.Replay(context.Background(), 1)
+		var apiErr *statsapi.Error
+		if !errors.As(err, &apiErr) || apiErr.Status != http.StatusNotFound {
+			t.Errorf("replay over the %s: %v, want 404 for the missing request", name, err)
+		}
+	}
+}
+
+func TestLinksNeedToken(t *testing.T) {
+	s, sock := startServer(t)
+	for _, method := range []string{"GET", "POST", "DELETE"} {
+		if got := send(t, s, method, "/api/v1/stats/links", nil); got != http.StatusForbidden {
+			t.Errorf("%s links without the token: %d", method, got)
+		}
+		// No link provider here: past the guard, the handler says so
+		if got := send(t, s, method, "/api/v1/stats/links", map[string]string{statsapi.TokenHeader: s.token}); got != http.StatusServiceUnavailable {
+			t.Errorf("%s links with the token: %d", method, got)
+		}
+	}
+	_, port, _ := strings.Cut(s.Addr(), ":")
+	n, _ := strconv.Atoi(port)
+	for name, c := range map[string]*statsapi.Client{"port": statsapi.New(n), "socket": statsapi.NewUnix(sock)} {
+		_, err := c.Links(context.Background(), "")
+		var apiErr *statsapi.Error
+		if !errors.As(err, &apiErr) || apiErr.Status != http.StatusServiceUnavailable {
+			t.Errorf("links over the %s: %v", name, err)
+		}
+	}
+}
+
+func TestDashboardStartsOnce(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	p := New()
+	p.dashboardPort = port
+	p.SetOverheadSource(func() []hooks.PluginOverhead { return nil })
+	t.Cleanup(p.Close)
+	// Tunnels connect concurrently; each asks for the dashboard
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.startDashboard()
+		}()
+	}
+	wg.Wait()
+	if p.server == nil || p.server.Addr() == "" || p.server.overhead == nil {
+		t.Fatalf("dashboard not started with its options: %+v", p.server)
+	}
+}
@@ -2,31 +2,24 @@ package stats
 
 import (
 	"encoding/base64"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
-	"runtime"
+	"maps"
+	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/QuadTriangle/prod.bd/cli/internal/config"
 	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
 	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+	"github.com/QuadTriangle/prod.bd/cli/statsapi"
 )
 
-// goroutineID returns the current goroutine's ID.
-// Used to correlate OnRequest (has subdomain) with BeforeProxy (has request ID)
-// within the same goroutine in handleMessage.
-func goroutineID() uint64 {
-	var buf [64]byte
-	n := runtime.Stack(buf[:], false)
-	// "goroutine 123 [..."
-	s := strings.TrimPrefix(string(buf[:n]), "goroutine ")
-	s = s[:strings.IndexByte(s, ' ')]
-	id, _ := strconv.ParseUint(s, 10, 64)
-	return id
-}
-
 // RequestEntry is a single logged request/response pair held in memory.
 type RequestEntry struct {
 	ID              int
@@ -42,12 +35,26 @@ type RequestEntry struct {
 	RequestBody     string
 	ResponseHeaders map[string][]string
 	ResponseBody    string
+	ReplayOf        int // ID of the entry this one replayed, 0 if original
+}
+
+// Feedback is a reviewer comment submitted through the banner's feedback widget.
+type Feedback struct {
+	ID        int
+	Subdomain string
+	Comment   string
+	URL       string
+	UserAgent string
+	Timestamp time.Time
 }
 
 // TunnelStats holds aggregate stats for one tunnel.
 type TunnelStats struct {
 	Subdomain     string
 	Port          int
+	Label         string // optional display name for the port
+	Region        string // worker region the tunnel registered in (-region)
+	Group         string // config file group the tunnel was started with (up <group>)
 	TotalRequests int
 	ErrorCount    int
 	TotalBytesIn  int
@@ -55,63 +62,149 @@ type TunnelStats struct {
 	TotalLatency  time.Duration
 	MaxLatency    time.Duration
 	MinLatency    time.Duration
+	Latencies     Histogram
 	ConnectedAt   time.Time
+	// ProtocolErrors counts tunnel messages the CLI couldn't handle, by
+	// error code (unknown-type, malformed, too-large).
+	ProtocolErrors map[string]int
+	// SchemaViolations counts request bodies that failed -schema validation.
+	SchemaViolations int
+	// BaselineMatched counts requests compared with their -baseline
+	// recording; BaselineDiverged those whose response differed.
+	BaselineMatched  int
+	BaselineDiverged int
+	// Queued is how many requests wait behind -max-concurrent, MaxQueued
+	// the most that ever did, and QueueRejected how many got a 503 because
+	// -max-queued were already waiting.
+	Queued        int
+	MaxQueued     int
+	QueueRejected int
+	// TargetHealth is the last -healthcheck probe of the local server, or
+	// nil if health checks are off.
+	TargetHealth *hooks.TargetHealth
+	// FirstConnectedAt is the first connect of this session; ConnectedAt
+	// is reset by every reconnect.
+	FirstConnectedAt time.Time
+	Reconnects       int
+	// Connections are the latest connects and disconnects, oldest first.
+	Connections []ConnEvent
+	// WorkerConfigKeys are the keys of the config the worker last pushed
+	// mid-session; values are left out as they may hold credentials.
+	WorkerConfigKeys []string
+	ConfigUpdatedAt  time.Time
+
+	paths map[string]*PathStats // keyed by method and template; see Store.Paths
 }
 
-// Store is the in-memory stats store. Safe for concurrent use.
-type Store struct {
-	mu          sync.RWMutex
-	tunnels     map[string]*TunnelStats // keyed by subdomain
-	tunnelOrder []string                // insertion order for stable iteration
-	logs        []RequestEntry          // ring buffer
-	maxLogs     int
-	nextID      int
-	// lastSubdomain tracks the most recent subdomain from OnRequest
-	// so AfterProxy can associate the request with the right tunnel.
-	// Keyed by goroutine-safe request flow: OnRequest sets it, BeforeProxy reads it.
-	pendingSubdomain sync.Map // request-ID -> subdomain
+// maxConnEvents caps the connection history kept per tunnel.
+const maxConnEvents = 20
+
+// ConnEvent is a tunnel connecting or dropping.
+type ConnEvent struct {
+	Time      time.Time
+	Connected bool
+	Error     string // why it dropped, if known
 }
 
-func NewStore(maxLogs int) *Store {
-	return &Store{
-		tunnels: make(map[string]*TunnelStats),
-		maxLogs: maxLogs,
+// connHistory outlives reconnects, unlike TunnelStats.
+type connHistory struct {
+	first         time.Time
+	reconnects    int
+	events        []ConnEvent
+	configKeys    []string
+	configUpdated time.Time
+}
+
+func (h *connHistory) add(e ConnEvent) {
+	if len(h.events) == maxConnEvents {
+		h.events = append(h.events[:0], h.events[1:]...)
 	}
+	h.events = append(h.events, e)
 }
 
-// SetPendingSubdomain is called from OnRequest (which has the subdomain)
-// right before BeforeProxy, so the reqHook can pick it up.
-func (s *Store) SetPendingSubdomain(subdomain string) {
-	// Use a counter-based key isn't feasible since OnRequest doesn't know the request ID yet.
-	// Instead we use a channel-like approach: store the subdomain, BeforeProxy consumes it.
-	// This works because handleMessage calls NotifyRequest then RunBeforeProxy sequentially
-	// within the same goroutine.
-	s.pendingSubdomain.Store(goroutineID(), subdomain)
+// Store is the in-memory stats store. Safe for concurrent use.
+type Store struct {
+	mu             sync.RWMutex
+	tunnels        map[string]*TunnelStats // keyed by subdomain
+	tunnelOrder    []string                // insertion order for stable iteration
+	logs           logRing                 // recent entries
+	maxLogs        int
+	nextID         int
+	feedback       []Feedback // ring buffer, capped at maxLogs
+	nextFeedbackID int
+	logDB          *LogDB            // optional persistent copy of the log
+	baseline       *Baseline         // -baseline, compared with live responses
+	recorder       *BaselineRecorder // -baseline-record
+	labels         map[int]string    // port -> display label
+	regions        map[int]string    // port -> worker region
+	group          string
+	subs           map[chan StoreEvent]struct{}
+	policy         CapturePolicy                 // which requests keep their bodies
+	redaction      Redaction                     // secrets masked before entries are stored
+	targetHealth   map[string]hooks.TargetHealth // keyed by subdomain; outlives reconnects
+	history        map[string]*connHistory       // keyed by subdomain
+	lowPower       bool                          // bodies aren't captured while set
+	aggregateOnly  bool                          // see SetAggregateOnly
+	usage          func(subdomain string) hooks.WireUsage
+	costPerGB      float64
 }
 
-// ConsumePendingSubdomain retrieves and removes the subdomain set by OnRequest.
-func (s *Store) ConsumePendingSubdomain() string {
-	if v, ok := s.pendingSubdomain.LoadAndDelete(goroutineID()); ok {
-		return v.(string)
+func NewStore(maxLogs int) *Store {
+	return &Store{
+		tunnels:      make(map[string]*TunnelStats),
+		logs:         newSliceRing(maxLogs),
+		maxLogs:      maxLogs,
+		policy:       captureAll,
+		redaction:    defaultRedaction,
+		targetHealth: make(map[string]hooks.TargetHealth),
+		history:      make(map[string]*connHistory),
 	}
-	return ""
 }
 
 func (s *Store) RecordConnect(subdomain string, port int) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	now := time.Now()
+	if h, ok := s.history[subdomain]; ok {
+		h.reconnects++
+		h.add(ConnEvent{Time: now, Connected: true})
+	} else {
+		s.history[subdomain] = &connHistory{first: now, events: []ConnEvent{{Time: now, Connected: true}}}
+	}
 	s.tunnels[subdomain] = &TunnelStats{
 		Subdomain:   subdomain,
 		Port:        port,
+		Label:       s.labels[port],
+		Region:      s.regions[port],
+		Group:       s.group,
 		MinLatency:  time.Duration(1<<63 - 1), // max duration sentinel
-		ConnectedAt: time.Now(),
+		ConnectedAt: now,
 	}
 	s.tunnelOrder = append(s.tunnelOrder, subdomain)
+	s.publish(StoreEvent{Kind: EventConnect, Subdomain: subdomain, Port: port})
+}
+
+// RecordConfigUpdate keeps the config the worker pushed for a tunnel.
+func (s *Store) RecordConfigUpdate(subdomain string, config map[string]any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h, ok := s.history[subdomain]; ok {
+		h.configKeys, h.configUpdated = slices.Sorted(maps.Keys(config)), time.Now()
+	}
 }
 
-func (s *Store) RecordDisconnect(subdomain string) {
+// RecordDisconnect removes a tunnel's live stats; err says why it dropped,
+// nil on shutdown.
+func (s *Store) RecordDisconnect(subdomain string, err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if h, ok := s.history[subdomain]; ok {
+		e := ConnEvent{Time: time.Now()}
+		if err != nil {
+			e.Error = err.Error()
+		}
+		h.add(e)
+	}
 	delete(s.tunnels, subdomain)
 	// Remove from order slice
 	for i, sd := range s.tunnelOrder {
@@ -120,62 +213,303 @@ func (s *Store) RecordDisconnect(subdomain string) {
 			break
 		}
 	}
+	s.publish(StoreEvent{Kind: EventDisconnect, Subdomain: subdomain})
 }
 
-func (s *Store) RecordRequest(subdomain string, req types.TunnelRequest, resp types.TunnelResponse, latency time.Duration) {
-	bytesIn := len(req.Body)
-	if req.Body != "" {
-		if decoded, err := base64.StdEncoding.DecodeString(req.Body); err == nil {
-			bytesIn = len(decoded)
-		}
+// RecordRequest logs a request/response pair and updates the tunnel's
+// aggregates. Bodies are kept if the capture policy selects the request,
+// except in low-power mode; secrets are masked by the store's redaction first. Returns the new entry's
+// ID.
+func (s *Store) RecordRequest(subdomain string, req types.TunnelRequest, resp types.TunnelResponse, latency time.Duration) int {
+	s.mu.RLock()
+	capture := !s.lowPower && s.policy.Capture(req.Path, resp.Status)
+	redaction, aggregateOnly := s.redaction, s.aggregateOnly
+	s.mu.RUnlock()
+	if aggregateOnly {
+		return s.add(aggregateEntry(subdomain, req, resp, latency))
 	}
-	bytesOut := len(resp.Body)
-	if resp.Body != "" {
-		if decoded, err := base64.StdEncoding.DecodeString(resp.Body); err == nil {
-			bytesOut = len(decoded)
-		}
+	entry := newEntry(subdomain, req, resp, latency, capture)
+	redaction.apply(&entry)
+	full := entry
+	if !capture && s.comparing() {
+		full = newEntry(subdomain, req, resp, latency, true)
+		redaction.apply(&full)
 	}
+	full.ID = s.add(entry)
+	if s.comparing() {
+		s.checkBaseline(full)
+	}
+	return full.ID
+}
 
-	// Decode bodies for storage (cap at 64KB to avoid memory bloat)
-	var reqBody, respBody string
-	if req.Body != "" {
-		if decoded, err := base64.StdEncoding.DecodeString(req.Body); err == nil && len(decoded) < 64_000 {
-			reqBody = string(decoded)
-		}
+// comparing reports whether requests are recorded to or compared with a
+// baseline, which needs their bodies.
+func (s *Store) comparing() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.baseline != nil || s.recorder != nil
+}
+
+// RecordReplay is RecordRequest for a replay of the logged request originalID.
+// Replays always keep their bodies.
+func (s *Store) RecordReplay(originalID int, subdomain string, req types.TunnelRequest, resp types.TunnelResponse, latency time.Duration) int {
+	s.mu.RLock()
+	redaction, aggregateOnly := s.redaction, s.aggregateOnly
+	s.mu.RUnlock()
+	if aggregateOnly {
+		return s.add(aggregateEntry(subdomain, req, resp, latency))
 	}
-	if resp.Body != "" {
-		if decoded, err := base64.StdEncoding.DecodeString(resp.Body); err == nil && len(decoded) < 64_000 {
-			respBody = string(decoded)
-		}
+	entry := newEntry(subdomain, req, resp, latency, true)
+	redaction.apply(&entry)
+	entry.ReplayOf = originalID
+	return s.add(entry)
+}
+
+// RecordProtocolError counts a tunnel message the CLI couldn't handle.
+func (s *Store) RecordProtocolError(subdomain, code string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ts, ok := s.tunnels[subdomain]
+	if !ok {
+		return
+	}
+	if ts.ProtocolErrors == nil {
+		ts.ProtocolErrors = make(map[string]int)
+	}
+	ts.ProtocolErrors[code]++
+}
+
+// RecordQueue records the queue depth of a tunnel, and a request turned
+// away if rejected is set.
+func (s *Store) RecordQueue(subdomain string, queued int, rejected bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ts, ok := s.tunnels[subdomain]
+	if !ok {
+		return
+	}
+	ts.Queued = queued
+	ts.MaxQueued = max(ts.MaxQueued, queued)
+	if rejected {
+		ts.QueueRejected++
+	}
+}
+
+// RecordSchemaViolation counts a request body that failed -schema validation.
+func (s *Store) RecordSchemaViolation(subdomain string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ts, ok := s.tunnels[subdomain]; ok {
+		ts.SchemaViolations++
+	}
+}
+
+// RecordTargetHealth stores the latest health check of a tunnel's local server.
+func (s *Store) RecordTargetHealth(subdomain string, h hooks.TargetHealth) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.targetHealth[subdomain] = h
+}
+
+// SetLabels sets display labels by local port, applied to tunnels as they connect.
+func (s *Store) SetLabels(labels map[int]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.labels = labels
+}
+
+// SetRegions sets worker regions by local port, applied to tunnels as they connect.
+func (s *Store) SetRegions(regions map[int]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.regions = regions
+}
+
+// SetGroup sets the group name shown for every tunnel.
+func (s *Store) SetGroup(group string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.group = group
+}
+
+// SetCapturePolicy controls which requests get their bodies stored.
+func (s *Store) SetCapturePolicy(p CapturePolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policy = p
+}
+
+// SetUsageSource wires in the tunnels' byte counts on the wire (usually
+// tunnel.Usage), priced at costPerGB in the API if it is set.
+func (s *Store) SetUsageSource(fn func(subdomain string) hooks.WireUsage, costPerGB float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.usage, s.costPerGB = fn, costPerGB
+}
+
+// Usage returns the wire usage of a tunnel.
+func (s *Store) Usage(subdomain string) hooks.WireUsage {
+	s.mu.RLock()
+	fn := s.usage
+	s.mu.RUnlock()
+	if fn == nil {
+		return hooks.WireUsage{Subdomain: subdomain}
+	}
+	return fn(subdomain)
+}
+
+// CostPerGB is the price wire usage is estimated at, 0 if unset.
+func (s *Store) CostPerGB() float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.costPerGB
+}
+
+// SetLowPower pauses (or resumes) body capture and tells stream
+// subscribers, for -low-power.
+func (s *Store) SetLowPower(low bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lowPower = low
+	s.publish(StoreEvent{Kind: EventPower, LowPower: low})
+}
+
+// LowPower reports whether the store is in low-power mode.
+func (s *Store) LowPower() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lowPower
+}
+
+// SetRedaction controls which secrets are masked in the request log.
+func (s *Store) SetRedaction(r Redaction) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.redaction = r
+}
+
+// SetAggregateOnly makes the store keep aggregate metrics only, for
+// -stats-aggregate-only: requests count towards their tunnel's totals and
+// latencies, but their entries never get a method, path, headers or body,
+// and are neither logged nor persisted, so the request log, search, HAR
+// export and per-endpoint stats stay empty. It can't be undone.
+func (s *Store) SetAggregateOnly() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.aggregateOnly = true
+}
+
+// AggregateOnly reports whether SetAggregateOnly was called.
+func (s *Store) AggregateOnly() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.aggregateOnly
+}
+
+// aggregateEntry builds the entry of a request in aggregate-only mode: what
+// the tunnel totals need and nothing that says what was requested.
+func aggregateEntry(subdomain string, req types.TunnelRequest, resp types.TunnelResponse, latency time.Duration) RequestEntry {
+	return RequestEntry{
+		Subdomain: subdomain,
+		Status:    resp.Status,
+		Latency:   latency,
+		BytesIn:   decodedLen(req.Body),
+		BytesOut:  decodedLen(resp.Body),
+		Timestamp: time.Now(),
 	}
+}
+
+// decodedLen returns the length of a base64 body once decoded.
+func decodedLen(b64 string) int {
+	return base64.StdEncoding.DecodedLen(len(b64)) - strings.Count(b64[max(len(b64)-2, 0):], "=")
+}
 
+// newEntry builds a log entry, decoding bodies for storage if captureBodies.
+func newEntry(subdomain string, req types.TunnelRequest, resp types.TunnelResponse, latency time.Duration, captureBodies bool) RequestEntry {
 	entry := RequestEntry{
 		Subdomain:       subdomain,
 		Method:          req.Method,
 		Path:            req.Path,
 		Status:          resp.Status,
 		Latency:         latency,
-		BytesIn:         bytesIn,
-		BytesOut:        bytesOut,
+		BytesIn:         base64.StdEncoding.DecodedLen(len(req.Body)),
+		BytesOut:        base64.StdEncoding.DecodedLen(len(resp.Body)),
 		Timestamp:       time.Now(),
 		RequestHeaders:  req.Headers,
-		RequestBody:     reqBody,
 		ResponseHeaders: resp.Headers,
-		ResponseBody:    respBody,
 	}
 
+	// Decode bodies for storage as readable text (capped at maxStoredBody to
+	// avoid memory bloat)
+	if req.Body != "" {
+		if decoded, err := base64.StdEncoding.DecodeString(req.Body); err == nil {
+			entry.BytesIn = len(decoded)
+			if captureBodies {
+				if text, headers, ok := normalizeBody(decoded, req.Headers); ok {
+					entry.RequestBody, entry.RequestHeaders = text, headers
+				}
+			}
+		}
+	}
+	if resp.Body != "" {
+		if decoded, err := base64.StdEncoding.DecodeString(resp.Body); err == nil {
+			entry.BytesOut = len(decoded)
+			if captureBodies {
+				if text, headers, ok := normalizeBody(decoded, resp.Headers); ok {
+					entry.ResponseBody, entry.ResponseHeaders = text, headers
+				}
+			}
+		}
+	}
+	return entry
+}
+
+// UseMmapBuffer replaces the in-memory log with a memory-mapped ring of
+// slots fixed-size slots, for high request rates. Entries captured so far
+// are dropped, so call it before traffic starts.
+func (s *Store) UseMmapBuffer(slots, slotSize int) error {
+	ring, err := newMmapRing(slots, slotSize)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logs.close()
+	s.logs = ring
+	return nil
+}
+
+// SetLogDB persists every entry recorded from now on to db.
+func (s *Store) SetLogDB(db *LogDB) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logDB = db
+}
+
+// LogDB returns the persistent log, or nil if -log-db isn't set.
+func (s *Store) LogDB() *LogDB {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.logDB
+}
+
+// add appends entry to the ring buffer and folds it into the tunnel aggregates.
+func (s *Store) add(entry RequestEntry) int {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	s.nextID++
 	entry.ID = s.nextID
+	if s.logDB != nil && !s.aggregateOnly {
+		s.logDB.Enqueue(entry)
+	}
+	subdomain := entry.Subdomain
+	bytesIn, bytesOut, latency := entry.BytesIn, entry.BytesOut, entry.Latency
 
-	// Ring buffer: keep last maxLogs entries
-	if len(s.logs) >= s.maxLogs {
-		s.logs = append(s.logs[1:], entry)
-	} else {
-		s.logs = append(s.logs, entry)
+	if !s.aggregateOnly {
+		s.logs.push(entry)
 	}
+	s.publish(StoreEvent{Kind: EventRequest, Subdomain: subdomain, Entry: entry})
 
 	if ts, ok := s.tunnels[subdomain]; ok {
 		ts.TotalRequests++
@@ -188,10 +522,78 @@ func (s *Store) RecordRequest(subdomain string, req types.TunnelRequest, resp ty
 		if latency < ts.MinLatency {
 			ts.MinLatency = latency
 		}
-		if resp.Status >= 400 {
+		if entry.Status >= 400 {
 			ts.ErrorCount++
 		}
+		ts.Latencies.Record(latency)
+		if !s.aggregateOnly {
+			ts.recordPath(entry)
+		}
+	}
+	return entry.ID
+}
+
+// recordPath folds entry into the stats of its endpoint.
+func (ts *TunnelStats) recordPath(entry RequestEntry) {
+	method, path := entry.Method, pathTemplate(entry.Path)
+	key := method + " " + path
+	ps, ok := ts.paths[key]
+	if !ok {
+		if ts.paths == nil {
+			ts.paths = make(map[string]*PathStats)
+		}
+		if len(ts.paths) >= maxPathStats {
+			method, path = otherPaths, otherPaths
+			key = method + " " + path
+			ps, ok = ts.paths[key]
+		}
+		if !ok {
+			ps = &PathStats{Subdomain: ts.Subdomain, Method: method, Path: path}
+			ts.paths[key] = ps
+		}
+	}
+	ps.Requests++
+	if entry.Status >= 400 {
+		ps.Errors++
 	}
+	ps.TotalLatency += entry.Latency
+	ps.MaxLatency = max(ps.MaxLatency, entry.Latency)
+	ps.Latencies.Record(entry.Latency)
+}
+
+// Paths returns the per-endpoint stats of a tunnel this connection, or of
+// all tunnels if subdomain is empty.
+func (s *Store) Paths(subdomain string) []PathStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []PathStats
+	for _, sd := range s.tunnelOrder {
+		ts, ok := s.tunnels[sd]
+		if !ok || (subdomain != "" && sd != subdomain) {
+			continue
+		}
+		for _, ps := range ts.paths {
+			out = append(out, *ps)
+		}
+	}
+	return out
+}
+
+// Entry returns a logged request by ID, if it's still in the ring buffer.
+func (s *Store) Entry(id int) (RequestEntry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.logs.find(id)
+}
+
+// TunnelPort returns the local port of a connected tunnel.
+func (s *Store) TunnelPort(subdomain string) (int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if ts, ok := s.tunnels[subdomain]; ok {
+		return ts.Port, true
+	}
+	return 0, false
 }
 
 // Snapshot returns a copy of all tunnel stats in stable insertion order.
@@ -202,21 +604,63 @@ func (s *Store) Snapshot() []TunnelStats {
 	for _, sd := range s.tunnelOrder {
 		if ts, ok := s.tunnels[sd]; ok {
 			cp := *ts
+			cp.paths = nil
+			cp.ProtocolErrors = maps.Clone(ts.ProtocolErrors)
+			if h, ok := s.targetHealth[sd]; ok {
+				cp.TargetHealth = &h
+			}
+			if h, ok := s.history[sd]; ok {
+				cp.FirstConnectedAt, cp.Reconnects = h.first, h.reconnects
+				cp.Connections = slices.Clone(h.events)
+				cp.WorkerConfigKeys, cp.ConfigUpdatedAt = h.configKeys, h.configUpdated
+			}
 			out = append(out, cp)
 		}
 	}
 	return out
 }
 
+// StatusClasses counts logged requests for a subdomain by status class
+// ("2xx", "3xx", ...). Only the in-memory log window is counted.
+func (s *Store) StatusClasses(subdomain string) map[string]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := map[string]int{}
+	s.logs.statuses(func(sub string, status int) {
+		if sub == subdomain && status >= 100 {
+			out[strconv.Itoa(status/100)+"xx"]++
+		}
+	})
+	return out
+}
+
 // RecentLogs returns the last n request entries.
 func (s *Store) RecentLogs(n int) []RequestEntry {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	if n > len(s.logs) {
-		n = len(s.logs)
+	return s.logs.recent(n)
+}
+
+// AddFeedback stores a reviewer comment and returns it with its ID set.
+func (s *Store) AddFeedback(f Feedback) Feedback {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextFeedbackID++
+	f.ID = s.nextFeedbackID
+	if len(s.feedback) >= s.maxLogs {
+		s.feedback = append(s.feedback[1:], f)
+	} else {
+		s.feedback = append(s.feedback, f)
 	}
-	out := make([]RequestEntry, n)
-	copy(out, s.logs[len(s.logs)-n:])
+	return f
+}
+
+// Feedback returns all stored reviewer comments, oldest first.
+func (s *Store) Feedback() []Feedback {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Feedback, len(s.feedback))
+	copy(out, s.feedback)
 	return out
 }
 
@@ -226,19 +670,70 @@ func (s *Store) RecentLogs(n int) []RequestEntry {
 // Controlled by a single -dashboard flag: port > 0 enables stats + dashboard, 0 disables everything.
 type Plugin struct {
 	dashboardPort int
+	publicStats   bool
+	observerToken string
+	observerShown sync.Map // subdomain -> struct{}, once its share link was logged
+	logDBPath     string
+	logRetention  time.Duration
+	baselinePath  string
+	recordPath    string
+	baselineIgn   string
+	recorder      *BaselineRecorder
+	captureRate   float64
+	captureErrors bool
+	capturePaths  string
+	captureBuffer string
+	aggregateOnly bool
+	captureSlots  int
+	slotSize      int
+	redact        *redactFlag
 	store         *Store
+	serverMu      sync.Mutex // guards server, started by whichever tunnel connects first
 	server        *Server
+	overhead      func() []hooks.PluginOverhead
+	links         Links
+	feedback      FeedbackWidget
+}
+
+// FeedbackWidget tells whether visitors are offered a feedback box (the
+// banner plugin), so that comments are only taken when they are.
+type FeedbackWidget interface {
+	Feedback() bool
+}
+
+// Links makes and manages magic links for the control API (the magiclink
+// plugin).
+type Links interface {
+	NewLink(subdomain, email, path string, ttl time.Duration) (statsapi.Link, error)
+	Links(subdomain string) []statsapi.Link
+	RevokeLink(id string) bool
 }
 
 func New() *Plugin {
 	return &Plugin{
-		store: NewStore(1000),
+		store:  NewStore(1000),
+		redact: newRedactFlag(),
 	}
 }
 
 func (p *Plugin) Name() string { return "stats" }
 func (p *Plugin) RegisterFlags(fs *flag.FlagSet) {
 	fs.IntVar(&p.dashboardPort, "dashboard-port", 9999, "Stats dashboard port (0 to disable stats entirely)")
+	fs.BoolVar(&p.publicStats, "public-stats", false, "Serve aggregate-only stats at /_prodbd/stats on the public URL (protected by -auth / -allow-ip)")
+	fs.StringVar(&p.observerToken, "observer-token", "", "Let teammates holding this token watch live stats and the request log, read-only, at /_prodbd/observe/ on the public URL (auto to generate one)")
+	fs.StringVar(&p.logDBPath, "log-db", "", "Persist the request log to this SQLite file (e.g. ~/.prod/requests.db), or fs:<dir> for plain files, for history across sessions")
+	fs.Float64Var(&p.captureRate, "capture-bodies", 100, "Percentage of requests whose bodies are kept in the request log (metadata is always logged)")
+	fs.BoolVar(&p.captureErrors, "capture-errors", true, "Always keep bodies of 4xx/5xx responses, regardless of -capture-bodies")
+	fs.StringVar(&p.capturePaths, "capture-paths", "", "Comma-separated path globs whose bodies are always kept (e.g. /api/checkout,/webhooks/*)")
+	fs.StringVar(&p.captureBuffer, "capture-buffer", "memory", "Request log backend: memory, or mmap for a preallocated ring with no per-request allocations")
+	fs.IntVar(&p.captureSlots, "capture-slots", 1000, "Entries kept by -capture-buffer mmap")
+	fs.IntVar(&p.slotSize, "capture-slot-size", 4096, "Bytes per -capture-buffer mmap entry; longer headers and bodies are truncated")
+	fs.Var(p.redact, "redact", "Also mask these in the request log, comma-separated: header:Name, json:field-glob, regex:expr (last, may contain commas); none drops the defaults (Authorization, Cookie, Set-Cookie headers and password/secret JSON fields)")
+	fs.DurationVar(&p.logRetention, "log-retention", 7*24*time.Hour, "Delete -log-db entries older than this (0 keeps everything)")
+	fs.BoolVar(&p.aggregateOnly, "stats-aggregate-only", false, "Keep only aggregate stats (counts, errors, latency, traffic) and never store a request's path, headers or body, e.g. for regulated data")
+	fs.StringVar(&p.recordPath, "baseline-record", "", "Record every request and its response to this file, as a baseline for -baseline")
+	fs.StringVar(&p.baselinePath, "baseline", "", "Compare responses with those recorded by -baseline-record for the same requests, and flag divergences in stats and the dashboard")
+	fs.StringVar(&p.baselineIgn, "baseline-ignore", "Date", "Comma-separated response headers and JSON field paths ($.a.b) -baseline leaves out")
 }
 func (p *Plugin) Enabled() bool                { return p.dashboardPort > 0 }
 func (p *Plugin) WorkerConfig() map[string]any { return nil }
@@ -249,21 +744,162 @@ func (p *Plugin) ConnectionHooks() []hooks.ConnectionHook {
 	return []hooks.ConnectionHook{&connHook{store: p.store, plugin: p}}
 }
 
+// PathHandlers serves the feedback widget's submissions while it is shown
+// and, with -public-stats, the aggregate stats page on the public tunnel;
+// with -observer-token, the read-only observer dashboard.
+func (p *Plugin) PathHandlers() []hooks.PathHandler {
+	var handlers []hooks.PathHandler
+	if p.feedback != nil && p.feedback.Feedback() {
+		handlers = append(handlers, &feedbackHandler{store: p.store})
+	}
+	if p.publicStats {
+		handlers = append(handlers, &publicStatsHandler{store: p.store})
+	}
+	if p.observerToken == "auto" {
+		p.observerToken = newToken()
+	}
+	if p.observerToken != "" {
+		srv := &Server{store: p.store, overhead: p.overhead}
+		handlers = append(handlers, hooks.FromHandler(ObserverPath, observerHandler(srv, p.observerToken)))
+	}
+	return handlers
+}
+
+// SubscribeEvents counts protocol errors reported by the tunnel client and
+// -schema violations, tracks request queues, and pauses body capture and dashboard streams in
+// low-power mode.
+func (p *Plugin) SubscribeEvents(bus *hooks.Bus) {
+	hooks.Subscribe(bus, func(e hooks.PowerModeChanged) {
+		p.store.SetLowPower(e.LowPower)
+	})
+	hooks.Subscribe(bus, func(e hooks.ProtocolError) {
+		p.store.RecordProtocolError(e.Subdomain, e.Code)
+	})
+	hooks.Subscribe(bus, func(e hooks.SchemaViolation) {
+		p.store.RecordSchemaViolation(e.Subdomain)
+	})
+	hooks.Subscribe(bus, func(e hooks.QueueChanged) {
+		p.store.RecordQueue(e.Subdomain, e.Queued, e.Rejected)
+	})
+}
+
 // Store returns the underlying store for external consumers (TUI, subcommands).
 func (p *Plugin) Store() *Store { return p.store }
 
+// SetOverheadSource wires per-plugin hook timings (usually Pipeline.Overhead)
+// into the stats API. Call before the first tunnel connects.
+func (p *Plugin) SetOverheadSource(fn func() []hooks.PluginOverhead) { p.overhead = fn }
+
+// SetLinks serves magic links from l on the local API (not the observer
+// endpoint). Call before the first tunnel connects.
+func (p *Plugin) SetLinks(l Links) { p.links = l }
+
+// SetFeedbackWidget takes comments at FeedbackPath only while w shows the
+// widget. Call before Pipeline.Activate.
+func (p *Plugin) SetFeedbackWidget(w FeedbackWidget) { p.feedback = w }
+
+// Validate loads -baseline and creates -baseline-record, and switches the
+// store to aggregate-only with -stats-aggregate-only. Call after flags are
+// parsed, before the first tunnel connects.
+func (p *Plugin) Validate() error {
+	if p.aggregateOnly {
+		if p.baselinePath != "" || p.recordPath != "" || p.logDBPath != "" {
+			return errors.New("-stats-aggregate-only keeps no requests, so it can't be combined with -log-db, -baseline or -baseline-record")
+		}
+		p.store.SetAggregateOnly()
+	}
+	if p.baselinePath == "" && p.recordPath == "" {
+		return nil
+	}
+	if !p.Enabled() {
+		return errors.New("-baseline and -baseline-record need stats; don't set -dashboard-port 0")
+	}
+	if p.baselinePath != "" && p.baselinePath == p.recordPath {
+		return errors.New("-baseline-record would overwrite the -baseline being compared with; record to another file")
+	}
+	if p.baselinePath != "" {
+		b, err := LoadBaseline(p.baselinePath, strings.Split(p.baselineIgn, ","))
+		if err != nil {
+			return fmt.Errorf("-baseline: %w", err)
+		}
+		p.store.SetBaseline(b)
+	}
+	if p.recordPath != "" {
+		r, err := CreateBaseline(p.recordPath)
+		if err != nil {
+			return fmt.Errorf("-baseline-record: %w", err)
+		}
+		p.recorder = r
+		p.store.SetBaselineRecorder(r)
+	}
+	return nil
+}
+
+// Close stops the local API and flushes and closes the persistent request
+// log and baseline recording, if open.
+func (p *Plugin) Close() {
+	p.serverMu.Lock()
+	if p.server != nil {
+		p.server.Close()
+	}
+	p.serverMu.Unlock()
+	if db := p.store.LogDB(); db != nil {
+		p.store.SetLogDB(nil)
+		if err := db.Close(); err != nil {
+			log.Printf("[stats] closing log-db: %v", err)
+		}
+	}
+	if p.recorder != nil {
+		p.store.SetBaselineRecorder(nil)
+		if err := p.recorder.Close(); err != nil {
+			log.Printf("[stats] closing baseline recording: %v", err)
+		}
+	}
+}
+
 // startDashboard starts the local HTTP server for the dashboard on first connect.
 func (p *Plugin) startDashboard() {
+	p.serverMu.Lock()
+	defer p.serverMu.Unlock()
 	if p.dashboardPort == 0 || p.server != nil {
 		return
 	}
-	srv, err := StartServer(p.store, p.dashboardPort)
-	if err != nil {
-		log.Printf("[stats] failed to start dashboard server: %v", err)
-		return
+	p.store.SetCapturePolicy(CapturePolicy{
+		Rate:   min(max(p.captureRate, 0), 100) / 100,
+		Errors: p.captureErrors,
+		Paths:  parsePathGlobs(p.capturePaths),
+	})
+	p.store.SetRedaction(p.redact.r)
+	switch p.captureBuffer {
+	case "memory":
+	case "mmap":
+		if err := p.store.UseMmapBuffer(p.captureSlots, p.slotSize); err != nil {
+			log.Printf("[stats] using in-memory capture buffer: %v", err)
+		}
+	default:
+		log.Printf("[stats] unknown -capture-buffer %q, using memory", p.captureBuffer)
 	}
+	if p.logDBPath != "" {
+		db, err := OpenLogDB(p.logDBPath, p.logRetention)
+		if err != nil {
+			log.Printf("[stats] request history disabled: %v", err)
+		} else {
+			p.store.SetLogDB(db)
+		}
+	}
+	srv := NewServer(p.store, ServerOptions{Overhead: p.overhead, Links: p.links})
 	p.server = srv
-	log.Printf("[stats] dashboard API listening on http://%s", srv.Addr())
+	if err := srv.Listen(p.dashboardPort); err != nil {
+		log.Printf("[stats] failed to start dashboard server: %v", err)
+	} else {
+		log.Printf("[stats] dashboard API listening on http://%s", srv.Addr())
+	}
+	// The control socket still works when another prod holds the port
+	if path, err := statsapi.SocketPath(os.Getpid()); err != nil {
+		log.Printf("[stats] control socket disabled: %v", err)
+	} else if err := srv.ListenControl(path); err != nil {
+		log.Printf("[stats] control socket disabled: %v", err)
+	}
 }
 
 // --- Hooks ---
@@ -271,32 +907,23 @@ func (p *Plugin) startDashboard() {
 type reqHook struct {
 	hooks.NoOpRequestHook
 	store *Store
-	// Per-request tracking: start time + subdomain, keyed by request ID
-	pending sync.Map // req.ID -> reqMeta
 }
 
-type reqMeta struct {
-	start     time.Time
-	subdomain string
-}
+// startKey is the RequestContext.Values key holding the request start time.
+const startKey = "stats.start"
 
-func (h *reqHook) BeforeProxy(req types.TunnelRequest) types.TunnelRequest {
-	// Consume the subdomain that OnRequest stashed for this goroutine
-	subdomain := h.store.ConsumePendingSubdomain()
-	h.pending.Store(req.ID, reqMeta{start: time.Now(), subdomain: subdomain})
+func (h *reqHook) BeforeProxy(ctx *hooks.RequestContext, req types.TunnelRequest) types.TunnelRequest {
+	ctx.Values[startKey] = time.Now()
 	return req
 }
 
-func (h *reqHook) AfterProxy(req types.TunnelRequest, resp types.TunnelResponse) types.TunnelResponse {
+func (h *reqHook) AfterProxy(ctx *hooks.RequestContext, req types.TunnelRequest, resp types.TunnelResponse) types.TunnelResponse {
 	var latency time.Duration
-	subdomain := ""
-	if v, ok := h.pending.LoadAndDelete(req.ID); ok {
-		meta := v.(reqMeta)
-		latency = time.Since(meta.start)
-		subdomain = meta.subdomain
+	if start, ok := ctx.Values[startKey].(time.Time); ok {
+		latency = time.Since(start)
 	}
 
-	h.store.RecordRequest(subdomain, req, resp, latency)
+	h.store.RecordRequest(ctx.Subdomain, req, resp, latency)
 
 	return resp
 }
@@ -307,17 +934,24 @@ type connHook struct {
 	plugin *Plugin
 }
 
-func (h *connHook) OnConnect(subdomain string, port int) {
+func (h *connHook) OnConnect(subdomain string, port int, _ *hooks.WarmupResult) {
 	h.store.RecordConnect(subdomain, port)
 	h.plugin.startDashboard()
+	if token := h.plugin.observerToken; token != "" {
+		if _, shown := h.plugin.observerShown.LoadOrStore(subdomain, struct{}{}); !shown {
+			log.Printf("[stats] read-only observer link: %s", ObserverURL(config.PublicURL(subdomain), token))
+		}
+	}
 }
 
 func (h *connHook) OnDisconnect(subdomain string, err error) {
-	h.store.RecordDisconnect(subdomain)
+	h.store.RecordDisconnect(subdomain, err)
+}
+
+func (h *connHook) OnConfigUpdate(subdomain string, config map[string]any) {
+	h.store.RecordConfigUpdate(subdomain, config)
 }
 
-func (h *connHook) OnRequest(subdomain string) {
-	// Stash subdomain for the reqHook.BeforeProxy call that follows
-	// in the same goroutine (handleMessage calls NotifyRequest → RunBeforeProxy sequentially)
-	h.store.SetPendingSubdomain(subdomain)
+func (h *connHook) OnTargetHealth(subdomain string, _ int, health hooks.TargetHealth) {
+	h.store.RecordTargetHealth(subdomain, health)
 }
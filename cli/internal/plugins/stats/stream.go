@@ -0,0 +1,120 @@
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Kinds of StoreEvent.
+const (
+	EventRequest    = "request"
+	EventConnect    = "connect"
+	EventDisconnect = "disconnect"
+	EventPower      = "power"
+)
+
+// StoreEvent is a change to the Store pushed to subscribers.
+type StoreEvent struct {
+	Kind      string
+	Subdomain string
+	Port      int          // connect only
+	Entry     RequestEntry // request only
+	LowPower  bool         // power only
+}
+
+// subscriberBuffer is how many events a slow subscriber may lag behind
+// before further events are dropped for it.
+const subscriberBuffer = 256
+
+// Subscribe returns a channel of store events and a function that ends the
+// subscription. Events are dropped rather than blocking the store when the
+// subscriber falls behind.
+func (s *Store) Subscribe() (<-chan StoreEvent, func()) {
+	ch := make(chan StoreEvent, subscriberBuffer)
+	s.mu.Lock()
+	if s.subs == nil {
+		s.subs = make(map[chan StoreEvent]struct{})
+	}
+	s.subs[ch] = struct{}{}
+	s.mu.Unlock()
+	return ch, func() {
+		s.mu.Lock()
+		delete(s.subs, ch)
+		s.mu.Unlock()
+	}
+}
+
+// publish sends e to subscribers. Callers hold s.mu.
+func (s *Store) publish(e StoreEvent) {
+	for ch := range s.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// streamKeepalive is how often an idle stream gets a comment line, so
+// proxies and browsers don't time it out.
+const streamKeepalive = 15 * time.Second
+
+// handleStream pushes store events as server-sent events: "request" with a
+// request entry, "tunnel" with {subdomain, port, connected} and "power"
+// with {low_power}. Keepalives pause in low-power mode. Optional filter:
+// subdomain.
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	subdomain := r.URL.Query().Get("subdomain")
+	events, unsubscribe := s.store.Subscribe()
+	defer unsubscribe()
+	keepalive := time.NewTicker(streamKeepalive)
+	defer keepalive.Stop()
+	if s.store.LowPower() {
+		keepalive.Stop()
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case e := <-events:
+			if e.Kind == EventPower {
+				if e.LowPower {
+					keepalive.Stop()
+				} else {
+					keepalive.Reset(streamKeepalive)
+				}
+				data, _ := json.Marshal(map[string]bool{"low_power": e.LowPower})
+				fmt.Fprintf(w, "event: power\ndata: %s\n\n", data)
+				break
+			}
+			if subdomain != "" && e.Subdomain != subdomain {
+				continue
+			}
+			name, v := "tunnel", any(map[string]any{
+				"subdomain": e.Subdomain,
+				"port":      e.Port,
+				"connected": e.Kind == EventConnect,
+			})
+			if e.Kind == EventRequest {
+				name, v = "request", toRequestJSON(e.Entry)
+			}
+			data, _ := json.Marshal(v)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/qr"
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+// VerifyPath is where the code form posts to on the public tunnel.
+const VerifyPath = hooks.ReservedPrefix + "totp/verify"
+
+// Codes are RFC 6238 defaults, which every authenticator app supports:
+// HMAC-SHA1, 6 digits, 30 second steps. One step either side absorbs clock
+// skew and slow typing.
+const (
+	step = 30 * time.Second
+	skew = 1
+)
+
+// A wrong code holds up the visitor's next attempt by failDelay; after
+// maxFailures in a row they are locked out for lockout. Visitors are told
+// apart by address; a request without one is refused rather than sharing a
+// lockout with every other such request.
+const (
+	failDelay   = time.Second
+	maxFailures = 5
+	lockout     = 15 * time.Minute
+)
+
+// codePage is the code form. The verbs are an error message, the form
+// action and the escaped path to go back to.
+const codePage = `<!doctype html><html><head><meta charset="utf-8">` +
+	`<meta name="viewport" content="width=device-width,initial-scale=1"><meta name="robots" content="noindex">` +
+	`<title>Verification code required</title></head>` +
+	`<body style="font:15px/1.5 system-ui,sans-serif;display:flex;justify-content:center;padding-top:15vh">` +
+	`<form method="post" action="%[2]s" style="display:flex;flex-direction:column;gap:10px;width:280px">` +
+	`<strong>Enter the code from your authenticator app</strong>%[1]s` +
+	`<input type="hidden" name="return" value="%[3]s">` +
+	`<input name="code" required autofocus inputmode="numeric" autocomplete="one-time-code" pattern="[0-9 ]*" placeholder="123 456" style="padding:6px 8px;font:inherit">` +
+	`<button type="submit" style="padding:6px 8px;font:inherit;cursor:pointer">Verify</button></form></body></html>`
+
+// Plugin adds a second factor: visitors enter a time-based code from an
+// authenticator app, enrolled by scanning a QR code printed at startup. It
+// is the "totp" scheme of the access plugin, so by default it stacks on
+// -password (and on -auth, which the worker keeps checking); it needs one
+// of the other schemes to stack on.
+type Plugin struct {
+	enabled    bool
+	secretFlag string // base32
+	sessionTTL time.Duration
+
+	plugins  func() []hooks.AuthPlugin
+	secret   []byte
+	sessions *hooks.Sessions
+
+	mu       sync.Mutex
+	lastUsed uint64              // step of the last code accepted; codes are single use
+	visitors map[string]*visitor // by address
+}
+
+// visitor is what the plugin remembers of one address's attempts.
+type visitor struct {
+	seen     time.Time // last attempt
+	failures int       // wrong codes in a row
+	retryAt  time.Time // no attempts before
+}
+
+// New returns the plugin; plugins lists the enabled auth plugins, one of
+// which must be a first factor.
+func New(plugins func() []hooks.AuthPlugin) *Plugin {
+	return &Plugin{plugins: plugins, visitors: make(map[string]*visitor)}
+}
+
+func (p *Plugin) Name() string { return "totp" }
+func (p *Plugin) RegisterFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&p.enabled, "totp", false, "Also require a code from an authenticator app; without -totp-secret a new one is made and its QR code printed")
+	fs.StringVar(&p.secretFlag, "totp-secret", "", "Base32 TOTP secret an authenticator app is already enrolled with (prefer $"+hooks.EnvName("totp-secret")+")")
+	fs.DurationVar(&p.sessionTTL, "totp-session", 12*time.Hour, "How long a verified code lasts with -totp")
+}
+func (p *Plugin) Enabled() bool                           { return p.enabled }
+func (p *Plugin) WorkerConfig() map[string]any            { return nil }
+func (p *Plugin) RequestHooks() []hooks.RequestHook       { return nil }
+func (p *Plugin) ConnectionHooks() []hooks.ConnectionHook { return nil }
+func (p *Plugin) PathHandlers() []hooks.PathHandler {
+	return []hooks.PathHandler{&pathHandler{plugin: p}}
+}
+func (p *Plugin) Authenticator() hooks.Authenticator { return authenticator{p} }
+func (p *Plugin) SecondFactor()                      {}
+
+// Validate checks the flags and, without -totp-secret, enrolls a new
+// secret. Call after flags are parsed and the other auth plugins
+// validated, before registering.
+func (p *Plugin) Validate() error {
+	if !p.enabled {
+		return nil
+	}
+	if p.sessionTTL <= 0 {
+		return errors.New("-totp-session must be positive")
+	}
+	if !p.hasFirstFactor() {
+		return errors.New("-totp is a second factor; also set -password, -auth, -oauth-provider, -jwt-secret or -magic-links")
+	}
+	p.sessions = hooks.NewSessions(p.Name(), p.sessionTTL)
+	if p.secretFlag == "" {
+		p.secret = make([]byte, 20)
+		rand.Read(p.secret)
+		printEnrollment(p.secret)
+		return nil
+	}
+	secret, err := decodeSecret(p.secretFlag)
+	if err != nil {
+		return fmt.Errorf("-totp-secret: %w", err)
+	}
+	if len(secret) < 10 {
+		return errors.New("-totp-secret: too short, use at least 16 base32 characters")
+	}
+	p.secret = secret
+	return nil
+}
+
+// hasFirstFactor reports whether another scheme that isn't a second factor
+// is enabled.
+func (p *Plugin) hasFirstFactor() bool {
+	for _, ap := range p.plugins() {
+		if _, second := ap.(hooks.SecondFactor); !second {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeSecret reads a base32 secret the way apps show them: any case,
+// spaces and padding optional.
+func decodeSecret(s string) ([]byte, error) {
+	s = strings.ToUpper(strings.NewReplacer(" ", "", "-", "", "=", "").Replace(s))
+	return base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(s)
+}
+
+// printEnrollment shows the QR code and secret to add to an authenticator
+// app.
+func printEnrollment(secret []byte) {
+	encoded := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret)
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "tunnel"
+	}
+	uri := (&url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/prod.bd:" + host,
+		RawQuery: url.Values{"secret": {encoded}, "issuer": {"prod.bd"}}.Encode(),
+	}).String()
+	fmt.Fprintln(os.Stderr, "Scan with an authenticator app to enroll for -totp:")
+	if code, err := qr.Encode(uri); err == nil {
+		fmt.Fprint(os.Stderr, code.Terminal())
+	}
+	fmt.Fprintf(os.Stderr, "Or enter the key %s by hand. To keep this enrollment next time, set %s=%s\n\n", encoded, hooks.EnvName("totp-secret"), encoded)
+}
+
+// code returns the code for time step counter.
+func (p *Plugin) code(counter uint64) string {
+	m := hmac.New(sha1.New, p.secret)
+	binary.Write(m, binary.BigEndian, counter)
+	sum := m.Sum(nil)
+	off := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[off:]) & 0x7fffffff
+	return fmt.Sprintf("%06d", n%1_000_000)
+}
+
+// check reports whether code from addr is right at now and not used
+// before, by anyone. A visitor held up by earlier wrong codes isn't checked;
+// wait is how much longer they have to wait.
+func (p *Plugin) check(addr, code string, now time.Time) (ok bool, wait time.Duration) {
+	code = strings.ReplaceAll(code, " ", "")
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	v := p.visitor(addr, now)
+	if wait := v.retryAt.Sub(now); wait > 0 {
+		return false, wait
+	}
+	current := uint64(now.Unix()) / uint64(step/time.Second)
+	for c := current - skew; c <= current+skew; c++ {
+		if c > p.lastUsed && hmac.Equal([]byte(code), []byte(p.code(c))) {
+			p.lastUsed, v.failures = c, 0
+			return true, 0
+		}
+	}
+	v.failures++
+	v.retryAt = now.Add(failDelay)
+	if v.failures >= maxFailures {
+		v.failures = 0
+		v.retryAt = now.Add(lockout)
+	}
+	return false, 0
+}
+
+// visitor returns the record of addr, forgetting visitors not seen for
+// lockout, by when their hold-ups are over.
+// Call with mu held.
+func (p *Plugin) visitor(addr string, now time.Time) *visitor {
+	for a, v := range p.visitors {
+		if now.Sub(v.seen) > lockout {
+			delete(p.visitors, a)
+		}
+	}
+	v, ok := p.visitors[addr]
+	if !ok {
+		v = &visitor{}
+		p.visitors[addr] = v
+	}
+	v.seen = now
+	return v
+}
+
+// authenticator is the "totp" scheme.
+type authenticator struct {
+	plugin *Plugin
+}
+
+func (a authenticator) Name() string { return a.plugin.Name() }
+
+func (a authenticator) Verify(_ *hooks.RequestContext, req types.TunnelRequest) (string, bool) {
+	return a.plugin.sessions.Verify(req, time.Now())
+}
+
+// Challenge shows page loads the code form; other requests get a 401.
+func (a authenticator) Challenge(_ *hooks.RequestContext, req types.TunnelRequest) (types.TunnelResponse, bool) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return hooks.TextResponse(http.StatusUnauthorized, "Verification code required"), true
+	}
+	return hooks.FormResponse(http.StatusUnauthorized, codePage, "", VerifyPath, req.Path), true
+}
+
+type pathHandler struct {
+	plugin *Plugin
+}
+
+func (h *pathHandler) ServePath(ctx *hooks.RequestContext, req types.TunnelRequest) (types.TunnelResponse, bool) {
+	reqPath, _, _ := strings.Cut(req.Path, "?")
+	if reqPath != VerifyPath {
+		return types.TunnelResponse{}, false
+	}
+	if req.Method != http.MethodPost {
+		return hooks.FormResponse(http.StatusOK, codePage, "", VerifyPath, "/"), true
+	}
+	body, _ := base64.StdEncoding.DecodeString(req.Body)
+	form, _ := url.ParseQuery(string(body))
+	returnTo := form.Get("return")
+	addr := hooks.CanonicalHeader(req.Headers).Get("Cf-Connecting-Ip")
+	if addr == "" {
+		return hooks.TextResponse(http.StatusBadRequest, "Missing visitor address"), true
+	}
+	ok, wait := h.plugin.check(addr, form.Get("code"), time.Now())
+	if wait > 0 {
+		resp := hooks.FormResponse(http.StatusTooManyRequests, codePage, fmt.Sprintf("Too many wrong codes; try again in %s", wait.Round(time.Second)), VerifyPath, returnTo)
+		resp.Headers["Retry-After"] = []string{strconv.Itoa(int(wait.Seconds()) + 1)}
+		return resp, true
+	}
+	if !ok {
+		log.Printf("[totp] wrong code from %s on %s", addr, ctx.Subdomain)
+		return hooks.FormResponse(http.StatusUnauthorized, codePage, "Wrong or already used code", VerifyPath, returnTo), true
+	}
+	resp := hooks.Redirect(returnTo)
+	resp.Headers["Set-Cookie"] = []string{h.plugin.sessions.Issue("", time.Now())}
+	return resp, true
+}
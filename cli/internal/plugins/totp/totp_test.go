@@ -0,0 +1,140 @@
+package totp
+
+import (
+	"encoding/base64"
+	"flag"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+// password stands in for a first factor.
+type password struct{}
+
+func (password) Name() string                            { return "password" }
+func (password) RegisterFlags(*flag.FlagSet)             {}
+func (password) Enabled() bool                           { return true }
+func (password) WorkerConfig() map[string]any            { return nil }
+func (password) RequestHooks() []hooks.RequestHook       { return nil }
+func (password) ConnectionHooks() []hooks.ConnectionHook { return nil }
+func (password) Authenticator() hooks.Authenticator      { return nil }
+
+// newPlugin returns an enabled plugin with the RFC 6238 test secret.
+func newPlugin(t *testing.T, others ...hooks.AuthPlugin) (*Plugin, error) {
+	t.Helper()
+	var p *Plugin
+	p = New(func() []hooks.AuthPlugin { return append([]hooks.AuthPlugin{p}, others...) })
+	p.enabled = true
+	p.sessionTTL = time.Hour
+	p.secretFlag = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" // "12345678901234567890"
+	return p, p.Validate()
+}
+
+func TestNeedsFirstFactor(t *testing.T) {
+	if _, err := newPlugin(t); err == nil {
+		t.Fatal("-totp on its own accepted")
+	}
+	if _, err := newPlugin(t, password{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCodes(t *testing.T) {
+	p, err := newPlugin(t, password{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// RFC 6238 appendix B, truncated to 6 digits
+	if got := p.code(59 / 30); got != "287082" {
+		t.Fatalf("code at 59s = %s", got)
+	}
+
+	now := time.Unix(59, 0)
+	if ok, _ := p.check("1.2.3.4", "287 082", now); !ok {
+		t.Fatal("right code refused")
+	}
+	if ok, _ := p.check("1.2.3.4", "287082", now.Add(2*time.Second)); ok {
+		t.Fatal("code used twice by the same visitor")
+	}
+	// Someone who saw the code over the shoulder can't replay it
+	if ok, _ := p.check("5.6.7.8", "287082", now.Add(2*time.Second)); ok {
+		t.Fatal("code used twice by another visitor")
+	}
+}
+
+func TestWrongCodesLockOut(t *testing.T) {
+	p, err := newPlugin(t, password{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(59, 0)
+	right := p.code(59 / 30)
+
+	// Attempts right after a wrong one wait, without holding up others
+	p.check("1.2.3.4", "000000", now)
+	if ok, wait := p.check("1.2.3.4", right, now); ok || wait <= 0 {
+		t.Fatalf("attempt right after a wrong code: ok %v, wait %v", ok, wait)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.check("5.6.7.8", "000000", now)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second / 2):
+		t.Fatal("a wrong code held up another visitor")
+	}
+
+	for i := 1; i < maxFailures; i++ {
+		now = now.Add(failDelay)
+		p.check("1.2.3.4", "000000", now)
+	}
+	now = now.Add(failDelay)
+	if ok, wait := p.check("1.2.3.4", right, now); ok || wait < lockout-failDelay {
+		t.Fatalf("after %d wrong codes: ok %v, wait %v", maxFailures, ok, wait)
+	}
+	if ok, _ := p.check("1.2.3.4", p.code(uint64(now.Add(lockout).Unix())/30), now.Add(lockout)); !ok {
+		t.Fatal("still locked out after the lockout")
+	}
+	if len(p.visitors) != 1 {
+		t.Fatalf("remembering %d visitors, want only the one seen recently", len(p.visitors))
+	}
+}
+
+func TestVerifyPath(t *testing.T) {
+	p, err := newPlugin(t, password{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := p.PathHandlers()[0]
+	post := func(addr, code string) types.TunnelResponse {
+		body := url.Values{"code": {code}, "return": {"/app"}}.Encode()
+		req := types.TunnelRequest{
+			Method:  http.MethodPost,
+			Path:    VerifyPath,
+			Headers: map[string][]string{"Cf-Connecting-Ip": {addr}},
+			Body:    base64.StdEncoding.EncodeToString([]byte(body)),
+		}
+		resp, _ := h.ServePath(hooks.NewRequestContext(hooks.NewTunnelContext("abc", 3000), time.Now()), req)
+		return resp
+	}
+
+	// Without an address, wrong codes would lock out everyone without one
+	for range maxFailures + 1 {
+		if resp := post("", "000000"); resp.Status != http.StatusBadRequest {
+			t.Fatalf("no visitor address: %d", resp.Status)
+		}
+	}
+	if resp := post("1.2.3.4", "000000"); resp.Status != http.StatusUnauthorized {
+		t.Fatalf("wrong code: %d", resp.Status)
+	}
+	resp := post("1.2.3.4", p.code(uint64(time.Now().Unix())/30))
+	if resp.Status != http.StatusTooManyRequests || resp.Headers["Retry-After"] == nil {
+		t.Fatalf("code right after a wrong one: %d %v", resp.Status, resp.Headers)
+	}
+}
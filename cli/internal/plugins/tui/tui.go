@@ -0,0 +1,307 @@
+package tui
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/config"
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/plugins/stats"
+)
+
+// ANSI escape sequences used for rendering.
+const (
+	clearScreen = "\033[H\033[2J"
+	hideCursor  = "\033[?25l"
+	showCursor  = "\033[?25h"
+	bold        = "\033[1m"
+	dim         = "\033[2m"
+	red         = "\033[31m"
+	green       = "\033[32m"
+	yellow      = "\033[33m"
+	reset       = "\033[0m"
+)
+
+const (
+	refreshInterval = time.Second
+	recentRequests  = 12
+	logLines        = 6
+)
+
+// tunnelState is the connection status of one tunnel as seen by the TUI.
+type tunnelState struct {
+	port      int
+	connected bool
+	since     time.Time
+	lastErr   string
+	attempt   int       // reconnect attempts since the last drop
+	retryAt   time.Time // when the next reconnect attempt starts
+	expiresAt time.Time // set once -ttl warns
+	renew     bool
+	warmup    *hooks.WarmupResult
+}
+
+// Plugin renders live tunnel stats, recent requests and connection status
+// in the terminal. It reads from the stats Store, so -dashboard-port must be > 0.
+type Plugin struct {
+	enabled bool
+	store   *stats.Store
+	out     io.Writer
+
+	mu      sync.Mutex
+	tunnels map[string]*tunnelState
+	logs    *logBuffer
+	started bool
+	stop    chan struct{}
+}
+
+func New(store *stats.Store) *Plugin {
+	return &Plugin{
+		store:   store,
+		out:     os.Stdout,
+		tunnels: make(map[string]*tunnelState),
+		logs:    &logBuffer{max: logLines},
+		stop:    make(chan struct{}),
+	}
+}
+
+func (p *Plugin) Name() string { return "tui" }
+func (p *Plugin) RegisterFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&p.enabled, "tui", false, "Show a live terminal UI with tunnel status and recent requests (needs -dashboard-port > 0)")
+}
+func (p *Plugin) Enabled() bool                     { return p.enabled }
+func (p *Plugin) WorkerConfig() map[string]any      { return nil }
+func (p *Plugin) RequestHooks() []hooks.RequestHook { return nil }
+func (p *Plugin) ConnectionHooks() []hooks.ConnectionHook {
+	return []hooks.ConnectionHook{&connHook{plugin: p}}
+}
+
+// SubscribeEvents surfaces alerts from other plugins in the log pane.
+func (p *Plugin) SubscribeEvents(bus *hooks.Bus) {
+	hooks.Subscribe(bus, func(e hooks.AlertFired) {
+		if e.Subdomain != "" {
+			log.Printf("[%s] alert on %s: %s", e.Source, e.Subdomain, e.Message)
+			return
+		}
+		log.Printf("[%s] alert: %s", e.Source, e.Message)
+	})
+}
+
+// start takes over the terminal on first connect: log output is captured
+// into the TUI's log pane and the screen is redrawn every second.
+func (p *Plugin) start() {
+	p.mu.Lock()
+	if p.started {
+		p.mu.Unlock()
+		return
+	}
+	p.started = true
+	p.mu.Unlock()
+
+	log.SetOutput(p.logs)
+	fmt.Fprint(p.out, hideCursor)
+	go func() {
+		ticker := time.NewTicker(refreshInterval)
+		defer ticker.Stop()
+		for {
+			p.render()
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop restores the terminal and plain log output. Safe to call if the TUI
+// never started.
+func (p *Plugin) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.started {
+		return
+	}
+	p.started = false
+	close(p.stop)
+	fmt.Fprint(p.out, showCursor)
+	log.SetOutput(os.Stderr)
+}
+
+func (p *Plugin) render() {
+	var b strings.Builder
+	b.WriteString(clearScreen)
+	fmt.Fprintf(&b, "%sprod.bd%s  %s%s%s\n\n", bold, reset, dim, time.Now().Format("15:04:05"), reset)
+
+	// Tunnels
+	snap := map[string]stats.TunnelStats{}
+	for _, ts := range p.store.Snapshot() {
+		snap[ts.Subdomain] = ts
+	}
+	p.mu.Lock()
+	subs := make([]string, 0, len(p.tunnels))
+	for sub := range p.tunnels {
+		subs = append(subs, sub)
+	}
+	sort.Strings(subs)
+	fmt.Fprintf(&b, "%s%-8s %-34s %-6s %9s %7s %9s %10s%s\n", bold, "STATUS", "TUNNEL", "PORT", "UPTIME", "REQS", "ERRORS", "AVG", reset)
+	for _, sub := range subs {
+		st := p.tunnels[sub]
+		status := green + "online " + reset
+		if !st.connected {
+			status = red + "offline" + reset
+		}
+		ts := snap[sub]
+		avg := "-"
+		if ts.TotalRequests > 0 {
+			avg = (ts.TotalLatency / time.Duration(ts.TotalRequests)).Round(time.Millisecond).String()
+		}
+		fmt.Fprintf(&b, "%s  %-34s %-6d %9s %7d %9d %10s\n",
+			status, config.PublicURL(sub), st.port,
+			time.Since(st.since).Round(time.Second), ts.TotalRequests, ts.ErrorCount, avg)
+		if !st.connected && st.lastErr != "" {
+			fmt.Fprintf(&b, "         %s%s%s\n", dim, st.lastErr, reset)
+		}
+		if !st.connected && st.attempt > 0 {
+			wait := max(time.Until(st.retryAt), 0).Round(time.Second)
+			fmt.Fprintf(&b, "         %sretry #%d in %s%s\n", dim, st.attempt, wait, reset)
+		}
+		if st.connected && !st.expiresAt.IsZero() {
+			left := max(time.Until(st.expiresAt), 0).Round(time.Second)
+			if st.renew {
+				fmt.Fprintf(&b, "         %srenewing in %s (-ttl)%s\n", yellow, left, reset)
+			} else {
+				fmt.Fprintf(&b, "         %sexpires in %s (-ttl)%s\n", yellow, left, reset)
+			}
+		}
+		if w := st.warmup; st.connected && w != nil {
+			if w.Err != nil {
+				fmt.Fprintf(&b, "         %swarm-up %s failed: %v%s\n", yellow, w.Path, w.Err, reset)
+			} else {
+				fmt.Fprintf(&b, "         %swarm-up %s: %d in %s, %d conns%s\n", dim, w.Path, w.Status, w.Latency.Round(time.Millisecond), w.Conns, reset)
+			}
+		}
+	}
+	p.mu.Unlock()
+
+	// Recent requests, newest first
+	fmt.Fprintf(&b, "\n%sRECENT REQUESTS%s\n", bold, reset)
+	entries := p.store.RecentLogs(recentRequests)
+	if len(entries) == 0 {
+		fmt.Fprintf(&b, "%swaiting for traffic...%s\n", dim, reset)
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		fmt.Fprintf(&b, "%s %s%3d%s %-7s %-40s %8s  %s\n",
+			e.Timestamp.Format("15:04:05"), statusColor(e.Status), e.Status, reset,
+			e.Method, truncate(e.Path, 40), e.Latency.Round(time.Millisecond), e.Subdomain)
+	}
+
+	// Log pane
+	fmt.Fprintf(&b, "\n%sLOG%s\n", bold, reset)
+	for _, line := range p.logs.Lines() {
+		fmt.Fprintf(&b, "%s%s%s\n", dim, line, reset)
+	}
+	fmt.Fprint(p.out, b.String())
+}
+
+func statusColor(status int) string {
+	switch {
+	case status >= 500:
+		return red
+	case status >= 400:
+		return yellow
+	default:
+		return green
+	}
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-1] + "…"
+}
+
+// logBuffer keeps the last max log lines for the log pane.
+type logBuffer struct {
+	mu    sync.Mutex
+	max   int
+	lines []string
+}
+
+func (l *logBuffer) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		l.lines = append(l.lines, string(line))
+	}
+	if len(l.lines) > l.max {
+		l.lines = l.lines[len(l.lines)-l.max:]
+	}
+	return len(p), nil
+}
+
+func (l *logBuffer) Lines() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.lines...)
+}
+
+// --- Hooks ---
+
+type connHook struct {
+	hooks.NoOpConnectionHook
+	plugin *Plugin
+}
+
+func (h *connHook) OnConnect(subdomain string, port int, warmup *hooks.WarmupResult) {
+	p := h.plugin
+	p.mu.Lock()
+	p.tunnels[subdomain] = &tunnelState{port: port, connected: true, since: time.Now(), warmup: warmup}
+	p.mu.Unlock()
+	p.start()
+}
+
+func (h *connHook) OnRetry(subdomain string, attempt int, delay time.Duration, _ error) {
+	p := h.plugin
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if st, ok := p.tunnels[subdomain]; ok {
+		st.attempt = attempt
+		st.retryAt = time.Now().Add(delay)
+	}
+}
+
+func (h *connHook) OnExpiring(subdomain string, remaining time.Duration, renew bool) {
+	p := h.plugin
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if st, ok := p.tunnels[subdomain]; ok {
+		st.expiresAt = time.Now().Add(remaining)
+		st.renew = renew
+	}
+}
+
+func (h *connHook) OnDisconnect(subdomain string, err error) {
+	p := h.plugin
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st, ok := p.tunnels[subdomain]
+	if !ok {
+		return
+	}
+	st.connected = false
+	st.since = time.Now()
+	if err != nil {
+		st.lastErr = err.Error()
+	}
+}
@@ -0,0 +1,145 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/config"
+)
+
+// githubAPI is the GitHub REST API base URL.
+var githubAPI = "https://api.github.com"
+
+// githubHook points a repository webhook at the tunnel. It reuses a hook
+// that already targets a tunnel URL (left by an earlier session), else
+// creates one.
+type githubHook struct {
+	repo   string // owner/name
+	events []string
+	token  string
+
+	id        int64    // hook being managed; 0 until Point succeeds
+	created   bool     // the hook was created by Point and is deleted on Restore
+	oldURL    string   // the reused hook's URL before Point
+	oldEvents []string // the reused hook's events, if Point changed them
+}
+
+type githubHookJSON struct {
+	ID     int64    `json:"id"`
+	Events []string `json:"events"`
+	Config struct {
+		URL string `json:"url"`
+	} `json:"config"`
+}
+
+func (g *githubHook) String() string { return "github " + g.repo }
+
+func (g *githubHook) Point(ctx context.Context, target string) error {
+	var existing []githubHookJSON
+	if err := g.call(ctx, http.MethodGet, "/hooks", nil, &existing); err != nil {
+		return err
+	}
+	for _, h := range existing {
+		if isTunnelURL(h.Config.URL) {
+			// The config endpoint changes the URL without touching the secret
+			if err := g.call(ctx, http.MethodPatch, fmt.Sprintf("/hooks/%d/config", h.ID), map[string]any{"url": target}, nil); err != nil {
+				return err
+			}
+			g.id, g.oldURL = h.ID, h.Config.URL
+			if !sameEvents(h.Events, g.events) {
+				if err := g.call(ctx, http.MethodPatch, fmt.Sprintf("/hooks/%d", h.ID), map[string]any{"events": g.events}, nil); err != nil {
+					return err
+				}
+				g.oldEvents = h.Events
+			}
+			return nil
+		}
+	}
+
+	var created githubHookJSON
+	err := g.call(ctx, http.MethodPost, "/hooks", map[string]any{
+		"name":   "web",
+		"active": true,
+		"events": g.events,
+		"config": map[string]any{"url": target, "content_type": "json"},
+	}, &created)
+	if err != nil {
+		return err
+	}
+	g.id, g.created = created.ID, true
+	return nil
+}
+
+func (g *githubHook) Restore(ctx context.Context) error {
+	if g.id == 0 {
+		return nil
+	}
+	if g.created {
+		return g.call(ctx, http.MethodDelete, fmt.Sprintf("/hooks/%d", g.id), nil, nil)
+	}
+	if g.oldEvents != nil {
+		if err := g.call(ctx, http.MethodPatch, fmt.Sprintf("/hooks/%d", g.id), map[string]any{"events": g.oldEvents}, nil); err != nil {
+			return err
+		}
+	}
+	return g.call(ctx, http.MethodPatch, fmt.Sprintf("/hooks/%d/config", g.id), map[string]any{"url": g.oldURL}, nil)
+}
+
+// call sends a request for path under the repository and decodes the JSON
+// response into out, if set.
+func (g *githubHook) call(ctx context.Context, method, path string, body, out any) error {
+	var rd io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rd = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, githubAPI+"/repos/"+g.repo+path, rd)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+g.token)
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return fmt.Errorf("%s %s: %s %s", method, path, resp.Status, e.Message)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+func sameEvents(a, b []string) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
+
+// isTunnelURL reports whether u is on a tunnel subdomain.
+func isTunnelURL(u string) bool {
+	parsed, err := url.Parse(u)
+	return err == nil && strings.HasSuffix(parsed.Hostname(), "."+config.PublicDomain)
+}
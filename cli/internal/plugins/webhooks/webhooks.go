@@ -0,0 +1,161 @@
+package webhooks
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/config"
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+)
+
+// apiTimeout bounds pointing or restoring a webhook at a provider.
+const apiTimeout = 15 * time.Second
+
+// provider points one webhook at a URL and later puts it back.
+type provider interface {
+	Point(ctx context.Context, url string) error
+	Restore(ctx context.Context) error
+	String() string
+}
+
+// Plugin points webhooks at providers (-github-hook) to the tunnel's public
+// URL once it connects, and restores them on shutdown, so each session
+// doesn't need the URL pasted into the provider's settings.
+type Plugin struct {
+	githubRepo   string
+	githubEvents string
+	githubToken  string
+	path         string
+
+	mu        sync.Mutex
+	providers []provider
+	subdomain string        // tunnel the hooks point at; set by Registered
+	pointed   chan struct{} // closed once Point has run for every provider
+	started   bool
+	closed    bool
+}
+
+func New() *Plugin {
+	return &Plugin{pointed: make(chan struct{})}
+}
+
+func (p *Plugin) Name() string { return "webhooks" }
+func (p *Plugin) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&p.githubRepo, "github-hook", "", "Point a webhook of this GitHub repo (owner/name) at the tunnel while it runs, restoring it on exit")
+	fs.StringVar(&p.githubEvents, "github-hook-events", "push", "Comma-separated GitHub events for -github-hook")
+	fs.StringVar(&p.githubToken, "github-token", "", "GitHub token with admin:repo_hook scope for -github-hook (default $GITHUB_TOKEN)")
+	fs.StringVar(&p.path, "hook-path", "", "Path appended to the tunnel URL for webhooks, e.g. /webhooks/github")
+}
+func (p *Plugin) Enabled() bool                     { return p.githubRepo != "" }
+func (p *Plugin) WorkerConfig() map[string]any      { return nil }
+func (p *Plugin) RequestHooks() []hooks.RequestHook { return nil }
+func (p *Plugin) ConnectionHooks() []hooks.ConnectionHook {
+	return []hooks.ConnectionHook{&connHook{plugin: p}}
+}
+
+// Validate checks the flags and builds the providers. Call after flags are
+// parsed, before registering.
+func (p *Plugin) Validate() error {
+	if !p.Enabled() {
+		return nil
+	}
+	if owner, name, ok := strings.Cut(p.githubRepo, "/"); !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+		return fmt.Errorf("-github-hook must be owner/name, got %q", p.githubRepo)
+	}
+	if p.path != "" && !strings.HasPrefix(p.path, "/") {
+		return fmt.Errorf("-hook-path must start with /")
+	}
+	token := p.githubToken
+	if token == "" {
+		token = os.Getenv("GITHUB_TOKEN")
+	}
+	if token == "" {
+		return fmt.Errorf("-github-hook needs a token: set -github-token or GITHUB_TOKEN")
+	}
+	var events []string
+	for _, e := range strings.Split(p.githubEvents, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			events = append(events, e)
+		}
+	}
+	if len(events) == 0 {
+		return fmt.Errorf("-github-hook-events is empty")
+	}
+	p.providers = append(p.providers, &githubHook{repo: p.githubRepo, events: events, token: token})
+	return nil
+}
+
+// Registered picks the tunnel the webhooks point at: the lowest registered
+// port.
+func (p *Plugin) Registered(mapping map[int]string) {
+	if !p.Enabled() || len(mapping) == 0 {
+		return
+	}
+	ports := make([]int, 0, len(mapping))
+	for port := range mapping {
+		ports = append(ports, port)
+	}
+	p.mu.Lock()
+	p.subdomain = mapping[slices.Min(ports)]
+	p.mu.Unlock()
+}
+
+// point runs once, when the chosen tunnel first connects.
+func (p *Plugin) point(subdomain string) {
+	p.mu.Lock()
+	if p.started || p.closed || subdomain != p.subdomain {
+		p.mu.Unlock()
+		return
+	}
+	p.started = true
+	p.mu.Unlock()
+
+	defer close(p.pointed)
+	target := config.PublicURL(subdomain) + p.path
+	for _, pr := range p.providers {
+		ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+		if err := pr.Point(ctx, target); err != nil {
+			log.Printf("[webhooks] %s: %v", pr, err)
+		} else {
+			log.Printf("[webhooks] %s -> %s", pr, target)
+		}
+		cancel()
+	}
+}
+
+// Close restores the webhooks pointed at the tunnel.
+func (p *Plugin) Close() {
+	p.mu.Lock()
+	started := p.started
+	p.closed = true
+	p.mu.Unlock()
+	if !started {
+		return
+	}
+	<-p.pointed
+	for _, pr := range p.providers {
+		ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+		if err := pr.Restore(ctx); err != nil {
+			log.Printf("[webhooks] restoring %s: %v", pr, err)
+		} else {
+			log.Printf("[webhooks] %s restored", pr)
+		}
+		cancel()
+	}
+}
+
+type connHook struct {
+	hooks.NoOpConnectionHook
+	plugin *Plugin
+}
+
+func (h *connHook) OnConnect(subdomain string, _ int, _ *hooks.WarmupResult) {
+	go h.plugin.point(subdomain)
+}
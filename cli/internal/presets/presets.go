@@ -0,0 +1,85 @@
+package presets
+
+import (
+	"errors"
+	"flag"
+	"slices"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/config"
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+)
+
+// InternalDemo locks a tunnel down for showing unreleased work to colleagues:
+// not indexed, visibly marked as a preview, no framework fingerprinting
+// headers, closed after 8 hours, and never without sign-in through the
+// company's identity provider (-oauth-provider, or -jwt-secret or
+// -jwt-public-key for tokens from a SAML or OIDC gateway). Other auth
+// schemes can be stacked on top with -access.
+//
+// When the config file points at self-hosted workers, the demo goes through
+// them: the nearest region unless -region picks one, with a client
+// certificate for their mTLS edge.
+var InternalDemo = hooks.Preset{
+	Name:        "internal-demo",
+	Description: "noindex, preview banner, closes after 8h, stripped server headers; requires SSO (-oauth-provider, -jwt-secret or -jwt-public-key), and mTLS with self-hosted workers",
+	Flags: map[string]string{
+		"noindex":        "true",
+		"banner":         "true",
+		"banner-text":    "Internal demo — confidential, not production",
+		"banner-expires": "8h",
+		"ttl":            "8h",
+		"strip-header":   "Server,X-Powered-By,X-AspNet-Version,X-AspNetMvc-Version,X-SourceFiles,X-Debug-Token,X-Debug-Token-Link",
+		"public-stats":   "false",
+	},
+	Validate: func(fs *flag.FlagSet) error {
+		if !slices.ContainsFunc(ssoFlags, func(name string) bool { return flagSet(fs, name) }) {
+			return errors.New("sign-in through your identity provider is required: set -oauth-provider, -jwt-secret or -jwt-public-key")
+		}
+		if f := fs.Lookup("ttl"); f != nil && f.Value.String() == "0s" {
+			return errors.New("an internal demo must expire; -ttl can't be 0")
+		}
+		return corporateRelay(fs)
+	},
+}
+
+// ssoFlags turn on sign-in through an identity provider.
+var ssoFlags = []string{"oauth-provider", "jwt-secret", "jwt-public-key"}
+
+// corporateRelay sends the tunnel through the self-hosted workers the config
+// file names, if any: the lowest-latency region unless -region is set, and
+// only with a client certificate.
+func corporateRelay(fs *flag.FlagSet) error {
+	file, err := config.LoadFile()
+	if err != nil {
+		return err
+	}
+	if len(file.Regions) == 0 && config.GetWorkerURL() == config.DefaultWorkerURL {
+		return nil
+	}
+	if len(file.Regions) > 0 && !flagSet(fs, "region") {
+		if err := fs.Set("region", "auto"); err != nil {
+			return err
+		}
+	}
+	if !flagSet(fs, "worker-cert") || !flagSet(fs, "worker-key") {
+		return errors.New("self-hosted workers need a client certificate: set -worker-cert and -worker-key")
+	}
+	return nil
+}
+
+// All lists the built-in presets.
+var All = []hooks.Preset{InternalDemo}
+
+// flagSet reports whether flag name turns something on: it has a value,
+// and for a bool flag that value isn't false.
+func flagSet(fs *flag.FlagSet, name string) bool {
+	f := fs.Lookup(name)
+	if f == nil {
+		return false
+	}
+	v := f.Value.String()
+	if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+		return v != "" && v != "false"
+	}
+	return v != ""
+}
@@ -0,0 +1,103 @@
+package presets
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// demoFlagSet declares the flags internal-demo reads the way prod does.
+func demoFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("prod", flag.ContinueOnError)
+	for _, name := range []string{"auth", "oauth-provider", "jwt-secret", "jwt-public-key", "region", "worker-cert", "worker-key"} {
+		fs.String(name, "", "")
+	}
+	fs.Bool("magic-links", false, "")
+	fs.Duration("ttl", 0, "")
+	return fs
+}
+
+// home gives the test an empty home directory, optionally with a config
+// file, and the default worker.
+func home(t *testing.T, configFile string) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("WORKER_URL", "")
+	if configFile != "" {
+		os.MkdirAll(filepath.Join(dir, ".prod"), 0700)
+		if err := os.WriteFile(filepath.Join(dir, ".prod", "config.yaml"), []byte(configFile), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func validate(t *testing.T, args ...string) (*flag.FlagSet, error) {
+	t.Helper()
+	fs := demoFlagSet()
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	for name, v := range InternalDemo.Flags {
+		if fs.Lookup(name) != nil {
+			fs.Set(name, v)
+		}
+	}
+	return fs, InternalDemo.Validate(fs)
+}
+
+func TestInternalDemoNeedsSSO(t *testing.T) {
+	home(t, "")
+	tests := []struct {
+		args []string
+		ok   bool
+	}{
+		{nil, false},
+		{[]string{"-auth", "team:secret"}, false},
+		{[]string{"-magic-links"}, false},
+		{[]string{"-oauth-provider", ""}, false},
+		{[]string{"-oauth-provider", "google"}, true},
+		{[]string{"-jwt-public-key", "idp.pem"}, true},
+	}
+	for _, tt := range tests {
+		if _, err := validate(t, tt.args...); (err == nil) != tt.ok {
+			t.Errorf("%v: %v", tt.args, err)
+		}
+	}
+}
+
+func TestInternalDemoExpires(t *testing.T) {
+	home(t, "")
+	fs, err := validate(t, "-oauth-provider", "google")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fs.Lookup("ttl").Value.(flag.Getter).Get().(time.Duration); got != 8*time.Hour {
+		t.Errorf("ttl %v, want 8h", got)
+	}
+	fs = demoFlagSet()
+	fs.Parse([]string{"-oauth-provider", "google", "-ttl", "0"})
+	if err := InternalDemo.Validate(fs); err == nil {
+		t.Error("-ttl 0 accepted")
+	}
+}
+
+func TestInternalDemoCorporateRelay(t *testing.T) {
+	home(t, "regions:\n  eu: https://eu.tunnel.corp.example\n  us: https://us.tunnel.corp.example\n")
+	if _, err := validate(t, "-oauth-provider", "google"); err == nil {
+		t.Fatal("self-hosted workers accepted without a client certificate")
+	}
+	fs, err := validate(t, "-oauth-provider", "google", "-worker-cert", "c.pem", "-worker-key", "k.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fs.Lookup("region").Value.String(); got != "auto" {
+		t.Errorf("region %q, want auto", got)
+	}
+	fs, _ = validate(t, "-oauth-provider", "google", "-worker-cert", "c.pem", "-worker-key", "k.pem", "-region", "eu")
+	if got := fs.Lookup("region").Value.String(); got != "eu" {
+		t.Errorf("region %q, want the one asked for", got)
+	}
+}
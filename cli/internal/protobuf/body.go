@@ -0,0 +1,155 @@
+package protobuf
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+	"sync/atomic"
+)
+
+// active is the registry loaded from -proto; nil leaves bodies alone.
+var active atomic.Pointer[Registry]
+
+// Use makes r the registry DecodeBody uses.
+func Use(r *Registry) { active.Store(r) }
+
+// SetTypes binds plain (non-gRPC) protobuf bodies to message types by path,
+// from comma-separated path=pkg.Message pairs. Paths are path.Match globs;
+// /dir/* also matches below dir. Bodies on bound paths are decoded whatever
+// their Content-Type.
+func (r *Registry) SetTypes(spec string) error {
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		pattern, message, ok := strings.Cut(part, "=")
+		if !ok || !strings.HasPrefix(pattern, "/") {
+			return fmt.Errorf("%q is not /path=pkg.Message", part)
+		}
+		if _, err := path.Match(pattern, "/"); err != nil {
+			return fmt.Errorf("bad path pattern %q", pattern)
+		}
+		message = strings.TrimPrefix(message, ".")
+		if _, ok := r.Messages[message]; !ok {
+			return fmt.Errorf("unknown message %s", message)
+		}
+		r.types = append(r.types, pathType{pattern: pattern, message: message})
+	}
+	return nil
+}
+
+// boundType returns the -proto-types message for reqPath, if any.
+func (r *Registry) boundType(reqPath string) (string, bool) {
+	for _, t := range r.types {
+		if ok, _ := path.Match(t.pattern, reqPath); ok {
+			return t.message, true
+		}
+		if dir, ok := strings.CutSuffix(t.pattern, "*"); ok && strings.HasSuffix(dir, "/") && strings.HasPrefix(reqPath, dir) {
+			return t.message, true
+		}
+	}
+	return "", false
+}
+
+// DecodeBody renders a protobuf or gRPC-web request or response body as
+// indented JSON, with the message type it was decoded as ("" if decoded by
+// field number). ok is false if no -proto files are loaded, or the body isn't
+// protobuf or doesn't parse. headers are those of the body's own message.
+func DecodeBody(reqPath string, headers map[string][]string, body []byte, response bool) (text, message string, ok bool) {
+	r := active.Load()
+	if r == nil || len(body) == 0 {
+		return "", "", false
+	}
+	reqPath, _, _ = strings.Cut(reqPath, "?")
+	mediaType, params, _ := mime.ParseMediaType(header(headers, "Content-Type"))
+
+	var v any
+	var err error
+	switch {
+	case strings.HasPrefix(mediaType, "application/grpc"):
+		if m, ok := r.Methods[reqPath]; ok {
+			message = m.Input
+			if response {
+				message = m.Output
+			}
+		}
+		if strings.HasPrefix(mediaType, "application/grpc-web-text") {
+			if body, err = base64.StdEncoding.DecodeString(string(body)); err != nil {
+				return "", "", false
+			}
+		}
+		v, err = r.decodeFrames(body, message)
+	default:
+		bound, isBound := r.boundType(reqPath)
+		if !isBound && !strings.Contains(mediaType, "protobuf") {
+			return "", "", false
+		}
+		message = bound
+		for _, key := range []string{"messagetype", "proto", "type"} {
+			if t := strings.TrimPrefix(params[key], "."); t != "" {
+				message = t
+				break
+			}
+		}
+		v, err = r.Decode(body, message)
+	}
+	if err != nil {
+		return "", "", false
+	}
+	if _, known := r.Messages[message]; !known {
+		message = ""
+	}
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return "", "", false
+	}
+	return string(out), message, true
+}
+
+// decodeFrames decodes a gRPC(-web) body: length-prefixed messages, then in
+// gRPC-web an optional trailers frame. One message decodes to itself, more
+// to an array; trailers are added as {"trailers": "..."}.
+func (r *Registry) decodeFrames(body []byte, message string) (any, error) {
+	var frames []any
+	for len(body) > 0 {
+		if len(body) < 5 {
+			return nil, errTruncated
+		}
+		flags, n := body[0], binary.BigEndian.Uint32(body[1:5])
+		if uint64(len(body)-5) < uint64(n) {
+			return nil, errTruncated
+		}
+		payload := body[5 : 5+n]
+		body = body[5+n:]
+		switch {
+		case flags&0x80 != 0:
+			frames = append(frames, object{{"trailers", strings.TrimSpace(string(payload))}})
+		case flags&0x01 != 0:
+			frames = append(frames, object{{"compressed", base64.StdEncoding.EncodeToString(payload)}})
+		default:
+			v, err := r.Decode(payload, message)
+			if err != nil {
+				return nil, err
+			}
+			frames = append(frames, v)
+		}
+	}
+	if len(frames) == 1 {
+		return frames[0], nil
+	}
+	return frames, nil
+}
+
+func header(h map[string][]string, name string) string {
+	for k, vs := range h {
+		if strings.EqualFold(k, name) && len(vs) > 0 {
+			return vs[0]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,383 @@
+package protobuf
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"unicode/utf8"
+)
+
+// maxDepth bounds nesting when guessing whether unknown bytes are a message.
+const maxDepth = 32
+
+var errTruncated = errors.New("truncated protobuf")
+
+// Wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireStart   = 3 // proto2 group start
+	wireEnd     = 4 // proto2 group end
+	wireFixed32 = 5
+)
+
+// member is one key of an object; object keeps keys in wire order.
+type member struct {
+	key   string
+	value any
+}
+
+type object []member
+
+func (o object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, m := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(m.key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		v, err := json.Marshal(m.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// set sets key, appending to its array if the field is repeated.
+func (o *object) set(key string, v any, repeated bool) {
+	for i := range *o {
+		if (*o)[i].key != key {
+			continue
+		}
+		if arr, ok := (*o)[i].value.([]any); ok && repeated {
+			if run, ok := v.([]any); ok {
+				// another packed run
+				(*o)[i].value = append(arr, run...)
+			} else {
+				(*o)[i].value = append(arr, v)
+			}
+		} else {
+			// last one wins for singular fields, like protobuf itself
+			(*o)[i].value = v
+		}
+		return
+	}
+	if repeated {
+		if arr, ok := v.([]any); ok {
+			*o = append(*o, member{key, arr})
+			return
+		}
+		v = []any{v}
+	}
+	*o = append(*o, member{key, v})
+}
+
+// add sets key, turning it into an array if it repeats. Fields without a
+// definition may be repeated or not; only a second occurrence tells.
+func (o *object) add(key string, v any) {
+	for i := range *o {
+		if (*o)[i].key == key {
+			if arr, ok := (*o)[i].value.([]any); ok {
+				(*o)[i].value = append(arr, v)
+			} else {
+				(*o)[i].value = []any{(*o)[i].value, v}
+			}
+			return
+		}
+	}
+	*o = append(*o, member{key, v})
+}
+
+// Decode decodes a message of the named type into an ordered JSON value.
+// Field names, enum names and nested types come from the registry; fields it
+// doesn't know are keyed by number. An empty or unknown type name decodes
+// everything by number.
+func (r *Registry) Decode(data []byte, message string) (any, error) {
+	var m *Message
+	if r != nil {
+		m = r.Messages[message]
+	}
+	return r.decodeMessage(data, m, 0)
+}
+
+func (r *Registry) decodeMessage(data []byte, m *Message, depth int) (object, error) {
+	if depth > maxDepth {
+		return nil, errors.New("protobuf nested too deeply")
+	}
+	out := object{}
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errTruncated
+		}
+		data = data[n:]
+		num, wire := int(tag>>3), int(tag&7)
+		if num == 0 {
+			return nil, errors.New("invalid field number 0")
+		}
+		var raw []byte
+		var val uint64
+		switch wire {
+		case wireVarint:
+			if val, n = binary.Uvarint(data); n <= 0 {
+				return nil, errTruncated
+			}
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return nil, errTruncated
+			}
+			val, data = binary.LittleEndian.Uint64(data), data[8:]
+		case wireFixed32:
+			if len(data) < 4 {
+				return nil, errTruncated
+			}
+			val, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		case wireBytes:
+			l, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < l {
+				return nil, errTruncated
+			}
+			raw, data = data[n:n+int(l)], data[n+int(l):]
+		case wireStart, wireEnd:
+			return nil, errors.New("protobuf groups are not supported")
+		default:
+			return nil, fmt.Errorf("invalid wire type %d", wire)
+		}
+
+		var f *Field
+		if m != nil {
+			f = m.Fields[num]
+		}
+		if f == nil {
+			out.add(strconv.Itoa(num), r.unknown(wire, val, raw, depth))
+			continue
+		}
+		if f.Map {
+			entry, err := r.decodeMapEntry(raw, f, depth)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", f.Name, err)
+			}
+			merged := false
+			for i := range out {
+				if out[i].key == f.Name {
+					obj := out[i].value.(object)
+					obj.set(entry.key, entry.value, false)
+					out[i].value = obj
+					merged = true
+				}
+			}
+			if !merged {
+				out = append(out, member{f.Name, object{entry}})
+			}
+			continue
+		}
+		v, err := r.value(f.Type, wire, val, raw, f.Repeated, depth)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		out.set(f.Name, v, f.Repeated)
+	}
+	return out, nil
+}
+
+// decodeMapEntry decodes one map<K, V> entry (key = 1, value = 2).
+func (r *Registry) decodeMapEntry(data []byte, f *Field, depth int) (member, error) {
+	entry := &Message{Fields: map[int]*Field{
+		1: {Name: "key", Number: 1, Type: f.Key},
+		2: {Name: "value", Number: 2, Type: f.Type},
+	}}
+	obj, err := r.decodeMessage(data, entry, depth+1)
+	if err != nil {
+		return member{}, err
+	}
+	var m member
+	for _, kv := range obj {
+		if kv.key == "key" {
+			m.key = fmt.Sprint(kv.value)
+		} else {
+			m.value = kv.value
+		}
+	}
+	return m, nil
+}
+
+// value decodes one field value of a known type. Packed repeated scalars
+// arrive as one length-delimited run and come back as a slice.
+func (r *Registry) value(typ string, wire int, val uint64, raw []byte, repeated bool, depth int) (any, error) {
+	if wire == wireBytes && repeated && isPackable(typ, r) {
+		var out []any
+		for len(raw) > 0 {
+			var v uint64
+			switch scalarWire(typ, r) {
+			case wireFixed64:
+				if len(raw) < 8 {
+					return nil, errTruncated
+				}
+				v, raw = binary.LittleEndian.Uint64(raw), raw[8:]
+			case wireFixed32:
+				if len(raw) < 4 {
+					return nil, errTruncated
+				}
+				v, raw = uint64(binary.LittleEndian.Uint32(raw)), raw[4:]
+			default:
+				var n int
+				if v, n = binary.Uvarint(raw); n <= 0 {
+					return nil, errTruncated
+				}
+				raw = raw[n:]
+			}
+			out = append(out, r.scalar(typ, v))
+		}
+		return out, nil
+	}
+
+	switch typ {
+	case "string", "bytes":
+		if wire != wireBytes {
+			return nil, fmt.Errorf("expected %s, got wire type %d", typ, wire)
+		}
+		if typ == "bytes" {
+			return base64.StdEncoding.EncodeToString(raw), nil
+		}
+		return string(raw), nil
+	}
+	if m, ok := r.Messages[typ]; ok {
+		if wire != wireBytes {
+			return nil, fmt.Errorf("expected a message, got wire type %d", wire)
+		}
+		return r.decodeMessage(raw, m, depth+1)
+	}
+	if wire == wireBytes {
+		if isScalar(typ) {
+			return nil, fmt.Errorf("expected %s, got length-delimited data", typ)
+		}
+		// A type from a missing import: decode it by number
+		return r.unknown(wire, 0, raw, depth), nil
+	}
+	return r.scalar(typ, val), nil
+}
+
+// scalar converts a varint or fixed value to its JSON form. 64-bit integers
+// are strings, as in protobuf's JSON mapping.
+func (r *Registry) scalar(typ string, v uint64) any {
+	switch typ {
+	case "int32":
+		return int32(v)
+	case "int64":
+		return strconv.FormatInt(int64(v), 10)
+	case "uint32", "fixed32":
+		return uint32(v)
+	case "uint64", "fixed64":
+		return strconv.FormatUint(v, 10)
+	case "sint32":
+		return int32(uint32(v)>>1) ^ -int32(v&1)
+	case "sint64":
+		return strconv.FormatInt(int64(v>>1)^-int64(v&1), 10)
+	case "sfixed32":
+		return int32(uint32(v))
+	case "sfixed64":
+		return strconv.FormatInt(int64(v), 10)
+	case "bool":
+		return v != 0
+	case "float":
+		return jsonFloat(float64(math.Float32frombits(uint32(v))))
+	case "double":
+		return jsonFloat(math.Float64frombits(v))
+	}
+	if e, ok := r.Enums[typ]; ok {
+		if name, ok := e.Values[int(int32(v))]; ok {
+			return name
+		}
+		return int32(v)
+	}
+	return v
+}
+
+// jsonFloat keeps NaN and infinities, which JSON numbers can't hold, as
+// strings.
+func jsonFloat(f float64) any {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return f
+}
+
+// unknown decodes a field without a definition. Length-delimited data is
+// shown as a nested message if it parses as one, else as text if it's
+// printable, else as base64.
+func (r *Registry) unknown(wire int, val uint64, raw []byte, depth int) any {
+	switch wire {
+	case wireVarint:
+		return val
+	case wireFixed32:
+		return uint32(val)
+	case wireBytes:
+		if len(raw) > 0 && !printable(raw) {
+			if obj, err := r.decodeMessage(raw, nil, depth+1); err == nil {
+				return obj
+			}
+		}
+		if printable(raw) {
+			return string(raw)
+		}
+		return base64.StdEncoding.EncodeToString(raw)
+	}
+	return val
+}
+
+// printable reports whether b looks like text, so "abc" isn't mistaken for
+// a message that happens to parse.
+func printable(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if r < 0x20 && r != '\n' && r != '\r' && r != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+var scalars = map[string]int{
+	"double": wireFixed64, "fixed64": wireFixed64, "sfixed64": wireFixed64,
+	"float": wireFixed32, "fixed32": wireFixed32, "sfixed32": wireFixed32,
+	"int32": wireVarint, "int64": wireVarint, "uint32": wireVarint, "uint64": wireVarint,
+	"sint32": wireVarint, "sint64": wireVarint, "bool": wireVarint,
+	"string": wireBytes, "bytes": wireBytes,
+}
+
+func isScalar(typ string) bool {
+	_, ok := scalars[typ]
+	return ok
+}
+
+// isPackable reports whether repeated fields of typ may be packed: numeric
+// scalars and enums.
+func isPackable(typ string, r *Registry) bool {
+	if w, ok := scalars[typ]; ok {
+		return w != wireBytes
+	}
+	_, ok := r.Enums[typ]
+	return ok
+}
+
+func scalarWire(typ string, r *Registry) int {
+	if w, ok := scalars[typ]; ok {
+		return w
+	}
+	return wireVarint // enums
+}
@@ -0,0 +1,497 @@
+// Package protobuf decodes protobuf and gRPC-web bodies into readable JSON
+// for the dashboard, the inspector and exports, using message definitions
+// parsed from user-supplied .proto files. Without a matching definition,
+// fields are decoded by number from the wire format alone.
+package protobuf
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Message is a parsed message definition.
+type Message struct {
+	Name   string // fully qualified, e.g. shop.v1.Item
+	Fields map[int]*Field
+}
+
+// Field is one message field.
+type Field struct {
+	Name     string
+	Number   int
+	Type     string // scalar type name, or the fully qualified message/enum name once resolved
+	Repeated bool
+	Map      bool   // map<Key, Value>: Type is the Value type
+	Key      string // map key type
+	scope    string // where Type is resolved from
+}
+
+// Enum is a parsed enum definition.
+type Enum struct {
+	Name   string
+	Values map[int]string
+}
+
+// Method is a service RPC, keyed by its gRPC path (/pkg.Service/Method).
+type Method struct {
+	Input  string
+	Output string
+}
+
+// Registry holds the definitions from a set of .proto files.
+type Registry struct {
+	Messages map[string]*Message
+	Enums    map[string]*Enum
+	Methods  map[string]Method // keyed by /pkg.Service/Method
+
+	types []pathType // -proto-types bindings
+}
+
+// pathType binds plain protobuf bodies on a path to a message type.
+type pathType struct {
+	pattern string
+	message string
+}
+
+// Load parses the .proto files (directories are searched for *.proto) and
+// resolves their type references. Imports are read from the importing file's
+// directory or any of the given directories; ones that can't be found, such
+// as google/protobuf well-known types, leave their fields decoded by number.
+func Load(paths []string) (*Registry, error) {
+	r := &Registry{
+		Messages: make(map[string]*Message),
+		Enums:    make(map[string]*Enum),
+		Methods:  make(map[string]Method),
+	}
+	var files, dirs []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+			dirs = append(dirs, filepath.Dir(p))
+			continue
+		}
+		dirs = append(dirs, p)
+		err = filepath.WalkDir(p, func(path string, d os.DirEntry, err error) error {
+			if err == nil && !d.IsDir() && strings.HasSuffix(path, ".proto") {
+				files = append(files, path)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no .proto files in %s", strings.Join(paths, ", "))
+	}
+
+	seen := make(map[string]bool)
+	var load func(file string) error
+	load = func(file string) error {
+		abs, _ := filepath.Abs(file)
+		if seen[abs] {
+			return nil
+		}
+		seen[abs] = true
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		imports, err := r.parse(string(data))
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		for _, imp := range imports {
+			for _, dir := range append([]string{filepath.Dir(file)}, dirs...) {
+				candidate := filepath.Join(dir, imp)
+				if _, err := os.Stat(candidate); err == nil {
+					if err := load(candidate); err != nil {
+						return err
+					}
+					break
+				}
+			}
+		}
+		return nil
+	}
+	for _, f := range files {
+		if err := load(f); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, m := range r.Messages {
+		for _, f := range m.Fields {
+			if !isScalar(f.Type) {
+				f.Type = r.resolve(f.Type, f.scope)
+			}
+		}
+	}
+	for path, m := range r.Methods {
+		scope := strings.TrimPrefix(path[:strings.LastIndex(path, "/")], "/")
+		m.Input = r.resolve(m.Input, scope)
+		m.Output = r.resolve(m.Output, scope)
+		r.Methods[path] = m
+	}
+	return r, nil
+}
+
+// resolve finds the fully qualified name of a type referenced from scope,
+// following protobuf's innermost-first lookup. Unknown names are returned
+// as they are.
+func (r *Registry) resolve(name, scope string) string {
+	if full, ok := strings.CutPrefix(name, "."); ok {
+		return full
+	}
+	for {
+		candidate := name
+		if scope != "" {
+			candidate = scope + "." + name
+		}
+		if _, ok := r.Messages[candidate]; ok {
+			return candidate
+		}
+		if _, ok := r.Enums[candidate]; ok {
+			return candidate
+		}
+		if scope == "" {
+			return name
+		}
+		if i := strings.LastIndex(scope, "."); i >= 0 {
+			scope = scope[:i]
+		} else {
+			scope = ""
+		}
+	}
+}
+
+// --- Parser ---
+
+// parser reads one .proto file. Options, reserved ranges, extensions and
+// proto2 groups are skipped; they don't change how fields decode.
+type parser struct {
+	toks []string
+	pos  int
+	r    *Registry
+	pkg  string
+}
+
+func (r *Registry) parse(src string) (imports []string, err error) {
+	toks, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks, r: r}
+	for !p.done() {
+		switch tok := p.next(); tok {
+		case ";":
+		case "syntax", "edition", "option":
+			p.skipStatement()
+		case "package":
+			p.pkg = p.next()
+			p.expect(";")
+		case "import":
+			if p.peek() == "public" || p.peek() == "weak" {
+				p.next()
+			}
+			imports = append(imports, unquote(p.next()))
+			p.expect(";")
+		case "message":
+			p.message(p.pkg)
+		case "enum":
+			p.enum(p.pkg)
+		case "service":
+			p.service()
+		case "extend":
+			p.next()
+			p.skipBlock()
+		default:
+			return nil, fmt.Errorf("unexpected %q", tok)
+		}
+	}
+	if p.pos > len(p.toks) {
+		return nil, fmt.Errorf("unexpected end of file")
+	}
+	return imports, nil
+}
+
+func (p *parser) message(scope string) {
+	name := qualify(scope, p.next())
+	m := &Message{Name: name, Fields: make(map[int]*Field)}
+	p.r.Messages[name] = m
+	p.expect("{")
+	for !p.done() && p.peek() != "}" {
+		switch tok := p.peek(); tok {
+		case ";":
+			p.next()
+		case "message":
+			p.next()
+			p.message(name)
+		case "enum":
+			p.next()
+			p.enum(name)
+		case "option", "reserved", "extensions":
+			p.skipStatement()
+		case "extend":
+			p.next()
+			p.next()
+			p.skipBlock()
+		case "oneof":
+			p.next()
+			p.next()
+			p.expect("{")
+			for !p.done() && p.peek() != "}" {
+				if p.peek() == "option" {
+					p.skipStatement()
+					continue
+				}
+				p.field(m, name)
+			}
+			p.expect("}")
+		default:
+			p.field(m, name)
+		}
+	}
+	p.expect("}")
+}
+
+func (p *parser) field(m *Message, scope string) {
+	f := &Field{scope: scope}
+	switch p.peek() {
+	case "repeated":
+		f.Repeated = true
+		p.next()
+	case "optional", "required":
+		p.next()
+	}
+	typ := p.next()
+	if typ == "map" {
+		p.expect("<")
+		f.Map = true
+		f.Key = p.next()
+		p.expect(",")
+		typ = p.next()
+		p.expect(">")
+	}
+	f.Type = typ
+	f.Name = p.next()
+	if typ == "group" {
+		// proto2 group: the name is followed by = N { ... }; skipped
+		p.expect("=")
+		p.next()
+		p.skipBlock()
+		return
+	}
+	p.expect("=")
+	f.Number, _ = strconv.Atoi(p.next())
+	p.skipStatement()
+	m.Fields[f.Number] = f
+}
+
+func (p *parser) enum(scope string) {
+	e := &Enum{Name: qualify(scope, p.next()), Values: make(map[int]string)}
+	p.r.Enums[e.Name] = e
+	p.expect("{")
+	for !p.done() && p.peek() != "}" {
+		switch tok := p.next(); tok {
+		case ";":
+		case "option", "reserved":
+			p.pos--
+			p.skipStatement()
+		default:
+			p.expect("=")
+			n := p.next()
+			if n == "-" {
+				n += p.next()
+			}
+			if v, err := strconv.ParseInt(n, 0, 32); err == nil {
+				if _, dup := e.Values[int(v)]; !dup {
+					// allow_alias: the first name wins
+					e.Values[int(v)] = tok
+				}
+			}
+			p.skipStatement()
+		}
+	}
+	p.expect("}")
+}
+
+func (p *parser) service() {
+	name := qualify(p.pkg, p.next())
+	p.expect("{")
+	for !p.done() && p.peek() != "}" {
+		switch tok := p.next(); tok {
+		case ";":
+		case "option":
+			p.pos--
+			p.skipStatement()
+		case "rpc":
+			method := p.next()
+			var types [2]string
+			for i := range types {
+				if i == 1 {
+					p.expect("returns")
+				}
+				p.expect("(")
+				if p.peek() == "stream" {
+					p.next()
+				}
+				types[i] = p.next()
+				p.expect(")")
+			}
+			p.r.Methods["/"+name+"/"+method] = Method{Input: types[0], Output: types[1]}
+			if p.peek() == "{" {
+				p.skipBlock()
+			} else {
+				p.expect(";")
+			}
+		default:
+			p.pos--
+			p.skipStatement()
+		}
+	}
+	p.expect("}")
+}
+
+func (p *parser) done() bool { return p.pos >= len(p.toks) }
+
+func (p *parser) peek() string {
+	if p.done() {
+		return ""
+	}
+	return p.toks[p.pos]
+}
+
+// next returns the next token. Past the end it returns "" and keeps
+// counting, so parse can report the truncation.
+func (p *parser) next() string {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+// expect consumes tok if it's next. Mismatches resynchronize at the next
+// statement rather than failing, as unknown syntax is usually an option.
+func (p *parser) expect(tok string) {
+	if p.peek() == tok {
+		p.pos++
+		return
+	}
+	if tok != "}" {
+		p.skipStatement()
+	}
+}
+
+// skipStatement skips to just past the next ; at this nesting level, or
+// past a { } block if one comes first.
+func (p *parser) skipStatement() {
+	depth := 0
+	for !p.done() {
+		switch p.next() {
+		case "{", "[", "(":
+			depth++
+		case "]", ")":
+			depth--
+		case "}":
+			depth--
+			if depth <= 0 {
+				return
+			}
+		case ";":
+			if depth == 0 {
+				return
+			}
+		}
+	}
+}
+
+// skipBlock skips a { ... } block, the { included.
+func (p *parser) skipBlock() {
+	for !p.done() && p.peek() != "{" {
+		p.next()
+	}
+	depth := 0
+	for !p.done() {
+		switch p.next() {
+		case "{":
+			depth++
+		case "}":
+			if depth--; depth == 0 {
+				return
+			}
+		}
+	}
+}
+
+func qualify(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
+
+func unquote(s string) string {
+	if u, err := strconv.Unquote(s); err == nil {
+		return u
+	}
+	return strings.Trim(s, `"'`)
+}
+
+// tokenize splits .proto source into identifiers (dotted names included),
+// numbers, quoted strings and single-character symbols, dropping comments.
+func tokenize(src string) ([]string, error) {
+	var toks []string
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case unicode.IsSpace(rune(c)):
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated comment")
+			}
+			i += end + 4
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(src) && src[j] != c {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			toks = append(toks, src[i:j+1])
+			i = j + 1
+		case isWordByte(c):
+			j := i
+			for j < len(src) && isWordByte(src[j]) {
+				j++
+			}
+			toks = append(toks, src[i:j])
+			i = j
+		default:
+			toks = append(toks, string(c))
+			i++
+		}
+	}
+	return toks, nil
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '.' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+// coalesce enables single-flight GETs. Set with SetCoalesce before the first request.
+var coalesce bool
+
+// SetCoalesce makes identical concurrent GETs share one local request: the
+// first one is proxied and the others wait for its response. Streamed
+// responses and ones that set cookies aren't shared; waiting requests are
+// then proxied on their own.
+func SetCoalesce(on bool) { coalesce = on }
+
+// coalesceHeaders are the request headers that can change the response, so
+// requests differing in any of them are never coalesced.
+var coalesceHeaders = []string{"Accept", "Accept-Language", "Authorization", "Cookie", "Range"}
+
+// flight is one local GET that identical requests are waiting on.
+type flight struct {
+	done   chan struct{}
+	resp   types.TunnelResponse // before response hooks; ID unset
+	shared bool                 // resp may be handed to waiters
+}
+
+var (
+	flightsMu sync.Mutex
+	flights   = map[string]*flight{}
+)
+
+// coalesceKey identifies requests that may share a response. ok is false for
+// requests that must not be coalesced.
+func coalesceKey(localPort int, req types.TunnelRequest) (string, bool) {
+	if !coalesce || req.Method != http.MethodGet || req.Body != "" {
+		return "", false
+	}
+	h := http.Header(req.Headers)
+	var b strings.Builder
+	b.WriteString(strconv.Itoa(localPort))
+	b.WriteByte(' ')
+	b.WriteString(req.Path)
+	for _, name := range coalesceHeaders {
+		b.WriteByte('\n')
+		b.WriteString(strings.Join(h.Values(name), ", "))
+	}
+	return b.String(), true
+}
+
+// joinFlight returns the flight for key, starting one if none is running.
+// leader reports whether the caller started it and must call land.
+func joinFlight(key string) (f *flight, leader bool) {
+	flightsMu.Lock()
+	defer flightsMu.Unlock()
+	if f, ok := flights[key]; ok {
+		return f, false
+	}
+	f = &flight{done: make(chan struct{})}
+	flights[key] = f
+	return f, true
+}
+
+// land publishes the leader's response to waiters and ends the flight.
+func (f *flight) land(key string, w *streamWriter) {
+	flightsMu.Lock()
+	delete(flights, key)
+	flightsMu.Unlock()
+
+	if !w.streaming && w.failed == nil && w.header.Get("Set-Cookie") == "" {
+		f.resp = w.tunnelResponse("")
+		f.shared = true
+	}
+	close(f.done)
+}
+
+// wait blocks until the flight lands or timeout passes. It returns a copy of
+// the shared response, or false if the caller has to proxy the request itself.
+func (f *flight) wait(timeout time.Duration) (types.TunnelResponse, bool) {
+	select {
+	case <-f.done:
+	case <-time.After(timeout):
+		return types.TunnelResponse{}, false
+	}
+	if !f.shared {
+		return types.TunnelResponse{}, false
+	}
+	resp := f.resp
+	// Each waiter's response hooks get their own headers to modify
+	resp.Headers = http.Header(f.resp.Headers).Clone()
+	return resp, true
+}
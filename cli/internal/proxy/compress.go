@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"net/http"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+// compressMinSize is the smallest buffered response body, in bytes, that is
+// gzip-compressed for the tunnel. 0 disables compression. Set with
+// SetCompression.
+var compressMinSize int64 = 1 << 10
+
+// SetCompression sets the smallest response body compressed over the
+// tunnel; 0 disables compression.
+func SetCompression(minSize int64) { compressMinSize = minSize }
+
+// CompressResponse gzips a buffered response body for the tunnel, marking it
+// with Encoding so the worker inflates it before answering the visitor.
+// Small bodies, bodies the local server already encoded, and bodies that
+// don't shrink are left as they are.
+func CompressResponse(resp types.TunnelResponse) types.TunnelResponse {
+	if compressMinSize <= 0 || resp.Encoding != "" || decodedLen(resp.Body) < compressMinSize {
+		return resp
+	}
+	if http.Header(resp.Headers).Get("Content-Encoding") != "" {
+		return resp
+	}
+	raw, err := base64.StdEncoding.DecodeString(resp.Body)
+	if err != nil {
+		return resp
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(raw)
+	if err := zw.Close(); err != nil || buf.Len() >= len(raw) {
+		return resp
+	}
+	resp.Body = base64.StdEncoding.EncodeToString(buf.Bytes())
+	resp.Encoding = types.EncodingGzip
+	return resp
+}
@@ -2,95 +2,501 @@ package proxy
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"maps"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/QuadTriangle/prod.bd/cli/internal/config"
 	"github.com/QuadTriangle/prod.bd/cli/internal/types"
 )
 
-func HandleRequest(req types.TunnelRequest, localPort int) types.TunnelResponse {
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-		// Don't follow redirects, let the browser handle them
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
+// StreamThreshold is the Content-Length above which responses are streamed
+// in chunks instead of buffered into a single http-response message.
+const StreamThreshold = 1 << 20
+
+// maxBodySize caps request bodies and buffered response bodies, in bytes.
+// 0 means no limit. Set with SetMaxBodySize before the first request.
+var maxBodySize int64
+
+// SetMaxBodySize limits request bodies and buffered (non-streamed) response
+// bodies to n bytes; 0 disables the limit. Bodies are base64-encoded into a
+// single websocket message, so oversized ones would otherwise exceed the
+// worker's message limit and drop the tunnel.
+func SetMaxBodySize(n int64) { maxBodySize = n }
+
+// bufferedTimeout bounds a buffered request end to end, matching the worker's
+// 30s wait. Streamed responses only have their headers bounded.
+const bufferedTimeout = 30 * time.Second
+
+// transport is shared by all local proxies so connections are pooled.
+var transport = &http.Transport{
+	Proxy:                 http.ProxyFromEnvironment,
+	ResponseHeaderTimeout: 30 * time.Second,
+	MaxIdleConnsPerHost:   16,
+	IdleConnTimeout:       90 * time.Second,
+}
+
+// h2cTransport speaks cleartext HTTP/2 (prior knowledge) to h2c:// targets.
+var h2cTransport = func() *http.Transport {
+	t := transport.Clone()
+	t.Protocols = new(http.Protocols)
+	t.Protocols.SetUnencryptedHTTP2(true)
+	return t
+}()
+
+// reverseProxies caches one httputil.ReverseProxy per local port.
+var reverseProxies sync.Map // port -> *httputil.ReverseProxy
+
+// reverseProxy returns the ReverseProxy for a local port.
+func reverseProxy(localPort int) *httputil.ReverseProxy {
+	if rp, ok := reverseProxies.Load(localPort); ok {
+		return rp.(*httputil.ReverseProxy)
+	}
+
+	target := Target(localPort)
+	scheme, rt := roundTripper(target)
+	rp := &httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			r.URL.Scheme = scheme
+			r.URL.Host = target.Host
+			// Many local dev servers check Host header
+			r.Host = target.Host
+			// If we forward Accept-Encoding, Go passes compressed bytes through
+			// raw, but Cloudflare's edge may strip Content-Encoding on the way
+			// back — leaving the browser with undecoded gzip bytes.
+			r.Header.Del("Accept-Encoding")
+		},
+		Transport: rt,
+		// Flush every write so event streams reach the visitor immediately
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			w.WriteHeader(http.StatusBadGateway)
+			fmt.Fprintf(w, "Failed to connect to local port %d: %v", localPort, err)
+		},
+		// The ReverseProxy only logs a body it couldn't finish reading
+		ModifyResponse: func(resp *http.Response) error {
+			if w, ok := resp.Request.Context().Value(captureKey{}).(*captureWriter); ok {
+				resp.Body = &upstreamBody{ReadCloser: resp.Body, w: w}
+			}
+			return nil
 		},
 	}
+	actual, _ := reverseProxies.LoadOrStore(localPort, rp)
+	return actual.(*httputil.ReverseProxy)
+}
 
-	host := config.GetTargetHost()
-	targetURL := fmt.Sprintf("http://%s:%d%s", host, localPort, req.Path)
+// roundTripper returns the URL scheme and transport for requests to target.
+func roundTripper(target *url.URL) (string, http.RoundTripper) {
+	switch target.Scheme {
+	case "h2c":
+		return "http", h2cTransport
+	case "unix":
+		return "http", unixTransport(target.Path)
+	}
+	return target.Scheme, transport
+}
 
-	var body io.Reader
+// HandleRequest proxies req to the local port, or the one its path is routed
+// to (SetRoutes), and buffers the whole response.
+func HandleRequest(req types.TunnelRequest, localPort int) types.TunnelResponse {
+	ctx, cancel := context.WithTimeout(context.Background(), bufferedTimeout)
+	defer cancel()
+
+	httpReq, errResp := toHTTPRequest(ctx, req, nil)
+	if errResp != nil {
+		return *errResp
+	}
+	w := newCaptureWriter()
+	reverseProxy(RoutePort(localPort, req.Path)).ServeHTTP(w, w.watch(httpReq))
+	return w.tunnelResponse(req.ID)
+}
+
+// BodyFilters rewrite bodies as they pass through HandleRequestStream (the
+// pipeline's hooks.BodyTransformers). Either may be nil.
+type BodyFilters struct {
+	Request func(body io.Reader) io.Reader
+	// Response returns the writer the body goes through into w, or nil to
+	// leave it alone. It is closed after the last write.
+	Response func(status int, header http.Header, w io.Writer) io.WriteCloser
+}
+
+// HandleRequestStream proxies req like HandleRequest and writes the response
+// through writeJSON, with bodies passed through filters. Event streams and large or unknown-length non-HTML
+// bodies are sent incrementally (http-response-start/-chunk/-end); everything
+// else is buffered into one http-response. after runs the response hooks: on
+// the full response when buffered, or on a body-less copy (streamed=true)
+// when streaming.
+// Cancelling ctx aborts the local request, unless other requests share it.
+// With SetCoalesce, identical concurrent GETs may share one local request;
+// with SetThrottle, the request and response are slowed down.
+func HandleRequestStream(ctx context.Context, req types.TunnelRequest, localPort int, filters BodyFilters, after func(resp types.TunnelResponse, streamed bool) types.TunnelResponse, writeJSON func(any) error) error {
+	if l := throttled(localPort); l != nil {
+		l.request(req)
+		writeJSON = l.writer(writeJSON)
+	}
+	httpReq, errResp := toHTTPRequest(ctx, req, filters.Request)
+	if errResp != nil {
+		return writeJSON(after(*errResp, false))
+	}
+
+	w := &streamWriter{captureWriter: newCaptureWriter(), id: req.ID, filter: filters.Response, after: after, writeJSON: writeJSON}
+	if key, ok := coalesceKey(localPort, req); ok {
+		f, leader := joinFlight(key)
+		if leader {
+			// Others may be waiting on this response
+			httpReq = httpReq.WithContext(context.WithoutCancel(ctx))
+			defer f.land(key, w)
+		} else if resp, ok := f.wait(bufferedTimeout); ok {
+			resp.Type, resp.ID = types.TypeHTTPResponse, req.ID
+			return writeJSON(after(resp, false))
+		}
+	}
+	reverseProxy(RoutePort(localPort, req.Path)).ServeHTTP(w, w.watch(httpReq))
+	return w.finish()
+}
+
+// ShouldStream reports whether a local response should be streamed rather
+// than buffered. HTML is always buffered so response hooks can rewrite it;
+// gRPC always streams so its trailers travel in http-response-end.
+func ShouldStream(header http.Header) bool {
+	ct := strings.ToLower(header.Get("Content-Type"))
+	if strings.HasPrefix(ct, "text/event-stream") || strings.HasPrefix(ct, "application/grpc") {
+		return true
+	}
+	if strings.HasPrefix(ct, "text/html") {
+		return false
+	}
+	n, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	return err != nil || n > StreamThreshold
+}
+
+// toHTTPRequest builds the inbound request handed to the ReverseProxy, with
+// the body passed through filter if set. On failure it returns a ready-made
+// 502 response instead.
+func toHTTPRequest(ctx context.Context, req types.TunnelRequest, filter func(io.Reader) io.Reader) (*http.Request, *types.TunnelResponse) {
+	if size := decodedLen(req.Body); maxBodySize > 0 && size > maxBodySize {
+		log.Printf("Rejected %s %s: request body of %d bytes exceeds max body size", req.Method, req.Path, size)
+		return nil, &types.TunnelResponse{
+			Type:   types.TypeHTTPResponse,
+			ID:     req.ID,
+			Status: http.StatusRequestEntityTooLarge,
+			Body:   base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("Request body of %d bytes exceeds -max-body-size (%d bytes)", size, maxBodySize))),
+		}
+	}
+
+	var body io.Reader = http.NoBody
 	if req.Body != "" {
 		decoded, err := base64.StdEncoding.DecodeString(req.Body)
 		if err != nil {
-			return types.TunnelResponse{
+			return nil, &types.TunnelResponse{
 				Type:   types.TypeHTTPResponse,
 				ID:     req.ID,
-				Status: 502,
+				Status: http.StatusBadRequest,
 				Body:   base64.StdEncoding.EncodeToString([]byte("Invalid Request Body")),
 			}
 		}
 		body = bytes.NewReader(decoded)
 	}
 
-	httpReq, err := http.NewRequest(req.Method, targetURL, body)
+	filtered := false
+	if filter != nil && body != http.NoBody {
+		if r := filter(body); r != body {
+			body, filtered = r, true
+		}
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, req.Method, "http://local"+req.Path, body)
 	if err != nil {
-		return types.TunnelResponse{
+		return nil, &types.TunnelResponse{
 			Type:   types.TypeHTTPResponse,
 			ID:     req.ID,
 			Status: 502,
 			Body:   base64.StdEncoding.EncodeToString([]byte("Failed to create request")),
 		}
 	}
-
 	for k, vals := range req.Headers {
-		canonical := http.CanonicalHeaderKey(k)
-		// If we forward Accept-Encoding, Go passes compressed bytes through
-		// raw, but Cloudflare's edge may strip Content-Encoding on the way
-		// back — leaving the browser with undecoded gzip bytes.
-		if canonical == "Accept-Encoding" {
-			continue
-		}
-		httpReq.Header[canonical] = vals
+		httpReq.Header[http.CanonicalHeaderKey(k)] = vals
+	}
+	if filtered {
+		// The length may have changed; send it chunked
+		httpReq.ContentLength = -1
+		httpReq.Header.Del("Content-Length")
 	}
+	return httpReq, nil
+}
+
+// decodedLen returns the decoded size of a base64 body without decoding it.
+func decodedLen(encoded string) int64 {
+	n := int64(len(encoded)) / 4 * 3
+	return n - int64(len(encoded)-len(strings.TrimRight(encoded, "=")))
+}
 
-	// Many local dev servers check Host header
-	httpReq.Host = fmt.Sprintf("%s:%d", host, localPort)
+// errBodyTooLarge aborts the ReverseProxy's body copy past maxBodySize.
+var errBodyTooLarge = errors.New("response body exceeds max body size")
 
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		return types.TunnelResponse{
-			Type:   types.TypeHTTPResponse,
-			ID:     req.ID,
-			Status: 502,
-			Body:   base64.StdEncoding.EncodeToString(fmt.Appendf(nil, "Failed to connect to local port %d: %v", localPort, err)),
-		}
+// captureWriter is the http.ResponseWriter the ReverseProxy writes into.
+// It buffers the body; trailers end up in the header map once the body is
+// done, so they're propagated as ordinary headers.
+type captureWriter struct {
+	header   http.Header
+	status   int
+	body     bytes.Buffer
+	tooLarge bool  // body passed maxBodySize; the response becomes a 502
+	failed   error // a body filter failed; the response becomes a 502
+	upstream error // reading the local body failed midway; the response becomes a 502
+}
+
+// captureKey is the request context key of the captureWriter a local
+// response goes to.
+type captureKey struct{}
+
+// watch returns r set up to report a failure reading the local response
+// body to w.
+func (w *captureWriter) watch(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), captureKey{}, w))
+}
+
+// upstreamBody records the first error reading a local response body.
+type upstreamBody struct {
+	io.ReadCloser
+	w *captureWriter
+}
+
+func (b *upstreamBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && b.w.upstream == nil {
+		b.w.upstream = err
 	}
-	defer resp.Body.Close()
+	return n, err
+}
 
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return types.TunnelResponse{Type: types.TypeHTTPResponse, ID: req.ID, Status: 502}
+func newCaptureWriter() *captureWriter {
+	return &captureWriter{header: http.Header{}}
+}
+
+func (w *captureWriter) Header() http.Header { return w.header }
+
+func (w *captureWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *captureWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.tooLarge {
+		return 0, errBodyTooLarge
 	}
+	if maxBodySize > 0 && int64(w.body.Len()+len(p)) > maxBodySize {
+		log.Printf("Response body exceeds max body size (%d bytes), returning 502", maxBodySize)
+		w.tooLarge = true
+		w.body.Reset()
+		return 0, errBodyTooLarge
+	}
+	return w.body.Write(p)
+}
 
+func (w *captureWriter) Flush() {}
+
+// headers returns the response headers for the tunnel.
+func (w *captureWriter) headers() map[string][]string {
 	// Preserve all header values (multi-value)
 	headers := make(map[string][]string)
-	maps.Copy(headers, resp.Header)
-	// Body is already decompressed by Go's transport, so these are stale
+	maps.Copy(headers, w.header)
+	// Body is already decompressed by Go's transport, so these are stale;
+	// trailers were folded into the headers above.
 	delete(headers, "Content-Encoding")
 	delete(headers, "Content-Length")
+	delete(headers, "Trailer")
+	for k, v := range headers {
+		if name, ok := strings.CutPrefix(k, http.TrailerPrefix); ok {
+			delete(headers, k)
+			headers[http.CanonicalHeaderKey(name)] = v
+		}
+	}
+	return headers
+}
 
+func (w *captureWriter) tunnelResponse(id string) types.TunnelResponse {
+	if w.tooLarge {
+		return types.TunnelResponse{
+			Type:   types.TypeHTTPResponse,
+			ID:     id,
+			Status: http.StatusBadGateway,
+			Body:   base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("Response body exceeds -max-body-size (%d bytes)", maxBodySize))),
+		}
+	}
+	if w.failed != nil {
+		return types.TunnelResponse{
+			Type:   types.TypeHTTPResponse,
+			ID:     id,
+			Status: http.StatusBadGateway,
+			Body:   base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("Failed to transform response body: %v", w.failed))),
+		}
+	}
+	if w.upstream != nil {
+		return types.TunnelResponse{
+			Type:   types.TypeHTTPResponse,
+			ID:     id,
+			Status: http.StatusBadGateway,
+			Body:   base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("Local server failed mid-body: %v", w.upstream))),
+		}
+	}
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
 	return types.TunnelResponse{
 		Type:    types.TypeHTTPResponse,
-		ID:      req.ID,
-		Status:  resp.StatusCode,
-		Headers: headers,
-		Body:    base64.StdEncoding.EncodeToString(respBody),
+		ID:      id,
+		Status:  status,
+		Headers: w.headers(),
+		Body:    base64.StdEncoding.EncodeToString(w.body.Bytes()),
+	}
+}
+
+// streamWriter decides at WriteHeader time whether to stream. Streamed bodies
+// are sent as one http-response-chunk per write (the ReverseProxy flushes
+// every write); otherwise it behaves like captureWriter. Either way the body
+// goes through filter first, if it wants it.
+type streamWriter struct {
+	*captureWriter
+	id        string
+	filter    func(int, http.Header, io.Writer) io.WriteCloser
+	after     func(types.TunnelResponse, bool) types.TunnelResponse
+	writeJSON func(any) error
+
+	body      io.WriteCloser // filter in front of sink; nil if none
+	streaming bool
+	trailers  []string // names announced in the Trailer header
+	err       error    // first tunnel write error; aborts the copy
+}
+
+func (w *streamWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	w.captureWriter.WriteHeader(status)
+	w.streaming = ShouldStream(w.header)
+	if w.filter != nil {
+		w.body = w.filter(status, w.header, sinkWriter{w})
+	}
+	if !w.streaming {
+		return
+	}
+
+	for _, v := range w.header.Values("Trailer") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				w.trailers = append(w.trailers, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	head := w.after(types.TunnelResponse{
+		Type:    types.TypeHTTPResponse,
+		ID:      w.id,
+		Status:  status,
+		Headers: w.headers(),
+	}, true)
+	w.err = w.writeJSON(types.TunnelResponseStart{
+		Type:    types.TypeHTTPRespStart,
+		ID:      w.id,
+		Status:  head.Status,
+		Headers: head.Headers,
+	})
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.body == nil {
+		return w.sink(p)
+	}
+	n, err := w.body.Write(p)
+	if err != nil && w.failed == nil && !w.tooLarge && w.err == nil {
+		w.failed = err
+	}
+	return n, err
+}
+
+// sinkWriter is where filters write the body to.
+type sinkWriter struct{ w *streamWriter }
+
+func (s sinkWriter) Write(p []byte) (int, error) { return s.w.sink(p) }
+
+// sink sends (part of) the body on: as a chunk if streaming, else into the
+// buffer.
+func (w *streamWriter) sink(p []byte) (int, error) {
+	if !w.streaming {
+		return w.captureWriter.Write(p)
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.err = w.writeJSON(types.TunnelResponseChunk{
+		Type: types.TypeHTTPRespChunk,
+		ID:   w.id,
+		Body: base64.StdEncoding.EncodeToString(p),
+	})
+	if w.err != nil {
+		return 0, w.err
+	}
+	return len(p), nil
+}
+
+// finish flushes the filter, then sends the buffered response or ends the
+// stream. A stream whose body failed midway ends with Error set, so the
+// worker aborts it rather than passing off what was sent as the whole body.
+func (w *streamWriter) finish() error {
+	if w.body != nil {
+		if err := w.body.Close(); err != nil && w.failed == nil {
+			w.failed = err
+		}
+	}
+	if !w.streaming {
+		return w.writeJSON(w.after(w.tunnelResponse(w.id), false))
+	}
+	if w.err != nil {
+		return w.err
+	}
+	end := types.TunnelResponseEnd{Type: types.TypeHTTPRespEnd, ID: w.id, Trailers: w.trailerValues()}
+	switch {
+	case w.upstream != nil:
+		end.Error = fmt.Sprintf("local server failed mid-body: %v", w.upstream)
+	case w.failed != nil:
+		end.Error = fmt.Sprintf("failed to transform response body: %v", w.failed)
+	}
+	if end.Error != "" {
+		log.Printf("Aborted streamed response %s: %s", w.id, end.Error)
+	}
+	return w.writeJSON(end)
+}
+
+// trailerValues collects trailers set after the body: announced ones, and
+// undeclared ones the ReverseProxy adds with http.TrailerPrefix.
+func (w *streamWriter) trailerValues() map[string][]string {
+	out := make(map[string][]string)
+	for _, name := range w.trailers {
+		if v, ok := w.header[name]; ok {
+			out[name] = v
+		}
+	}
+	for k, v := range w.header {
+		if name, ok := strings.CutPrefix(k, http.TrailerPrefix); ok {
+			out[http.CanonicalHeaderKey(name)] = v
+		}
+	}
+	if len(out) == 0 {
+		return nil
 	}
+	return out
 }
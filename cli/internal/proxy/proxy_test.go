@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+// serveCut answers every request with the head and the first part of a
+// body it announced as longer, then hangs up.
+func serveCut(contentType string) func(net.Conn) {
+	return func(c net.Conn) {
+		defer c.Close()
+		if _, err := http.ReadRequest(bufio.NewReader(c)); err != nil {
+			return
+		}
+		io.WriteString(c, "HTTP/1.1 200 OK\r\nContent-Type: "+contentType+"\r\nContent-Length: 100\r\n\r\npart of the body")
+	}
+}
+
+func noAfter(resp types.TunnelResponse, _ bool) types.TunnelResponse { return resp }
+
+// end returns the http-response-end rec got for id.
+func end(t *testing.T, rec *recorder, id string) types.TunnelResponseEnd {
+	t.Helper()
+	for _, v := range rec.json {
+		if e, ok := v.(types.TunnelResponseEnd); ok && e.ID == id {
+			return e
+		}
+	}
+	t.Fatalf("no http-response-end in %v", rec.json)
+	return types.TunnelResponseEnd{}
+}
+
+func TestStreamedResponse(t *testing.T) {
+	port := listenLocal(t, func(c net.Conn) {
+		defer c.Close()
+		if _, err := http.ReadRequest(bufio.NewReader(c)); err != nil {
+			return
+		}
+		io.WriteString(c, "HTTP/1.1 200 OK\r\nContent-Type: application/grpc\r\nTrailer: Grpc-Status\r\nTransfer-Encoding: chunked\r\n\r\n"+
+			"5\r\nhello\r\n6\r\n world\r\n0\r\nGrpc-Status: 0\r\n\r\n")
+	})
+	rec := newRecorder()
+	var streamed bool
+	after := func(resp types.TunnelResponse, s bool) types.TunnelResponse {
+		streamed = s
+		resp.Headers["X-After"] = []string{"1"}
+		return resp
+	}
+	req := types.TunnelRequest{Type: types.TypeHTTPRequest, ID: "r1", Method: "POST", Path: "/svc/Call"}
+	if err := HandleRequestStream(context.Background(), req, port, BodyFilters{}, after, rec.writeJSON); err != nil {
+		t.Fatal(err)
+	}
+
+	start, ok := rec.json[0].(types.TunnelResponseStart)
+	if !ok || start.Status != http.StatusOK || start.Headers["X-After"] == nil || !streamed {
+		t.Fatalf("opened with %#v (streamed %v)", rec.json[0], streamed)
+	}
+	var body []byte
+	for _, v := range rec.json[1 : len(rec.json)-1] {
+		chunk := v.(types.TunnelResponseChunk)
+		b, _ := base64.StdEncoding.DecodeString(chunk.Body)
+		body = append(body, b...)
+	}
+	if string(body) != "hello world" {
+		t.Fatalf("streamed %q", body)
+	}
+	if e := end(t, rec, "r1"); e.Error != "" || e.Trailers["Grpc-Status"][0] != "0" {
+		t.Fatalf("ended with %#v", e)
+	}
+}
+
+func TestStreamCutShortEndsWithError(t *testing.T) {
+	port := listenLocal(t, serveCut("text/event-stream"))
+	rec := newRecorder()
+	req := types.TunnelRequest{Type: types.TypeHTTPRequest, ID: "r1", Method: "GET", Path: "/events"}
+	if err := HandleRequestStream(context.Background(), req, port, BodyFilters{}, noAfter, rec.writeJSON); err != nil {
+		t.Fatal(err)
+	}
+	if e := end(t, rec, "r1"); !strings.Contains(e.Error, "local server failed mid-body") {
+		t.Fatalf("stream cut short ended with Error %q", e.Error)
+	}
+}
+
+func TestStreamFilterFailureEndsWithError(t *testing.T) {
+	port := listenLocal(t, func(c net.Conn) {
+		defer c.Close()
+		if _, err := http.ReadRequest(bufio.NewReader(c)); err != nil {
+			return
+		}
+		io.WriteString(c, "HTTP/1.1 200 OK\r\nContent-Type: text/event-stream\r\nContent-Length: 5\r\n\r\nhello")
+	})
+	rec := newRecorder()
+	filters := BodyFilters{Response: func(int, http.Header, io.Writer) io.WriteCloser { return failingFilter{} }}
+	req := types.TunnelRequest{Type: types.TypeHTTPRequest, ID: "r1", Method: "GET", Path: "/events"}
+	if err := HandleRequestStream(context.Background(), req, port, filters, noAfter, rec.writeJSON); err != nil {
+		t.Fatal(err)
+	}
+	if e := end(t, rec, "r1"); !strings.Contains(e.Error, "failed to transform response body") {
+		t.Fatalf("stream with a failed filter ended with Error %q", e.Error)
+	}
+}
+
+func TestBufferedCutShortIs502(t *testing.T) {
+	port := listenLocal(t, serveCut("text/html"))
+	resp := HandleRequest(types.TunnelRequest{Type: types.TypeHTTPRequest, ID: "r1", Method: "GET", Path: "/"}, port)
+	if resp.Status != http.StatusBadGateway {
+		t.Fatalf("page cut short answered %d, want 502", resp.Status)
+	}
+
+	rec := newRecorder()
+	req := types.TunnelRequest{Type: types.TypeHTTPRequest, ID: "r2", Method: "GET", Path: "/"}
+	if err := HandleRequestStream(context.Background(), req, port, BodyFilters{}, noAfter, rec.writeJSON); err != nil {
+		t.Fatal(err)
+	}
+	if r, ok := rec.json[0].(types.TunnelResponse); !ok || r.Status != http.StatusBadGateway {
+		t.Fatalf("sent %#v, want a 502", rec.json[0])
+	}
+}
+
+// failingFilter refuses every write.
+type failingFilter struct{}
+
+func (failingFilter) Write([]byte) (int, error) { return 0, errors.New("bad gzip") }
+func (failingFilter) Close() error              { return nil }
@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Route sends a tunnel's requests under Prefix to another local port.
+type Route struct {
+	Prefix string // path prefix, matched at a segment boundary
+	Port   int
+}
+
+// routes holds the routing tables, keyed by the port the tunnel was
+// registered for, longest prefix first.
+var routes sync.Map // port -> []Route
+
+// SetRoutes makes the tunnel for localPort dispatch by path: requests under
+// a route's prefix go to its port, the rest to localPort. The longest
+// matching prefix wins; /api matches /api and /api/users but not /apis.
+// Call before the tunnel starts serving.
+func SetRoutes(localPort int, rs []Route) {
+	if len(rs) == 0 {
+		routes.Delete(localPort)
+		return
+	}
+	rs = slices.Clone(rs)
+	slices.SortStableFunc(rs, func(a, b Route) int { return len(b.Prefix) - len(a.Prefix) })
+	routes.Store(localPort, rs)
+}
+
+// Routes returns the routing table of the tunnel for localPort, longest
+// prefix first.
+func Routes(localPort int) []Route {
+	rs, _ := routes.Load(localPort)
+	r, _ := rs.([]Route)
+	return r
+}
+
+// RoutePort returns the local port that serves path on the tunnel for
+// localPort.
+func RoutePort(localPort int, path string) int {
+	rs, ok := routes.Load(localPort)
+	if !ok {
+		return localPort
+	}
+	path, _, _ = strings.Cut(path, "?")
+	for _, r := range rs.([]Route) {
+		if matchPrefix(r.Prefix, path) {
+			return r.Port
+		}
+	}
+	return localPort
+}
+
+func matchPrefix(prefix, path string) bool {
+	rest, ok := strings.CutPrefix(path, prefix)
+	return ok && (rest == "" || rest[0] == '/' || strings.HasSuffix(prefix, "/"))
+}
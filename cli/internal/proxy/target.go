@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/config"
+
+	"github.com/gorilla/websocket"
+)
+
+// targets holds explicit local targets, keyed by the port the tunnel was
+// registered for. Ports without one go to http://<target host>:<port>.
+var targets sync.Map // port -> *url.URL
+
+// wsDialer dials local WebSocket servers; TLS settings follow the HTTP transport.
+var wsDialer = *websocket.DefaultDialer
+
+// ParseTarget parses an http://, https:// or h2c:// target URL. h2c targets
+// are spoken to in cleartext HTTP/2, as gRPC servers expect. The port defaults
+// to the scheme's, and any path is dropped (requests keep their own).
+func ParseTarget(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target %q: %w", raw, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "h2c" {
+		return nil, fmt.Errorf("invalid target %q: scheme must be http, https or h2c", raw)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid target %q: missing host", raw)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return &url.URL{Scheme: u.Scheme, Host: net.JoinHostPort(u.Hostname(), port)}, nil
+}
+
+// TargetPort returns the port of a target from ParseTarget.
+func TargetPort(target *url.URL) int {
+	port, _ := strconv.Atoi(target.Port())
+	return port
+}
+
+// SetTarget points the tunnel for localPort at target. Call before the
+// tunnel starts serving.
+func SetTarget(localPort int, target *url.URL) {
+	targets.Store(localPort, target)
+}
+
+// Target returns where requests for localPort are sent.
+func Target(localPort int) *url.URL {
+	if t, ok := targets.Load(localPort); ok {
+		return t.(*url.URL)
+	}
+	return &url.URL{Scheme: "http", Host: net.JoinHostPort(config.GetTargetHost(), strconv.Itoa(localPort))}
+}
+
+// SetInsecureSkipVerify disables certificate verification for https targets,
+// for local servers with self-signed certificates. Call before the first request.
+func SetInsecureSkipVerify(skip bool) {
+	if !skip {
+		return
+	}
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	// A custom TLS config disables automatic HTTP/2 unless asked for
+	transport.ForceAttemptHTTP2 = true
+	wsDialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+}
+
+// wsURL returns the WebSocket URL for path on target.
+func wsURL(target *url.URL, path string) string {
+	scheme := "ws"
+	if strings.EqualFold(target.Scheme, "https") {
+		scheme = "wss"
+	}
+	return scheme + "://" + target.Host + path
+}
+
+// DialLocal opens a raw connection to localPort's server, for traffic
+// relayed byte for byte such as upgraded connections.
+func DialLocal(localPort int) (net.Conn, error) {
+	target := Target(localPort)
+	network, addr := dialAddr(target)
+	d := &net.Dialer{Timeout: 10 * time.Second}
+	if target.Scheme == "https" {
+		return tls.DialWithDialer(d, network, addr, wsDialer.TLSClientConfig)
+	}
+	return d.Dial(network, addr)
+}
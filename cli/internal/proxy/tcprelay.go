@@ -0,0 +1,187 @@
+package proxy
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+// tcpReadBuffer is the largest chunk sent in a single tcp-data message.
+const tcpReadBuffer = 32 * 1024
+
+// tcpStream is one visitor TCP stream. Data for the local connection is
+// queued so it keeps the order the tunnel delivered it in, and so data
+// arriving before the local dial completes isn't lost. The worker sends at
+// most types.TCPWindow unacknowledged bytes, which bounds the queue.
+type tcpStream struct {
+	mu     sync.Mutex
+	queue  [][]byte
+	queued int           // bytes in queue
+	ready  chan struct{} // signalled when queue gains data
+	once   sync.Once
+	done   chan struct{}
+}
+
+func newTCPStream() *tcpStream {
+	return &tcpStream{ready: make(chan struct{}, 1), done: make(chan struct{})}
+}
+
+func (s *tcpStream) close() {
+	s.once.Do(func() { close(s.done) })
+}
+
+// push queues data without blocking. It returns false if that would take
+// the stream past its window.
+func (s *tcpStream) push(data []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.queued+len(data) > types.TCPWindow {
+		return false
+	}
+	s.queue = append(s.queue, data)
+	s.queued += len(data)
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// take returns everything queued.
+func (s *tcpStream) take() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := s.queue
+	s.queue, s.queued = nil, 0
+	return q
+}
+
+// TCPRelay multiplexes raw TCP streams to a local port over the tunnel.
+// HandleData must be called in the order messages arrive from the tunnel;
+// it never blocks.
+type TCPRelay struct {
+	localPort   int
+	writeJSON   func(v any) error
+	writeBinary func(data []byte) error
+
+	mu      sync.Mutex
+	streams map[string]*tcpStream
+}
+
+func NewTCPRelay(localPort int, writeJSON func(v any) error, writeBinary func(data []byte) error) *TCPRelay {
+	return &TCPRelay{
+		localPort:   localPort,
+		writeJSON:   writeJSON,
+		writeBinary: writeBinary,
+		streams:     make(map[string]*tcpStream),
+	}
+}
+
+// HandleOpen registers the stream and dials the local port in the background.
+func (r *TCPRelay) HandleOpen(msg types.TCPOpen) {
+	st := newTCPStream()
+	r.mu.Lock()
+	r.streams[msg.ID] = st
+	r.mu.Unlock()
+
+	go r.serve(msg.ID, st)
+}
+
+func (r *TCPRelay) serve(streamID string, st *tcpStream) {
+	defer func() {
+		st.close()
+		r.mu.Lock()
+		if r.streams[streamID] == st {
+			delete(r.streams, streamID)
+		}
+		r.mu.Unlock()
+	}()
+
+	network, addr := dialAddr(Target(r.localPort))
+	conn, err := net.DialTimeout(network, addr, 10*time.Second)
+	if err != nil {
+		log.Printf("TCP open to local failed for stream %s: %v", streamID, err)
+		_ = r.writeJSON(types.TCPClose{Type: types.TypeTCPClose, ID: streamID, Code: 1011, Reason: "Failed to connect to local port"})
+		return
+	}
+	defer conn.Close()
+
+	// Local -> tunnel. Writes block while the tunnel is busy, which slows
+	// down reading from the local connection in turn.
+	go func() {
+		buf := make([]byte, tcpReadBuffer)
+		for {
+			n, err := conn.Read(buf)
+			if n > 0 {
+				if werr := r.writeBinary(types.EncodeTCPData(streamID, buf[:n])); werr != nil {
+					log.Printf("Error sending tcp-data for stream %s: %v", streamID, werr)
+					st.close()
+					return
+				}
+			}
+			if err != nil {
+				_ = r.writeJSON(types.TCPClose{Type: types.TypeTCPClose, ID: streamID})
+				st.close()
+				return
+			}
+		}
+	}()
+
+	// Tunnel -> local, in arrival order; each write reopens the window by
+	// as much
+	for {
+		select {
+		case <-st.done:
+			return
+		case <-st.ready:
+		}
+		for _, data := range st.take() {
+			if _, err := conn.Write(data); err != nil {
+				log.Printf("Error writing to local TCP for stream %s: %v", streamID, err)
+				_ = r.writeJSON(types.TCPClose{Type: types.TypeTCPClose, ID: streamID, Code: 1011, Reason: "Local write failed"})
+				return
+			}
+			if err := r.writeJSON(types.TCPAck{Type: types.TypeTCPAck, ID: streamID, Bytes: len(data)}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// HandleData queues a chunk for the local connection. A worker that sends
+// more than types.TCPWindow unacknowledged bytes has the stream closed.
+func (r *TCPRelay) HandleData(id string, data []byte) {
+	r.mu.Lock()
+	st := r.streams[id]
+	r.mu.Unlock()
+	if st == nil {
+		return
+	}
+	if !st.push(data) {
+		log.Printf("tcp-data for stream %s overran its window, closing it", id)
+		r.close(id, st)
+		_ = r.writeJSON(types.TCPClose{Type: types.TypeTCPClose, ID: id, Code: 1008, Reason: "Flow control window exceeded"})
+	}
+}
+
+// HandleClose tears down a stream closed by the visitor.
+func (r *TCPRelay) HandleClose(msg types.TCPClose) {
+	r.mu.Lock()
+	st := r.streams[msg.ID]
+	r.mu.Unlock()
+	if st != nil {
+		r.close(msg.ID, st)
+	}
+}
+
+func (r *TCPRelay) close(id string, st *tcpStream) {
+	r.mu.Lock()
+	if r.streams[id] == st {
+		delete(r.streams, id)
+	}
+	r.mu.Unlock()
+	st.close()
+}
@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+// recorder collects what a relay sends to the worker.
+type recorder struct {
+	mu     sync.Mutex
+	json   []any
+	binary [][]byte
+	sent   chan struct{}
+}
+
+func newRecorder() *recorder { return &recorder{sent: make(chan struct{}, 1024)} }
+
+func (r *recorder) writeJSON(v any) error {
+	r.mu.Lock()
+	r.json = append(r.json, v)
+	r.mu.Unlock()
+	r.sent <- struct{}{}
+	return nil
+}
+
+func (r *recorder) writeBinary(data []byte) error {
+	r.mu.Lock()
+	r.binary = append(r.binary, bytes.Clone(data))
+	r.mu.Unlock()
+	r.sent <- struct{}{}
+	return nil
+}
+
+// waitFor waits until ok holds for what was sent so far.
+func (r *recorder) waitFor(t *testing.T, ok func() bool) {
+	t.Helper()
+	deadline := time.After(5 * time.Second)
+	for {
+		r.mu.Lock()
+		done := ok()
+		r.mu.Unlock()
+		if done {
+			return
+		}
+		select {
+		case <-r.sent:
+		case <-deadline:
+			t.Fatal("timed out")
+		}
+	}
+}
+
+// listenLocal starts a local server on a fresh port for the relay to dial,
+// running serve on each connection.
+func listenLocal(t *testing.T, serve func(net.Conn)) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	port := ln.Addr().(*net.TCPAddr).Port
+	SetTarget(port, &url.URL{Scheme: "http", Host: ln.Addr().String()})
+	return port
+}
+
+func TestTCPRelayEchoAndAck(t *testing.T) {
+	port := listenLocal(t, func(c net.Conn) {
+		defer c.Close()
+		io.Copy(c, c)
+	})
+	rec := newRecorder()
+	relay := NewTCPRelay(port, rec.writeJSON, rec.writeBinary)
+
+	relay.HandleOpen(types.TCPOpen{Type: types.TypeTCPOpen, ID: "s1"})
+	// Data before the dial completes is queued, not lost
+	relay.HandleData("s1", []byte("hello "))
+	relay.HandleData("s1", []byte("world"))
+
+	var echoed []byte
+	rec.waitFor(t, func() bool {
+		echoed = nil
+		for _, msg := range rec.binary {
+			id, payload, err := types.DecodeTCPData(msg)
+			if err != nil || id != "s1" {
+				t.Fatalf("bad frame %q: %v", msg, err)
+			}
+			echoed = append(echoed, payload...)
+		}
+		return len(echoed) >= len("hello world")
+	})
+	if string(echoed) != "hello world" {
+		t.Fatalf("echoed %q, want %q", echoed, "hello world")
+	}
+
+	acked := 0
+	rec.waitFor(t, func() bool {
+		acked = 0
+		for _, v := range rec.json {
+			if ack, ok := v.(types.TCPAck); ok && ack.ID == "s1" {
+				acked += ack.Bytes
+			}
+		}
+		return acked >= len("hello world")
+	})
+	if acked != len("hello world") {
+		t.Fatalf("acked %d bytes, want %d", acked, len("hello world"))
+	}
+
+	relay.HandleClose(types.TCPClose{Type: types.TypeTCPClose, ID: "s1"})
+}
+
+func TestTCPRelayHandleDataNeverBlocks(t *testing.T) {
+	rec := newRecorder()
+	relay := NewTCPRelay(0, rec.writeJSON, rec.writeBinary)
+	// A stream whose local connection takes nothing
+	st := newTCPStream()
+	relay.streams["s1"] = st
+
+	chunk := make([]byte, 64*1024)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// Twice the window: the relay must close the stream, not stall
+		for i := 0; i < 2*types.TCPWindow/len(chunk); i++ {
+			relay.HandleData("s1", chunk)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("HandleData blocked on a stalled stream")
+	}
+
+	select {
+	case <-st.done:
+	default:
+		t.Fatal("stream past its window is still open")
+	}
+	if len(rec.json) != 1 {
+		t.Fatalf("sent %v, want one tcp-close", rec.json)
+	}
+	if c, ok := rec.json[0].(types.TCPClose); !ok || c.ID != "s1" || c.Code != 1008 {
+		t.Fatalf("sent %#v, want a 1008 tcp-close", rec.json[0])
+	}
+}
+
+func TestTCPStreamWindow(t *testing.T) {
+	st := newTCPStream()
+	if !st.push(make([]byte, types.TCPWindow)) {
+		t.Fatal("a full window was refused")
+	}
+	if st.push([]byte{0}) {
+		t.Fatal("a byte past the window was queued")
+	}
+	if q := st.take(); len(q) != 1 || len(q[0]) != types.TCPWindow {
+		t.Fatalf("take returned %d chunks", len(q))
+	}
+	if !st.push([]byte{0}) {
+		t.Fatal("queue still full after take")
+	}
+}
+
+func TestTCPDataFrame(t *testing.T) {
+	msg := types.EncodeTCPData("stream-1", []byte{0, 1, 2})
+	id, payload, err := types.DecodeTCPData(msg)
+	if err != nil || id != "stream-1" || !bytes.Equal(payload, []byte{0, 1, 2}) {
+		t.Fatalf("DecodeTCPData = %q, %v, %v", id, payload, err)
+	}
+	for _, bad := range [][]byte{nil, {1}, {2, 1, 'a'}, {1, 0}, {1, 5, 'a'}} {
+		if _, _, err := types.DecodeTCPData(bad); err == nil {
+			t.Errorf("DecodeTCPData(%v) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+// link simulates a slow network in front of one local port: every request is
+// delayed by latency, and request and response bodies share rate, so
+// concurrent requests slow each other down like on a real connection.
+type link struct {
+	latency time.Duration
+	rate    int64 // bytes per second; 0 for no limit
+
+	mu   sync.Mutex
+	free time.Time // when the bytes sent so far have gone through
+}
+
+// links holds the throttled ports.
+var links sync.Map // port -> *link
+
+// SetThrottle slows visitors' requests to localPort down: each one waits
+// latency before reaching the local server, and bodies flow at rate bytes
+// per second in total. Zero values leave the port unthrottled. Call before
+// the tunnel starts serving.
+func SetThrottle(localPort int, rate int64, latency time.Duration) {
+	if rate <= 0 && latency <= 0 {
+		links.Delete(localPort)
+		return
+	}
+	links.Store(localPort, &link{latency: latency, rate: max(rate, 0)})
+}
+
+func throttled(localPort int) *link {
+	if l, ok := links.Load(localPort); ok {
+		return l.(*link)
+	}
+	return nil
+}
+
+// transfer blocks until n more bytes have passed through the link.
+func (l *link) transfer(n int64) {
+	if l.rate == 0 || n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.free.Before(now) {
+		l.free = now
+	}
+	l.free = l.free.Add(time.Duration(n * int64(time.Second) / l.rate))
+	wait := time.Until(l.free)
+	l.mu.Unlock()
+	time.Sleep(wait)
+}
+
+// request delays an incoming request by the latency and its body's transfer.
+func (l *link) request(req types.TunnelRequest) {
+	time.Sleep(l.latency)
+	l.transfer(decodedLen(req.Body))
+}
+
+// writer wraps writeJSON so response bodies leave at the link's rate.
+func (l *link) writer(writeJSON func(any) error) func(any) error {
+	return func(v any) error {
+		switch m := v.(type) {
+		case types.TunnelResponse:
+			l.transfer(decodedLen(m.Body))
+		case types.TunnelResponseChunk:
+			l.transfer(decodedLen(m.Body))
+		}
+		return writeJSON(v)
+	}
+}
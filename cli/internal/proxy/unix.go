@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// UnixTarget returns the target for an HTTP server listening on a unix
+// socket. Requests carry Host: localhost.
+func UnixTarget(socketPath string) *url.URL {
+	return &url.URL{Scheme: "unix", Host: "localhost", Path: socketPath}
+}
+
+// unixTransports holds one pooled transport per socket path.
+var unixTransports sync.Map // socket path -> *http.Transport
+
+// unixTransport returns the transport that dials socketPath for every request.
+func unixTransport(socketPath string) *http.Transport {
+	if t, ok := unixTransports.Load(socketPath); ok {
+		return t.(*http.Transport)
+	}
+	t := transport.Clone()
+	t.Proxy = nil
+	t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", socketPath)
+	}
+	actual, _ := unixTransports.LoadOrStore(socketPath, t)
+	return actual.(*http.Transport)
+}
+
+// dialAddr returns the network and address of target's server.
+func dialAddr(target *url.URL) (network, addr string) {
+	if target.Scheme == "unix" {
+		return "unix", target.Path
+	}
+	return "tcp", target.Host
+}
+
+// wsDialerFor returns the WebSocket dialer for target.
+func wsDialerFor(target *url.URL) *websocket.Dialer {
+	d := wsDialer
+	if target.Scheme == "unix" {
+		d.NetDialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var nd net.Dialer
+			return nd.DialContext(ctx, "unix", target.Path)
+		}
+	}
+	return &d
+}
@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// warmupTimeout bounds the whole warm-up, so a hung local server can't keep
+// the tunnel from serving.
+const warmupTimeout = 10 * time.Second
+
+// Warmup sends conns concurrent GETs for path to the local server, so the
+// server handles its cold start and the transport keeps conns keep-alive
+// connections pooled before the first visitor arrives. It returns the status
+// of the first response, how many requests succeeded, and the slowest
+// latency. conns is capped at the transport's idle pool size.
+func Warmup(localPort int, path string, conns int) (status, succeeded int, latency time.Duration, err error) {
+	conns = min(max(conns, 1), transport.MaxIdleConnsPerHost)
+	ctx, cancel := context.WithTimeout(context.Background(), warmupTimeout)
+	defer cancel()
+
+	target := Target(localPort)
+	scheme, rt := roundTripper(target)
+	targetURL := scheme + "://" + target.Host + path
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			code, reqErr := getDiscard(ctx, rt, targetURL, "prod.bd-warmup")
+			mu.Lock()
+			defer mu.Unlock()
+			latency = max(latency, time.Since(start))
+			if reqErr != nil {
+				err = reqErr
+				return
+			}
+			if status == 0 {
+				status = code
+			}
+			succeeded++
+		}()
+	}
+	wg.Wait()
+	if succeeded > 0 {
+		err = nil
+	}
+	return status, succeeded, latency, err
+}
+
+// probeTimeout bounds one health check request.
+const probeTimeout = 5 * time.Second
+
+// Probe sends one GET for path to the local server (-healthcheck) and
+// returns the response status and latency.
+func Probe(localPort int, path string) (status int, latency time.Duration, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	target := Target(localPort)
+	scheme, rt := roundTripper(target)
+	start := time.Now()
+	status, err = getDiscard(ctx, rt, scheme+"://"+target.Host+path, "prod.bd-healthcheck")
+	return status, time.Since(start), err
+}
+
+// getDiscard sends one GET and drains the body so the connection is reused.
+func getDiscard(ctx context.Context, rt http.RoundTripper, targetURL, userAgent string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, targetURL, nil)
+	if err != nil {
+		return 0, fmt.Errorf("invalid path: %w", err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
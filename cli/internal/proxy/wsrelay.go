@@ -2,12 +2,10 @@ package proxy
 
 import (
 	"encoding/base64"
-	"fmt"
 	"log"
 	"net/http"
 	"sync"
 
-	"github.com/QuadTriangle/prod.bd/cli/internal/config"
 	"github.com/QuadTriangle/prod.bd/cli/internal/types"
 
 	"github.com/gorilla/websocket"
@@ -45,8 +43,8 @@ func NewWSRelay(localPort int, writeJSON func(v any) error) *WSRelay {
 
 // HandleOpen dials the local WebSocket server and starts relaying frames.
 func (r *WSRelay) HandleOpen(msg types.WSOpen) {
-	host := config.GetTargetHost()
-	localURL := fmt.Sprintf("ws://%s:%d%s", host, r.localPort, msg.Path)
+	target := Target(RoutePort(r.localPort, msg.Path))
+	localURL := wsURL(target, msg.Path)
 
 	reqHeader := http.Header{}
 	for k, vals := range msg.Headers {
@@ -60,9 +58,9 @@ func (r *WSRelay) HandleOpen(msg types.WSOpen) {
 			reqHeader[canonical] = vals
 		}
 	}
-	reqHeader.Set("Host", fmt.Sprintf("%s:%d", host, r.localPort))
+	reqHeader.Set("Host", target.Host)
 
-	localConn, _, err := websocket.DefaultDialer.Dial(localURL, reqHeader)
+	localConn, _, err := wsDialerFor(target).Dial(localURL, reqHeader)
 	if err != nil {
 		log.Printf("WS open to local failed for session %s: %v", msg.ID, err)
 		_ = r.writeJSON(types.WSClose{
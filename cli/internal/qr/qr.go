@@ -0,0 +1,428 @@
+// Package qr encodes short texts as QR codes and draws them in the
+// terminal. It covers what prod needs, links and otpauth URIs: byte mode,
+// error correction level M, versions 1 to 10 (up to 213 bytes).
+package qr
+
+import (
+	"errors"
+	"strings"
+)
+
+// Code is an encoded QR symbol.
+type Code struct {
+	Size    int
+	modules [][]bool // [y][x], true is dark
+}
+
+// Dark reports whether the module at column x, row y is dark.
+func (c *Code) Dark(x, y int) bool { return c.modules[y][x] }
+
+// version describes the level M blocks of one version.
+type version struct {
+	ecPerBlock int
+	blocks     []int // data codewords of each block
+	align      []int // alignment pattern centres
+}
+
+var versions = []version{
+	1:  {10, []int{16}, nil},
+	2:  {16, []int{28}, []int{6, 18}},
+	3:  {26, []int{44}, []int{6, 22}},
+	4:  {18, []int{32, 32}, []int{6, 26}},
+	5:  {24, []int{43, 43}, []int{6, 30}},
+	6:  {16, []int{27, 27, 27, 27}, []int{6, 34}},
+	7:  {18, []int{31, 31, 31, 31}, []int{6, 22, 38}},
+	8:  {22, []int{38, 38, 39, 39}, []int{6, 24, 42}},
+	9:  {22, []int{36, 36, 36, 37, 37}, []int{6, 26, 46}},
+	10: {26, []int{43, 43, 43, 43, 44}, []int{6, 28, 50}},
+}
+
+func (v version) dataLen() int {
+	n := 0
+	for _, b := range v.blocks {
+		n += b
+	}
+	return n
+}
+
+// ErrTooLong is returned for texts that don't fit in version 10.
+var ErrTooLong = errors.New("qr: text too long")
+
+// Encode makes the smallest code that holds text.
+func Encode(text string) (*Code, error) {
+	for ver := 1; ver < len(versions); ver++ {
+		countBits := 8
+		if ver >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+8*len(text) <= versions[ver].dataLen()*8 {
+			return build(ver, encodeData(text, countBits, versions[ver].dataLen())), nil
+		}
+	}
+	return nil, ErrTooLong
+}
+
+// encodeData lays out text in byte mode, padded to n codewords.
+func encodeData(text string, countBits, n int) []byte {
+	var b bitBuffer
+	b.append(0b0100, 4)
+	b.append(len(text), countBits)
+	for i := 0; i < len(text); i++ {
+		b.append(int(text[i]), 8)
+	}
+	b.append(0, min(4, n*8-b.len))
+	b.append(0, (8-b.len%8)%8)
+	data := b.bytes()
+	for pad := byte(0xEC); len(data) < n; pad ^= 0xEC ^ 0x11 {
+		data = append(data, pad)
+	}
+	return data
+}
+
+type bitBuffer struct {
+	bits []bool
+	len  int
+}
+
+func (b *bitBuffer) append(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		b.bits = append(b.bits, v>>i&1 == 1)
+	}
+	b.len += n
+}
+
+func (b *bitBuffer) bytes() []byte {
+	out := make([]byte, b.len/8)
+	for i, bit := range b.bits {
+		if bit {
+			out[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	return out
+}
+
+// codewords splits data into blocks, adds error correction and interleaves
+// them.
+func codewords(v version, data []byte) []byte {
+	gen := rsGenerator(v.ecPerBlock)
+	var blocks, ecs [][]byte
+	for _, n := range v.blocks {
+		blocks = append(blocks, data[:n])
+		ecs = append(ecs, rsRemainder(data[:n], gen))
+		data = data[n:]
+	}
+	var out []byte
+	for i := 0; i < v.blocks[len(v.blocks)-1]; i++ {
+		for _, b := range blocks {
+			if i < len(b) {
+				out = append(out, b[i])
+			}
+		}
+	}
+	for i := 0; i < v.ecPerBlock; i++ {
+		for _, ec := range ecs {
+			out = append(out, ec[i])
+		}
+	}
+	return out
+}
+
+// build draws version ver holding data, with the mask that scores best.
+func build(ver int, data []byte) *Code {
+	v := versions[ver]
+	size := 17 + 4*ver
+	c := &Code{Size: size, modules: grid(size)}
+	function := grid(size)
+	set := func(x, y int, dark bool) {
+		c.modules[y][x] = dark
+		function[y][x] = true
+	}
+
+	// Timing patterns, then finders and alignment patterns over them
+	for i := range size {
+		set(6, i, i%2 == 0)
+		set(i, 6, i%2 == 0)
+	}
+	for _, p := range [][2]int{{3, 3}, {size - 4, 3}, {3, size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := p[0]+dx, p[1]+dy
+				if x >= 0 && x < size && y >= 0 && y < size {
+					d := max(abs(dx), abs(dy))
+					set(x, y, d != 2 && d != 4)
+				}
+			}
+		}
+	}
+	for i, ax := range v.align {
+		for j, ay := range v.align {
+			if (i == 0 && j == 0) || (i == 0 && j == len(v.align)-1) || (i == len(v.align)-1 && j == 0) {
+				continue // finder corners
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					set(ax+dx, ay+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+	// Reserve the format areas; drawFormat fills them per mask
+	drawFormat(c, 0, set)
+	if ver >= 7 {
+		rem := ver
+		for range 12 {
+			rem = rem<<1 ^ (rem>>11)*0x1F25
+		}
+		bits := ver<<12 | rem
+		for i := range 18 {
+			a, b := size-11+i%3, i/3
+			set(a, b, bits>>i&1 == 1)
+			set(b, a, bits>>i&1 == 1)
+		}
+	}
+
+	// Data, in two-column zigzags from the bottom right
+	cw := codewords(v, data)
+	i := 0
+	for right := size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := range size {
+			y := vert
+			if upward {
+				y = size - 1 - vert
+			}
+			for j := range 2 {
+				x := right - j
+				if !function[y][x] && i < len(cw)*8 {
+					c.modules[y][x] = cw[i/8]>>(7-i%8)&1 == 1
+					i++
+				}
+			}
+		}
+	}
+
+	best, bestScore := 0, -1
+	for mask := range 8 {
+		applyMask(c, function, mask)
+		drawFormat(c, mask, set)
+		if s := penalty(c); bestScore < 0 || s < bestScore {
+			best, bestScore = mask, s
+		}
+		applyMask(c, function, mask) // undo
+	}
+	applyMask(c, function, best)
+	drawFormat(c, best, set)
+	return c
+}
+
+// drawFormat writes the format bits for level M and mask, both copies, and
+// the dark module.
+func drawFormat(c *Code, mask int, set func(x, y int, dark bool)) {
+	data := 0b00<<3 | mask // level M
+	rem := data
+	for range 10 {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 == 1 }
+	size := c.Size
+	for i := range 6 {
+		set(8, i, bit(i))
+	}
+	set(8, 7, bit(6))
+	set(8, 8, bit(7))
+	set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		set(14-i, 8, bit(i))
+	}
+	for i := range 8 {
+		set(size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		set(8, size-15+i, bit(i))
+	}
+	set(8, size-8, true)
+}
+
+func applyMask(c *Code, function [][]bool, mask int) {
+	for y := range c.Size {
+		for x := range c.Size {
+			if function[y][x] {
+				continue
+			}
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (x+y)%2 == 0
+			case 1:
+				flip = y%2 == 0
+			case 2:
+				flip = x%3 == 0
+			case 3:
+				flip = (x+y)%3 == 0
+			case 4:
+				flip = (x/3+y/2)%2 == 0
+			case 5:
+				flip = x*y%2+x*y%3 == 0
+			case 6:
+				flip = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				flip = ((x+y)%2+x*y%3)%2 == 0
+			}
+			c.modules[y][x] = c.modules[y][x] != flip
+		}
+	}
+}
+
+// penalty scores how hard the symbol is to read; lower is better.
+func penalty(c *Code) int {
+	size, score, dark := c.Size, 0, 0
+	finder := []bool{true, false, true, true, true, false, true}
+	line := func(at func(i int) bool) {
+		run := 1
+		for i := 1; i <= size; i++ {
+			if i < size && at(i) == at(i-1) {
+				run++
+				continue
+			}
+			if run >= 5 {
+				score += run - 2
+			}
+			run = 1
+		}
+		for i := 0; i+7 <= size; i++ {
+			match := true
+			for k, f := range finder {
+				match = match && at(i+k) == f
+			}
+			if !match {
+				continue
+			}
+			light := func(from, to int) bool {
+				for k := from; k < to; k++ {
+					if k >= 0 && k < size && at(k) {
+						return false
+					}
+				}
+				return true
+			}
+			if light(i-4, i) || light(i+7, i+11) {
+				score += 40
+			}
+		}
+	}
+	for y := range size {
+		line(func(i int) bool { return c.modules[y][i] })
+	}
+	for x := range size {
+		line(func(i int) bool { return c.modules[i][x] })
+	}
+	for y := range size {
+		for x := range size {
+			if c.modules[y][x] {
+				dark++
+			}
+			if x > 0 && y > 0 {
+				m := c.modules[y][x]
+				if c.modules[y-1][x] == m && c.modules[y][x-1] == m && c.modules[y-1][x-1] == m {
+					score += 3
+				}
+			}
+		}
+	}
+	return score + abs(dark*20-size*size*10)/(size*size)*10
+}
+
+// Terminal draws the code with half blocks, two rows per line, in black on
+// white whatever the terminal's colours, with a quiet zone around it.
+func (c *Code) Terminal() string {
+	const quiet = 4
+	dark := func(x, y int) bool {
+		return x >= 0 && y >= 0 && x < c.Size && y < c.Size && c.modules[y][x]
+	}
+	var b strings.Builder
+	for y := -quiet; y < c.Size+quiet; y += 2 {
+		b.WriteString("\x1b[30;47m")
+		for x := -quiet; x < c.Size+quiet; x++ {
+			switch top, bottom := dark(x, y), dark(x, y+1); {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\x1b[0m\n")
+	}
+	return b.String()
+}
+
+func grid(size int) [][]bool {
+	g := make([][]bool, size)
+	for i := range g {
+		g[i] = make([]bool, size)
+	}
+	return g
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Reed-Solomon over GF(256) with the QR polynomial x^8+x^4+x^3+x^2+1.
+
+func gfMul(a, b byte) byte {
+	var p byte
+	for b > 0 {
+		if b&1 == 1 {
+			p ^= a
+		}
+		hi := a & 0x80
+		a <<= 1
+		if hi != 0 {
+			a ^= 0x1D
+		}
+		b >>= 1
+	}
+	return p
+}
+
+// rsGenerator returns the coefficients, highest power first without the
+// leading 1, of the degree-n generator polynomial.
+func rsGenerator(n int) []byte {
+	gen := make([]byte, n)
+	gen[n-1] = 1
+	root := byte(1)
+	for range n {
+		for j := range n {
+			gen[j] = gfMul(gen[j], root)
+			if j+1 < n {
+				gen[j] ^= gen[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+	return gen
+}
+
+func rsRemainder(data, gen []byte) []byte {
+	rem := make([]byte, len(gen))
+	for _, d := range data {
+		factor := d ^ rem[0]
+		copy(rem, rem[1:])
+		rem[len(rem)-1] = 0
+		for i, g := range gen {
+			rem[i] ^= gfMul(g, factor)
+		}
+	}
+	return rem
+}
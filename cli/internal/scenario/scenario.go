@@ -0,0 +1,403 @@
+// Package scenario replays ordered request sequences against a local server
+// with several virtual users, as a lightweight load test built from captured
+// sessions.
+package scenario
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxBody caps how much of a response body is read for extraction.
+const maxBody = 10 << 20
+
+// varRef is a ${name} reference. Bare $name is left alone, as captured
+// bodies (GraphQL variables, Mongo operators) are full of them.
+var varRef = regexp.MustCompile(`\$\{\w+\}`)
+
+// File is a scenario file. Every virtual user runs the steps in order,
+// with its own cookie jar, as often as the run asks for:
+//
+//	steps:
+//	  - name: login
+//	    method: POST
+//	    path: /api/login
+//	    headers: {Content-Type: application/json}
+//	    body: '{"user": "demo${user}"}'
+//	    extract: {token: json:token}
+//	    think: 500ms
+//	  - path: /api/items
+//	    headers: {Authorization: 'Bearer ${token}'}
+//
+// ${name} is replaced by a variable extracted by an earlier step, or by the
+// built-ins ${user} (1-based virtual user number) and ${iteration}.
+type File struct {
+	Steps []Step `yaml:"steps"`
+}
+
+// Step is one request. Extract maps variable names to where their value is
+// taken from in the response: json:a.b for a JSON field, header:Name for a
+// header, or a regular expression over the body (its first group, if any).
+type Step struct {
+	Name    string            `yaml:"name,omitempty"`
+	Method  string            `yaml:"method,omitempty"` // default GET
+	Path    string            `yaml:"path"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+	Body    string            `yaml:"body,omitempty"`
+	Extract map[string]string `yaml:"extract,omitempty"`
+	Think   time.Duration     `yaml:"think,omitempty"` // pause after the step
+
+	regexps map[string]*regexp.Regexp
+}
+
+// Load reads and checks a scenario file.
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f File
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if len(f.Steps) == 0 {
+		return nil, fmt.Errorf("%s has no steps", path)
+	}
+	for i := range f.Steps {
+		s := &f.Steps[i]
+		if !strings.HasPrefix(s.Path, "/") {
+			return nil, fmt.Errorf("%s: step %d: path must start with /", path, i+1)
+		}
+		s.Method = strings.ToUpper(s.Method)
+		if s.Method == "" {
+			s.Method = http.MethodGet
+		}
+		if s.Name == "" {
+			s.Name = s.Method + " " + s.Path
+		}
+		s.regexps = make(map[string]*regexp.Regexp)
+		for name, from := range s.Extract {
+			if strings.HasPrefix(from, "json:") || strings.HasPrefix(from, "header:") {
+				continue
+			}
+			if s.regexps[name], err = regexp.Compile(from); err != nil {
+				return nil, fmt.Errorf("%s: step %d: extract %s: %w", path, i+1, name, err)
+			}
+		}
+	}
+	return &f, nil
+}
+
+// Marshal encodes f as a scenario file.
+func (f *File) Marshal() ([]byte, error) {
+	return yaml.Marshal(f)
+}
+
+// Config describes one run.
+type Config struct {
+	BaseURL    string // local server, e.g. http://localhost:3000
+	Users      int    // virtual users running the scenario concurrently
+	Iterations int    // runs per user; 0 repeats until Duration is up
+	// Duration bounds the whole run; 0 means until every user has done
+	// Iterations.
+	Duration time.Duration
+}
+
+// StepReport summarizes one step across all users and iterations.
+type StepReport struct {
+	Name     string
+	Requests int
+	Errors   int // transport errors, 5xx and failed extractions
+	P50      time.Duration
+	P99      time.Duration
+	LastErr  string
+}
+
+// Report is the result of a run.
+type Report struct {
+	Iterations int // scenario runs completed without errors
+	Failed     int // scenario runs cut short by an error
+	Elapsed    time.Duration
+	Steps      []StepReport
+}
+
+// stepStats accumulates one step's results.
+type stepStats struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+	errors    int
+	lastErr   string
+}
+
+// Run replays f with cfg.Users virtual users until each has done
+// cfg.Iterations runs, cfg.Duration is up, or ctx is done.
+func Run(ctx context.Context, f *File, cfg Config) Report {
+	if cfg.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Duration)
+		defer cancel()
+	}
+	stats := make([]stepStats, len(f.Steps))
+	var mu sync.Mutex
+	var report Report
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for user := 1; user <= max(cfg.Users, 1); user++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			jar, _ := cookiejar.New(nil)
+			client := &http.Client{
+				Jar: jar,
+				// Captured sessions already contain the redirected requests
+				CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+			}
+			for it := 1; cfg.Iterations == 0 || it <= cfg.Iterations; it++ {
+				if ctx.Err() != nil {
+					return
+				}
+				ok := runOnce(ctx, client, f, cfg.BaseURL, user, it, stats)
+				if ctx.Err() != nil {
+					// Cut short by the end of the run, not by the app
+					return
+				}
+				mu.Lock()
+				if ok {
+					report.Iterations++
+				} else {
+					report.Failed++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	report.Elapsed = time.Since(start)
+
+	for i := range stats {
+		st := &stats[i]
+		slices.Sort(st.latencies)
+		report.Steps = append(report.Steps, StepReport{
+			Name:     f.Steps[i].Name,
+			Requests: len(st.latencies),
+			Errors:   st.errors,
+			P50:      percentile(st.latencies, 0.50),
+			P99:      percentile(st.latencies, 0.99),
+			LastErr:  st.lastErr,
+		})
+	}
+	return report
+}
+
+// runOnce runs the steps once for one user, stopping at the first error
+// since later steps usually depend on earlier ones.
+func runOnce(ctx context.Context, client *http.Client, f *File, baseURL string, user, iteration int, stats []stepStats) bool {
+	vars := map[string]string{"user": strconv.Itoa(user), "iteration": strconv.Itoa(iteration)}
+	for i := range f.Steps {
+		s := &f.Steps[i]
+		req, err := s.request(ctx, baseURL, vars)
+		var latency time.Duration
+		if err == nil {
+			latency, err = s.send(client, req, vars)
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+		st := &stats[i]
+		st.mu.Lock()
+		if req != nil {
+			st.latencies = append(st.latencies, latency)
+		}
+		if err != nil {
+			st.errors++
+			st.lastErr = err.Error()
+		}
+		st.mu.Unlock()
+		if err != nil {
+			return false
+		}
+		if s.Think > 0 {
+			select {
+			case <-ctx.Done():
+				return false
+			case <-time.After(s.Think):
+			}
+		}
+	}
+	return true
+}
+
+// request builds the step's request with vars filled in.
+func (s *Step) request(ctx context.Context, baseURL string, vars map[string]string) (*http.Request, error) {
+	var missing []string
+	expand := func(text string) string {
+		return varRef.ReplaceAllStringFunc(text, func(ref string) string {
+			name := ref[2 : len(ref)-1]
+			v, ok := vars[name]
+			if !ok {
+				missing = append(missing, name)
+			}
+			return v
+		})
+	}
+	req, err := http.NewRequestWithContext(ctx, s.Method, strings.TrimSuffix(baseURL, "/")+expand(s.Path), strings.NewReader(expand(s.Body)))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range s.Headers {
+		req.Header.Set(k, expand(v))
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("undefined variable %s", missing[0])
+	}
+	return req, nil
+}
+
+// send sends req and extracts the step's variables into vars.
+func (s *Step) send(client *http.Client, req *http.Request, vars map[string]string) (time.Duration, error) {
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return time.Since(start), err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBody))
+	latency := time.Since(start)
+	if err != nil {
+		return latency, err
+	}
+	if resp.StatusCode >= 500 {
+		return latency, fmt.Errorf("%s", resp.Status)
+	}
+	for name, from := range s.Extract {
+		v, ok := s.extract(name, from, resp.Header, body)
+		if !ok {
+			return latency, fmt.Errorf("extract %s: %q not found in the response", name, from)
+		}
+		vars[name] = v
+	}
+	return latency, nil
+}
+
+// extract takes one variable's value from a response.
+func (s *Step) extract(name, from string, header http.Header, body []byte) (string, bool) {
+	if field, ok := strings.CutPrefix(from, "json:"); ok {
+		return jsonField(body, field)
+	}
+	if h, ok := strings.CutPrefix(from, "header:"); ok {
+		v := header.Get(h)
+		return v, v != ""
+	}
+	m := s.regexps[name].FindSubmatch(body)
+	switch {
+	case m == nil:
+		return "", false
+	case len(m) > 1:
+		return string(m[1]), true
+	default:
+		return string(m[0]), true
+	}
+}
+
+// jsonField returns the value at a dotted path (a.b.0.c) in a JSON body,
+// as text.
+func jsonField(body []byte, field string) (string, bool) {
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return "", false
+	}
+	for _, key := range strings.Split(field, ".") {
+		switch node := v.(type) {
+		case map[string]any:
+			var ok bool
+			if v, ok = node[key]; !ok {
+				return "", false
+			}
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", false
+			}
+			v = node[i]
+		default:
+			return "", false
+		}
+	}
+	switch val := v.(type) {
+	case string:
+		return val, true
+	case nil:
+		return "", false
+	default:
+		data, _ := json.Marshal(val)
+		return string(data), true
+	}
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(float64(len(sorted)-1)*p)]
+}
+
+// Captured is a logged request to build a scenario from.
+type Captured struct {
+	Method  string
+	Path    string
+	Headers map[string][]string
+	Body    string
+	At      time.Time
+}
+
+// maxThink caps think times taken from gaps between captured requests, so
+// an idle tab doesn't turn into minutes of waiting.
+const maxThink = 10 * time.Second
+
+// skippedHeaders aren't copied from captured requests: the tunnel and
+// transport set them, and cookies come from each virtual user's jar.
+var skippedHeaders = []string{
+	"Host", "Content-Length", "Connection", "Accept-Encoding", "Cookie",
+	"X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host", "X-Real-Ip", "Cdn-Loop",
+}
+
+// FromCaptured builds a scenario from requests in the order they were made,
+// with the gaps between them as think times.
+func FromCaptured(reqs []Captured) *File {
+	f := &File{}
+	for i, r := range reqs {
+		s := Step{Method: r.Method, Path: r.Path, Body: r.Body}
+		if s.Method == http.MethodGet {
+			s.Method = ""
+		}
+		for k, v := range r.Headers {
+			k = http.CanonicalHeaderKey(k)
+			if slices.Contains(skippedHeaders, k) || strings.HasPrefix(k, "Cf-") {
+				continue
+			}
+			if s.Headers == nil {
+				s.Headers = make(map[string]string)
+			}
+			s.Headers[k] = strings.Join(v, ", ")
+		}
+		if i+1 < len(reqs) {
+			s.Think = min(reqs[i+1].At.Sub(r.At), maxThink).Round(10 * time.Millisecond)
+		}
+		f.Steps = append(f.Steps, s)
+	}
+	return f
+}
@@ -0,0 +1,249 @@
+package screenshot
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// chromeCandidates are looked up on PATH, in order.
+var chromeCandidates = []string{
+	"google-chrome", "google-chrome-stable", "chromium", "chromium-browser", "chrome", "msedge",
+}
+
+// FindChrome locates a Chrome/Chromium binary. CHROME_PATH takes precedence.
+func FindChrome() (string, error) {
+	if p := os.Getenv("CHROME_PATH"); p != "" {
+		return p, nil
+	}
+	for _, name := range chromeCandidates {
+		if p, err := exec.LookPath(name); err == nil {
+			return p, nil
+		}
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		p := "/Applications/Google Chrome.app/Contents/MacOS/Google Chrome"
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	case "windows":
+		for _, p := range []string{
+			`C:\Program Files\Google\Chrome\Application\chrome.exe`,
+			`C:\Program Files (x86)\Google\Chrome\Application\chrome.exe`,
+		} {
+			if _, err := os.Stat(p); err == nil {
+				return p, nil
+			}
+		}
+	}
+	return "", errors.New("chrome not found (set CHROME_PATH)")
+}
+
+// Options controls the capture.
+type Options struct {
+	Width   int           // viewport width in CSS pixels
+	Timeout time.Duration // overall deadline including page load
+}
+
+// Capture loads url in headless Chrome and returns a full-page PNG.
+// It drives Chrome over the DevTools protocol so content below the fold is included.
+func Capture(url string, opts Options) ([]byte, error) {
+	if opts.Width <= 0 {
+		opts.Width = 1280
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 60 * time.Second
+	}
+
+	bin, err := FindChrome()
+	if err != nil {
+		return nil, err
+	}
+
+	profile, err := os.MkdirTemp("", "prod-chrome-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(profile)
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, bin,
+		"--headless=new",
+		"--disable-gpu",
+		"--hide-scrollbars",
+		"--no-first-run",
+		"--remote-debugging-port=0",
+		"--user-data-dir="+profile,
+		fmt.Sprintf("--window-size=%d,800", opts.Width),
+		"about:blank",
+	)
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start chrome: %w", err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	// Chrome prints the browser endpoint as "DevTools listening on ws://..."
+	wsURL := ""
+	sc := bufio.NewScanner(stderr)
+	for sc.Scan() {
+		if _, after, ok := strings.Cut(sc.Text(), "DevTools listening on "); ok {
+			wsURL = strings.TrimSpace(after)
+			break
+		}
+	}
+	if wsURL == "" {
+		return nil, errors.New("chrome did not expose a DevTools endpoint")
+	}
+	// Keep draining stderr so Chrome never blocks on a full pipe
+	go func() {
+		for sc.Scan() {
+		}
+	}()
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, wsURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to chrome: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(deadline)
+	}
+
+	c := &cdp{conn: conn}
+
+	var target struct {
+		TargetID string `json:"targetId"`
+	}
+	if err := c.call("", "Target.createTarget", map[string]any{"url": "about:blank"}, &target); err != nil {
+		return nil, err
+	}
+	var attached struct {
+		SessionID string `json:"sessionId"`
+	}
+	if err := c.call("", "Target.attachToTarget", map[string]any{"targetId": target.TargetID, "flatten": true}, &attached); err != nil {
+		return nil, err
+	}
+	sid := attached.SessionID
+
+	if err := c.call(sid, "Page.enable", nil, nil); err != nil {
+		return nil, err
+	}
+	if err := c.call(sid, "Page.navigate", map[string]any{"url": url}, nil); err != nil {
+		return nil, err
+	}
+	if err := c.waitEvent(sid, "Page.loadEventFired"); err != nil {
+		return nil, err
+	}
+
+	var metrics struct {
+		CSSContentSize struct {
+			Width  float64 `json:"width"`
+			Height float64 `json:"height"`
+		} `json:"cssContentSize"`
+	}
+	if err := c.call(sid, "Page.getLayoutMetrics", nil, &metrics); err != nil {
+		return nil, err
+	}
+
+	var shot struct {
+		Data string `json:"data"`
+	}
+	err = c.call(sid, "Page.captureScreenshot", map[string]any{
+		"format":                "png",
+		"captureBeyondViewport": true,
+		"clip": map[string]any{
+			"x": 0, "y": 0,
+			"width":  metrics.CSSContentSize.Width,
+			"height": metrics.CSSContentSize.Height,
+			"scale":  1,
+		},
+	}, &shot)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(shot.Data)
+}
+
+// cdp is a minimal synchronous DevTools protocol client. Events that arrive
+// while waiting for a call result are buffered for waitEvent.
+type cdp struct {
+	conn   *websocket.Conn
+	nextID int
+	events []cdpMessage
+}
+
+type cdpMessage struct {
+	ID        int             `json:"id,omitempty"`
+	SessionID string          `json:"sessionId,omitempty"`
+	Method    string          `json:"method,omitempty"`
+	Params    any             `json:"params,omitempty"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	Error     *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+func (c *cdp) call(sessionID, method string, params any, result any) error {
+	c.nextID++
+	id := c.nextID
+	if err := c.conn.WriteJSON(cdpMessage{ID: id, SessionID: sessionID, Method: method, Params: params}); err != nil {
+		return err
+	}
+	for {
+		var msg cdpMessage
+		if err := c.conn.ReadJSON(&msg); err != nil {
+			return fmt.Errorf("%s: %w", method, err)
+		}
+		if msg.ID != id {
+			if msg.Method != "" {
+				c.events = append(c.events, msg)
+			}
+			continue
+		}
+		if msg.Error != nil {
+			return fmt.Errorf("%s: %s", method, msg.Error.Message)
+		}
+		if result != nil {
+			return json.Unmarshal(msg.Result, result)
+		}
+		return nil
+	}
+}
+
+func (c *cdp) waitEvent(sessionID, method string) error {
+	for i, ev := range c.events {
+		if ev.Method == method && ev.SessionID == sessionID {
+			c.events = append(c.events[:i], c.events[i+1:]...)
+			return nil
+		}
+	}
+	for {
+		var msg cdpMessage
+		if err := c.conn.ReadJSON(&msg); err != nil {
+			return fmt.Errorf("waiting for %s: %w", method, err)
+		}
+		if msg.Method == method && msg.SessionID == sessionID {
+			return nil
+		}
+	}
+}
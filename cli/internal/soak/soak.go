@@ -0,0 +1,209 @@
+// Package soak drives synthetic traffic through a running tunnel for a long
+// period and watches for drift: rising latency, growing memory or goroutines.
+package soak
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/audit"
+	"github.com/QuadTriangle/prod.bd/cli/internal/storage"
+)
+
+// Config describes one soak run.
+type Config struct {
+	Subdomain string
+	URL       string        // public URL traffic is sent to
+	RPS       int           // requests per second
+	Duration  time.Duration // total run time
+	Window    time.Duration // sampling interval for the report
+	// RuntimeURL is the tunnel process's /api/v1/stats/runtime endpoint.
+	// Empty skips memory/goroutine sampling.
+	RuntimeURL string
+}
+
+// Sample is one reporting window.
+type Sample struct {
+	At         int64   `json:"at"` // unix seconds, end of window
+	Requests   int     `json:"requests"`
+	Errors     int     `json:"errors"` // transport errors and 5xx
+	P50Ms      float64 `json:"p50_ms"`
+	P99Ms      float64 `json:"p99_ms"`
+	Goroutines int     `json:"goroutines,omitempty"`
+	HeapMB     float64 `json:"heap_mb,omitempty"`
+}
+
+// Report is the result of a soak run, saved next to audit reports.
+type Report struct {
+	Subdomain string   `json:"subdomain"`
+	URL       string   `json:"url"`
+	RPS       int      `json:"rps"`
+	Duration  string   `json:"duration"`
+	Requests  int      `json:"requests"`
+	Errors    int      `json:"errors"`
+	Samples   []Sample `json:"samples"`
+	// Drift compares the last window against the first.
+	LatencyDriftMs float64 `json:"latency_drift_ms"` // p99
+	GoroutineDrift int     `json:"goroutine_drift"`  // count
+	HeapDriftMB    float64 `json:"heap_drift_mb"`    // MB
+	CreatedAt      int64   `json:"created_at"`
+}
+
+// window accumulates results between samples.
+type window struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+	errors    int
+}
+
+func (w *window) record(d time.Duration, failed bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.latencies = append(w.latencies, d)
+	if failed {
+		w.errors++
+	}
+}
+
+// flush returns and resets the window's results.
+func (w *window) flush() ([]time.Duration, int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	l, e := w.latencies, w.errors
+	w.latencies, w.errors = nil, 0
+	return l, e
+}
+
+// Run sends cfg.RPS GET requests per second to cfg.URL until cfg.Duration
+// elapses or ctx is cancelled. progress, if set, is called after each window.
+func Run(ctx context.Context, cfg Config, progress func(Sample)) (*Report, error) {
+	if cfg.RPS <= 0 {
+		return nil, fmt.Errorf("rps must be > 0")
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = time.Minute
+	}
+	ctx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	report := &Report{
+		Subdomain: cfg.Subdomain,
+		URL:       cfg.URL,
+		RPS:       cfg.RPS,
+		Duration:  cfg.Duration.String(),
+		CreatedAt: time.Now().Unix(),
+	}
+
+	var win window
+	var inflight sync.WaitGroup
+	send := func() {
+		defer inflight.Done()
+		start := time.Now()
+		resp, err := client.Get(cfg.URL)
+		if err != nil {
+			win.record(time.Since(start), true)
+			return
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		win.record(time.Since(start), resp.StatusCode >= 500)
+	}
+
+	tick := time.NewTicker(time.Second / time.Duration(cfg.RPS))
+	defer tick.Stop()
+	sampleTick := time.NewTicker(cfg.Window)
+	defer sampleTick.Stop()
+
+	takeSample := func() {
+		s := summarize(win.flush())
+		s.At = time.Now().Unix()
+		if cfg.RuntimeURL != "" {
+			fetchRuntime(client, cfg.RuntimeURL, &s)
+		}
+		report.Samples = append(report.Samples, s)
+		report.Requests += s.Requests
+		report.Errors += s.Errors
+		if progress != nil {
+			progress(s)
+		}
+	}
+
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-tick.C:
+			inflight.Add(1)
+			go send()
+		case <-sampleTick.C:
+			takeSample()
+		}
+	}
+	inflight.Wait()
+	takeSample()
+
+	if n := len(report.Samples); n > 1 {
+		first, last := report.Samples[0], report.Samples[n-1]
+		report.LatencyDriftMs = last.P99Ms - first.P99Ms
+		report.GoroutineDrift = last.Goroutines - first.Goroutines
+		report.HeapDriftMB = last.HeapMB - first.HeapMB
+	}
+	return report, nil
+}
+
+func summarize(latencies []time.Duration, errors int) Sample {
+	s := Sample{Requests: len(latencies), Errors: errors}
+	if len(latencies) == 0 {
+		return s
+	}
+	slices.Sort(latencies)
+	pct := func(p float64) float64 {
+		i := int(float64(len(latencies)-1) * p)
+		return float64(latencies[i].Microseconds()) / 1000
+	}
+	s.P50Ms = pct(0.50)
+	s.P99Ms = pct(0.99)
+	return s
+}
+
+// fetchRuntime fills the process stats of s from the tunnel's runtime endpoint.
+func fetchRuntime(client *http.Client, url string, s *Sample) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	var rt struct {
+		Goroutines int    `json:"goroutines"`
+		HeapAlloc  uint64 `json:"heap_alloc"`
+	}
+	if json.NewDecoder(resp.Body).Decode(&rt) == nil {
+		s.Goroutines = rt.Goroutines
+		s.HeapMB = float64(rt.HeapAlloc) / (1 << 20)
+	}
+}
+
+// Save stores the report alongside audit reports and returns where it went.
+func Save(r *Report) (string, error) {
+	store, err := storage.Default()
+	if err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	where, err := store.Put(audit.ReportsCollection, fmt.Sprintf("soak-%s-%d", r.Subdomain, r.CreatedAt), data)
+	if err != nil {
+		return "", fmt.Errorf("failed to write report: %w", err)
+	}
+	return where, nil
+}
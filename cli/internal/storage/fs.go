@@ -0,0 +1,227 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fsRequestsFile holds captured requests, one JSON object per line.
+const fsRequestsFile = "requests.jsonl"
+
+// FS keeps documents as <dir>/<collection>/<key>.json and requests in
+// <dir>/requests.jsonl. Queries scan the whole file, so it suits the
+// history of one developer rather than a team.
+type FS struct {
+	dir string
+
+	mu     sync.Mutex // guards the requests file and nextID
+	nextID int64
+}
+
+// OpenFS opens (or creates) a store rooted at dir.
+func OpenFS(dir string) (Backend, error) {
+	dir, err := expandHome(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	f := &FS{dir: dir}
+	// IDs continue from the last line, so they stay unique across runs
+	err = f.scan(func(r StoredRequest) bool {
+		f.nextID = max(f.nextID, r.ID)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// scan calls fn for each stored request, oldest first, until it returns
+// false. Lines that don't decode (a write cut short) are skipped.
+func (f *FS) scan(fn func(StoredRequest) bool) error {
+	file, err := os.Open(filepath.Join(f.dir, fsRequestsFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	sc := bufio.NewScanner(file)
+	sc.Buffer(nil, 64<<20)
+	for sc.Scan() {
+		var r StoredRequest
+		if json.Unmarshal(sc.Bytes(), &r) != nil {
+			continue
+		}
+		if !fn(r) {
+			break
+		}
+	}
+	return sc.Err()
+}
+
+func (f *FS) AppendRequest(r Request) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	line, err := json.Marshal(StoredRequest{ID: f.nextID + 1, Request: r})
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filepath.Join(f.dir, fsRequestsFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	f.nextID++
+	return nil
+}
+
+func (f *FS) QueryRequests(q Query) ([]StoredRequest, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []StoredRequest
+	err := f.scan(func(r StoredRequest) bool {
+		switch {
+		case q.Subdomain != "" && r.Subdomain != q.Subdomain:
+		case !q.Since.IsZero() && r.Timestamp.Before(q.Since):
+		case !q.Until.IsZero() && !r.Timestamp.Before(q.Until):
+		default:
+			out = append(out, r)
+		}
+		return true
+	})
+	slices.Reverse(out)
+	if q.Limit > 0 && len(out) > q.Limit {
+		out = out[:q.Limit]
+	}
+	return out, err
+}
+
+func (f *FS) PruneRequests(cutoff time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var kept bytes.Buffer
+	dropped := false
+	err := f.scan(func(r StoredRequest) bool {
+		if r.Timestamp.Before(cutoff) {
+			dropped = true
+			return true
+		}
+		line, _ := json.Marshal(r)
+		kept.Write(append(line, '\n'))
+		return true
+	})
+	if err != nil || !dropped {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(f.dir, fsRequestsFile), kept.Bytes(), 0600)
+}
+
+// docPath returns where a document lives, refusing keys that would escape
+// the collection's directory.
+func (f *FS) docPath(collection, key string) (string, error) {
+	for _, part := range []string{collection, key} {
+		if strings.ContainsAny(part, `/\`) || part == "." || part == ".." {
+			return "", fmt.Errorf("invalid document name %q", part)
+		}
+	}
+	if key == "" {
+		return "", fmt.Errorf("document key is required")
+	}
+	return filepath.Join(f.dir, collection, key+".json"), nil
+}
+
+func (f *FS) Put(collection, key string, data []byte) (string, error) {
+	path, err := f.docPath(collection, key)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	return path, os.WriteFile(path, data, 0644)
+}
+
+func (f *FS) Get(collection, key string) ([]byte, error) {
+	path, err := f.docPath(collection, key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (f *FS) List(collection, prefix string) ([][]byte, error) {
+	entries, err := os.ReadDir(filepath.Join(f.dir, collection))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out [][]byte
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), prefix) || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(f.dir, collection, e.Name()))
+		if err != nil {
+			continue
+		}
+		out = append(out, data)
+	}
+	return out, nil
+}
+
+func (f *FS) Delete(collection, key string) error {
+	path, err := f.docPath(collection, key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (f *FS) Close() error { return nil }
+
+// writeFileAtomic replaces path via a temporary file, so readers never see
+// it half written.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS requests (
+	id               INTEGER PRIMARY KEY AUTOINCREMENT,
+	session          TEXT    NOT NULL,
+	entry_id         INTEGER NOT NULL,
+	subdomain        TEXT    NOT NULL,
+	method           TEXT    NOT NULL,
+	path             TEXT    NOT NULL,
+	status           INTEGER NOT NULL,
+	latency_us       INTEGER NOT NULL,
+	bytes_in         INTEGER NOT NULL,
+	bytes_out        INTEGER NOT NULL,
+	created_at       INTEGER NOT NULL,
+	request_headers  TEXT,
+	request_body     TEXT,
+	response_headers TEXT,
+	response_body    TEXT,
+	replay_of        INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS requests_created_at ON requests (created_at);
+CREATE INDEX IF NOT EXISTS requests_subdomain ON requests (subdomain, created_at);
+CREATE TABLE IF NOT EXISTS documents (
+	collection TEXT NOT NULL,
+	key        TEXT NOT NULL,
+	data       BLOB NOT NULL,
+	PRIMARY KEY (collection, key)
+);
+`
+
+// SQLite keeps everything in one SQLite file.
+type SQLite struct {
+	db   *sql.DB
+	path string
+}
+
+// OpenSQLite opens (or creates) the database at path.
+func OpenSQLite(path string) (Backend, error) {
+	path, err := expandHome(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer; a single connection avoids SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("initializing %s: %w", path, err)
+	}
+	return &SQLite{db: db, path: path}, nil
+}
+
+func (s *SQLite) AppendRequest(r Request) error {
+	reqHeaders, _ := json.Marshal(r.RequestHeaders)
+	respHeaders, _ := json.Marshal(r.ResponseHeaders)
+	_, err := s.db.Exec(`INSERT INTO requests
+		(session, entry_id, subdomain, method, path, status, latency_us, bytes_in, bytes_out,
+		 created_at, request_headers, request_body, response_headers, response_body, replay_of)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.Session, r.EntryID, r.Subdomain, r.Method, r.Path, r.Status, r.Latency.Microseconds(),
+		r.BytesIn, r.BytesOut, r.Timestamp.UnixMilli(),
+		string(reqHeaders), r.RequestBody, string(respHeaders), r.ResponseBody, r.ReplayOf)
+	return err
+}
+
+func (s *SQLite) QueryRequests(q Query) ([]StoredRequest, error) {
+	var where []string
+	var args []any
+	if q.Subdomain != "" {
+		where = append(where, "subdomain = ?")
+		args = append(args, q.Subdomain)
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, q.Since.UnixMilli())
+	}
+	if !q.Until.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, q.Until.UnixMilli())
+	}
+	query := `SELECT id, session, entry_id, subdomain, method, path, status, latency_us, bytes_in,
+		bytes_out, created_at, request_headers, request_body, response_headers, response_body, replay_of
+		FROM requests`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if q.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []StoredRequest
+	for rows.Next() {
+		var r StoredRequest
+		var latencyUS, createdAt int64
+		var reqHeaders, respHeaders sql.NullString
+		if err := rows.Scan(&r.ID, &r.Session, &r.EntryID, &r.Subdomain, &r.Method, &r.Path, &r.Status,
+			&latencyUS, &r.BytesIn, &r.BytesOut, &createdAt, &reqHeaders, &r.RequestBody,
+			&respHeaders, &r.ResponseBody, &r.ReplayOf); err != nil {
+			return nil, err
+		}
+		r.Latency = time.Duration(latencyUS) * time.Microsecond
+		r.Timestamp = time.UnixMilli(createdAt)
+		_ = json.Unmarshal([]byte(reqHeaders.String), &r.RequestHeaders)
+		_ = json.Unmarshal([]byte(respHeaders.String), &r.ResponseHeaders)
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLite) PruneRequests(cutoff time.Time) error {
+	_, err := s.db.Exec(`DELETE FROM requests WHERE created_at < ?`, cutoff.UnixMilli())
+	return err
+}
+
+func (s *SQLite) Put(collection, key string, data []byte) (string, error) {
+	_, err := s.db.Exec(`INSERT INTO documents (collection, key, data) VALUES (?, ?, ?)
+		ON CONFLICT (collection, key) DO UPDATE SET data = excluded.data`, collection, key, data)
+	return s.path + "#" + strings.TrimPrefix(collection+"/"+key, "/"), err
+}
+
+func (s *SQLite) Get(collection, key string) ([]byte, error) {
+	var data []byte
+	err := s.db.QueryRow(`SELECT data FROM documents WHERE collection = ? AND key = ?`, collection, key).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (s *SQLite) List(collection, prefix string) ([][]byte, error) {
+	// substr rather than LIKE, which would treat _ and % in prefix as wildcards
+	rows, err := s.db.Query(`SELECT data FROM documents
+		WHERE collection = ? AND substr(key, 1, length(?)) = ? ORDER BY key`, collection, prefix, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out [][]byte
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		out = append(out, data)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLite) Delete(collection, key string) error {
+	_, err := s.db.Exec(`DELETE FROM documents WHERE collection = ? AND key = ?`, collection, key)
+	return err
+}
+
+func (s *SQLite) Close() error { return s.db.Close() }
@@ -0,0 +1,164 @@
+// Package storage is where prod keeps what outlives a session: captured
+// requests, audit reports and session notes. Callers program against
+// Backend; SQLite and plain files are built in, and other backends (say,
+// Postgres for a team server) can be added with Register without touching
+// the plugins that use them.
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/config"
+)
+
+// ErrNotFound is returned by Documents.Get for a missing key.
+var ErrNotFound = errors.New("not found")
+
+// Request is a captured request/response pair as persisted.
+type Request struct {
+	Session         string // distinguishes EntryIDs from different CLI runs
+	EntryID         int    // ID the request had in its session
+	Subdomain       string
+	Method          string
+	Path            string
+	Status          int
+	Latency         time.Duration
+	BytesIn         int
+	BytesOut        int
+	Timestamp       time.Time
+	RequestHeaders  map[string][]string
+	RequestBody     string
+	ResponseHeaders map[string][]string
+	ResponseBody    string
+	ReplayOf        int
+}
+
+// StoredRequest is a Request with the ID its backend assigned.
+type StoredRequest struct {
+	ID int64
+	Request
+}
+
+// Query filters Requests.Query. Zero values mean no filter.
+type Query struct {
+	Subdomain string
+	Since     time.Time
+	Until     time.Time
+	Limit     int
+}
+
+// Requests holds captured traffic.
+type Requests interface {
+	AppendRequest(r Request) error
+	// QueryRequests returns matching requests, newest first.
+	QueryRequests(q Query) ([]StoredRequest, error)
+	// PruneRequests deletes requests captured before cutoff.
+	PruneRequests(cutoff time.Time) error
+}
+
+// Documents holds JSON documents by key, grouped in collections
+// ("reports", ...). The empty collection is the top level.
+type Documents interface {
+	// Put stores data under key and says where it went, for messages.
+	Put(collection, key string, data []byte) (where string, err error)
+	// Get returns ErrNotFound if key doesn't exist.
+	Get(collection, key string) ([]byte, error)
+	// List returns the documents whose keys start with prefix, by key.
+	List(collection, prefix string) ([][]byte, error)
+	Delete(collection, key string) error
+}
+
+// Backend is one place to persist everything.
+type Backend interface {
+	Requests
+	Documents
+	Close() error
+}
+
+// Opener opens a backend at a location (a path, or a DSN for servers).
+type Opener func(location string) (Backend, error)
+
+var (
+	backendsMu sync.Mutex
+	backends   = map[string]Opener{
+		"sqlite": OpenSQLite,
+		"fs":     OpenFS,
+	}
+)
+
+// Register adds a backend for "<scheme>:<location>" in Open.
+func Register(scheme string, open Opener) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	backends[scheme] = open
+}
+
+// Open opens a backend from "<scheme>:<location>", e.g.
+// "sqlite:~/.prod/requests.db" or "fs:~/.prod". A bare path is a SQLite
+// file, as -log-db has always taken.
+func Open(spec string) (Backend, error) {
+	scheme, location, ok := strings.Cut(spec, ":")
+	if !ok || len(scheme) < 2 || strings.ContainsAny(scheme, `/\.~`) {
+		// No scheme, or a Windows drive letter
+		return OpenSQLite(spec)
+	}
+	backendsMu.Lock()
+	open := backends[scheme]
+	backendsMu.Unlock()
+	if open == nil {
+		return nil, fmt.Errorf("unknown storage backend %q (%s)", scheme, strings.Join(Schemes(), ", "))
+	}
+	return open(location)
+}
+
+// Schemes lists the registered backends.
+func Schemes() []string {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	out := make([]string, 0, len(backends))
+	for s := range backends {
+		out = append(out, s)
+	}
+	sort.Strings(out)
+	return out
+}
+
+var (
+	defaultOnce    sync.Once
+	defaultBackend Backend
+	defaultErr     error
+)
+
+// Default returns the shared backend for reports and notes: files under
+// ~/.prod, opened on first use.
+func Default() (Backend, error) {
+	defaultOnce.Do(func() {
+		dir, err := config.Dir()
+		if err != nil {
+			defaultErr = err
+			return
+		}
+		defaultBackend, defaultErr = OpenFS(dir)
+	})
+	return defaultBackend, defaultErr
+}
+
+// expandHome resolves a leading ~/ to the home directory.
+func expandHome(path string) (string, error) {
+	rest, ok := strings.CutPrefix(path, "~/")
+	if !ok {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, rest), nil
+}
@@ -0,0 +1,40 @@
+package tunnel
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Backoff controls how StartTunnel waits between reconnect attempts.
+// The first retry after a drop is immediate; after that the delay doubles
+// from Initial up to Max, with jitter so many tunnels don't reconnect in
+// lockstep after a worker outage.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+}
+
+// DefaultBackoff is used when StartTunnel is given a zero Backoff.
+var DefaultBackoff = Backoff{Initial: time.Second, Max: time.Minute}
+
+// Delay returns how long to wait before reconnect attempt n (1-based).
+func (b Backoff) Delay(attempt int) time.Duration {
+	if attempt <= 1 {
+		return 0
+	}
+	if b.Initial <= 0 {
+		b.Initial = DefaultBackoff.Initial
+	}
+	if b.Max <= 0 {
+		b.Max = DefaultBackoff.Max
+	}
+
+	d := b.Initial
+	for i := 2; i < attempt && d < b.Max; i++ {
+		d *= 2
+	}
+	d = min(d, b.Max)
+	// Equal jitter: somewhere in [d/2, d)
+	half := d / 2
+	return half + rand.N(d-half)
+}
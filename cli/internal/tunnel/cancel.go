@@ -0,0 +1,63 @@
+package tunnel
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// statusClientClosed is reported for requests the worker cancelled, as
+// nginx does for visitors who hung up.
+const statusClientClosed = 499
+
+// cancels tracks the contexts of HTTP requests in flight, so an
+// http-cancel from the worker (the visitor went away, or it stopped
+// waiting) aborts the local request instead of finishing work no one reads.
+type cancels struct {
+	mu      sync.Mutex
+	running map[string]context.CancelFunc
+	early   map[string]time.Time // cancelled while still queued
+}
+
+func newCancels() *cancels {
+	return &cancels{running: make(map[string]context.CancelFunc), early: make(map[string]time.Time)}
+}
+
+// track returns the context to serve request id with, already done if the
+// request was cancelled before it started, and the func to call when it is
+// served.
+func (c *cancels) track(id string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.early[id]; ok {
+		delete(c.early, id)
+		cancel()
+		return ctx, cancel
+	}
+	c.running[id] = cancel
+	return ctx, func() {
+		c.mu.Lock()
+		delete(c.running, id)
+		c.mu.Unlock()
+		cancel()
+	}
+}
+
+// cancel aborts request id, or remembers to if it hasn't started yet. Late
+// cancels for requests already answered are forgotten after resumeWindow.
+func (c *cancels) cancel(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cancel, ok := c.running[id]; ok {
+		cancel()
+		return
+	}
+	cutoff := time.Now().Add(-resumeWindow)
+	for early, at := range c.early {
+		if at.Before(cutoff) {
+			delete(c.early, early)
+		}
+	}
+	c.early[id] = time.Now()
+}
@@ -0,0 +1,56 @@
+package tunnel
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCancelRunningRequest(t *testing.T) {
+	c := newCancels()
+	ctx, done := c.track("r1")
+	if ctx.Err() != nil {
+		t.Fatal("request cancelled before anyone asked")
+	}
+	c.cancel("r1")
+	if ctx.Err() == nil {
+		t.Fatal("cancel didn't reach the running request")
+	}
+	done()
+	if len(c.running) != 0 || len(c.early) != 0 {
+		t.Fatalf("left behind running %v, early %v", c.running, c.early)
+	}
+}
+
+func TestCancelBeforeStart(t *testing.T) {
+	c := newCancels()
+	// Cancelled while queued behind -max-concurrent
+	c.cancel("r1")
+	ctx, done := c.track("r1")
+	defer done()
+	if ctx.Err() == nil {
+		t.Fatal("request cancelled while queued was started")
+	}
+	if len(c.early) != 0 {
+		t.Fatalf("early cancel kept after use: %v", c.early)
+	}
+
+	// Others are unaffected
+	ctx, done = c.track("r2")
+	defer done()
+	if ctx.Err() != nil {
+		t.Fatal("cancel of r1 reached r2")
+	}
+}
+
+func TestLateCancelsForgotten(t *testing.T) {
+	c := newCancels()
+	c.cancel("answered")
+	c.early["answered"] = time.Now().Add(-resumeWindow - time.Second)
+	c.cancel("r2")
+	if _, ok := c.early["answered"]; ok {
+		t.Fatal("cancel for a request answered long ago kept")
+	}
+	if _, ok := c.early["r2"]; !ok {
+		t.Fatal("fresh early cancel dropped")
+	}
+}
@@ -2,14 +2,24 @@ package tunnel
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"maps"
 	"net/http"
 	"net/url"
+	"runtime/debug"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/QuadTriangle/prod.bd/cli/internal/config"
+	"github.com/QuadTriangle/prod.bd/cli/internal/failpoint"
 	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
 	"github.com/QuadTriangle/prod.bd/cli/internal/proxy"
 	"github.com/QuadTriangle/prod.bd/cli/internal/types"
@@ -17,11 +27,36 @@ import (
 	"github.com/gorilla/websocket"
 )
 
-func Register(clientID string, ports []int, workerBaseURL string, workerConfig map[string]any) (map[int]string, error) {
+// ErrUnauthorized is returned when the worker rejects the account token
+// (missing, invalid or revoked).
+var ErrUnauthorized = errors.New("worker rejected credentials; run `prod login` with a valid token")
+
+// authHeader returns the Authorization header for the saved account token.
+func authHeader() (http.Header, error) {
+	token, err := config.GetToken()
+	if err != nil {
+		return nil, err
+	}
+	h := http.Header{}
+	if token != "" {
+		h.Set("Authorization", "Bearer "+token)
+	}
+	return h, nil
+}
+
+// Register allocates subdomains for ports. subdomains optionally maps a port
+// to the subdomain it should be reserved under; the worker rejects the whole
+// registration if any requested subdomain is taken or invalid.
+// tcpPorts lists the ports (also present in ports) to tunnel as raw TCP, and
+// labels optionally names ports for display.
+func Register(clientID string, ports []int, subdomains map[int]string, tcpPorts []int, labels map[int]string, workerBaseURL string, workerConfig map[string]any) (map[int]string, error) {
 	reqBody := types.RegisterRequest{
-		ClientID: clientID,
-		Ports:    ports,
-		Config:   workerConfig,
+		ClientID:   clientID,
+		Ports:      ports,
+		Subdomains: subdomains,
+		TCPPorts:   tcpPorts,
+		Labels:     labels,
+		Config:     workerConfig,
 	}
 
 	data, err := json.Marshal(reqBody)
@@ -29,80 +64,140 @@ func Register(clientID string, ports []int, workerBaseURL string, workerConfig m
 		return nil, err
 	}
 
-	resp, err := http.Post(workerBaseURL+"/api/register", "application/json", bytes.NewBuffer(data))
+	header, err := authHeader()
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, workerBaseURL+"/api/register", bytes.NewBuffer(data))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header = header
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := workerHTTP.Do(httpReq)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("server returned status: %d", resp.StatusCode)
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, ErrUnauthorized
 	}
 
 	var res types.RegisterResponse
-	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
-		return nil, err
-	}
+	decodeErr := json.NewDecoder(resp.Body).Decode(&res)
 
+	// Error responses carry a JSON message (e.g. subdomain already taken)
 	if res.Error != "" {
 		return nil, fmt.Errorf("server error: %s", res.Error)
 	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("server returned status: %d", resp.StatusCode)
+	}
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
 
 	return res.Tunnels, nil
 }
 
-func StartTunnel(subdomain string, localPort int, workerBaseURL string, pipeline *hooks.Pipeline, done <-chan struct{}) {
+// StartTunnel keeps the tunnel for subdomain connected until done is closed,
+// reconnecting with backoff after each drop, and right away when the
+// network changes. Requests in flight across a reconnect are resumed on the
+// new connection. On shutdown, requests already being served get up to
+// drainTimeout to finish before the tunnel closes.
+func StartTunnel(subdomain string, localPort int, workerBaseURL string, p *hooks.Pipeline, backoff Backoff, drainTimeout time.Duration, done <-chan struct{}) {
 	u, _ := url.Parse(workerBaseURL)
 	scheme := "wss"
 	if u.Scheme == "http" {
 		scheme = "ws"
 	}
 
-	wsURL := fmt.Sprintf("%s://%s/_tunnel?subdomain=%s", scheme, u.Host, subdomain)
+	sess := newSession()
+	wsURL := fmt.Sprintf("%s://%s/_tunnel?subdomain=%s&session=%s", scheme, u.Host, subdomain, sess.id)
+	if tcpPorts[localPort] {
+		// The worker then relays visitor WebSockets as TCP streams
+		wsURL += "&mode=tcp"
+	}
+	pipeline := p.ForTunnel(subdomain, localPort)
+	watchPower(pipeline.Events())
+	go monitorTarget(subdomain, localPort, pipeline, done)
 
 	// Retry loop
+	attempt := 0
 	for {
 		select {
 		case <-done:
-			log.Printf("Tunnel %s shutting down", subdomain)
+			Logf(localPort, "Tunnel %s shutting down", subdomain)
 			return
 		default:
 		}
 
-		log.Printf("Connecting to %s (port %d)...", subdomain, localPort)
-		if err := connectAndServe(wsURL, localPort, subdomain, pipeline, done); err != nil {
-			pipeline.NotifyDisconnect(subdomain, err)
-			log.Printf("Tunnel %s disconnected: %v. Retrying in 5s...", subdomain, err)
-			select {
-			case <-done:
-				return
-			case <-time.After(5 * time.Second):
-			}
+		Logf(localPort, "Connecting to %s (port %d)...", subdomain, localPort)
+		connected, err := connectAndServe(wsURL, localPort, subdomain, pipeline, sess, networkChanged(u.Host), drainTimeout, done)
+		if err == nil {
+			continue
+		}
+		pipeline.NotifyDisconnect(err)
+		// Retrying won't fix bad credentials
+		if errors.Is(err, ErrUnauthorized) {
+			Logf(localPort, "Tunnel %s stopped: %v", subdomain, err)
+			return
+		}
+
+		// A drop after a working connection starts the backoff over
+		if connected {
+			attempt = 0
+		}
+		attempt++
+		delay := backoff.Delay(attempt)
+		pipeline.NotifyRetry(attempt, delay, err)
+		pipeline.Events().Publish(hooks.TunnelDegraded{Subdomain: subdomain, Attempt: attempt, Err: err})
+		Logf(localPort, "Tunnel %s disconnected: %v. Retrying in %s (attempt %d)...", subdomain, err, delay.Round(time.Millisecond), attempt)
+		select {
+		case <-done:
+			return
+		case <-time.After(delay):
+		case <-networkChanged(u.Host):
+			// A new network may well work; don't sit out the backoff
 		}
 	}
 }
 
-func connectAndServe(wsURL string, localPort int, subdomain string, pipeline *hooks.Pipeline, done <-chan struct{}) error {
-	c, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+// connectAndServe runs one tunnel connection of sess, until it fails or
+// netChanged closes. connected reports whether the websocket was
+// established before the error.
+func connectAndServe(wsURL string, localPort int, subdomain string, pipeline *hooks.TunnelPipeline, sess *session, netChanged <-chan struct{}, drainTimeout time.Duration, done <-chan struct{}) (connected bool, err error) {
+	header, err := authHeader()
+	if err != nil {
+		return false, err
+	}
+	// Count the connection's bytes for Usage
+	dialer := workerDialer()
+	dialer.NetDialContext = wireCounterFor(subdomain).dial
+	c, resp, err := dialer.Dial(wsURL, header)
 	if err != nil {
-		return err
+		if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+			return false, ErrUnauthorized
+		}
+		return false, err
 	}
 	defer c.Close()
 
-	pipeline.NotifyConnect(subdomain, localPort)
-	log.Printf("Tunnel established for port %d", localPort)
+	Logf(localPort, "Tunnel established for port %d", localPort)
+	pipeline.NotifyConnect(warmup(localPort))
 
-	// Close WebSocket when shutdown signal received
-	go func() {
-		<-done
-		c.WriteMessage(websocket.CloseMessage,
-			websocket.FormatCloseMessage(websocket.CloseNormalClosure, "shutdown"))
-		c.Close()
-	}()
+	// Compress buffered responses if the worker can inflate them
+	gzipOK := workerDecodes(resp.Header, types.EncodingGzip)
 
 	// Thread-safe writer
 	var writeMutex sync.Mutex
 	writeJSON := func(v any) error {
+		failpoint.DelayWrite()
+		if r, ok := v.(types.TunnelResponse); ok && gzipOK {
+			v = proxy.CompressResponse(r)
+		}
 		writeMutex.Lock()
 		defer writeMutex.Unlock()
 		return c.WriteJSON(v)
@@ -112,80 +207,228 @@ func connectAndServe(wsURL string, localPort int, subdomain string, pipeline *ho
 		defer writeMutex.Unlock()
 		return c.WriteMessage(websocket.TextMessage, []byte(msg))
 	}
+	writeBinary := func(data []byte) error {
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+		return c.WriteMessage(websocket.BinaryMessage, data)
+	}
+
+	// Responses, including those of requests from a previous connection,
+	// go out here from now on
+	cw := &connWriter{write: writeJSON}
+	sess.attach(cw)
+	defer sess.detach(cw)
 
-	// Keepalive: ping every 30s to prevent idle disconnects
+	// On shutdown, stop taking requests and let in-flight ones finish
+	// before closing the WebSocket
+	requests := sess.requests
 	go func() {
-		ticker := time.NewTicker(30 * time.Second)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-done:
-				return
-			case <-ticker.C:
-				if err := writeText("ping"); err != nil {
-					log.Printf("Keepalive ping failed: %v", err)
-					return
-				}
-			}
+		<-done
+		if n := requests.drain(drainTimeout); n > 0 {
+			Logf(localPort, "Tunnel %s: drain timeout, abandoning %d in-flight request(s)", subdomain, n)
+		}
+		writeMutex.Lock()
+		c.WriteMessage(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, "shutdown"))
+		writeMutex.Unlock()
+		c.Close()
+	}()
+
+	// Keepalive: ping to prevent idle disconnects, and reconnect when the
+	// worker stops answering
+	var ka keepalive
+	stop := make(chan struct{})
+	defer close(stop)
+	c.SetReadDeadline(ka.readDeadline())
+	go ka.run(c.Close, writeText, localPort, pipeline, stop, done)
+
+	// Move to the new network right away; the old connection may take
+	// minutes to time out
+	var moved atomic.Bool
+	go func() {
+		select {
+		case <-netChanged:
+			Logf(localPort, "Network changed, moving tunnel %s to the new connection", subdomain)
+			moved.Store(true)
+			c.Close()
+		case <-stop:
+		case <-done:
 		}
 	}()
 
 	// WebSocket relay for visitor WS sessions
 	wsRelay := proxy.NewWSRelay(localPort, writeJSON)
+	// Raw TCP relay for tunnels registered with -tcp
+	tcpRelay := proxy.NewTCPRelay(localPort, writeJSON, writeBinary)
+
+	// HTTP requests beyond -max-concurrent queue by priority class
+	pool := newWorkerPool(maxConcurrent, maxQueued, func(queued int, rejected bool) {
+		pipeline.Events().Publish(hooks.QueueChanged{Subdomain: subdomain, Queued: queued, Rejected: rejected})
+	})
+
+	// Tell the worker (and stats) about messages we can't handle
+	reportError := func(e types.TunnelError) {
+		reportProtocolError(e, subdomain, writeJSON, pipeline)
+	}
 
 	// Main read loop
 	for {
-		_, message, err := c.ReadMessage()
+		msgType, message, err := c.ReadMessage()
 		if err != nil {
-			return err
+			if moved.Load() {
+				return true, errNetworkChanged
+			}
+			return true, ka.readErr(err, pipeline)
+		}
+		ka.heard()
+		c.SetReadDeadline(ka.readDeadline())
+		if len(message) > types.MaxMessageSize {
+			env := peekEnvelope(message)
+			reportError(types.TunnelError{
+				ID:      env.ID,
+				MsgType: env.Type,
+				Code:    types.ErrCodeTooLarge,
+				Message: fmt.Sprintf("message of %d bytes exceeds %d", len(message), types.MaxMessageSize),
+			})
+			continue
+		}
+
+		if failpoint.DropFrame() {
+			continue
 		}
+		message = failpoint.Corrupt(message)
 
 		if string(message) == "pong" {
 			continue
 		}
+		powerActive()
 
-		go handleMessage(message, localPort, subdomain, writeJSON, wsRelay, pipeline)
+		// TCP streams are order-sensitive, so dispatch them from the read loop
+		// instead of a goroutine per message. The relay only queues the data.
+		if msgType == websocket.BinaryMessage {
+			handleTCPData(message, localPort, tcpRelay, reportError)
+			continue
+		}
+		if handleTCPMessage(message, tcpRelay, pipeline, writeJSON, reportError) {
+			continue
+		}
+
+		if class, ok := classifyMessage(message); ok {
+			queued := pool.submit(class, func() {
+				handleMessage(message, localPort, subdomain, sess, wsRelay, pipeline)
+			})
+			if !queued {
+				go rejectQueueFull(message, localPort, sess)
+			}
+			continue
+		}
+		go handleMessage(message, localPort, subdomain, sess, wsRelay, pipeline)
 	}
 }
 
 // handleMessage routes an incoming tunnel message by its type field.
-func handleMessage(raw []byte, localPort int, subdomain string, writeJSON func(any) error, wsRelay *proxy.WSRelay, pipeline *hooks.Pipeline) {
-	// Peek at the type field to route without fully unmarshaling into the wrong struct
-	var envelope struct {
-		Type string `json:"type"`
+// Responses go out through sess, whichever connection is current by then.
+func handleMessage(raw []byte, localPort int, subdomain string, sess *session, wsRelay *proxy.WSRelay, pipeline *hooks.TunnelPipeline) {
+	defer func() {
+		if r := recover(); r != nil {
+			onPanic(localPort, r)
+		}
+	}()
+
+	writeJSON := sess.writeJSON
+	reportError := func(e types.TunnelError) {
+		reportProtocolError(e, subdomain, writeJSON, pipeline)
 	}
+
+	// Peek at the type field to route without fully unmarshaling into the wrong struct
+	var envelope messageEnvelope
 	if err := json.Unmarshal(raw, &envelope); err != nil {
-		log.Printf("Error unmarshaling message: %v", err)
+		reportError(types.TunnelError{Code: types.ErrCodeMalformed, Message: err.Error()})
 		return
 	}
+	malformed := func(err error) {
+		reportError(types.TunnelError{ID: envelope.ID, MsgType: envelope.Type, Code: types.ErrCodeMalformed, Message: err.Error()})
+	}
 
 	switch envelope.Type {
 	case types.TypeHTTPRequest:
 		var req types.TunnelRequest
 		if err := json.Unmarshal(raw, &req); err != nil {
-			log.Printf("Error unmarshaling HTTP request: %v", err)
+			malformed(err)
 			return
 		}
-		pipeline.NotifyRequest(subdomain)
-		req = pipeline.RunBeforeProxy(req)
-		resp := proxy.HandleRequest(req, localPort)
-		resp = pipeline.RunAfterProxy(req, resp)
-		if err := writeJSON(resp); err != nil {
-			log.Printf("Error sending HTTP response: %v", err)
+		if err := req.Validate(); err != nil {
+			Logf(localPort, "Rejected malformed HTTP request: %v", err)
+			if req.ID != "" {
+				_ = writeJSON(types.TunnelResponse{
+					Type:   types.TypeHTTPResponse,
+					ID:     req.ID,
+					Status: http.StatusBadRequest,
+					Body:   base64.StdEncoding.EncodeToString([]byte("Malformed request")),
+				})
+			}
+			return
 		}
+		// Re-sent by the worker after a reconnect: answer it once
+		if dup, resp := sess.seen(req.ID); dup {
+			if resp != nil {
+				_ = writeJSON(*resp)
+			}
+			return
+		}
+		if !sess.requests.begin() {
+			_ = writeJSON(types.TunnelResponse{
+				Type:   types.TypeHTTPResponse,
+				ID:     req.ID,
+				Status: http.StatusServiceUnavailable,
+				Body:   base64.StdEncoding.EncodeToString([]byte("Tunnel is shutting down")),
+			})
+			return
+		}
+		defer sess.requests.end()
+		ctx, done := sess.cancels.track(req.ID)
+		defer done()
+		if ctx.Err() != nil {
+			// Cancelled while queued behind -max-concurrent
+			Logf(localPort, "Dropped %s %s: cancelled before it started", req.Method, req.Path)
+			return
+		}
+		serveHTTP(ctx, req, localPort, subdomain, writeJSON, pipeline)
+
+	case types.TypeHTTPCancel:
+		var msg types.HTTPCancel
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			malformed(err)
+			return
+		}
+		sess.cancels.cancel(msg.ID)
 
 	case types.TypeWSOpen:
 		var msg types.WSOpen
 		if err := json.Unmarshal(raw, &msg); err != nil {
-			log.Printf("Error unmarshaling ws-open: %v", err)
+			malformed(err)
 			return
 		}
+		if err := msg.Validate(); err != nil {
+			Logf(localPort, "Rejected malformed ws-open: %v", err)
+			if msg.ID != "" {
+				_ = writeJSON(types.WSClose{Type: types.TypeWSClose, ID: msg.ID, Code: 1008, Reason: "Malformed request"})
+			}
+			return
+		}
+		req, admitted := admitStream(pipeline, msg.ID, msg.Path, msg.Headers)
+		if !admitted {
+			Logf(localPort, "Refused WebSocket %s: the access policy turned the visitor away", msg.Path)
+			_ = writeJSON(types.WSClose{Type: types.TypeWSClose, ID: msg.ID, Code: 1008, Reason: "Forbidden"})
+			return
+		}
+		msg.Headers = req.Headers
 		wsRelay.HandleOpen(msg)
 
 	case types.TypeWSFrame:
 		var msg types.WSFrame
 		if err := json.Unmarshal(raw, &msg); err != nil {
-			log.Printf("Error unmarshaling ws-frame: %v", err)
+			malformed(err)
 			return
 		}
 		wsRelay.HandleFrame(msg)
@@ -193,9 +436,168 @@ func handleMessage(raw []byte, localPort int, subdomain string, writeJSON func(a
 	case types.TypeWSClose:
 		var msg types.WSClose
 		if err := json.Unmarshal(raw, &msg); err != nil {
-			log.Printf("Error unmarshaling ws-close: %v", err)
+			malformed(err)
 			return
 		}
 		wsRelay.HandleClose(msg)
+
+	case types.TypeConfigUpdate:
+		var msg types.ConfigUpdate
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			malformed(err)
+			return
+		}
+		Logf(localPort, "Worker updated the config of %s: %s", subdomain, strings.Join(slices.Sorted(maps.Keys(msg.Config)), ", "))
+		pipeline.NotifyConfigUpdate(msg.Config)
+
+	default:
+		reportError(types.TunnelError{
+			ID:      envelope.ID,
+			MsgType: envelope.Type,
+			Code:    types.ErrCodeUnknownType,
+			Message: fmt.Sprintf("unsupported message type %q", envelope.Type),
+		})
+	}
+}
+
+// onPanic is called with what a handler of a worker message panicked with,
+// so a malformed message can't take down the whole CLI. Tests replace it to
+// catch the panic.
+var onPanic = func(port int, r any) {
+	Logf(port, "Recovered from panic handling tunnel message: %v\n%s", r, debug.Stack())
+}
+
+// admitStream runs the tunnel's gates on a WebSocket or TCP stream about to
+// be opened, which skip the request hooks, and returns the upgrade request
+// the local side should see.
+func admitStream(pipeline *hooks.TunnelPipeline, id, path string, headers map[string][]string) (types.TunnelRequest, bool) {
+	req := types.TunnelRequest{Type: types.TypeHTTPRequest, ID: id, Method: http.MethodGet, Path: path, Headers: headers}
+	req, _, admitted := pipeline.Admit(pipeline.NewRequest(time.Now().Add(30*time.Second)), req)
+	return req, admitted
+}
+
+// rejectQueueFull answers an http-request turned away by -max-queued.
+func rejectQueueFull(raw []byte, localPort int, sess *session) {
+	env := peekEnvelope(raw)
+	Logf(localPort, "Rejected request %s: %d requests already queued (-max-queued)", env.ID, maxQueued)
+	_ = sess.writeJSON(types.TunnelResponse{
+		Type:    types.TypeHTTPResponse,
+		ID:      env.ID,
+		Status:  http.StatusServiceUnavailable,
+		Headers: map[string][]string{"Retry-After": {"1"}, "Content-Type": {"text/plain; charset=utf-8"}},
+		Body:    base64.StdEncoding.EncodeToString([]byte("Too many requests queued on this tunnel; try again shortly")),
+	})
+}
+
+// serveHTTP answers one HTTP request through the pipeline: reserved paths,
+// request hooks, then the local server. Responses go out through writeJSON
+// as tunnel messages. Once reqCtx is done the local request is aborted and
+// nothing more is sent.
+func serveHTTP(reqCtx context.Context, req types.TunnelRequest, localPort int, subdomain string, writeJSON func(any) error, pipeline *hooks.TunnelPipeline) {
+	send := writeJSON
+	writeJSON = func(v any) error {
+		if reqCtx.Err() != nil {
+			return nil // the worker drops it anyway
+		}
+		return send(v)
+	}
+	// Matches the worker's 30s wait for a response
+	ctx := pipeline.NewRequest(time.Now().Add(30 * time.Second))
+	if resp, ok := pipeline.ServeReserved(ctx, req); ok {
+		if err := writeJSON(resp); err != nil {
+			Logf(localPort, "Error sending HTTP response: %v", err)
+		}
+		return
+	}
+	pipeline.NotifyRequest()
+	start := time.Now()
+	req, shortResp, answered := pipeline.RunBeforeProxy(ctx, req)
+	status := 0
+	after := func(resp types.TunnelResponse, streamed bool) types.TunnelResponse {
+		ctx.Streamed = streamed
+		resp = pipeline.RunAfterProxy(ctx, req, resp)
+		status = resp.Status
+		return resp
+	}
+	if answered {
+		// A hook answered; the local server never sees the request
+		if err := writeJSON(after(shortResp, false)); err != nil {
+			Logf(localPort, "Error sending HTTP response: %v", err)
+		}
+	} else if targetDown(proxy.RoutePort(localPort, req.Path)) {
+		// -healthcheck says the local server is down; explain that
+		// instead of a bare 502
+		resp := localDownResponse(localPort)
+		resp.ID = req.ID
+		if err := writeJSON(after(resp, false)); err != nil {
+			Logf(localPort, "Error sending HTTP response: %v", err)
+		}
+	} else if err := proxy.HandleRequestStream(reqCtx, req, localPort, bodyFilters(ctx, req, pipeline), after, writeJSON); err != nil {
+		Logf(localPort, "Error sending HTTP response: %v", err)
+	}
+	if reqCtx.Err() != nil {
+		Logf(localPort, "Cancelled %s %s: no one is waiting for the response", req.Method, req.Path)
+		status = statusClientClosed
+	}
+	pipeline.Events().Publish(hooks.RequestCompleted{
+		Subdomain: subdomain,
+		Method:    req.Method,
+		Path:      req.Path,
+		Status:    status,
+		Latency:   time.Since(start),
+		Streamed:  ctx.Streamed,
+	})
+}
+
+// bodyFilters passes bodies through the pipeline's body transformers.
+func bodyFilters(ctx *hooks.RequestContext, req types.TunnelRequest, pipeline *hooks.TunnelPipeline) proxy.BodyFilters {
+	return proxy.BodyFilters{
+		Request: func(body io.Reader) io.Reader {
+			return pipeline.RequestBody(ctx, req, body)
+		},
+		Response: func(status int, header http.Header, w io.Writer) io.WriteCloser {
+			return pipeline.ResponseBody(ctx, req, status, header, w)
+		},
+	}
+}
+
+// workerDecodes reports whether the worker listed encoding in the tunnel
+// upgrade response.
+func workerDecodes(header http.Header, encoding string) bool {
+	for _, e := range strings.Split(header.Get(types.EncodingsHeader), ",") {
+		if strings.TrimSpace(e) == encoding {
+			return true
+		}
+	}
+	return false
+}
+
+// messageEnvelope holds the fields common to all tunnel messages.
+type messageEnvelope struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// peekEnvelope reads the type and ID of a message, leaving them empty if it
+// can't be decoded.
+func peekEnvelope(raw []byte) messageEnvelope {
+	var env messageEnvelope
+	_ = json.Unmarshal(raw, &env)
+	return env
+}
+
+// reportProtocolError sends e to the worker, logs it and publishes it as a
+// hooks.ProtocolError event.
+func reportProtocolError(e types.TunnelError, subdomain string, writeJSON func(any) error, pipeline *hooks.TunnelPipeline) {
+	e.Type = types.TypeError
+	Logf(pipeline.Tunnel().Port, "Protocol error on %s (%s %s): %s", subdomain, e.Code, e.MsgType, e.Message)
+	if err := writeJSON(e); err != nil {
+		Logf(pipeline.Tunnel().Port, "Error sending protocol error: %v", err)
 	}
+	pipeline.Events().Publish(hooks.ProtocolError{
+		Subdomain: subdomain,
+		Code:      e.Code,
+		MsgType:   e.MsgType,
+		Err:       errors.New(e.Message),
+	})
 }
@@ -0,0 +1,159 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/proxy"
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+// worker collects what the CLI sends over a tunnel connection.
+type worker struct {
+	mu   sync.Mutex
+	sent []any
+}
+
+func (w *worker) write(v any) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.sent = append(w.sent, v)
+	return nil
+}
+
+// answered reports whether the worker got a response, buffered or streamed,
+// for request id.
+func (w *worker) answered(id string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, v := range w.sent {
+		switch m := v.(type) {
+		case types.TunnelResponse:
+			if m.ID == id {
+				return true
+			}
+		case types.TunnelResponseStart:
+			if m.ID == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// echoServer is a local server echoing request bodies, as the target of
+// the returned port.
+func echoServer(t testing.TB) int {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	}))
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL)
+	port := srv.Listener.Addr().(*net.TCPAddr).Port
+	proxy.SetTarget(port, u)
+	return port
+}
+
+// catchPanics makes handlers report what they panicked with to *panicked
+// instead of logging it.
+func catchPanics(t testing.TB, panicked *any) {
+	prev := onPanic
+	onPanic = func(_ int, r any) { *panicked = r }
+	t.Cleanup(func() { onPanic = prev })
+}
+
+// FuzzHandleMessage feeds arbitrary worker messages through handleMessage
+// and the read loop's tcp-* dispatch. None may panic, and every valid
+// http-request must be answered.
+func FuzzHandleMessage(f *testing.F) {
+	for _, seed := range []string{
+		`{"type":"http-request","id":"r1","method":"GET","path":"/","headers":{}}`,
+		`{"type":"http-request","id":"r1","method":"POST","path":"/echo?x=1","headers":{"Content-Type":["text/plain"]},"body":"aGVsbG8="}`,
+		`{"type":"http-request","id":"r1","method":"POST","path":"/","body":"not base64!"}`,
+		`{"type":"http-request","id":"r1","method":"GET","path":"/_prodbd/stats"}`,
+		`{"type":"http-request","id":"","method":"G ET","path":"x"}`,
+		`{"type":"http-cancel","id":"r1","reason":"disconnect"}`,
+		`{"type":"ws-open","id":"s1","path":"/ws","headers":{"Origin":["https://a.example"]}}`,
+		`{"type":"ws-frame","id":"s1","isText":false,"payload":"%%%"}`,
+		`{"type":"ws-close","id":"s1","code":1000}`,
+		`{"type":"tcp-open","id":"s1"}`,
+		`{"type":"tcp-close","id":"s1","code":1000}`,
+		`{"type":"config-update","config":{"allowIps":["10.0.0.0/8"]}}`,
+		`{"type":"nope"}`,
+		`{"type":["http-request"]}`,
+		`not json`,
+	} {
+		f.Add([]byte(seed))
+	}
+	port := echoServer(f)
+	var pipeline hooks.Pipeline
+	pipeline.Activate()
+	tp := pipeline.ForTunnel("abc", port)
+	var panicked any
+	catchPanics(f, &panicked)
+
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		panicked = nil
+		w := &worker{}
+		sess := newSession()
+		sess.attach(&connWriter{write: w.write})
+		reportError := func(e types.TunnelError) { reportProtocolError(e, "abc", w.write, tp) }
+		tcpRelay := proxy.NewTCPRelay(port, w.write, func([]byte) error { return nil })
+
+		handleTCPData(raw, port, tcpRelay, reportError)
+		if !handleTCPMessage(raw, tcpRelay, tp, w.write, reportError) {
+			handleMessage(raw, port, "abc", sess, proxy.NewWSRelay(port, w.write), tp)
+		}
+		if panicked != nil {
+			t.Fatalf("panicked on %q: %v", raw, panicked)
+		}
+
+		var req types.TunnelRequest
+		if json.Unmarshal(raw, &req) == nil && req.Type == types.TypeHTTPRequest && req.Validate() == nil && !w.answered(req.ID) {
+			t.Fatalf("request %q not answered; sent %v", raw, w.sent)
+		}
+	})
+}
+
+func TestHandleMessageRecovers(t *testing.T) {
+	var panicked any
+	catchPanics(t, &panicked)
+	var pipeline hooks.Pipeline
+	pipeline.Activate()
+	// No relay to hand the stream to
+	handled := handleTCPMessage([]byte(`{"type":"tcp-close","id":"s1"}`), nil, pipeline.ForTunnel("abc", 3000), (&worker{}).write, func(types.TunnelError) {})
+	if panicked == nil || !handled {
+		t.Fatalf("handled %v, recovered %v", handled, panicked)
+	}
+}
+
+func TestDuplicateRequestAnsweredOnce(t *testing.T) {
+	port := echoServer(t)
+	var pipeline hooks.Pipeline
+	pipeline.Activate()
+	tp := pipeline.ForTunnel("abc", port)
+	w := &worker{}
+	sess := newSession()
+	sess.attach(&connWriter{write: w.write})
+
+	raw := []byte(`{"type":"http-request","id":"r1","method":"POST","path":"/","body":"aGk="}`)
+	handleMessage(raw, port, "abc", sess, nil, tp)
+	// Re-sent after a reconnect: the kept answer goes out again
+	handleMessage(raw, port, "abc", sess, nil, tp)
+	if len(w.sent) != 2 {
+		t.Fatalf("sent %v, want the response twice", w.sent)
+	}
+	first, ok1 := w.sent[0].(types.TunnelResponse)
+	second, ok2 := w.sent[1].(types.TunnelResponse)
+	if !ok1 || !ok2 || first.ID != "r1" || first.Body != "aGk=" || second.Body != first.Body {
+		t.Fatalf("sent %#v", w.sent)
+	}
+}
@@ -0,0 +1,63 @@
+package tunnel
+
+import (
+	"sync"
+	"time"
+)
+
+// inflight tracks the HTTP requests a connection is serving so shutdown can
+// let them finish before closing the websocket.
+type inflight struct {
+	mu       sync.Mutex
+	n        int
+	draining bool
+	idle     chan struct{} // closed once draining with nothing in flight
+}
+
+func newInflight() *inflight {
+	return &inflight{idle: make(chan struct{})}
+}
+
+// begin registers a new request. It returns false once draining has started,
+// in which case the request must be turned away and end not called.
+func (f *inflight) begin() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.draining {
+		return false
+	}
+	f.n++
+	return true
+}
+
+// end marks a request started with begin as finished.
+func (f *inflight) end() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.n--
+	if f.draining && f.n == 0 {
+		close(f.idle)
+	}
+}
+
+// drain stops new requests and waits up to timeout for in-flight ones.
+// Returns how many were still running when it gave up.
+func (f *inflight) drain(timeout time.Duration) int {
+	f.mu.Lock()
+	if !f.draining {
+		f.draining = true
+		if f.n == 0 {
+			close(f.idle)
+		}
+	}
+	f.mu.Unlock()
+
+	select {
+	case <-f.idle:
+		return 0
+	case <-time.After(timeout):
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.n
+}
@@ -0,0 +1,138 @@
+//go:build failpoints
+
+package tunnel
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/config"
+	"github.com/QuadTriangle/prod.bd/cli/internal/failpoint"
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+
+	"github.com/gorilla/websocket"
+)
+
+// workerConn is one tunnel connection the fake worker accepted.
+type workerConn struct {
+	*websocket.Conn
+	session string
+}
+
+// fakeWorker accepts tunnel connections and hands them to the test.
+func fakeWorker(t *testing.T) (string, <-chan workerConn) {
+	t.Helper()
+	conns := make(chan workerConn, 4)
+	var upgrader websocket.Upgrader
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		t.Cleanup(func() { c.Close() })
+		conns <- workerConn{c, r.URL.Query().Get("session")}
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, conns
+}
+
+// accept waits for the next tunnel connection.
+func accept(t *testing.T, conns <-chan workerConn) workerConn {
+	t.Helper()
+	select {
+	case c := <-conns:
+		return c
+	case <-time.After(5 * time.Second):
+		t.Fatal("client didn't connect")
+		return workerConn{}
+	}
+}
+
+// send sends the worker's request for / under id.
+func (c workerConn) send(t *testing.T, id string) {
+	t.Helper()
+	req := types.TunnelRequest{Type: types.TypeHTTPRequest, ID: id, Method: "GET", Path: "/", Headers: map[string][]string{}}
+	if err := c.WriteJSON(req); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// answers reads until the response to id, returning the IDs of the other
+// responses on the way.
+func (c workerConn) answers(t *testing.T, id string) []string {
+	t.Helper()
+	var others []string
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, msg, err := c.ReadMessage()
+		if err != nil {
+			t.Fatalf("no response to %s (got %v): %v", id, others, err)
+		}
+		env := peekEnvelope(msg)
+		if env.Type != types.TypeHTTPResponse {
+			continue
+		}
+		if env.ID == id {
+			return others
+		}
+		others = append(others, env.ID)
+	}
+}
+
+func TestResumesAfterDroppedAndCorruptFrames(t *testing.T) {
+	t.Setenv(config.TokenEnv, "test")
+	port := echoServer(t)
+	var panicked any
+	catchPanics(t, &panicked)
+	t.Cleanup(func() {
+		failpoint.Set(failpoint.DropFrames, 0)
+		failpoint.Set(failpoint.CorruptFrames, 0)
+	})
+
+	workerURL, conns := fakeWorker(t)
+	var p hooks.Pipeline
+	p.Activate()
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		StartTunnel("abc", port, workerURL, &p, Backoff{Initial: time.Millisecond, Max: time.Millisecond}, time.Second, done)
+	}()
+
+	first := accept(t, conns)
+	// r1 is lost on the way and r2 arrives garbled; r3 still gets through
+	if err := failpoint.Set(failpoint.DropFrames, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := failpoint.Set(failpoint.CorruptFrames, 1); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"r1", "r2", "r3"} {
+		first.send(t, id)
+	}
+	if others := first.answers(t, "r3"); len(others) > 0 {
+		t.Fatalf("answered %v, which never arrived intact", others)
+	}
+
+	// The worker gives up on the connection and re-sends r1 on the next
+	first.Close()
+	second := accept(t, conns)
+	if second.session == "" || second.session != first.session {
+		t.Fatalf("reconnected as session %q, want %q", second.session, first.session)
+	}
+	second.send(t, "r1")
+	second.answers(t, "r1")
+
+	close(done)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("tunnel didn't shut down")
+	}
+	if panicked != nil {
+		t.Fatalf("handler panicked: %v", panicked)
+	}
+}
@@ -0,0 +1,162 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/proxy"
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+// Health check settings; healthPath "" disables it. Set with SetHealthCheck.
+var (
+	healthPath     string
+	healthInterval time.Duration
+)
+
+// targetHealth holds the last probe of each monitored local port. Only ports
+// passed to CheckTargets are monitored.
+var (
+	targetHealthMu sync.Mutex
+	targetHealth   = map[int]hooks.TargetHealth{}
+)
+
+// SetHealthCheck makes tunnels GET path on their local server every
+// interval, and answer visitors with a 503 page while it is down. An empty
+// path disables health checks. Call before CheckTargets.
+func SetHealthCheck(path string, interval time.Duration) {
+	healthPath, healthInterval = path, interval
+}
+
+// CheckTargets probes the local server of each port once, logging the ones
+// that are down, and starts monitoring them. Call before Register with the
+// HTTP ports only.
+func CheckTargets(ports []int) {
+	if healthPath == "" {
+		return
+	}
+	for _, port := range ports {
+		h := probeTarget(port)
+		setTargetHealth(port, h)
+		if !h.Healthy {
+			Logf(port, "Local server on port %d is not up yet (%s); visitors get a 503 page until it is", port, describeHealth(h))
+		}
+	}
+}
+
+func probeTarget(port int) hooks.TargetHealth {
+	status, latency, err := proxy.Probe(port, healthPath)
+	return hooks.TargetHealth{
+		Path:    healthPath,
+		Healthy: err == nil && status < http.StatusInternalServerError,
+		Status:  status,
+		Latency: latency,
+		Err:     err,
+		Checked: time.Now(),
+	}
+}
+
+func describeHealth(h hooks.TargetHealth) string {
+	if h.Err != nil {
+		return h.Err.Error()
+	}
+	return fmt.Sprintf("GET %s -> %d", h.Path, h.Status)
+}
+
+// setTargetHealth records h for port and reports whether health changed.
+func setTargetHealth(port int, h hooks.TargetHealth) bool {
+	targetHealthMu.Lock()
+	defer targetHealthMu.Unlock()
+	prev, ok := targetHealth[port]
+	targetHealth[port] = h
+	return !ok || prev.Healthy != h.Healthy
+}
+
+// monitored returns the last probe of port, and false if port isn't monitored.
+func monitored(port int) (hooks.TargetHealth, bool) {
+	targetHealthMu.Lock()
+	defer targetHealthMu.Unlock()
+	h, ok := targetHealth[port]
+	return h, ok
+}
+
+// targetDown reports whether the last probe of a monitored port failed.
+func targetDown(port int) bool {
+	h, ok := monitored(port)
+	return ok && !h.Healthy
+}
+
+// monitorTarget probes localPort every healthInterval until done, telling
+// hooks about the current health first and then about every change. It
+// pauses in low-power mode and probes right away when that ends.
+func monitorTarget(subdomain string, localPort int, pipeline *hooks.TunnelPipeline, done <-chan struct{}) {
+	h, ok := monitored(localPort)
+	if !ok {
+		return
+	}
+	pipeline.NotifyTargetHealth(h)
+
+	ticker := time.NewTicker(healthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		case <-powerChanged():
+		}
+		if lowPower() {
+			continue
+		}
+		h := probeTarget(localPort)
+		if !setTargetHealth(localPort, h) {
+			continue
+		}
+		if h.Healthy {
+			Logf(localPort, "Local server on port %d is back up", localPort)
+		} else {
+			Logf(localPort, "Local server on port %d is down (%s)", localPort, describeHealth(h))
+		}
+		pipeline.NotifyTargetHealth(h)
+	}
+}
+
+var localDownPage = template.Must(template.New("down").Parse(`<!doctype html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width,initial-scale=1">
+<title>Local app not running · prod.bd</title>
+<style>body{font:15px/1.5 system-ui,sans-serif;max-width:520px;margin:80px auto;padding:0 16px;color:#222}
+h1{font-size:1.4rem}.logo{color:#16a34a;font-weight:700}code{background:#f1f5f9;padding:1px 4px;border-radius:3px}</style>
+</head><body>
+<p class="logo">prod.bd</p>
+<h1>Local app not running</h1>
+<p>The tunnel is up, but the app behind it on port <code>{{.Port}}</code> isn't answering <code>GET {{.Path}}</code>.</p>
+<p>If it's yours, start it and this page will go away within {{.Interval}}.</p>
+</body></html>`))
+
+// localDownResponse is the 503 page served instead of proxying while the
+// local server of localPort fails its health check.
+func localDownResponse(localPort int) types.TunnelResponse {
+	var buf bytes.Buffer
+	localDownPage.Execute(&buf, struct {
+		Port     int
+		Path     string
+		Interval time.Duration
+	}{localPort, healthPath, healthInterval})
+	return types.TunnelResponse{
+		Type:   types.TypeHTTPResponse,
+		Status: http.StatusServiceUnavailable,
+		Headers: map[string][]string{
+			"Content-Type":  {"text/html; charset=utf-8"},
+			"Cache-Control": {"no-store"},
+			"Retry-After":   {strconv.Itoa(max(int(healthInterval.Seconds()), 1))},
+		},
+		Body: base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}
+}
@@ -0,0 +1,98 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+)
+
+// Keepalive settings; pingInterval 0 turns keepalive off. Set with
+// SetKeepalive.
+var (
+	pingInterval   = 30 * time.Second
+	maxMissedPings = 3
+)
+
+// SetKeepalive sets how often each tunnel pings the worker and how many pings
+// in a row may go unanswered before the connection is presumed dead and
+// replaced. interval 0 sends no pings and sets no read deadline. Call before
+// starting tunnels.
+func SetKeepalive(interval time.Duration, maxMissed int) {
+	pingInterval, maxMissedPings = interval, max(maxMissed, 1)
+}
+
+// keepalive tracks whether the worker still answers on one connection. The
+// worker answers every "ping" with "pong", but any message from it counts.
+type keepalive struct {
+	missed atomic.Int32 // pings sent since the worker was last heard from
+	dead   atomic.Bool  // the connection was closed for missing pings
+}
+
+// heard records a message from the worker.
+func (k *keepalive) heard() { k.missed.Store(0) }
+
+// readDeadline is when a read should give up: a little after the point where
+// the ping loop would already have declared the connection dead, so it only
+// fires if that loop is stuck.
+func (k *keepalive) readDeadline() time.Time {
+	if pingInterval <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(keepaliveInterval() * time.Duration(maxMissedPings+2))
+}
+
+// run pings every keepaliveInterval until stop or done is closed. Once
+// maxMissedPings pings in a row go unanswered, it tells the pipeline and
+// closes the connection, so the read loop returns and the tunnel reconnects
+// instead of waiting for TCP to give up.
+func (k *keepalive) run(closeConn func() error, writeText func(string) error, localPort int, pipeline *hooks.TunnelPipeline, stop, done <-chan struct{}) {
+	if pingInterval <= 0 {
+		return
+	}
+	for {
+		timer := time.NewTimer(keepaliveInterval())
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-done:
+			timer.Stop()
+			return
+		case <-powerChanged():
+			// Start over at the new interval
+			timer.Stop()
+			continue
+		case <-timer.C:
+		}
+		if missed := int(k.missed.Load()); missed >= maxMissedPings {
+			Logf(localPort, "Worker hasn't answered %d keepalive pings, reconnecting", missed)
+			k.dead.Store(true)
+			pipeline.NotifyUnhealthy(missed)
+			closeConn()
+			return
+		}
+		k.missed.Add(1)
+		if err := writeText("ping"); err != nil {
+			Logf(localPort, "Keepalive ping failed: %v", err)
+			return
+		}
+	}
+}
+
+// readErr explains why the read loop stopped, preferring the keepalive's view
+// of a dead connection over the raw read error.
+func (k *keepalive) readErr(err error, pipeline *hooks.TunnelPipeline) error {
+	if k.dead.Load() {
+		return fmt.Errorf("connection presumed dead: %d keepalive pings unanswered", k.missed.Load())
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		pipeline.NotifyUnhealthy(int(k.missed.Load()))
+		return fmt.Errorf("nothing from the worker in %s: %w", keepaliveInterval()*time.Duration(maxMissedPings+2), err)
+	}
+	return err
+}
@@ -0,0 +1,423 @@
+package tunnel
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/config"
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/proxy"
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+// ltInfo is a localtunnel server's answer to a tunnel request: the TCP port
+// to open sockets to and how many it accepts.
+type ltInfo struct {
+	ID           string `json:"id"`
+	Port         int    `json:"port"`
+	MaxConnCount int    `json:"max_conn_count"`
+	URL          string `json:"url"`
+	IP           string `json:"ip"`
+	Message      string `json:"message"` // set instead of the rest on errors
+}
+
+// ltTunnels holds the current server assignment per subdomain key, refreshed
+// on every reconnect.
+var ltTunnels sync.Map // subdomain -> ltInfo
+
+// ltRequestID numbers requests read from localtunnel sockets, which carry
+// no IDs of their own.
+var ltRequestID atomic.Uint64
+
+// ltRequest asks the localtunnel server at host for a tunnel, named sub if
+// set, else a random name.
+func ltRequest(host, sub string) (ltInfo, error) {
+	endpoint := strings.TrimSuffix(host, "/") + "/?new"
+	if sub != "" {
+		endpoint = strings.TrimSuffix(host, "/") + "/" + url.PathEscape(sub)
+	}
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Get(endpoint)
+	if err != nil {
+		return ltInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	var info ltInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil && resp.StatusCode == http.StatusOK {
+		return ltInfo{}, fmt.Errorf("localtunnel server %s: %w", host, err)
+	}
+	if info.Message != "" {
+		return ltInfo{}, fmt.Errorf("localtunnel server %s: %s", host, info.Message)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ltInfo{}, fmt.Errorf("localtunnel server %s: %s", host, resp.Status)
+	}
+	if info.ID == "" || info.Port == 0 || info.URL == "" {
+		return ltInfo{}, fmt.Errorf("localtunnel server %s: incomplete tunnel info", host)
+	}
+	if info.MaxConnCount < 1 {
+		info.MaxConnCount = 1
+	}
+	return info, nil
+}
+
+// RegisterLocaltunnel requests a tunnel per port from the localtunnel server
+// at host, the counterpart of Register. The mapping it returns uses the
+// server's tunnel IDs as subdomains; their public URLs are recorded with
+// config.SetPublicURL.
+func RegisterLocaltunnel(host string, ports []int, subdomains map[int]string) (map[int]string, error) {
+	mapping := make(map[int]string, len(ports))
+	for _, port := range ports {
+		info, err := ltRequest(host, subdomains[port])
+		if err != nil {
+			return nil, err
+		}
+		ltTunnels.Store(info.ID, info)
+		config.SetPublicURL(info.ID, info.URL)
+		mapping[port] = info.ID
+	}
+	return mapping, nil
+}
+
+// StartLocaltunnel serves the tunnel registered as subdomain until done is
+// closed, like StartTunnel but over a localtunnel server's raw sockets. After
+// a drop it asks the server for the same name again.
+func StartLocaltunnel(subdomain string, localPort int, host string, p *hooks.Pipeline, backoff Backoff, drainTimeout time.Duration, done <-chan struct{}) {
+	pipeline := p.ForTunnel(subdomain, localPort)
+	watchPower(pipeline.Events())
+	go monitorTarget(subdomain, localPort, pipeline, done)
+
+	attempt := 0
+	for {
+		select {
+		case <-done:
+			Logf(localPort, "Tunnel %s shutting down", subdomain)
+			return
+		default:
+		}
+
+		var err error
+		connected := false
+		v, ok := ltTunnels.LoadAndDelete(subdomain)
+		info, _ := v.(ltInfo)
+		if !ok {
+			if info, err = ltRequest(host, subdomain); err == nil {
+				config.SetPublicURL(subdomain, info.URL)
+			}
+		}
+		if err == nil {
+			Logf(localPort, "Connecting to %s (port %d)...", info.URL, localPort)
+			connected, err = serveLocaltunnel(info, host, localPort, subdomain, pipeline, drainTimeout, done)
+			if err == nil {
+				continue
+			}
+		}
+		pipeline.NotifyDisconnect(err)
+
+		if connected {
+			attempt = 0
+		}
+		attempt++
+		delay := backoff.Delay(attempt)
+		pipeline.NotifyRetry(attempt, delay, err)
+		pipeline.Events().Publish(hooks.TunnelDegraded{Subdomain: subdomain, Attempt: attempt, Err: err})
+		Logf(localPort, "Tunnel %s disconnected: %v. Retrying in %s (attempt %d)...", subdomain, err, delay.Round(time.Millisecond), attempt)
+		select {
+		case <-done:
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// serveLocaltunnel keeps info.MaxConnCount sockets open to the server, each
+// carrying visitor HTTP requests one after another. It returns once every
+// socket has failed (the server dropped the tunnel) or done is closed, in
+// which case err is nil.
+func serveLocaltunnel(info ltInfo, host string, localPort int, subdomain string, pipeline *hooks.TunnelPipeline, drainTimeout time.Duration, done <-chan struct{}) (connected bool, err error) {
+	remote := info.IP
+	if remote == "" {
+		u, err := url.Parse(host)
+		if err != nil {
+			return false, err
+		}
+		remote = u.Hostname()
+	}
+	addr := net.JoinHostPort(remote, strconv.Itoa(info.Port))
+	counter := wireCounterFor(subdomain)
+	dial := func() (net.Conn, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return counter.dial(ctx, "tcp", addr)
+	}
+
+	first, err := dial()
+	if err != nil {
+		return false, err
+	}
+	Logf(localPort, "Tunnel established for port %d", localPort)
+	pipeline.NotifyConnect(warmup(localPort))
+
+	requests := newInflight()
+	errc := make(chan error, info.MaxConnCount)
+	var wg sync.WaitGroup
+
+	// open tracks live sockets so shutdown can close them; none are added
+	// once stopped
+	var mu sync.Mutex
+	open := make(map[net.Conn]bool)
+	stopped := false
+	track := func(c net.Conn) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if !stopped {
+			open[c] = true
+		}
+		return !stopped
+	}
+	untrack := func(c net.Conn) bool {
+		c.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		delete(open, c)
+		return !stopped
+	}
+	closeAll := func() {
+		mu.Lock()
+		stopped = true
+		for c := range open {
+			c.Close()
+		}
+		mu.Unlock()
+		wg.Wait()
+	}
+
+	// Each socket serves one visitor connection; the server hands it out
+	// and expects a fresh one in its place once it closes
+	sockets := make([]net.Conn, info.MaxConnCount)
+	sockets[0] = first
+	for _, c := range sockets {
+		wg.Add(1)
+		go func(c net.Conn) {
+			defer wg.Done()
+			for {
+				if c == nil {
+					var err error
+					if c, err = dial(); err != nil {
+						errc <- err
+						return
+					}
+				}
+				if !track(c) {
+					c.Close()
+					return
+				}
+				serveLTConn(c, localPort, subdomain, requests, pipeline)
+				if !untrack(c) {
+					return
+				}
+				c = nil
+			}
+		}(c)
+	}
+
+	failed := 0
+	for {
+		select {
+		case <-done:
+			if n := requests.drain(drainTimeout); n > 0 {
+				Logf(localPort, "Tunnel %s: drain timeout, abandoning %d in-flight request(s)", subdomain, n)
+			}
+			closeAll()
+			return true, nil
+		case err := <-errc:
+			// The server refuses sockets once it has dropped the tunnel
+			if failed++; failed == info.MaxConnCount {
+				closeAll()
+				return true, err
+			}
+		}
+	}
+}
+
+// serveLTConn reads HTTP/1.1 requests off a localtunnel socket and answers
+// each through serveHTTP until the visitor or the server closes it.
+func serveLTConn(c net.Conn, localPort int, subdomain string, requests *inflight, pipeline *hooks.TunnelPipeline) {
+	br := bufio.NewReader(c)
+	for {
+		httpReq, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		powerActive()
+		if isUpgrade(httpReq) {
+			relayUpgrade(c, br, httpReq, localPort)
+			return
+		}
+		body, err := io.ReadAll(httpReq.Body)
+		httpReq.Body.Close()
+		if err != nil {
+			return
+		}
+
+		req := types.TunnelRequest{
+			Type:    types.TypeHTTPRequest,
+			ID:      "lt-" + strconv.FormatUint(ltRequestID.Add(1), 10),
+			Method:  httpReq.Method,
+			Path:    httpReq.RequestURI,
+			Headers: httpReq.Header,
+		}
+		req.Headers["Host"] = []string{httpReq.Host}
+		if len(body) > 0 {
+			req.Body = base64.StdEncoding.EncodeToString(body)
+		}
+
+		w := &ltResponseWriter{w: bufio.NewWriter(c), head: httpReq.Method == http.MethodHead}
+		switch {
+		case req.Validate() != nil:
+			w.writeJSON(types.TunnelResponse{Status: http.StatusBadRequest, Body: base64.StdEncoding.EncodeToString([]byte("Malformed request"))})
+		case !requests.begin():
+			w.writeJSON(types.TunnelResponse{Status: http.StatusServiceUnavailable, Body: base64.StdEncoding.EncodeToString([]byte("Tunnel is shutting down"))})
+			return
+		default:
+			serveHTTP(context.Background(), req, localPort, subdomain, w.writeJSON, pipeline)
+			requests.end()
+		}
+		if w.err != nil || httpReq.Close {
+			return
+		}
+	}
+}
+
+// isUpgrade reports whether r asks to switch protocols (WebSockets).
+func isUpgrade(r *http.Request) bool {
+	for _, v := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// relayUpgrade hands a protocol upgrade straight to the local server and
+// pipes bytes both ways until either side closes. Request hooks don't see
+// upgraded connections, as with WebSockets over the prod.bd worker.
+func relayUpgrade(c net.Conn, br *bufio.Reader, r *http.Request, localPort int) {
+	local, err := proxy.DialLocal(proxy.RoutePort(localPort, r.RequestURI))
+	if err != nil {
+		Logf(localPort, "Upgrade %s: %v", r.URL.Path, err)
+		io.WriteString(c, "HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+		return
+	}
+	defer local.Close()
+	if err := r.Write(local); err != nil {
+		return
+	}
+	go func() {
+		io.Copy(c, local)
+		c.Close()
+	}()
+	io.Copy(local, br)
+}
+
+// ltResponseWriter turns the tunnel messages serveHTTP writes back into an
+// HTTP/1.1 response on a localtunnel socket.
+type ltResponseWriter struct {
+	w       *bufio.Writer
+	head    bool // HEAD response: headers only
+	chunked io.WriteCloser
+	err     error // first write error; the socket is dropped after it
+}
+
+func (lw *ltResponseWriter) writeJSON(v any) error {
+	if lw.err != nil {
+		return lw.err
+	}
+	switch m := v.(type) {
+	case types.TunnelResponse:
+		body, err := base64.StdEncoding.DecodeString(m.Body)
+		if err != nil {
+			return err
+		}
+		resp := &http.Response{
+			StatusCode:    m.Status,
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header(m.Headers),
+			ContentLength: int64(len(body)),
+			Body:          io.NopCloser(bytes.NewReader(body)),
+		}
+		if lw.head {
+			resp.Body = nil
+			if n, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64); err == nil {
+				resp.ContentLength = n
+			}
+			resp.Request = &http.Request{Method: http.MethodHead}
+		}
+		lw.err = resp.Write(lw.w)
+	case types.TunnelResponseStart:
+		header := http.Header(m.Headers).Clone()
+		if header == nil {
+			header = http.Header{}
+		}
+		if header.Get("Content-Length") == "" && !lw.head {
+			header.Del("Transfer-Encoding")
+			header.Set("Transfer-Encoding", "chunked")
+			lw.chunked = httputil.NewChunkedWriter(lw.w)
+		}
+		fmt.Fprintf(lw.w, "HTTP/1.1 %d %s\r\n", m.Status, http.StatusText(m.Status))
+		if lw.err = header.Write(lw.w); lw.err == nil {
+			_, lw.err = io.WriteString(lw.w, "\r\n")
+		}
+	case types.TunnelResponseChunk:
+		body, err := base64.StdEncoding.DecodeString(m.Body)
+		if err != nil {
+			return err
+		}
+		if lw.head {
+			return nil
+		}
+		if lw.chunked != nil {
+			_, lw.err = lw.chunked.Write(body)
+		} else {
+			_, lw.err = lw.w.Write(body)
+		}
+	case types.TunnelResponseEnd:
+		if m.Error != "" {
+			// Cut the response short so the visitor sees it failed
+			lw.err = errors.New(m.Error)
+			return lw.err
+		}
+		if lw.chunked != nil {
+			if lw.err = lw.chunked.Close(); lw.err == nil {
+				if lw.err = http.Header(m.Trailers).Write(lw.w); lw.err == nil {
+					_, lw.err = io.WriteString(lw.w, "\r\n")
+				}
+			}
+		}
+	default:
+		return nil
+	}
+	if lw.err == nil {
+		lw.err = lw.w.Flush()
+	}
+	return lw.err
+}
@@ -0,0 +1,56 @@
+package tunnel
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// LogSeparator ends the tunnel name Logf puts in front of a message.
+const LogSeparator = " | "
+
+// Log naming and filtering, keyed by local port. Set with SetLogName and
+// SetLogFilter.
+var (
+	logNames  sync.Map // port -> name
+	logFilter map[int]bool
+)
+
+// SetLogName sets the name (label or subdomain) prefixed to the log lines of
+// the tunnel on port, so interleaved output of several tunnels stays readable.
+func SetLogName(port int, name string) {
+	logNames.Store(port, name)
+}
+
+// SetLogFilter limits tunnel log lines to the tunnels on ports; lines of
+// other tunnels are dropped. Lines not about a tunnel are always logged.
+// Call before the tunnels start.
+func SetLogFilter(ports []int) {
+	logFilter = make(map[int]bool, len(ports))
+	for _, port := range ports {
+		logFilter[port] = true
+	}
+}
+
+// Logf logs a message about the tunnel on port, prefixed with its name.
+func Logf(port int, format string, args ...any) {
+	if logFilter != nil && !logFilter[port] {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	if name, ok := logNames.Load(port); ok {
+		msg = name.(string) + LogSeparator + msg
+	}
+	log.Print(msg)
+}
+
+// SplitLogLine splits a message written by Logf into the tunnel name and
+// the rest. ok is false for other messages.
+func SplitLogLine(msg string) (name, rest string, ok bool) {
+	name, rest, ok = strings.Cut(msg, LogSeparator)
+	if !ok || name == "" || strings.ContainsAny(name, " []") {
+		return "", msg, false
+	}
+	return name, rest, true
+}
@@ -0,0 +1,154 @@
+package tunnel
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+)
+
+// Low-power modes for SetLowPower.
+const (
+	LowPowerOff     = "off"
+	LowPowerBattery = "battery" // only while running on battery
+	LowPowerAlways  = "always"  // whenever idle, plugged in or not
+)
+
+// Low-power settings; lowPowerMode off turns it off. Set with SetLowPower.
+var (
+	lowPowerMode = LowPowerOff
+	lowPowerIdle = 5 * time.Minute
+	lowPowerPing = 90 * time.Second
+)
+
+// powerPoll is how often the battery and idle time are checked.
+const powerPoll = 30 * time.Second
+
+// SetLowPower lets idle tunnels go easy on a laptop's battery and radios.
+// Once no message has come from the worker for idle (and, in battery mode,
+// the machine is on battery), keepalive pings go out every ping instead of
+// -ping-interval, local health checks pause, and plugins get a
+// hooks.PowerModeChanged so they can pause their own work. The first
+// request ends it. ping should stay under the 100s after which Cloudflare
+// may close a silent WebSocket. Call before starting tunnels.
+func SetLowPower(mode string, idle, ping time.Duration) {
+	lowPowerMode, lowPowerIdle, lowPowerPing = mode, idle, ping
+}
+
+var power struct {
+	once       sync.Once
+	bus        *hooks.Bus
+	low        atomic.Bool
+	lastActive atomic.Int64 // unix nanoseconds
+
+	mu      sync.Mutex
+	changed chan struct{} // closed and replaced on every switch
+}
+
+// watchPower starts the low-power check, once per process; mode changes
+// are published on bus.
+func watchPower(bus *hooks.Bus) {
+	if lowPowerMode == LowPowerOff {
+		return
+	}
+	power.once.Do(func() {
+		power.bus = bus
+		power.changed = make(chan struct{})
+		power.lastActive.Store(time.Now().UnixNano())
+		go func() {
+			ticker := time.NewTicker(powerPoll)
+			defer ticker.Stop()
+			for range ticker.C {
+				idle := time.Since(time.Unix(0, power.lastActive.Load())) >= lowPowerIdle
+				setLowPower(idle && (lowPowerMode == LowPowerAlways || onBattery()))
+			}
+		}()
+	})
+}
+
+// powerActive records traffic on a tunnel, leaving low-power mode at once.
+func powerActive() {
+	if lowPowerMode == LowPowerOff {
+		return
+	}
+	power.lastActive.Store(time.Now().UnixNano())
+	if power.low.Load() {
+		setLowPower(false)
+	}
+}
+
+func setLowPower(low bool) {
+	power.mu.Lock()
+	defer power.mu.Unlock()
+	if power.low.Load() == low {
+		return
+	}
+	power.low.Store(low)
+	close(power.changed)
+	power.changed = make(chan struct{})
+
+	if low {
+		log.Printf("Idle, switching to low-power mode (keepalive every %s)", keepaliveInterval())
+	} else {
+		log.Print("Leaving low-power mode")
+	}
+	power.bus.Publish(hooks.PowerModeChanged{LowPower: low})
+}
+
+// lowPower reports whether tunnels are in low-power mode.
+func lowPower() bool { return power.low.Load() }
+
+// keepaliveInterval is how often to ping the worker, longer in low-power
+// mode.
+func keepaliveInterval() time.Duration {
+	if pingInterval > 0 && lowPower() {
+		return max(lowPowerPing, pingInterval)
+	}
+	return pingInterval
+}
+
+// powerChanged returns a channel closed at the next switch in or out of
+// low-power mode, or nil if it is off.
+func powerChanged() <-chan struct{} {
+	if lowPowerMode == LowPowerOff {
+		return nil
+	}
+	power.mu.Lock()
+	defer power.mu.Unlock()
+	return power.changed
+}
+
+// onBattery reports whether the machine runs on battery. Where that can't
+// be told (no battery, or an unsupported OS) it reports false.
+func onBattery() bool {
+	switch runtime.GOOS {
+	case "linux":
+		// Discharging if no mains supply is online, and there is a battery
+		supplies, _ := filepath.Glob("/sys/class/power_supply/*")
+		battery := false
+		for _, dir := range supplies {
+			typ, _ := os.ReadFile(filepath.Join(dir, "type"))
+			switch strings.TrimSpace(string(typ)) {
+			case "Mains", "USB":
+				if online, _ := os.ReadFile(filepath.Join(dir, "online")); strings.TrimSpace(string(online)) == "1" {
+					return false
+				}
+			case "Battery":
+				battery = true
+			}
+		}
+		return battery
+	case "darwin":
+		out, err := exec.Command("pmset", "-g", "batt").Output()
+		return err == nil && bytes.Contains(out, []byte("'Battery Power'"))
+	}
+	return false
+}
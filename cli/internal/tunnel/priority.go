@@ -0,0 +1,188 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+// Class is a request's scheduling priority when the tunnel is at its
+// concurrency limit. Lower classes are dequeued first.
+type Class int
+
+const (
+	Interactive Class = iota // page loads and API calls
+	Asset                    // static files
+	Webhook                  // machine deliveries that can wait
+	numClasses
+)
+
+func (c Class) String() string {
+	switch c {
+	case Interactive:
+		return "interactive"
+	case Asset:
+		return "asset"
+	case Webhook:
+		return "webhook"
+	}
+	return "unknown"
+}
+
+// Classifier assigns classes by path. Patterns use path.Match syntax; one
+// ending in "/*" also matches everything below that directory.
+type Classifier struct {
+	Webhooks []string
+	Assets   []string
+}
+
+// DefaultClassifier covers common webhook endpoints and asset directories.
+// GETs for files with static extensions are always assets.
+var DefaultClassifier = Classifier{
+	Webhooks: []string{"/webhook", "/webhooks/*", "/hooks/*", "/api/webhooks/*"},
+	Assets:   []string{"/static/*", "/assets/*", "/_next/static/*", "/public/*"},
+}
+
+var assetExts = map[string]bool{
+	".js": true, ".mjs": true, ".css": true, ".map": true,
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".svg": true, ".webp": true, ".ico": true, ".avif": true,
+	".woff": true, ".woff2": true, ".ttf": true, ".otf": true,
+	".mp4": true, ".webm": true, ".mp3": true, ".wasm": true,
+}
+
+// Classify returns the class of a request.
+func (c Classifier) Classify(method, reqPath string) Class {
+	reqPath, _, _ = strings.Cut(reqPath, "?")
+	if matchPaths(c.Webhooks, reqPath) {
+		return Webhook
+	}
+	if method == http.MethodGet || method == http.MethodHead {
+		if assetExts[strings.ToLower(path.Ext(reqPath))] || matchPaths(c.Assets, reqPath) {
+			return Asset
+		}
+	}
+	return Interactive
+}
+
+func matchPaths(patterns []string, p string) bool {
+	for _, pat := range patterns {
+		if ok, _ := path.Match(pat, p); ok {
+			return true
+		}
+		if dir, ok := strings.CutSuffix(pat, "*"); ok && strings.HasSuffix(dir, "/") && strings.HasPrefix(p, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// Scheduling settings; maxConcurrent and maxQueued 0 mean no limit. Set
+// with SetScheduling.
+var (
+	maxConcurrent int
+	maxQueued     int
+	classifier    = DefaultClassifier
+)
+
+// SetScheduling limits each tunnel to limit concurrent HTTP requests (0 for
+// no limit). Requests beyond it queue and are started in class order, so
+// page loads aren't stuck behind a burst of webhook deliveries; once queue
+// requests wait (0 for no limit), more are turned away with a 503. Call
+// before starting tunnels.
+func SetScheduling(limit, queue int, c Classifier) {
+	maxConcurrent, maxQueued, classifier = limit, queue, c
+}
+
+// classifyMessage returns the class of an http-request message; ok is false
+// for every other message type.
+func classifyMessage(raw []byte) (c Class, ok bool) {
+	var msg struct {
+		Type   string `json:"type"`
+		Method string `json:"method"`
+		Path   string `json:"path"`
+	}
+	if json.Unmarshal(raw, &msg) != nil || msg.Type != types.TypeHTTPRequest {
+		return 0, false
+	}
+	return classifier.Classify(msg.Method, msg.Path), true
+}
+
+// workerPool runs at most limit jobs at once, queueing up to maxQueued of
+// the rest by class. Within a class jobs run in arrival order.
+type workerPool struct {
+	limit     int
+	maxQueued int
+	// changed, if set, is told the queue depth whenever it changes or a
+	// job is turned away. It is called with mu held, so must be quick.
+	changed func(queued int, rejected bool)
+
+	mu      sync.Mutex
+	running int
+	queued  int
+	queues  [numClasses][]func()
+}
+
+func newWorkerPool(limit, maxQueued int, changed func(queued int, rejected bool)) *workerPool {
+	return &workerPool{limit: limit, maxQueued: maxQueued, changed: changed}
+}
+
+// submit runs job now if under the limit, otherwise queues it. It returns
+// false, dropping job, if the queue is full.
+func (p *workerPool) submit(c Class, job func()) bool {
+	if p.limit <= 0 {
+		go job()
+		return true
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.running < p.limit {
+		p.running++
+		go p.work(job)
+		return true
+	}
+	if p.maxQueued > 0 && p.queued >= p.maxQueued {
+		p.notify(true)
+		return false
+	}
+	p.queues[c] = append(p.queues[c], job)
+	p.queued++
+	p.notify(false)
+	return true
+}
+
+func (p *workerPool) notify(rejected bool) {
+	if p.changed != nil {
+		p.changed(p.queued, rejected)
+	}
+}
+
+// work runs job, then keeps taking queued jobs until none are left.
+func (p *workerPool) work(job func()) {
+	for job != nil {
+		job()
+		job = p.next()
+	}
+}
+
+// next pops the highest-priority queued job, or releases the worker's slot
+// and returns nil.
+func (p *workerPool) next() func() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for c := range p.queues {
+		if q := p.queues[c]; len(q) > 0 {
+			job := q[0]
+			q[0] = nil
+			p.queues[c] = q[1:]
+			p.queued--
+			p.notify(false)
+			return job
+		}
+	}
+	p.running--
+	return nil
+}
@@ -0,0 +1,113 @@
+package tunnel
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		method, path string
+		want         Class
+	}{
+		{"GET", "/", Interactive},
+		{"POST", "/api/users", Interactive},
+		{"GET", "/app.js?v=3", Asset},
+		{"HEAD", "/logo.PNG", Asset},
+		{"GET", "/static/app", Asset},
+		{"POST", "/static/upload", Interactive},
+		{"POST", "/webhook", Webhook},
+		{"POST", "/webhooks/stripe", Webhook},
+		{"GET", "/hooks/github/ping.js", Webhook},
+		{"POST", "/webhookx", Interactive},
+	}
+	for _, tt := range tests {
+		if got := DefaultClassifier.Classify(tt.method, tt.path); got != tt.want {
+			t.Errorf("%s %s: %s, want %s", tt.method, tt.path, got, tt.want)
+		}
+	}
+	if _, ok := classifyMessage([]byte(`{"type":"ws-open","path":"/webhook"}`)); ok {
+		t.Error("ws-open classified as an HTTP request")
+	}
+}
+
+func TestWorkerPoolRunsQueuedByClass(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		order   []string
+		depths  []int
+		release = make(chan struct{})
+		done    sync.WaitGroup
+	)
+	p := newWorkerPool(1, 0, func(queued int, _ bool) { depths = append(depths, queued) })
+	job := func(name string) func() {
+		done.Add(1)
+		return func() {
+			defer done.Done()
+			if name == "first" {
+				<-release
+			}
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+		}
+	}
+
+	p.submit(Interactive, job("first"))
+	p.submit(Webhook, job("webhook 1"))
+	p.submit(Asset, job("asset"))
+	p.submit(Webhook, job("webhook 2"))
+	p.submit(Interactive, job("page"))
+	close(release)
+	done.Wait()
+
+	want := []string{"first", "page", "asset", "webhook 1", "webhook 2"}
+	if !slices.Equal(order, want) {
+		t.Fatalf("ran %v, want %v", order, want)
+	}
+	if !slices.Equal(depths, []int{1, 2, 3, 4, 3, 2, 1, 0}) {
+		t.Fatalf("queue depths %v", depths)
+	}
+}
+
+func TestWorkerPoolTurnsAwayPastMaxQueued(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	rejected := 0
+	p := newWorkerPool(1, 2, func(_ int, r bool) {
+		if r {
+			rejected++
+		}
+	})
+	wait := func() { <-release }
+	for i, want := range []bool{true, true, true, false} {
+		if got := p.submit(Interactive, wait); got != want {
+			t.Fatalf("submit %d: %v, want %v", i, got, want)
+		}
+	}
+	if rejected != 1 {
+		t.Fatalf("told of %d rejections", rejected)
+	}
+}
+
+func TestWorkerPoolUnlimited(t *testing.T) {
+	p := newWorkerPool(0, 1, nil)
+	release := make(chan struct{})
+	started := make(chan struct{}, 3)
+	for range 3 {
+		p.submit(Webhook, func() {
+			started <- struct{}{}
+			<-release
+		})
+	}
+	for range 3 {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("jobs queued without a limit")
+		}
+	}
+	close(release)
+}
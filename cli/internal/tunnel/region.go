@@ -0,0 +1,72 @@
+package tunnel
+
+import (
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// rttProbes is how many requests MeasureRTT sends over one connection.
+const rttProbes = 3
+
+// Region is a worker deployment that tunnels can register with (-region).
+type Region struct {
+	Name      string
+	WorkerURL string
+	RTT       time.Duration // 0 if Err is set
+	Err       error
+}
+
+// MeasureRTT returns the round-trip time to the worker: the fastest of a few
+// GETs over one keep-alive connection, so DNS and TLS setup don't count.
+func MeasureRTT(workerBaseURL string) (time.Duration, error) {
+	transport := workerTransport()
+	transport.MaxIdleConnsPerHost = 1
+	client := &http.Client{Timeout: 5 * time.Second, Transport: transport}
+	defer client.CloseIdleConnections()
+
+	var best time.Duration
+	for i := range rttProbes {
+		start := time.Now()
+		resp, err := client.Get(workerBaseURL + "/")
+		if err != nil {
+			return 0, err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if rtt := time.Since(start); i == 0 || rtt < best {
+			best = rtt
+		}
+	}
+	return best, nil
+}
+
+// RankRegions measures every region concurrently and returns them fastest
+// first, with unreachable ones last.
+func RankRegions(regions map[string]string) []Region {
+	out := make([]Region, 0, len(regions))
+	for name, url := range regions {
+		out = append(out, Region{Name: name, WorkerURL: url})
+	}
+	var wg sync.WaitGroup
+	for i := range out {
+		wg.Add(1)
+		go func(r *Region) {
+			defer wg.Done()
+			r.RTT, r.Err = MeasureRTT(r.WorkerURL)
+		}(&out[i])
+	}
+	wg.Wait()
+	sort.Slice(out, func(i, j int) bool {
+		if (out[i].Err == nil) != (out[j].Err == nil) {
+			return out[i].Err == nil
+		}
+		if out[i].RTT != out[j].RTT {
+			return out[i].RTT < out[j].RTT
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
@@ -0,0 +1,103 @@
+package tunnel
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// networkPoll is how often the network is checked for changes; 0 turns the
+// check off. Set with SetNetworkWatch.
+var networkPoll = 2 * time.Second
+
+// SetNetworkWatch sets how often to check whether the machine moved to
+// another network (Wi-Fi to hotspot, VPN up or down). On a change, tunnels
+// reconnect right away instead of waiting for keepalives to notice the old
+// connection is gone. interval 0 turns this off. Call before starting
+// tunnels.
+func SetNetworkWatch(interval time.Duration) {
+	networkPoll = interval
+}
+
+// errNetworkChanged ends a connection that was moved to a new network.
+var errNetworkChanged = errors.New("network changed")
+
+var netWatch struct {
+	once    sync.Once
+	mu      sync.Mutex
+	changed chan struct{} // closed and replaced on every change
+}
+
+// networkChanged returns a channel closed at the next network change, or
+// nil if the check is off. host is the worker's; the route to it is part of
+// what is watched. The watch starts on first use and runs until exit.
+func networkChanged(host string) <-chan struct{} {
+	if networkPoll <= 0 {
+		return nil
+	}
+	netWatch.once.Do(func() {
+		netWatch.changed = make(chan struct{})
+		go watchNetwork(host)
+	})
+	netWatch.mu.Lock()
+	defer netWatch.mu.Unlock()
+	return netWatch.changed
+}
+
+func watchNetwork(host string) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	var routeTo string
+	resolve := func() {
+		if ips, err := net.LookupIP(host); err == nil && len(ips) > 0 {
+			routeTo = ips[0].String()
+		}
+	}
+	resolve()
+	last := networkFingerprint(routeTo)
+	ticker := time.NewTicker(networkPoll)
+	defer ticker.Stop()
+	for range ticker.C {
+		if routeTo == "" {
+			resolve()
+		}
+		fp := networkFingerprint(routeTo)
+		if fp == last {
+			continue
+		}
+		last = fp
+		netWatch.mu.Lock()
+		close(netWatch.changed)
+		netWatch.changed = make(chan struct{})
+		netWatch.mu.Unlock()
+	}
+}
+
+// networkFingerprint describes the machine's network: the addresses of its
+// interfaces that are up, and the local address traffic to ip leaves from
+// (a UDP "connection" only picks the route, it sends nothing).
+func networkFingerprint(ip string) string {
+	var parts []string
+	ifaces, _ := net.Interfaces()
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, _ := ifi.Addrs()
+		for _, a := range addrs {
+			parts = append(parts, ifi.Name+"="+a.String())
+		}
+	}
+	if ip != "" {
+		if c, err := net.Dial("udp", net.JoinHostPort(ip, "443")); err == nil {
+			parts = append(parts, "route="+c.LocalAddr().(*net.UDPAddr).IP.String())
+			c.Close()
+		}
+	}
+	slices.Sort(parts)
+	return strings.Join(parts, " ")
+}
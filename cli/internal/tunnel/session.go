@@ -0,0 +1,157 @@
+package tunnel
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+// resumeWindow is how long a response waits for the tunnel to reconnect
+// after its connection dropped, and how long answered requests are
+// remembered in case the worker re-sends them. Matches the worker's 30s
+// wait for a response.
+const resumeWindow = 30 * time.Second
+
+// Buffered responses are kept to answer re-sent requests again up to these
+// sizes; bigger ones are only answered once.
+const (
+	maxResumeBody  = 1 << 20
+	maxResumeBytes = 16 << 20
+)
+
+// session is a tunnel's state across its connections. The worker re-sends
+// the requests that were in flight when a connection dropped (a laptop
+// switching networks, say) to the next connection of the same session;
+// responses go out over whichever connection is current, and each request
+// is served once.
+type session struct {
+	id       string // sent as ?session= so the worker knows it's us again
+	requests *inflight
+	cancels  *cancels
+
+	mu      sync.Mutex
+	conn    *connWriter   // nil while reconnecting
+	swapped chan struct{} // closed and replaced when conn changes
+	served  map[string]*servedRequest
+	order   []string // served IDs, oldest first
+	kept    int      // bytes of kept responses
+}
+
+// connWriter writes to one connection.
+type connWriter struct {
+	write func(any) error
+}
+
+type servedRequest struct {
+	at   time.Time
+	resp *types.TunnelResponse // the buffered answer, once sent
+}
+
+func newSession() *session {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return &session{
+		id:       hex.EncodeToString(b),
+		requests: newInflight(),
+		cancels:  newCancels(),
+		swapped:  make(chan struct{}),
+		served:   make(map[string]*servedRequest),
+	}
+}
+
+// attach makes w the connection responses go out on.
+func (s *session) attach(w *connWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.swap(w)
+}
+
+// detach stops using w, if it is still the current connection.
+func (s *session) detach(w *connWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == w {
+		s.swap(nil)
+	}
+}
+
+func (s *session) swap(w *connWriter) {
+	s.conn = w
+	close(s.swapped)
+	s.swapped = make(chan struct{})
+}
+
+// writeJSON sends v over the current connection. If there is none, or
+// writing fails, it waits up to resumeWindow for the next connection.
+func (s *session) writeJSON(v any) error {
+	deadline := time.Now().Add(resumeWindow)
+	for {
+		s.mu.Lock()
+		w, swapped := s.conn, s.swapped
+		s.mu.Unlock()
+		err := errors.New("tunnel didn't reconnect in time")
+		if w != nil {
+			if err = w.write(v); err == nil {
+				s.keep(v)
+				return nil
+			}
+		}
+		select {
+		case <-swapped:
+		case <-time.After(time.Until(deadline)):
+			return err
+		}
+	}
+}
+
+// seen records that request id arrived. dup reports one the worker re-sent
+// after a reconnect, with the answer to send again if it was buffered.
+func (s *session) seen(id string) (dup bool, resp *types.TunnelResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune()
+	if r, ok := s.served[id]; ok {
+		return true, r.resp
+	}
+	s.served[id] = &servedRequest{at: time.Now()}
+	s.order = append(s.order, id)
+	return false, nil
+}
+
+// keep remembers a buffered response for a re-sent request.
+func (s *session) keep(v any) {
+	resp, ok := v.(types.TunnelResponse)
+	if !ok || len(resp.Body) > maxResumeBody {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.served[resp.ID]
+	if !ok || r.resp != nil || s.kept+len(resp.Body) > maxResumeBytes {
+		return
+	}
+	r.resp = &resp
+	s.kept += len(resp.Body)
+}
+
+// prune forgets requests older than resumeWindow.
+func (s *session) prune() {
+	cutoff := time.Now().Add(-resumeWindow)
+	n := 0
+	for _, id := range s.order {
+		r := s.served[id]
+		if r.at.After(cutoff) {
+			break
+		}
+		if r.resp != nil {
+			s.kept -= len(r.resp.Body)
+		}
+		delete(s.served, id)
+		n++
+	}
+	s.order = s.order[n:]
+}
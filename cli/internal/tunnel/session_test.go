@@ -0,0 +1,79 @@
+package tunnel
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+func TestSessionWritesAfterReconnect(t *testing.T) {
+	s := newSession()
+	old := &connWriter{write: func(any) error { return errors.New("connection closed") }}
+	s.attach(old)
+
+	w := &worker{}
+	sent := make(chan error, 1)
+	go func() { sent <- s.writeJSON(types.TunnelResponse{ID: "r1"}) }()
+	select {
+	case err := <-sent:
+		t.Fatalf("write on a dropped connection returned %v instead of waiting", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// A late detach of the old connection doesn't drop the new one
+	next := &connWriter{write: w.write}
+	s.attach(next)
+	s.detach(old)
+	select {
+	case err := <-sent:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("write not resumed on the new connection")
+	}
+	if len(w.sent) != 1 {
+		t.Fatalf("new connection got %v", w.sent)
+	}
+	if s.conn != next {
+		t.Fatal("detaching the old connection dropped the new one")
+	}
+}
+
+func TestSessionKeepsAnswersForResentRequests(t *testing.T) {
+	s := newSession()
+	s.attach(&connWriter{write: (&worker{}).write})
+
+	if dup, _ := s.seen("r1"); dup {
+		t.Fatal("first request seen as a duplicate")
+	}
+	resp := types.TunnelResponse{Type: types.TypeHTTPResponse, ID: "r1", Status: 200, Body: "aGk="}
+	if err := s.writeJSON(resp); err != nil {
+		t.Fatal(err)
+	}
+	dup, kept := s.seen("r1")
+	if !dup || kept == nil || kept.Body != resp.Body {
+		t.Fatalf("re-sent request: dup %v, kept %+v", dup, kept)
+	}
+
+	// Too big to keep: answered once, then dropped
+	s.seen("r2")
+	if err := s.writeJSON(types.TunnelResponse{ID: "r2", Body: strings.Repeat("a", maxResumeBody+1)}); err != nil {
+		t.Fatal(err)
+	}
+	if dup, kept := s.seen("r2"); !dup || kept != nil {
+		t.Fatalf("oversized answer: dup %v, kept %v", dup, kept != nil)
+	}
+
+	// Forgotten after resumeWindow
+	s.served["r1"].at = time.Now().Add(-resumeWindow - time.Second)
+	if dup, _ := s.seen("r1"); dup {
+		t.Fatal("request from long ago still seen")
+	}
+	if s.kept != 0 {
+		t.Fatalf("%d bytes still kept after pruning", s.kept)
+	}
+}
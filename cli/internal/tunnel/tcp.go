@@ -0,0 +1,87 @@
+package tunnel
+
+import (
+	"encoding/json"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/proxy"
+	"github.com/QuadTriangle/prod.bd/cli/internal/types"
+)
+
+// tcpPorts are the local ports tunneled as raw TCP (-tcp). Set with
+// SetTCPPorts.
+var tcpPorts = map[int]bool{}
+
+// SetTCPPorts marks the tunnels of ports as raw TCP: the worker relays
+// visitor WebSockets to them as streams of bytes instead of proxying HTTP.
+// Call before starting tunnels.
+func SetTCPPorts(ports []int) {
+	tcpPorts = make(map[int]bool, len(ports))
+	for _, port := range ports {
+		tcpPorts[port] = true
+	}
+}
+
+// handleTCPData hands a binary tcp-data message to the relay.
+func handleTCPData(raw []byte, localPort int, tcpRelay *proxy.TCPRelay, reportError func(types.TunnelError)) {
+	defer func() {
+		if r := recover(); r != nil {
+			onPanic(localPort, r)
+		}
+	}()
+	id, payload, err := types.DecodeTCPData(raw)
+	if err != nil {
+		reportError(types.TunnelError{MsgType: types.TypeTCPData, Code: types.ErrCodeMalformed, Message: err.Error()})
+		return
+	}
+	tcpRelay.HandleData(id, payload)
+}
+
+// handleTCPMessage dispatches tcp-* messages. Returns false for any other
+// type. Streams the access policy turns away are closed with 1008.
+func handleTCPMessage(raw []byte, tcpRelay *proxy.TCPRelay, pipeline *hooks.TunnelPipeline, writeJSON func(any) error, reportError func(types.TunnelError)) (handled bool) {
+	var envelope messageEnvelope
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		return false
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			onPanic(pipeline.Tunnel().Port, r)
+			handled = true
+		}
+	}()
+	malformed := func(err error) {
+		reportError(types.TunnelError{ID: envelope.ID, MsgType: envelope.Type, Code: types.ErrCodeMalformed, Message: err.Error()})
+	}
+
+	switch envelope.Type {
+	case types.TypeTCPOpen:
+		var msg types.TCPOpen
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			malformed(err)
+			return true
+		}
+		if err := msg.Validate(); err != nil {
+			malformed(err)
+			return true
+		}
+		if _, admitted := admitStream(pipeline, msg.ID, "/", msg.Headers); !admitted {
+			Logf(pipeline.Tunnel().Port, "Refused TCP stream %s: the access policy turned the visitor away", msg.ID)
+			_ = writeJSON(types.TCPClose{Type: types.TypeTCPClose, ID: msg.ID, Code: 1008, Reason: "Forbidden"})
+			return true
+		}
+		tcpRelay.HandleOpen(msg)
+
+	case types.TypeTCPClose:
+		var msg types.TCPClose
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			malformed(err)
+			return true
+		}
+		tcpRelay.HandleClose(msg)
+
+	default:
+		return false
+	}
+	return true
+}
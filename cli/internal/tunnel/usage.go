@@ -0,0 +1,84 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+)
+
+// wireCounter counts the bytes of one tunnel's connections to the worker.
+type wireCounter struct {
+	sent, received atomic.Int64
+	connections    atomic.Int32
+}
+
+var wireCounters sync.Map // subdomain -> *wireCounter
+
+func wireCounterFor(subdomain string) *wireCounter {
+	c, _ := wireCounters.LoadOrStore(subdomain, &wireCounter{})
+	return c.(*wireCounter)
+}
+
+// dial opens a TCP connection whose traffic is counted; it is a websocket
+// Dialer's NetDialContext, so TLS and the upgrade handshake are counted too.
+func (w *wireCounter) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	var d net.Dialer
+	c, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	w.connections.Add(1)
+	return &countingConn{Conn: c, w: w}, nil
+}
+
+type countingConn struct {
+	net.Conn
+	w *wireCounter
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.w.received.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.w.sent.Add(int64(n))
+	return n, err
+}
+
+// Usage returns the wire usage of the tunnel for subdomain so far.
+func Usage(subdomain string) hooks.WireUsage {
+	c, ok := wireCounters.Load(subdomain)
+	if !ok {
+		return hooks.WireUsage{Subdomain: subdomain}
+	}
+	return c.(*wireCounter).usage(subdomain)
+}
+
+// AllUsage returns the wire usage of every tunnel that connected this
+// session, by subdomain.
+func AllUsage() []hooks.WireUsage {
+	var out []hooks.WireUsage
+	wireCounters.Range(func(k, v any) bool {
+		out = append(out, v.(*wireCounter).usage(k.(string)))
+		return true
+	})
+	slices.SortFunc(out, func(a, b hooks.WireUsage) int { return strings.Compare(a.Subdomain, b.Subdomain) })
+	return out
+}
+
+func (w *wireCounter) usage(subdomain string) hooks.WireUsage {
+	return hooks.WireUsage{
+		Subdomain:   subdomain,
+		Sent:        w.sent.Load(),
+		Received:    w.received.Load(),
+		Connections: int(w.connections.Load()),
+	}
+}
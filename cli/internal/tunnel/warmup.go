@@ -0,0 +1,35 @@
+package tunnel
+
+import (
+	"time"
+
+	"github.com/QuadTriangle/prod.bd/cli/internal/hooks"
+	"github.com/QuadTriangle/prod.bd/cli/internal/proxy"
+)
+
+// Warm-up settings; warmupPath "" disables it. Set with SetWarmup.
+var (
+	warmupPath  string
+	warmupConns int
+)
+
+// SetWarmup makes each tunnel send conns GET requests for path to its local
+// server every time it connects, before reporting the connection to hooks.
+// An empty path disables warm-up. Call before starting tunnels.
+func SetWarmup(path string, conns int) {
+	warmupPath, warmupConns = path, conns
+}
+
+// warmup runs the configured warm-up for localPort, or returns nil if disabled.
+func warmup(localPort int) *hooks.WarmupResult {
+	if warmupPath == "" {
+		return nil
+	}
+	status, conns, latency, err := proxy.Warmup(localPort, warmupPath, warmupConns)
+	if err != nil {
+		Logf(localPort, "Warm-up of port %d failed: %v", localPort, err)
+	} else {
+		Logf(localPort, "Warmed up port %d: GET %s -> %d in %s (%d connections)", localPort, warmupPath, status, latency.Round(time.Millisecond), conns)
+	}
+	return &hooks.WarmupResult{Path: warmupPath, Status: status, Conns: conns, Latency: latency, Err: err}
+}
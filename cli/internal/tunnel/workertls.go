@@ -0,0 +1,78 @@
+package tunnel
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/websocket"
+)
+
+// workerTLS configures TLS to the worker, for registration, region probes
+// and tunnel connections alike; nil uses Go's defaults. Set with
+// SetWorkerTLS.
+var workerTLS *tls.Config
+
+// workerHTTP is the client for requests to the worker's API.
+var workerHTTP = http.DefaultClient
+
+// SetWorkerTLS sets up mutual TLS with a self-hosted worker behind an edge
+// that requires client certificates: certFile and keyFile (PEM) are
+// presented to the worker, and the certificates in caFile (a PEM bundle)
+// are trusted for its server certificate on top of the system roots. Empty
+// arguments leave that part at the default; the certificate and key go
+// together. Call before Register.
+func SetWorkerTLS(certFile, keyFile, caFile string) error {
+	if certFile == "" && keyFile == "" && caFile == "" {
+		return nil
+	}
+	if (certFile == "") != (keyFile == "") {
+		return errors.New("a worker client certificate needs both a certificate and a key file")
+	}
+	cfg := &tls.Config{}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("loading worker client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return fmt.Errorf("reading worker CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no PEM certificates in worker CA bundle %s", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+	workerTLS = cfg
+	workerHTTP = &http.Client{Transport: workerTransport()}
+	return nil
+}
+
+// workerTransport returns a new HTTP transport for the worker.
+func workerTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if workerTLS != nil {
+		t.TLSClientConfig = workerTLS.Clone()
+	}
+	return t
+}
+
+// workerDialer returns a new WebSocket dialer for the worker.
+func workerDialer() *websocket.Dialer {
+	d := *websocket.DefaultDialer
+	if workerTLS != nil {
+		d.TLSClientConfig = workerTLS.Clone()
+	}
+	return &d
+}
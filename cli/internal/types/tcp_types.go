@@ -0,0 +1,68 @@
+package types
+
+import (
+	"errors"
+)
+
+// Visitors reach a -tcp tunnel by opening a WebSocket to its URL (prod
+// connect does that for local clients); the worker relays each one as a
+// stream. Stream data travels in binary WebSocket messages (see
+// EncodeTCPData) rather than base64 JSON; opening, closing and
+// acknowledging streams use the JSON messages below.
+
+// TCPOpen tells the CLI a visitor opened a raw TCP stream to the tunnel.
+type TCPOpen struct {
+	Type string `json:"type"`
+	ID   string `json:"id"` // Stream ID
+	// Headers of the visitor's WebSocket upgrade, for the access policy
+	// (e.g. CF-Connecting-IP)
+	Headers map[string][]string `json:"headers,omitempty"`
+}
+
+// TCPAck tells the worker that Bytes more of a stream's data were written
+// to the local connection, reopening that much of its TCPWindow.
+type TCPAck struct {
+	Type  string `json:"type"`
+	ID    string `json:"id"`
+	Bytes int    `json:"bytes"`
+}
+
+// TCPClose signals the other side that a TCP stream has ended. Code is the
+// WebSocket close code the worker closes the visitor's socket with (1000
+// if unset).
+type TCPClose struct {
+	Type   string `json:"type"`
+	ID     string `json:"id"`
+	Code   int    `json:"code,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// TCPWindow is how many bytes of a stream the worker may send before the
+// CLI acknowledges them. The worker holds back the rest, so a slow local
+// server can't make the CLI buffer without bound.
+const TCPWindow = 1 << 20
+
+// tcpDataFrame starts the binary message carrying stream data.
+const tcpDataFrame = 1
+
+// EncodeTCPData frames a chunk of stream id as a binary message: a frame
+// type byte, the length of id in one byte, id, then the payload.
+func EncodeTCPData(id string, payload []byte) []byte {
+	msg := make([]byte, 0, 2+len(id)+len(payload))
+	msg = append(msg, tcpDataFrame, byte(len(id)))
+	msg = append(msg, id...)
+	return append(msg, payload...)
+}
+
+// DecodeTCPData splits a binary message from EncodeTCPData. payload aliases
+// msg.
+func DecodeTCPData(msg []byte) (id string, payload []byte, err error) {
+	if len(msg) < 2 || msg[0] != tcpDataFrame {
+		return "", nil, errors.New("not a tcp-data frame")
+	}
+	n := int(msg[1])
+	if n == 0 || len(msg) < 2+n {
+		return "", nil, errors.New("truncated tcp-data frame")
+	}
+	return string(msg[2 : 2+n]), msg[2+n:], nil
+}
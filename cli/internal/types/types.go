@@ -2,13 +2,61 @@ package types
 
 // Wire-level type discriminator — present on all tunnel messages
 const (
-	TypeHTTPRequest  = "http-request"
-	TypeHTTPResponse = "http-response"
-	TypeWSOpen       = "ws-open"
-	TypeWSFrame      = "ws-frame"
-	TypeWSClose      = "ws-close"
+	TypeHTTPRequest   = "http-request"
+	TypeHTTPResponse  = "http-response"
+	TypeHTTPRespStart = "http-response-start"
+	TypeHTTPRespChunk = "http-response-chunk"
+	TypeHTTPRespEnd   = "http-response-end"
+	TypeHTTPCancel    = "http-cancel"
+	TypeWSOpen        = "ws-open"
+	TypeWSFrame       = "ws-frame"
+	TypeWSClose       = "ws-close"
+	TypeTCPOpen       = "tcp-open"
+	TypeTCPData       = "tcp-data" // binary, see EncodeTCPData
+	TypeTCPAck        = "tcp-ack"
+	TypeTCPClose      = "tcp-close"
+	TypeError         = "error"
+	TypeConfigUpdate  = "config-update"
 )
 
+// Body encodings of TunnelResponse.
+const EncodingGzip = "gzip"
+
+// EncodingsHeader is the tunnel upgrade response header in which the worker
+// lists the TunnelResponse encodings it can decode, comma-separated.
+const EncodingsHeader = "X-Tunnel-Encodings"
+
+// Codes carried by TunnelError.
+const (
+	ErrCodeUnknownType = "unknown-type" // message type the CLI doesn't know
+	ErrCodeMalformed   = "malformed"    // message couldn't be decoded
+	ErrCodeTooLarge    = "too-large"    // message exceeds MaxMessageSize
+)
+
+// MaxMessageSize is the largest tunnel message the CLI accepts. Bigger ones
+// are dropped and answered with a too-large TunnelError. It leaves room for
+// base64-encoded request bodies of up to about 48MB.
+const MaxMessageSize = 64 << 20
+
+// TunnelError reports a message the CLI couldn't handle back to the worker,
+// so protocol mismatches are visible on both ends. ID and MsgType identify
+// the offending message when they could be read.
+type TunnelError struct {
+	Type    string `json:"type"`
+	ID      string `json:"id,omitempty"`
+	MsgType string `json:"msgType,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ConfigUpdate is sent by the worker when the tunnel's config changed
+// mid-session (an admin tightened its IP allowlist, say). Config is the whole
+// config now in effect: what the CLI registered, with the admin's overrides.
+type ConfigUpdate struct {
+	Type   string         `json:"type"`
+	Config map[string]any `json:"config"`
+}
+
 // TunnelRequest is an HTTP request forwarded through the tunnel.
 type TunnelRequest struct {
 	Type    string              `json:"type"`
@@ -26,12 +74,57 @@ type TunnelResponse struct {
 	Status  int                 `json:"status"`
 	Headers map[string][]string `json:"headers"`
 	Body    string              `json:"body,omitempty"` // Base64 encoded
+	// Encoding is EncodingGzip if Body is gzip-compressed before base64.
+	// Only used when the worker lists it in EncodingsHeader.
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// Reasons carried by HTTPCancel.
+const (
+	CancelDisconnect = "disconnect" // the visitor went away
+	CancelTimeout    = "timeout"    // the worker stopped waiting for a response
+)
+
+// HTTPCancel is sent by the worker when no one will read the response to an
+// http-request any more, so the CLI can abort it.
+type HTTPCancel struct {
+	Type   string `json:"type"`
+	ID     string `json:"id"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// TunnelResponseStart opens a streamed response: status and headers, no body.
+// The body follows as TunnelResponseChunk messages and a TunnelResponseEnd.
+type TunnelResponseStart struct {
+	Type    string              `json:"type"`
+	ID      string              `json:"id"`
+	Status  int                 `json:"status"`
+	Headers map[string][]string `json:"headers"`
+}
+
+// TunnelResponseChunk carries the next piece of a streamed response body.
+type TunnelResponseChunk struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+	Body string `json:"body"` // Base64 encoded
+}
+
+// TunnelResponseEnd terminates a streamed response. Error is set if the local
+// server failed mid-body; Trailers carries HTTP trailers such as grpc-status.
+type TunnelResponseEnd struct {
+	Type     string              `json:"type"`
+	ID       string              `json:"id"`
+	Error    string              `json:"error,omitempty"`
+	Trailers map[string][]string `json:"trailers,omitempty"`
 }
 
 type RegisterRequest struct {
-	ClientID string         `json:"clientId"`
-	Ports    []int          `json:"ports"`
-	Config   map[string]any `json:"config,omitempty"`
+	ClientID   string         `json:"clientId"`
+	Ports      []int          `json:"ports"`
+	Subdomains map[int]string `json:"subdomains,omitempty"` // port -> requested subdomain
+	TCPPorts   []int          `json:"tcpPorts,omitempty"`   // subset of Ports tunneled as raw TCP
+	Labels     map[int]string `json:"labels,omitempty"`     // port -> display name (e.g. frontend, api)
+	Config     map[string]any `json:"config,omitempty"`
 }
 
 type RegisterResponse struct {
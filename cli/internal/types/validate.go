@@ -0,0 +1,90 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Messages are relayed from the public internet by the worker, so fields
+// that end up in local requests are checked before use.
+
+// Validate checks that an HTTP request is safe to replay against the local server.
+func (r TunnelRequest) Validate() error {
+	if r.ID == "" {
+		return errors.New("missing request id")
+	}
+	if !isToken(r.Method) {
+		return fmt.Errorf("invalid method %q", r.Method)
+	}
+	if err := validatePath(r.Path); err != nil {
+		return err
+	}
+	return validateHeaders(r.Headers)
+}
+
+// Validate checks a WebSocket open request before dialing the local server.
+func (m WSOpen) Validate() error {
+	if m.ID == "" {
+		return errors.New("missing session id")
+	}
+	if err := validatePath(m.Path); err != nil {
+		return err
+	}
+	return validateHeaders(m.Headers)
+}
+
+// Validate checks a TCP stream open request before dialing the local server.
+func (m TCPOpen) Validate() error {
+	if m.ID == "" || len(m.ID) > 255 {
+		return fmt.Errorf("invalid stream id %q", m.ID)
+	}
+	return validateHeaders(m.Headers)
+}
+
+// validatePath accepts origin-form request targets ("/path?query") only.
+func validatePath(path string) error {
+	if !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("invalid path %q: must start with /", path)
+	}
+	if strings.ContainsAny(path, " \r\n\x00") {
+		return fmt.Errorf("invalid path %q: contains whitespace or control characters", path)
+	}
+	// As the proxy builds the local URL
+	if _, err := url.Parse("http://local" + path); err != nil {
+		return fmt.Errorf("invalid path %q: %v", path, errors.Unwrap(err))
+	}
+	return nil
+}
+
+func validateHeaders(headers map[string][]string) error {
+	for name, values := range headers {
+		if !isToken(name) {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		for _, v := range values {
+			if strings.ContainsAny(v, "\r\n\x00") {
+				return fmt.Errorf("invalid value for header %s", name)
+			}
+		}
+	}
+	return nil
+}
+
+// isToken reports whether s is a non-empty RFC 9110 token (method and header names).
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+package types
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// FuzzTunnelRequestValidate checks that any http-request Validate passes
+// can be written out to the local server and read back as the same
+// request.
+func FuzzTunnelRequestValidate(f *testing.F) {
+	f.Add([]byte(`{"type":"http-request","id":"r1","method":"GET","path":"/","headers":{"Accept":["*/*"]}}`))
+	f.Add([]byte(`{"type":"http-request","id":"r1","method":"POST","path":"/a?b=c","headers":{"X":["1","2"]},"body":"aGk="}`))
+	f.Add([]byte(`{"type":"http-request","id":"r1","method":"GET /x HTTP/1.1","path":"/"}`))
+	f.Add([]byte(`{"type":"http-request","id":"r1","method":"GET","path":"/%zz"}`))
+	f.Add([]byte(`{"type":"http-request","id":"r1","method":"GET","path":"/","headers":{"X":["a\r\nInjected: 1"]}}`))
+	f.Add([]byte(`{"type":"http-request","id":"r1","method":"GET","path":"http://other/"}`))
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		var req TunnelRequest
+		if json.Unmarshal(raw, &req) != nil || req.Validate() != nil {
+			return
+		}
+		httpReq, err := http.NewRequest(req.Method, "http://local"+req.Path, nil)
+		if err != nil {
+			t.Fatalf("valid request %+v can't be built: %v", req, err)
+		}
+		httpReq.Header = req.Headers
+		var buf bytes.Buffer
+		if err := httpReq.Write(&buf); err != nil {
+			t.Fatalf("valid request %+v can't be written: %v", req, err)
+		}
+		back, err := http.ReadRequest(bufio.NewReader(&buf))
+		if err != nil {
+			t.Fatalf("valid request %+v written as %q: %v", req, buf.String(), err)
+		}
+		if back.Method != req.Method || back.Host != "local" {
+			t.Fatalf("valid request %+v read back as %s to %s", req, back.Method, back.Host)
+		}
+	})
+}
+
+// FuzzStreamOpenValidate checks that ws-open and tcp-open messages Validate
+// passes carry nothing that could split the local request.
+func FuzzStreamOpenValidate(f *testing.F) {
+	f.Add([]byte(`{"type":"ws-open","id":"s1","path":"/ws","headers":{"Origin":["https://a.example"]}}`))
+	f.Add([]byte(`{"type":"tcp-open","id":"s1","headers":{"X":["a\nb"]}}`))
+	f.Add([]byte(`{"type":"ws-open","id":"","path":"ws"}`))
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		var ws WSOpen
+		if json.Unmarshal(raw, &ws) == nil && ws.Validate() == nil {
+			if ws.ID == "" || !bytes.HasPrefix([]byte(ws.Path), []byte("/")) {
+				t.Fatalf("accepted ws-open %+v", ws)
+			}
+			checkHeaders(t, ws.Headers)
+		}
+		var tcp TCPOpen
+		if json.Unmarshal(raw, &tcp) == nil && tcp.Validate() == nil {
+			// The ID has to fit the one-byte length of tcp-data frames
+			if id, _, err := DecodeTCPData(EncodeTCPData(tcp.ID, nil)); err != nil || id != tcp.ID {
+				t.Fatalf("accepted tcp-open id %q doesn't survive framing", tcp.ID)
+			}
+			checkHeaders(t, tcp.Headers)
+		}
+	})
+}
+
+func checkHeaders(t *testing.T, headers map[string][]string) {
+	t.Helper()
+	for name, values := range headers {
+		if !isToken(name) {
+			t.Fatalf("accepted header name %q", name)
+		}
+		for _, v := range values {
+			if bytes.ContainsAny([]byte(v), "\r\n\x00") {
+				t.Fatalf("accepted header %s: %q", name, v)
+			}
+		}
+	}
+}
+
+func FuzzDecodeTCPData(f *testing.F) {
+	f.Add(EncodeTCPData("s1", []byte("hello")))
+	f.Add([]byte{1, 0})
+	f.Add([]byte{1, 5, 'a'})
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		id, payload, err := DecodeTCPData(msg)
+		if err != nil {
+			return
+		}
+		if again := EncodeTCPData(id, payload); !bytes.Equal(again, msg) {
+			t.Fatalf("DecodeTCPData(%q) = %q, %q, which encodes to %q", msg, id, payload, again)
+		}
+	})
+}
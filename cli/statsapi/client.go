@@ -0,0 +1,394 @@
+// Package statsapi is a Go client for the local API a running prod serves
+// on its dashboard port and its control socket: tunnel stats, the request
+// log, replays. Its
+// OpenAPI description is openapi.json here, also served at
+// /api/v1/openapi.json. Endpoints under /api/v1 only change compatibly;
+// breaking changes get a new version.
+package statsapi
+
+//go:generate go run ../internal/plugins/stats/genopenapi openapi.json
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version is the API version this package speaks.
+const Version = "v1"
+
+// Prefix is where the versioned API is served.
+const Prefix = "/api/" + Version
+
+// DefaultPort is prod's default -dashboard-port.
+const DefaultPort = 9999
+
+// TokenHeader carries the token from /token on requests to the dashboard
+// port that change anything, so that other websites can't make them.
+const TokenHeader = "X-Prod-Token"
+
+// Client talks to one prod process.
+type Client struct {
+	BaseURL string // e.g. http://127.0.0.1:9999
+	HTTP    *http.Client
+}
+
+// New returns a client for the prod whose dashboard is on port.
+func New(port int) *Client {
+	return &Client{
+		BaseURL: fmt.Sprintf("http://127.0.0.1:%d", port),
+		HTTP:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Error is a non-2xx response.
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, http.StatusText(e.Status), e.Message)
+}
+
+// do sends a request to path (after Prefix) and returns the response if it
+// succeeded.
+func (c *Client) do(ctx context.Context, hc *http.Client, method, path string, q url.Values) (*http.Response, error) {
+	u := strings.TrimSuffix(c.BaseURL, "/") + Prefix + path
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if method != http.MethodGet || tokenPaths[path] {
+		token, err := c.token(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(TokenHeader, token)
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, &Error{Status: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	return resp, nil
+}
+
+// tokenPaths need the token even to read: magic links are as good as a
+// password.
+var tokenPaths = map[string]bool{"/stats/links": true}
+
+// token fetches the token for requests that change anything.
+func (c *Client) token(ctx context.Context) (string, error) {
+	resp, err := c.do(ctx, c.HTTP, http.MethodGet, "/token", nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return strings.TrimSpace(string(data)), err
+}
+
+func (c *Client) getJSON(ctx context.Context, path string, q url.Values, v any) error {
+	resp, err := c.do(ctx, c.HTTP, http.MethodGet, path, q)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Tunnels lists the connected tunnels.
+func (c *Client) Tunnels(ctx context.Context) ([]Tunnel, error) {
+	var body struct {
+		Tunnels []Tunnel `json:"tunnels"`
+	}
+	err := c.getJSON(ctx, "/stats/tunnels", nil, &body)
+	return body.Tunnels, err
+}
+
+// Summary totals all tunnels.
+func (c *Client) Summary(ctx context.Context) (Summary, error) {
+	var body struct {
+		Summary Summary `json:"summary"`
+	}
+	err := c.getJSON(ctx, "/stats/summary", nil, &body)
+	return body.Summary, err
+}
+
+// Paths returns per-endpoint stats, slowest p99 first, for one tunnel or
+// all if subdomain is empty.
+func (c *Client) Paths(ctx context.Context, subdomain string) ([]PathStats, error) {
+	var body struct {
+		Paths []PathStats `json:"paths"`
+	}
+	q := url.Values{}
+	if subdomain != "" {
+		q.Set("subdomain", subdomain)
+	}
+	err := c.getJSON(ctx, "/stats/paths", q, &body)
+	return body.Paths, err
+}
+
+// BaselineDivergences lists responses that differed from the -baseline
+// recording, newest first, for one tunnel or all if subdomain is empty.
+func (c *Client) BaselineDivergences(ctx context.Context, subdomain string) ([]BaselineDivergence, error) {
+	var body struct {
+		Divergences []BaselineDivergence `json:"divergences"`
+	}
+	q := url.Values{}
+	if subdomain != "" {
+		q.Set("subdomain", subdomain)
+	}
+	err := c.getJSON(ctx, "/stats/baseline", q, &body)
+	return body.Divergences, err
+}
+
+// RequestQuery filters Requests. Zero values mean no filter.
+type RequestQuery struct {
+	Subdomain  string
+	Methods    []string
+	Statuses   []string // e.g. 404 or 5xx
+	PathPrefix string
+	PathRegex  string
+	MinLatency time.Duration
+	Since      time.Time
+	Until      time.Time
+	Limit      int // default 100, max 500
+	Cursor     int // NextCursor of the previous page
+}
+
+func (q RequestQuery) values() url.Values {
+	v := url.Values{}
+	set := func(k, val string) {
+		if val != "" {
+			v.Set(k, val)
+		}
+	}
+	set("subdomain", q.Subdomain)
+	set("method", strings.Join(q.Methods, ","))
+	set("status", strings.Join(q.Statuses, ","))
+	set("path", q.PathPrefix)
+	set("path_regex", q.PathRegex)
+	if q.MinLatency > 0 {
+		v.Set("min_latency", strconv.FormatFloat(float64(q.MinLatency)/float64(time.Millisecond), 'f', -1, 64))
+	}
+	if !q.Since.IsZero() {
+		v.Set("since", strconv.FormatInt(q.Since.Unix(), 10))
+	}
+	if !q.Until.IsZero() {
+		v.Set("until", strconv.FormatInt(q.Until.Unix(), 10))
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if q.Cursor > 0 {
+		v.Set("cursor", strconv.Itoa(q.Cursor))
+	}
+	return v
+}
+
+// Requests returns a page of the request log, newest first.
+func (c *Client) Requests(ctx context.Context, q RequestQuery) (RequestPage, error) {
+	var page RequestPage
+	err := c.getJSON(ctx, "/stats/requests", q.values(), &page)
+	return page, err
+}
+
+// Export returns the requests matching q as a HAR 1.2 document, oldest
+// first. Cursor is ignored; Limit defaults to 1000, max 10000.
+func (c *Client) Export(ctx context.Context, q RequestQuery) (io.ReadCloser, error) {
+	v := q.values()
+	v.Set("format", "har")
+	resp, err := c.do(ctx, c.HTTP, http.MethodGet, "/stats/export", v)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Curl renders a logged request as a curl command, against the public URL
+// or, with local, the local server.
+func (c *Client) Curl(ctx context.Context, id int, local bool) (string, error) {
+	q := url.Values{}
+	if local {
+		q.Set("target", "local")
+	}
+	resp, err := c.do(ctx, c.HTTP, http.MethodGet, "/stats/requests/"+strconv.Itoa(id)+"/curl", q)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return string(data), err
+}
+
+// Replay re-sends a logged request to its tunnel's local port.
+func (c *Client) Replay(ctx context.Context, id int) (ReplayResult, error) {
+	var res ReplayResult
+	resp, err := c.do(ctx, c.HTTP, http.MethodPost, "/stats/requests/"+strconv.Itoa(id)+"/replay", nil)
+	if err != nil {
+		return res, err
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(&res)
+	return res, err
+}
+
+// Diff replays a logged request and compares the response with the
+// original. ignore lists headers and JSON paths ($.a.b) to leave out; nil
+// leaves out Date.
+func (c *Client) Diff(ctx context.Context, id int, ignore []string) (ReplayDiff, error) {
+	var d ReplayDiff
+	q := url.Values{}
+	if ignore != nil {
+		q.Set("ignore", strings.Join(ignore, ","))
+	}
+	resp, err := c.do(ctx, c.HTTP, http.MethodPost, "/stats/requests/"+strconv.Itoa(id)+"/diff", q)
+	if err != nil {
+		return d, err
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(&d)
+	return d, err
+}
+
+// Plugins reports the time each plugin's hooks add per request.
+func (c *Client) Plugins(ctx context.Context) ([]PluginOverhead, error) {
+	var body struct {
+		Plugins []PluginOverhead `json:"plugins"`
+	}
+	err := c.getJSON(ctx, "/stats/plugins", nil, &body)
+	return body.Plugins, err
+}
+
+// Runtime reports the health of the prod process.
+func (c *Client) Runtime(ctx context.Context) (Runtime, error) {
+	var rt Runtime
+	err := c.getJSON(ctx, "/stats/runtime", nil, &rt)
+	return rt, err
+}
+
+// Feedback lists comments from the banner's feedback widget, newest first.
+func (c *Client) Feedback(ctx context.Context, subdomain string) ([]Feedback, error) {
+	var body struct {
+		Feedback []Feedback `json:"feedback"`
+	}
+	q := url.Values{}
+	if subdomain != "" {
+		q.Set("subdomain", subdomain)
+	}
+	err := c.getJSON(ctx, "/stats/feedback", q, &body)
+	return body.Feedback, err
+}
+
+// LinkRequest describes a magic link for NewLink. Subdomain may be empty
+// when one tunnel is connected; Path defaults to / and TTL to the tunnel's
+// -magic-link-ttl.
+type LinkRequest struct {
+	Subdomain string
+	Email     string
+	Path      string
+	TTL       time.Duration
+}
+
+// NewLink makes a magic link; its URL is only ever returned here.
+func (c *Client) NewLink(ctx context.Context, r LinkRequest) (Link, error) {
+	var body struct {
+		Link Link `json:"link"`
+	}
+	q := url.Values{"email": {r.Email}}
+	if r.Subdomain != "" {
+		q.Set("subdomain", r.Subdomain)
+	}
+	if r.Path != "" {
+		q.Set("path", r.Path)
+	}
+	if r.TTL > 0 {
+		q.Set("ttl", r.TTL.String())
+	}
+	resp, err := c.do(ctx, c.HTTP, http.MethodPost, "/stats/links", q)
+	if err != nil {
+		return body.Link, err
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	return body.Link, err
+}
+
+// Links lists magic links, newest first, for one tunnel or all if
+// subdomain is empty.
+func (c *Client) Links(ctx context.Context, subdomain string) ([]Link, error) {
+	var body struct {
+		Links []Link `json:"links"`
+	}
+	q := url.Values{}
+	if subdomain != "" {
+		q.Set("subdomain", subdomain)
+	}
+	err := c.getJSON(ctx, "/stats/links", q, &body)
+	return body.Links, err
+}
+
+// RevokeLink voids a magic link and signs out whoever used it.
+func (c *Client) RevokeLink(ctx context.Context, id string) error {
+	resp, err := c.do(ctx, c.HTTP, http.MethodDelete, "/stats/links", url.Values{"id": {id}})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Stream calls fn with each request logged from now on, until ctx is done,
+// fn returns false or the process exits. An empty subdomain streams all
+// tunnels.
+func (c *Client) Stream(ctx context.Context, subdomain string, fn func(Request) bool) error {
+	q := url.Values{}
+	if subdomain != "" {
+		q.Set("subdomain", subdomain)
+	}
+	// No timeout: the stream stays open, with keepalives
+	resp, err := c.do(ctx, &http.Client{Transport: c.HTTP.Transport}, http.MethodGet, "/stats/stream", q)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(nil, 4<<20)
+	event := ""
+	for sc.Scan() {
+		line := sc.Text()
+		if name, ok := strings.CutPrefix(line, "event: "); ok {
+			event = name
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok || event != "request" {
+			continue
+		}
+		var r Request
+		if json.Unmarshal([]byte(data), &r) == nil && !fn(r) {
+			return nil
+		}
+	}
+	if err := sc.Err(); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,92 @@
+package statsapi
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// SocketDir holds the control sockets of running prod processes, one
+// <pid>.sock each. They serve the same API as the dashboard port.
+func SocketDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".prod", "run"), nil
+}
+
+// SocketPath is the control socket of the prod process pid.
+func SocketPath(pid int) (string, error) {
+	dir, err := SocketDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, strconv.Itoa(pid)+".sock"), nil
+}
+
+// NewUnix returns a client for the prod serving the control socket at path.
+func NewUnix(path string) *Client {
+	return &Client{
+		BaseURL: "http://prod",
+		HTTP: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", path)
+				},
+			},
+		},
+	}
+}
+
+// Process is a running prod found by Running.
+type Process struct {
+	PID    int
+	Socket string
+	Client *Client
+}
+
+// Running lists the prod processes with a control socket, by PID. Sockets
+// left behind by processes that died are removed.
+func Running() ([]Process, error) {
+	dir, err := SocketDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []Process
+	for _, e := range entries {
+		pid, err := strconv.Atoi(strings.TrimSuffix(e.Name(), ".sock"))
+		if err != nil || !strings.HasSuffix(e.Name(), ".sock") {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		conn, err := net.DialTimeout("unix", path, time.Second)
+		if err != nil {
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				os.Remove(path)
+			}
+			continue
+		}
+		conn.Close()
+		out = append(out, Process{PID: pid, Socket: path, Client: NewUnix(path)})
+	}
+	slices.SortFunc(out, func(a, b Process) int { return a.PID - b.PID })
+	return out, nil
+}
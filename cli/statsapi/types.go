@@ -0,0 +1,251 @@
+package statsapi
+
+// Tunnel is one connected tunnel and its traffic so far (GET /stats/tunnels).
+type Tunnel struct {
+	Subdomain     string  `json:"subdomain"`
+	Port          int     `json:"port"`
+	Label         string  `json:"label,omitempty"`
+	Region        string  `json:"region,omitempty"`
+	Group         string  `json:"group,omitempty"`
+	TotalRequests int     `json:"total_requests"`
+	ErrorCount    int     `json:"error_count"`
+	AvgLatency    float64 `json:"avg_latency"`
+	MaxLatency    float64 `json:"max_latency"`
+	MinLatency    float64 `json:"min_latency"`
+	// Latency percentiles in milliseconds, within about 3%
+	P50Latency    float64 `json:"p50_latency"`
+	P90Latency    float64 `json:"p90_latency"`
+	P99Latency    float64 `json:"p99_latency"`
+	TotalBytesIn  int     `json:"total_bytes_in"`
+	TotalBytesOut int     `json:"total_bytes_out"`
+	ConnectedAt   int64   `json:"connected_at"`
+	// ProtocolErrors counts unhandled tunnel messages by error code
+	ProtocolErrors map[string]int `json:"protocol_errors,omitempty"`
+	// SchemaViolations counts request bodies that failed -schema validation
+	SchemaViolations int `json:"schema_violations,omitempty"`
+	// BaselineMatched counts requests compared with their -baseline
+	// recording; BaselineDiverged those whose response differed
+	BaselineMatched  int `json:"baseline_matched,omitempty"`
+	BaselineDiverged int `json:"baseline_diverged,omitempty"`
+	// Queued is how many requests wait behind -max-concurrent now,
+	// MaxQueued the most that ever did, and QueueRejected how many got a
+	// 503 because the -max-queued limit was reached
+	Queued        int `json:"queued,omitempty"`
+	MaxQueued     int `json:"max_queued,omitempty"`
+	QueueRejected int `json:"queue_rejected,omitempty"`
+	// TargetHealth is the last -healthcheck probe of the local server
+	TargetHealth *TargetHealth `json:"target_health,omitempty"`
+	// FirstConnectedAt is the first connect of the session; ConnectedAt is
+	// the latest reconnect
+	FirstConnectedAt int64 `json:"first_connected_at"`
+	Reconnects       int   `json:"reconnects"`
+	// Connections are the latest connects and drops, oldest first
+	Connections []ConnEvent `json:"connections,omitempty"`
+	// WorkerConfigKeys are the keys of the config the worker last pushed
+	// mid-session, e.g. after an admin tightened the IP allowlist
+	WorkerConfigKeys []string `json:"worker_config_keys,omitempty"`
+	ConfigUpdatedAt  int64    `json:"config_updated_at,omitempty"`
+	// WireBytesSent and WireBytesReceived are the session's traffic with
+	// the worker as it went over the network: TLS, WebSocket framing and
+	// base64 included, unlike the body totals
+	WireBytesSent     int64 `json:"wire_bytes_sent"`
+	WireBytesReceived int64 `json:"wire_bytes_received"`
+	// CostEstimate prices the wire bytes at -cost-per-gb
+	CostEstimate float64 `json:"cost_estimate,omitempty"`
+}
+
+// ConnEvent is a tunnel connecting or dropping. Error says why it dropped,
+// if known.
+type ConnEvent struct {
+	Time      int64  `json:"time"`
+	Connected bool   `json:"connected"`
+	Error     string `json:"error,omitempty"`
+}
+
+// TargetHealth is the last -healthcheck probe of a tunnel's local server.
+type TargetHealth struct {
+	Healthy   bool    `json:"healthy"`
+	Status    int     `json:"status,omitempty"`
+	Error     string  `json:"error,omitempty"`
+	LatencyMs float64 `json:"latency_ms"`
+	CheckedAt int64   `json:"checked_at"`
+}
+
+// Request is a logged request/response pair (GET /stats/requests). Times
+// are unix seconds, latencies milliseconds.
+type Request struct {
+	ID              int                 `json:"id"`
+	Subdomain       string              `json:"subdomain"`
+	Method          string              `json:"method"`
+	Path            string              `json:"path"`
+	Status          int                 `json:"status"`
+	LatencyMs       float64             `json:"latency_ms"`
+	BytesIn         int                 `json:"bytes_in"`
+	BytesOut        int                 `json:"bytes_out"`
+	CreatedAt       int64               `json:"created_at"`
+	RequestHeaders  map[string][]string `json:"request_headers,omitempty"`
+	RequestBody     string              `json:"request_body,omitempty"`
+	ResponseHeaders map[string][]string `json:"response_headers,omitempty"`
+	ResponseBody    string              `json:"response_body,omitempty"`
+	// Protobuf and gRPC-web bodies as JSON, when -proto files are loaded
+	RequestBodyDecoded  string `json:"request_body_decoded,omitempty"`
+	ResponseBodyDecoded string `json:"response_body_decoded,omitempty"`
+	ReplayOf            int    `json:"replay_of,omitempty"`
+	Session             string `json:"session,omitempty"` // history only
+	Curl                string `json:"curl,omitempty"`    // against the public URL
+}
+
+// PluginOverhead is the time a plugin's request hooks add per call.
+type PluginOverhead struct {
+	Plugin string  `json:"plugin"`
+	Calls  int     `json:"calls"`
+	AvgMs  float64 `json:"avg_ms"`
+	MaxMs  float64 `json:"max_ms"`
+}
+
+// Feedback is a reviewer comment from the banner's feedback widget.
+type Feedback struct {
+	ID        int    `json:"id"`
+	Subdomain string `json:"subdomain"`
+	Comment   string `json:"comment"`
+	URL       string `json:"url,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// Summary totals all tunnels (GET /stats/summary).
+type Summary struct {
+	ActiveTunnels int     `json:"active_tunnels"`
+	TotalRequests int     `json:"total_requests"`
+	TotalErrors   int     `json:"total_errors"`
+	AvgLatency    float64 `json:"avg_latency"`
+	// Latency percentiles across tunnels, as in Tunnel
+	P50Latency       float64 `json:"p50_latency"`
+	P90Latency       float64 `json:"p90_latency"`
+	P99Latency       float64 `json:"p99_latency"`
+	TotalBytesIn     int     `json:"total_bytes_in"`
+	TotalBytesOut    int     `json:"total_bytes_out"`
+	ProtocolErrors   int     `json:"protocol_errors"`
+	SchemaViolations int     `json:"schema_violations"`
+	// Wire bytes and cost across tunnels, as in Tunnel
+	WireBytesSent     int64   `json:"wire_bytes_sent"`
+	WireBytesReceived int64   `json:"wire_bytes_received"`
+	CostPerGB         float64 `json:"cost_per_gb,omitempty"`
+	CostEstimate      float64 `json:"cost_estimate,omitempty"`
+}
+
+// PathStats aggregates a tunnel's requests to one endpoint this connection
+// (GET /stats/paths). Path segments that look like IDs are replaced by
+// {id}; past 200 endpoints a tunnel's requests are counted under method
+// and path "*". Latencies are milliseconds.
+type PathStats struct {
+	Subdomain  string  `json:"subdomain"`
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Requests   int     `json:"requests"`
+	Errors     int     `json:"errors"`
+	AvgLatency float64 `json:"avg_latency"`
+	MaxLatency float64 `json:"max_latency"`
+	P50Latency float64 `json:"p50_latency"`
+	P90Latency float64 `json:"p90_latency"`
+	P99Latency float64 `json:"p99_latency"`
+}
+
+// Link is a magic link (-magic-links) that signs one person in to a
+// tunnel (GET/POST/DELETE /stats/links). URL is only set when the link is
+// made. Times are unix seconds; UsedAt is 0 until the link is opened.
+type Link struct {
+	ID        string `json:"id"`
+	Subdomain string `json:"subdomain"`
+	Email     string `json:"email"`
+	Path      string `json:"path"`
+	URL       string `json:"url,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at"`
+	UsedAt    int64  `json:"used_at,omitempty"`
+	Revoked   bool   `json:"revoked,omitempty"`
+}
+
+// RequestPage is one page of GET /stats/requests, newest first. NextCursor
+// fetches the next page; it is 0 on the last.
+type RequestPage struct {
+	Requests   []Request `json:"requests"`
+	NextCursor int       `json:"next_cursor,omitempty"`
+}
+
+// Runtime is the health of the prod process (GET /stats/runtime).
+type Runtime struct {
+	PID           int    `json:"pid"`
+	Goroutines    int    `json:"goroutines"`
+	HeapAlloc     uint64 `json:"heap_alloc"`
+	HeapInuse     uint64 `json:"heap_inuse"`
+	Sys           uint64 `json:"sys"`
+	NumGC         uint32 `json:"num_gc"`
+	UptimeSeconds int64  `json:"uptime_seconds"`
+	LowPower      bool   `json:"low_power"` // idle on battery, see -low-power
+}
+
+// ReplayResult is the new entry a replay was logged as.
+type ReplayResult struct {
+	ID       int `json:"id"`
+	ReplayOf int `json:"replay_of"`
+	Status   int `json:"status"`
+}
+
+// BaselineDivergence is a live response that differed from its -baseline
+// recording (GET /stats/baseline). In Diff, Original is the recording and
+// Replay the live response.
+type BaselineDivergence struct {
+	RequestID int        `json:"request_id"`
+	Subdomain string     `json:"subdomain"`
+	Method    string     `json:"method"`
+	Path      string     `json:"path"`
+	Timestamp int64      `json:"timestamp"`
+	Diff      ReplayDiff `json:"diff"`
+}
+
+// ReplayDiff compares the response of a replay with the originally logged
+// one (POST /stats/requests/{id}/diff). Identical is true if nothing
+// compared differs.
+type ReplayDiff struct {
+	ID        int          `json:"id"` // the replay's log entry
+	ReplayOf  int          `json:"replay_of"`
+	Identical bool         `json:"identical"`
+	Status    StatusDiff   `json:"status"`
+	Headers   []HeaderDiff `json:"headers,omitempty"` // only those that differ
+	Body      BodyDiff     `json:"body"`
+}
+
+// StatusDiff compares response statuses.
+type StatusDiff struct {
+	Original int  `json:"original"`
+	Replay   int  `json:"replay"`
+	Changed  bool `json:"changed"`
+}
+
+// HeaderDiff is a response header that differs; a nil side means absent.
+type HeaderDiff struct {
+	Name     string   `json:"name"`
+	Original []string `json:"original"`
+	Replay   []string `json:"replay"`
+}
+
+// BodyDiff compares response bodies: JSON ones field by field, others as a
+// unified line diff. Skipped says why they couldn't be compared; Truncated
+// that the diff was cut short (or, for long text bodies, left out).
+type BodyDiff struct {
+	Changed   bool        `json:"changed"`
+	Skipped   string      `json:"skipped,omitempty"`
+	Fields    []FieldDiff `json:"fields,omitempty"`
+	Unified   string      `json:"unified,omitempty"`
+	Truncated bool        `json:"truncated,omitempty"`
+}
+
+// FieldDiff is a JSON value that was added, removed or changed, at a path
+// like $.items[0].price.
+type FieldDiff struct {
+	Path     string `json:"path"`
+	Change   string `json:"change"` // added, removed or changed
+	Original any    `json:"original,omitempty"`
+	Replay   any    `json:"replay,omitempty"`
+}
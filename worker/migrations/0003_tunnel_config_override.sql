@@ -0,0 +1,4 @@
+-- Migration number: 0003 	 2026-10-15
+-- Admin overrides of per-tunnel config, pushed to the CLI mid-session and kept across re-registrations
+
+ALTER TABLE tunnels ADD COLUMN config_override TEXT NOT NULL DEFAULT '{}';
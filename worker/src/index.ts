@@ -1,14 +1,17 @@
 import { Hono } from "hono";
 import { TunnelDO } from "./tunnel-do";
-import { tunnelConfig, invalidateConfigCache } from "./middleware/tunnel-config";
+import { tunnelConfig, invalidateConfigCache, readConfig } from "./middleware/tunnel-config";
 import { pluginMiddleware, runRegisterHooks, type RegisterResult } from "./plugins";
 
 // --- Import feature plugins here ---
 // Each plugin self-registers via registerMiddleware() / onRegister() at import time.
 import "./middleware/ipfilter";
 import "./middleware/auth";
+import "./middleware/oauth";
 import "./middleware/subdomain-block";
 import { isSubdomainBlocked } from "./middleware/subdomain-block";
+import { registerAuth } from "./middleware/register-auth";
+import { adminAuth } from "./middleware/admin-auth";
 
 export { TunnelDO };
 
@@ -58,9 +61,71 @@ async function allocateSubdomain(db: D1Database, clientId: string, port: number,
     return null;
 }
 
-app.post("/api/register", async (c) => {
+// Custom subdomains: lowercase letters, digits and inner hyphens, 3-32 chars
+const CUSTOM_SUBDOMAIN_RE = /^[a-z0-9][a-z0-9-]{1,30}[a-z0-9]$/;
+const RESERVED_SUBDOMAINS = new Set(["www", "tunnel", "api", "app", "admin"]);
+
+/**
+ * Validates a requested subdomain. Returns an error message, or null if usable.
+ */
+function validateCustomSubdomain(subdomain: string): string | null {
+    if (!CUSTOM_SUBDOMAIN_RE.test(subdomain)) {
+        return `Invalid subdomain "${subdomain}": use 3-32 lowercase letters, digits or hyphens`;
+    }
+    if (RESERVED_SUBDOMAINS.has(subdomain) || isSubdomainBlocked(subdomain)) {
+        return `Subdomain "${subdomain}" is not allowed`;
+    }
+    return null;
+}
+
+/**
+ * Returns an error message if a client other than clientId owns subdomain.
+ */
+async function subdomainTaken(db: D1Database, clientId: string, subdomain: string): Promise<string | null> {
+    const owner = await db.prepare(
+        "SELECT client_id FROM tunnels WHERE subdomain = ?"
+    ).bind(subdomain).first<{ client_id: string }>();
+    return owner && owner.client_id !== clientId ? `Subdomain "${subdomain}" is already taken` : null;
+}
+
+/**
+ * Reserves a specific subdomain for (clientId, port).
+ * Returns an error message if another client already owns it.
+ */
+async function reserveSubdomain(db: D1Database, clientId: string, port: number, subdomain: string, config: string): Promise<string | null> {
+    const owner = await db.prepare(
+        "SELECT client_id, port FROM tunnels WHERE subdomain = ?"
+    ).bind(subdomain).first<{ client_id: string; port: number }>();
+
+    if (owner && owner.client_id !== clientId) {
+        return `Subdomain "${subdomain}" is already taken`;
+    }
+    if (owner && owner.port === port) {
+        await db.prepare(
+            "UPDATE tunnels SET config = ? WHERE subdomain = ?"
+        ).bind(config, subdomain).run();
+        return null;
+    }
+
+    // Release whatever this port (or the subdomain's old port) was mapped to, then claim it
+    await db.prepare(
+        "DELETE FROM tunnels WHERE client_id = ? AND (port = ? OR subdomain = ?)"
+    ).bind(clientId, port, subdomain).run();
+    await db.prepare(
+        "INSERT INTO tunnels (subdomain, client_id, port, config) VALUES (?, ?, ?, ?)"
+    ).bind(subdomain, clientId, port, config).run();
+    return null;
+}
+
+app.post("/api/register", registerAuth(), async (c) => {
     try {
-        const body = await c.req.json<{ clientId: string; ports: number[]; config?: Record<string, unknown> }>();
+        const body = await c.req.json<{
+            clientId: string;
+            ports: number[];
+            subdomains?: Record<number, string>;
+            labels?: Record<number, string>;
+            config?: Record<string, unknown>;
+        }>();
         const { clientId, ports } = body;
         const configStr = body.config ? JSON.stringify(body.config) : "{}";
 
@@ -68,6 +133,30 @@ app.post("/api/register", async (c) => {
             return c.json({ error: "Invalid request" }, 400);
         }
 
+        const requested = body.subdomains ?? {};
+        for (const sub of Object.values(requested)) {
+            const invalid = validateCustomSubdomain(sub);
+            if (invalid) {
+                return c.json({ error: invalid }, 400);
+            }
+        }
+
+        // Check every requested subdomain before writing anything, so a
+        // conflict rejects the whole registration
+        const wantedBy = new Map<string, number>();
+        for (const port of ports) {
+            const wanted = requested[port];
+            if (!wanted) continue;
+            if (wantedBy.has(wanted)) {
+                return c.json({ error: `Subdomain "${wanted}" requested for both port ${wantedBy.get(wanted)} and port ${port}` }, 400);
+            }
+            wantedBy.set(wanted, port);
+            const taken = await subdomainTaken(c.env.DB, clientId, wanted);
+            if (taken) {
+                return c.json({ error: taken }, 409);
+            }
+        }
+
         const results: Record<number, string> = {};
 
         // Ensure client exists first (tunnels has FK to clients)
@@ -86,6 +175,25 @@ app.post("/api/register", async (c) => {
         }
 
         for (const port of ports) {
+            const wanted = requested[port];
+            if (wanted) {
+                const taken = await reserveSubdomain(c.env.DB, clientId, port, wanted, configStr);
+                if (taken) {
+                    return c.json({ error: taken }, 409);
+                }
+                if (existingMap.has(port)) {
+                    invalidateConfigCache(existingMap.get(port)!);
+                }
+                // The subdomain may have moved off another of this client's ports
+                for (const [p, sub] of existingMap) {
+                    if (sub === wanted && p !== port) existingMap.delete(p);
+                }
+                existingMap.set(port, wanted);
+                invalidateConfigCache(wanted);
+                results[port] = wanted;
+                continue;
+            }
+
             if (existingMap.has(port)) {
                 // Always update config — clears stale config when no plugins are active
                 await c.env.DB.prepare(
@@ -108,7 +216,7 @@ app.post("/api/register", async (c) => {
         const registerResult: RegisterResult = { tunnels: results, extra: {} };
         const parsedConfig = body.config ?? {};
         await runRegisterHooks(
-            { clientId, ports, config: parsedConfig, db: c.env.DB },
+            { clientId, ports, labels: body.labels ?? {}, config: parsedConfig, db: c.env.DB },
             registerResult,
         );
 
@@ -119,7 +227,7 @@ app.post("/api/register", async (c) => {
     }
 });
 
-app.get("/_tunnel", async (c) => {
+app.get("/_tunnel", registerAuth(), async (c) => {
     const upgradeHeader = c.req.header("Upgrade");
     if (!upgradeHeader || upgradeHeader !== "websocket") {
         return c.text("Expected Upgrade: websocket", 426);
@@ -137,6 +245,48 @@ app.get("/_tunnel", async (c) => {
     return stub.fetch(c.req.raw);
 });
 
+// Admin: change a tunnel's config mid-session (e.g. tighten its IP allowlist).
+// The body's keys override what the CLI registered, and survive its
+// re-registrations; null removes an override. The connected CLI is sent the
+// result as a config-update message.
+app.patch("/api/admin/tunnels/:subdomain/config", adminAuth(), async (c) => {
+    const subdomain = c.req.param("subdomain");
+    let patch: Record<string, unknown>;
+    try {
+        patch = await c.req.json();
+    } catch {
+        return c.json({ error: "Invalid JSON" }, 400);
+    }
+    if (!patch || typeof patch !== "object" || Array.isArray(patch)) {
+        return c.json({ error: "Body must be a JSON object" }, 400);
+    }
+
+    const row = await c.env.DB.prepare(
+        "SELECT config_override FROM tunnels WHERE subdomain = ?"
+    ).bind(subdomain).first<{ config_override: string }>();
+    if (!row) {
+        return c.json({ error: "Unknown tunnel" }, 404);
+    }
+    let override: Record<string, unknown> = {};
+    try { override = JSON.parse(row.config_override); } catch { /* start over */ }
+    for (const [key, value] of Object.entries(patch)) {
+        if (value === null) {
+            delete override[key];
+        } else {
+            override[key] = value;
+        }
+    }
+    await c.env.DB.prepare(
+        "UPDATE tunnels SET config_override = ? WHERE subdomain = ?"
+    ).bind(JSON.stringify(override), subdomain).run();
+    invalidateConfigCache(subdomain);
+
+    const config = (await readConfig(c.env.DB, subdomain)) ?? {};
+    const id = c.env.TUNNEL_DO.idFromName("temp_global_tunnel");
+    const pushed = await c.env.TUNNEL_DO.get(id).pushConfig(subdomain, config);
+    return c.json({ subdomain, config, pushed });
+});
+
 // Wildcard handler for incoming traffic
 // tunnelConfig() loads config, pluginMiddleware() runs all registered feature middleware.
 app.all("*", tunnelConfig(), pluginMiddleware(), async (c) => {
@@ -0,0 +1,24 @@
+// Admin API auth.
+// The /api/admin routes require "Authorization: Bearer <ADMIN_TOKEN>". Unset
+// ADMIN_TOKEN = the admin API is disabled.
+
+import type { Context, Next } from "hono";
+import { safeEqual } from "./register-auth";
+
+type AdminAuthEnv = Env & { ADMIN_TOKEN?: string };
+
+export function adminAuth() {
+    return async (c: Context<{ Bindings: Env }>, next: Next) => {
+        const configured = (c.env as AdminAuthEnv).ADMIN_TOKEN;
+        if (!configured) {
+            return c.json({ error: "Admin API disabled: set the ADMIN_TOKEN secret" }, 404);
+        }
+
+        const header = c.req.header("Authorization") ?? "";
+        const token = header.startsWith("Bearer ") ? header.slice(7).trim() : "";
+        if (!token || !safeEqual(token, configured)) {
+            return c.json({ error: "Unauthorized" }, 401);
+        }
+        return next();
+    };
+}
@@ -0,0 +1,26 @@
+// OAuth/OIDC callback middleware
+// Reads config.oauth ({ callback: "/_prodbd/oauth/callback" }). The CLI signs
+// visitors in itself; the worker only guards the callback route: requests
+// without a state are refused at the edge, and responses (which follow a
+// URL holding the authorization code) are never cached or sent as Referer.
+
+import { registerMiddleware } from "../plugins";
+
+registerMiddleware(async (c, next) => {
+    const config = c.get("tunnelConfig") as Record<string, unknown> | undefined;
+    const oauth = config?.oauth as { callback?: unknown } | undefined;
+
+    if (typeof oauth?.callback !== "string" || new URL(c.req.url).pathname !== oauth.callback) {
+        return next();
+    }
+
+    if (!c.req.query("state")) {
+        c.res = c.text("Bad Request", 400);
+        return;
+    }
+
+    await next();
+    c.res = new Response(c.res.body, c.res);
+    c.res.headers.set("Cache-Control", "no-store");
+    c.res.headers.set("Referrer-Policy", "no-referrer");
+});
@@ -0,0 +1,36 @@
+// Account tokens for tunnel registration.
+// When the REGISTER_TOKENS secret is set (comma-separated), /api/register and
+// the /_tunnel WebSocket require "Authorization: Bearer <token>" from the CLI
+// (`prod login`). Unset = open registration, as before.
+
+import type { Context, Next } from "hono";
+
+type RegisterAuthEnv = Env & { REGISTER_TOKENS?: string };
+
+/** Constant-time string comparison to avoid leaking token prefixes. */
+export function safeEqual(a: string, b: string): boolean {
+    if (a.length !== b.length) return false;
+    let diff = 0;
+    for (let i = 0; i < a.length; i++) {
+        diff |= a.charCodeAt(i) ^ b.charCodeAt(i);
+    }
+    return diff === 0;
+}
+
+export function registerAuth() {
+    return async (c: Context<{ Bindings: Env }>, next: Next) => {
+        const configured = (c.env as RegisterAuthEnv).REGISTER_TOKENS;
+        if (!configured) {
+            return next();
+        }
+
+        const header = c.req.header("Authorization") ?? "";
+        const token = header.startsWith("Bearer ") ? header.slice(7).trim() : "";
+        const allowed = configured.split(",").map((t) => t.trim()).filter(Boolean);
+
+        if (!token || !allowed.some((t) => safeEqual(t, token))) {
+            return c.json({ error: "Unauthorized: run `prod login` with a valid token" }, 401);
+        }
+        return next();
+    };
+}
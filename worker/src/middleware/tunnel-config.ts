@@ -6,30 +6,43 @@ import type { Context, Next } from "hono";
 // Config is untyped — each middleware reads its own keys.
 export type TunnelConfig = Record<string, unknown>;
 
-// Cache config in memory to avoid D1 reads on every request.
+// Cache config in memory to avoid D1 reads on every request. Invalidating
+// only reaches the isolate that made the change, so the TTL is how long
+// other isolates keep enforcing a config an admin just tightened: keep it
+// short.
 const configCache = new Map<string, { config: TunnelConfig; fetchedAt: number }>();
-const CACHE_TTL_MS = 30_000; // 30s
+const CACHE_TTL_MS = 2_000; // 2s
 
-/** Invalidate cached config for a subdomain (call after config update). */
+/** Invalidate this isolate's cached config for a subdomain (call after config update). */
 export function invalidateConfigCache(subdomain: string): void {
     configCache.delete(subdomain);
 }
 
+function parseConfig(json: string | undefined): TunnelConfig {
+    if (!json) return {};
+    try { return JSON.parse(json); } catch { return {}; /* ignore bad JSON */ }
+}
+
+/**
+ * Reads a tunnel's config from D1, bypassing the cache: what the CLI
+ * registered, with admin overrides (config_override) on top. Null if the
+ * tunnel doesn't exist.
+ */
+export async function readConfig(db: D1Database, subdomain: string): Promise<TunnelConfig | null> {
+    const row = await db.prepare(
+        "SELECT config, config_override FROM tunnels WHERE subdomain = ?"
+    ).bind(subdomain).first<{ config: string; config_override: string }>();
+    if (!row) return null;
+    return { ...parseConfig(row.config), ...parseConfig(row.config_override) };
+}
+
 async function loadConfig(db: D1Database, subdomain: string): Promise<TunnelConfig> {
     const cached = configCache.get(subdomain);
     if (cached && Date.now() - cached.fetchedAt < CACHE_TTL_MS) {
         return cached.config;
     }
 
-    const row = await db.prepare(
-        "SELECT config FROM tunnels WHERE subdomain = ?"
-    ).bind(subdomain).first<{ config: string }>();
-
-    let config: TunnelConfig = {};
-    if (row?.config) {
-        try { config = JSON.parse(row.config); } catch { /* ignore bad JSON */ }
-    }
-
+    const config = (await readConfig(db, subdomain)) ?? {};
     configCache.set(subdomain, { config, fetchedAt: Date.now() });
     return config;
 }
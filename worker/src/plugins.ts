@@ -47,6 +47,8 @@ export function pluginMiddleware(): MiddlewareHandler {
 export interface RegisterContext {
     clientId: string;
     ports: number[];
+    /** Display names the CLI gave to ports (port -> label) */
+    labels: Record<number, string>;
     config: Record<string, unknown>;
     db: D1Database;
 }
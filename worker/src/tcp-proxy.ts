@@ -0,0 +1,98 @@
+// Raw TCP proxy — visitors of a -tcp tunnel open a WebSocket to it (prod
+// connect does that for local clients), and each one is relayed to the CLI
+// as a stream. Stream data travels in binary tunnel messages; opening,
+// closing and acknowledging streams in JSON ones.
+
+export const TYPE_TCP_OPEN = "tcp-open";
+export const TYPE_TCP_ACK = "tcp-ack";
+export const TYPE_TCP_CLOSE = "tcp-close";
+
+// Bytes of a stream the CLI takes before acknowledging them; more are held here
+export const TCP_WINDOW = 1 << 20;
+// Held bytes past which a visitor that won't slow down is disconnected
+export const TCP_MAX_BACKLOG = 4 << 20;
+
+const TCP_DATA_FRAME = 1;
+// Largest payload of one tcp-data message
+const TCP_CHUNK = 64 * 1024;
+
+export interface TCPOpenMessage {
+    type: typeof TYPE_TCP_OPEN;
+    id: string;
+    headers: Record<string, string[]>;
+}
+
+export interface TCPAckMessage {
+    type: typeof TYPE_TCP_ACK;
+    id: string;
+    bytes: number;
+}
+
+export interface TCPCloseMessage {
+    type: typeof TYPE_TCP_CLOSE;
+    id: string;
+    code?: number;
+    reason?: string;
+}
+
+/** Flow control state of one stream. */
+export interface TCPStream {
+    /** Bytes sent to the CLI and not acknowledged yet */
+    inflight: number;
+    /** Visitor data waiting for the window to open */
+    backlog: Uint8Array[];
+    backlogBytes: number;
+}
+
+/** Frame a chunk of stream id: frame type, id length, id, payload. */
+export function encodeTCPData(id: string, payload: Uint8Array): Uint8Array {
+    const idBytes = new TextEncoder().encode(id);
+    const msg = new Uint8Array(2 + idBytes.length + payload.length);
+    msg[0] = TCP_DATA_FRAME;
+    msg[1] = idBytes.length;
+    msg.set(idBytes, 2);
+    msg.set(payload, 2 + idBytes.length);
+    return msg;
+}
+
+/** Split a binary tunnel message from encodeTCPData, or null if it isn't one. */
+export function decodeTCPData(buf: ArrayBuffer): { id: string; payload: Uint8Array } | null {
+    const msg = new Uint8Array(buf);
+    if (msg.length < 2 || msg[0] !== TCP_DATA_FRAME) return null;
+    const n = msg[1];
+    if (n === 0 || msg.length < 2 + n) return null;
+    return {
+        id: new TextDecoder().decode(msg.subarray(2, 2 + n)),
+        payload: msg.subarray(2 + n),
+    };
+}
+
+/**
+ * Send visitor data to the CLI within the stream's window, holding back
+ * what doesn't fit. Returns false if the backlog overflowed.
+ */
+export function sendTCPData(id: string, stream: TCPStream, data: Uint8Array, tunnelWs: WebSocket): boolean {
+    // Pieces small enough to always fit in the window
+    for (let off = 0; off < data.length; off += TCP_CHUNK) {
+        const chunk = data.subarray(off, off + TCP_CHUNK);
+        if (stream.backlog.length > 0 || stream.inflight + chunk.length > TCP_WINDOW) {
+            stream.backlog.push(chunk);
+            stream.backlogBytes += chunk.length;
+            continue;
+        }
+        stream.inflight += chunk.length;
+        tunnelWs.send(encodeTCPData(id, chunk));
+    }
+    return stream.backlogBytes <= TCP_MAX_BACKLOG;
+}
+
+/** Take an acknowledgement from the CLI and send held data that now fits. */
+export function ackTCPData(id: string, stream: TCPStream, bytes: number, tunnelWs: WebSocket): void {
+    stream.inflight = Math.max(0, stream.inflight - bytes);
+    while (stream.backlog.length > 0 && stream.inflight + stream.backlog[0].length <= TCP_WINDOW) {
+        const data = stream.backlog.shift()!;
+        stream.backlogBytes -= data.length;
+        stream.inflight += data.length;
+        tunnelWs.send(encodeTCPData(id, data));
+    }
+}
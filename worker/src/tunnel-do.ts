@@ -5,10 +5,31 @@ import {
     collectHeaders, encodeBase64,
     forwardVisitorFrame, deliverFrameToVisitor,
 } from "./ws-proxy";
+import {
+    TYPE_TCP_OPEN, TYPE_TCP_ACK, TYPE_TCP_CLOSE,
+    type TCPOpenMessage, type TCPAckMessage, type TCPCloseMessage, type TCPStream,
+    decodeTCPData, sendTCPData, ackTCPData,
+} from "./tcp-proxy";
 
 // --- HTTP tunnel protocol types ---
 const TYPE_HTTP_REQUEST = "http-request";
 const TYPE_HTTP_RESPONSE = "http-response";
+// Streamed responses (SSE, large downloads): start → chunk* → end
+const TYPE_HTTP_RESPONSE_START = "http-response-start";
+const TYPE_HTTP_RESPONSE_CHUNK = "http-response-chunk";
+const TYPE_HTTP_RESPONSE_END = "http-response-end";
+// Tells the CLI no one will read a response any more, so it aborts the local request
+const TYPE_HTTP_CANCEL = "http-cancel";
+// Sent by the CLI for messages it couldn't handle (unknown type, malformed, too large)
+const TYPE_ERROR = "error";
+// Sent to the CLI when an admin changes a tunnel's config mid-session
+const TYPE_CONFIG_UPDATE = "config-update";
+// How long requests in flight on a dropped tunnel wait for the same CLI to
+// reconnect (e.g. after switching networks) before failing
+const RESUME_GRACE_MS = 10_000;
+// Body encodings the CLI may use for http-response, advertised on the tunnel upgrade
+const ENCODING_GZIP = "gzip";
+const ENCODINGS_HEADER = "X-Tunnel-Encodings";
 
 interface TunnelRequest {
     type: string;
@@ -25,11 +46,46 @@ interface TunnelResponse {
     status: number;
     headers: Record<string, string[]>;
     body?: string;
+    // "gzip" if body is gzip-compressed before base64
+    encoding?: string;
+}
+
+interface HTTPCancel {
+    type: string;
+    id: string;
+    /** "disconnect" (the visitor went away) or "timeout" */
+    reason: string;
+}
+
+interface TunnelResponseChunk {
+    type: string;
+    id: string;
+    body: string;
+}
+
+interface TunnelResponseEnd {
+    type: string;
+    id: string;
+    error?: string;
+    // HTTP trailers (e.g. grpc-status). Workers can't emit response trailers
+    // yet, so these are currently dropped at the edge.
+    trailers?: Record<string, string[]>;
+}
+
+interface TunnelError {
+    type: string;
+    id?: string;
+    msgType?: string;
+    code: string;
+    message: string;
 }
 
 // --- WebSocket attachment types ---
-interface TunnelAttachment { subdomain: string }
-interface VisitorAttachment { visitorSessionId: string; subdomain: string }
+// session identifies the CLI process across its reconnects (?session=), so
+// requests in flight when a connection drops can be resumed on the next one.
+// tcp marks -tcp tunnels (?mode=tcp), and the visitors relayed to them as streams.
+interface TunnelAttachment { subdomain: string; session?: string; tcp?: boolean }
+interface VisitorAttachment { visitorSessionId: string; subdomain: string; tcp?: boolean }
 type WSAttachment = TunnelAttachment | VisitorAttachment;
 
 
@@ -37,15 +93,58 @@ function isVisitor(a: WSAttachment): a is VisitorAttachment {
     return "visitorSessionId" in a;
 }
 
+function decodeBase64(b64: string): Uint8Array {
+    return Uint8Array.from(atob(b64), (c) => c.charCodeAt(0));
+}
+
+// decodeBody returns a buffered response body, inflating it if the CLI compressed it.
+function decodeBody(resp: TunnelResponse): BodyInit | null {
+    if (!resp.body) return null;
+    const bytes = decodeBase64(resp.body);
+    if (resp.encoding === ENCODING_GZIP) {
+        return new Blob([bytes]).stream().pipeThrough(new DecompressionStream("gzip"));
+    }
+    return bytes;
+}
+
+function toHeaders(headers: Record<string, string[]> | undefined): Headers {
+    const out = new Headers();
+    if (headers) {
+        for (const [key, values] of Object.entries(headers)) {
+            for (const v of values) {
+                out.append(key, v);
+            }
+        }
+    }
+    return out;
+}
+
 
 export class TunnelDO extends DurableObject {
     // Reconstructed after Worker hibernation to restore active WebSocket tunnels
     private tunnels = new Map<string, WebSocket>();
     private visitorSockets = new Map<string, WebSocket>();
+    // Flow control of TCP streams, by visitor session id
+    private tcpStreams = new Map<string, TCPStream>();
 
     private pendingRequests = new Map<
         string,
-        { subdomain: string; resolve: (resp: TunnelResponse) => void; reject: (err: Error) => void }
+        {
+            subdomain: string;
+            /** CLI session the request was sent to, and the message, to re-send on resume */
+            session?: string;
+            message: string;
+            resolve: (resp: TunnelResponse) => void;
+            /** Resolves with a streaming body; returns the writer for later chunks. */
+            resolveStream: (resp: TunnelResponse) => WritableStreamDefaultWriter<Uint8Array>;
+            reject: (err: Error) => void;
+        }
+    >();
+
+    // Responses whose body is still arriving as http-response-chunk messages
+    private streamingResponses = new Map<
+        string,
+        { subdomain: string; writer: WritableStreamDefaultWriter<Uint8Array> }
     >();
 
     constructor(ctx: DurableObjectState, env: Env) {
@@ -85,6 +184,15 @@ export class TunnelDO extends DurableObject {
             return new Response("Tunnel not connected", { status: 502 });
         }
 
+        // A -tcp tunnel only carries streams, one per visitor WebSocket
+        const tunnelAtt = tunnelWs.deserializeAttachment() as TunnelAttachment | null;
+        if (tunnelAtt?.tcp) {
+            if (!isUpgrade) {
+                return new Response("This is a TCP tunnel; connect with `prod connect " + url.host + "`", { status: 426 });
+            }
+            return this.handleTCPUpgrade(request, tunnelWs, subdomain);
+        }
+
         // Visitor WebSocket upgrade
         if (isUpgrade) {
             return this.handleVisitorUpgrade(request, tunnelWs, subdomain);
@@ -103,20 +211,46 @@ export class TunnelDO extends DurableObject {
             return new Response("Missing subdomain", { status: 400 });
         }
 
+        const session = url.searchParams.get("session") ?? undefined;
+        const tcp = url.searchParams.get("mode") === "tcp";
+
         const pair = new WebSocketPair();
         const [client, server] = Object.values(pair);
 
         const existing = this.tunnels.get(subdomain);
         if (existing) {
+            const previous = (existing.deserializeAttachment() as TunnelAttachment | null)?.session;
+            if (!session || previous !== session) {
+                this.abortStreams(subdomain);
+            }
+            // Its visitor WebSockets were relayed by the old connection
+            this.closeVisitors(subdomain);
             existing.close(1000, "New connection replacing old one");
             this.tunnels.delete(subdomain);
         }
 
         this.ctx.acceptWebSocket(server);
-        server.serializeAttachment({ subdomain } as TunnelAttachment);
+        server.serializeAttachment({ subdomain, session, tcp } as TunnelAttachment);
         this.tunnels.set(subdomain, server);
 
-        return new Response(null, { status: 101, webSocket: client });
+        // The same CLI is back: re-send what it may not have received (it
+        // skips requests it is already serving). Another one can't answer
+        // what its predecessor was sent.
+        for (const [id, pending] of this.pendingRequests) {
+            if (pending.subdomain !== subdomain) continue;
+            if (session && pending.session === session) {
+                server.send(pending.message);
+            } else {
+                pending.reject(new Error("Tunnel connection replaced"));
+                this.pendingRequests.delete(id);
+            }
+        }
+
+        return new Response(null, {
+            status: 101,
+            webSocket: client,
+            headers: { [ENCODINGS_HEADER]: ENCODING_GZIP },
+        });
     }
 
     // ── Visitor WebSocket upgrade (proxied through tunnel) ───
@@ -145,6 +279,38 @@ export class TunnelDO extends DurableObject {
         return new Response(null, { status: 101, webSocket: client });
     }
 
+    // ── Visitor TCP stream (-tcp tunnels) ────────────────────
+
+    private async handleTCPUpgrade(
+        request: Request, tunnelWs: WebSocket, subdomain: string
+    ): Promise<Response> {
+        const sessionId = crypto.randomUUID();
+
+        // The CLI dials the local port; its access policy sees the headers
+        const open: TCPOpenMessage = { type: TYPE_TCP_OPEN, id: sessionId, headers: collectHeaders(request) };
+        tunnelWs.send(JSON.stringify(open));
+
+        const pair = new WebSocketPair();
+        const [client, server] = Object.values(pair);
+
+        this.ctx.acceptWebSocket(server);
+        server.serializeAttachment({ visitorSessionId: sessionId, subdomain, tcp: true } as VisitorAttachment);
+        this.visitorSockets.set(sessionId, server);
+        this.tcpStreams.set(sessionId, { inflight: 0, backlog: [], backlogBytes: 0 });
+
+        return new Response(null, { status: 101, webSocket: client });
+    }
+
+    /** The flow control state of a stream, reset if hibernation lost it. */
+    private tcpStream(sessionId: string): TCPStream {
+        let stream = this.tcpStreams.get(sessionId);
+        if (!stream) {
+            stream = { inflight: 0, backlog: [], backlogBytes: 0 };
+            this.tcpStreams.set(sessionId, stream);
+        }
+        return stream;
+    }
+
     // ── Hibernation handlers ─────────────────────────────────
 
     async webSocketMessage(ws: WebSocket, message: string | ArrayBuffer) {
@@ -154,14 +320,29 @@ export class TunnelDO extends DurableObject {
         // Visitor → forward frame to CLI tunnel
         if (isVisitor(att)) {
             const tunnelWs = this.getTunnelSocket(att.subdomain);
-            if (tunnelWs && tunnelWs.readyState === WebSocket.OPEN) {
-                forwardVisitorFrame(att.visitorSessionId, message, tunnelWs);
+            if (!tunnelWs || tunnelWs.readyState !== WebSocket.OPEN) return;
+            if (att.tcp) {
+                const data = typeof message === "string" ? new TextEncoder().encode(message) : new Uint8Array(message);
+                if (!sendTCPData(att.visitorSessionId, this.tcpStream(att.visitorSessionId), data, tunnelWs)) {
+                    this.closeTCPStream(att.visitorSessionId, ws, 1013, "TCP stream backlog full");
+                }
+                return;
+            }
+            forwardVisitorFrame(att.visitorSessionId, message, tunnelWs);
+            return;
+        }
+
+        // CLI tunnel → binary messages are TCP stream data
+        if (typeof message !== "string") {
+            const frame = decodeTCPData(message);
+            const visitor = frame ? this.visitorSockets.get(frame.id) : undefined;
+            if (frame && visitor && visitor.readyState === WebSocket.OPEN) {
+                visitor.send(frame.payload);
             }
             return;
         }
 
         // CLI tunnel → route by message type
-        if (typeof message !== "string") return;
         try {
             const msg = JSON.parse(message);
             this.handleTunnelMessage(msg);
@@ -180,6 +361,58 @@ export class TunnelDO extends DurableObject {
                 }
                 break;
             }
+            case TYPE_HTTP_RESPONSE_START: {
+                const pending = this.pendingRequests.get(msg.id);
+                if (pending) {
+                    this.pendingRequests.delete(msg.id);
+                    const writer = pending.resolveStream(msg as TunnelResponse);
+                    this.streamingResponses.set(msg.id, { subdomain: pending.subdomain, writer });
+                }
+                break;
+            }
+            case TYPE_HTTP_RESPONSE_CHUNK: {
+                const stream = this.streamingResponses.get(msg.id);
+                if (stream) {
+                    const chunk = msg as TunnelResponseChunk;
+                    stream.writer.write(decodeBase64(chunk.body)).catch(() => {
+                        // Visitor went away; drop the rest of the body
+                        if (this.streamingResponses.delete(msg.id)) {
+                            this.cancelRequest(stream.subdomain, msg.id, "disconnect");
+                        }
+                    });
+                }
+                break;
+            }
+            case TYPE_HTTP_RESPONSE_END: {
+                const stream = this.streamingResponses.get(msg.id);
+                if (stream) {
+                    const end = msg as TunnelResponseEnd;
+                    this.streamingResponses.delete(msg.id);
+                    if (end.error) {
+                        stream.writer.abort(new Error(end.error)).catch(() => { });
+                    } else {
+                        stream.writer.close().catch(() => { });
+                    }
+                }
+                break;
+            }
+            case TYPE_ERROR: {
+                const err = msg as TunnelError;
+                console.warn(`CLI protocol error (${err.code}) for ${err.msgType || "message"} ${err.id || ""}: ${err.message}`);
+                // Fail the visitor's request now instead of at the 30s timeout
+                const pending = err.id ? this.pendingRequests.get(err.id) : undefined;
+                if (pending) {
+                    this.pendingRequests.delete(err.id!);
+                    pending.resolve({
+                        type: TYPE_HTTP_RESPONSE,
+                        id: err.id!,
+                        status: 502,
+                        headers: { "content-type": ["text/plain; charset=utf-8"] },
+                        body: btoa(`Tunnel protocol error: ${err.code}`),
+                    });
+                }
+                break;
+            }
             case TYPE_WS_FRAME: {
                 const visitor = this.visitorSockets.get(msg.id);
                 if (visitor && visitor.readyState === WebSocket.OPEN) {
@@ -196,6 +429,26 @@ export class TunnelDO extends DurableObject {
                 }
                 break;
             }
+            case TYPE_TCP_ACK: {
+                const ack = msg as TCPAckMessage;
+                const stream = this.tcpStreams.get(ack.id);
+                const visitor = this.visitorSockets.get(ack.id);
+                const tunnelWs = visitor && this.getTunnelSocket((visitor.deserializeAttachment() as VisitorAttachment).subdomain);
+                if (stream && tunnelWs && tunnelWs.readyState === WebSocket.OPEN) {
+                    ackTCPData(ack.id, stream, ack.bytes, tunnelWs);
+                }
+                break;
+            }
+            case TYPE_TCP_CLOSE: {
+                const visitor = this.visitorSockets.get(msg.id);
+                if (visitor) {
+                    const cm = msg as TCPCloseMessage;
+                    try { visitor.close(cm.code || 1000, cm.reason || ""); } catch { }
+                    this.visitorSockets.delete(msg.id);
+                }
+                this.tcpStreams.delete(msg.id);
+                break;
+            }
         }
     }
 
@@ -208,33 +461,25 @@ export class TunnelDO extends DurableObject {
         // Visitor disconnected → notify CLI
         if (isVisitor(att)) {
             this.visitorSockets.delete(att.visitorSessionId);
+            this.tcpStreams.delete(att.visitorSessionId);
             const tunnelWs = this.getTunnelSocket(att.subdomain);
             if (tunnelWs && tunnelWs.readyState === WebSocket.OPEN) {
                 tunnelWs.send(JSON.stringify({
-                    type: TYPE_WS_CLOSE, id: att.visitorSessionId, code, reason,
+                    type: att.tcp ? TYPE_TCP_CLOSE : TYPE_WS_CLOSE, id: att.visitorSessionId, code, reason,
                 }));
             }
             return;
         }
 
-        // CLI tunnel disconnected → clean up everything for this subdomain
+        // A connection replaced by a newer one: its requests live on there
         const sub = att.subdomain;
-        this.tunnels.delete(sub);
-
-        for (const [id, pending] of this.pendingRequests) {
-            if (pending.subdomain === sub) {
-                pending.reject(new Error("Tunnel connection closed"));
-                this.pendingRequests.delete(id);
-            }
-        }
+        if (this.tunnels.get(sub) !== ws) return;
 
-        for (const [sessionId, visitor] of this.visitorSockets) {
-            const va = visitor.deserializeAttachment() as VisitorAttachment | null;
-            if (va && va.subdomain === sub) {
-                try { visitor.close(1001, "Tunnel disconnected"); } catch { }
-                this.visitorSockets.delete(sessionId);
-            }
-        }
+        // CLI tunnel disconnected → clean up everything for this subdomain
+        this.tunnels.delete(sub);
+        this.abortStreams(sub);
+        this.closeVisitors(sub);
+        this.failPendingUnlessResumed(sub, "Tunnel connection closed");
     }
 
     async webSocketError(ws: WebSocket, error: unknown) {
@@ -243,6 +488,10 @@ export class TunnelDO extends DurableObject {
 
         if (isVisitor(att)) {
             console.error(`Visitor WS error for session ${att.visitorSessionId}:`, error);
+            if (att.tcp) {
+                this.closeTCPStream(att.visitorSessionId, ws, 1011, "WebSocket error");
+                return;
+            }
             this.visitorSockets.delete(att.visitorSessionId);
             try { ws.close(1011, "WebSocket error"); } catch { }
             return;
@@ -250,20 +499,89 @@ export class TunnelDO extends DurableObject {
 
         const sub = att.subdomain;
         console.error(`Tunnel error for ${sub}:`, error);
-        this.tunnels.delete(sub);
+        if (this.tunnels.get(sub) === ws) {
+            this.tunnels.delete(sub);
+            this.abortStreams(sub);
+            this.failPendingUnlessResumed(sub, "WebSocket error");
+        }
 
-        for (const [id, pending] of this.pendingRequests) {
-            if (pending.subdomain === sub) {
-                pending.reject(new Error("WebSocket error"));
-                this.pendingRequests.delete(id);
+        try { ws.close(1011, "WebSocket error"); } catch { }
+    }
+
+    /**
+     * Fails a dropped tunnel's pending requests, unless its CLI reconnects
+     * within RESUME_GRACE_MS and resumes them.
+     */
+    private failPendingUnlessResumed(subdomain: string, reason: string) {
+        setTimeout(() => {
+            if (this.tunnels.has(subdomain)) return;
+            for (const [id, pending] of this.pendingRequests) {
+                if (pending.subdomain === subdomain) {
+                    pending.reject(new Error(reason));
+                    this.pendingRequests.delete(id);
+                }
+            }
+        }, RESUME_GRACE_MS);
+    }
+
+    /** Closes the visitor WebSockets of a tunnel that went away. */
+    private closeVisitors(subdomain: string) {
+        for (const [sessionId, visitor] of this.visitorSockets) {
+            const va = visitor.deserializeAttachment() as VisitorAttachment | null;
+            if (va && va.subdomain === subdomain) {
+                try { visitor.close(1001, "Tunnel disconnected"); } catch { }
+                this.visitorSockets.delete(sessionId);
+                this.tcpStreams.delete(sessionId);
             }
         }
+    }
 
-        try { ws.close(1011, "WebSocket error"); } catch { }
+    /** Closes a visitor's TCP stream on both ends. */
+    private closeTCPStream(sessionId: string, visitor: WebSocket, code: number, reason: string) {
+        const att = visitor.deserializeAttachment() as VisitorAttachment;
+        this.visitorSockets.delete(sessionId);
+        this.tcpStreams.delete(sessionId);
+        try { visitor.close(code, reason); } catch { }
+        const tunnelWs = this.getTunnelSocket(att.subdomain);
+        if (tunnelWs && tunnelWs.readyState === WebSocket.OPEN) {
+            const msg: TCPCloseMessage = { type: TYPE_TCP_CLOSE, id: sessionId, reason };
+            tunnelWs.send(JSON.stringify(msg));
+        }
+    }
+
+    /** Abort streamed bodies still in flight for a tunnel that went away. */
+    private abortStreams(subdomain: string) {
+        for (const [id, stream] of this.streamingResponses) {
+            if (stream.subdomain === subdomain) {
+                stream.writer.abort(new Error("Tunnel connection closed")).catch(() => { });
+                this.streamingResponses.delete(id);
+            }
+        }
+    }
+
+    // ── Config push ──────────────────────────────────────────
+
+    /**
+     * Sends a tunnel's updated config to its CLI, so plugins can re-apply it
+     * without reconnecting. Returns false if the tunnel isn't connected.
+     */
+    async pushConfig(subdomain: string, config: Record<string, unknown>): Promise<boolean> {
+        const ws = this.getTunnelSocket(subdomain);
+        if (!ws || ws.readyState !== WebSocket.OPEN) return false;
+        ws.send(JSON.stringify({ type: TYPE_CONFIG_UPDATE, config }));
+        return true;
     }
 
     // ── HTTP request proxy ───────────────────────────────────
 
+    /** Tells the CLI to stop working on a request whose response no one will read. */
+    private cancelRequest(subdomain: string, id: string, reason: string) {
+        const ws = this.getTunnelSocket(subdomain);
+        if (!ws || ws.readyState !== WebSocket.OPEN) return;
+        const msg: HTTPCancel = { type: TYPE_HTTP_CANCEL, id, reason };
+        try { ws.send(JSON.stringify(msg)); } catch { }
+    }
+
     private async proxyHTTPRequest(request: Request, ws: WebSocket): Promise<Response> {
         const reqId = crypto.randomUUID();
         const url = new URL(request.url);
@@ -281,30 +599,40 @@ export class TunnelDO extends DurableObject {
             tunnelReq.body = encodeBase64(await request.arrayBuffer());
         }
 
+        const message = JSON.stringify(tunnelReq);
+        const session = (ws.deserializeAttachment() as TunnelAttachment | null)?.session;
+
         return new Promise<Response>((resolve) => {
             const timeout = setTimeout(() => {
                 this.pendingRequests.delete(reqId);
+                this.cancelRequest(subdomain, reqId, "timeout");
                 resolve(new Response("Gateway Timeout", { status: 504 }));
             }, 30000);
 
+            // The visitor hung up (needs the enable_request_signal flag)
+            request.signal?.addEventListener("abort", () => {
+                if (this.pendingRequests.delete(reqId)) {
+                    clearTimeout(timeout);
+                    this.cancelRequest(subdomain, reqId, "disconnect");
+                    resolve(new Response("Client Closed Request", { status: 499 }));
+                } else if (this.streamingResponses.delete(reqId)) {
+                    this.cancelRequest(subdomain, reqId, "disconnect");
+                }
+            });
+
             this.pendingRequests.set(reqId, {
                 subdomain,
+                session,
+                message,
                 resolve: (resp) => {
                     clearTimeout(timeout);
-                    const body = resp.body
-                        ? Uint8Array.from(atob(resp.body), (c) => c.charCodeAt(0))
-                        : null;
-
-                    const respHeaders = new Headers();
-                    if (resp.headers) {
-                        for (const [key, values] of Object.entries(resp.headers)) {
-                            for (const v of values) {
-                                respHeaders.append(key, v);
-                            }
-                        }
-                    }
-
-                    resolve(new Response(body, { status: resp.status, headers: respHeaders }));
+                    resolve(new Response(decodeBody(resp), { status: resp.status, headers: toHeaders(resp.headers) }));
+                },
+                resolveStream: (resp) => {
+                    clearTimeout(timeout);
+                    const { readable, writable } = new TransformStream<Uint8Array, Uint8Array>();
+                    resolve(new Response(readable, { status: resp.status, headers: toHeaders(resp.headers) }));
+                    return writable.getWriter();
                 },
                 reject: (err) => {
                     clearTimeout(timeout);
@@ -313,7 +641,7 @@ export class TunnelDO extends DurableObject {
             });
 
             try {
-                ws.send(JSON.stringify(tunnelReq));
+                ws.send(message);
             } catch {
                 this.pendingRequests.delete(reqId);
                 clearTimeout(timeout);